@@ -0,0 +1,145 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/shell"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	archiveCmd.Flags().StringVarP(&archiveOut, "out", "o", "",
+		"Archive to write, a file or a Cloud Storage object gs://BUCKET/OBJECT (defaults to DEPLOYMENT_NAME.tgz)")
+	archiveCmd.Flags().BoolVar(&archiveAllowModified, "allow-modified", false,
+		"Archive the deployment even if its files diverge from the manifest written by create")
+	restoreCmd.Flags().StringVarP(&restoreOut, "out", "o", "",
+		"Directory to restore the deployment to (defaults to the name of the archive without its extension)")
+	rootCmd.AddCommand(archiveCmd, restoreCmd)
+}
+
+var (
+	archiveOut           string
+	archiveAllowModified bool
+	restoreOut           string
+	archiveCmd           = &cobra.Command{
+		Use:   "archive DEPLOYMENT_DIRECTORY",
+		Short: "Archive a deployment directory, with its Terraform state, for storage.",
+		Long: "Writes a deployment directory, without the Terraform working directories of its groups, as a gzipped tarball " +
+			"that records the hashes of its files. The deployment must match the manifest written by create. " +
+			"Restore the archive with \"ghpc restore\", e.g. to destroy the deployment from another machine.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runArchiveCmd,
+		SilenceUsage:      true,
+	}
+	restoreCmd = &cobra.Command{
+		Use:          "restore ARCHIVE",
+		Short:        "Restore a deployment directory written by ghpc archive.",
+		Long:         "Extracts an archive, a file or a Cloud Storage object gs://BUCKET/OBJECT, and verifies the restored files.",
+		Args:         cobra.ExactArgs(1),
+		RunE:         runRestoreCmd,
+		SilenceUsage: true,
+	}
+)
+
+func runArchiveCmd(cmd *cobra.Command, args []string) error {
+	root := filepath.Clean(args[0])
+	if _, err := bundledDeployment(root); err != nil {
+		return err
+	}
+	if err := checkArchivedDeployment(root); err != nil {
+		return err
+	}
+
+	out := archiveOut
+	if out == "" {
+		out = filepath.Base(root) + ".tgz"
+	}
+	if shell.IsObjectURL(out) {
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(shell.WriteDeploymentArchive(root, pw)) }()
+		if err := shell.UploadObject(cmd.Context(), out, pr); err != nil {
+			pr.CloseWithError(err)
+			return err
+		}
+	} else {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := shell.WriteDeploymentArchive(root, f); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Deployment %s archived in %s\n", root, out)
+	return nil
+}
+
+// checkArchivedDeployment errors if the deployment diverges from the manifest
+// written by create, unless --allow-modified is set
+func checkArchivedDeployment(root string) error {
+	diff, err := modulewriter.VerifyDeployment(root)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("deployment %s has no manifest; it was created by an earlier version of ghpc and cannot be verified", root)
+		return nil
+	}
+	if err != nil || diff.Empty() {
+		return err
+	}
+	files := append(append(append([]string{}, diff.Modified...), diff.Missing...), diff.Added...)
+	if !archiveAllowModified {
+		return fmt.Errorf("deployment %s diverges from its manifest in %s; "+
+			"run \"ghpc verify %s\" for details or archive it with --allow-modified", root, strings.Join(files, ", "), root)
+	}
+	log.Printf("archiving deployment %s, which diverges from its manifest in %s", root, strings.Join(files, ", "))
+	return nil
+}
+
+func runRestoreCmd(cmd *cobra.Command, args []string) error {
+	src := args[0]
+	dir := restoreOut
+	if dir == "" {
+		dir = strings.TrimSuffix(strings.TrimSuffix(path.Base(filepath.ToSlash(src)), ".tgz"), ".tar.gz")
+	}
+
+	var r io.ReadCloser
+	var err error
+	if shell.IsObjectURL(src) {
+		r, err = shell.DownloadObject(cmd.Context(), src)
+	} else {
+		r, err = os.Open(src)
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := shell.RestoreDeploymentArchive(r, dir); err != nil {
+		return err
+	}
+	fmt.Printf("Deployment restored in %s\n", dir)
+	fmt.Printf("Destroy it with: ghpc destroy %s\n", dir)
+	return nil
+}
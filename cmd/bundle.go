@@ -0,0 +1,125 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	bundleCmd.Flags().StringVarP(&bundleFile, "out", "o", "", "Bundle file to write (defaults to DEPLOYMENT_NAME.tgz)")
+	bundleCmd.Flags().StringSliceVar(&mirrorPlatforms, "platform", nil,
+		"Platforms to bundle providers for, e.g. linux_amd64 (defaults to the current platform)")
+	bundleCmd.AddCommand(bundleVerifyCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
+
+var (
+	bundleFile string
+	bundleCmd  = &cobra.Command{
+		Use:   "bundle DEPLOYMENT_DIRECTORY",
+		Short: "Package a deployment for networks without Internet access.",
+		Long: "Package a deployment directory, the Terraform modules and providers and the packer plugins it needs into a single tarball. " +
+			"Extract the tarball and run \"ghpc deploy\" on the extracted directory to deploy it without Internet access.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runBundleCmd,
+		SilenceUsage:      true,
+	}
+	bundleVerifyCmd = &cobra.Command{
+		Use:          "verify BUNDLE",
+		Short:        "Check that a bundle, or a deployment directory extracted from one, is complete.",
+		Args:         cobra.ExactArgs(1),
+		RunE:         runBundleVerifyCmd,
+		SilenceUsage: true,
+	}
+)
+
+func runBundleCmd(cmd *cobra.Command, args []string) error {
+	root := filepath.Clean(args[0])
+	dc, err := bundledDeployment(root)
+	if err != nil {
+		return err
+	}
+	if err := shell.PrepareBundle(root, dc.Config.DeploymentGroups, mirrorPlatforms); err != nil {
+		return err
+	}
+
+	out := bundleFile
+	if out == "" {
+		out = filepath.Base(root) + ".tgz"
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := shell.WriteBundle(root, f); err != nil {
+		return err
+	}
+	fmt.Printf("Deployment %s bundled in %s\n", root, out)
+	return nil
+}
+
+func runBundleVerifyCmd(cmd *cobra.Command, args []string) error {
+	root := args[0]
+	if isDir, _ := shell.DirInfo(root); !isDir {
+		f, err := os.Open(root)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if root, err = os.MkdirTemp("", "ghpc-bundle-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(root)
+		if err := shell.ExtractBundle(f, root); err != nil {
+			return fmt.Errorf("failed to extract bundle %s: %w", args[0], err)
+		}
+	}
+
+	dc, err := bundledDeployment(root)
+	if err != nil {
+		return err
+	}
+	if problems := shell.VerifyBundle(root, dc.Config.DeploymentGroups); len(problems) > 0 {
+		return fmt.Errorf("bundle %s is incomplete:\n%s", args[0], strings.Join(problems, "\n"))
+	}
+	fmt.Printf("Bundle %s is complete\n", args[0])
+	return nil
+}
+
+// bundledDeployment reads the expanded blueprint of a deployment and checks
+// that the deployment directory matches it
+func bundledDeployment(root string) (config.DeploymentConfig, error) {
+	artifacts := filepath.Join(root, modulewriter.HiddenGhpcDirName, modulewriter.ArtifactsDirName)
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifacts, expandedBlueprintFilename))
+	if err != nil {
+		return config.DeploymentConfig{}, err
+	}
+	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, root); err != nil {
+		return config.DeploymentConfig{}, err
+	}
+	return dc, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"hpc-toolkit/pkg/catalog"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	catalogCmd.PersistentFlags().StringVar(&catalogIndexURL, "index-url", "",
+		"URL of a remote index of blueprints to list in addition to the embedded examples")
+	catalogNewFromCmd.Flags().StringVarP(&catalogOut, "out", "o", "", "Blueprint file to write (defaults to NAME.yaml)")
+	catalogNewFromCmd.Flags().StringSliceVar(&cliVariables, "vars", nil,
+		"Comma-separated list of name=value deployment variables to set in the new blueprint")
+	catalogNewFromCmd.Flags().BoolVar(&catalogNoInput, "no-input", false,
+		"Do not prompt for the deployment variables that are not set by --vars")
+	catalogCmd.AddCommand(catalogListCmd, catalogShowCmd, catalogNewFromCmd)
+	rootCmd.AddCommand(catalogCmd)
+}
+
+var (
+	catalogIndexURL string
+	catalogOut      string
+	catalogNoInput  bool
+	catalogCmd      = &cobra.Command{
+		Use:   "catalog",
+		Short: "Browse the example blueprints and start new blueprints from them.",
+	}
+	catalogListCmd = &cobra.Command{
+		Use:          "list",
+		Short:        "List the blueprints of the catalog.",
+		Args:         cobra.NoArgs,
+		RunE:         runCatalogListCmd,
+		SilenceUsage: true,
+	}
+	catalogShowCmd = &cobra.Command{
+		Use:          "show NAME",
+		Short:        "Print a blueprint of the catalog.",
+		Args:         cobra.ExactArgs(1),
+		RunE:         runCatalogShowCmd,
+		SilenceUsage: true,
+	}
+	catalogNewFromCmd = &cobra.Command{
+		Use:   "new-from NAME",
+		Short: "Write a new blueprint from a blueprint of the catalog.",
+		Long: "Copies a blueprint of the catalog, setting its deployment variables from --vars and, " +
+			"when run in a terminal, from answers to prompts.",
+		Args:         cobra.ExactArgs(1),
+		RunE:         runCatalogNewFromCmd,
+		SilenceUsage: true,
+	}
+)
+
+func runCatalogListCmd(cmd *cobra.Command, args []string) error {
+	idx, err := catalog.Load(cmd.Context(), catalogIndexURL)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range idx.Blueprints {
+		fmt.Fprintf(w, "%s\t%s\n", e.Name, e.Description)
+	}
+	return w.Flush()
+}
+
+func readCatalogBlueprint(cmd *cobra.Command, name string) ([]byte, error) {
+	idx, err := catalog.Load(cmd.Context(), catalogIndexURL)
+	if err != nil {
+		return nil, err
+	}
+	e, err := idx.Find(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.Read(cmd.Context())
+}
+
+func runCatalogShowCmd(cmd *cobra.Command, args []string) error {
+	data, err := readCatalogBlueprint(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func runCatalogNewFromCmd(cmd *cobra.Command, args []string) error {
+	out := catalogOut
+	if out == "" {
+		out = args[0] + ".yaml"
+	}
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("%s already exists", out)
+	}
+	data, err := readCatalogBlueprint(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	values, err := parseVarAssignments(cliVariables)
+	if err != nil {
+		return err
+	}
+	if userConfig.Project != "" {
+		if _, ok := values["project_id"]; !ok {
+			values["project_id"] = userConfig.Project
+		}
+	}
+	if !catalogNoInput && isTerminal(os.Stdin) {
+		vars, err := catalog.Vars(data)
+		if err != nil {
+			return err
+		}
+		if err := promptVars(os.Stdin, os.Stdout, vars, values); err != nil {
+			return err
+		}
+	}
+	if data, err = catalog.SetVars(data, values); err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Blueprint %s written from %s.\n", out, args[0])
+	return nil
+}
+
+// parseVarAssignments splits name=value assignments; values are YAML
+func parseVarAssignments(s []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, a := range s {
+		name, value, ok := strings.Cut(a, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid variable %q, must be name=value", a)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// promptVars asks for the values of the deployment variables that are not
+// yet set, in blueprint order; an empty answer keeps the value of the
+// blueprint
+func promptVars(in io.Reader, out io.Writer, vars []catalog.Var, values map[string]string) error {
+	r := bufio.NewReader(in)
+	for _, v := range vars {
+		if _, ok := values[v.Name]; ok {
+			continue
+		}
+		hint := v.Value
+		if hint == "" {
+			hint = v.Hint
+		}
+		if hint != "" {
+			fmt.Fprintf(out, "%s [%s]: ", v.Name, hint)
+		} else {
+			fmt.Fprintf(out, "%s: ", v.Name)
+		}
+		answer, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if answer = strings.TrimSpace(answer); answer != "" {
+			values[v.Name] = answer
+		}
+		if err == io.EOF {
+			fmt.Fprintln(out)
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"hpc-toolkit/pkg/catalog"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestParseVarAssignments(c *C) {
+	values, err := parseVarAssignments([]string{"region=us-east4", "labels={a: b=c}"})
+	c.Assert(err, IsNil)
+	c.Check(values, DeepEquals, map[string]string{"region": "us-east4", "labels": "{a: b=c}"})
+
+	_, err = parseVarAssignments([]string{"region"})
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestPromptVars(c *C) {
+	vars := []catalog.Var{
+		{Name: "project_id", Hint: "Set GCP Project ID Here"},
+		{Name: "deployment_name", Value: "hpc-example"},
+		{Name: "region", Value: "us-central1"},
+		{Name: "zone"},
+	}
+	values := map[string]string{"region": "us-east4"}
+	var out bytes.Buffer
+	c.Assert(promptVars(strings.NewReader("my-project\n\n"), &out, vars, values), IsNil)
+	c.Check(out.String(), Equals,
+		"project_id [Set GCP Project ID Here]: deployment_name [hpc-example]: zone: \n")
+	c.Check(values, DeepEquals, map[string]string{"project_id": "my-project", "region": "us-east4"})
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/shell"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "List the artifacts that would be removed without removing them")
+	rootCmd.AddCommand(cleanCmd)
+}
+
+var (
+	cleanDryRun bool
+	cleanCmd    = &cobra.Command{
+		Use:   "clean DEPLOYMENT_DIRECTORY",
+		Short: "Remove the Terraform working directories, caches and saved plans of a deployment.",
+		Long: "Remove the .terraform directories, packer caches and saved Terraform plans of a deployment directory, " +
+			"which are recreated on demand, e.g. before archiving it. Terraform state and its backups are never removed.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runCleanCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runCleanCmd(cmd *cobra.Command, args []string) error {
+	root := filepath.Clean(args[0])
+	if _, err := bundledDeployment(root); err != nil {
+		return err
+	}
+	artifacts, err := shell.DeploymentArtifacts(root)
+	if err != nil {
+		return err
+	}
+	if len(artifacts) == 0 {
+		fmt.Printf("Deployment %s has no artifacts to remove\n", root)
+		return nil
+	}
+
+	var total int64
+	for _, a := range artifacts {
+		fmt.Printf("%s (%s)\n", filepath.Join(root, filepath.FromSlash(a.Path)), formatSize(a.Size))
+		total += a.Size
+	}
+	if cleanDryRun {
+		fmt.Printf("Would remove %d artifacts of deployment %s, freeing %s\n", len(artifacts), root, formatSize(total))
+		return nil
+	}
+	if err := shell.RemoveArtifacts(root, artifacts); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d artifacts of deployment %s, freeing %s\n", len(artifacts), root, formatSize(total))
+	return nil
+}
+
+// formatSize formats a number of bytes in the largest binary unit under it
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/deploymentio"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func init() {
+	configCmd.AddCommand(configGetCmd, configSetCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.PersistentPreRunE = loadUserConfig
+}
+
+// checkpointDisableEnv stops Terraform and Packer from calling HashiCorp to
+// check for new versions and security bulletins
+const checkpointDisableEnv = "CHECKPOINT_DISABLE"
+
+var (
+	// userConfig holds the persistent defaults of the user
+	userConfig config.UserConfig
+	// userConfigFlags are the flags whose defaults are set by the user
+	// configuration, by key
+	userConfigFlags = map[string]string{
+		"validation_level": "validation-level",
+		"project":          "project",
+		"catalog_url":      "index-url",
+	}
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Manage the persistent defaults of ghpc.",
+		Long: fmt.Sprintf(`Manage the persistent defaults of ghpc, read by all commands from
+~/.config/ghpc/config.yaml, or the file named by %s. Keys are %v.`,
+			config.UserConfigEnv, config.UserConfigKeys),
+	}
+	configGetCmd = &cobra.Command{
+		Use:          "get [KEY]",
+		Short:        "Print the value of a key, or the whole configuration.",
+		Args:         cobra.MaximumNArgs(1),
+		ValidArgs:    config.UserConfigKeys,
+		RunE:         runConfigGetCmd,
+		SilenceUsage: true,
+	}
+	configSetCmd = &cobra.Command{
+		Use:          "set KEY VALUE",
+		Short:        "Set the value of a key; an empty value unsets it.",
+		Args:         cobra.ExactArgs(2),
+		ValidArgs:    config.UserConfigKeys,
+		RunE:         runConfigSetCmd,
+		SilenceUsage: true,
+	}
+)
+
+// loadUserConfig reads the user configuration and applies it to the flags of
+// the command and to the environment of Terraform and Packer. The config
+// commands read the configuration themselves.
+func loadUserConfig(cmd *cobra.Command, args []string) error {
+	if cmd == configCmd || cmd.Parent() == configCmd {
+		return nil
+	}
+	path, err := config.UserConfigPath()
+	if err != nil {
+		return err
+	}
+	if userConfig, err = config.LoadUserConfig(path); err != nil {
+		return err
+	}
+	return applyUserConfig(cmd, userConfig)
+}
+
+func applyUserConfig(cmd *cobra.Command, c config.UserConfig) error {
+	for key, name := range userConfigFlags {
+		f := cmd.Flags().Lookup(name)
+		value, _ := c.Get(key)
+		if f == nil || f.Changed || value == "" {
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("user configuration %s: %w", key, err)
+		}
+	}
+	if c.CacheDir != "" && os.Getenv(shell.PluginCacheEnv) == "" {
+		os.Setenv(shell.PluginCacheEnv, filepath.Join(c.CacheDir, "terraform-plugins"))
+	}
+	if c.Telemetry != nil && !*c.Telemetry {
+		os.Setenv(checkpointDisableEnv, "1")
+	}
+	if c.Umask != "" {
+		umask, err := deploymentio.ParseUmask(c.Umask)
+		if err != nil {
+			return fmt.Errorf("user configuration umask: %w", err)
+		}
+		deploymentio.SetUmask(umask)
+	}
+	return nil
+}
+
+// applyUserConfigDefaults sets the project and Terraform backend of a
+// blueprint that does not set them
+func applyUserConfigDefaults(bp *config.Blueprint, c config.UserConfig) error {
+	if c.Project != "" && !bp.Vars.Has("project_id") {
+		bp.Vars.Set("project_id", cty.StringVal(c.Project))
+	}
+	if c.BackendBucket != "" && bp.TerraformBackendDefaults.Type == "" {
+		return setBackendConfig(bp, []string{"bucket=" + c.BackendBucket})
+	}
+	return nil
+}
+
+func runConfigGetCmd(cmd *cobra.Command, args []string) error {
+	path, err := config.UserConfigPath()
+	if err != nil {
+		return err
+	}
+	c, err := config.LoadUserConfig(path)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		for _, key := range config.UserConfigKeys {
+			value, _ := c.Get(key)
+			fmt.Printf("%s: %s\n", key, value)
+		}
+		return nil
+	}
+	value, err := c.Get(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+func runConfigSetCmd(cmd *cobra.Command, args []string) error {
+	path, err := config.UserConfigPath()
+	if err != nil {
+		return err
+	}
+	c, err := config.LoadUserConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Set(args[0], args[1]); err != nil {
+		return err
+	}
+	return c.Save(path)
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/deploymentio"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestApplyUserConfig(c *C) {
+	var level, project string
+	cmd := &cobra.Command{}
+	cmd.Flags().StringVar(&level, "validation-level", "WARNING", "")
+	cmd.Flags().StringVar(&project, "project", "", "")
+	c.Assert(cmd.Flags().Set("project", "cli-project"), IsNil)
+
+	defer os.Unsetenv(shell.PluginCacheEnv)
+	defer os.Unsetenv(checkpointDisableEnv)
+	defer deploymentio.ResetUmask()
+	os.Unsetenv(shell.PluginCacheEnv)
+	off := false
+	uc := config.UserConfig{
+		ValidationLevel: "ERROR",
+		Project:         "user-project",
+		CacheDir:        "/cache/ghpc",
+		Telemetry:       &off,
+		Umask:           "0002",
+	}
+	c.Assert(applyUserConfig(cmd, uc), IsNil)
+	c.Check(level, Equals, "ERROR")
+	// flags of the command line take precedence
+	c.Check(project, Equals, "cli-project")
+	c.Check(os.Getenv(shell.PluginCacheEnv), Equals, filepath.Join("/cache/ghpc", "terraform-plugins"))
+	c.Check(os.Getenv(checkpointDisableEnv), Equals, "1")
+	c.Check(deploymentio.FileMode(false), Equals, os.FileMode(0664))
+}
+
+func (s *MySuite) TestApplyUserConfigDefaults(c *C) {
+	uc := config.UserConfig{Project: "user-project", BackendBucket: "tf-state"}
+
+	bp := config.Blueprint{}
+	c.Assert(applyUserConfigDefaults(&bp, uc), IsNil)
+	c.Check(bp.Vars.Get("project_id"), DeepEquals, cty.StringVal("user-project"))
+	c.Check(bp.TerraformBackendDefaults.Type, Equals, "gcs")
+	c.Check(bp.TerraformBackendDefaults.Configuration.Get("bucket"), DeepEquals, cty.StringVal("tf-state"))
+
+	// blueprints take precedence
+	bp = config.Blueprint{TerraformBackendDefaults: config.TerraformBackend{Type: "local"}}
+	bp.Vars.Set("project_id", cty.StringVal("bp-project"))
+	c.Assert(applyUserConfigDefaults(&bp, uc), IsNil)
+	c.Check(bp.Vars.Get("project_id"), DeepEquals, cty.StringVal("bp-project"))
+	c.Check(bp.TerraformBackendDefaults.Type, Equals, "local")
+}
@@ -18,22 +18,40 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hpc-toolkit/pkg/catalog"
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/deploymentio"
 	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/sourcereader"
+	"hpc-toolkit/pkg/validators"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 )
 
 const msgCLIVars = "Comma-separated list of name=value variables to override YAML configuration. Can be used multiple times."
 const msgCLIBackendConfig = "Comma-separated list of name=value variables to set Terraform backend configuration. Can be used multiple times."
 
+// modulePolicyEnv names the environment variable holding the module policy
+// file of administrators, which is enforced for all users in addition to any
+// other module policy
+const modulePolicyEnv = "GHPC_MODULE_POLICY"
+
 func init() {
 	createCmd.Flags().StringVarP(&bpFilenameDeprecated, "config", "c", "", "")
 	cobra.CheckErr(createCmd.Flags().MarkDeprecated("config",
@@ -42,14 +60,33 @@ func init() {
 	createCmd.Flags().StringVarP(&outputDir, "out", "o", "",
 		"Sets the output directory where the HPC deployment directory will be created.")
 	createCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	createCmd.Flags().StringVar(&blueprintName, "blueprint", "", blueprintNameDesc)
 	createCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
 	createCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
 	createCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	createCmd.Flags().DurationVar(&validationTimeout, "validation-timeout", 0, validationTimeoutDesc)
+	createCmd.Flags().StringVar(&modulePolicyFile, "module-policy", "", modulePolicyDesc)
+	createCmd.Flags().BoolVar(&debugExpansion, "debug-expansion", false, debugExpansionDesc)
+	createCmd.Flags().BoolVar(&selectZone, "select-zone", false, selectZoneDesc)
+	createCmd.Flags().StringSliceVar(&onlyGroups, "only-group", nil, onlyGroupDesc)
+	createCmd.Flags().StringSliceVar(&skipGroups, "skip-group", nil, skipGroupDesc)
+	createCmd.MarkFlagsMutuallyExclusive("only-group", "skip-group")
+	createCmd.Flags().BoolVar(&preview, "preview", false, previewDesc)
+	createCmd.Flags().StringSliceVar(&previewFiles, "preview-file", nil, previewFileDesc)
+	createCmd.Flags().StringVar(&cdktfLanguage, "cdktf", "", cdktfDesc)
+	createCmd.Flags().BoolVar(&terragrunt, "terragrunt", false, terragruntDesc)
 	createCmd.Flags().BoolVarP(&overwriteDeployment, "overwrite-deployment", "w", false,
 		"If specified, an existing deployment directory is overwritten by the new deployment. \n"+
 			"Note: Terraform state IS preserved. \n"+
 			"Note: Terraform workspaces are NOT supported (behavior undefined). \n"+
 			"Note: Packer is NOT supported.")
+	createCmd.Flags().StringVar(&varsFile, "vars-file", "", varsFileDesc)
+	createCmd.Flags().StringVar(&createUmask, "umask", "", umaskDesc)
+	createCmd.Flags().BoolVar(&createNoInput, "no-input", false,
+		"Do not prompt for the deployment variables that the blueprint requires but does not set")
+	createCmd.Flags().BoolVar(&allowExistingState, "allow-existing-state", false,
+		"Create a new deployment directory even if the Terraform state of one of its groups already exists in Cloud Storage, "+
+			"e.g. to recreate the directory of a deployment.")
 	rootCmd.AddCommand(createCmd)
 }
 
@@ -57,66 +94,407 @@ var (
 	bpFilenameDeprecated string
 	outputDir            string
 	cliVariables         []string
+	blueprintName        string
+	blueprintNameDesc    = "Name of the blueprint to use, if the file has several blueprints separated by ---"
+
+	varsFile     string
+	varsFileDesc = "File of deployment variables saved by ghpc create, loaded for those that the blueprint and --vars do not set " +
+		"(default BLUEPRINT.vars.yaml next to the blueprint)"
+	createNoInput bool
+	// promptForVars asks for the deployment variables that the blueprint
+	// requires but does not set, when expanding it fails for their absence
+	promptForVars bool
+	// createsDeployment expands the blueprint to be written to a deployment
+	// directory, whose previous deployment, if any, expansion takes into
+	// account
+	createsDeployment bool
 
 	cliBEConfigVars     []string
 	overwriteDeployment bool
+	allowExistingState  bool
 	validationLevel     string
 	validationLevelDesc = "Set validation level to one of (\"ERROR\", \"WARNING\", \"IGNORE\")"
 	validatorsToSkip    []string
 	skipValidatorsDesc  = "Validators to skip"
 
+	validationTimeout     time.Duration
+	validationTimeoutDesc = "Overall deadline for running validators (e.g. \"5m\"); validators still running at the deadline are reported as warnings"
+
+	modulePolicyFile string
+	modulePolicyDesc = "YAML file of module sources and kinds that are allowed, denied or exempt; replaces module_policy in the blueprint; the policy of $" + modulePolicyEnv + " is enforced as well"
+
+	onlyGroups    []string
+	onlyGroupDesc = "Deployment groups to act upon; all other groups must already exist in the deployment"
+	skipGroups    []string
+	skipGroupDesc = "Deployment groups to leave untouched; they must already exist in the deployment"
+
+	preview         bool
+	previewDesc     = "Print the generated files, or their changes to an existing deployment directory, to stdout instead of writing the deployment"
+	previewFiles    []string
+	previewFileDesc = "Generated files to print with --preview (default main.tf, variables.tf, providers.tf and defaults.auto.pkrvars.hcl)"
+
+	debugExpansion     bool
+	debugExpansionDesc = "Log every expansion phase, with the changes it makes to module settings and validators, to stderr"
+
+	selectZone     bool
+	selectZoneDesc = "When modules require a zone and only the region is set, choose the first zone of the region that is up, " +
+		"listed with the Compute Engine API, rather than the first zone of the region"
+
+	cdktfLanguage string
+	cdktfDesc     = "Also write a CDKTF project of the Terraform groups in this language (" +
+		strings.Join(modulewriter.CDKTFLanguages, " or ") + ") to the cdktf directory of the deployment"
+
+	createUmask string
+	umaskDesc   = "Octal umask of the files and directories of the deployment, e.g. 0002 to make them writable by your group " +
+		"(default the umask of the user configuration, else that of the process)"
+
+	terragrunt     bool
+	terragruntDesc = "Also write a terragrunt.hcl to every Terraform group of the deployment, " +
+		"so that terragrunt run-all can deploy it"
+
 	createCmd = &cobra.Command{
 		Use:               "create BLUEPRINT_NAME",
 		Short:             "Create a new deployment.",
 		Long:              "Create a new deployment based on a provided blueprint.",
-		Run:               runCreateCmd,
+		RunE:              runCreateCmd,
 		Args:              cobra.ExactArgs(1),
 		ValidArgsFunction: filterYaml,
+		SilenceUsage:      true,
 	}
 )
 
-func runCreateCmd(cmd *cobra.Command, args []string) {
-	dc := expandOrDie(args[0])
-	if err := modulewriter.WriteDeployment(dc, outputDir, overwriteDeployment); err != nil {
-		var target *modulewriter.OverwriteDeniedError
-		if errors.As(err, &target) {
-			fmt.Printf("\n%s\n", err.Error())
-			os.Exit(1)
-		} else {
-			log.Fatal(err)
+func runCreateCmd(cmd *cobra.Command, args []string) error {
+	// an interrupt aborts expansion and validation; once the deployment is
+	// being written, it is completed rather than left partially written
+	ctx, stop := interruptContext(cmd)
+	defer stop()
+	// git modules are cloned once, when first read, and reused by the writer;
+	// errors are returned rather than fatal, so that the clones are removed
+	defer sourcereader.CleanupFetched()
+	if cdktfLanguage != "" && !slices.Contains(modulewriter.CDKTFLanguages, cdktfLanguage) {
+		return fmt.Errorf("--cdktf must be one of %v, got %q", modulewriter.CDKTFLanguages, cdktfLanguage)
+	}
+	if createUmask != "" {
+		umask, err := deploymentio.ParseUmask(createUmask)
+		if err != nil {
+			return fmt.Errorf("--umask: %w", err)
+		}
+		deploymentio.SetUmask(umask)
+	}
+	promptForVars = !createNoInput && !readStdin && !preview && isTerminal(os.Stdin)
+	createsDeployment = true
+	dc := expandOrDie(ctx, args[0])
+	scripts, err := dc.HostStartupScripts()
+	if err != nil {
+		return err
+	}
+	if preview {
+		highlight := isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+		return modulewriter.PreviewDeployment(dc, outputDir, os.Stdout, previewFiles, highlight)
+	}
+	groups, err := selectGroups(dc.Config, onlyGroups, skipGroups)
+	if err != nil {
+		return err
+	}
+	if err := checkNewStatePrefixes(ctx, dc.Config); err != nil {
+		return err
+	}
+	if err := modulewriter.UploadStartupScripts(ctx, scripts); err != nil {
+		return err
+	}
+	if err := modulewriter.WriteDeploymentGroups(dc, outputDir, overwriteDeployment, groups); err != nil {
+		return err
+	}
+	deploymentName, err := dc.Config.DeploymentName()
+	if err != nil {
+		return err
+	}
+	if cdktfLanguage != "" {
+		if err := modulewriter.WriteCDKTFProject(dc, filepath.Join(outputDir, deploymentName), cdktfLanguage); err != nil {
+			return err
+		}
+	}
+	if terragrunt {
+		if err := modulewriter.WriteTerragruntProject(dc, filepath.Join(outputDir, deploymentName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interruptContext returns a context of the command that is cancelled when
+// ghpc is interrupted or terminated
+func interruptContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+}
+
+// configError is the output of --error-format json
+type configError struct {
+	Code    config.ErrorCode `json:"code,omitempty"`
+	Message string           `json:"message"`
+}
+
+// fatalConfigError prints err in the format of --error-format and exits; json
+// prints the code and message of err as an object to stderr
+func fatalConfigError(err error) {
+	if errorFormat != "json" {
+		log.Fatal(err)
+	}
+	json.NewEncoder(os.Stderr).Encode(configError{Code: config.CodeOf(err), Message: err.Error()})
+	os.Exit(1)
+}
+
+// readDeploymentConfig reads the blueprint at path, or from stdin with
+// --stdin
+func readDeploymentConfig(path string) (config.DeploymentConfig, error) {
+	if !readStdin {
+		return config.NewDeploymentConfigNamed(path, blueprintName)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return config.DeploymentConfig{}, fmt.Errorf("failed to read the blueprint from stdin: %w", err)
+	}
+	return config.NewDeploymentConfigFromData("stdin", data, blueprintName)
+}
+
+func expandOrDie(ctx context.Context, path string) config.DeploymentConfig {
+	if errorFormat != "text" && errorFormat != "json" {
+		log.Fatalf("--error-format must be text or json, got %q", errorFormat)
+	}
+	saved := config.Dict{}
+	savedPath := ""
+	if !readStdin {
+		savedPath = varsFile
+		if savedPath == "" {
+			savedPath = config.SavedVarsPath(path)
+		}
+		var err error
+		if saved, err = config.ReadSavedVars(savedPath); err != nil {
+			fatalConfigError(err)
+		}
+	}
+
+	// the blueprint is read and expanded again after each answer to a prompt
+	// for a missing deployment variable
+	answers := config.Dict{}
+	for attempt := 0; ; attempt++ {
+		dc := readAndConfigureOrDie(path, answers, saved, savedPath, attempt == 0)
+		err := dc.ExpandConfig(ctx)
+		if err == nil {
+			if len(answers.Items()) > 0 {
+				offerToSaveVars(os.Stdin, os.Stdout, savedPath, answers)
+			}
+			return dc
 		}
+		name, missing := config.MissingVar(err)
+		if !missing || !promptForVars || answers.Has(name) {
+			if missing && savedPath != "" {
+				log.Printf("set deployment variable %s in the blueprint, with --vars or in %s", name, savedPath)
+			}
+			// deferred calls are not run by log.Fatal
+			sourcereader.CleanupFetched()
+			fatalConfigError(err)
+		}
+		v, err := promptVar(os.Stdin, os.Stdout, name)
+		if err != nil {
+			sourcereader.CleanupFetched()
+			fatalConfigError(err)
+		}
+		answers.Set(name, v)
 	}
 }
 
-func expandOrDie(path string) config.DeploymentConfig {
-	dc, err := config.NewDeploymentConfig(path)
+// readAndConfigureOrDie reads the blueprint at path and sets it up from the
+// flags of the command; deployment variables that neither the blueprint nor
+// --vars set are taken from the answers to prompts, then from the vars file
+func readAndConfigureOrDie(path string, answers config.Dict, saved config.Dict, savedPath string, verbose bool) config.DeploymentConfig {
+	dc, err := readDeploymentConfig(path)
 	if err != nil {
-		log.Fatal(err)
+		fatalConfigError(err)
 	}
 	// Set properties from CLI
 	if err := setCLIVariables(&dc.Config, cliVariables); err != nil {
-		log.Fatalf("Failed to set the variables at CLI: %v", err)
+		fatalConfigError(fmt.Errorf("Failed to set the variables at CLI: %w", err))
+	}
+	dc.Config.SetSavedVars(answers)
+	if names := dc.Config.SetSavedVars(saved); len(names) > 0 && verbose {
+		log.Printf("deployment variables %s read from %s", strings.Join(names, ", "), savedPath)
 	}
 	if err := setBackendConfig(&dc.Config, cliBEConfigVars); err != nil {
-		log.Fatalf("Failed to set the backend config at CLI: %v", err)
+		fatalConfigError(fmt.Errorf("Failed to set the backend config at CLI: %w", err))
+	}
+	if err := applyUserConfigDefaults(&dc.Config, userConfig); err != nil {
+		fatalConfigError(fmt.Errorf("Failed to apply the user configuration: %w", err))
 	}
 	if err := setValidationLevel(&dc.Config, validationLevel); err != nil {
-		log.Fatal(err)
+		fatalConfigError(err)
 	}
 	if err := skipValidators(&dc); err != nil {
-		log.Fatal(err)
+		fatalConfigError(err)
+	}
+	if validationTimeout > 0 {
+		dc.Config.ValidationTimeout = validationTimeout
+	}
+	if err := applyModulePolicies(&dc); err != nil {
+		fatalConfigError(err)
 	}
 	if dc.Config.GhpcVersion != "" {
-		fmt.Printf("ghpc_version setting is ignored.")
+		// logged rather than printed, to keep the stdout of ghpc expand --stdin
+		// a blueprint
+		log.Println("ghpc_version setting is ignored.")
 	}
 	dc.Config.GhpcVersion = GitCommitInfo
+	if createsDeployment {
+		if err := configureDeploymentDir(&dc); err != nil {
+			fatalConfigError(err)
+		}
+	}
+	if debugExpansion {
+		dc.TraceExpansion(os.Stderr)
+	}
+	if selectZone {
+		dc.SelectZonesWith(validators.SelectZone)
+	}
+	return dc
+}
 
-	// Expand the blueprint
-	if err := dc.ExpandConfig(); err != nil {
-		log.Fatal(err)
+// applyModulePolicies replaces the module policy of the blueprint with that
+// of --module-policy, if set, and enforces the policy of GHPC_MODULE_POLICY
+// whatever the blueprint and flags say
+func applyModulePolicies(dc *config.DeploymentConfig) error {
+	if modulePolicyFile != "" {
+		p, err := config.LoadModulePolicy(modulePolicyFile)
+		if err != nil {
+			return err
+		}
+		dc.Config.ModulePolicy = &p
 	}
+	if f := os.Getenv(modulePolicyEnv); f != "" {
+		p, err := config.LoadModulePolicy(f)
+		if err != nil {
+			return err
+		}
+		dc.EnforceModulePolicy(p)
+	}
+	return nil
+}
 
-	return dc
+// configureDeploymentDir tells expansion the deployment directory to which
+// the blueprint is written and sets the ghpc_deployment_id label of the
+// blueprint to that of the directory, if it exists, or to a new one. Unlike
+// deployment names, the ids of deployments sharing a project differ, so ghpc
+// never mistakes the resources of one for those of another.
+func configureDeploymentDir(dc *config.DeploymentConfig) error {
+	name, err := dc.Config.DeploymentName()
+	if err != nil {
+		return nil // reported by expansion
+	}
+	dir := filepath.Join(outputDir, name)
+	if err := dc.SetDeploymentDir(dir, filepath.Join(dir,
+		modulewriter.HiddenGhpcDirName, modulewriter.ArtifactsDirName, expandedBlueprintFilename)); err != nil {
+		return err
+	}
+	id := dc.PreviousDeploymentID()
+	if id == "" {
+		id = config.NewDeploymentID()
+	}
+	dc.Config.SetDeploymentID(id)
+	return nil
+}
+
+// promptVar asks for the value of a missing deployment variable, which is
+// read as by --vars
+func promptVar(in io.Reader, out io.Writer, name string) (cty.Value, error) {
+	values := map[string]string{}
+	if err := promptVars(in, out, []catalog.Var{{Name: name}}, values); err != nil {
+		return cty.NilVal, err
+	}
+	answer, ok := values[name]
+	if !ok {
+		return cty.NilVal, fmt.Errorf("no value was given for deployment variable %s", name)
+	}
+	return parseVarValue(name, answer)
+}
+
+// offerToSaveVars asks whether to save the answers to the prompts for
+// deployment variables to the vars file, which later runs load
+func offerToSaveVars(in io.Reader, out io.Writer, path string, answers config.Dict) {
+	if path == "" {
+		return
+	}
+	fmt.Fprintf(out, "Save these deployment variables to %s, which later runs load? [Y,n]: ", path)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return
+	}
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "" && a != "y" && a != "yes" {
+		return
+	}
+	if err := config.SaveVars(path, answers); err != nil {
+		log.Printf("failed to save deployment variables: %v", err)
+		return
+	}
+	log.Printf("deployment variables saved to %s", path)
+}
+
+// checkNewStatePrefixes errors if the deployment directory is new and a group
+// would use an existing Terraform state; the states of deployments whose
+// directory already exists are theirs
+func checkNewStatePrefixes(ctx context.Context, bp config.Blueprint) error {
+	if allowExistingState {
+		return nil
+	}
+	deploymentName, err := bp.DeploymentName()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, deploymentName)); err == nil {
+		return nil
+	}
+	return modulewriter.CheckNewStatePrefixes(ctx, bp.StatePrefixes())
+}
+
+// isTerminal returns true if f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// selectGroups returns the deployment groups selected by the --only-group and
+// --skip-group flags in blueprint order; naming a group that was split into
+// sub-groups selects all of them. nil is returned if neither flag is set
+func selectGroups(bp config.Blueprint, only []string, skip []string) ([]config.GroupName, error) {
+	if len(only) == 0 && len(skip) == 0 {
+		return nil, nil
+	}
+	if len(only) > 0 && len(skip) > 0 {
+		return nil, errors.New("--only-group and --skip-group cannot be used together")
+	}
+
+	named := map[config.GroupName]bool{}
+	for _, n := range append(only, skip...) {
+		found := false
+		for _, g := range bp.DeploymentGroups {
+			if g.MatchesName(config.GroupName(n)) {
+				named[g.Name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("could not find group %s in blueprint", n)
+		}
+	}
+
+	groups := []config.GroupName{}
+	for _, g := range bp.DeploymentGroups {
+		if named[g.Name] == (len(only) > 0) {
+			groups = append(groups, g.Name)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, errors.New("all deployment groups were skipped")
+	}
+	return groups, nil
 }
 
 func setCLIVariables(bp *config.Blueprint, s []string) error {
@@ -126,17 +504,25 @@ func setCLIVariables(bp *config.Blueprint, s []string) error {
 		if len(arr) != 2 {
 			return fmt.Errorf("invalid format: '%s' should follow the 'name=value' format", cliVar)
 		}
-		// Convert the variable's string literal to its equivalent default type.
-		key := arr[0]
-		var v config.YamlValue
-		if err := yaml.Unmarshal([]byte(arr[1]), &v); err != nil {
-			return fmt.Errorf("invalid input: unable to convert '%s' value '%s' to known type", key, arr[1])
+		v, err := parseVarValue(arr[0], arr[1])
+		if err != nil {
+			return err
 		}
-		bp.Vars.Set(key, v.Unwrap())
+		bp.Vars.Set(arr[0], v)
 	}
 	return nil
 }
 
+// parseVarValue converts the variable's string literal to its equivalent
+// default type
+func parseVarValue(key string, s string) (cty.Value, error) {
+	var v config.YamlValue
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return cty.NilVal, fmt.Errorf("invalid input: unable to convert '%s' value '%s' to known type", key, s)
+	}
+	return v.Unwrap(), nil
+}
+
 func setBackendConfig(bp *config.Blueprint, s []string) error {
 	if len(s) == 0 {
 		return nil // no op
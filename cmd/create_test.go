@@ -15,8 +15,15 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"hpc-toolkit/pkg/config"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/spf13/cobra"
 	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
 )
@@ -76,6 +83,31 @@ func (s *MySuite) TestSetCLIVariables(c *C) {
 	c.Check(bp.Vars, DeepEquals, config.Dict{})
 }
 
+func (s *MySuite) TestPromptVar(c *C) {
+	var out bytes.Buffer
+	v, err := promptVar(strings.NewReader("[a, b]\n"), &out, "zones")
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}))
+	c.Check(out.String(), Equals, "zones: ")
+
+	_, err = promptVar(strings.NewReader("\n"), &out, "zones")
+	c.Check(err, ErrorMatches, ".*no value was given for deployment variable zones.*")
+}
+
+func (s *MySuite) TestOfferToSaveVars(c *C) {
+	path := filepath.Join(c.MkDir(), "bp.vars.yaml")
+	answers := config.NewDict(map[string]cty.Value{"project_id": cty.StringVal("test-project")})
+
+	offerToSaveVars(strings.NewReader("n\n"), io.Discard, path, answers)
+	_, err := os.Stat(path)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	offerToSaveVars(strings.NewReader("\n"), io.Discard, path, answers)
+	saved, err := config.ReadSavedVars(path)
+	c.Assert(err, IsNil)
+	c.Check(saved.Items(), DeepEquals, answers.Items())
+}
+
 func (s *MySuite) TestSetBackendConfig(c *C) {
 	// Success
 	vars := []string{
@@ -128,3 +160,112 @@ func (s *MySuite) TestValidationLevels(c *C) {
 
 	c.Check(setValidationLevel(&bp, "INVALID"), NotNil)
 }
+
+func (s *MySuite) TestSelectGroups(c *C) {
+	bp := config.Blueprint{
+		DeploymentGroups: []config.DeploymentGroup{
+			{Name: "primary"}, {Name: "image"}, {Name: "compute"}},
+	}
+
+	groups, err := selectGroups(bp, nil, nil)
+	c.Check(err, IsNil)
+	c.Check(groups, IsNil)
+
+	groups, err = selectGroups(bp, []string{"compute", "primary"}, nil)
+	c.Check(err, IsNil)
+	c.Check(groups, DeepEquals, []config.GroupName{"primary", "compute"})
+
+	groups, err = selectGroups(bp, nil, []string{"image"})
+	c.Check(err, IsNil)
+	c.Check(groups, DeepEquals, []config.GroupName{"primary", "compute"})
+
+	_, err = selectGroups(bp, []string{"nope"}, nil)
+	c.Check(err, ErrorMatches, ".*could not find group nope.*")
+
+	_, err = selectGroups(bp, []string{"image"}, []string{"compute"})
+	c.Check(err, NotNil)
+
+	_, err = selectGroups(bp, nil, []string{"primary", "image", "compute"})
+	c.Check(err, NotNil)
+
+	split := config.Blueprint{
+		DeploymentGroups: []config.DeploymentGroup{
+			{Name: "primary"},
+			{Name: "build-1", SubgroupOf: "build"},
+			{Name: "build-2", SubgroupOf: "build"}},
+	}
+	groups, err = selectGroups(split, []string{"build"}, nil)
+	c.Check(err, IsNil)
+	c.Check(groups, DeepEquals, []config.GroupName{"build-1", "build-2"})
+
+	groups, err = selectGroups(split, nil, []string{"build-1"})
+	c.Check(err, IsNil)
+	c.Check(groups, DeepEquals, []config.GroupName{"primary", "build-2"})
+}
+
+func (s *MySuite) TestConfigureDeploymentDir(c *C) {
+	defer func(d string) { outputDir = d }(outputDir)
+	outputDir = c.MkDir()
+	newConfig := func() config.DeploymentConfig {
+		return config.DeploymentConfig{Config: config.Blueprint{
+			Vars: config.NewDict(map[string]cty.Value{"deployment_name": cty.StringVal("hpc")})}}
+	}
+
+	// a new deployment is given a new id
+	dc := newConfig()
+	c.Assert(configureDeploymentDir(&dc), IsNil)
+	c.Check(dc.Config.DeploymentID(), Matches, "[0-9a-f]{16}")
+
+	// an existing deployment keeps its id
+	artifacts := filepath.Join(outputDir, "hpc", ".ghpc", "artifacts")
+	c.Assert(os.MkdirAll(artifacts, 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(artifacts, expandedBlueprintFilename), []byte(`vars:
+  deployment_name: hpc
+  labels: {ghpc_deployment_id: 0123456789abcdef}
+`), 0644), IsNil)
+	dc = newConfig()
+	c.Assert(configureDeploymentDir(&dc), IsNil)
+	c.Check(dc.Config.DeploymentID(), Equals, "0123456789abcdef")
+}
+
+func (s *MySuite) TestApplyModulePolicies(c *C) {
+	defer func(f string) { modulePolicyFile = f }(modulePolicyFile)
+	dir := c.MkDir()
+	adminPolicy := filepath.Join(dir, "admin.yaml")
+	c.Assert(os.WriteFile(adminPolicy, []byte("deny:\n- kind: packer\n"), 0644), IsNil)
+	userPolicy := filepath.Join(dir, "user.yaml")
+	c.Assert(os.WriteFile(userPolicy, []byte("exempt:\n- source: \"**\"\n"), 0644), IsNil)
+	c.Assert(os.Setenv(modulePolicyEnv, adminPolicy), IsNil)
+	defer os.Unsetenv(modulePolicyEnv)
+
+	bp := `
+blueprint_name: policy
+vars:
+  project_id: test-project
+  deployment_name: policy
+deployment_groups:
+- group: image
+  modules:
+  - id: image
+    source: mock/image
+    kind: packer
+`
+	// neither --module-policy "" nor exemptions relax the admin policy
+	for _, f := range []string{"", userPolicy} {
+		modulePolicyFile = f
+		dc, err := config.NewDeploymentConfigFromData("test", []byte(bp), "")
+		c.Assert(err, IsNil)
+		c.Assert(applyModulePolicies(&dc), IsNil)
+		dc.Config.ValidationLevel = config.ValidationIgnore
+		c.Check(dc.ExpandConfig(context.Background()), ErrorMatches, ".*not permitted by the module policy: image .*", Commentf("--module-policy %q", f))
+	}
+}
+
+func (s *MySuite) TestRunCreateCmdReturnsErrors(c *C) {
+	defer func(l string) { cdktfLanguage = l }(cdktfLanguage)
+	cdktfLanguage = "cobol"
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	err := runCreateCmd(cmd, []string{"blueprint.yaml"})
+	c.Check(err, ErrorMatches, `--cdktf must be one of .*, got "cobol"`)
+}
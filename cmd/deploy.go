@@ -16,13 +16,23 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
 	"hpc-toolkit/pkg/shell"
+	"hpc-toolkit/pkg/validators"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 func init() {
@@ -33,15 +43,41 @@ func init() {
 
 	autoApproveFlag := "auto-approve"
 	deployCmd.Flags().BoolVarP(&autoApprove, autoApproveFlag, "", false, "Automatically approve proposed changes")
+	deployCmd.Flags().StringSliceVar(&onlyGroups, "only-group", nil, onlyGroupDesc)
+	deployCmd.Flags().StringSliceVar(&skipGroups, "skip-group", nil, skipGroupDesc)
+	deployCmd.Flags().StringSliceVar(&deployTargets, "target", nil,
+		"Apply only the changes to these modules, by ID, and their dependencies with terraform apply -target")
+	deployCmd.MarkFlagsMutuallyExclusive("only-group", "skip-group", "target")
+	deployCmd.Flags().BoolVar(&detach, "detach", false,
+		"Run the deployment in the background as a job that survives the end of the terminal session; requires --auto-approve")
+	deployCmd.Flags().StringVar(&executor, "executor", shell.LocalExecutor,
+		"Where to run terraform and packer: \"local\", \"container\" or \"cloudbuild\"; remote executors require --auto-approve")
+	deployCmd.Flags().StringVar(&executorImage, "image", "",
+		"Container image providing ghpc, terraform and packer for the container and cloudbuild executors")
+	deployCmd.Flags().StringVar(&stagingBucket, "staging-bucket", "",
+		"Cloud Storage bucket, gs://BUCKET[/FOLDER], that holds the deployment and build logs of the cloudbuild executor")
+	deployCmd.Flags().BoolVar(&installTerraform, "install-terraform", false,
+		"Download a version of Terraform that satisfies the deployment into the ghpc cache if terraform in PATH does not")
+
+	deployCmd.Flags().BoolVar(&forceUnlock, "force-unlock", false,
+		"Offer to remove the lock on the Terraform state of a group held by another, e.g. an interrupted deployment; "+
+			"with --auto-approve the lock is removed without confirmation")
 
 	rootCmd.AddCommand(deployCmd)
 }
 
 var (
-	deploymentRoot string
-	autoApprove    bool
-	applyBehavior  shell.ApplyBehavior
-	deployCmd      = &cobra.Command{
+	deploymentRoot   string
+	autoApprove      bool
+	detach           bool
+	executor         string
+	executorImage    string
+	stagingBucket    string
+	installTerraform bool
+	forceUnlock      bool
+	deployTargets    []string
+	applyBehavior    shell.ApplyBehavior
+	deployCmd        = &cobra.Command{
 		Use:               "deploy DEPLOYMENT_DIRECTORY",
 		Short:             "deploy all resources in a Toolkit deployment directory.",
 		Long:              "deploy all resources in a Toolkit deployment directory.",
@@ -55,6 +91,9 @@ var (
 
 func parseDeployArgs(cmd *cobra.Command, args []string) error {
 	applyBehavior = getApplyBehavior(autoApprove)
+	if detach && !autoApprove {
+		return fmt.Errorf("--detach requires --auto-approve, as detached deployments cannot prompt for approval")
+	}
 
 	deploymentRoot = args[0]
 	artifactsDir = getArtifactsDir(deploymentRoot)
@@ -62,6 +101,32 @@ func parseDeployArgs(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	return checkExecutorArgs()
+}
+
+// checkExecutorArgs errors if the flags required by the executor are missing
+func checkExecutorArgs() error {
+	switch executor {
+	case shell.LocalExecutor:
+		return nil
+	case shell.ContainerExecutor, shell.CloudBuildExecutor:
+	default:
+		return fmt.Errorf("unknown executor %q, must be one of %s, %s or %s",
+			executor, shell.LocalExecutor, shell.ContainerExecutor, shell.CloudBuildExecutor)
+	}
+
+	if !autoApprove {
+		return fmt.Errorf("the %s executor requires --auto-approve, as it cannot prompt for approval", executor)
+	}
+	if executorImage == "" {
+		return fmt.Errorf("the %s executor requires --image", executor)
+	}
+	if executor == shell.CloudBuildExecutor && stagingBucket == "" {
+		return fmt.Errorf("the %s executor requires --staging-bucket", executor)
+	}
+	if artifactsDir != filepath.Clean(filepath.Join(deploymentRoot, defaultArtifactsDir)) {
+		return fmt.Errorf("the %s executor only supports the default artifacts directory", executor)
+	}
 	return nil
 }
 
@@ -73,37 +138,298 @@ func getApplyBehavior(autoApprove bool) shell.ApplyBehavior {
 }
 
 func runDeployCmd(cmd *cobra.Command, args []string) error {
+	if detach {
+		job, err := shell.StartDetachedJob(artifactsDir, withoutDetachFlag(os.Args[1:]))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Started deployment job %s, logging to %s\n", job.ID, job.Log)
+		fmt.Printf("Follow its progress with: ghpc jobs logs --follow %s %s\n", deploymentRoot, job.ID)
+		return nil
+	}
+
+	var err error
+	switch executor {
+	case shell.ContainerExecutor:
+		err = remoteDeployment().RunInContainer()
+	case shell.CloudBuildExecutor:
+		err = deployInCloudBuild()
+	default:
+		err = deploy()
+	}
+	if id := os.Getenv(shell.JobIDEnv); id != "" {
+		if ferr := shell.FinishJob(artifactsDir, id, err); ferr != nil {
+			log.Printf("failed to record the result of job %s: %v", id, ferr)
+		}
+	}
+	return err
+}
+
+// remoteDeployment describes the deployment for the container and cloudbuild
+// executors, passing along the groups to deploy
+func remoteDeployment() shell.RemoteDeployment {
+	args := []string{}
+	if len(onlyGroups) > 0 {
+		args = append(args, "--only-group", strings.Join(onlyGroups, ","))
+	}
+	if len(skipGroups) > 0 {
+		args = append(args, "--skip-group", strings.Join(skipGroups, ","))
+	}
+	if len(deployTargets) > 0 {
+		args = append(args, "--target", strings.Join(deployTargets, ","))
+	}
+	if forceUnlock {
+		args = append(args, "--force-unlock")
+	}
+	return shell.RemoteDeployment{
+		DeploymentRoot: deploymentRoot,
+		Args:           args,
+		Image:          executorImage,
+		StagingBucket:  stagingBucket,
+	}
+}
+
+// deployInCloudBuild runs the deployment in Cloud Build in the project of the
+// deployment
+func deployInCloudBuild() error {
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return err
+	}
+	project := dc.Config.Vars.Get("project_id")
+	if project.Type() != cty.String || project.IsNull() {
+		return fmt.Errorf("the %s executor requires the deployment variable project_id", shell.CloudBuildExecutor)
+	}
+	r := remoteDeployment()
+	r.ProjectID = project.AsString()
+	return r.RunInCloudBuild()
+}
+
+// checkCredentials errors if the credentials with which Terraform deploys
+// have expired or were revoked, rather than failing in the middle of the
+// deployment; other failures to check them are only logged
+func checkCredentials(dc config.DeploymentConfig) error {
+	ctx := validators.WithImpersonation(context.Background(), dc.Config.ImpersonateServiceAccount)
+	err := validators.TestCredentials(ctx, "")
+	var cerr *validators.CredentialsError
+	if errors.As(err, &cerr) {
+		return err
+	}
+	if err != nil {
+		log.Printf("could not check the credentials of the deployment: %v", err)
+	}
+	return nil
+}
+
+// withoutDetachFlag returns the command line of a detached deployment
+func withoutDetachFlag(args []string) []string {
+	filtered := []string{}
+	for _, a := range args {
+		if a == "--detach" || strings.HasPrefix(a, "--detach=") {
+			continue
+		}
+		filtered = append(filtered, a)
+	}
+	return filtered
+}
+
+func deploy() error {
 	expandedBlueprintFile := filepath.Join(artifactsDir, expandedBlueprintFilename)
 	dc, err := config.NewDeploymentConfig(expandedBlueprintFile)
 	if err != nil {
 		return err
 	}
+	if err := dc.Config.RestoreSensitiveVars(deploymentRoot); err != nil {
+		return err
+	}
 
 	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
 		return err
 	}
+	if err := configureTerraformVersion(); err != nil {
+		return err
+	}
+	if err := shell.ConfigureProviderInstallation(deploymentRoot); err != nil {
+		return err
+	}
+	if err := shell.ConfigurePackerPlugins(deploymentRoot); err != nil {
+		return err
+	}
+
+	groups, err := selectGroups(dc.Config, onlyGroups, skipGroups)
+	if err != nil {
+		return err
+	}
+	targets, err := targetAddresses(dc.Config, deployTargets)
+	if err != nil {
+		return err
+	}
+	if targets != nil {
+		groups = maps.Keys(targets)
+	}
+	if err := checkUpstreamOutputs(dc, groups); err != nil {
+		return err
+	}
+	if err := checkCredentials(dc); err != nil {
+		return err
+	}
 
 	for _, group := range dc.Config.DeploymentGroups {
-		groupDir := filepath.Join(deploymentRoot, string(group.Name))
-		if err = shell.ImportInputs(groupDir, artifactsDir, expandedBlueprintFile); err != nil {
+		if groups != nil && !slices.Contains(groups, group.Name) {
+			log.Printf("skipping deployment group %s", group.Name)
+			continue
+		}
+		notifyGroup(dc, group, config.DeployStarted, nil)
+		err := deployGroupWithRetries(dc, group, expandedBlueprintFile, targets[group.Name])
+		if err != nil {
+			notifyGroup(dc, group, config.DeployFailed, err)
 			return err
 		}
+		notifyGroup(dc, group, config.DeploySucceeded, nil)
+	}
+	return nil
+}
 
-		var err error
-		switch group.Kind {
-		case config.PackerKind:
-			// Packer groups are enforced to have length 1
-			moduleDir := filepath.Join(groupDir, string(group.Modules[0].ID))
-			err = deployPackerGroup(moduleDir)
-		case config.TerraformKind:
-			err = deployTerraformGroup(groupDir)
-		default:
-			err = fmt.Errorf("group %s is an unsupported kind %s", groupDir, group.Kind.String())
+// configureTerraformVersion checks that terraform in PATH satisfies the
+// version range recorded in the manifest of the deployment. With
+// --install-terraform, a satisfying version is installed in the ghpc cache
+// instead and put first in PATH.
+func configureTerraformVersion() error {
+	m, err := modulewriter.ReadManifest(deploymentRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // deployments written by older versions of ghpc
+	}
+	if err != nil || m.TerraformVersion == "" {
+		return err
+	}
+	err = shell.CheckTerraformVersion(m.TerraformVersion)
+	if err == nil || !installTerraform {
+		return err
+	}
+	cacheDir := userConfig.CacheDir
+	if cacheDir == "" {
+		if cacheDir, err = os.UserCacheDir(); err != nil {
+			return fmt.Errorf("failed to find a cache directory for Terraform: %w", err)
 		}
-		if err != nil {
+		cacheDir = filepath.Join(cacheDir, "ghpc")
+	}
+	dir, err := shell.InstallTerraform(context.Background(), m.TerraformVersion, cacheDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("using terraform from %s", dir)
+	return os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// targetAddresses returns the Terraform addresses of the targeted modules by
+// group; it is nil if no module is targeted
+func targetAddresses(bp config.Blueprint, targets []string) (map[config.GroupName][]string, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	addresses := map[config.GroupName][]string{}
+	for _, t := range targets {
+		id := config.ModuleID(t)
+		if _, err := bp.Module(id); err != nil {
+			return nil, fmt.Errorf("could not find module %s in blueprint", t)
+		}
+		g := bp.ModuleGroupOrDie(id)
+		if g.Kind != config.TerraformKind {
+			return nil, fmt.Errorf("module %s is in %s group %s; only Terraform modules can be targeted", t, g.Kind, g.Name)
+		}
+		addresses[g.Name] = append(addresses[g.Name], "module."+t)
+	}
+	return addresses, nil
+}
+
+// deployGroupWithRetries deploys the group again after the failures that its
+// retry policy deems transient, such as stockouts, up to its max attempts
+func deployGroupWithRetries(dc config.DeploymentConfig, group config.DeploymentGroup, expandedBlueprintFile string, targets []string) error {
+	p := group.Retry
+	for attempt := 1; ; attempt++ {
+		err := deployGroup(dc, group, expandedBlueprintFile, targets)
+		if err == nil || attempt >= p.Attempts() || !p.Retryable(err) {
+			return err
+		}
+		log.Printf("attempt %d of %d to deploy group %s failed with a retryable error: %v", attempt, p.Attempts(), group.Name, err)
+		log.Printf("retrying the deployment of group %s in %s", group.Name, p.RetryDelay())
+		time.Sleep(p.RetryDelay())
+	}
+}
+
+func deployGroup(dc config.DeploymentConfig, group config.DeploymentGroup, expandedBlueprintFile string, targets []string) error {
+	groupDir := filepath.Join(deploymentRoot, string(group.Name))
+	if err := shell.ImportInputs(groupDir, artifactsDir, expandedBlueprintFile); err != nil {
+		return err
+	}
+
+	switch group.Kind {
+	case config.PackerKind:
+		mod := group.PackerModule()
+		if err := deployPackerGroup(filepath.Join(groupDir, string(mod.ID))); err != nil {
 			return err
 		}
+		// the family is unknown for templates without an image_family variable
+		family, err := dc.Config.BuiltImageFamily(mod)
+		if err != nil {
+			return nil
+		}
+		return shell.CheckBuiltImageFamily(deploymentRoot, group, mod, family)
+	case config.TerraformKind:
+		return deployTerraformGroup(groupDir, targets)
+	default:
+		return fmt.Errorf("group %s is an unsupported kind %s", groupDir, group.Kind.String())
+	}
+}
+
+// notifyGroup sends the notifications of the blueprint of an event of the
+// deployment of a group
+func notifyGroup(dc config.DeploymentConfig, group config.DeploymentGroup, event string, err error) {
+	notifications := append(dc.Config.Notifications, userConfig.Notifications...)
+	if len(notifications) == 0 {
+		return
+	}
+	e := shell.DeployEvent{
+		Blueprint: dc.Config.BlueprintName,
+		Group:     string(group.Name),
+		Kind:      group.Kind.String(),
+		Event:     event,
+		Time:      time.Now().UTC(),
+	}
+	if name := dc.Config.Vars.Get("deployment_name"); name.Type() == cty.String && !name.IsNull() {
+		e.Deployment = name.AsString()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	shell.Notify(notifications, e)
+}
 
+// checkUpstreamOutputs confirms that the outputs of every skipped group needed
+// by a selected group have already been exported to the artifacts directory
+func checkUpstreamOutputs(dc config.DeploymentConfig, groups []config.GroupName) error {
+	if groups == nil {
+		return nil
+	}
+	for _, g := range groups {
+		group, err := dc.Config.Group(g)
+		if err != nil {
+			return err
+		}
+		outputNamesByGroup, err := config.OutputNamesByGroup(group, dc)
+		if err != nil {
+			return err
+		}
+		for upstream, names := range outputNamesByGroup {
+			if len(names) == 0 || slices.Contains(groups, upstream) {
+				continue
+			}
+			if !shell.OutputsExported(artifactsDir, upstream) {
+				return fmt.Errorf("deployment group %s requires outputs from skipped group %s; "+
+					"deploy it or run \"ghpc export-outputs %s\" first",
+					g, upstream, filepath.Join(deploymentRoot, string(upstream)))
+			}
+		}
 	}
 	return nil
 }
@@ -134,14 +460,26 @@ func deployPackerGroup(moduleDir string) error {
 	return nil
 }
 
-func deployTerraformGroup(groupDir string) error {
+func deployTerraformGroup(groupDir string, targets []string) error {
 	tf, err := shell.ConfigureTerraform(groupDir)
 	if err != nil {
 		return err
 	}
 
-	if err = shell.ExportOutputs(tf, artifactsDir, applyBehavior); err != nil {
+	err = shell.ExportOutputs(tf, artifactsDir, applyBehavior, targets...)
+	var lockErr *shell.StateLockError
+	if !forceUnlock || !errors.As(err, &lockErr) {
 		return err
 	}
-	return nil
+
+	// the lock of an interrupted deployment outlives it; it is only removed
+	// once confirmed, as removing the lock of a running command corrupts the
+	// state
+	if applyBehavior != shell.AutomaticApply && !shell.ForceUnlockChoice(lockErr) {
+		return err
+	}
+	if err := shell.ForceUnlock(tf, lockErr.Lock); err != nil {
+		return err
+	}
+	return shell.ExportOutputs(tf, artifactsDir, applyBehavior, targets...)
 }
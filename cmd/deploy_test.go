@@ -17,6 +17,7 @@ limitations under the License.
 package cmd
 
 import (
+	"hpc-toolkit/pkg/config"
 	"hpc-toolkit/pkg/shell"
 	"os"
 
@@ -28,9 +29,63 @@ func (s *MySuite) TestDeployGroups(c *C) {
 	var err error
 	pathEnv := os.Getenv("PATH")
 	os.Setenv("PATH", "")
-	err = deployTerraformGroup(".")
+	err = deployTerraformGroup(".", nil)
 	c.Assert(err, NotNil)
 	err = deployPackerGroup(".")
 	c.Assert(err, NotNil)
 	os.Setenv("PATH", pathEnv)
 }
+
+func (s *MySuite) TestWithoutDetachFlag(c *C) {
+	c.Check(withoutDetachFlag([]string{"deploy", "dir", "--detach", "--auto-approve", "--detach=true"}),
+		DeepEquals, []string{"deploy", "dir", "--auto-approve"})
+}
+
+func (s *MySuite) TestCheckExecutorArgs(c *C) {
+	defer func() {
+		executor, executorImage, stagingBucket, autoApprove = shell.LocalExecutor, "", "", false
+		artifactsDir = ""
+	}()
+	deploymentRoot = "hpc"
+	artifactsDir = ""
+	artifactsDir = getArtifactsDir(deploymentRoot)
+
+	executor = shell.LocalExecutor
+	c.Check(checkExecutorArgs(), IsNil)
+
+	executor = "laptop"
+	c.Check(checkExecutorArgs(), ErrorMatches, `unknown executor "laptop".*`)
+
+	executor = shell.CloudBuildExecutor
+	c.Check(checkExecutorArgs(), ErrorMatches, ".*requires --auto-approve.*")
+	autoApprove = true
+	c.Check(checkExecutorArgs(), ErrorMatches, ".*requires --image")
+	executorImage = "us-docker.pkg.dev/project/repo/ghpc"
+	c.Check(checkExecutorArgs(), ErrorMatches, ".*requires --staging-bucket")
+	stagingBucket = "gs://bucket"
+	c.Check(checkExecutorArgs(), IsNil)
+}
+
+func (s *MySuite) TestTargetAddresses(c *C) {
+	bp := config.Blueprint{DeploymentGroups: []config.DeploymentGroup{
+		{Name: "network", Kind: config.TerraformKind, Modules: []config.Module{{ID: "vpc"}}},
+		{Name: "image", Kind: config.PackerKind, Modules: []config.Module{{ID: "builder"}}},
+		{Name: "cluster", Kind: config.TerraformKind, Modules: []config.Module{{ID: "debug"}, {ID: "compute"}}},
+	}}
+
+	got, err := targetAddresses(bp, nil)
+	c.Check(err, IsNil)
+	c.Check(got, IsNil)
+
+	got, err = targetAddresses(bp, []string{"compute", "vpc", "debug"})
+	c.Check(err, IsNil)
+	c.Check(got, DeepEquals, map[config.GroupName][]string{
+		"network": {"module.vpc"},
+		"cluster": {"module.compute", "module.debug"},
+	})
+
+	_, err = targetAddresses(bp, []string{"builder"})
+	c.Check(err, ErrorMatches, "module builder is in packer group image; .*")
+	_, err = targetAddresses(bp, []string{"nope"})
+	c.Check(err, ErrorMatches, "could not find module nope in blueprint")
+}
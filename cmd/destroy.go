@@ -16,12 +16,15 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"hpc-toolkit/pkg/config"
 	"hpc-toolkit/pkg/modulewriter"
 	"hpc-toolkit/pkg/shell"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -32,12 +35,21 @@ func init() {
 	destroyCmd.MarkFlagDirname(artifactsFlag)
 
 	destroyCmd.Flags().BoolVar(&autoApprove, "auto-approve", false, "Automatically approve proposed changes")
+	destroyCmd.Flags().BoolVar(&forceDestroy, "force", false,
+		"Destroy deployment groups whose outputs are still used by resources of later groups")
+	destroyCmd.Flags().BoolVar(&noDrainSlurm, "no-drain-slurm", false,
+		"Do not drain the partitions of Slurm clusters, or stop their controllers, before deleting their compute nodes")
+	destroyCmd.Flags().BoolVar(&skipSlurmCleanup, "skip-slurm-cleanup", false,
+		"Do not delete the compute nodes and placement groups that Slurm controllers created on demand")
 
 	rootCmd.AddCommand(destroyCmd)
 }
 
 var (
-	destroyCmd = &cobra.Command{
+	forceDestroy     bool
+	noDrainSlurm     bool
+	skipSlurmCleanup bool
+	destroyCmd       = &cobra.Command{
 		Use:               "destroy DEPLOYMENT_DIRECTORY",
 		Short:             "destroy all resources in a Toolkit deployment directory.",
 		Long:              "destroy all resources in a Toolkit deployment directory.",
@@ -72,6 +84,9 @@ func runDestroyCmd(cmd *cobra.Command, args []string) error {
 	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
 		return err
 	}
+	if err := shell.ConfigureProviderInstallation(deploymentRoot); err != nil {
+		return err
+	}
 
 	// destroy in reverse order of creation!
 	packerManifests := []string{}
@@ -82,12 +97,16 @@ func runDestroyCmd(cmd *cobra.Command, args []string) error {
 		var err error
 		switch group.Kind {
 		case config.PackerKind:
-			// Packer groups are enforced to have length 1
 			// TODO: destroyPackerGroup(moduleDir)
-			moduleDir := filepath.Join(groupDir, string(group.Modules[0].ID))
+			moduleDir := filepath.Join(groupDir, string(group.PackerModule().ID))
 			packerManifests = append(packerManifests, filepath.Join(moduleDir, "packer-manifest.json"))
 		case config.TerraformKind:
-			err = destroyTerraformGroup(groupDir)
+			if err = checkDependentGroups(dc, group); err == nil {
+				err = cleanupSlurmClusters(dc, group)
+			}
+			if err == nil {
+				err = destroyTerraformGroup(groupDir)
+			}
 		default:
 			err = fmt.Errorf("group %s is an unsupported kind %s", groupDir, group.Kind.String())
 		}
@@ -108,3 +127,128 @@ func destroyTerraformGroup(groupDir string) error {
 
 	return shell.Destroy(tf, applyBehavior)
 }
+
+// cleanupSlurmClusters deletes the compute nodes and placement groups that
+// the Slurm controllers of a group created on demand. Terraform does not know
+// about them, so destroying the group would leave them running and the
+// networks they use could not be destroyed. Controllers that delete them
+// themselves, with enable_cleanup_compute, are skipped.
+func cleanupSlurmClusters(dc config.DeploymentConfig, group config.DeploymentGroup) error {
+	if skipSlurmCleanup {
+		return nil
+	}
+	ctx := context.Background()
+	var stateIDs map[string][]string
+	for _, c := range dc.Config.SlurmClusters(group.Name) {
+		if c.CleansUp {
+			continue
+		}
+		if c.DeploymentID == "" {
+			log.Printf("WARNING: deployment %s has no %s label, so the compute nodes of Slurm cluster %s cannot be told apart "+
+				"from those of deployments of the same name and are not deleted", deploymentRoot, config.DeploymentIDLabel, c.Name)
+			continue
+		}
+		if !noDrainSlurm {
+			if err := shell.DrainSlurmPartitions(ctx, c); err != nil {
+				log.Printf("WARNING: %v; deleting its compute nodes anyway", err)
+			}
+		}
+		if stateIDs == nil {
+			var unread []string
+			var err error
+			if stateIDs, unread, err = deploymentStateIDs(dc); err != nil {
+				return err
+			}
+			if len(unread) > 0 {
+				log.Printf("WARNING: the Terraform states of groups %s were not read, so the compute nodes of the Slurm clusters of group %s are not deleted",
+					strings.Join(unread, ", "), group.Name)
+				return nil
+			}
+		}
+		resources, err := shell.SlurmResources(ctx, c, stateIDs)
+		if err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			continue
+		}
+
+		names := []string{}
+		for _, r := range resources {
+			names = append(names, r.ShortName())
+		}
+		changes := shell.ProposedChanges{
+			Summary: fmt.Sprintf("Proposed change: delete %d compute nodes and placement groups of Slurm cluster %s, created by module %s",
+				len(resources), c.Name, c.Module),
+			Full: "Proposed change: delete resources created on demand by Slurm\n" + strings.Join(names, "\n"),
+		}
+		if applyBehavior != shell.AutomaticApply && !shell.ApplyChangesChoice(changes) {
+			log.Printf("WARNING: the compute nodes of Slurm cluster %s are kept; destroying the networks they use will fail", c.Name)
+			continue
+		}
+		if err := shell.DeleteOrphans(ctx, resources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependentGroups returns the later groups that use outputs of a group, with
+// the names of the outputs they use
+func dependentGroups(dc config.DeploymentConfig, group config.DeploymentGroup) (map[config.GroupName][]string, error) {
+	deps := map[config.GroupName][]string{}
+	i := dc.Config.GroupIndex(group.Name)
+	if i == -1 {
+		return nil, fmt.Errorf("group %s not found in blueprint", group.Name)
+	}
+	for _, g := range dc.Config.DeploymentGroups[i+1:] {
+		outputs, err := config.OutputNamesByGroup(g, dc)
+		if err != nil {
+			return nil, err
+		}
+		if len(outputs[group.Name]) > 0 {
+			deps[g.Name] = outputs[group.Name]
+		}
+	}
+	return deps, nil
+}
+
+// checkDependentGroups refuses to destroy a group while the Terraform state of
+// a later group that uses its outputs still holds resources, e.g. compute
+// nodes attached to the network being destroyed. Destroying it would orphan
+// them or leave the deletion stuck. With --force, a warning is logged instead.
+func checkDependentGroups(dc config.DeploymentConfig, group config.DeploymentGroup) error {
+	deps, err := dependentGroups(dc, group)
+	if err != nil {
+		return err
+	}
+	for _, g := range dc.Config.DeploymentGroups {
+		outputs, ok := deps[g.Name]
+		if !ok || g.Kind != config.TerraformKind {
+			continue
+		}
+		groupDir := filepath.Join(deploymentRoot, string(g.Name))
+		if isDir, _ := shell.DirInfo(groupDir); !isDir {
+			continue
+		}
+		tf, err := shell.ConfigureTerraform(groupDir)
+		if err != nil {
+			return err
+		}
+		resources, err := shell.StateResources(tf)
+		if err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("group %s still has %d resources, such as %s, that use outputs %s of group %s",
+			g.Name, len(resources), resources[0], strings.Join(outputs, ", "), group.Name)
+		if forceDestroy {
+			log.Printf("WARNING: %s; destroying group %s anyway", msg, group.Name)
+			continue
+		}
+		return fmt.Errorf("%s; destroy group %s first, or pass --force", msg, g.Name)
+	}
+	return nil
+}
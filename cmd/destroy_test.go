@@ -0,0 +1,65 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestDependentGroups(c *C) {
+	network := config.DeploymentGroup{
+		Name: "network",
+		Modules: []config.Module{{
+			ID:      "vpc",
+			Outputs: []modulereader.OutputInfo{{Name: "network_name"}},
+		}},
+	}
+	image := config.DeploymentGroup{
+		Name:    "image",
+		Kind:    config.PackerKind,
+		Modules: []config.Module{{ID: "builder", Settings: config.NewDict(nil)}},
+	}
+	cluster := config.DeploymentGroup{
+		Name: "cluster",
+		Modules: []config.Module{{
+			ID: "nodes",
+			Settings: config.NewDict(map[string]cty.Value{
+				"network": config.MustParseExpression("module.vpc.network_name").AsValue(),
+			}),
+		}},
+	}
+	dc := config.DeploymentConfig{Config: config.Blueprint{
+		DeploymentGroups: []config.DeploymentGroup{network, image, cluster},
+	}}
+
+	deps, err := dependentGroups(dc, network)
+	c.Assert(err, IsNil)
+	c.Check(deps, DeepEquals, map[config.GroupName][]string{
+		"cluster": {config.AutomaticOutputName("network_name", "vpc")},
+	})
+
+	deps, err = dependentGroups(dc, cluster)
+	c.Assert(err, IsNil)
+	c.Check(deps, DeepEquals, map[config.GroupName][]string{})
+
+	_, err = dependentGroups(dc, config.DeploymentGroup{Name: "unknown"})
+	c.Check(err, ErrorMatches, ".*unknown not found.*")
+}
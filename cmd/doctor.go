@@ -0,0 +1,79 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	doctorCmd.Flags().StringVar(&doctorProject, "project", "", "Project whose organization policies are checked")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var (
+	doctorProject string
+	doctorCmd     = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the local environment can create and deploy blueprints.",
+		Long: "Check the versions of terraform, packer and gcloud, the Application Default Credentials, " +
+			"the organization policy on default service accounts and access to the endpoints used by deployments, " +
+			"and print how to fix each problem.",
+		Args:         cobra.NoArgs,
+		RunE:         runDoctorCmd,
+		SilenceUsage: true,
+	}
+)
+
+func runDoctorCmd(cmd *cobra.Command, args []string) error {
+	diags := shell.Diagnose(doctorProject)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tCHECK\tDETAIL")
+	failed := 0
+	for _, d := range diags {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", d.Status, d.Check, d.Detail)
+		if d.Status == shell.DiagnosticFailure {
+			failed++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fixes := []shell.Diagnostic{}
+	for _, d := range diags {
+		if d.Status != shell.DiagnosticOK && d.Fix != "" {
+			fixes = append(fixes, d)
+		}
+	}
+	if len(fixes) > 0 {
+		fmt.Println()
+		fmt.Println("Suggested fixes:")
+		for _, d := range fixes {
+			fmt.Printf("  %s: %s\n", d.Check, d.Fix)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
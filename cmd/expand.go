@@ -17,6 +17,9 @@ package cmd
 
 import (
 	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/sourcereader"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -27,28 +30,72 @@ func init() {
 		"please see the command usage for more details."))
 
 	expandCmd.Flags().StringVarP(&outputFilename, "out", "o", "expanded.yaml",
-		"Output file for the expanded HPC Environment Definition.")
+		"Output file for the expanded HPC Environment Definition; - writes it to stdout, the default with --stdin.")
+	expandCmd.Flags().BoolVar(&readStdin, "stdin", false,
+		"Read the blueprint from stdin instead of BLUEPRINT_NAME, so that ghpc expand can be used as a filter.")
 	expandCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	expandCmd.Flags().StringVar(&blueprintName, "blueprint", "", blueprintNameDesc)
 	expandCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
 	expandCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
 	expandCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	expandCmd.Flags().DurationVar(&validationTimeout, "validation-timeout", 0, validationTimeoutDesc)
+	expandCmd.Flags().StringVar(&modulePolicyFile, "module-policy", "", modulePolicyDesc)
+	expandCmd.Flags().BoolVar(&debugExpansion, "debug-expansion", false, debugExpansionDesc)
+	expandCmd.Flags().BoolVar(&selectZone, "select-zone", false, selectZoneDesc)
+	expandCmd.Flags().BoolVar(&annotateProvenance, "provenance", false,
+		"Annotate every module setting of the expanded blueprint with a comment telling where its value came from.")
+	expandCmd.Flags().BoolVar(&minimizeExpansion, "minimize", false,
+		"Leave out of the expanded blueprint the validators, variables, settings and other fields that the toolkit adds to every blueprint.")
 	rootCmd.AddCommand(expandCmd)
 }
 
 var (
-	outputFilename string
-	expandCmd      = &cobra.Command{
+	outputFilename     string
+	annotateProvenance bool
+	minimizeExpansion  bool
+	readStdin          bool
+	expandCmd          = &cobra.Command{
 		Use:               "expand BLUEPRINT_NAME",
 		Short:             "Expand the Environment Blueprint.",
 		Long:              "Updates the Environment Blueprint in the same way as create, but without writing the deployment.",
 		Run:               runExpandCmd,
-		Args:              cobra.ExactArgs(1),
+		Args:              expandArgs,
 		ValidArgsFunction: filterYaml,
 	}
 )
 
+// expandArgs requires the blueprint file, unless the blueprint is read from
+// stdin
+func expandArgs(cmd *cobra.Command, args []string) error {
+	if readStdin && len(args) > 0 {
+		return fmt.Errorf("the blueprint is read from stdin with --stdin, got %s", args[0])
+	}
+	if readStdin {
+		return nil
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
 func runExpandCmd(cmd *cobra.Command, args []string) {
-	dc := expandOrDie(args[0])
-	cobra.CheckErr(dc.ExportBlueprint(outputFilename))
+	ctx, stop := interruptContext(cmd)
+	defer stop()
+	defer sourcereader.CleanupFetched()
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+	dc := expandOrDie(ctx, path)
+	opts := config.ExportOptions{
+		Provenance: annotateProvenance,
+		Minimize:   minimizeExpansion,
+	}
+	if outputFilename == "-" || (readStdin && !cmd.Flags().Changed("out")) {
+		out, err := dc.MarshalBlueprint(opts)
+		cobra.CheckErr(err)
+		_, err = os.Stdout.Write(out)
+		cobra.CheckErr(err)
+		return
+	}
+	cobra.CheckErr(dc.ExportBlueprintWithOptions(outputFilename, opts))
 	fmt.Printf("Expanded Environment Definition created successfully, saved as %s.\n", outputFilename)
 }
@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	fmtCmd.Flags().BoolVarP(&fmtWrite, "write", "w", false, "Write the result to the blueprint files instead of stdout")
+	fmtCmd.Flags().BoolVarP(&fmtList, "list", "l", false, "List blueprint files whose formatting differs from ghpc fmt and exit with status 1 if there are any")
+	fmtCmd.MarkFlagsMutuallyExclusive("write", "list")
+	rootCmd.AddCommand(fmtCmd)
+}
+
+var (
+	fmtWrite bool
+	fmtList  bool
+	fmtCmd   = &cobra.Command{
+		Use:               "fmt BLUEPRINT_NAME...",
+		Short:             "Format blueprints.",
+		Long:              "Rewrites blueprints with a canonical key order, formatted expressions and consistent quoting, preserving comments.",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: filterYaml,
+		RunE:              runFmtCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runFmtCmd(cmd *cobra.Command, args []string) error {
+	unformatted := false
+	for _, path := range args {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, err := config.FormatBlueprint(src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		switch {
+		case fmtList:
+			if !bytes.Equal(src, out) {
+				fmt.Println(path)
+				unformatted = true
+			}
+		case fmtWrite:
+			if bytes.Equal(src, out) {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			if _, err := os.Stdout.Write(out); err != nil {
+				return err
+			}
+		}
+	}
+	if unformatted {
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{imagesListCmd, imagesPruneCmd} {
+		c.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+		c.MarkFlagDirname("artifacts")
+		imagesCmd.AddCommand(c)
+	}
+	imagesPruneCmd.Flags().IntVar(&keepImages, "keep", 1, "Number of the newest images of each Packer module to keep")
+	imagesPruneCmd.Flags().BoolVar(&autoApprove, "auto-approve", false, "Delete the images without asking for approval")
+
+	rootCmd.AddCommand(imagesCmd)
+}
+
+var (
+	keepImages int
+	imagesCmd  = &cobra.Command{
+		Use:   "images",
+		Short: "Manage the images built by the Packer groups of a deployment.",
+	}
+	imagesListCmd = &cobra.Command{
+		Use:               "list DEPLOYMENT_DIRECTORY",
+		Short:             "List the images built by the deployment, newest first.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseImagesArgs,
+		RunE:              runImagesListCmd,
+		SilenceUsage:      true,
+	}
+	imagesPruneCmd = &cobra.Command{
+		Use:               "prune DEPLOYMENT_DIRECTORY",
+		Short:             "Delete all but the newest images built by each Packer module of the deployment.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRunE:           parseImagesPruneArgs,
+		RunE:              runImagesPruneCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func parseImagesPruneArgs(cmd *cobra.Command, args []string) error {
+	if keepImages < 1 {
+		return fmt.Errorf("--keep must be at least 1, so that the latest image of each module is kept")
+	}
+	parseImagesArgs(cmd, args)
+	return nil
+}
+
+func parseImagesArgs(cmd *cobra.Command, args []string) {
+	deploymentRoot = args[0]
+	artifactsDir = getArtifactsDir(deploymentRoot)
+}
+
+func builtImages() ([]shell.BuiltImage, error) {
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return nil, err
+	}
+	return shell.BuiltImages(deploymentRoot, dc.Config.DeploymentGroups)
+}
+
+func runImagesListCmd(cmd *cobra.Command, args []string) error {
+	images, err := builtImages()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tIMAGE\tFAMILY\tPROJECT\tBUILT")
+	for _, img := range images {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			img.Module, img.Name, img.Family, img.Project, img.Built.Local().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func runImagesPruneCmd(cmd *cobra.Command, args []string) error {
+	images, err := builtImages()
+	if err != nil {
+		return err
+	}
+	prune := shell.ImagesToPrune(images, keepImages)
+	if len(prune) == 0 {
+		fmt.Println("No images to prune")
+		return nil
+	}
+
+	names := []string{}
+	for _, img := range prune {
+		names = append(names, fmt.Sprintf("%s (module %s, project %s)", img.Name, img.Module, img.Project))
+	}
+	c := shell.ProposedChanges{
+		Summary: fmt.Sprintf("Proposed change: delete %d images built by %s", len(prune), deploymentRoot),
+		Full:    "Proposed change: delete images\n" + strings.Join(names, "\n"),
+	}
+	if !autoApprove && !shell.ApplyChangesChoice(c) {
+		return nil
+	}
+	return shell.DeleteImages(prune)
+}
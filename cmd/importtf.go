@@ -0,0 +1,61 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	importTfCmd.Flags().StringVarP(&importTfOut, "out", "o", "", "Write the blueprint to this file instead of stdout")
+	rootCmd.AddCommand(importTfCmd)
+}
+
+var (
+	importTfOut string
+	importTfCmd = &cobra.Command{
+		Use:   "import-tf TERRAFORM_DIRECTORY",
+		Short: "Generate a blueprint from a Terraform root module.",
+		Long: "Generates a starting blueprint from a Terraform root module: the modules it calls become blueprint modules " +
+			"and its variables deployment variables. Everything that needs review is reported on stderr.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runImportTfCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runImportTfCmd(cmd *cobra.Command, args []string) error {
+	bp, warnings, err := config.BlueprintFromTerraform(args[0])
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if importTfOut == "" {
+		_, err = os.Stdout.Write(bp)
+		return err
+	}
+	if _, err := os.Stat(importTfOut); err == nil {
+		return fmt.Errorf("%s already exists", importTfOut)
+	}
+	return os.WriteFile(importTfOut, bp, 0644)
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func init() {
+	inventoryCmd.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+	inventoryCmd.MarkFlagDirname("artifacts")
+	inventoryCmd.Flags().StringSliceVar(&inventoryProjects, "projects", nil,
+		"Projects to search (defaults to the project_id of the deployment and the projects of its groups)")
+	inventoryCmd.Flags().BoolVar(&inventoryOrphans, "orphans", false, "List only the orphaned resources")
+	inventoryCmd.Flags().BoolVar(&deleteOrphans, "delete-orphans", false,
+		"Delete the orphaned Compute Engine instances, instance templates, disks, images and placement policies")
+	inventoryCmd.Flags().BoolVar(&autoApprove, "auto-approve", false, "Delete the orphans without asking for approval")
+	rootCmd.AddCommand(inventoryCmd)
+}
+
+var (
+	inventoryProjects []string
+	inventoryOrphans  bool
+	deleteOrphans     bool
+	inventoryCmd      = &cobra.Command{
+		Use:   "inventory DEPLOYMENT_DIRECTORY",
+		Short: "List the resources labeled with the deployment and flag those that it does not manage.",
+		Long: "Lists the resources whose ghpc_deployment_id label is that of the deployment, found with Cloud Asset Inventory, " +
+			"and compares them with the Terraform states of its groups and the images recorded by its Packer groups. " +
+			"Resources recorded in neither, nor created by managed instance groups or instance templates, " +
+			"e.g. Slurm nodes created on demand, are orphaned; --delete-orphans deletes them.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseImagesArgs,
+		RunE:              runInventoryCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runInventoryCmd(cmd *cobra.Command, args []string) error {
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return err
+	}
+	// deployments written before ghpc_deployment_id was set are found by their
+	// name, which other deployments may share
+	labelKey, label := config.DeploymentIDLabel, dc.Config.DeploymentID()
+	if label == "" {
+		labelKey = "ghpc_deployment"
+		if label, err = dc.Config.DeploymentLabel(); err != nil {
+			return err
+		}
+	}
+	projects := inventoryProjects
+	if len(projects) == 0 {
+		projects = dc.Config.Projects()
+	}
+	if len(projects) == 0 {
+		return fmt.Errorf("the projects of deployment %s are not known, set them with --projects", deploymentRoot)
+	}
+
+	ctx := context.Background()
+	resources, err := shell.LabeledResources(ctx, projects, labelKey, label)
+	if err != nil {
+		return err
+	}
+	stateIDs, unread, err := deploymentStateIDs(dc)
+	if err != nil {
+		return err
+	}
+	images, err := shell.BuiltImages(deploymentRoot, dc.Config.DeploymentGroups)
+	if err != nil {
+		return err
+	}
+	shell.ClassifyResources(resources, stateIDs, images)
+	if err := shell.ClassifyInstances(ctx, resources); err != nil {
+		return err
+	}
+	slices.SortStableFunc(resources, func(a, b shell.InventoryResource) bool { return a.Name < b.Name })
+
+	orphans := shell.Orphans(resources)
+	listed := resources
+	if inventoryOrphans || deleteOrphans {
+		listed = orphans
+	}
+	if err := printInventory(listed); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d resources are labeled %s=%s in %s, %d of them orphaned\n",
+		len(resources), labelKey, label, strings.Join(projects, ", "), len(orphans))
+	if len(unread) > 0 {
+		fmt.Printf("The Terraform states of groups %s were not read, so resources they manage may be listed as orphaned\n",
+			strings.Join(unread, ", "))
+	}
+
+	if !deleteOrphans || len(orphans) == 0 {
+		return nil
+	}
+	if labelKey != config.DeploymentIDLabel {
+		return fmt.Errorf("refusing to delete orphans, since deployment %s has no %s label and other deployments may share its name; "+
+			"write it again with ghpc create and deploy it", deploymentRoot, config.DeploymentIDLabel)
+	}
+	if len(unread) > 0 {
+		return fmt.Errorf("refusing to delete orphans, since the Terraform states of groups %s were not read; "+
+			"write the groups to %s with ghpc create and retry", strings.Join(unread, ", "), deploymentRoot)
+	}
+	names := []string{}
+	for _, r := range orphans {
+		if r.IsDeletable() {
+			names = append(names, r.ShortName())
+		}
+	}
+	if len(names) == 0 {
+		fmt.Println("No orphans can be deleted by ghpc; delete them manually")
+		return nil
+	}
+	c := shell.ProposedChanges{
+		Summary: fmt.Sprintf("Proposed change: delete %d orphaned resources of %s", len(names), deploymentRoot),
+		Full:    "Proposed change: delete orphaned resources\n" + strings.Join(names, "\n"),
+	}
+	if !autoApprove && !shell.ApplyChangesChoice(c) {
+		return nil
+	}
+	return shell.DeleteOrphans(ctx, orphans)
+}
+
+// deploymentStateIDs returns the identifiers of the resources recorded in the
+// Terraform states of the groups of the deployment, by group, and the names of
+// the Terraform groups whose states could not be read, since they were not
+// written to the deployment directory. Resources of those groups cannot be
+// told apart from orphans.
+func deploymentStateIDs(dc config.DeploymentConfig) (map[string][]string, []string, error) {
+	if err := shell.ConfigureProviderInstallation(deploymentRoot); err != nil {
+		return nil, nil, err
+	}
+	ids := map[string][]string{}
+	unread := []string{}
+	for _, g := range dc.Config.DeploymentGroups {
+		if g.Kind != config.TerraformKind {
+			continue
+		}
+		groupDir := filepath.Join(deploymentRoot, string(g.Name))
+		if isDir, _ := shell.DirInfo(groupDir); !isDir {
+			unread = append(unread, string(g.Name))
+			continue
+		}
+		tf, err := shell.ConfigureTerraform(groupDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ids[string(g.Name)], err = shell.StateResourceIDs(tf); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ids, unread, nil
+}
+
+func printInventory(resources []shell.InventoryResource) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tTYPE\tLOCATION\tCREATED\tOWNER\tGROUP")
+	for _, r := range resources {
+		group := r.Group
+		if group == "" {
+			group = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.ShortName(), r.AssetType, r.Location, r.Created, r.Owner, group)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/shell"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestDeploymentStateIDsUnread(c *C) {
+	defer func() { deploymentRoot = "" }()
+	deploymentRoot = c.MkDir()
+	c.Assert(os.Setenv(shell.PluginCacheEnv, c.MkDir()), IsNil)
+	defer os.Unsetenv(shell.PluginCacheEnv)
+
+	dc := config.DeploymentConfig{Config: config.Blueprint{
+		DeploymentGroups: []config.DeploymentGroup{
+			{Name: "network", Kind: config.TerraformKind},
+			{Name: "image", Kind: config.PackerKind},
+			{Name: "cluster", Kind: config.TerraformKind},
+		},
+	}}
+	ids, unread, err := deploymentStateIDs(dc)
+	c.Assert(err, IsNil)
+	c.Check(ids, DeepEquals, map[string][]string{})
+	c.Check(unread, DeepEquals, []string{"network", "cluster"})
+}
@@ -0,0 +1,141 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/shell"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{jobsListCmd, jobsLogsCmd, jobsWaitCmd} {
+		c.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+		c.MarkFlagDirname("artifacts")
+		jobsCmd.AddCommand(c)
+	}
+	jobsLogsCmd.Flags().BoolVarP(&followLogs, "follow", "f", false, "Keep printing the log until the job is no longer running")
+	jobsWaitCmd.Flags().DurationVar(&jobPollInterval, "interval", 10*time.Second, "Interval between checks of the job status")
+
+	rootCmd.AddCommand(jobsCmd)
+}
+
+var (
+	followLogs      bool
+	jobPollInterval time.Duration
+	jobsCmd         = &cobra.Command{
+		Use:   "jobs",
+		Short: "Inspect deployments running in the background.",
+		Long:  "Inspect deployments started with \"ghpc deploy --detach\".",
+	}
+	jobsListCmd = &cobra.Command{
+		Use:               "list DEPLOYMENT_DIRECTORY",
+		Short:             "List the background jobs of a deployment.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseJobsArgs,
+		RunE:              runJobsListCmd,
+		SilenceUsage:      true,
+	}
+	jobsLogsCmd = &cobra.Command{
+		Use:               "logs DEPLOYMENT_DIRECTORY JOB_ID",
+		Short:             "Print the output of a background job.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(2), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseJobsArgs,
+		RunE:              runJobsLogsCmd,
+		SilenceUsage:      true,
+	}
+	jobsWaitCmd = &cobra.Command{
+		Use:               "wait DEPLOYMENT_DIRECTORY JOB_ID",
+		Short:             "Wait for a background job to finish; fails if the job fails.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(2), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseJobsArgs,
+		RunE:              runJobsWaitCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func parseJobsArgs(cmd *cobra.Command, args []string) {
+	deploymentRoot = args[0]
+	artifactsDir = getArtifactsDir(deploymentRoot)
+}
+
+func runJobsListCmd(cmd *cobra.Command, args []string) error {
+	jobs, err := shell.ListJobs(artifactsDir)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSTARTED\tCOMMAND")
+	for _, j := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\tghpc %s\n",
+			j.ID, j.Status(), j.Started.Local().Format(time.RFC3339), strings.Join(j.Args, " "))
+	}
+	return w.Flush()
+}
+
+func runJobsLogsCmd(cmd *cobra.Command, args []string) error {
+	job, err := shell.FindJob(artifactsDir, args[1])
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(job.Log)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return err
+		}
+		if !followLogs {
+			return nil
+		}
+		if job, err = shell.FindJob(artifactsDir, job.ID); err != nil {
+			return err
+		}
+		if job.Status() != shell.JobRunning {
+			// print what was written before the job finished
+			_, err := io.Copy(os.Stdout, f)
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func runJobsWaitCmd(cmd *cobra.Command, args []string) error {
+	job, err := shell.WaitJob(artifactsDir, args[1], jobPollInterval)
+	if err != nil {
+		return err
+	}
+	switch job.Status() {
+	case shell.JobFailed:
+		return fmt.Errorf("job %s failed: %s; see %s", job.ID, job.Error, job.Log)
+	case shell.JobLost:
+		return fmt.Errorf("job %s exited without recording its result; see %s", job.ID, job.Log)
+	}
+	fmt.Printf("job %s succeeded\n", job.ID)
+	return nil
+}
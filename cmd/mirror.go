@@ -0,0 +1,79 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/shell"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mirrorCmd.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+	mirrorCmd.MarkFlagDirname("artifacts")
+	mirrorCmd.Flags().StringSliceVar(&mirrorPlatforms, "platform", nil,
+		"Platforms to mirror providers for, e.g. linux_amd64 (defaults to the current platform)")
+	rootCmd.AddCommand(mirrorCmd)
+}
+
+var (
+	mirrorPlatforms []string
+	mirrorCmd       = &cobra.Command{
+		Use:   "mirror-providers DEPLOYMENT_DIRECTORY",
+		Short: "Download the Terraform providers of a deployment into a local mirror.",
+		Long: "Download the Terraform providers of all deployment groups into the .ghpc/providers directory of the deployment. " +
+			"ghpc deploy and destroy install providers from this mirror, so that the deployment can be copied to sites without Internet access.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseMirrorArgs,
+		RunE:              runMirrorCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func parseMirrorArgs(cmd *cobra.Command, args []string) {
+	deploymentRoot = args[0]
+	artifactsDir = getArtifactsDir(deploymentRoot)
+}
+
+func runMirrorCmd(cmd *cobra.Command, args []string) error {
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return err
+	}
+	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
+		return err
+	}
+
+	mirror := shell.ProvidersMirrorDir(deploymentRoot)
+	for _, group := range dc.Config.DeploymentGroups {
+		if group.Kind != config.TerraformKind {
+			continue
+		}
+		tf, err := shell.ConfigureTerraform(filepath.Join(deploymentRoot, string(group.Name)))
+		if err != nil {
+			return err
+		}
+		if err := shell.MirrorProviders(tf, mirror, mirrorPlatforms); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Providers of %s are mirrored in %s\n", deploymentRoot, mirror)
+	return nil
+}
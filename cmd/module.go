@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/scaffold"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	moduleTestScaffoldCmd.Flags().BoolVar(&scaffoldTerratest, "terratest", false,
+		"Also generate a terratest Go test, in a Go module of its own")
+	moduleTestScaffoldCmd.Flags().BoolVar(&scaffoldForce, "force", false, "Overwrite existing files of the scaffold")
+	moduleCmd.AddCommand(moduleTestScaffoldCmd)
+	rootCmd.AddCommand(moduleCmd)
+}
+
+var (
+	scaffoldTerratest bool
+	scaffoldForce     bool
+	moduleCmd         = &cobra.Command{
+		Use:   "module",
+		Short: "Generate tests for the modules used by blueprints.",
+	}
+	moduleTestScaffoldCmd = &cobra.Command{
+		Use:   "test-scaffold SOURCE",
+		Short: "Generate a blueprint and tests exercising a Terraform module.",
+		Long: "Reads the inputs and outputs of the Terraform module in the local directory SOURCE and writes, " +
+			"to its tests directory, a minimal blueprint using the module and a `terraform test` file " +
+			"that plans and applies it. Required inputs are set to placeholders to be replaced by hand.",
+		Args:         cobra.ExactArgs(1),
+		RunE:         runModuleTestScaffoldCmd,
+		SilenceUsage: true,
+	}
+)
+
+func runModuleTestScaffoldCmd(cmd *cobra.Command, args []string) error {
+	source := filepath.Clean(args[0])
+	if fi, err := os.Stat(source); err != nil || !fi.IsDir() {
+		return fmt.Errorf("%s is not a local module directory; test-scaffold writes the tests into the module", args[0])
+	}
+	if !sourcereader.IsLocalPath(source) && !sourcereader.IsEmbeddedPath(source) {
+		source = "./" + source
+	}
+
+	info, err := modulereader.GetModuleInfo(source, config.TerraformKind.String())
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(source)
+	files, err := scaffold.Generate(source, name, info, scaffold.Options{Terratest: scaffoldTerratest})
+	if err != nil {
+		return err
+	}
+
+	if !scaffoldForce {
+		for _, f := range files {
+			p := filepath.Join(source, f.Path)
+			if _, err := os.Stat(p); err == nil {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", p)
+			}
+		}
+	}
+	for _, f := range files {
+		p := filepath.Join(source, f.Path)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, f.Content, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", p)
+	}
+	return nil
+}
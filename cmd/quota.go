@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"hpc-toolkit/pkg/quota"
+	"hpc-toolkit/pkg/sourcereader"
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	quotaRequestCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	quotaRequestCmd.Flags().StringVar(&blueprintName, "blueprint", "", blueprintNameDesc)
+	quotaRequestCmd.Flags().StringVar(&quotaJustification, "justification", "", "Justification of the quota increase requests")
+	quotaRequestCmd.Flags().StringVar(&quotaContactEmail, "contact-email", "", "Email address that Google Cloud contacts about the requests")
+	quotaRequestCmd.Flags().BoolVar(&fileQuotaRequests, "file", false,
+		"File the requests with the Cloud Quotas API rather than printing them")
+	quotaCmd.AddCommand(quotaRequestCmd)
+	rootCmd.AddCommand(quotaCmd)
+}
+
+var (
+	quotaJustification string
+	quotaContactEmail  string
+	fileQuotaRequests  bool
+	quotaCmd           = &cobra.Command{
+		Use:   "quota",
+		Short: "Manage the Compute Engine quotas needed by a blueprint.",
+	}
+	quotaRequestCmd = &cobra.Command{
+		Use:   "request BLUEPRINT_NAME",
+		Short: "Generate the quota increase requests needed to deploy the blueprint.",
+		Long: "Compares the regional CPU quotas of the projects of the blueprint with the VMs that its modules create " +
+			"and prints, as Cloud Quotas API quota preferences, the increases needed to create them in addition to " +
+			"the current usage. With --file the requests are filed.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: filterYaml,
+		RunE:              runQuotaRequestCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runQuotaRequestCmd(cmd *cobra.Command, args []string) error {
+	ctx, stop := interruptContext(cmd)
+	defer stop()
+	defer sourcereader.CleanupFetched()
+
+	// the requests are derived from the blueprint whether or not its
+	// validators, including test_compute_quotas, would pass
+	validationLevel = "IGNORE"
+	dc := expandOrDie(ctx, args[0])
+	opts := validators.ClientOptions(validators.WithImpersonation(ctx, dc.Config.ImpersonateServiceAccount))
+	shortfalls, err := quota.Shortfalls(ctx, dc.QuotaDemands(), opts...)
+	if err != nil {
+		return err
+	}
+	if len(shortfalls) == 0 {
+		log.Println("The quotas of the blueprint are sufficient, no increase is needed")
+		return nil
+	}
+
+	prefs := []quota.Preference{}
+	for _, s := range shortfalls {
+		prefs = append(prefs, s.Preference(quotaJustification, quotaContactEmail))
+	}
+	if !fileQuotaRequests {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(prefs)
+	}
+	for _, p := range prefs {
+		if err := p.File(ctx, opts...); err != nil {
+			return err
+		}
+		fmt.Printf("Requested a limit of %s for %s in %s: %s\n",
+			p.QuotaConfig.PreferredValue, p.QuotaID, p.Dimensions["region"], p.Name)
+	}
+	return nil
+}
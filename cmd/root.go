@@ -20,6 +20,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"hpc-toolkit/pkg/config"
 	"log"
 	"os"
 	"path/filepath"
@@ -39,8 +40,9 @@ var (
 )
 
 var (
-	annotation = make(map[string]string)
-	rootCmd    = &cobra.Command{
+	annotation  = make(map[string]string)
+	errorFormat string
+	rootCmd     = &cobra.Command{
 		Use:   "ghpc",
 		Short: "A blueprint and deployment engine for HPC clusters in GCP.",
 		Long: `gHPC provides a flexible and simple to use interface to accelerate
@@ -79,10 +81,14 @@ Built from '{{index .Annotations "branch"}}' branch.
 Commit info: {{index .Annotations "commitInfo"}}
 `)
 	}
+	config.ToolkitVersion = rootCmd.Version
 	return rootCmd.Execute()
 }
 
-func init() {}
+func init() {
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", "text",
+		"Format of the errors of importing, expanding and validating blueprints: text, or json with the error code")
+}
 
 // checkGitHashMismatch will compare the hash of the git repository vs the git
 // hash the ghpc binary was compiled against, if the git repository if found and
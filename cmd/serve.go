@@ -0,0 +1,333 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/shell"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// maxBlueprintSize limits the size of the blueprints sent to the server
+const maxBlueprintSize = 10 << 20
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddress, "address", "localhost:8080", "Address on which to listen for requests")
+	serveCmd.Flags().StringVar(&modulePolicyFile, "module-policy", "", modulePolicyDesc)
+	serveCmd.Flags().StringVar(&serveStartupScriptBucket, "startup-script-bucket", "",
+		"Cloud Storage bucket to which the server may upload startup-script runners, "+
+			"as requests name it in the "+config.StartupScriptBucketVar+" deployment variable")
+	rootCmd.AddCommand(serveCmd)
+}
+
+var (
+	serveAddress             string
+	serveStartupScriptBucket string
+	serveCmd                 = &cobra.Command{
+		Use:   "serve",
+		Short: "Expand, validate and create deployments over HTTP.",
+		Long: "Serves an HTTP API that expands and validates the blueprints sent in requests and returns the " +
+			"deployment directories that create would write as gzipped tarballs.",
+		Args:         cobra.NoArgs,
+		RunE:         runServeCmd,
+		SilenceUsage: true,
+	}
+)
+
+// serveMu serializes requests: expansion reads and caches modules in global
+// state and the logs of a request are captured by redirecting the logger
+var serveMu sync.Mutex
+
+// serveRequest holds the parameters of a request, given in its query string
+type serveRequest struct {
+	vars            []string
+	backendConfig   []string
+	validationLevel string
+	skipValidators  []string
+	// deploymentID labels the resources of deployments created by the request
+	deploymentID string
+}
+
+// serveError is the body of failed requests; log holds the messages logged
+// while the request was handled, such as failed validators
+type serveError struct {
+	Error string           `json:"error"`
+	Code  config.ErrorCode `json:"code,omitempty"`
+	Log   []string         `json:"log,omitempty"`
+}
+
+// serveValidation is the body of validate requests
+type serveValidation struct {
+	Valid bool     `json:"valid"`
+	Log   []string `json:"log,omitempty"`
+}
+
+func runServeCmd(cmd *cobra.Command, args []string) error {
+	ctx, stop := interruptContext(cmd)
+	defer stop()
+	srv := &http.Server{Addr: serveAddress, Handler: newServeMux()}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	log.Printf("listening on %s", serveAddress)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/expand", serveHandler(serveExpand))
+	mux.HandleFunc("/v1/validate", serveHandler(serveValidate))
+	mux.HandleFunc("/v1/create", serveHandler(serveCreate))
+	return mux
+}
+
+// serveHandler reads the blueprint and parameters of POST requests and calls
+// h with the logger writing to a buffer. Errors and panics are returned as
+// serveError with the captured log.
+func serveHandler(h func(w http.ResponseWriter, r *http.Request, bp []byte, sr serveRequest, logs *bytes.Buffer) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeServeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method), nil)
+			return
+		}
+		bp, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBlueprintSize))
+		if err != nil {
+			writeServeError(w, http.StatusBadRequest, fmt.Errorf("failed to read the blueprint: %w", err), nil)
+			return
+		}
+		q := r.URL.Query()
+		sr := serveRequest{
+			vars:            q["vars"],
+			backendConfig:   q["backend_config"],
+			validationLevel: q.Get("validation_level"),
+			skipValidators:  q["skip_validators"],
+		}
+		if sr.validationLevel == "" {
+			sr.validationLevel = "WARNING"
+		}
+
+		serveMu.Lock()
+		defer serveMu.Unlock()
+		var logs bytes.Buffer
+		log.SetOutput(io.MultiWriter(os.Stderr, &logs))
+		defer log.SetOutput(os.Stderr)
+		defer sourcereader.CleanupFetched()
+		defer func() {
+			if p := recover(); p != nil {
+				writeServeError(w, http.StatusInternalServerError, fmt.Errorf("%v", p), &logs)
+			}
+		}()
+		if err := h(w, r, bp, sr, &logs); err != nil {
+			writeServeError(w, http.StatusUnprocessableEntity, err, &logs)
+		}
+	}
+}
+
+func writeServeError(w http.ResponseWriter, status int, err error, logs *bytes.Buffer) {
+	body := serveError{Error: err.Error(), Code: config.CodeOf(err)}
+	if logs != nil {
+		body.Log = logLines(logs)
+	}
+	writeServeJSON(w, status, body)
+}
+
+func writeServeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func logLines(logs *bytes.Buffer) []string {
+	s := strings.TrimSpace(logs.String())
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// expandServeRequest expands a blueprint sent to the server as expandOrDie
+// expands the blueprint of the command line, returning errors rather than
+// exiting
+func expandServeRequest(ctx context.Context, bp []byte, sr serveRequest) (config.DeploymentConfig, error) {
+	if config.IsSopsEncrypted(bp) {
+		return config.DeploymentConfig{}, errors.New("blueprints encrypted with sops are not accepted by ghpc serve, " +
+			"since they would be decrypted with the credentials of the server")
+	}
+	dc, err := config.NewDeploymentConfigFromData("blueprint", bp, "")
+	if err != nil {
+		return dc, err
+	}
+	if len(dc.Config.Secrets) > 0 {
+		return dc, errors.New("blueprints with secrets are not accepted by ghpc serve, " +
+			"since they would be decrypted with the credentials of the server")
+	}
+	if dc.Config.HasExecValidators() {
+		return dc, errors.New("blueprints with exec validators are not accepted by ghpc serve, since they run commands on the server")
+	}
+	if dc.Config.HasFileFunctions() {
+		return dc, errors.New("blueprints that call file or templatefile are not accepted by ghpc serve, since they read files on the server")
+	}
+	if err := checkServeLocalPaths(dc.Config); err != nil {
+		return dc, err
+	}
+	if err := setCLIVariables(&dc.Config, sr.vars); err != nil {
+		return dc, fmt.Errorf("failed to set the variables: %w", err)
+	}
+	if err := checkServeStartupScriptBucket(dc.Config); err != nil {
+		return dc, err
+	}
+	if err := setBackendConfig(&dc.Config, sr.backendConfig); err != nil {
+		return dc, fmt.Errorf("failed to set the backend config: %w", err)
+	}
+	if err := applyUserConfigDefaults(&dc.Config, userConfig); err != nil {
+		return dc, fmt.Errorf("failed to apply the user configuration: %w", err)
+	}
+	if err := setValidationLevel(&dc.Config, sr.validationLevel); err != nil {
+		return dc, err
+	}
+	for _, v := range sr.skipValidators {
+		if err := dc.SkipValidator(v); err != nil {
+			return dc, err
+		}
+	}
+	if err := applyModulePolicies(&dc); err != nil {
+		return dc, err
+	}
+	dc.Config.GhpcVersion = GitCommitInfo
+	if sr.deploymentID != "" {
+		dc.Config.SetDeploymentID(sr.deploymentID)
+	}
+	if err := dc.ExpandConfig(ctx); err != nil {
+		return dc, err
+	}
+	// module aliases and variables may resolve to local paths on expansion
+	return dc, checkServeLocalPaths(dc.Config)
+}
+
+// checkServeLocalPaths refuses blueprints whose modules or startup-script
+// runners are local files, which would be read from the server
+func checkServeLocalPaths(bp config.Blueprint) error {
+	if paths := bp.LocalPaths(); len(paths) > 0 {
+		return fmt.Errorf("blueprints with local module or runner sources, such as %s, are not accepted by ghpc serve, "+
+			"since they are read from the server", paths[0])
+	}
+	return nil
+}
+
+// checkServeStartupScriptBucket refuses blueprints that name a bucket for
+// startup-script runners other than that of --startup-script-bucket, since
+// the server uploads the runners with its own credentials
+func checkServeStartupScriptBucket(bp config.Blueprint) error {
+	if !bp.Vars.Has(config.StartupScriptBucketVar) {
+		return nil
+	}
+	if serveStartupScriptBucket == "" {
+		return fmt.Errorf("deployment variable %s is not accepted by ghpc serve, since the server has no --startup-script-bucket",
+			config.StartupScriptBucketVar)
+	}
+	v, _ := bp.Vars.Get(config.StartupScriptBucketVar).Unmark()
+	if v.Type() != cty.String || !v.IsKnown() || v.IsNull() || v.AsString() != serveStartupScriptBucket {
+		return fmt.Errorf("deployment variable %s must be %q, the --startup-script-bucket of the server",
+			config.StartupScriptBucketVar, serveStartupScriptBucket)
+	}
+	return nil
+}
+
+// serveExpand returns the expanded blueprint as YAML
+func serveExpand(w http.ResponseWriter, r *http.Request, bp []byte, sr serveRequest, logs *bytes.Buffer) error {
+	dc, err := expandServeRequest(r.Context(), bp, sr)
+	if err != nil {
+		return err
+	}
+	out, err := dc.MarshalBlueprint(config.ExportOptions{})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+	return nil
+}
+
+// serveValidate returns whether the blueprint expands and passes its
+// validators, with the log of failed validators
+func serveValidate(w http.ResponseWriter, r *http.Request, bp []byte, sr serveRequest, logs *bytes.Buffer) error {
+	_, err := expandServeRequest(r.Context(), bp, sr)
+	if err != nil {
+		log.Print(err)
+	}
+	writeServeJSON(w, http.StatusOK, serveValidation{Valid: err == nil, Log: logLines(logs)})
+	return nil
+}
+
+// serveCreate returns the deployment directory as a gzipped tarball
+func serveCreate(w http.ResponseWriter, r *http.Request, bp []byte, sr serveRequest, logs *bytes.Buffer) error {
+	dir, err := os.MkdirTemp("", "ghpc-serve-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	sr.deploymentID = config.NewDeploymentID()
+	dc, err := expandServeRequest(r.Context(), bp, sr)
+	if err != nil {
+		return err
+	}
+	scripts, err := dc.HostStartupScripts()
+	if err != nil {
+		return err
+	}
+	if err := modulewriter.UploadStartupScripts(r.Context(), scripts); err != nil {
+		return err
+	}
+	outDir := filepath.Join(dir, "out")
+	if err := modulewriter.WriteDeployment(dc, outDir, false); err != nil {
+		return err
+	}
+	name, err := dc.Config.DeploymentName()
+	if err != nil {
+		return err
+	}
+	// the tarball is buffered so that a failure is reported as an error
+	// rather than as a truncated response
+	var buf bytes.Buffer
+	if err := shell.WriteArchive(filepath.Join(outDir, name), &buf); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".tgz"))
+	w.Write(buf.Bytes())
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"hpc-toolkit/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestServeMethodNotAllowed(c *C) {
+	rec := httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/expand", nil))
+	c.Check(rec.Code, Equals, http.StatusMethodNotAllowed)
+	c.Check(rec.Header().Get("Allow"), Equals, http.MethodPost)
+}
+
+func (s *MySuite) TestServeInvalidBlueprint(c *C) {
+	bp := "blueprint_name: [not a name\n"
+
+	rec := httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/expand", strings.NewReader(bp)))
+	c.Check(rec.Code, Equals, http.StatusUnprocessableEntity)
+	var e serveError
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &e), IsNil)
+	c.Check(e.Error, Not(Equals), "")
+	c.Check(e.Code, Equals, config.ErrCodeYamlUnmarshal)
+
+	rec = httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/validate", strings.NewReader(bp)))
+	c.Check(rec.Code, Equals, http.StatusOK)
+	var v serveValidation
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &v), IsNil)
+	c.Check(v.Valid, Equals, false)
+	c.Check(v.Log, Not(HasLen), 0)
+}
+
+func (s *MySuite) TestServeRejectsExecValidators(c *C) {
+	bp := `blueprint_name: exec
+vars:
+  deployment_name: exec
+validators:
+- validator: exec
+  inputs:
+    command: "true"
+deployment_groups: []
+`
+	rec := httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/expand", strings.NewReader(bp)))
+	c.Check(rec.Code, Equals, http.StatusUnprocessableEntity)
+	var e serveError
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &e), IsNil)
+	c.Check(e.Error, Matches, ".*exec validators are not accepted.*")
+}
+
+func (s *MySuite) TestServeRejectsFileFunctions(c *C) {
+	bp := `blueprint_name: files
+vars:
+  deployment_name: files
+  motd: $(file("../../etc/passwd"))
+deployment_groups: []
+`
+	rec := httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/expand", strings.NewReader(bp)))
+	c.Check(rec.Code, Equals, http.StatusUnprocessableEntity)
+	var e serveError
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &e), IsNil)
+	c.Check(e.Error, Matches, ".*call file or templatefile are not accepted.*")
+}
+
+func (s *MySuite) TestServeRejectsLocalPaths(c *C) {
+	for _, src := range []string{
+		"source: /etc",
+		"source: ./modules/network/vpc",
+		`source: modules/scripts/startup-script
+    settings:
+      runners:
+      - type: shell
+        destination: passwd
+        source: /etc/passwd`,
+	} {
+		bp := `blueprint_name: local
+vars:
+  deployment_name: local
+deployment_groups:
+- group: primary
+  modules:
+  - id: local
+    ` + src + "\n"
+		rec := httptest.NewRecorder()
+		newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/create", strings.NewReader(bp)))
+		c.Check(rec.Code, Equals, http.StatusUnprocessableEntity)
+		var e serveError
+		c.Assert(json.Unmarshal(rec.Body.Bytes(), &e), IsNil)
+		c.Check(e.Error, Matches, ".*local module or runner sources.*are not accepted.*")
+	}
+}
+
+func (s *MySuite) TestServeRejectsSecrets(c *C) {
+	for _, bp := range []string{
+		`blueprint_name: secrets
+vars:
+  deployment_name: secrets
+secrets:
+- file: /home/someone/prod.yaml
+  vars: [db_password]
+deployment_groups: []
+`,
+		`blueprint_name: sops
+vars:
+  deployment_name: sops
+  db_password: ENC[AES256_GCM,data:abc=,iv:def=,tag:ghi=,type:str]
+deployment_groups: []
+sops:
+  gcp_kms:
+  - resource_id: projects/p/locations/global/keyRings/r/cryptoKeys/k
+`,
+	} {
+		rec := httptest.NewRecorder()
+		newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/create", strings.NewReader(bp)))
+		c.Check(rec.Code, Equals, http.StatusUnprocessableEntity)
+		var e serveError
+		c.Assert(json.Unmarshal(rec.Body.Bytes(), &e), IsNil)
+		c.Check(e.Error, Matches, "(?s).*(secrets|sops).*", Commentf(bp))
+	}
+}
+
+func (s *MySuite) TestServeStartupScriptBucket(c *C) {
+	defer func(b string) { serveStartupScriptBucket = b }(serveStartupScriptBucket)
+	bp := func(bucket string) config.Blueprint {
+		return config.Blueprint{Vars: config.NewDict(map[string]cty.Value{
+			config.StartupScriptBucketVar: cty.StringVal(bucket),
+		})}
+	}
+
+	serveStartupScriptBucket = ""
+	c.Check(checkServeStartupScriptBucket(config.Blueprint{}), IsNil)
+	c.Check(checkServeStartupScriptBucket(bp("gs://mine")), ErrorMatches, ".*has no --startup-script-bucket")
+
+	serveStartupScriptBucket = "gs://scripts"
+	c.Check(checkServeStartupScriptBucket(bp("gs://scripts")), IsNil)
+	c.Check(checkServeStartupScriptBucket(bp("gs://mine")), ErrorMatches, `.*must be "gs://scripts".*`)
+}
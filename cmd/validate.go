@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	validateCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	validateCmd.Flags().StringVar(&blueprintName, "blueprint", "", blueprintNameDesc)
+	validateCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
+	validateCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
+	validateCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	validateCmd.Flags().DurationVar(&validationTimeout, "validation-timeout", 0, validationTimeoutDesc)
+	validateCmd.Flags().StringVar(&modulePolicyFile, "module-policy", "", modulePolicyDesc)
+	validateCmd.Flags().BoolVar(&debugExpansion, "debug-expansion", false, debugExpansionDesc)
+	validateCmd.Flags().BoolVar(&selectZone, "select-zone", false, selectZoneDesc)
+	validateCmd.Flags().BoolVar(&explainValidators, "explain", false,
+		"Print why each validator runs, the values of its inputs and the API calls it would make, without running it.")
+	validateCmd.Flags().BoolVar(&explainUse, "explain-use", false,
+		"Print which inputs of each module were set from which outputs of the modules in its use list.")
+	rootCmd.AddCommand(validateCmd)
+}
+
+var (
+	explainValidators bool
+	explainUse        bool
+	validateCmd       = &cobra.Command{
+		Use:               "validate BLUEPRINT_NAME",
+		Short:             "Validate the Environment Blueprint.",
+		Long:              "Expands the Environment Blueprint and runs its validators, without writing the deployment.",
+		Run:               runValidateCmd,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: filterYaml,
+	}
+)
+
+func runValidateCmd(cmd *cobra.Command, args []string) {
+	ctx, stop := interruptContext(cmd)
+	defer stop()
+	defer sourcereader.CleanupFetched()
+	if !explainValidators {
+		dc := expandOrDie(ctx, args[0])
+		if explainUse {
+			writeUseReports(os.Stdout, dc.UseReports())
+		}
+		fmt.Println("Validation of the blueprint completed.")
+		return
+	}
+
+	// validators are explained rather than run, so that no API is called
+	level := validationLevel
+	validationLevel = "IGNORE"
+	dc := expandOrDie(ctx, args[0])
+	fmt.Printf("Validators of %s, which run at validation level %s:\n", args[0], level)
+	if explainUse {
+		writeUseReports(os.Stdout, dc.UseReports())
+	}
+	writeValidatorExplanations(os.Stdout, dc.ExplainValidators())
+}
+
+func writeUseReports(w io.Writer, rs []config.UseReport) {
+	for _, r := range rs {
+		fmt.Fprintf(w, "module %s uses %s, matching outputs by %s\n", r.Module, r.Used, r.Mode)
+		if len(r.Matches) == 0 {
+			fmt.Fprintln(w, "  no output matches an input")
+		}
+		for _, m := range r.Matches {
+			from := fmt.Sprintf("%s.%s", r.Used, m.Output)
+			if m.Mapped {
+				from = fmt.Sprintf("%s (mapped)", m.Output)
+			}
+			if m.Skipped != "" {
+				fmt.Fprintf(w, "  %s: not set from %s, %s\n", m.Input, from, m.Skipped)
+			} else {
+				fmt.Fprintf(w, "  %s = %s\n", m.Input, from)
+			}
+		}
+	}
+}
+
+func writeValidatorExplanations(w io.Writer, es []config.ValidatorExplanation) {
+	for _, e := range es {
+		fmt.Fprintf(w, "\n%s\n", e.Validator)
+		if e.Reason != "" {
+			fmt.Fprintf(w, "  added by default: %s\n", e.Reason)
+		} else {
+			fmt.Fprintln(w, "  configured in the blueprint")
+		}
+		if e.Skipped {
+			fmt.Fprintln(w, "  skipped")
+			continue
+		}
+		if len(e.Inputs) > 0 {
+			fmt.Fprintln(w, "  inputs:")
+		}
+		for _, in := range e.Inputs {
+			if in.Expression != "" {
+				fmt.Fprintf(w, "    %s: %s = %s\n", in.Name, in.Expression, in.Value)
+			} else {
+				fmt.Fprintf(w, "    %s: %s\n", in.Name, in.Value)
+			}
+		}
+		if len(e.APICalls) == 0 {
+			fmt.Fprintln(w, "  API calls: none, the blueprint alone is inspected")
+			continue
+		}
+		fmt.Fprintf(w, "  API calls:\n    %s\n", strings.Join(e.APICalls, "\n    "))
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/sourcereader"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	vendorCmd.Flags().StringVar(&vendorDir, "dir", "vendor/modules",
+		"Directory to copy the repositories of remote module sources to, relative to the working directory")
+	vendorCmd.Flags().StringVarP(&vendorOut, "out", "o", "", "Blueprint file to write (defaults to rewriting BLUEPRINT_NAME)")
+	rootCmd.AddCommand(vendorCmd)
+}
+
+var (
+	vendorDir string
+	vendorOut string
+	vendorCmd = &cobra.Command{
+		Use:   "vendor BLUEPRINT_NAME",
+		Short: "Copy the remote module sources of a blueprint into a local directory.",
+		Long: "Copies the git repositories of the remote module sources of a blueprint, at the refs they select, into a local directory, " +
+			"vendor/modules by default, and rewrites the blueprint to use the copies, so that it can be expanded and deployed without network access. " +
+			"Local sources are relative to the working directory, so run ghpc vendor from the directory that ghpc create runs in.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: filterYaml,
+		RunE:              runVendorCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runVendorCmd(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	defer sourcereader.CleanupFetched()
+
+	out, err := config.VendorBlueprint(src, func(source string, hash string) (string, string, error) {
+		return sourcereader.VendorSource(context.Background(), source, hash, vendorDir)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	dst := vendorOut
+	if dst == "" {
+		dst = path
+	}
+	if dst == path && bytes.Equal(src, out) {
+		fmt.Printf("%s has no remote module sources to vendor\n", path)
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, out, info.Mode().Perm()); err != nil {
+		return err
+	}
+	fmt.Printf("Module sources of %s are vendored in %s and %s uses them\n", path, vendorDir, dst)
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/modulewriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify DEPLOYMENT_DIRECTORY",
+	Short: "Check that a deployment directory has not been modified since it was created.",
+	Long: "Compare the files of a deployment directory with the hashes recorded by \"ghpc create\" and report the files " +
+		"that were modified, removed or added since. Files written by deploying, such as Terraform state, are not compared.",
+	Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+	ValidArgsFunction: matchDirs,
+	RunE:              runVerifyCmd,
+	SilenceUsage:      true,
+}
+
+func runVerifyCmd(cmd *cobra.Command, args []string) error {
+	diff, err := modulewriter.VerifyDeployment(args[0])
+	if err != nil {
+		return err
+	}
+	if diff.Empty() {
+		fmt.Printf("Deployment %s matches its manifest\n", args[0])
+		return nil
+	}
+	for _, f := range diff.Modified {
+		fmt.Printf("modified: %s\n", f)
+	}
+	for _, f := range diff.Missing {
+		fmt.Printf("missing:  %s\n", f)
+	}
+	for _, f := range diff.Added {
+		fmt.Printf("added:    %s\n", f)
+	}
+	return fmt.Errorf("deployment %s diverges from its manifest %s", args[0], modulewriter.ManifestPath(args[0]))
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	versionCmd.Flags().BoolVar(&checkUpdate, "check-update", false,
+		"Look up the latest release of ghpc on GitHub and tell whether it is newer than this one")
+	rootCmd.AddCommand(versionCmd)
+}
+
+// latestReleaseURL is the GitHub API endpoint of the latest release of the
+// toolkit
+var latestReleaseURL = "https://api.github.com/repos/GoogleCloudPlatform/hpc-toolkit/releases/latest"
+
+// updateCheckTimeout bounds the lookup of the latest release
+const updateCheckTimeout = 10 * time.Second
+
+var (
+	checkUpdate bool
+	versionCmd  = &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of ghpc.",
+		Long: "Print the version of ghpc, as --version does. With --check-update, also tell whether a newer " +
+			"release is available; ghpc never looks for updates unless asked.",
+		Args:         cobra.NoArgs,
+		RunE:         runVersionCmd,
+		SilenceUsage: true,
+	}
+)
+
+// release is the subset of a GitHub release read by the update check
+type release struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+}
+
+func runVersionCmd(cmd *cobra.Command, args []string) error {
+	t, err := template.New("version").Parse(rootCmd.VersionTemplate())
+	if err != nil {
+		return err
+	}
+	if err := t.Execute(cmd.OutOrStdout(), rootCmd); err != nil {
+		return err
+	}
+	if !checkUpdate {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), updateCheckTimeout)
+	defer cancel()
+	latest, err := latestRelease(ctx, latestReleaseURL)
+	if err != nil {
+		return fmt.Errorf("could not look up the latest release of ghpc: %w", err)
+	}
+	newer, err := isNewerRelease(rootCmd.Version, latest.TagName)
+	if err != nil {
+		return err
+	}
+	if newer {
+		fmt.Fprintf(cmd.OutOrStdout(), "ghpc %s is available: %s\n", latest.TagName, latest.HTMLURL)
+	} else {
+		fmt.Fprintln(cmd.OutOrStdout(), "ghpc is up to date")
+	}
+	return nil
+}
+
+// latestRelease reads the latest release from the GitHub API
+func latestRelease(ctx context.Context, u string) (release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return release{}, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return release{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return release{}, fmt.Errorf("failed to read %s: %s", u, resp.Status)
+	}
+	var r release
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return release{}, fmt.Errorf("failed to read %s: %w", u, err)
+	}
+	return r, nil
+}
+
+// isNewerRelease returns true if the tag of a release names a newer version
+// than current
+func isNewerRelease(current string, tag string) (bool, error) {
+	cv, err := version.NewVersion(current)
+	if err != nil {
+		return false, fmt.Errorf("invalid version %q of ghpc: %w", current, err)
+	}
+	tv, err := version.NewVersion(tag)
+	if err != nil {
+		return false, fmt.Errorf("invalid version %q of the latest release: %w", tag, err)
+	}
+	return tv.GreaterThan(cv), nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestLatestRelease(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/releases/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"tag_name": "v1.25.0", "html_url": "https://github.com/GoogleCloudPlatform/hpc-toolkit/releases/tag/v1.25.0"}`)
+	}))
+	defer srv.Close()
+
+	r, err := latestRelease(context.Background(), srv.URL+"/releases/latest")
+	c.Assert(err, IsNil)
+	c.Check(r, DeepEquals, release{
+		TagName: "v1.25.0",
+		HTMLURL: "https://github.com/GoogleCloudPlatform/hpc-toolkit/releases/tag/v1.25.0",
+	})
+
+	_, err = latestRelease(context.Background(), srv.URL+"/missing")
+	c.Check(err, ErrorMatches, ".*404 Not Found")
+}
+
+func (s *MySuite) TestIsNewerRelease(c *C) {
+	check := func(current, tag string) bool {
+		newer, err := isNewerRelease(current, tag)
+		c.Assert(err, IsNil)
+		return newer
+	}
+	c.Check(check("v1.19.1", "v1.25.0"), Equals, true)
+	c.Check(check("v1.19.1", "v1.19.1"), Equals, false)
+	c.Check(check("v1.19.1", "v1.9.0"), Equals, false)
+
+	_, err := isNewerRelease("v1.19.1", "nightly")
+	c.Check(err, ErrorMatches, `invalid version "nightly" of the latest release.*`)
+}
@@ -18,6 +18,7 @@ package main
 import (
 	"embed"
 	"hpc-toolkit/cmd"
+	"hpc-toolkit/pkg/catalog"
 	"hpc-toolkit/pkg/sourcereader"
 	"os"
 )
@@ -25,6 +26,9 @@ import (
 //go:embed modules community/modules
 var moduleFS embed.FS
 
+//go:embed examples community/examples
+var exampleFS embed.FS
+
 // Git references when use Makefile
 var gitTagVersion string
 var gitBranch string
@@ -34,6 +38,7 @@ var gitInitialHash string
 
 func main() {
 	sourcereader.ModuleFS = moduleFS
+	catalog.ExampleFS = exampleFS
 	cmd.GitTagVersion = gitTagVersion
 	cmd.GitBranch = gitBranch
 	cmd.GitCommitInfo = gitCommitInfo
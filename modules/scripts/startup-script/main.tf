@@ -77,13 +77,18 @@ locals {
   user_provided_bucket_name = try(regex(local.bucket_regex, local.gcs_bucket_path_trimmed)[0], null)
   storage_bucket_name       = coalesce(one(google_storage_bucket.configs_bucket[*].name), local.user_provided_bucket_name)
 
+  # runners whose source is already in Cloud Storage are downloaded from there
+  # rather than uploaded to the startup-script bucket
+  local_runners = [for runner in local.runners : runner if !can(regex(local.bucket_regex, runner["source"]))]
+
   load_runners = templatefile(
     "${path.module}/templates/startup-script-custom.tpl",
     {
-      bucket = local.storage_bucket_name,
       runners = [
         for runner in local.runners : {
-          object      = google_storage_bucket_object.scripts[basename(runner["destination"])].output_name
+          url = can(regex(local.bucket_regex, runner["source"])) ? runner["source"] : (
+            "gs://${local.storage_bucket_name}/${google_storage_bucket_object.scripts[basename(runner["destination"])].output_name}"
+          )
           type        = runner["type"]
           destination = runner["destination"]
           args        = contains(keys(runner), "args") ? runner["args"] : ""
@@ -107,7 +112,7 @@ locals {
   # Final content output to the user
   stdlib = join("", local.stdlib_list)
 
-  runners_map = { for runner in local.runners :
+  runners_map = { for runner in local.local_runners :
     basename(runner["destination"]) => {
       content = lookup(runner, "content", null)
       source  = lookup(runner, "source", null)
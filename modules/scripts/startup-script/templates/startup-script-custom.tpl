@@ -14,7 +14,7 @@ stdlib::run_playbook() {
 stdlib::runner() {
 
   type=$1
-  object=$2
+  url=$2
   destination=$3
   tmpdir=$4
   args=$5
@@ -26,18 +26,18 @@ stdlib::runner() {
     destpath=$tmpdir
   fi
 
-  stdlib::get_from_bucket -u "gs://${bucket}/$object" -d "$destpath" -f "$filename"
+  stdlib::get_from_bucket -u "$url" -d "$destpath" -f "$filename"
 
-  stdlib::info "=== start executing runner: $object ==="
+  stdlib::info "=== start executing runner: $url ==="
   case "$1" in
     ansible-local) stdlib::run_playbook "$destpath/$filename" "$args";;
     shell) chmod u+x /$destpath/$filename && ./$destpath/$filename $args;;
   esac
   
   exit_code=$?
-  stdlib::info "=== $object finished with exit_code=$exit_code ==="
+  stdlib::info "=== $url finished with exit_code=$exit_code ==="
   if [ "$exit_code" -ne "0" ] ; then
-    stdlib::error "=== execution of $object failed, exiting ==="
+    stdlib::error "=== execution of $url failed, exiting ==="
     exit $exit_code
   fi
 }
@@ -48,7 +48,7 @@ stdlib::load_runners(){
   stdlib::debug "=== BEGIN Running runners ==="
 
   %{for r in runners ~}
-  stdlib::runner "${r.type}" "${r.object}" "${r.destination}" $${tmpdir} "${r.args}"
+  stdlib::runner "${r.type}" "${r.url}" "${r.destination}" $${tmpdir} "${r.args}"
   %{endfor ~}
 
   stdlib::debug "=== END Running runners ==="
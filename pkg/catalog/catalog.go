@@ -0,0 +1,201 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catalog indexes the example blueprints from which new blueprints
+// are scaffolded
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// ExampleFS contains the embedded example blueprints (./examples and
+// ./community/examples)
+var ExampleFS fs.FS
+
+// exampleDirs are the directories of ExampleFS searched for blueprints
+var exampleDirs = []string{"examples", "community/examples"}
+
+// Entry is a blueprint of the catalog, read from ExampleFS if Path is set and
+// from URL otherwise
+type Entry struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Path        string `yaml:"path,omitempty"`
+	URL         string `yaml:"url,omitempty"`
+}
+
+// Index lists the blueprints of the catalog; it is the format of remote
+// indexes
+type Index struct {
+	Blueprints []Entry `yaml:"blueprints"`
+}
+
+// Find returns the entry of a blueprint
+func (idx Index) Find(name string) (Entry, error) {
+	i := slices.IndexFunc(idx.Blueprints, func(e Entry) bool { return e.Name == name })
+	if i < 0 {
+		return Entry{}, fmt.Errorf("blueprint %q is not in the catalog, see \"ghpc catalog list\"", name)
+	}
+	return idx.Blueprints[i], nil
+}
+
+// merge adds the entries of o, which replace the entries of the same name
+func (idx Index) merge(o Index) Index {
+	res := Index{Blueprints: slices.Clone(idx.Blueprints)}
+	for _, e := range o.Blueprints {
+		if i := slices.IndexFunc(res.Blueprints, func(r Entry) bool { return r.Name == e.Name }); i >= 0 {
+			res.Blueprints[i] = e
+		} else {
+			res.Blueprints = append(res.Blueprints, e)
+		}
+	}
+	slices.SortFunc(res.Blueprints, func(a, b Entry) bool { return a.Name < b.Name })
+	return res
+}
+
+// Load returns the index of the embedded examples merged with the remote
+// index at indexURL, if it is not empty
+func Load(ctx context.Context, indexURL string) (Index, error) {
+	idx, err := embeddedIndex(ExampleFS)
+	if err != nil {
+		return Index{}, err
+	}
+	if indexURL == "" {
+		return idx, nil
+	}
+	remote, err := fetchIndex(ctx, indexURL)
+	if err != nil {
+		return Index{}, err
+	}
+	return idx.merge(remote), nil
+}
+
+// embeddedIndex lists the blueprints of the example directories of fsys,
+// named after their files
+func embeddedIndex(fsys fs.FS) (Index, error) {
+	idx := Index{}
+	if fsys == nil {
+		return idx, nil
+	}
+	for _, dir := range exampleDirs {
+		err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || path.Ext(p) != ".yaml" {
+				return err
+			}
+			data, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			idx.Blueprints = append(idx.Blueprints, Entry{
+				Name:        strings.TrimSuffix(path.Base(p), ".yaml"),
+				Description: description(data),
+				Path:        p,
+			})
+			return nil
+		})
+		if err != nil {
+			return Index{}, err
+		}
+	}
+	return idx.merge(Index{}), nil
+}
+
+// description returns the first comment of a blueprint following its
+// license header, joined into a single line
+func description(data []byte) string {
+	lines := []string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	afterHeader := !bytes.Contains(data, []byte("\n---"))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case !afterHeader:
+			afterHeader = line == "---"
+		case strings.HasPrefix(line, "#"):
+			lines = append(lines, strings.TrimSpace(strings.TrimLeft(line, "#")))
+		case len(lines) > 0 || line != "":
+			return strings.Join(lines, " ")
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// fetchIndex reads a remote index; relative URLs of its entries are resolved
+// against the URL of the index
+func fetchIndex(ctx context.Context, indexURL string) (Index, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return Index{}, fmt.Errorf("invalid catalog index URL %q: %w", indexURL, err)
+	}
+	data, err := fetch(ctx, indexURL)
+	if err != nil {
+		return Index{}, err
+	}
+	var idx Index
+	if err := yaml.Unmarshal(data, &idx); err != nil {
+		return Index{}, fmt.Errorf("failed to decode catalog index %s: %w", indexURL, err)
+	}
+	for i, e := range idx.Blueprints {
+		if e.Name == "" || e.URL == "" {
+			return Index{}, fmt.Errorf("catalog index %s: blueprint %d must have a name and a url", indexURL, i)
+		}
+		u, err := base.Parse(e.URL)
+		if err != nil {
+			return Index{}, fmt.Errorf("catalog index %s: blueprint %s has an invalid url: %w", indexURL, e.Name, err)
+		}
+		idx.Blueprints[i].URL = u.String()
+		idx.Blueprints[i].Path = ""
+	}
+	return idx, nil
+}
+
+// Read returns the blueprint of an entry
+func (e Entry) Read(ctx context.Context) ([]byte, error) {
+	if e.Path != "" {
+		if ExampleFS == nil {
+			return nil, fmt.Errorf("blueprint %s is not embedded in this build of ghpc", e.Name)
+		}
+		return fs.ReadFile(ExampleFS, e.Path)
+	}
+	return fetch(ctx, e.URL)
+}
+
+func fetch(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package catalog
+
+import (
+	"testing"
+	"testing/fstest"
+
+	. "gopkg.in/check.v1"
+)
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+const license = `# Copyright 2023 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+
+---
+`
+
+func (s *MySuite) TestDescription(c *C) {
+	c.Check(description([]byte(license+`
+# This blueprint provisions a cluster
+# for many short jobs.
+
+blueprint_name: htc
+`)), Equals, "This blueprint provisions a cluster for many short jobs.")
+	c.Check(description([]byte(license+"\nblueprint_name: plain\n# not a description\n")), Equals, "")
+	c.Check(description([]byte("# no header\nblueprint_name: x\n")), Equals, "no header")
+}
+
+func (s *MySuite) TestEmbeddedIndex(c *C) {
+	fsys := fstest.MapFS{
+		"examples/hpc-slurm.yaml":                {Data: []byte(license + "\n# Slurm cluster\nblueprint_name: a\n")},
+		"examples/README.md":                     {Data: []byte("# Examples")},
+		"community/examples/intel/pfs-daos.yaml": {Data: []byte("blueprint_name: b\n")},
+		"community/examples/htc-htcondor.yaml":   {Data: []byte("blueprint_name: c\n")},
+		"community/modules/not-an-example.yaml":  {Data: []byte("blueprint_name: d\n")},
+	}
+	idx, err := embeddedIndex(fsys)
+	c.Assert(err, IsNil)
+	c.Check(idx.Blueprints, DeepEquals, []Entry{
+		{Name: "hpc-slurm", Description: "Slurm cluster", Path: "examples/hpc-slurm.yaml"},
+		{Name: "htc-htcondor", Path: "community/examples/htc-htcondor.yaml"},
+		{Name: "pfs-daos", Path: "community/examples/intel/pfs-daos.yaml"},
+	})
+
+	// remote entries replace embedded entries of the same name
+	merged := idx.merge(Index{Blueprints: []Entry{
+		{Name: "hpc-slurm", URL: "https://example.com/hpc-slurm.yaml"},
+		{Name: "a-site", URL: "https://example.com/a-site.yaml"},
+	}})
+	c.Check(merged.Blueprints, HasLen, 4)
+	c.Check(merged.Blueprints[0].Name, Equals, "a-site")
+	e, err := merged.Find("hpc-slurm")
+	c.Assert(err, IsNil)
+	c.Check(e.URL, Equals, "https://example.com/hpc-slurm.yaml")
+	_, err = merged.Find("missing")
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestSetVars(c *C) {
+	bp := []byte(license + `
+blueprint_name: hpc
+
+vars:
+  project_id:  ## Set GCP Project ID Here ##
+  deployment_name: hpc-example # name of the deployment
+  region: us-central1
+`)
+	vars, err := Vars(bp)
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, []Var{
+		{Name: "project_id", Hint: "Set GCP Project ID Here"},
+		{Name: "deployment_name", Value: "hpc-example", Hint: "name of the deployment"},
+		{Name: "region", Value: "us-central1"},
+	})
+
+	out, err := SetVars(bp, map[string]string{
+		"project_id":      "my-project",
+		"deployment_name": "demo",
+		"zones":           "[us-central1-a, us-central1-b]",
+	})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, license+`
+blueprint_name: hpc
+
+vars:
+  project_id: my-project
+  deployment_name: demo # name of the deployment
+  region: us-central1
+  zones: [us-central1-a, us-central1-b]
+`)
+
+	// blocks are replaced by a single line
+	out, err = SetVars([]byte("vars:\n  labels:\n    a: b\n  region: r\nx: 1\n"), map[string]string{"labels": "{c: d}"})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "vars:\n  labels: {c: d}\n  region: r\nx: 1\n")
+
+	out, err = SetVars([]byte("blueprint_name: hpc\n"), map[string]string{"region": "r"})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "blueprint_name: hpc\nvars:\n  region: r\n")
+
+	_, err = SetVars(bp, map[string]string{"region": "[unterminated"})
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// Var is a deployment variable of a blueprint; Value is empty unless it is
+// set to a scalar
+type Var struct {
+	Name  string
+	Value string
+	// Hint is the comment next to the value, e.g. "Set GCP Project ID Here"
+	Hint string
+}
+
+// varsNode returns the mapping of the deployment variables of a blueprint
+func varsNode(doc *yaml.Node) (*yaml.Node, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("blueprint is not a YAML mapping")
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "vars" {
+			if root.Content[i+1].Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("vars of the blueprint is not a mapping")
+			}
+			return root.Content[i+1], nil
+		}
+	}
+	vars := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "vars"}, vars)
+	return vars, nil
+}
+
+// Vars returns the deployment variables of a blueprint in order
+func Vars(data []byte) ([]Var, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	vars, err := varsNode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	res := []Var{}
+	for i := 0; i+1 < len(vars.Content); i += 2 {
+		k, v := vars.Content[i], vars.Content[i+1]
+		// the comment of a variable without a value belongs to its key
+		r := Var{Name: k.Value, Hint: strings.Trim(v.LineComment+k.LineComment, "# ")}
+		if v.Kind == yaml.ScalarNode && v.Tag != "!!null" {
+			r.Value = v.Value
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// SetVars sets deployment variables of a blueprint. Values are YAML, so that
+// numbers, lists and maps keep their type. Only the lines of the variables
+// that are set are rewritten, which keeps the layout and comments of the
+// blueprint; the hint of a variable that had no value is removed.
+func SetVars(data []byte, values map[string]string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	vars, err := varsNode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if vars.Line == 0 { // the blueprint has no vars
+		if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+			lines = append(lines, "\n")
+		}
+		lines = append(lines, "vars:\n")
+	}
+
+	// lines are replaced from the last variable up, so that the numbers of
+	// the lines of the preceding variables are unchanged
+	set := map[string]bool{}
+	for i := len(vars.Content) - 2; i >= 0; i -= 2 {
+		k, v := vars.Content[i], vars.Content[i+1]
+		value, ok := values[k.Value]
+		if !ok {
+			continue
+		}
+		text, err := valueText(k.Value, value)
+		if err != nil {
+			return nil, err
+		}
+		comment := ""
+		if v.Tag != "!!null" && v.LineComment != "" && lastLine(v) == v.Line {
+			comment = " " + v.LineComment
+		}
+		line := fmt.Sprintf("%s%s: %s%s\n", strings.Repeat(" ", k.Column-1), k.Value, text, comment)
+		lines = append(lines[:k.Line-1], append([]string{line}, lines[lastLine(v):]...)...)
+		set[k.Value] = true
+	}
+
+	// other variables are added after the last one
+	indent, at := "  ", len(lines)
+	if n := len(vars.Content); n > 0 {
+		indent = strings.Repeat(" ", vars.Content[0].Column-1)
+		at = lastLine(vars.Content[n-1])
+	}
+	names := maps.Keys(values)
+	slices.Sort(names)
+	added := []string{}
+	for _, name := range names {
+		if set[name] {
+			continue
+		}
+		text, err := valueText(name, values[name])
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, fmt.Sprintf("%s%s: %s\n", indent, name, text))
+	}
+	lines = append(lines[:at], append(added, lines[at:]...)...)
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// lastLine returns the last line of a node and its descendants
+func lastLine(n *yaml.Node) int {
+	last := n.Line
+	for _, c := range n.Content {
+		if l := lastLine(c); l > last {
+			last = l
+		}
+	}
+	return last
+}
+
+// valueText returns a YAML value on a single line
+func valueText(name string, value string) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return "", fmt.Errorf("invalid value of %s: %w", name, err)
+	}
+	if len(doc.Content) == 0 {
+		return "null", nil
+	}
+	n := doc.Content[0]
+	n.Style |= yaml.FlowStyle
+	n.HeadComment, n.LineComment, n.FootComment = "", "", ""
+	out, err := yaml.Marshal(n)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
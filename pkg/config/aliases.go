@@ -0,0 +1,53 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// moduleAliasName matches the names of module aliases, which have no "/" or
+// "." so that they cannot be mistaken for module sources
+var moduleAliasName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// resolveModuleAliases replaces the sources of modules that name an alias of
+// module_aliases with the source of the alias, so that the versions of the
+// modules of a blueprint are set in a single place
+func (bp *Blueprint) resolveModuleAliases() error {
+	names := maps.Keys(bp.ModuleAliases)
+	slices.Sort(names)
+	for _, name := range names {
+		source := bp.ModuleAliases[name]
+		if !moduleAliasName.MatchString(name) {
+			return fmt.Errorf("module alias %q: the names of aliases must start with a letter and have only letters, digits, dashes and underscores", name)
+		}
+		if source == "" {
+			return fmt.Errorf("module alias %s: %s", name, errorMessages["emptySource"])
+		}
+		if _, ok := bp.ModuleAliases[source]; ok {
+			return fmt.Errorf("module alias %s: aliases must name module sources rather than other aliases, got %s", name, source)
+		}
+	}
+	return bp.WalkModules(func(m *Module) error {
+		if source, ok := bp.ModuleAliases[m.Source]; ok {
+			m.Source = source
+		}
+		return nil
+	})
+}
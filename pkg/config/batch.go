@@ -0,0 +1,50 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"hpc-toolkit/pkg/validators"
+)
+
+// batchJobModule submits Cloud Batch jobs
+const batchJobModule = "scheduler/batch-job-template"
+
+// usesBatch returns true if any module submits Cloud Batch jobs
+func (bp Blueprint) usesBatch() bool {
+	return len(bp.modulesWithSource(batchJobModule)) > 0
+}
+
+// batchModule describes the project and job service account of a module that
+// submits Batch jobs; ok is false if the module does not submit jobs or its
+// project cannot be determined before deployment. The service account is left
+// empty if the jobs run with an instance template, which sets it, or if it is
+// not known.
+func (bp Blueprint) batchModule(m Module) (validators.BatchModule, bool) {
+	if !sourceIs(m.Source, batchJobModule) {
+		return validators.BatchModule{}, false
+	}
+	project, ok := bp.moduleProject(m)
+	if !ok {
+		return validators.BatchModule{}, false
+	}
+	bm := validators.BatchModule{Module: string(m.ID), ProjectID: project}
+	if m.Settings.Has("instance_template") {
+		return bm, true
+	}
+	if sa, ok := bp.nodePoolServiceAccount(m); ok {
+		bm.ServiceAccount = sa
+	}
+	return bm, true
+}
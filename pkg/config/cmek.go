@@ -0,0 +1,108 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+
+	"hpc-toolkit/pkg/validators"
+)
+
+// cmekSettings are the settings by which modules encrypt their disks or
+// Filestore instances with a customer-managed Cloud KMS key
+var cmekSettings = []string{
+	"kms_key_name",
+	"kms_key",
+	"kms_key_self_link",
+	"disk_kms_key_name",
+	"boot_disk_kms_key",
+}
+
+// cmekKeySetting returns the first CMEK setting of a module
+func cmekKeySetting(m Module) (string, bool) {
+	for _, s := range cmekSettings {
+		if m.Settings.Has(s) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// usesCMEK returns true if any module sets a customer-managed encryption key
+func (bp Blueprint) usesCMEK() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, ok := cmekKeySetting(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
+
+// moduleRegion returns the region of the resources of a module: its region
+// setting, the region of its zone setting, or the region deployment variable
+func (bp Blueprint) moduleRegion(m Module) (string, bool) {
+	v := GlobalRef("region").AsExpression().AsValue()
+	zonal := false
+	switch {
+	case m.Settings.Has("region"):
+		v = m.Settings.Get("region")
+	case m.Settings.Has("zone"):
+		v, zonal = m.Settings.Get("zone"), true
+	case !bp.Vars.Has("region"):
+		return "", false
+	}
+	v, ok := evalIfKnown(v, bp)
+	if !ok || !isNonEmptyString(v) {
+		return "", false
+	}
+	if !zonal {
+		return v.AsString(), true
+	}
+	zone := v.AsString()
+	i := strings.LastIndex(zone, "-")
+	if i <= 0 {
+		return "", false
+	}
+	return zone[:i], true
+}
+
+// cmekModule describes the customer-managed key of a module; ok is false if
+// the module sets no key or its key or project cannot be determined before
+// deployment. The region is left empty if it is not known.
+func (bp Blueprint) cmekModule(m Module) (validators.CMEKModule, bool) {
+	s, ok := cmekKeySetting(m)
+	if !ok {
+		return validators.CMEKModule{}, false
+	}
+	key, ok := evalIfKnown(m.Settings.Get(s), bp)
+	if !ok || !isNonEmptyString(key) {
+		return validators.CMEKModule{}, false
+	}
+	project, ok := bp.moduleProject(m)
+	if !ok {
+		return validators.CMEKModule{}, false
+	}
+	cm := validators.CMEKModule{
+		Module:    string(m.ID),
+		ProjectID: project,
+		Key:       key.AsString(),
+		Filestore: strings.Contains(m.Source, "filestore"),
+	}
+	if region, ok := bp.moduleRegion(m); ok {
+		cm.Region = region
+	}
+	return cm, true
+}
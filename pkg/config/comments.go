@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readComments returns the YAML document of data so that its comments can
+// be restored when the blueprint is exported; comments are best effort, so
+// nil is returned if the document cannot be parsed
+func readComments(data []byte) *yaml.Node {
+	var n yaml.Node
+	if err := yaml.Unmarshal(data, &n); err != nil {
+		return nil
+	}
+	return &n
+}
+
+// keys that identify the items of blueprint sequences
+var itemKeys = []string{"id", "group", "validator"}
+
+// copyComments copies the comments of src, and of the nodes it contains, to
+// the corresponding nodes of dst. Mapping entries are matched by key and
+// sequence items by index, unless they are mappings identified by an id,
+// group or validator key. License headers are not copied.
+func copyComments(src, dst *yaml.Node) {
+	if src == nil || dst == nil {
+		return
+	}
+	if src.Kind == yaml.DocumentNode && len(src.Content) == 1 {
+		src = src.Content[0]
+	}
+	if dst.Kind == yaml.DocumentNode && len(dst.Content) == 1 {
+		dst = dst.Content[0]
+	}
+	copyNodeComments(src, dst)
+
+	switch {
+	case src.Kind == yaml.MappingNode && dst.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			j := mappingKeyIndex(dst, src.Content[i].Value)
+			if j == -1 {
+				continue
+			}
+			key, value := src.Content[i], src.Content[i+1]
+			copyNodeComments(key, dst.Content[j])
+			if value.Kind != yaml.ScalarNode && dst.Content[j+1].Style&yaml.FlowStyle == 0 && dst.Content[j].LineComment == "" {
+				// the line comment of a flow collection belongs after the
+				// key when the collection is written in block style
+				dst.Content[j].LineComment = value.LineComment
+			}
+			copyComments(value, dst.Content[j+1])
+		}
+	case src.Kind == yaml.SequenceNode && dst.Kind == yaml.SequenceNode:
+		for i, item := range src.Content {
+			if match := matchingItem(item, dst, i); match != nil {
+				copyComments(item, match)
+			}
+		}
+	}
+}
+
+func copyNodeComments(src, dst *yaml.Node) {
+	if dst.HeadComment == "" && !isLicense(src.HeadComment) {
+		dst.HeadComment = src.HeadComment
+	}
+	if dst.LineComment == "" && (dst.Kind == yaml.ScalarNode || dst.Style&yaml.FlowStyle != 0) {
+		dst.LineComment = src.LineComment
+	}
+	if dst.FootComment == "" {
+		dst.FootComment = src.FootComment
+	}
+}
+
+func isLicense(comment string) bool {
+	return strings.Contains(comment, "Licensed under the Apache License")
+}
+
+// mappingKeyIndex returns the index of key in the content of a mapping node,
+// or -1 if the node is not a mapping or does not contain key
+func mappingKeyIndex(n *yaml.Node, key string) int {
+	if n.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchingItem returns the item of dst that corresponds to the item of a
+// source sequence at index i
+func matchingItem(item *yaml.Node, dst *yaml.Node, i int) *yaml.Node {
+	if item.Kind == yaml.MappingNode {
+		for _, k := range itemKeys {
+			j := mappingKeyIndex(item, k)
+			if j == -1 {
+				continue
+			}
+			for _, d := range dst.Content {
+				if l := mappingKeyIndex(d, k); l != -1 && d.Content[l+1].Value == item.Content[j+1].Value {
+					return d
+				}
+			}
+			return nil
+		}
+	}
+	if i < len(dst.Content) {
+		return dst.Content[i]
+	}
+	return nil
+}
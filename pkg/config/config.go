@@ -17,19 +17,26 @@ package config
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/sourcereader"
 )
 
 const (
@@ -46,6 +53,9 @@ var errorMessages = map[string]string{
 	"yamlUnmarshalError": "failed to parse the blueprint in %s, check YAML syntax for errors, err=%w",
 	"yamlMarshalError":   "failed to export the configuration to a blueprint yaml file",
 	"fileSaveError":      "failed to write the expanded yaml",
+	"multipleBlueprints": "the file has several blueprints, select one by name",
+	"blueprintNotFound":  "the blueprint was not found in the file",
+	"duplicateBlueprint": "the file has several blueprints of the same name",
 	// expand
 	"missingSetting":       "a required setting is missing from a module",
 	"globalLabelType":      "deployment variable 'labels' are not a map",
@@ -55,10 +65,20 @@ var errorMessages = map[string]string{
 	"invalidDeploymentRef": "invalid deployment-wide reference (only \"vars\") is supported)",
 	"varNotFound":          "Could not find source of variable",
 	"intergroupOrder":      "References to outputs from other groups must be to earlier groups",
+	"referenceCycle":       "modules refer to each other in a cycle, which Terraform cannot deploy",
 	"referenceWrongGroup":  "Reference specified the wrong group for the module",
 	"noOutput":             "Output not found for a variable",
 	"groupNotFound":        "The group ID was not found",
 	"cannotUsePacker":      "Packer modules cannot be used by other modules",
+	"backendNotFound":      "deployment group refers to a backend that is not defined in terraform_backends",
+	"backendAndProfile":    "deployment group cannot set both backend and terraform_backend",
+	"emptyBackendType":     "backend profile in terraform_backends must set type",
+	"prefixTemplate":       "invalid template in the prefix of a terraform backend",
+	"sharedStatePrefix":    "deployment groups would share the Terraform state of a backend prefix",
+	"ghpcVersion":          "the blueprint cannot be imported by this release of ghpc",
+	"fileFunction":         "failed to read a file of the blueprint",
+	"unscopedValidator":    "ignore_modules and ignore_groups can only be set for validators that inspect modules",
+	"modulePolicy":         "modules are not permitted by the module policy",
 	// validator
 	"emptyID":            "a module id cannot be empty",
 	"emptySource":        "a module source cannot be empty",
@@ -71,6 +91,7 @@ var errorMessages = map[string]string{
 	"emptyGroupName":     "group name must be set for each deployment group",
 	"illegalChars":       "invalid character(s) found in group name",
 	"invalidOutput":      "requested output was not found in the module",
+	"duplicateOutput":    "requested output is listed more than once in the module",
 	"varNotDefined":      "variable not defined",
 	"valueNotString":     "value was not of type string",
 	"valueEmptyString":   "value is an empty string",
@@ -90,10 +111,10 @@ type GroupName string
 // Validate checks that the group name is valid
 func (n GroupName) Validate() error {
 	if n == "" {
-		return errors.New(errorMessages["emptyGroupName"])
+		return configErrorf("emptyGroupName", "")
 	}
 	if hasIllegalChars(string(n)) {
-		return fmt.Errorf("%s %s", errorMessages["illegalChars"], n)
+		return configErrorf("illegalChars", " %s", n)
 	}
 	return nil
 }
@@ -102,8 +123,37 @@ func (n GroupName) Validate() error {
 type DeploymentGroup struct {
 	Name             GroupName        `yaml:"group"`
 	TerraformBackend TerraformBackend `yaml:"terraform_backend"`
-	Modules          []Module         `yaml:"modules"`
-	Kind             ModuleKind
+	// Backend names one of the blueprint terraform_backends profiles; it is
+	// replaced by the profile's configuration in TerraformBackend on expansion
+	Backend string `yaml:"backend,omitempty"`
+	// ProjectID overrides the project_id deployment variable for the modules
+	// and providers of the group
+	ProjectID string `yaml:"project_id,omitempty"`
+	// ImpersonateServiceAccount overrides the blueprint service account that
+	// the providers of the group impersonate
+	ImpersonateServiceAccount string `yaml:"impersonate_service_account,omitempty"`
+	// SubgroupOf names the group mixing Packer and Terraform modules that was
+	// split into this and other sub-groups on expansion
+	SubgroupOf GroupName `yaml:"subgroup_of,omitempty"`
+	// Retry is the policy of ghpc deploy for transient failures of the group
+	Retry RetryPolicy `yaml:"retry,omitempty"`
+	// Vars can only be referenced, as $(group.name), by the modules of the
+	// group
+	Vars    Dict     `yaml:"vars,omitempty"`
+	Modules []Module `yaml:"modules"`
+	Kind    ModuleKind
+}
+
+// MatchesName returns true if the group is named n or is a sub-group of the
+// group named n
+func (g DeploymentGroup) MatchesName(n GroupName) bool {
+	return g.Name == n || (g.SubgroupOf != "" && g.SubgroupOf == n)
+}
+
+// PackerModule returns the module of a Packer group, which checkPackerGroups
+// enforces to be its only module
+func (g DeploymentGroup) PackerModule() Module {
+	return g.Modules[0]
 }
 
 // Module return the module with the given ID
@@ -116,7 +166,7 @@ func (bp *Blueprint) Module(id ModuleID) (*Module, error) {
 		return nil
 	})
 	if mod == nil {
-		return nil, fmt.Errorf("%s: %s", errorMessages["invalidMod"], id)
+		return nil, configErrorf("invalidMod", ": %s", id)
 	}
 	return mod, nil
 }
@@ -130,7 +180,7 @@ func (bp Blueprint) ModuleGroup(mod ModuleID) (DeploymentGroup, error) {
 			}
 		}
 	}
-	return DeploymentGroup{}, fmt.Errorf("%s: %s", errorMessages["invalidMod"], mod)
+	return DeploymentGroup{}, configErrorf("invalidMod", ": %s", mod)
 }
 
 // ModuleGroupOrDie returns the group containing the module; panics if unfound
@@ -168,6 +218,15 @@ type TerraformBackend struct {
 	Configuration Dict
 }
 
+// copy returns a TerraformBackend that does not share configuration with b
+func (b TerraformBackend) copy() TerraformBackend {
+	c := TerraformBackend{Type: b.Type, Configuration: Dict{}}
+	for k, v := range b.Configuration.Items() {
+		c.Configuration.Set(k, v)
+	}
+	return c
+}
+
 // ModuleKind abstracts Toolkit module kinds (presently: packer/terraform)
 type ModuleKind struct {
 	kind string
@@ -182,6 +241,10 @@ var TerraformKind = ModuleKind{kind: "terraform"}
 // PackerKind is the kind for Packer modules (should be treated as const)
 var PackerKind = ModuleKind{kind: "packer"}
 
+// MockKind is the kind for mock modules, whose inputs and outputs are declared
+// in the blueprint (should be treated as const)
+var MockKind = ModuleKind{kind: "mock"}
+
 // UnmarshalYAML implements a custom unmarshaler from YAML string to ModuleKind
 func (mk *ModuleKind) UnmarshalYAML(n *yaml.Node) error {
 	var kind string
@@ -192,7 +255,7 @@ func (mk *ModuleKind) UnmarshalYAML(n *yaml.Node) error {
 		mk.kind = kind
 		return nil
 	}
-	return fmt.Errorf(yamlErrorMsg, n.Line, "kind must be \"packer\" or \"terraform\" or removed from YAML")
+	return fmt.Errorf(yamlErrorMsg, n.Line, "kind must be \"packer\", \"terraform\" or \"mock\" or removed from YAML")
 }
 
 // MarshalYAML implements a custom marshaler from ModuleKind to YAML string
@@ -203,7 +266,7 @@ func (mk ModuleKind) MarshalYAML() (interface{}, error) {
 // IsValidModuleKind ensures that the user has specified a supported kind
 func IsValidModuleKind(kind string) bool {
 	return kind == TerraformKind.String() || kind == PackerKind.String() ||
-		kind == UnknownKind.String()
+		kind == MockKind.String() || kind == UnknownKind.String()
 }
 
 func (mk ModuleKind) String() string {
@@ -222,6 +285,26 @@ const (
 	testZoneInRegionName
 	testApisEnabledName
 	testDeploymentVariableNotUsedName
+	testSlurmAccountingName
+	testStartupScriptsName
+	testHostnamesName
+	testOSLoginSSHKeysName
+	testSpotConfigurationName
+	testOpsAgentName
+	testContainerImagesName
+	testComputeQuotasName
+	testDiskSizesName
+	testBatchPermissionsName
+	execName
+	testPlacementAndMTUName
+	testSubnetCapacityName
+	testCMEKKeysName
+	testZoneAvailableName
+	testGPUImagesName
+	testExternalIPsName
+	testCredentialsName
+	testGPUNetworkingName
+	testModuleZonesName
 )
 
 // this enum will be used to control how fatal validator failures will be
@@ -252,6 +335,46 @@ func (v validatorName) String() string {
 		return "test_module_not_used"
 	case testDeploymentVariableNotUsedName:
 		return "test_deployment_variable_not_used"
+	case testSlurmAccountingName:
+		return "test_slurm_accounting"
+	case testStartupScriptsName:
+		return "test_startup_scripts"
+	case testHostnamesName:
+		return "test_hostnames"
+	case testOSLoginSSHKeysName:
+		return "test_os_login_ssh_keys"
+	case testSpotConfigurationName:
+		return "test_spot_configuration"
+	case testOpsAgentName:
+		return "test_ops_agent"
+	case testContainerImagesName:
+		return "test_container_images"
+	case testComputeQuotasName:
+		return "test_compute_quotas"
+	case testDiskSizesName:
+		return "test_disk_sizes"
+	case testBatchPermissionsName:
+		return "test_batch_permissions"
+	case execName:
+		return "exec"
+	case testPlacementAndMTUName:
+		return "test_placement_and_mtu"
+	case testSubnetCapacityName:
+		return "test_subnet_capacity"
+	case testCMEKKeysName:
+		return "test_cmek_keys"
+	case testZoneAvailableName:
+		return "test_zone_available"
+	case testGPUImagesName:
+		return "test_gpu_images"
+	case testExternalIPsName:
+		return "test_external_ips"
+	case testCredentialsName:
+		return "test_credentials"
+	case testGPUNetworkingName:
+		return "test_gpu_networking"
+	case testModuleZonesName:
+		return "test_module_zones"
 	default:
 		return "unknown_validator"
 	}
@@ -261,6 +384,86 @@ type validatorConfig struct {
 	Validator string
 	Inputs    Dict
 	Skip      bool
+	// SkipReason justifies skipping the validator in the validation report
+	SkipReason string `yaml:"skip_reason,omitempty"`
+	// Timeout bounds the execution time of the validator; when zero the
+	// default of defaultValidatorTimeout is used
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// IgnoreModules and IgnoreGroups exclude modules, or all modules in the
+	// groups, from validators that inspect the modules of the blueprint
+	IgnoreModules []ModuleID  `yaml:"ignore_modules,omitempty"`
+	IgnoreGroups  []GroupName `yaml:"ignore_groups,omitempty"`
+	// reason tells why a default validator was added; it is empty for
+	// validators configured in the blueprint
+	reason string
+}
+
+// validators that inspect modules and can be configured to ignore some of them
+var moduleScopedValidators = []validatorName{
+	testApisEnabledName,
+	testModuleNotUsedName,
+	testSlurmAccountingName,
+	testStartupScriptsName,
+	testHostnamesName,
+	testOSLoginSSHKeysName,
+	testSpotConfigurationName,
+	testOpsAgentName,
+	testContainerImagesName,
+	testComputeQuotasName,
+	testDiskSizesName,
+	testBatchPermissionsName,
+	testPlacementAndMTUName,
+	testSubnetCapacityName,
+	testCMEKKeysName,
+	testGPUImagesName,
+	testExternalIPsName,
+	testGPUNetworkingName,
+	testModuleZonesName,
+}
+
+// isModuleScoped returns true if the named validator inspects modules
+func isModuleScoped(name string) bool {
+	for _, n := range moduleScopedValidators {
+		if name == n.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// ignores returns true if the validator is configured, or annotated in the
+// module, to ignore the module
+func (v validatorConfig) ignores(m Module, bp Blueprint) bool {
+	if slices.Contains(v.IgnoreModules, m.ID) {
+		return true
+	}
+	if _, ok := m.skipValidators[v.Validator]; ok {
+		return true
+	}
+	g, err := bp.ModuleGroup(m.ID)
+	return err == nil && slices.ContainsFunc(v.IgnoreGroups, g.MatchesName)
+}
+
+// checkScope confirms that modules and groups ignored by the validator exist
+// and that the validator inspects modules
+func (v validatorConfig) checkScope(bp Blueprint) error {
+	if len(v.IgnoreModules) == 0 && len(v.IgnoreGroups) == 0 {
+		return nil
+	}
+	if !isModuleScoped(v.Validator) {
+		return configErrorf("unscopedValidator", ": %s", v.Validator)
+	}
+	for _, id := range v.IgnoreModules {
+		if _, err := bp.Module(id); err != nil {
+			return fmt.Errorf("validator %s ignores module %s: %w", v.Validator, id, err)
+		}
+	}
+	for _, n := range v.IgnoreGroups {
+		if !slices.ContainsFunc(bp.DeploymentGroups, func(g DeploymentGroup) bool { return g.MatchesName(n) }) {
+			return fmt.Errorf("validator %s ignores group %s: could not find group %s in blueprint", v.Validator, n, n)
+		}
+	}
+	return nil
 }
 
 func (v *validatorConfig) check(name validatorName, requiredInputs []string) error {
@@ -295,23 +498,42 @@ type ModuleID string
 // Module stores YAML definition of an HPC cluster component defined in a blueprint
 type Module struct {
 	Source string
+	// SourceHash pins the contents of the source, which are verified before
+	// the deployment is written; see sourcereader.CheckSourceHash
+	SourceHash string `yaml:"source_hash,omitempty"`
 	// DeploymentSource - is source to be used for this module in written deployment.
 	DeploymentSource string `yaml:"-"` // "-" prevents user from specifying it
 	Kind             ModuleKind
 	ID               ModuleID
-	Use              []ModuleID
-	WrapSettingsWith map[string][]string
-	Outputs          []modulereader.OutputInfo `yaml:"outputs,omitempty"`
-	Settings         Dict
-	RequiredApis     map[string][]string `yaml:"required_apis"`
-}
-
-// createWrapSettingsWith ensures WrapSettingsWith field is not nil, if it is
-// a new map is created.
-func (m *Module) createWrapSettingsWith() {
-	if m.WrapSettingsWith == nil {
-		m.WrapSettingsWith = make(map[string][]string)
-	}
+	// Enabled is a boolean, or an expression of deployment variables, that
+	// leaves the module out of the deployment when it is false
+	Enabled *YamlValue `yaml:"enabled,omitempty"`
+	Use     []ModuleID
+	// Depends lists modules that must be created before this module, although
+	// it uses none of their outputs
+	Depends []ModuleID `yaml:"depends,omitempty"`
+	// Transforms are the pipelines of transformers applied to settings
+	Transforms map[string][]SettingTransformer `yaml:"transforms,omitempty"`
+	// LegacyWrapSettingsWith is read from blueprints expanded by earlier
+	// versions and replaced by Transforms
+	LegacyWrapSettingsWith map[string][]string       `yaml:"wrapsettingswith,omitempty"`
+	Outputs                []modulereader.OutputInfo `yaml:"outputs,omitempty"`
+	Settings               Dict
+	// Imports adopt existing resources instead of creating them; they map
+	// addresses of resources within the module to the IDs of the resources
+	Imports      Dict                `yaml:"imports,omitempty"`
+	RequiredApis map[string][]string `yaml:"required_apis"`
+	// Mock declares the inputs and outputs of a module of kind mock
+	Mock *MockInterface `yaml:"mock,omitempty"`
+	// skipValidators holds the reasons given by the ghpc:skip-validator
+	// annotations of the module by validator name
+	skipValidators map[string]string
+	// useMaps holds the settings mapped to expressions of the outputs of
+	// used modules, by used module; see UnmarshalYAML
+	useMaps map[ModuleID]Dict
+	// useModes holds the modes by which the outputs of used modules are
+	// matched, by used module, if not UseByName
+	useModes map[ModuleID]UseMode
 }
 
 // InfoOrDie returns the ModuleInfo for the module or panics
@@ -330,30 +552,172 @@ func (m Module) InfoOrDie() modulereader.ModuleInfo {
 type Blueprint struct {
 	BlueprintName            string `yaml:"blueprint_name"`
 	GhpcVersion              string `yaml:"ghpc_version,omitempty"`
+	MinimumGhpcVersion       string `yaml:"minimum_ghpc_version,omitempty"`
 	Validators               []validatorConfig
-	ValidationLevel          int `yaml:"validation_level,omitempty"`
+	ValidationLevel          int           `yaml:"validation_level,omitempty"`
+	ValidationTimeout        time.Duration `yaml:"validation_timeout,omitempty"`
 	Vars                     Dict
 	DeploymentGroups         []DeploymentGroup `yaml:"deployment_groups"`
 	TerraformBackendDefaults TerraformBackend  `yaml:"terraform_backend_defaults"`
+	// ImpersonateServiceAccount is the service account that the providers of
+	// the Terraform groups and the API clients of the validators impersonate
+	ImpersonateServiceAccount string `yaml:"impersonate_service_account,omitempty"`
+	// ExperimentalFeatures opt in to expansion behaviors that are not yet
+	// enabled by default
+	ExperimentalFeatures []string `yaml:"experimental_features,omitempty"`
+	// TerraformBackends are named backend profiles selected by deployment
+	// groups with the backend field
+	TerraformBackends map[string]TerraformBackend `yaml:"terraform_backends,omitempty"`
+	// ExternalDeployments are other deployments whose group outputs modules
+	// read, as $(external.deployment.group.output)
+	ExternalDeployments map[string]ExternalDeployment `yaml:"external_deployments,omitempty"`
+	// ModuleAliases are short names that module sources may use in place of
+	// the sources they stand for
+	ModuleAliases map[string]string `yaml:"module_aliases,omitempty"`
+	// ModulePolicy restricts the module sources and kinds that may be used
+	ModulePolicy *ModulePolicy `yaml:"module_policy,omitempty"`
+	// Secrets are set when expanding blueprints encrypted with sops, so that
+	// their encrypted deployment variables are never exported. They are not
+	// part of the YAML, which may not name files to decrypt, but recorded by
+	// ghpc in a file next to the exported blueprint.
+	Secrets []SecretsSource `yaml:"-"`
+	// VarSources read deployment variables from central stores when the
+	// blueprint is expanded
+	VarSources []VarSource `yaml:"var_sources,omitempty"`
+	// Notifications are sent when the deployment of each group starts,
+	// succeeds or fails
+	Notifications []Notification `yaml:"notifications,omitempty"`
+	// GhpcMetadata adds a ghpc_metadata local, which records the blueprint
+	// and ghpc that generated the group, to the main.tf of Terraform groups
+	GhpcMetadata bool `yaml:"ghpc_metadata,omitempty"`
 }
 
 // DeploymentConfig is a container for the imported YAML data and supporting data for
 // creating the blueprint from it
 type DeploymentConfig struct {
 	Config Blueprint
+	// comments holds the YAML document of the imported blueprint, whose
+	// comments are restored when the blueprint is exported
+	comments *yaml.Node
+	// userSettings holds the module settings written in the blueprint, which
+	// are recorded before expansion to annotate the provenance of settings
+	userSettings map[ModuleID]map[string]cty.Value
+	// userVars and userModules hold the deployment variables and the module
+	// fields written in the blueprint, to minimize the expanded blueprint
+	userVars    map[string]cty.Value
+	userModules map[ModuleID]userModule
+	// resolvedVars records the deployment variables read from var sources
+	resolvedVars []ResolvedVar
+	// trace receives the changes of each expansion phase, if set
+	trace io.Writer
+	// selectZone chooses the zone of blueprints that only set their region,
+	// if set; defaultedVars tells how the deployment variables set by
+	// expansion were chosen
+	selectZone    ZoneSelector
+	defaultedVars map[string]string
+	// useReports record the matches of the use lists of modules
+	useReports []UseReport
+	// blueprintDir is the directory of the blueprint file, to which the paths
+	// of file and templatefile are relative; it is empty for blueprints that
+	// are not read from a file, whose paths are relative to the working
+	// directory
+	blueprintDir string
+	// deploymentDir is the directory to which the expanded blueprint is
+	// written, which may hold a previous deployment; it is empty for
+	// blueprints that are only expanded
+	deploymentDir string
+	// previousVars are the deployment variables of the previous expansion of
+	// the deployment, which are empty for a new deployment
+	previousVars Dict
+	// enforcedPolicies are module policies that apply in addition to the
+	// module policy of the blueprint
+	enforcedPolicies []ModulePolicy
+}
+
+// SetDeploymentDir sets the directory to which the expanded blueprint is
+// written, whose previous deployment, if any, expansion takes into account.
+// Only the deployment variables of the previous expanded blueprint are read,
+// so that secrets are not decrypted.
+func (dc *DeploymentConfig) SetDeploymentDir(dir string, expandedBlueprintFile string) error {
+	vars, err := readExpandedVars(expandedBlueprintFile)
+	if err != nil {
+		return err
+	}
+	dc.deploymentDir = dir
+	dc.previousVars = vars
+	return nil
 }
 
-// ExpandConfig expands the yaml config in place
-func (dc *DeploymentConfig) ExpandConfig() error {
+// PreviousDeploymentID returns the ghpc_deployment_id label of the previous
+// expansion of the deployment, or "" if there is none
+func (dc DeploymentConfig) PreviousDeploymentID() string {
+	return Blueprint{Vars: dc.previousVars}.DeploymentID()
+}
+
+// ExpandConfig expands the yaml config in place. Cancelling ctx aborts the
+// reading of var sources and modules and the API calls of validators.
+// Errors without a code of their own are given ErrCodeExpansion.
+func (dc *DeploymentConfig) ExpandConfig(ctx context.Context) error {
+	return withCode(ErrCodeExpansion, dc.expandConfig(ctx))
+}
+
+func (dc *DeploymentConfig) expandConfig(ctx context.Context) error {
+	if err := checkExperimentalFeatures(dc.Config); err != nil {
+		return err
+	}
+	dc.recordUserSettings()
+	dc.recordUserFields()
+	if err := dc.resolveVarSources(ctx); err != nil {
+		return err
+	}
+	if err := dc.Config.dropDisabledModules(dc.deploymentDir); err != nil {
+		return err
+	}
+	if err := dc.tracePhase("file function resolution", dc.resolveFileFunctions); err != nil {
+		return err
+	}
+	if err := dc.Config.resolveModuleAliases(); err != nil {
+		return err
+	}
 	if err := dc.Config.checkMovedModules(); err != nil {
 		return err
 	}
 	dc.Config.setGlobalLabels()
-	dc.Config.addKindToModules()
-	dc.validateConfig()
-	dc.expand()
-	dc.validate()
-	return nil
+	if err := dc.tracePhase("group variable application", dc.Config.applyGroupVars); err != nil {
+		return err
+	}
+	dc.tracePhase("kind defaulting", func() error {
+		dc.Config.addKindToModules()
+		return nil
+	})
+	if err := dc.tracePhase("external reference resolution", dc.Config.applyExternalReferences); err != nil {
+		return err
+	}
+	if err := dc.Config.registerMockModules(); err != nil {
+		return err
+	}
+	if err := dc.Config.splitMixedGroups(); err != nil {
+		return err
+	}
+	if err := dc.Config.checkModulePolicy(dc.enforcedPolicies); err != nil {
+		return err
+	}
+	if err := dc.tracePhase("image reference resolution", dc.Config.resolveImageReferences); err != nil {
+		return err
+	}
+	if err := dc.validateConfig(ctx); err != nil {
+		return err
+	}
+	if err := dc.tracePhase("location defaulting", func() error { return dc.defaultLocation(ctx) }); err != nil {
+		return err
+	}
+	if err := dc.expand(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return dc.validate(ctx)
 }
 
 func (bp *Blueprint) setGlobalLabels() {
@@ -367,7 +731,7 @@ func (bp *Blueprint) setGlobalLabels() {
 func (m Module) listUnusedModules() []ModuleID {
 	used := map[ModuleID]bool{}
 	// Recurse through objects/maps/lists checking each element for having `ProductOfModuleUse` mark.
-	cty.Walk(m.Settings.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
+	m.Settings.Walk(func(p cty.Path, v cty.Value) (bool, error) {
 		if mark, has := HasMark[ProductOfModuleUse](v); has {
 			used[mark.Module] = true
 		}
@@ -403,26 +767,24 @@ func GetUsedDeploymentVars(val cty.Value) []string {
 func (dc *DeploymentConfig) listUnusedDeploymentVariables() []string {
 	// these variables are required or automatically constructed and applied;
 	// these should not be listed unused otherwise no blueprints are valid
-	var usedVars = map[string]bool{
-		"labels":          true,
-		"deployment_name": true,
+	usedPaths := [][]string{
+		{"labels"},
+		{"deployment_name"},
+		// consumed when the deployment is created
+		{StartupScriptBucketVar},
 	}
 
 	dc.Config.WalkModules(func(m *Module) error {
-		for _, v := range GetUsedDeploymentVars(m.Settings.AsObject()) {
-			usedVars[v] = true
-		}
+		cty.Walk(m.Settings.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
+			if e, is := IsExpressionValue(v); is {
+				usedPaths = append(usedPaths, globalVarPaths(e)...)
+			}
+			return true, nil
+		})
 		return nil
 	})
 
-	unusedVars := []string{}
-	for k := range dc.Config.Vars.Items() {
-		if _, ok := usedVars[k]; !ok {
-			unusedVars = append(unusedVars, k)
-		}
-	}
-
-	return unusedVars
+	return unusedGlobalVars(dc.Config.Vars, usedPaths)
 }
 
 func (bp Blueprint) checkMovedModules() error {
@@ -439,31 +801,103 @@ func (bp Blueprint) checkMovedModules() error {
 	return err
 }
 
-// NewDeploymentConfig is a constructor for DeploymentConfig
+// NewDeploymentConfig is a constructor for DeploymentConfig. Errors without
+// a code of their own are given ErrCodeImport.
 func NewDeploymentConfig(configFilename string) (DeploymentConfig, error) {
-	blueprint, err := importBlueprint(configFilename)
+	return NewDeploymentConfigNamed(configFilename, "")
+}
+
+// NewDeploymentConfigNamed is NewDeploymentConfig of the blueprint named
+// blueprintName, in a file of several `---`-separated blueprints. An empty
+// name selects the blueprint of a file that has only one.
+func NewDeploymentConfigNamed(configFilename string, blueprintName string) (DeploymentConfig, error) {
+	blueprint, comments, err := importBlueprint(configFilename, blueprintName)
 	if err != nil {
+		return DeploymentConfig{}, withCode(ErrCodeImport, err)
+	}
+	dc, err := newDeploymentConfig(configFilename, blueprint, comments)
+	dc.blueprintDir = filepath.Dir(configFilename)
+	return dc, err
+}
+
+// NewDeploymentConfigFromData is NewDeploymentConfigNamed of blueprints that
+// are not read from a file, such as those piped to ghpc; source names them in
+// errors. Blueprints encrypted with sops can only be read from files.
+func NewDeploymentConfigFromData(source string, data []byte, blueprintName string) (DeploymentConfig, error) {
+	blueprint, comments, err := decodeBlueprint(source, data, blueprintName, false)
+	if err != nil {
+		return DeploymentConfig{}, withCode(ErrCodeImport, err)
+	}
+	return newDeploymentConfig(source, blueprint, comments)
+}
+
+// newDeploymentConfig returns the DeploymentConfig of the blueprint whose
+// YAML document, read by readComments, is comments
+func newDeploymentConfig(source string, blueprint Blueprint, comments *yaml.Node) (DeploymentConfig, error) {
+	if err := blueprint.readSkipAnnotations(comments); err != nil {
+		return DeploymentConfig{}, withCode(ErrCodeImport, fmt.Errorf("%s: %w", source, err))
+	}
+	if err := blueprint.checkGhpcVersion(ToolkitVersion); err != nil {
 		return DeploymentConfig{}, err
 	}
-	return DeploymentConfig{Config: blueprint}, nil
+	return DeploymentConfig{Config: blueprint, comments: comments}, nil
 }
 
-// ImportBlueprint imports the blueprint configuration provided.
-func importBlueprint(blueprintFilename string) (Blueprint, error) {
+// ImportBlueprint imports the blueprint named blueprintName in the file,
+// returning it with the parsed YAML document that defines it.
+func importBlueprint(blueprintFilename string, blueprintName string) (Blueprint, *yaml.Node, error) {
+	data, err := os.ReadFile(blueprintFilename)
+	if err != nil {
+		return Blueprint{}, nil, configErrorf("fileLoadError", ", filename=%s: %v", blueprintFilename, err)
+	}
+	blueprint, node, err := decodeBlueprint(blueprintFilename, data, blueprintName, true)
+	if err != nil {
+		return blueprint, nil, err
+	}
+	// only blueprints read from files decrypt their secrets, so that
+	// blueprints sent over the network are never decrypted
+	recorded, err := readSecretsRecord(blueprintFilename)
+	if err != nil {
+		return blueprint, nil, err
+	}
+	blueprint.Secrets = append(recorded, blueprint.Secrets...)
+	blueprint.restoreSecrets(blueprintFilename)
+	return blueprint, node, nil
+}
+
+// decodeBlueprint decodes the blueprint named blueprintName in the YAML
+// stream read from source, returning it with the parsed YAML document that
+// defines it, which is only parsed once as blueprints can be large. Only
+// blueprints read from a file, as fromFile tells, are decrypted with sops.
+func decodeBlueprint(source string, stream []byte, blueprintName string, fromFile bool) (Blueprint, *yaml.Node, error) {
 	var blueprint Blueprint
 
-	reader, err := os.Open(blueprintFilename)
+	doc, node, err := selectBlueprint(source, stream, blueprintName)
 	if err != nil {
-		return blueprint, fmt.Errorf("%s, filename=%s: %v",
-			errorMessages["fileLoadError"], blueprintFilename, err)
+		return blueprint, nil, err
+	}
+	if node == nil {
+		node = readComments(doc)
+	}
+	if !fromFile && node != nil && isSopsEncrypted(node) {
+		return blueprint, nil, fmt.Errorf("%s: blueprints encrypted with sops must be read from a file", source)
+	}
+	data, secrets, err := decryptBlueprint(source, doc, node)
+	if err != nil {
+		return blueprint, nil, err
 	}
 
-	decoder := yaml.NewDecoder(reader)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
 	decoder.KnownFields(true)
 
 	if err = decoder.Decode(&blueprint); err != nil {
-		return blueprint, fmt.Errorf(errorMessages["yamlUnmarshalError"],
-			blueprintFilename, err)
+		return blueprint, nil, configErrorf("yamlUnmarshalError", "", source, err)
+	}
+	if err = blueprint.migrateWrapSettingsWith(); err != nil {
+		return blueprint, nil, err
+	}
+	if secrets != nil {
+		blueprint.Secrets = append(blueprint.Secrets, *secrets)
 	}
 
 	// if the validation level has been explicitly set to an invalid value
@@ -472,31 +906,77 @@ func importBlueprint(blueprintFilename string) (Blueprint, error) {
 		blueprint.ValidationLevel = ValidationError
 	}
 
-	return blueprint, nil
+	return blueprint, node, nil
+}
+
+// ExportOptions select the optional transformations of an exported blueprint
+type ExportOptions struct {
+	// Provenance adds a comment to every module setting that tells where its
+	// value came from
+	Provenance bool
+	// Minimize elides what the toolkit adds to every expanded blueprint,
+	// leaving the settings of the user and of used modules
+	Minimize bool
 }
 
 // ExportBlueprint exports the internal representation of a blueprint config
 func (dc DeploymentConfig) ExportBlueprint(outputFilename string) error {
-	var buf bytes.Buffer
-	buf.WriteString(YamlLicense)
-	buf.WriteString("\n")
-	encoder := yaml.NewEncoder(&buf)
-	encoder.SetIndent(2)
-	err := encoder.Encode(&dc.Config)
-	encoder.Close()
-	d := buf.Bytes()
+	return dc.ExportBlueprintWithOptions(outputFilename, ExportOptions{})
+}
+
+// ExportAnnotatedBlueprint exports the blueprint like ExportBlueprint, adding
+// a comment to every module setting that tells where its value came from
+func (dc DeploymentConfig) ExportAnnotatedBlueprint(outputFilename string) error {
+	return dc.ExportBlueprintWithOptions(outputFilename, ExportOptions{Provenance: true})
+}
 
+// ExportBlueprintWithOptions exports the blueprint like ExportBlueprint,
+// transformed as selected by opts
+func (dc DeploymentConfig) ExportBlueprintWithOptions(outputFilename string, opts ExportOptions) error {
+	d, err := dc.MarshalBlueprint(opts)
 	if err != nil {
-		return fmt.Errorf("%s: %w", errorMessages["yamlMarshalError"], err)
+		return err
 	}
 
 	err = ioutil.WriteFile(outputFilename, d, 0644)
 	if err != nil {
 		// hitting this error writing yaml
-		return fmt.Errorf("%s, Filename: %s: %w",
-			errorMessages["fileSaveError"], outputFilename, err)
+		return configErrorf("fileSaveError", ", Filename: %s: %w", outputFilename, err)
 	}
-	return nil
+	return dc.Config.writeSecretsRecord(outputFilename)
+}
+
+// MarshalBlueprint returns the YAML of the blueprint that
+// ExportBlueprintWithOptions writes
+func (dc DeploymentConfig) MarshalBlueprint(opts ExportOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(YamlLicense)
+	buf.WriteString("\n")
+	bp := dc.Config
+	// encrypted variables keep the placeholder that restores them
+	bp.Vars = bp.redactSensitiveVars()
+	bp.Vars = bp.redactSecrets()
+	var n yaml.Node
+	if err := n.Encode(&bp); err != nil {
+		return nil, configErrorf("yamlMarshalError", ": %w", err)
+	}
+	copyComments(dc.comments, &n)
+	dc.annotateDefaultedVars(&n)
+	if opts.Provenance {
+		dc.annotateProvenance(&n)
+	}
+	if opts.Minimize {
+		dc.minimize(&n)
+	}
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(&n)
+	encoder.Close()
+	if err != nil {
+		return nil, configErrorf("yamlMarshalError", ": %w", err)
+	}
+	return buf.Bytes(), nil
 }
 
 // addKindToModules sets the kind to 'terraform' when empty.
@@ -511,20 +991,34 @@ func (bp *Blueprint) addKindToModules() {
 
 // checkModulesInfo ensures each module in the blueprint has known detailed
 // metadata (inputs, outputs)
-func (bp *Blueprint) checkModulesInfo() error {
+func (bp *Blueprint) checkModulesInfo(ctx context.Context) error {
 	return bp.WalkModules(func(m *Module) error {
-		_, err := modulereader.GetModuleInfo(m.Source, m.Kind.String())
+		_, err := modulereader.GetModuleInfoContext(ctx, m.Source, m.Kind.String())
 		return err
 	})
 }
 
+// checkSourceHashes verifies the sources of modules that pin a source hash
+func (bp *Blueprint) checkSourceHashes(ctx context.Context) error {
+	return bp.WalkModules(func(m *Module) error {
+		if m.SourceHash == "" {
+			return nil
+		}
+		if err := sourcereader.VerifySourceHash(ctx, m.Source, m.SourceHash); err != nil {
+			return fmt.Errorf("module %s: %w", m.ID, err)
+		}
+		return nil
+	})
+}
+
 // checkModulesAndGroups ensures:
 //   - all module IDs are unique across all groups
 //   - if deployment group kind is unknown (not explicit in blueprint), then it is
 //     set to th kind of the first module that has a known kind (a prior func sets
 //     module kind to Terraform if unset)
 //   - all modules must be of the same kind and all modules must be of the same
-//     kind as the group
+//     kind as the group; groups of unknown kind that mix kinds have already
+//     been split by splitMixedGroups
 //   - all group names are unique and do not have illegal characters
 func checkModulesAndGroups(groups []DeploymentGroup) error {
 	seenMod := map[ModuleID]bool{}
@@ -535,21 +1029,24 @@ func checkModulesAndGroups(groups []DeploymentGroup) error {
 			return err
 		}
 		if seenGroups[grp.Name] {
-			return fmt.Errorf("%s: %s used more than once", errorMessages["duplicateGroup"], grp.Name)
+			return configErrorf("duplicateGroup", ": %s used more than once", grp.Name)
 		}
 		seenGroups[grp.Name] = true
 
 		for _, mod := range grp.Modules {
 			if seenMod[mod.ID] {
-				return fmt.Errorf("%s: %s used more than once", errorMessages["duplicateID"], mod.ID)
+				return configErrorf("duplicateID", ": %s used more than once", mod.ID)
 			}
 			seenMod[mod.ID] = true
 
 			// Verify Module Kind matches group Kind
+			if grp.Kind == MockKind {
+				return fmt.Errorf("deployment group %s: kind mock is only supported by modules", grp.Name)
+			}
 			if grp.Kind == UnknownKind {
-				grp.Kind = mod.Kind
+				grp.Kind = mod.Kind.groupKind()
 			}
-			if grp.Kind != mod.Kind {
+			if grp.Kind != mod.Kind.groupKind() {
 				return fmt.Errorf(
 					"mixing modules of differing kinds in a deployment group is not supported: deployment group %s, got %s and %s",
 					grp.Name, grp.Kind, mod.Kind)
@@ -572,6 +1069,25 @@ func checkUsedModuleNames(bp Blueprint) error {
 	})
 }
 
+// checkModuleDepends validates the ordering-only dependencies of modules,
+// which must be other modules that are not in later groups
+func checkModuleDepends(bp Blueprint) error {
+	return bp.WalkModules(func(mod *Module) error {
+		for _, dep := range mod.Depends {
+			if dep == mod.ID {
+				return fmt.Errorf("module %s depends on itself", mod.ID)
+			}
+			if _, err := bp.Module(dep); err != nil {
+				return fmt.Errorf("module %s depends on %w", mod.ID, err)
+			}
+			if bp.GroupIndex(bp.ModuleGroupOrDie(dep).Name) > bp.GroupIndex(bp.ModuleGroupOrDie(mod.ID).Name) {
+				return fmt.Errorf("module %s cannot depend on %s, which is in a later group", mod.ID, dep)
+			}
+		}
+		return nil
+	})
+}
+
 func checkBackend(b TerraformBackend) error {
 	const errMsg = "can not use variables in terraform_backend block, got '%s=%s'"
 	// TerraformBackend.Type is typed as string, "simple" variables and HCL literals stay "as is".
@@ -593,54 +1109,112 @@ func checkBackends(bp Blueprint) error {
 	if err := checkBackend(bp.TerraformBackendDefaults); err != nil {
 		return err
 	}
+	for name, b := range bp.TerraformBackends {
+		if b.Type == "" {
+			return configErrorf("emptyBackendType", ": %s", name)
+		}
+		if err := checkBackend(b); err != nil {
+			return err
+		}
+	}
 	for _, g := range bp.DeploymentGroups {
 		if err := checkBackend(g.TerraformBackend); err != nil {
 			return err
 		}
+		if g.Backend == "" {
+			continue
+		}
+		if _, ok := bp.TerraformBackends[g.Backend]; !ok {
+			return configErrorf("backendNotFound", ": group %s, backend %s", g.Name, g.Backend)
+		}
+		if g.TerraformBackend.Type != "" {
+			return configErrorf("backendAndProfile", ": group %s", g.Name)
+		}
 	}
 	return nil
 }
 
 // validateConfig runs a set of simple early checks on the imported input YAML
-func (dc *DeploymentConfig) validateConfig() {
-	_, err := dc.Config.DeploymentName()
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = dc.Config.checkBlueprintName()
-	if err != nil {
-		log.Fatal(err)
+func (dc *DeploymentConfig) validateConfig(ctx context.Context) error {
+	if _, err := dc.Config.DeploymentName(); err != nil {
+		return err
 	}
-
-	if err = dc.validateVars(); err != nil {
-		log.Fatal(err)
+	if err := dc.Config.checkBlueprintName(); err != nil {
+		return err
 	}
 
-	if err = dc.Config.checkModulesInfo(); err != nil {
-		log.Fatal(err)
+	if err := dc.validateVars(); err != nil {
+		return err
 	}
 
-	if err = checkModulesAndGroups(dc.Config.DeploymentGroups); err != nil {
-		log.Fatal(err)
+	if err := checkModulesAndGroups(dc.Config.DeploymentGroups); err != nil {
+		return err
 	}
 
 	// checkPackerGroups must come after checkModulesAndGroups, in which group
 	// Kind is set and aligned with module Kinds
-	if err = checkPackerGroups(dc.Config.DeploymentGroups); err != nil {
-		log.Fatal(err)
+	if err := checkPackerGroups(dc.Config.DeploymentGroups); err != nil {
+		return err
+	}
+
+	if err := checkUsedModuleNames(dc.Config); err != nil {
+		return err
+	}
+
+	if err := checkModuleDepends(dc.Config); err != nil {
+		return err
+	}
+
+	if err := checkReferenceCycles(dc.Config); err != nil {
+		return err
+	}
+
+	if err := checkNotifications(dc.Config); err != nil {
+		return err
+	}
+
+	if err := checkBackends(dc.Config); err != nil {
+		return err
+	}
+
+	if err := checkImpersonation(dc.Config); err != nil {
+		return err
+	}
+
+	if err := checkRetryPolicies(dc.Config); err != nil {
+		return err
+	}
+
+	for _, v := range dc.Config.Validators {
+		if err := v.checkScope(dc.Config); err != nil {
+			return err
+		}
+		if err := v.checkSkipReason(); err != nil {
+			return err
+		}
 	}
 
-	if err = checkUsedModuleNames(dc.Config); err != nil {
-		log.Fatal(err)
+	// modules are read, and git modules cloned, only once the blueprint has
+	// passed the checks that do not need them
+	if err := dc.Config.checkModulesInfo(ctx); err != nil {
+		return err
 	}
 
-	if err = checkBackends(dc.Config); err != nil {
-		log.Fatal(err)
+	if err := dc.Config.checkSourceHashes(ctx); err != nil {
+		return err
 	}
 
-	if err = checkModuleSettings(dc.Config); err != nil {
-		log.Fatal(err)
+	// deprecated inputs are renamed before settings are checked against the
+	// inputs of modules
+	if err := dc.tracePhase("deprecated input migration", dc.migrateDeprecatedInputs); err != nil {
+		return err
 	}
+
+	if err := checkModuleSettings(dc.Config); err != nil {
+		return err
+	}
+
+	return checkModuleImports(dc.Config)
 }
 
 // SkipValidator marks validator(s) as skipped,
@@ -665,13 +1239,25 @@ func (dc *DeploymentConfig) SkipValidator(name string) error {
 // InputValueError signifies a problem with the blueprint name.
 type InputValueError struct {
 	inputKey string
+	code     ErrorCode
 	cause    string
 }
 
+// newInputValueError returns the InputValueError of errorMessages[key] for
+// the input inputKey
+func newInputValueError(inputKey string, key string) *InputValueError {
+	return &InputValueError{inputKey: inputKey, code: errorCodes[key], cause: errorMessages[key]}
+}
+
 func (err *InputValueError) Error() string {
 	return fmt.Sprintf("%v input error, cause: %v", err.inputKey, err.cause)
 }
 
+// ErrorCode returns the code of the problem with the input
+func (err *InputValueError) ErrorCode() ErrorCode {
+	return err.code
+}
+
 var matchLabelNameExp *regexp.Regexp = regexp.MustCompile(`^[\p{Ll}\p{Lo}][\p{Ll}\p{Lo}\p{N}_-]{0,62}$`)
 var matchLabelValueExp *regexp.Regexp = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}_-]{0,63}$`)
 
@@ -692,55 +1278,126 @@ func isValidLabelValue(value string) bool {
 // DeploymentName returns the deployment_name from the config and does approperate checks.
 func (bp *Blueprint) DeploymentName() (string, error) {
 	if !bp.Vars.Has("deployment_name") {
-		return "", &InputValueError{
-			inputKey: "deployment_name",
-			cause:    errorMessages["varNotFound"],
-		}
+		return "", &MissingVarError{Var: "deployment_name", err: newInputValueError("deployment_name", "varNotFound")}
 	}
 
 	v := bp.Vars.Get("deployment_name")
 	if v.Type() != cty.String {
-		return "", &InputValueError{
-			inputKey: "deployment_name",
-			cause:    errorMessages["valueNotString"],
-		}
+		return "", newInputValueError("deployment_name", "valueNotString")
 	}
 
 	s := v.AsString()
 	if len(s) == 0 {
-		return "", &InputValueError{
-			inputKey: "deployment_name",
-			cause:    errorMessages["valueEmptyString"],
-		}
+		return "", newInputValueError("deployment_name", "valueEmptyString")
 	}
 
 	// Check that deployment_name is a valid label
 	if !isValidLabelValue(s) {
-		return "", &InputValueError{
-			inputKey: "deployment_name",
-			cause:    errorMessages["labelValueReqs"],
-		}
+		return "", newInputValueError("deployment_name", "labelValueReqs")
 	}
 
 	return s, nil
 }
 
+// DeploymentLabel returns the value of the ghpc_deployment label of the
+// resources of an expanded blueprint, which the labels deployment variable may
+// override, or its deployment name
+func (bp *Blueprint) DeploymentLabel() (string, error) {
+	if labels := bp.Vars.Get("labels"); bp.Vars.Has("labels") && labels.Type().IsObjectType() &&
+		labels.Type().HasAttribute(deploymentLabel) {
+		if v := labels.GetAttr(deploymentLabel); v.Type() == cty.String && v.IsKnown() && !v.IsNull() {
+			return v.AsString(), nil
+		}
+	}
+	return bp.DeploymentName()
+}
+
+// DeploymentID returns the ghpc_deployment_id label of the resources of the
+// blueprint, which ghpc create sets, or "" if it has none
+func (bp Blueprint) DeploymentID() string {
+	if labels := bp.Vars.Get("labels"); bp.Vars.Has("labels") && labels.Type().IsObjectType() &&
+		labels.Type().HasAttribute(DeploymentIDLabel) {
+		if v := labels.GetAttr(DeploymentIDLabel); v.Type() == cty.String && v.IsKnown() && !v.IsNull() {
+			return v.AsString()
+		}
+	}
+	return ""
+}
+
+// SetDeploymentID sets the ghpc_deployment_id label of the resources of the
+// blueprint, unless the labels deployment variable already sets it. It must
+// be called before expansion, which copies the labels to Packer modules.
+func (bp *Blueprint) SetDeploymentID(id string) {
+	labels := map[string]cty.Value{}
+	if v := bp.Vars.Get("labels"); bp.Vars.Has("labels") && (v.Type().IsObjectType() || v.Type().IsMapType()) && v.IsKnown() && !v.IsNull() {
+		labels = v.AsValueMap()
+		if labels == nil {
+			labels = map[string]cty.Value{}
+		}
+	} else if bp.Vars.Has("labels") {
+		return // expansion reports labels that are not a map
+	}
+	if _, ok := labels[DeploymentIDLabel]; ok {
+		return
+	}
+	labels[DeploymentIDLabel] = cty.StringVal(id)
+	bp.Vars.Set("labels", cty.ObjectVal(labels))
+}
+
+// NewDeploymentID returns a random ghpc_deployment_id label
+func NewDeploymentID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// readExpandedVars returns the deployment variables of the expanded blueprint
+// of an existing deployment, which are empty if it does not exist
+func readExpandedVars(expandedBlueprintFile string) (Dict, error) {
+	data, err := os.ReadFile(expandedBlueprintFile)
+	if os.IsNotExist(err) {
+		return Dict{}, nil
+	}
+	if err != nil {
+		return Dict{}, configErrorf("fileLoadError", ", filename=%s: %v", expandedBlueprintFile, err)
+	}
+	var bp struct {
+		Vars Dict `yaml:"vars"`
+	}
+	if err := yaml.Unmarshal(data, &bp); err != nil {
+		return Dict{}, configErrorf("yamlUnmarshalError", "", expandedBlueprintFile, err)
+	}
+	return bp.Vars, nil
+}
+
+// Projects returns the projects of the deployment groups: the project_id
+// deployment variable, if it is a string, and the projects that groups
+// override it with
+func (bp Blueprint) Projects() []string {
+	projects := []string{}
+	if v := bp.Vars.Get("project_id"); bp.Vars.Has("project_id") && v.Type() == cty.String && v.IsKnown() && !v.IsNull() {
+		projects = append(projects, v.AsString())
+	}
+	for _, g := range bp.DeploymentGroups {
+		if g.ProjectID != "" && !slices.Contains(projects, g.ProjectID) {
+			projects = append(projects, g.ProjectID)
+		}
+	}
+	return projects
+}
+
 // checkBlueprintName returns an error if blueprint_name does not comply with
 // requirements for correct GCP label values.
 func (bp *Blueprint) checkBlueprintName() error {
 
 	if len(bp.BlueprintName) == 0 {
-		return &InputValueError{
-			inputKey: "blueprint_name",
-			cause:    errorMessages["valueEmptyString"],
-		}
+		return newInputValueError("blueprint_name", "valueEmptyString")
 	}
 
 	if !isValidLabelValue(bp.BlueprintName) {
-		return &InputValueError{
-			inputKey: "blueprint_name",
-			cause:    errorMessages["labelValueReqs"],
-		}
+		return newInputValueError("blueprint_name", "labelValueReqs")
 	}
 
 	return nil
@@ -776,6 +1433,11 @@ func checkModuleSettings(bp Blueprint) error {
 						return false, err
 					}
 				}
+				for _, path := range globalVarPaths(e) {
+					if err := checkGlobalVarPath(bp, *m, path); err != nil {
+						return false, err
+					}
+				}
 			}
 			return true, nil
 		})
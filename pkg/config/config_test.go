@@ -17,6 +17,8 @@ limitations under the License.
 package config
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -25,12 +27,15 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/varsources"
 
 	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -61,10 +66,9 @@ deployment_groups:
 `)
 	testModules = []Module{
 		{
-			Source:           "./modules/network/vpc",
-			Kind:             TerraformKind,
-			ID:               "vpc",
-			WrapSettingsWith: make(map[string][]string),
+			Source: "./modules/network/vpc",
+			Kind:   TerraformKind,
+			ID:     "vpc",
 			Settings: NewDict(map[string]cty.Value{
 				"network_name": cty.StringVal("$\"${var.deployment_name}_net\""),
 				"project_id":   cty.StringVal("project_name"),
@@ -165,18 +169,16 @@ func setTestModuleInfo(mod Module, info modulereader.ModuleInfo) {
 
 func getDeploymentConfigForTest() DeploymentConfig {
 	testModule := Module{
-		Source:           "testSource",
-		Kind:             TerraformKind,
-		ID:               "testModule",
-		Use:              []ModuleID{},
-		WrapSettingsWith: make(map[string][]string),
+		Source: "testSource",
+		Kind:   TerraformKind,
+		ID:     "testModule",
+		Use:    []ModuleID{},
 	}
 	testModuleWithLabels := Module{
-		Source:           "./role/source",
-		ID:               "testModuleWithLabels",
-		Kind:             TerraformKind,
-		Use:              []ModuleID{},
-		WrapSettingsWith: make(map[string][]string),
+		Source: "./role/source",
+		ID:     "testModuleWithLabels",
+		Kind:   TerraformKind,
+		Use:    []ModuleID{},
 		Settings: NewDict(map[string]cty.Value{
 			"moduleLabel": cty.StringVal("moduleLabelValue"),
 		}),
@@ -393,7 +395,7 @@ func getDeploymentConfigWithTestModuleEmptyKind() DeploymentConfig {
 // config.go
 func (s *MySuite) TestExpandConfig(c *C) {
 	dc := getBasicDeploymentConfigWithTestModule()
-	dc.ExpandConfig()
+	dc.ExpandConfig(context.Background())
 }
 
 func (s *MySuite) TestCheckModulesAndGroups(c *C) {
@@ -418,6 +420,278 @@ func (s *MySuite) TestCheckModulesAndGroups(c *C) {
 	}
 }
 
+func (s *MySuite) TestSplitMixedGroups(c *C) {
+	be := TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+		"bucket": cty.StringVal("bkt"),
+		"prefix": cty.StringVal("pre"),
+	})}
+	retry := RetryPolicy{MaxAttempts: 3}
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "net", Modules: []Module{{ID: "network", Kind: TerraformKind}}},
+		{Name: "build", ProjectID: "img-project", TerraformBackend: be, Retry: retry, Modules: []Module{
+			{ID: "script", Kind: TerraformKind},
+			{ID: "bucket", Kind: TerraformKind},
+			{ID: "image", Kind: PackerKind},
+			{ID: "image2", Kind: PackerKind},
+			{ID: "vm", Kind: TerraformKind},
+		}},
+		{Name: "explicit", Kind: TerraformKind, Modules: []Module{
+			{ID: "pony", Kind: PackerKind},
+			{ID: "zebra", Kind: TerraformKind},
+		}},
+	}}
+	c.Assert(bp.splitMixedGroups(), IsNil)
+
+	names := []GroupName{}
+	for _, g := range bp.DeploymentGroups {
+		names = append(names, g.Name)
+	}
+	c.Check(names, DeepEquals, []GroupName{"net", "build-1", "build-2", "build-3", "build-4", "explicit"})
+	c.Check(bp.DeploymentGroups[0].SubgroupOf, Equals, GroupName(""))
+
+	sub := bp.DeploymentGroups[1:5]
+	c.Check(sub[0].Modules, HasLen, 2)
+	for i, kind := range []ModuleKind{TerraformKind, PackerKind, PackerKind, TerraformKind} {
+		c.Check(sub[i].Kind, Equals, kind)
+		c.Check(sub[i].SubgroupOf, Equals, GroupName("build"))
+		c.Check(sub[i].ProjectID, Equals, "img-project")
+		c.Check(sub[i].MatchesName("build"), Equals, true)
+		prefix := sub[i].TerraformBackend.Configuration.Get("prefix")
+		c.Check(prefix, DeepEquals, cty.StringVal("pre/"+string(sub[i].Name)))
+		if kind == TerraformKind {
+			c.Check(sub[i].Retry, DeepEquals, retry)
+		} else {
+			c.Check(sub[i].Retry, DeepEquals, RetryPolicy{})
+		}
+	}
+	c.Check(be.Configuration.Get("prefix"), DeepEquals, cty.StringVal("pre"))
+
+	// groups that set their kind are not split, and fail checks as before
+	c.Check(checkModulesAndGroups(bp.DeploymentGroups), ErrorMatches, "mixing modules of differing kinds.*explicit.*")
+
+	// sub-groups may not take the name of another group
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "build", Modules: []Module{
+			{ID: "script", Kind: TerraformKind},
+			{ID: "image", Kind: PackerKind},
+		}},
+		{Name: "build-2", Modules: []Module{{ID: "vm", Kind: TerraformKind}}},
+	}}
+	err := bp.splitMixedGroups()
+	c.Check(err, ErrorMatches, "group names must be unique: group build is split .* sub-group build-2 has the name of another group.*")
+	c.Check(CodeOf(err), Equals, ErrCodeDuplicateGroup)
+}
+
+func (s *MySuite) TestCheckModuleImports(c *C) {
+	check := func(kind ModuleKind, addr string, id cty.Value) error {
+		bp := Blueprint{
+			Vars: NewDict(map[string]cty.Value{"project_id": cty.StringVal("brownfield")}),
+			DeploymentGroups: []DeploymentGroup{{Name: "zero", Modules: []Module{
+				{ID: "net", Kind: TerraformKind},
+				{ID: "fs", Kind: kind, Imports: NewDict(map[string]cty.Value{addr: id})},
+			}}},
+		}
+		return checkModuleImports(bp)
+	}
+	fsID := GlobalRef("project_id").AsExpression().AsValue()
+
+	c.Check(check(TerraformKind, "google_filestore_instance.filestore_instance", fsID), IsNil)
+	c.Check(check(TerraformKind, `google_compute_subnetwork.subnet["primary"]`, cty.StringVal("sub")), IsNil)
+	c.Check(check(PackerKind, "google_filestore_instance.filestore_instance", fsID),
+		ErrorMatches, ".*only Terraform modules.*")
+	c.Check(check(TerraformKind, "google_filestore_instance", fsID),
+		ErrorMatches, ".*not a resource address.*")
+	c.Check(check(TerraformKind, "data.google_project.p", fsID),
+		ErrorMatches, ".*only managed resources.*")
+	c.Check(check(TerraformKind, "google_filestore_instance.i", ModuleRef("net", "id").AsExpression().AsValue()),
+		ErrorMatches, ".*can only refer to deployment variables.*")
+	c.Check(check(TerraformKind, "google_filestore_instance.i", cty.NumberIntVal(1)),
+		ErrorMatches, ".*not a string.*")
+}
+
+func (s *MySuite) TestCheckModuleDepends(c *C) {
+	check := func(depends ...ModuleID) error {
+		bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "zero", Modules: []Module{{ID: "nat"}, {ID: "pool", Depends: depends}}},
+			{Name: "one", Modules: []Module{{ID: "later"}}},
+		}}
+		return checkModuleDepends(bp)
+	}
+	c.Check(check(), IsNil)
+	c.Check(check("nat"), IsNil)
+	c.Check(check("pool"), ErrorMatches, "module pool depends on itself")
+	c.Check(check("nope"), ErrorMatches, "module pool depends on .*: nope")
+	c.Check(check("later"), ErrorMatches, "module pool cannot depend on later, which is in a later group")
+}
+
+func (s *MySuite) TestCheckReferenceCycles(c *C) {
+	ref := func(m ModuleID, n string) cty.Value { return ModuleRef(m, n).AsExpression().AsValue() }
+	net := Module{ID: "net", Settings: NewDict(map[string]cty.Value{"project_id": GlobalRef("project_id").AsExpression().AsValue()})}
+	fs := Module{ID: "fs", Use: []ModuleID{"net"}}
+	vm := Module{ID: "vm", Use: []ModuleID{"net", "fs"}, Settings: NewDict(map[string]cty.Value{
+		"subnetwork_self_link": ref("net", "subnetwork_self_link")})}
+	bp := func(ms ...Module) Blueprint {
+		return Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "zero", Modules: ms}}}
+	}
+	c.Check(checkReferenceCycles(bp(net, fs, vm)), IsNil)
+
+	{ // a module cannot refer to its own outputs
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{"name": ref("vm", "name")})
+		c.Check(checkReferenceCycles(bp(net, fs, vm)), ErrorMatches,
+			".*cycle.*: module vm setting name → \\$\\(vm.name\\)")
+	}
+
+	{ // the cycle path goes through settings, use and depends
+		net := net
+		net.Settings = NewDict(map[string]cty.Value{
+			"labels": cty.ObjectVal(map[string]cty.Value{"vm": ref("vm", "name")})})
+		fs := fs
+		fs.Use, fs.Depends = nil, []ModuleID{"net"}
+		c.Check(checkReferenceCycles(bp(net, fs, vm)), ErrorMatches,
+			".*cycle.*: module net setting labels → \\$\\(vm.name\\) → module vm use → net")
+
+		vm := vm
+		vm.Use = []ModuleID{"fs"}
+		c.Check(checkReferenceCycles(bp(net, fs, vm)), ErrorMatches,
+			".*cycle.*: module net setting labels → \\$\\(vm.name\\) → module vm use → fs → module fs depends → net")
+	}
+
+	{ // unknown modules are not part of cycles
+		vm := vm
+		vm.Use = []ModuleID{"nope"}
+		c.Check(checkReferenceCycles(bp(net, fs, vm)), IsNil)
+	}
+}
+
+func (s *MySuite) TestApplyGroupVars(c *C) {
+	ref := func(n string) cty.Value { return ModuleRef(groupVarsRoot, n).AsExpression().AsValue() }
+	bp := func() Blueprint {
+		return Blueprint{
+			Vars: NewDict(map[string]cty.Value{"zone": cty.StringVal("us-central1-a")}),
+			DeploymentGroups: []DeploymentGroup{
+				{Name: "a", Vars: NewDict(map[string]cty.Value{"net": cty.StringVal("net-a")}), Modules: []Module{
+					{ID: "vm", Settings: NewDict(map[string]cty.Value{
+						"network":  ref("net"),
+						"networks": cty.TupleVal([]cty.Value{ref("net")}),
+						"zone":     GlobalRef("zone").AsExpression().AsValue()})}}},
+				{Name: "b", Modules: []Module{{ID: "other"}}},
+			}}
+	}
+
+	{ // references are replaced by the values of the group vars
+		bp := bp()
+		c.Assert(bp.applyGroupVars(), IsNil)
+		vm, _ := bp.Module("vm")
+		c.Check(vm.Settings.Get("network"), DeepEquals, cty.StringVal("net-a"))
+		c.Check(vm.Settings.Get("networks"), DeepEquals, cty.TupleVal([]cty.Value{cty.StringVal("net-a")}))
+		_, isExpr := IsExpressionValue(vm.Settings.Get("zone"))
+		c.Check(isExpr, Equals, true)
+	}
+
+	{ // modules cannot refer to the vars of other groups
+		bp := bp()
+		bp.DeploymentGroups[1].Modules[0].Settings = NewDict(map[string]cty.Value{"network": ref("net")})
+		c.Check(bp.applyGroupVars(), ErrorMatches, "module other setting network: group b has no variable net; .*")
+	}
+
+	{ // group vars cannot shadow deployment vars
+		bp := bp()
+		bp.DeploymentGroups[0].Vars.Set("zone", cty.StringVal("us-east1-b"))
+		c.Check(bp.applyGroupVars(), ErrorMatches, "group a defines variable zone, which is already a deployment variable; .*")
+	}
+
+	{ // module "group" cannot be told apart from group vars
+		bp := bp()
+		bp.DeploymentGroups[1].Modules[0].ID = groupVarsRoot
+		c.Check(bp.applyGroupVars(), ErrorMatches, "module ID group is reserved .*")
+
+		bp.DeploymentGroups[0].Vars = Dict{}
+		bp.DeploymentGroups[0].Modules[0].Settings = NewDict(map[string]cty.Value{})
+		c.Check(bp.applyGroupVars(), IsNil)
+	}
+}
+
+func (s *MySuite) TestApplyExternalReferences(c *C) {
+	ref := func(s string) cty.Value { return MustParseExpression(s).AsValue() }
+	bp := func() Blueprint {
+		return Blueprint{
+			ExternalDeployments: map[string]ExternalDeployment{
+				"network": {TerraformBackend: TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+					"bucket": cty.StringVal("tf-state"),
+					"prefix": cty.StringVal("hpc-network/shared/{{group}}"),
+				})}},
+			},
+			DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{
+				{ID: "vm", Kind: TerraformKind, Settings: NewDict(map[string]cty.Value{
+					"network":  ref("module.external.network.primary.network_name"),
+					"subnet":   ref(`module.external.network.primary.subnetworks[0]`),
+					"networks": cty.TupleVal([]cty.Value{ref("module.external.network.primary.network_name")}),
+					"zone":     GlobalRef("zone").AsExpression().AsValue()})}}}},
+		}
+	}
+
+	{ // references are replaced by the outputs of remote states
+		bp := bp()
+		c.Assert(bp.applyExternalReferences(), IsNil)
+		vm, _ := bp.Module("vm")
+		c.Check(vm.Settings.Get("network"), DeepEquals,
+			ref(`data.terraform_remote_state.network["primary"].outputs.network_name`))
+		c.Check(vm.Settings.Get("subnet"), DeepEquals,
+			ref(`data.terraform_remote_state.network["primary"].outputs.subnetworks[0]`))
+		c.Check(vm.Settings.Get("networks"), DeepEquals, cty.TupleVal([]cty.Value{
+			ref(`data.terraform_remote_state.network["primary"].outputs.network_name`)}))
+		c.Check(vm.Settings.Get("zone"), DeepEquals, GlobalRef("zone").AsExpression().AsValue())
+
+		states, err := bp.RemoteStates(bp.DeploymentGroups[0])
+		c.Assert(err, IsNil)
+		c.Check(states, DeepEquals, []RemoteState{{
+			Name:    "network",
+			Backend: "gcs",
+			Configs: map[GroupName]Dict{"primary": NewDict(map[string]cty.Value{
+				"bucket": cty.StringVal("tf-state"),
+				"prefix": cty.StringVal("hpc-network/shared/primary"),
+			})},
+		}})
+	}
+
+	{ // the external deployment must be defined
+		bp := bp()
+		bp.DeploymentGroups[0].Modules[0].Settings.Set("network", ref("module.external.storage.primary.network_name"))
+		c.Check(bp.applyExternalReferences(), ErrorMatches,
+			"module vm setting network: external deployment storage is not defined in external_deployments, expected one of network")
+	}
+
+	{ // outputs of external deployments cannot be used in expressions
+		bp := bp()
+		bp.DeploymentGroups[0].Modules[0].Settings.Set("network",
+			ref(`"${module.external.network.primary.network_name}-${var.zone}"`))
+		c.Check(bp.applyExternalReferences(), ErrorMatches, "module vm setting network: the outputs of external deployments can only be referenced on their own.*")
+	}
+
+	{ // module "external" cannot be told apart from external deployments
+		bp := bp()
+		bp.DeploymentGroups[0].Modules = append(bp.DeploymentGroups[0].Modules, Module{ID: externalRoot})
+		c.Check(bp.applyExternalReferences(), ErrorMatches, "module ID external is reserved .*")
+	}
+
+	{ // only the group of the external deployment is filled in
+		bp := bp()
+		d := bp.ExternalDeployments["network"]
+		d.TerraformBackend.Configuration = NewDict(map[string]cty.Value{"prefix": cty.StringVal("{{deployment_name}}/{{group}}")})
+		bp.ExternalDeployments["network"] = d
+		c.Check(bp.applyExternalReferences(), ErrorMatches, "external deployment network: prefix can only use the placeholder {{group}}, got {{deployment_name}}")
+	}
+
+	{ // without external deployments, references are to module "external"
+		bp := bp()
+		bp.ExternalDeployments = nil
+		c.Assert(bp.applyExternalReferences(), IsNil)
+		vm, _ := bp.Module("vm")
+		c.Check(vm.Settings.Get("network"), DeepEquals, ref("module.external.network.primary.network_name"))
+	}
+}
+
 func (s *MySuite) TestListUnusedModules(c *C) {
 	{ // No modules in "use"
 		m := Module{ID: "m"}
@@ -457,6 +731,39 @@ func (s *MySuite) TestListUnusedDeploymentVariables(c *C) {
 	c.Assert(unusedVars, DeepEquals, []string{"unused_key"})
 }
 
+func (s *MySuite) TestGlobalVarNamespaces(c *C) {
+	network := cty.ObjectVal(map[string]cty.Value{
+		"cidr": cty.StringVal("10.0.0.0/16"),
+		"name": cty.StringVal("hpc"),
+		"nat":  cty.ObjectVal(map[string]cty.Value{"enabled": cty.True, "ports": cty.NumberIntVal(64)}),
+	})
+	slurm := cty.MapVal(map[string]cty.Value{"max_nodes": cty.NumberIntVal(20)})
+	vars := NewDict(map[string]cty.Value{
+		"network": network,
+		"slurm":   slurm,
+		"zones":   cty.ListVal([]cty.Value{cty.StringVal("us-central1-a")}),
+		"unused":  cty.StringVal("x"),
+	})
+
+	paths := globalVarPaths(MustParseExpression(`"${var.network.cidr}-${var.slurm["max_nodes"]}-${var.zones[0]}"`))
+	c.Check(paths, DeepEquals, [][]string{{"network", "cidr"}, {"slurm", "max_nodes"}, {"zones"}})
+
+	c.Check(unusedGlobalVars(vars, paths), DeepEquals, []string{"network.name", "network.nat", "unused"})
+	c.Check(unusedGlobalVars(vars, append(paths, []string{"network", "nat", "ports"}, []string{"unused"})),
+		DeepEquals, []string{"network.name", "network.nat.enabled"})
+	// using a whole namespace uses all of its leaves
+	c.Check(unusedGlobalVars(vars, append(paths, []string{"network"}, []string{"unused"})), DeepEquals, []string{})
+
+	bp := Blueprint{Vars: vars}
+	m := Module{ID: "m"}
+	c.Check(checkGlobalVarPath(bp, m, []string{"network", "nat", "enabled"}), IsNil)
+	c.Check(checkGlobalVarPath(bp, m, []string{"slurm", "max_nodes"}), IsNil)
+	c.Check(checkGlobalVarPath(bp, m, []string{"zones", "a"}), IsNil)
+	c.Check(checkGlobalVarPath(bp, m, []string{"network", "cidrs"}), ErrorMatches,
+		`module "m" references unknown global variable "network.cidrs": network has no "cidrs"`)
+	c.Check(checkGlobalVarPath(bp, m, []string{"slurm", "min_nodes"}), NotNil)
+}
+
 func (s *MySuite) TestAddKindToModules(c *C) {
 	/* Test addKindToModules() works when nothing to do */
 	dc := getBasicDeploymentConfigWithTestModule()
@@ -516,6 +823,125 @@ func (s *MySuite) TestGetModule(c *C) {
 	}
 }
 
+func (s *MySuite) TestDeploymentLabelAndProjects(c *C) {
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("hpc"),
+			"project_id":      cty.StringVal("hpc-project"),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary"},
+			{Name: "storage", ProjectID: "storage-project"},
+			{Name: "again", ProjectID: "hpc-project"},
+		},
+	}
+	label, err := bp.DeploymentLabel()
+	c.Check(err, IsNil)
+	c.Check(label, Equals, "hpc")
+	c.Check(bp.Projects(), DeepEquals, []string{"hpc-project", "storage-project"})
+
+	// the labels deployment variable overrides the label
+	bp.Vars.Set("labels", cty.ObjectVal(map[string]cty.Value{"ghpc_deployment": cty.StringVal("navy")}))
+	label, err = bp.DeploymentLabel()
+	c.Check(err, IsNil)
+	c.Check(label, Equals, "navy")
+}
+
+func (s *MySuite) TestDeploymentID(c *C) {
+	bp := Blueprint{Vars: NewDict(map[string]cty.Value{"deployment_name": cty.StringVal("hpc")})}
+	c.Check(bp.DeploymentID(), Equals, "")
+
+	id := NewDeploymentID()
+	c.Check(id, Matches, "[0-9a-f]{16}")
+	c.Check(NewDeploymentID(), Not(Equals), id)
+	bp.SetDeploymentID(id)
+	c.Check(bp.DeploymentID(), Equals, id)
+	// ids are not replaced, nor are the other labels
+	bp.Vars.Set("labels", cty.ObjectVal(map[string]cty.Value{
+		"team":            cty.StringVal("hpc"),
+		DeploymentIDLabel: cty.StringVal("set-by-user"),
+	}))
+	bp.SetDeploymentID(id)
+	c.Check(bp.DeploymentID(), Equals, "set-by-user")
+	c.Check(bp.Vars.Get("labels").GetAttr("team"), Equals, cty.StringVal("hpc"))
+
+	// the id of an existing deployment is read from its expanded blueprint
+	dir := c.MkDir()
+	expanded := filepath.Join(dir, "expanded_blueprint.yaml")
+	dc := DeploymentConfig{}
+	c.Check(dc.SetDeploymentDir(dir, expanded), IsNil)
+	c.Check(dc.PreviousDeploymentID(), Equals, "")
+	c.Assert(os.WriteFile(expanded, []byte(`blueprint_name: hpc
+vars:
+  deployment_name: hpc
+  labels:
+    ghpc_deployment: hpc
+    ghpc_deployment_id: 0123456789abcdef
+deployment_groups: []
+`), 0644), IsNil)
+	c.Check(dc.SetDeploymentDir(dir, expanded), IsNil)
+	c.Check(dc.PreviousDeploymentID(), Equals, "0123456789abcdef")
+}
+
+func (s *MySuite) TestSlurmClusters(c *C) {
+	controller := Module{
+		ID:       "controller",
+		Source:   "community/modules/scheduler/schedmd-slurm-gcp-v5-controller",
+		Settings: NewDict(nil),
+	}
+	named := Module{
+		ID:     "named",
+		Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-controller",
+		Settings: NewDict(map[string]cty.Value{
+			"slurm_cluster_name":     cty.StringVal("blue"),
+			"project_id":             cty.StringVal("blue-project"),
+			"enable_cleanup_compute": cty.True,
+		}),
+	}
+	hybrid := Module{
+		ID:     "hybrid",
+		Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-hybrid",
+		Settings: NewDict(map[string]cty.Value{
+			"slurm_cluster_name": MustParseExpression("module.names.cluster").AsValue(),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("hpc-slurm-cluster"),
+			"project_id":      cty.StringVal("hpc-project"),
+			"region":          cty.StringVal("us-central1"),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{{ID: "vpc", Source: "modules/network/vpc", Settings: NewDict(nil)}}},
+			{Name: "cluster", Modules: []Module{controller, named, hybrid}},
+		},
+	}
+
+	c.Check(bp.SlurmClusters("primary"), HasLen, 0)
+	c.Check(bp.SlurmClusters("missing"), HasLen, 0)
+	// the name of the hybrid cluster depends upon a module output
+	c.Check(bp.SlurmClusters("cluster"), DeepEquals, []SlurmCluster{
+		{Module: "controller", Name: "hpcslurmcl", ProjectID: "hpc-project", Region: "us-central1",
+			DeploymentLabel: "hpc-slurm-cluster", Truncated: true},
+		{Module: "named", Name: "blue", ProjectID: "blue-project", Region: "us-central1",
+			DeploymentLabel: "hpc-slurm-cluster", CleansUp: true},
+	})
+
+	for in, want := range map[string]struct {
+		name      string
+		truncated bool
+	}{
+		"hpc":                 {"hpc", false},
+		"42-Big.Cluster":      {"bigcluster", false},
+		"1-HPC_slurm-cluster": {"hpcslurmcl", true},
+		"research-cluster-1":  {"researchcl", true},
+	} {
+		name, truncated := defaultSlurmClusterName(in)
+		c.Check(name, Equals, want.name)
+		c.Check(truncated, Equals, want.truncated)
+	}
+}
+
 func (s *MySuite) TestDeploymentName(c *C) {
 	bp := Blueprint{}
 	var e *InputValueError
@@ -561,6 +987,9 @@ func (s *MySuite) TestDeploymentName(c *C) {
 	dn, err = bp.DeploymentName()
 	c.Assert(dn, Equals, "")
 	c.Check(errors.As(err, &e), Equals, true)
+	missing, ok := MissingVar(err)
+	c.Check(ok, Equals, true)
+	c.Check(missing, Equals, "deployment_name")
 }
 
 func (s *MySuite) TestCheckBlueprintName(c *C) {
@@ -597,17 +1026,58 @@ func (s *MySuite) TestCheckBlueprintName(c *C) {
 	c.Check(errors.As(err, &e), Equals, true)
 }
 
+func (s *MySuite) TestErrorCodes(c *C) {
+	{ // errors of errorMessages have the code of their message
+		err := configErrorf("referenceCycle", ": %s", "a → b → a")
+		c.Check(err, ErrorMatches, errorMessages["referenceCycle"]+": a → b → a")
+		c.Check(CodeOf(err), Equals, ErrCodeReferenceCycle)
+		c.Check(CodeOf(fmt.Errorf("wrapped: %w", err)), Equals, ErrCodeReferenceCycle)
+	}
+
+	{ // every message has a code, and no two messages share one
+		seen := map[ErrorCode]string{}
+		for key := range errorMessages {
+			code, ok := errorCodes[key]
+			c.Check(ok, Equals, true, Commentf("%s has no code", key))
+			c.Check(seen[code], Equals, "", Commentf("%s and %s share %s", key, seen[code], code))
+			seen[code] = key
+		}
+	}
+
+	{ // typed errors have codes
+		bp := Blueprint{Vars: NewDict(map[string]cty.Value{"deployment_name": cty.StringVal("")})}
+		_, err := bp.DeploymentName()
+		c.Check(CodeOf(err), Equals, ErrCodeValueEmptyString)
+		c.Check(CodeOf(&ValidatorTimeoutError{}), Equals, ErrCodeValidatorTimeout)
+		c.Check(CodeOf(errors.New("uncoded")), Equals, ErrorCode(""))
+	}
+
+	{ // errors without a code are given the code of the phase
+		c.Check(CodeOf(withCode(ErrCodeExpansion, errors.New("uncoded"))), Equals, ErrCodeExpansion)
+		err := configErrorf("invalidMod", ": %s", "x")
+		c.Check(withCode(ErrCodeExpansion, err), Equals, err)
+		c.Check(withCode(ErrCodeExpansion, nil), IsNil)
+
+		_, err = NewDeploymentConfig(filepath.Join(tmpTestDir, "missing.yaml"))
+		c.Check(CodeOf(err), Equals, ErrCodeFileLoad)
+	}
+}
+
 func (s *MySuite) TestNewBlueprint(c *C) {
 	dc := getDeploymentConfigForTest()
 	outFile := filepath.Join(tmpTestDir, "out_TestNewBlueprint.yaml")
 	c.Assert(dc.ExportBlueprint(outFile), IsNil)
 	newDC, err := NewDeploymentConfig(outFile)
 	c.Assert(err, IsNil)
+	// the reasons of default validators are not exported
+	for i := range dc.Config.Validators {
+		dc.Config.Validators[i].reason = ""
+	}
 	c.Assert(dc.Config, DeepEquals, newDC.Config)
 }
 
 func (s *MySuite) TestImportBlueprint(c *C) {
-	obtainedBlueprint, err := importBlueprint(simpleYamlFilename)
+	obtainedBlueprint, _, err := importBlueprint(simpleYamlFilename, "")
 	c.Assert(err, IsNil)
 	c.Assert(obtainedBlueprint.BlueprintName,
 		Equals, expectedSimpleBlueprint.BlueprintName)
@@ -725,10 +1195,351 @@ dragon: "Lews Therin Telamon"`)
 	file.Close()
 
 	// should fail on strict unmarshal as field does not match schema
-	_, err := importBlueprint(filename)
+	_, _, err := importBlueprint(filename, "")
 	c.Check(err, NotNil)
 }
 
+func (s *MySuite) TestImportBlueprintStream(c *C) {
+	stream := `# license header
+---
+blueprint_name: dev
+vars:
+  deployment_name: dev
+deployment_groups: []
+---
+blueprint_name: prod # production
+vars:
+  deployment_name: prod
+deployment_groups: []
+`
+	filename := filepath.Join(c.MkDir(), "stream.yaml")
+	c.Assert(os.WriteFile(filename, []byte(stream), 0644), IsNil)
+
+	{ // the named blueprint is selected
+		bp, doc, err := importBlueprint(filename, "prod")
+		c.Assert(err, IsNil)
+		c.Check(bp.BlueprintName, Equals, "prod")
+		// lines are those of the file
+		c.Check(doc.Content[0].Content[0].Line, Equals, 8)
+	}
+
+	{ // a name is required
+		_, _, err := importBlueprint(filename, "")
+		c.Check(CodeOf(err), Equals, ErrCodeMultipleBlueprints)
+		c.Check(err, ErrorMatches, ".* has dev, prod")
+	}
+
+	{ // the name must be in the file
+		_, _, err := importBlueprint(filename, "test")
+		c.Check(CodeOf(err), Equals, ErrCodeBlueprintNotFound)
+	}
+
+	{ // the name must be unique
+		dup := filepath.Join(c.MkDir(), "dup.yaml")
+		c.Assert(os.WriteFile(dup, []byte(stream+"---\nblueprint_name: prod\n"), 0644), IsNil)
+		_, _, err := importBlueprint(dup, "prod")
+		c.Check(CodeOf(err), Equals, ErrCodeDuplicateBlueprint)
+	}
+
+	{ // streams are not formatted
+		_, err := FormatBlueprint([]byte(stream))
+		c.Check(err, ErrorMatches, "files of several blueprints cannot be formatted")
+	}
+
+	{ // files of a single blueprint need no name, but must match one given
+		bp, _, err := importBlueprint(simpleYamlFilename, "")
+		c.Assert(err, IsNil)
+		_, _, err = importBlueprint(simpleYamlFilename, bp.BlueprintName)
+		c.Check(err, IsNil)
+		_, _, err = importBlueprint(simpleYamlFilename, "other")
+		c.Check(CodeOf(err), Equals, ErrCodeBlueprintNotFound)
+	}
+}
+
+func (s *MySuite) TestNewDeploymentConfigFromData(c *C) {
+	data, err := os.ReadFile(simpleYamlFilename)
+	c.Assert(err, IsNil)
+
+	dc, err := NewDeploymentConfigFromData("stdin", data, "")
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.BlueprintName, Equals, expectedSimpleBlueprint.BlueprintName)
+
+	// the exported blueprint is that written by ExportBlueprint
+	out, err := dc.MarshalBlueprint(ExportOptions{})
+	c.Assert(err, IsNil)
+	filename := filepath.Join(c.MkDir(), "expanded.yaml")
+	c.Assert(dc.ExportBlueprint(filename), IsNil)
+	written, err := os.ReadFile(filename)
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, string(written))
+
+	_, err = NewDeploymentConfigFromData("stdin", []byte("blueprint_name: [\n"), "")
+	c.Check(err, ErrorMatches, ".*failed to parse the blueprint in stdin.*")
+	c.Check(CodeOf(err), Equals, ErrCodeYamlUnmarshal)
+
+	encrypted := []byte("vars:\n  password: ENC[AES256_GCM,data:x]\nsops:\n  version: 3.8.1\n")
+	_, err = NewDeploymentConfigFromData("stdin", encrypted, "")
+	c.Check(err, ErrorMatches, "stdin: blueprints encrypted with sops must be read from a file")
+}
+
+func (s *MySuite) TestDropDisabledModules(c *C) {
+	bp := `
+blueprint_name: optional
+vars:
+  project_id: test-project
+  deployment_name: optional
+  create_filestore: false
+deployment_groups:
+- group: primary
+  modules:
+  - id: network
+    source: mock/network
+    kind: mock
+    mock:
+      outputs: [network_self_link]
+  - id: homefs
+    source: mock/filestore
+    kind: mock
+    enabled: $(vars.create_filestore)
+    use: [network]
+    mock:
+      inputs: [network_self_link]
+      outputs: [network_storage]
+  - id: vm
+    source: mock/vm
+    kind: mock
+    use: [network, homefs]
+    mock:
+      inputs: [network_self_link, network_storage]
+- group: monitoring
+  modules:
+  - id: dashboard
+    source: mock/dashboard
+    kind: mock
+    enabled: false
+`
+	expand := func(bp string) (DeploymentConfig, error) {
+		dc, err := NewDeploymentConfigFromData("test", []byte(bp), "")
+		c.Assert(err, IsNil)
+		dc.Config.ValidationLevel = ValidationIgnore
+		return dc, dc.ExpandConfig(context.Background())
+	}
+
+	dc, err := expand(bp)
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.DeploymentGroups, HasLen, 1)
+	c.Check(dc.Config.MockModules(), DeepEquals, []ModuleID{"network", "vm"})
+	vm, err := dc.Config.Module("vm")
+	c.Assert(err, IsNil)
+	c.Check(vm.Use, DeepEquals, []ModuleID{"network"})
+	c.Check(vm.Settings.Has("network_storage"), Equals, false)
+
+	dc, err = expand(strings.Replace(bp, "create_filestore: false", "create_filestore: true", 1))
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.MockModules(), DeepEquals, []ModuleID{"network", "homefs", "vm"})
+	homefs, err := dc.Config.Module("homefs")
+	c.Assert(err, IsNil)
+	c.Check(homefs.Enabled, IsNil)
+
+	// outputs of disabled modules cannot be referred to
+	_, err = expand(strings.Replace(bp, "use: [network, homefs]", "use: [network]\n    settings:\n      network_storage: $(homefs.network_storage)", 1))
+	c.Check(err, ErrorMatches, ".*module vm: setting network_storage refers to module homefs, which is disabled")
+
+	_, err = expand(strings.Replace(bp, "create_filestore: false", "create_filestore: maybe", 1))
+	c.Check(err, ErrorMatches, ".*module homefs: enabled must be a boolean known before deployment, got string")
+
+	// a group already written to the deployment directory keeps its state
+	deployDir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(deployDir, "monitoring"), 0755), IsNil)
+	dc, err = NewDeploymentConfigFromData("test", []byte(bp), "")
+	c.Assert(err, IsNil)
+	dc.Config.ValidationLevel = ValidationIgnore
+	c.Assert(dc.SetDeploymentDir(deployDir, filepath.Join(deployDir, "expanded_blueprint.yaml")), IsNil)
+	err = dc.ExpandConfig(context.Background())
+	c.Check(err, ErrorMatches, ".*deployment group monitoring has no enabled modules, but was written to .*")
+}
+
+func (s *MySuite) TestResolveModuleAliases(c *C) {
+	vpc := "github.com/GoogleCloudPlatform/hpc-toolkit//modules/network/vpc?ref=v1.25.0"
+	bp := Blueprint{
+		ModuleAliases: map[string]string{"vpc": vpc},
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{
+			{ID: "network", Source: "vpc"},
+			{ID: "homefs", Source: "modules/file-system/filestore"},
+		}}},
+	}
+	c.Assert(bp.resolveModuleAliases(), IsNil)
+	c.Check(bp.DeploymentGroups[0].Modules[0].Source, Equals, vpc)
+	c.Check(bp.DeploymentGroups[0].Modules[1].Source, Equals, "modules/file-system/filestore")
+
+	bp.ModuleAliases = map[string]string{"modules/vpc": vpc}
+	c.Check(bp.resolveModuleAliases(), ErrorMatches, `module alias "modules/vpc": .*`)
+
+	bp.ModuleAliases = map[string]string{"vpc": ""}
+	c.Check(bp.resolveModuleAliases(), ErrorMatches, "module alias vpc: a module source cannot be empty")
+
+	bp.ModuleAliases = map[string]string{"vpc": "network", "network": vpc}
+	c.Check(bp.resolveModuleAliases(), ErrorMatches, "module alias vpc: aliases must name module sources .*")
+}
+
+func (s *MySuite) TestMockModules(c *C) {
+	bp := `
+blueprint_name: mocked
+vars:
+  project_id: test-project
+  deployment_name: mocked
+deployment_groups:
+- group: primary
+  modules:
+  - id: network
+    source: mock/network
+    kind: mock
+    mock:
+      inputs:
+      - name: project_id
+        required: true
+      outputs:
+      - network_self_link
+  - id: vm
+    source: mock/vm
+    kind: mock
+    use: [network]
+    mock:
+      inputs:
+      - project_id
+      - network_self_link
+      - {name: labels, type: "map(string)"}
+      - name_prefix
+    settings:
+      name_prefix: $(network.network_self_link)
+`
+	expand := func(bp string) (DeploymentConfig, error) {
+		dc, err := NewDeploymentConfigFromData("test", []byte(bp), "")
+		c.Assert(err, IsNil)
+		dc.Config.ValidationLevel = ValidationIgnore
+		return dc, dc.ExpandConfig(context.Background())
+	}
+
+	dc, err := expand(bp)
+	c.Assert(err, IsNil)
+	// mock modules are grouped as Terraform modules
+	c.Check(dc.Config.DeploymentGroups[0].Kind, Equals, TerraformKind)
+	c.Check(dc.Config.MockModules(), DeepEquals, []ModuleID{"network", "vm"})
+	vm := dc.Config.DeploymentGroups[0].Modules[1]
+	_, used := HasMark[ProductOfModuleUse](vm.Settings.Get("network_self_link"))
+	c.Check(used, Equals, true)
+
+	// settings are checked against the declared inputs
+	_, err = expand(strings.Replace(bp, "name_prefix: $(network.network_self_link)", "bogus: 1", 1))
+	c.Check(err, ErrorMatches, "(?s).*Setting: bogus.*")
+
+	// mock modules of the same source declare the same inputs and outputs
+	_, err = expand(strings.Replace(bp, "source: mock/vm", "source: mock/network", 1))
+	c.Check(err, ErrorMatches,
+		"mock modules network and vm have source mock/network but declare different inputs or outputs")
+
+	// only mock modules declare their inputs and outputs
+	_, err = expand(strings.Replace(bp, "source: mock/vm\n    kind: mock", "source: mock/vm", 1))
+	c.Check(err, ErrorMatches, "module vm: mock inputs and outputs are only supported by modules of kind mock")
+}
+
+func (s *MySuite) TestDefaultLocation(c *C) {
+	bp := `
+blueprint_name: located
+vars:
+  project_id: test-project
+  deployment_name: located
+  region: us-east1
+deployment_groups:
+- group: primary
+  modules:
+  - id: vm
+    source: mock/zonal
+    kind: mock
+    mock:
+      inputs:
+      - project_id
+      - {name: zone, required: true}
+`
+	expand := func(bp string, s ZoneSelector) (DeploymentConfig, error) {
+		dc, err := NewDeploymentConfigFromData("test", []byte(bp), "")
+		c.Assert(err, IsNil)
+		dc.Config.ValidationLevel = ValidationIgnore
+		dc.SelectZonesWith(s)
+		return dc, dc.ExpandConfig(context.Background())
+	}
+
+	// the first zone of us-east1 is b
+	dc, err := expand(bp, nil)
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Get("zone"), DeepEquals, cty.StringVal("us-east1-b"))
+	c.Check(dc.Config.DeploymentGroups[0].Modules[0].Settings.Get("zone"), DeepEquals,
+		GlobalRef("zone").AsExpression().AsValue())
+	out, err := dc.MarshalBlueprint(ExportOptions{})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Matches, "(?s).*zone: us-east1-b # chosen by ghpc as the first zone of region us-east1\n.*")
+	validators := []string{}
+	for _, v := range dc.Config.Validators {
+		validators = append(validators, v.Validator)
+	}
+	c.Check(validators, DeepEquals, []string{"test_module_not_used", "test_deployment_variable_not_used",
+		"test_project_exists", "test_apis_enabled", "test_region_exists", "test_zone_exists", "test_zone_in_region",
+		"test_zone_available"})
+
+	// the zone selector is used, falling back to the first zone if it fails
+	dc, err = expand(bp, func(_ context.Context, project string, region string) (string, error) {
+		return region + "-d", nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Get("zone"), DeepEquals, cty.StringVal("us-east1-d"))
+	dc, err = expand(bp, func(context.Context, string, string) (string, error) {
+		return "", errors.New("no credentials")
+	})
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Get("zone"), DeepEquals, cty.StringVal("us-east1-b"))
+
+	// an existing deployment keeps the zone of its previous expansion in the
+	// region, without asking the zone selector
+	deployDir := c.MkDir()
+	expanded := filepath.Join(deployDir, "expanded_blueprint.yaml")
+	c.Assert(os.WriteFile(expanded, []byte("vars:\n  region: us-east1\n  zone: us-east1-c\n"), 0644), IsNil)
+	redeploy := func(bp string) (DeploymentConfig, error) {
+		dc, err := NewDeploymentConfigFromData("test", []byte(bp), "")
+		c.Assert(err, IsNil)
+		dc.Config.ValidationLevel = ValidationIgnore
+		c.Assert(dc.SetDeploymentDir(deployDir, expanded), IsNil)
+		dc.SelectZonesWith(func(context.Context, string, string) (string, error) {
+			c.Error("zone selector called for an existing deployment")
+			return "", errors.New("unexpected")
+		})
+		return dc, dc.ExpandConfig(context.Background())
+	}
+	dc, err = redeploy(bp)
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Get("zone"), DeepEquals, cty.StringVal("us-east1-c"))
+	out, err = dc.MarshalBlueprint(ExportOptions{})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Matches, "(?s).*zone: us-east1-c # kept by ghpc from the previous expansion .*")
+	// unless the region changed
+	c.Assert(os.WriteFile(expanded, []byte("vars:\n  region: us-west1\n  zone: us-west1-a\n"), 0644), IsNil)
+	c.Assert(dc.SetDeploymentDir(deployDir, expanded), IsNil)
+	_, ok := dc.previousZone("us-east1")
+	c.Check(ok, Equals, false)
+
+	// the zone is not chosen for modules that set it
+	dc, err = expand(bp+"    settings:\n      zone: us-east1-c\n", nil)
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Has("zone"), Equals, false)
+
+	// the region is that of the zone
+	regional := strings.Replace(strings.Replace(bp, "region: us-east1", "zone: europe-west4-a", 1),
+		"{name: zone, required: true}", "{name: region, required: true}", 1)
+	regional = strings.Replace(regional, "mock/zonal", "mock/regional", 1)
+	dc, err = expand(regional, nil)
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Get("region"), DeepEquals, cty.StringVal("europe-west4"))
+}
+
 func (s *MySuite) TestExportBlueprint(c *C) {
 	dc := DeploymentConfig{Config: expectedSimpleBlueprint}
 	outFilename := "out_TestExportBlueprint.yaml"
@@ -741,6 +1552,234 @@ func (s *MySuite) TestExportBlueprint(c *C) {
 	c.Assert(fileInfo.IsDir(), Equals, false)
 }
 
+func (s *MySuite) TestExportBlueprintComments(c *C) {
+	dir := c.MkDir()
+	bpFile := filepath.Join(dir, "commented.yaml")
+	c.Assert(os.WriteFile(bpFile, []byte(`# Licensed under the Apache License, Version 2.0
+blueprint_name: commented
+
+vars:
+  # where the cluster runs
+  region: us-central1
+
+deployment_groups:
+- group: primary
+  modules:
+  - id: network # shared by all groups
+    source: modules/network/vpc
+    use: [] # nothing to use
+`), 0644), IsNil)
+	dc, err := NewDeploymentConfig(bpFile)
+	c.Assert(err, IsNil)
+	dc.Config.Vars.Set("deployment_name", cty.StringVal("commented"))
+
+	outFile := filepath.Join(dir, "expanded.yaml")
+	c.Assert(dc.ExportBlueprint(outFile), IsNil)
+	out, err := os.ReadFile(outFile)
+	c.Assert(err, IsNil)
+	got := string(out)
+	c.Check(got, Matches, `(?s).*  # where the cluster runs\n  region: us-central1\n.*`)
+	c.Check(got, Matches, `(?s).*id: network # shared by all groups\n.*`)
+	c.Check(got, Matches, `(?s).*use: \[\] # nothing to use\n.*`)
+	// the license of the blueprint is replaced by the license of the export
+	c.Check(strings.Count(got, "Licensed under the Apache License"), Equals, 1)
+}
+
+func (s *MySuite) TestSopsEncryptedBlueprint(c *C) {
+	dir := c.MkDir()
+	bpFile := filepath.Join(dir, "encrypted.yaml")
+	c.Assert(os.WriteFile(bpFile, []byte(`blueprint_name: encrypted
+vars:
+  deployment_name: encrypted
+  db_password: ENC[AES256_GCM,data:c2VjcmV0,iv:aXY=,tag:dGFn,type:str]
+deployment_groups:
+- group: primary
+  modules:
+  - id: network
+    source: modules/network/vpc
+sops:
+  gcp_kms:
+  - resource_id: projects/p/locations/global/keyRings/r/cryptoKeys/k
+  mac: ENC[AES256_GCM,data:bWFj,iv:aXY=,tag:dGFn,type:str]
+  version: 3.7.3
+`), 0644), IsNil)
+	// stands in for the output of sops --decrypt
+	decrypted.m[bpFile] = []byte(`blueprint_name: encrypted
+vars:
+  deployment_name: encrypted
+  db_password: secret
+deployment_groups:
+- group: primary
+  modules:
+  - id: network
+    source: modules/network/vpc
+`)
+	defer delete(decrypted.m, bpFile)
+
+	dc, err := NewDeploymentConfig(bpFile)
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Get("db_password"), DeepEquals, cty.StringVal("secret"))
+	c.Check(dc.Config.Secrets, DeepEquals, []SecretsSource{{File: bpFile, Vars: []string{"db_password"}}})
+
+	outFile := filepath.Join(dir, "expanded.yaml")
+	c.Assert(dc.ExportBlueprint(outFile), IsNil)
+	out, err := os.ReadFile(outFile)
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(out), "secret\n"), Equals, false)
+	c.Check(string(out), Matches, `(?s).*db_password: <encrypted with sops>\n.*`)
+
+	// the expanded blueprint is decrypted from the source that ghpc records
+	// next to it, rather than in it
+	c.Check(string(out), Not(Matches), `(?s).*secrets:.*`)
+	recordFile := filepath.Join(dir, "expanded.secrets.yaml")
+	c.Check(SecretsRecordFilename(outFile), Equals, recordFile)
+	expanded, err := NewDeploymentConfig(outFile)
+	c.Assert(err, IsNil)
+	c.Check(expanded.Config.Vars.Get("db_password"), DeepEquals, cty.StringVal("secret"))
+	c.Check(expanded.Config.Secrets, DeepEquals, dc.Config.Secrets)
+
+	// blueprints without secrets leave no stale record
+	expanded.Config.Secrets = nil
+	c.Assert(expanded.ExportBlueprint(outFile), IsNil)
+	_, err = os.Stat(recordFile)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MySuite) TestSecretsNotInBlueprint(c *C) {
+	_, err := NewDeploymentConfigFromData("secrets", []byte(`blueprint_name: secrets
+vars:
+  deployment_name: secrets
+secrets:
+- file: /home/someone/prod.yaml
+  vars: [db_password]
+deployment_groups: []
+`), "")
+	c.Check(err, ErrorMatches, "(?s).*field secrets not found.*")
+}
+
+func (s *MySuite) TestEncryptedVars(c *C) {
+	var doc yaml.Node
+	c.Assert(yaml.Unmarshal([]byte(`
+vars:
+  project_id: hpc
+  db: {password: "ENC[AES256_GCM,data:c2VjcmV0,type:str]"}
+sops: {mac: "ENC[AES256_GCM,data:bWFj,type:str]"}
+`), &doc), IsNil)
+	c.Check(isSopsEncrypted(&doc), Equals, true)
+	vars, err := encryptedVars(&doc)
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, []string{"db"})
+
+	c.Assert(yaml.Unmarshal([]byte(`
+blueprint_name: "ENC[AES256_GCM,data:bmFtZQ==,type:str]"
+sops: {mac: "ENC[AES256_GCM,data:bWFj,type:str]"}
+`), &doc), IsNil)
+	_, err = encryptedVars(&doc)
+	c.Check(err, ErrorMatches, "blueprint_name is encrypted, but only deployment variables may be encrypted with sops.*")
+}
+
+func (s *MySuite) TestSettingProvenance(c *C) {
+	dc := DeploymentConfig{Config: Blueprint{
+		BlueprintName: "provenance",
+		DeploymentGroups: []DeploymentGroup{{
+			Name: "compute",
+			Modules: []Module{{
+				ID:     "vm",
+				Source: "modules/compute/vm-instance",
+				Settings: NewDict(map[string]cty.Value{
+					"name_prefix":       cty.StringVal("vm"),
+					"labels":            cty.ObjectVal(map[string]cty.Value{"owner": cty.StringVal("me")}),
+					"network_storage":   cty.TupleVal([]cty.Value{cty.StringVal("scratch")}),
+					"add_deployment_id": cty.True,
+				}),
+			}},
+		}},
+	}}
+	dc.recordUserSettings()
+
+	used := ModuleRef("network", "subnetwork_self_link").AsExpression().AsValue().
+		Mark(ProductOfModuleUse{Module: "network"})
+	mod := &dc.Config.DeploymentGroups[0].Modules[0]
+	mod.Settings.Set("labels", cty.ObjectVal(map[string]cty.Value{
+		"owner": cty.StringVal("me"), "ghpc_role": cty.StringVal("compute")}))
+	mod.Settings.Set("network_storage", cty.TupleVal([]cty.Value{cty.StringVal("scratch"), used}))
+	mod.Settings.Set("subnetwork_self_link", used)
+	mod.Settings.Set("zone", GlobalRef("zone").AsExpression().AsValue())
+	mod.Settings.Set("instance_image", cty.StringVal("centos"))
+
+	c.Check(dc.settingProvenance(*mod, dc.Config.DeploymentGroups[0]), DeepEquals, map[string]string{
+		"name_prefix":          "user setting",
+		"add_deployment_id":    "user setting",
+		"labels":               "user setting, modified by the toolkit",
+		"network_storage":      "user setting, extended by use of network",
+		"subnetwork_self_link": "use of network",
+		"zone":                 "deployment variable zone",
+		"instance_image":       "set by the toolkit",
+	})
+
+	dc.Config.DeploymentGroups[0].ProjectID = "service-project"
+	mod.Settings.Set("project_id", cty.StringVal("service-project"))
+	c.Check(dc.settingProvenance(*mod, dc.Config.DeploymentGroups[0])["project_id"], Equals,
+		"project_id of group compute")
+
+	outFile := filepath.Join(c.MkDir(), "expanded.yaml")
+	c.Assert(dc.ExportAnnotatedBlueprint(outFile), IsNil)
+	out, err := os.ReadFile(outFile)
+	c.Assert(err, IsNil)
+	got := string(out)
+	c.Check(got, Matches, `(?s).*name_prefix: vm # provenance: user setting\n.*`)
+	c.Check(got, Matches, `(?s).*labels: # provenance: user setting, modified by the toolkit\n.*`)
+}
+
+func (s *MySuite) TestExportMinimizedBlueprint(c *C) {
+	dc := DeploymentConfig{Config: Blueprint{
+		BlueprintName: "minimal",
+		Vars: NewDict(map[string]cty.Value{
+			"zone":   cty.StringVal("us-central1-a"),
+			"labels": cty.ObjectVal(map[string]cty.Value{"team": cty.StringVal("a")}),
+		}),
+		Validators: []validatorConfig{{Validator: testZoneExistsName.String(), Skip: true}},
+		DeploymentGroups: []DeploymentGroup{{
+			Name: "compute",
+			Modules: []Module{{
+				ID:       "vm",
+				Source:   "modules/compute/vm-instance",
+				Settings: NewDict(map[string]cty.Value{"name_prefix": cty.StringVal("vm")}),
+			}},
+		}},
+	}}
+	dc.recordUserSettings()
+	dc.recordUserFields()
+
+	bp := &dc.Config
+	bp.Vars.Set("labels", cty.ObjectVal(map[string]cty.Value{
+		"team": cty.StringVal("a"), "ghpc_blueprint": cty.StringVal("minimal")}))
+	bp.Vars.Set("deployment_name", cty.StringVal("added"))
+	bp.Validators = append(bp.Validators, validatorConfig{Validator: testModuleNotUsedName.String(), reason: "always added"})
+	mod := &bp.DeploymentGroups[0].Modules[0]
+	mod.RequiredApis = map[string][]string{"$(vars.project_id)": {"compute.googleapis.com"}}
+	mod.Outputs = []modulereader.OutputInfo{{Name: "name"}}
+	mod.Settings.Set("zone", GlobalRef("zone").AsExpression().AsValue())
+	mod.Settings.Set("subnetwork_self_link", ModuleRef("network", "subnetwork_self_link").AsExpression().AsValue().
+		Mark(ProductOfModuleUse{Module: "network"}))
+	mod.Settings.Set("labels", cty.ObjectVal(map[string]cty.Value{"ghpc_role": cty.StringVal("compute")}))
+	mod.Transforms = map[string][]SettingTransformer{"labels": {"merge"}}
+
+	outFile := filepath.Join(c.MkDir(), "expanded.yaml")
+	c.Assert(dc.ExportBlueprintWithOptions(outFile, ExportOptions{Minimize: true}), IsNil)
+	out, err := os.ReadFile(outFile)
+	c.Assert(err, IsNil)
+	got := string(out)
+	c.Check(got, Matches, `(?s).*name_prefix: vm\n.*`)
+	c.Check(got, Matches, `(?s).*subnetwork_self_link: \(\(module.network.subnetwork_self_link\)\)\n.*`)
+	c.Check(got, Matches, `(?s).*validator: test_zone_exists\n.*skip: true\n.*`)
+	c.Check(got, Matches, `(?s).*team: a\n.*`)
+	for _, elided := range []string{"test_module_not_used", "ghpc_blueprint", "deployment_name", "kind:",
+		"required_apis", "outputs", "zone: ((var.zone))", "ghpc_role", "transforms", "terraform_backend"} {
+		c.Check(strings.Contains(got, elided), Equals, false, Commentf("%s was not elided", elided))
+	}
+}
+
 func TestMain(m *testing.M) {
 	setup()
 	code := m.Run()
@@ -833,6 +1872,272 @@ func (s *MySuite) TestValidatorConfigCheck(c *C) {
 	}
 }
 
+func (s *MySuite) TestValidatorScope(c *C) {
+	bp := Blueprint{
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{{ID: "network"}}},
+			{Name: "experimental", Modules: []Module{{ID: "trial"}, {ID: "gpu"}}},
+		},
+	}
+	network, _ := bp.Module("network")
+	trial, _ := bp.Module("trial")
+	gpu, _ := bp.Module("gpu")
+
+	{ // OK. Nothing ignored
+		v := validatorConfig{Validator: testApisEnabledName.String()}
+		c.Check(v.checkScope(bp), IsNil)
+		c.Check(v.ignores(*trial, bp), Equals, false)
+	}
+
+	{ // OK. Ignore a group and a module
+		v := validatorConfig{
+			Validator:     testApisEnabledName.String(),
+			IgnoreModules: []ModuleID{"network"},
+			IgnoreGroups:  []GroupName{"experimental"},
+		}
+		c.Check(v.checkScope(bp), IsNil)
+		c.Check(v.ignores(*network, bp), Equals, true)
+		c.Check(v.ignores(*trial, bp), Equals, true)
+		c.Check(v.ignores(*gpu, bp), Equals, true)
+	}
+
+	{ // FAIL. Unknown module
+		v := validatorConfig{
+			Validator:     testModuleNotUsedName.String(),
+			IgnoreModules: []ModuleID{"nope"},
+		}
+		c.Check(v.checkScope(bp), ErrorMatches, ".*ignores module nope.*")
+	}
+
+	{ // FAIL. Unknown group
+		v := validatorConfig{
+			Validator:    testModuleNotUsedName.String(),
+			IgnoreGroups: []GroupName{"nope"},
+		}
+		c.Check(v.checkScope(bp), ErrorMatches, ".*ignores group nope.*")
+	}
+
+	{ // FAIL. Validator does not inspect modules
+		v := validatorConfig{
+			Validator:    testZoneExistsName.String(),
+			IgnoreGroups: []GroupName{"experimental"},
+		}
+		c.Check(v.checkScope(bp), ErrorMatches, ".*test_zone_exists")
+	}
+}
+
+func (s *MySuite) TestUserConfig(c *C) {
+	path := filepath.Join(c.MkDir(), "ghpc", "config.yaml")
+
+	// a missing file is an empty configuration
+	uc, err := LoadUserConfig(path)
+	c.Assert(err, IsNil)
+	c.Check(uc, DeepEquals, UserConfig{})
+
+	c.Assert(uc.Set("project", "hpc-project"), IsNil)
+	c.Assert(uc.Set("validation_level", "ERROR"), IsNil)
+	c.Assert(uc.Set("telemetry", "false"), IsNil)
+	c.Assert(uc.Save(path), IsNil)
+
+	uc, err = LoadUserConfig(path)
+	c.Assert(err, IsNil)
+	for key, want := range map[string]string{
+		"project":          "hpc-project",
+		"validation_level": "ERROR",
+		"telemetry":        "false",
+		"backend_bucket":   "",
+	} {
+		got, err := uc.Get(key)
+		c.Check(err, IsNil)
+		c.Check(got, Equals, want)
+	}
+
+	// the empty value unsets a key
+	c.Assert(uc.Set("telemetry", ""), IsNil)
+	c.Check(uc.Telemetry, IsNil)
+
+	c.Check(uc.Set("validation_level", "LOUD"), ErrorMatches, "validation_level must be one of .*")
+	c.Check(uc.Set("telemetry", "maybe"), ErrorMatches, "telemetry must be true or false.*")
+	c.Check(uc.Set("umask", "0002"), IsNil)
+	c.Check(uc.Set("umask", "0999"), ErrorMatches, "umask must be an octal number .*")
+	c.Check(uc.Umask, Equals, "0002")
+	c.Check(uc.Set("color", "blue"), ErrorMatches, `unknown user configuration key "color".*`)
+	_, err = uc.Get("color")
+	c.Check(err, NotNil)
+
+	// unknown keys in the file are errors
+	c.Assert(os.WriteFile(path, []byte("projekt: hpc-project\n"), 0644), IsNil)
+	_, err = LoadUserConfig(path)
+	c.Check(err, ErrorMatches, "(?s)failed to read user configuration .*field projekt not found.*")
+}
+
+func (s *MySuite) TestCheckNotifications(c *C) {
+	bp := Blueprint{Notifications: []Notification{
+		{Type: PubSubNotification, Topic: "projects/hpc-project/topics/deployments"},
+		{Type: SlackNotification, URL: "https://hooks.slack.com/services/T/B/X", Events: []string{DeployFailed}},
+		{Type: HTTPNotification, URL: "http://localhost:8080/hook"},
+	}}
+	c.Check(checkNotifications(bp), IsNil)
+	c.Check(bp.Notifications[1].Notifies(DeployStarted), Equals, false)
+	c.Check(bp.Notifications[2].Notifies(DeployStarted), Equals, true)
+
+	{ // FAIL. Topic is not a resource name
+		bp := Blueprint{Notifications: []Notification{{Type: PubSubNotification, Topic: "deployments"}}}
+		c.Check(checkNotifications(bp), ErrorMatches, "notification 0: pubsub notification requires a topic .*")
+	}
+
+	{ // FAIL. No URL
+		bp := Blueprint{Notifications: []Notification{{Type: SlackNotification}}}
+		c.Check(checkNotifications(bp), ErrorMatches, "notification 0: slack notification requires an http or https url.*")
+	}
+
+	{ // FAIL. Unknown event
+		bp := Blueprint{Notifications: []Notification{{Type: HTTPNotification, URL: "https://example.com", Events: []string{"destroy"}}}}
+		c.Check(checkNotifications(bp), ErrorMatches, `notification 0: unknown notification event "destroy".*`)
+	}
+
+	{ // FAIL. Unknown type
+		bp := Blueprint{Notifications: []Notification{{Type: "email"}}}
+		c.Check(checkNotifications(bp), ErrorMatches, `notification 0: unknown notification type "email".*`)
+	}
+}
+
+// fakeVarSource serves values from a map and records the projects it reads
+type fakeVarSource struct {
+	project string
+	values  map[string]cty.Value
+}
+
+func (f fakeVarSource) Get(_ context.Context, key string) (cty.Value, string, error) {
+	v, ok := f.values[key]
+	if !ok {
+		return cty.NilVal, "", fmt.Errorf("no key %s", key)
+	}
+	return v, f.project + "/" + key, nil
+}
+
+func (s *MySuite) TestResolveVarSources(c *C) {
+	values := map[string]cty.Value{
+		"vpc":   cty.StringVal("shared-vpc"),
+		"image": cty.StringVal("golden-image"),
+	}
+	varsources.Register("fake", func(project string, settings map[string]string) (varsources.Source, error) {
+		return fakeVarSource{project: project, values: values}, nil
+	})
+	dc := DeploymentConfig{Config: Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("hpc-project"),
+			"image_name": cty.StringVal("my-image"),
+		}),
+		VarSources: []VarSource{{
+			Type: "fake",
+			Vars: map[string]string{"network_name": "vpc", "image_name": "image"},
+		}},
+	}}
+
+	// variables set in the blueprint take precedence over their source
+	c.Assert(dc.resolveVarSources(context.Background()), IsNil)
+	c.Check(dc.Config.Vars.Get("network_name"), DeepEquals, cty.StringVal("shared-vpc"))
+	c.Check(dc.Config.Vars.Get("image_name"), DeepEquals, cty.StringVal("my-image"))
+	c.Check(dc.resolvedVars, DeepEquals, []ResolvedVar{{
+		Var: "network_name", Source: "fake", Location: "hpc-project/vpc", Value: cty.StringVal("shared-vpc")}})
+
+	{ // the project of the source overrides project_id
+		dc := DeploymentConfig{Config: Blueprint{VarSources: []VarSource{{
+			Type: "fake", Project: "platform", Vars: map[string]string{"network_name": "vpc"}}}}}
+		c.Assert(dc.resolveVarSources(context.Background()), IsNil)
+		c.Check(dc.resolvedVars[0].Location, Equals, "platform/vpc")
+	}
+
+	{ // FAIL. No project
+		dc := DeploymentConfig{Config: Blueprint{VarSources: []VarSource{{
+			Type: "fake", Vars: map[string]string{"network_name": "vpc"}}}}}
+		c.Check(dc.resolveVarSources(context.Background()), ErrorMatches, ".*no project_id deployment variable")
+	}
+
+	{ // FAIL. Missing key
+		dc := DeploymentConfig{Config: Blueprint{VarSources: []VarSource{{
+			Type: "fake", Project: "platform", Vars: map[string]string{"network_name": "subnet"}}}}}
+		c.Check(dc.resolveVarSources(context.Background()), ErrorMatches, "failed to read deployment variable network_name: no key subnet")
+	}
+
+	{ // FAIL. Unknown type
+		dc := DeploymentConfig{Config: Blueprint{VarSources: []VarSource{{
+			Type: "vault", Project: "platform", Vars: map[string]string{"network_name": "vpc"}}}}}
+		c.Check(dc.resolveVarSources(context.Background()), ErrorMatches, `.*unknown var source type "vault".*`)
+	}
+}
+
+func (s *MySuite) TestSkipAnnotations(c *C) {
+	read := func(src string) (Blueprint, error) {
+		bp := Blueprint{
+			DeploymentGroups: []DeploymentGroup{
+				{Name: "primary", Modules: []Module{{ID: "network"}, {ID: "login"}}},
+			},
+		}
+		var doc yaml.Node
+		c.Assert(yaml.Unmarshal([]byte(src), &doc), IsNil)
+		return bp, bp.readSkipAnnotations(&doc)
+	}
+
+	{ // OK. Annotations above a module and on its id
+		bp, err := read(`
+deployment_groups:
+- group: primary
+  modules:
+  # ghpc:skip-validator=test_apis_enabled reason=enabled by the project factory
+  - id: network
+  - id: login # ghpc:skip-validator=test_startup_scripts reason="runs its own configuration"
+`)
+		c.Assert(err, IsNil)
+		network, _ := bp.Module("network")
+		login, _ := bp.Module("login")
+		v := validatorConfig{Validator: testApisEnabledName.String()}
+		c.Check(v.ignores(*network, bp), Equals, true)
+		c.Check(v.ignores(*login, bp), Equals, false)
+
+		bp.Validators = []validatorConfig{
+			{Validator: testZoneExistsName.String(), Skip: true, SkipReason: "zone is created later"},
+			{Validator: testRegionExistsName.String()},
+		}
+		c.Check(DeploymentConfig{Config: bp}.ValidationReport(), DeepEquals, ValidationReport{
+			SkippedValidators: []SkippedValidator{
+				{Validator: "test_zone_exists", Reason: "zone is created later"},
+			},
+			SuppressedFindings: []SuppressedFinding{
+				{Validator: "test_apis_enabled", Module: "network", Reason: "enabled by the project factory"},
+				{Validator: "test_startup_scripts", Module: "login", Reason: "runs its own configuration"},
+			},
+		})
+	}
+
+	{ // FAIL. No reason
+		_, err := read(`
+deployment_groups:
+- group: primary
+  modules:
+  # ghpc:skip-validator=test_apis_enabled
+  - id: network
+`)
+		c.Check(err, ErrorMatches, "module network: .*must give a reason=")
+	}
+
+	{ // FAIL. Validator does not inspect modules
+		_, err := read(`
+deployment_groups:
+- group: primary
+  modules:
+  - id: network # ghpc:skip-validator=test_zone_exists reason=none
+`)
+		c.Check(err, ErrorMatches, ".*test_zone_exists")
+	}
+
+	{ // FAIL. Reason given for a validator that runs
+		v := validatorConfig{Validator: testZoneExistsName.String(), SkipReason: "why"}
+		c.Check(v.checkSkipReason(), NotNil)
+	}
+}
+
 func (s *MySuite) TestCheckBackends(c *C) {
 	// Helper to create blueprint with backend blocks only (first one is defaults)
 	// and run checkBackends.
@@ -911,6 +2216,45 @@ func (s *MySuite) TestCheckBackends(c *C) {
 			}))
 		c.Check(check(b), ErrorMatches, ".*can not use variables.*")
 	}
+
+	{ // OK. Group selects a backend profile
+		bp := Blueprint{
+			TerraformBackends: map[string]TerraformBackend{"prod": {Type: "gcs"}},
+			DeploymentGroups:  []DeploymentGroup{{Name: "g", Backend: "prod"}},
+		}
+		c.Check(checkBackends(bp), IsNil)
+	}
+
+	{ // FAIL. Group selects an undefined backend profile
+		bp := Blueprint{
+			TerraformBackends: map[string]TerraformBackend{"prod": {Type: "gcs"}},
+			DeploymentGroups:  []DeploymentGroup{{Name: "g", Backend: "scratch"}},
+		}
+		c.Check(checkBackends(bp), ErrorMatches, ".*group g, backend scratch")
+	}
+
+	{ // FAIL. Group sets both a backend profile and terraform_backend
+		bp := Blueprint{
+			TerraformBackends: map[string]TerraformBackend{"prod": {Type: "gcs"}},
+			DeploymentGroups: []DeploymentGroup{{
+				Name:             "g",
+				Backend:          "prod",
+				TerraformBackend: TerraformBackend{Type: "local"}}},
+		}
+		c.Check(checkBackends(bp), ErrorMatches, ".*both backend and terraform_backend.*")
+	}
+
+	{ // FAIL. Profile without a type
+		bp := Blueprint{TerraformBackends: map[string]TerraformBackend{"prod": {}}}
+		c.Check(checkBackends(bp), ErrorMatches, ".*must set type: prod")
+	}
+
+	{ // FAIL. Variable in profile configuration
+		b := TerraformBackend{Type: "gcs"}
+		b.Configuration.Set("bucket", GlobalRef("trenta").AsExpression().AsValue())
+		bp := Blueprint{TerraformBackends: map[string]TerraformBackend{"prod": b}}
+		c.Check(checkBackends(bp), ErrorMatches, ".*can not use variables.*")
+	}
 }
 
 func (s *MySuite) TestSkipValidator(c *C) {
@@ -1037,3 +2381,652 @@ func (s *MySuite) TestCheckModuleSettings(c *C) {
 	bp.Vars.Set("zebra", cty.StringVal("stripes"))
 	c.Check(checkModuleSettings(bp), IsNil)
 }
+
+func (s *MySuite) TestHostStartupScripts(c *C) {
+	src := filepath.Join(c.MkDir(), "install.sh")
+	c.Assert(os.WriteFile(src, []byte("echo install"), 0644), IsNil)
+
+	mod := Module{
+		ID:     "script",
+		Source: "modules/scripts/startup-script",
+		Settings: NewDict(map[string]cty.Value{
+			"runners": cty.TupleVal([]cty.Value{
+				cty.ObjectVal(map[string]cty.Value{
+					"type":        cty.StringVal("shell"),
+					"destination": cty.StringVal("hello.sh"),
+					"content":     cty.StringVal("echo hello"),
+				}),
+				cty.ObjectVal(map[string]cty.Value{
+					"type":        cty.StringVal("shell"),
+					"destination": cty.StringVal("/tmp/install.sh"),
+					"source":      cty.StringVal(src),
+				}),
+				// content set from deployment variables is left unchanged
+				cty.ObjectVal(map[string]cty.Value{
+					"type":        cty.StringVal("shell"),
+					"destination": cty.StringVal("var.sh"),
+					"content":     GlobalRef("script").AsExpression().AsValue(),
+				}),
+			}),
+		}),
+	}
+	dc := DeploymentConfig{Config: Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("golden"),
+			"script":          cty.StringVal("echo var"),
+		}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{mod}}},
+	}}
+	runners := mod.Settings.Get("runners")
+
+	// nothing is hosted without a bucket
+	scripts, err := dc.HostStartupScripts()
+	c.Assert(err, IsNil)
+	c.Check(scripts, HasLen, 0)
+	c.Check(mod.Settings.Get("runners"), DeepEquals, runners)
+
+	dc.Config.Vars.Set("startup_script_bucket", cty.StringVal("my-bucket/scripts/"))
+	scripts, err = dc.HostStartupScripts()
+	c.Assert(err, IsNil)
+	c.Assert(scripts, HasLen, 2)
+	c.Check(scripts[0].Module, Equals, ModuleID("script"))
+	c.Check(scripts[0].URI, Matches, "gs://my-bucket/scripts/golden/script/hello.sh-[0-9a-f]{8}")
+	c.Check(string(scripts[0].Content), Equals, "echo hello")
+	c.Check(scripts[1].URI, Matches, "gs://my-bucket/scripts/golden/script/install.sh-[0-9a-f]{8}")
+	c.Check(string(scripts[1].Content), Equals, "echo install")
+
+	hosted := dc.Config.DeploymentGroups[0].Modules[0].Settings.Get("runners").AsValueSlice()
+	c.Check(hosted[0], DeepEquals, cty.ObjectVal(map[string]cty.Value{
+		"type":        cty.StringVal("shell"),
+		"destination": cty.StringVal("hello.sh"),
+		"source":      cty.StringVal(scripts[0].URI),
+	}))
+	c.Check(hosted[1].GetAttr("source"), DeepEquals, cty.StringVal(scripts[1].URI))
+	c.Check(hosted[2], DeepEquals, runners.AsValueSlice()[2])
+
+	dc.Config.Vars.Set("startup_script_bucket", cty.StringVal(""))
+	_, err = dc.HostStartupScripts()
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestModulePolicy(c *C) {
+	vm := Module{ID: "vm", Kind: TerraformKind, Source: "./modules/compute/vm-instance"}
+	image := Module{ID: "image", Kind: PackerKind, Source: "modules/packer/custom-image"}
+	daos := Module{ID: "daos", Kind: TerraformKind, Source: "community/modules/file-system/daos"}
+	remote := Module{ID: "remote", Kind: TerraformKind, Source: "github.com/org/repo//modules/net?ref=v1"}
+
+	p := ModulePolicy{}
+	c.Check(p.permits(remote), Equals, true)
+
+	p.Allow = []ModulePolicyRule{{Source: "modules/**"}, {Source: "community/modules/*/daos"}}
+	c.Check(p.permits(vm), Equals, true)
+	c.Check(p.permits(image), Equals, true)
+	c.Check(p.permits(daos), Equals, true)
+	c.Check(p.permits(remote), Equals, false)
+
+	p.Deny = []ModulePolicyRule{{Kind: PackerKind}, {Source: "community/**"}}
+	c.Check(p.permits(vm), Equals, true)
+	c.Check(p.permits(image), Equals, false)
+	c.Check(p.permits(daos), Equals, false)
+
+	p.Exempt = []ModulePolicyRule{{Source: "community/modules/file-system/daos", Kind: TerraformKind}}
+	c.Check(p.permits(daos), Equals, true)
+
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "primary", Modules: []Module{vm, image, remote}},
+	}}
+	c.Check(bp.checkModulePolicy(nil), IsNil)
+	bp.ModulePolicy = &p
+	c.Check(bp.checkModulePolicy(nil), ErrorMatches,
+		errorMessages["modulePolicy"]+": image .*, remote .*")
+
+	// exemptions of the blueprint policy do not relax an enforced policy
+	bp.ModulePolicy = &ModulePolicy{Exempt: []ModulePolicyRule{{Source: "**"}}}
+	c.Check(bp.checkModulePolicy(nil), IsNil)
+	c.Check(bp.checkModulePolicy([]ModulePolicy{{Deny: []ModulePolicyRule{{Kind: PackerKind}}}}), ErrorMatches,
+		errorMessages["modulePolicy"]+": image .*")
+
+	policyFile := filepath.Join(c.MkDir(), "policy.yaml")
+	c.Assert(os.WriteFile(policyFile, []byte(
+		"allow:\n- source: modules/**\ndeny:\n- kind: packer\n"), 0644), IsNil)
+	loaded, err := LoadModulePolicy(policyFile)
+	c.Assert(err, IsNil)
+	c.Check(loaded, DeepEquals, ModulePolicy{
+		Allow: []ModulePolicyRule{{Source: "modules/**"}},
+		Deny:  []ModulePolicyRule{{Kind: PackerKind}},
+	})
+
+	c.Assert(os.WriteFile(policyFile, []byte("deny:\n- kind: ansible\n"), 0644), IsNil)
+	_, err = LoadModulePolicy(policyFile)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestFormatBlueprint(c *C) {
+	src := []byte(`# Copyright 2023 Google LLC
+
+---
+
+deployment_groups:
+- modules:
+  - settings:
+      name_prefix: $( vars.deployment_name )
+      machine_type: "n2-standard-4"
+      enabled: "true"
+    source: "modules/compute/vm-instance"
+    id: vm # the compute VM
+  group: primary
+vars:
+  # the project to deploy into
+  project_id: "my-project"
+  count: ((var.num_nodes+1))
+blueprint_name: formatted
+`)
+	out, err := FormatBlueprint(src)
+	c.Assert(err, IsNil)
+	got := string(out)
+
+	c.Check(strings.HasPrefix(got, "# Copyright 2023 Google LLC\n\n---\n"), Equals, true)
+	order := []string{"blueprint_name:", "vars:", "deployment_groups:", "group: primary", "id: vm", "source:", "settings:"}
+	for i := 1; i < len(order); i++ {
+		c.Check(strings.Index(got, order[i-1]) < strings.Index(got, order[i]), Equals, true,
+			Commentf("%q should precede %q in\n%s", order[i-1], order[i], got))
+	}
+	c.Check(got, Matches, "(?s).*# the project to deploy into.*")
+	c.Check(got, Matches, "(?s).*id: vm # the compute VM.*")
+	c.Check(got, Matches, "(?s).*name_prefix: \\$\\(vars.deployment_name\\)\n.*")
+	c.Check(got, Matches, "(?s).*count: \\(\\(var.num_nodes \\+ 1\\)\\)\n.*")
+	c.Check(got, Matches, "(?s).*project_id: my-project\n.*")
+	c.Check(got, Matches, "(?s).*enabled: \"true\"\n.*")
+
+	// formatting is stable
+	again, err := FormatBlueprint(out)
+	c.Assert(err, IsNil)
+	c.Check(string(again), Equals, got)
+
+	_, err = FormatBlueprint([]byte("blueprint_name: x\nnot_a_field: y\n"))
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestVendorBlueprint(c *C) {
+	src := []byte(`blueprint_name: vendored
+module_aliases:
+  vpc: github.com/org/repo//modules/vpc?ref=v1
+  unused: github.com/org/repo//modules/unused?ref=v1
+deployment_groups:
+- group: primary
+  modules:
+  - id: network # the shared network
+    source: vpc
+  - id: fs
+    source: "github.com/org/repo//modules/fs?ref=v1"
+    source_hash: git:0a1b2c3
+  - id: vm
+    source: modules/compute/vm-instance
+`)
+	calls := []string{}
+	vendor := func(source string, hash string) (string, string, error) {
+		calls = append(calls, source)
+		local := "./vendor/modules/github.com/org/repo@v1/" + strings.TrimSuffix(strings.Split(source, "//")[1], "?ref=v1")
+		if hash != "" {
+			hash = "sha256:" + strings.Repeat("ab", 32)
+		}
+		return local, hash, nil
+	}
+
+	out, err := VendorBlueprint(src, vendor)
+	c.Assert(err, IsNil)
+	got := string(out)
+	c.Check(calls, DeepEquals, []string{
+		"github.com/org/repo//modules/vpc?ref=v1",
+		"github.com/org/repo//modules/fs?ref=v1",
+		"github.com/org/repo//modules/unused?ref=v1",
+	})
+	c.Check(got, Matches, "(?s).*  vpc: ./vendor/modules/github.com/org/repo@v1/modules/vpc\n.*")
+	c.Check(got, Matches, "(?s).*  unused: ./vendor/modules/github.com/org/repo@v1/modules/unused\n.*")
+	c.Check(got, Matches, "(?s).*id: network # the shared network\n        source: vpc\n.*")
+	c.Check(got, Matches, "(?s).*source: ./vendor/modules/github.com/org/repo@v1/modules/fs\n        source_hash: sha256:abab.*")
+	c.Check(got, Matches, "(?s).*source: modules/compute/vm-instance\n.*")
+
+	{ // blueprints without git sources are unchanged
+		src := []byte("blueprint_name: local\ndeployment_groups:\n  - group: primary\n    modules: []\n")
+		out, err := VendorBlueprint(src, vendor)
+		c.Assert(err, IsNil)
+		c.Check(string(out), Equals, string(src))
+	}
+
+	{ // modules that share an alias cannot vendor it to different copies
+		src := []byte(`blueprint_name: pinned
+module_aliases:
+  vpc: github.com/org/repo//modules/vpc?ref=v1
+deployment_groups:
+- group: primary
+  modules:
+  - id: a
+    source: vpc
+    source_hash: git:0a1b2c3
+  - id: b
+    source: vpc
+    source_hash: git:4d5e6f7
+`)
+		_, err := VendorBlueprint(src, func(source string, hash string) (string, string, error) {
+			return "./vendor/" + hash, hash, nil
+		})
+		c.Check(err, ErrorMatches, "module b: github.com/org/repo//modules/vpc\\?ref=v1 is vendored to both ./vendor/git:0a1b2c3 and ./vendor/git:4d5e6f7, .*")
+	}
+}
+
+func (s *MySuite) TestBlueprintFromTerraform(c *C) {
+	dir := filepath.Join(c.MkDir(), "cluster")
+	c.Assert(os.MkdirAll(filepath.Join(dir, "modules", "net"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "main.tf"), []byte(`
+variable "project_id" {}
+variable "region" {
+  default = "us-central1"
+}
+
+module "network" {
+  source     = "./modules/net"
+  project_id = var.project_id
+  name       = "net-$${var.region}"
+}
+
+module "vm" {
+  source       = "github.com/GoogleCloudPlatform/hpc-toolkit//modules/compute/vm-instance?ref=v1.20.0"
+  count        = 2
+  network_self_link = module.network.self_link
+  zone         = "${var.region}-a"
+  labels       = { env = local.env }
+  depends_on   = [module.network]
+}
+
+resource "google_storage_bucket" "b" {
+  name     = "b"
+  location = "US"
+}
+
+output "vm_names" {
+  value = module.vm.name
+}
+`), 0644), IsNil)
+
+	out, warnings, err := BlueprintFromTerraform(dir)
+	c.Assert(err, IsNil)
+
+	var bp Blueprint
+	c.Assert(yaml.Unmarshal(out, &bp), IsNil)
+	c.Check(bp.BlueprintName, Equals, "cluster")
+	c.Check(bp.Vars.Get("region"), DeepEquals, cty.StringVal("us-central1"))
+	c.Check(bp.Vars.Get("project_id").IsNull(), Equals, true)
+	c.Check(bp.Vars.Get("deployment_name"), DeepEquals, cty.StringVal("cluster"))
+
+	c.Assert(bp.DeploymentGroups, HasLen, 1)
+	mods := bp.DeploymentGroups[0].Modules
+	c.Assert(mods, HasLen, 2)
+	net, vm := mods[0], mods[1]
+	c.Check(net.ID, Equals, ModuleID("network"))
+	c.Check(strings.HasSuffix(net.Source, "/cluster/modules/net"), Equals, true)
+	c.Check(net.Settings.Get("project_id"), DeepEquals, GlobalRef("project_id").AsExpression().AsValue())
+	// escaped interpolation is kept as a literal string
+	c.Check(net.Settings.Get("name"), DeepEquals, cty.StringVal("net-${var.region}"))
+
+	c.Check(vm.Source, Equals, "github.com/GoogleCloudPlatform/hpc-toolkit//modules/compute/vm-instance?ref=v1.20.0")
+	c.Check(vm.Depends, DeepEquals, []ModuleID{"network"})
+	c.Check(vm.Settings.Get("network_self_link"), DeepEquals, ModuleRef("network", "self_link").AsExpression().AsValue())
+	c.Check(vm.Settings.Has("count"), Equals, false)
+	// expressions referring to locals are imported as strings
+	c.Check(vm.Settings.Get("labels"), DeepEquals, cty.StringVal(`\(({ env = local.env }))`))
+	c.Check(vm.Outputs, DeepEquals, []modulereader.OutputInfo{{Name: "name"}})
+
+	all := strings.Join(warnings, "\n")
+	for _, w := range []string{
+		"variable project_id has no default",
+		"module vm sets count",
+		"setting labels of module vm refers to local.env",
+		"resource google_storage_bucket.b is not converted",
+		"output vm_names is exposed as output name of module vm",
+	} {
+		c.Check(strings.Contains(all, w), Equals, true, Commentf("missing warning %q in\n%s", w, all))
+	}
+}
+
+func (s *MySuite) TestMigrateDeprecatedInputs(c *C) {
+	mod := Module{
+		ID:     "vm",
+		Source: "test::deprecated",
+		Kind:   TerraformKind,
+		Settings: NewDict(map[string]cty.Value{
+			"machine_size": cty.StringVal("n2-standard-2"),
+			"legacy_mode":  cty.True,
+		}),
+	}
+	modulereader.SetModuleInfo(mod.Source, mod.Kind.String(), modulereader.ModuleInfo{
+		DeprecatedInputs: []modulereader.DeprecatedInput{
+			{Name: "machine_size", Replacement: "machine_type"},
+			{Name: "legacy_mode", Message: "it has no effect"},
+		}})
+	dc := DeploymentConfig{Config: Blueprint{
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{mod}}},
+	}}
+	dc.recordUserSettings()
+
+	c.Assert(dc.migrateDeprecatedInputs(), IsNil)
+	got := dc.Config.DeploymentGroups[0].Modules[0].Settings
+	c.Check(got.Items(), DeepEquals, map[string]cty.Value{
+		"machine_type": cty.StringVal("n2-standard-2"),
+		"legacy_mode":  cty.True,
+	})
+	c.Check(dc.userSettings["vm"]["machine_type"], DeepEquals, cty.StringVal("n2-standard-2"))
+
+	{ // FAIL. Setting both an input and its replacement
+		mod := mod
+		mod.Settings = NewDict(map[string]cty.Value{
+			"machine_size": cty.StringVal("n2-standard-2"),
+			"machine_type": cty.StringVal("n2-standard-4"),
+		})
+		dc := DeploymentConfig{Config: Blueprint{
+			DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{mod}}},
+		}}
+		c.Check(dc.migrateDeprecatedInputs(), ErrorMatches, ".*sets machine_type and the input machine_size that it replaces.*")
+	}
+}
+
+func (s *MySuite) TestTraceExpansion(c *C) {
+	dc := getDeploymentConfigForTest()
+	var buf bytes.Buffer
+	dc.TraceExpansion(&buf)
+
+	c.Assert(dc.tracePhase("phase", func() error {
+		m := &dc.Config.DeploymentGroups[0].Modules[0]
+		m.Settings.Set("zone", GlobalRef("zone").AsExpression().AsValue())
+		dc.Config.Validators = append(dc.Config.Validators, validatorConfig{Validator: "test_zone_exists", reason: "zone is set"})
+		return nil
+	}), IsNil)
+	c.Assert(dc.tracePhase("nothing", func() error { return nil }), IsNil)
+
+	c.Check(buf.String(), Equals, `== phase
+  module testModule:
+    + zone = "$(vars.zone)"
+  + validator test_zone_exists: zone is set
+== nothing
+  no changes
+`)
+}
+
+func (s *MySuite) TestSensitiveVars(c *C) {
+	mod := Module{
+		ID:     "db",
+		Source: "test::sensitive",
+		Kind:   TerraformKind,
+		Settings: NewDict(map[string]cty.Value{
+			"password": GlobalRef("db_password").AsExpression().AsValue(),
+			"name":     GlobalRef("db_name").AsExpression().AsValue(),
+		}),
+	}
+	modulereader.SetModuleInfo(mod.Source, mod.Kind.String(), modulereader.ModuleInfo{
+		Inputs: []modulereader.VarInfo{
+			{Name: "password", Type: "string", Sensitive: true},
+			{Name: "name", Type: "string"},
+		}})
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"db_password": cty.StringVal("hunter2"),
+			"db_name":     cty.StringVal("slurm"),
+			"api_key":     cty.StringVal("decrypted"),
+		}),
+		Secrets:          []SecretsSource{{File: "/secrets.yaml", Vars: []string{"api_key"}}},
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{mod}}},
+	}
+	c.Check(bp.SensitiveVars(), DeepEquals, []string{"api_key", "db_password"})
+
+	// sops variables keep the placeholder that restores them
+	bp.Vars = bp.redactSensitiveVars()
+	bp.Vars = bp.redactSecrets()
+	c.Check(bp.Vars.Items(), DeepEquals, map[string]cty.Value{
+		"db_password": cty.StringVal(redactedSensitive),
+		"db_name":     cty.StringVal("slurm"),
+		"api_key":     cty.StringVal(redactedSecret),
+	})
+}
+
+func (s *MySuite) TestRestoreSensitiveVars(c *C) {
+	root := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(root, "primary"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(root, "primary", SensitiveTfvarsFilename),
+		[]byte("db_password = \"hunter2\"\n"), 0644), IsNil)
+
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"db_password": cty.StringVal(redactedSensitive),
+			"db_name":     cty.StringVal("slurm"),
+			"api_token":   cty.StringVal(redactedSensitive),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "image", Kind: PackerKind},
+			{Name: "primary", Kind: TerraformKind},
+			{Name: "unwritten", Kind: TerraformKind},
+		},
+	}
+	c.Assert(bp.RestoreSensitiveVars(root), IsNil)
+	c.Check(bp.Vars.Items(), DeepEquals, map[string]cty.Value{
+		"db_password": cty.StringVal("hunter2"),
+		"db_name":     cty.StringVal("slurm"),
+		"api_token":   cty.StringVal(redactedSensitive),
+	})
+}
+
+func (s *MySuite) TestImpersonation(c *C) {
+	sa := "deployer@proj.iam.gserviceaccount.com"
+	bp := Blueprint{
+		ImpersonateServiceAccount: sa,
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary"},
+			{Name: "service", ImpersonateServiceAccount: "service@other.iam.gserviceaccount.com"},
+		},
+	}
+	c.Check(checkImpersonation(bp), IsNil)
+	c.Check(bp.ImpersonatedServiceAccount(bp.DeploymentGroups[0]), Equals, sa)
+	c.Check(bp.ImpersonatedServiceAccount(bp.DeploymentGroups[1]), Equals, "service@other.iam.gserviceaccount.com")
+
+	bp.ImpersonateServiceAccount = ""
+	c.Check(bp.ImpersonatedServiceAccount(bp.DeploymentGroups[0]), Equals, "")
+
+	bp.DeploymentGroups[1].ImpersonateServiceAccount = "service"
+	c.Check(checkImpersonation(bp), ErrorMatches, "group service: .* is not a service account email")
+
+	bp.DeploymentGroups[1].ImpersonateServiceAccount = ""
+	bp.ImpersonateServiceAccount = "$(vars.deployer)"
+	c.Check(checkImpersonation(bp), ErrorMatches, ".* can not use variables")
+}
+
+func (s *MySuite) TestRetryPolicy(c *C) {
+	stockout := errors.New("Error: googleapi: Error 503: ZONE_RESOURCE_POOL_EXHAUSTED")
+	quota := errors.New("Error: Quota 'C2_CPUS' exceeded.  Limit: 24.0 in region us-central1.")
+
+	{ // no retries by default
+		p := RetryPolicy{}
+		c.Check(p.Attempts(), Equals, 1)
+		c.Check(p.RetryDelay(), Equals, time.Minute)
+		c.Check(p.Retryable(stockout), Equals, true)
+		c.Check(p.Retryable(quota), Equals, true)
+		c.Check(p.Retryable(errors.New("Error: Invalid value for machine_type")), Equals, false)
+		c.Check(p.Retryable(nil), Equals, false)
+	}
+
+	{ // retryable errors replace the defaults
+		p := RetryPolicy{MaxAttempts: 3, RetryableErrors: []string{"Quota '.*' exceeded"}, Delay: 5 * time.Second}
+		c.Check(p.Attempts(), Equals, 3)
+		c.Check(p.RetryDelay(), Equals, 5*time.Second)
+		c.Check(p.Retryable(stockout), Equals, false)
+		c.Check(p.Retryable(quota), Equals, true)
+	}
+
+	{ // policies are checked
+		check := func(kind ModuleKind, p RetryPolicy) error {
+			return checkRetryPolicies(Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "compute", Kind: kind, Retry: p}}})
+		}
+		c.Check(check(PackerKind, RetryPolicy{}), IsNil)
+		c.Check(check(TerraformKind, RetryPolicy{MaxAttempts: 3}), IsNil)
+		c.Check(check(PackerKind, RetryPolicy{MaxAttempts: 3}), ErrorMatches, "group compute: retry is only supported .*")
+		c.Check(check(TerraformKind, RetryPolicy{MaxAttempts: -1}), ErrorMatches, ".*max_attempts must not be negative.*")
+		c.Check(check(TerraformKind, RetryPolicy{Delay: -time.Second}), ErrorMatches, ".*delay must not be negative.*")
+		c.Check(check(TerraformKind, RetryPolicy{RetryableErrors: []string{"("}}), ErrorMatches, ".*is not a regular expression.*")
+	}
+
+	{ // the delay is a duration in YAML
+		var g DeploymentGroup
+		c.Assert(yaml.Unmarshal([]byte("group: compute\nretry:\n  max_attempts: 2\n  delay: 30s\nmodules: []\n"), &g), IsNil)
+		c.Check(g.Retry, DeepEquals, RetryPolicy{MaxAttempts: 2, Delay: 30 * time.Second})
+	}
+}
+
+func (s *MySuite) TestCheckGhpcVersion(c *C) {
+	{ // releases that are recent enough import the blueprint
+		bp := Blueprint{MinimumGhpcVersion: "v1.19.0"}
+		c.Check(bp.checkGhpcVersion("v1.19.0"), IsNil)
+		c.Check(bp.checkGhpcVersion("v1.20.3"), IsNil)
+	}
+
+	{ // older releases are rejected
+		bp := Blueprint{MinimumGhpcVersion: "1.20"}
+		err := bp.checkGhpcVersion("v1.19.1")
+		c.Check(CodeOf(err), Equals, ErrCodeGhpcVersion)
+		c.Check(err, ErrorMatches, ".*requires ghpc 1.20 or later, this is ghpc v1.19.1.*")
+	}
+
+	{ // the minimum must be a version
+		bp := Blueprint{MinimumGhpcVersion: "latest"}
+		c.Check(bp.checkGhpcVersion("v1.19.1"), ErrorMatches, `.*minimum_ghpc_version "latest" is not a version.*`)
+	}
+
+	{ // nothing is checked without a known release
+		bp := Blueprint{MinimumGhpcVersion: "v9.0.0"}
+		c.Check(bp.checkGhpcVersion(""), IsNil)
+		c.Check(bp.checkGhpcVersion("- not built from official release"), IsNil)
+	}
+
+	{ // blueprints expanded by newer releases are only warned about
+		bp := Blueprint{GhpcVersion: "v1.25.0-12-g0123abc"}
+		c.Check(bp.checkGhpcVersion("v1.19.1"), IsNil)
+	}
+
+	{ // the check is made at import
+		ToolkitVersion = "v1.19.1"
+		defer func() { ToolkitVersion = "" }()
+		_, err := NewDeploymentConfigFromData("stdin", []byte("blueprint_name: bp\nminimum_ghpc_version: v1.30.0\ndeployment_groups: []\n"), "")
+		c.Check(CodeOf(err), Equals, ErrCodeGhpcVersion)
+	}
+}
+
+func (s *MySuite) TestResolveFileFunctions(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "scripts"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "scripts", "epilog.sh"), []byte("#!/bin/bash\necho $(hostname)\n"), 0644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "scripts", "prolog.tpl"), []byte("zone=${zone} n=${n}"), 0644), IsNil)
+
+	bpFile := filepath.Join(dir, "bp.yaml")
+	c.Assert(os.WriteFile(bpFile, []byte(`
+blueprint_name: files
+vars:
+  zone: us-central1-a
+  motd: $(file("scripts/epilog.sh"))
+deployment_groups:
+- group: primary
+  modules:
+  - id: vm
+    source: modules/compute/vm-instance
+    settings:
+      epilog: $(file("scripts/epilog.sh"))
+      scripts:
+      - content: $(templatefile("scripts/prolog.tpl", { zone = vars.zone, n = 2 }))
+`), 0644), IsNil)
+
+	// the functions are read as strings, and resolved relative to the blueprint
+	dc, err := NewDeploymentConfig(bpFile)
+	c.Assert(err, IsNil)
+	c.Check(dc.Config.Vars.Get("motd"), DeepEquals, cty.StringVal(`$(file("scripts/epilog.sh"))`))
+	c.Assert(dc.resolveFileFunctions(), IsNil)
+
+	epilog := cty.StringVal(`#!/bin/bash` + "\n" + `echo \$(hostname)` + "\n")
+	c.Check(dc.Config.Vars.Get("motd"), DeepEquals, epilog)
+	mod := dc.Config.DeploymentGroups[0].Modules[0]
+	c.Check(mod.Settings.Get("epilog"), DeepEquals, epilog)
+	c.Check(mod.Settings.Get("scripts"), DeepEquals, cty.TupleVal([]cty.Value{
+		cty.ObjectVal(map[string]cty.Value{"content": cty.StringVal("zone=us-central1-a n=2")})}))
+
+	{ // only deployment variables can be referenced
+		dc := DeploymentConfig{Config: Blueprint{
+			DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{{
+				ID: "vm", Settings: NewDict(map[string]cty.Value{
+					"script": cty.StringVal(`$(templatefile("scripts/prolog.tpl", { zone = homefs.network }))`)})}}}}},
+			blueprintDir: dir}
+		err := dc.resolveFileFunctions()
+		c.Check(CodeOf(err), Equals, ErrCodeFileFunction)
+		c.Check(err, ErrorMatches, ".*module vm setting script: .*can only refer to deployment variables.*")
+	}
+
+	{ // missing files fail
+		dc := DeploymentConfig{Config: Blueprint{
+			Vars: NewDict(map[string]cty.Value{"motd": cty.StringVal(`$(file("missing.txt"))`)})},
+			blueprintDir: dir}
+		c.Check(dc.resolveFileFunctions(), ErrorMatches, ".*deployment variable motd: .*missing.txt.*")
+	}
+
+	{ // files outside of the directory of the blueprint are refused
+		for _, path := range []string{"/etc/passwd", "../secret.txt", "scripts/../../secret.txt"} {
+			dc := DeploymentConfig{Config: Blueprint{
+				Vars: NewDict(map[string]cty.Value{"motd": cty.StringVal(fmt.Sprintf("$(file(%q))", path))})},
+				blueprintDir: dir}
+			err := dc.resolveFileFunctions()
+			c.Check(CodeOf(err), Equals, ErrCodeFileFunction)
+			c.Check(err, ErrorMatches, ".*(absolute path|outside of the directory).*")
+		}
+	}
+
+	c.Check(dc.Config.HasFileFunctions(), Equals, false)
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{{
+		ID: "vm", Settings: NewDict(map[string]cty.Value{
+			"scripts": cty.TupleVal([]cty.Value{cty.StringVal(`$(file("scripts/epilog.sh"))`)})})}}}}}
+	c.Check(bp.HasFileFunctions(), Equals, true)
+}
+
+func (s *MySuite) TestSavedVars(c *C) {
+	c.Check(SavedVarsPath("blueprints/hpc-slurm.yaml"), Equals, "blueprints/hpc-slurm.vars.yaml")
+	c.Check(SavedVarsPath("hpc-slurm"), Equals, "hpc-slurm.vars.yaml")
+
+	path := filepath.Join(c.MkDir(), "hpc-slurm.vars.yaml")
+	{ // a missing vars file has no variables
+		vars, err := ReadSavedVars(path)
+		c.Assert(err, IsNil)
+		c.Check(vars.Items(), HasLen, 0)
+	}
+
+	c.Assert(SaveVars(path, NewDict(map[string]cty.Value{
+		"project_id": cty.StringVal("test-project"),
+		"zone":       cty.StringVal("us-central1-a"),
+	})), IsNil)
+	c.Assert(SaveVars(path, NewDict(map[string]cty.Value{
+		"zone":       cty.StringVal("us-central1-c"),
+		"node_count": cty.NumberIntVal(4),
+	})), IsNil)
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, os.FileMode(0600))
+
+	saved, err := ReadSavedVars(path)
+	c.Assert(err, IsNil)
+	c.Check(saved.Items(), DeepEquals, map[string]cty.Value{
+		"project_id": cty.StringVal("test-project"),
+		"zone":       cty.StringVal("us-central1-c"),
+		"node_count": cty.NumberIntVal(4),
+	})
+
+	// the blueprint and --vars take precedence over the vars file
+	bp := Blueprint{Vars: NewDict(map[string]cty.Value{"project_id": cty.StringVal("bp-project")})}
+	c.Check(bp.SetSavedVars(saved), DeepEquals, []string{"node_count", "zone"})
+	c.Check(bp.Vars.Get("project_id"), DeepEquals, cty.StringVal("bp-project"))
+	c.Check(bp.Vars.Get("zone"), DeepEquals, cty.StringVal("us-central1-c"))
+
+	c.Assert(os.WriteFile(path, []byte("vars: [\n"), 0600), IsNil)
+	_, err = ReadSavedVars(path)
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// gkeNodePoolModule runs the containers of GKE job templates
+const gkeNodePoolModule = "compute/gke-node-pool"
+
+// containerImageSettings are the settings that name container images, by
+// module path
+var containerImageSettings = map[string][]string{
+	"compute/gke-job-template": {"image"},
+}
+
+// imageSettingsFor returns the container image settings of a module
+func imageSettingsFor(m Module) []string {
+	for path, settings := range containerImageSettings {
+		if sourceIs(m.Source, path) {
+			return settings
+		}
+	}
+	return nil
+}
+
+// usesContainerImages returns true if any module runs container images
+func (bp Blueprint) usesContainerImages() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		found = found || len(imageSettingsFor(*m)) > 0
+		return nil
+	})
+	return found
+}
+
+// containerImageModule describes the images of a module that are known
+// before deployment and the service accounts of the node pools it uses; ok is
+// false if the module runs no known images or its project cannot be
+// determined
+func (bp Blueprint) containerImageModule(m Module) (validators.ContainerImageModule, bool) {
+	images := []string{}
+	for _, s := range imageSettingsFor(m) {
+		if !m.Settings.Has(s) {
+			continue
+		}
+		v, ok := evalIfKnown(m.Settings.Get(s), bp)
+		if !ok || v.IsNull() || !v.IsWhollyKnown() {
+			continue
+		}
+		if isNonEmptyString(v) {
+			images = append(images, v.AsString())
+		} else if v.CanIterateElements() {
+			for it := v.ElementIterator(); it.Next(); {
+				if _, el := it.Element(); isNonEmptyString(el) {
+					images = append(images, el.AsString())
+				}
+			}
+		}
+	}
+	if len(images) == 0 {
+		return validators.ContainerImageModule{}, false
+	}
+	project, ok := bp.moduleProject(m)
+	if !ok {
+		return validators.ContainerImageModule{}, false
+	}
+
+	accounts := []string{}
+	for _, id := range referencedModules(m) {
+		pool, err := bp.Module(id)
+		if err != nil || !sourceIs(pool.Source, gkeNodePoolModule) {
+			continue
+		}
+		if sa, ok := bp.nodePoolServiceAccount(*pool); ok && !slices.Contains(accounts, sa) {
+			accounts = append(accounts, sa)
+		}
+	}
+	slices.Sort(accounts)
+	return validators.ContainerImageModule{
+		Module: string(m.ID), ProjectID: project, Images: images, ServiceAccounts: accounts}, true
+}
+
+// nodePoolServiceAccount returns the email of the service account of a GKE
+// node pool, which is the Compute Engine default service account unless set
+func (bp Blueprint) nodePoolServiceAccount(pool Module) (string, bool) {
+	if !pool.Settings.Has("service_account") {
+		return validators.DefaultComputeServiceAccount, true
+	}
+	sa, ok := evalIfKnown(pool.Settings.Get("service_account"), bp)
+	if !ok || sa.IsNull() || !sa.IsWhollyKnown() || !(sa.Type().IsObjectType() || sa.Type().IsMapType()) {
+		return "", false
+	}
+	email, ok := sa.AsValueMap()["email"]
+	if !ok || email.IsNull() {
+		return validators.DefaultComputeServiceAccount, true
+	}
+	if !isNonEmptyString(email) {
+		return "", false
+	}
+	return email.AsString(), true
+}
+
+// referencedModules returns the modules whose outputs the settings of a
+// module refer to
+func referencedModules(m Module) []ModuleID {
+	ids := []ModuleID{}
+	cty.Walk(m.Settings.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
+		e, is := IsExpressionValue(v)
+		if !is {
+			return true, nil
+		}
+		for _, r := range e.References() {
+			if !r.GlobalVar && !slices.Contains(ids, r.Module) {
+				ids = append(ids, r.Module)
+			}
+		}
+		return true, nil
+	})
+	return ids
+}
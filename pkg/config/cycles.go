@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// dependency is an edge of the graph of modules; hop describes how the
+// module depends upon module to, e.g. "setting network_self_link →
+// $(network1.network_self_link)"
+type dependency struct {
+	to  ModuleID
+	hop string
+}
+
+// moduleDependencies returns the modules that a module uses, depends upon or
+// refers to in its settings, in the order of the blueprint
+func moduleDependencies(m Module) []dependency {
+	deps := []dependency{}
+	for _, id := range m.Use {
+		deps = append(deps, dependency{to: id, hop: fmt.Sprintf("use → %s", id)})
+	}
+	for _, id := range m.Depends {
+		deps = append(deps, dependency{to: id, hop: fmt.Sprintf("depends → %s", id)})
+	}
+
+	settings := m.Settings.Items()
+	names := maps.Keys(settings)
+	slices.Sort(names)
+	for _, name := range names {
+		seen := []Reference{}
+		cty.Walk(settings[name], func(p cty.Path, v cty.Value) (bool, error) {
+			e, is := IsExpressionValue(v)
+			if !is {
+				return true, nil
+			}
+			for _, r := range e.References() {
+				if r.GlobalVar || slices.Contains(seen, r) {
+					continue
+				}
+				seen = append(seen, r)
+				deps = append(deps, dependency{
+					to:  r.Module,
+					hop: fmt.Sprintf("setting %s → $(%s.%s)", name, r.Module, r.Name)})
+			}
+			return true, nil
+		})
+	}
+	return deps
+}
+
+// checkReferenceCycles errors if modules depend upon each other through
+// their settings, use lists or depends lists; the error gives the full path
+// of the first cycle found. Terraform cannot deploy such modules, and would
+// only report the cycle after the deployment is written.
+func checkReferenceCycles(bp Blueprint) error {
+	deps := map[ModuleID][]dependency{}
+	order := []ModuleID{}
+	bp.WalkModules(func(m *Module) error {
+		deps[m.ID] = moduleDependencies(*m)
+		order = append(order, m.ID)
+		return nil
+	})
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[ModuleID]int{}
+	path := []ModuleID{}
+	hops := []string{}
+
+	var visit func(id ModuleID) error
+	visit = func(id ModuleID) error {
+		state[id] = visiting
+		path = append(path, id)
+		for _, d := range deps[id] {
+			if _, ok := deps[d.to]; !ok {
+				continue // unknown modules are reported elsewhere
+			}
+			hops = append(hops, d.hop)
+			switch state[d.to] {
+			case visiting:
+				return cycleError(path, hops, d.to)
+			case unvisited:
+				if err := visit(d.to); err != nil {
+					return err
+				}
+			}
+			hops = hops[:len(hops)-1]
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		return nil
+	}
+
+	for _, id := range order {
+		if state[id] == unvisited {
+			if err := visit(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cycleError describes the cycle that closes at module to, the last module
+// of path before hops are taken
+func cycleError(path []ModuleID, hops []string, to ModuleID) error {
+	start := slices.Index(path, to)
+	steps := []string{}
+	for i := start; i < len(path); i++ {
+		steps = append(steps, fmt.Sprintf("module %s %s", path[i], hops[i]))
+	}
+	return configErrorf("referenceCycle", ": %s", strings.Join(steps, " → "))
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"log"
+
+	"hpc-toolkit/pkg/modulereader"
+)
+
+// migrateDeprecatedInputs warns about the settings of modules that set
+// inputs deprecated by their authors, and renames those that have a
+// replacement. Renamed settings keep their provenance as user settings.
+func (dc *DeploymentConfig) migrateDeprecatedInputs() error {
+	return dc.Config.WalkModules(func(m *Module) error {
+		for _, d := range m.InfoOrDie().DeprecatedInputs {
+			if !m.Settings.Has(d.Name) {
+				continue
+			}
+			if d.Replacement == "" {
+				log.Print(deprecatedInputMsg(*m, d, ""))
+				continue
+			}
+			if m.Settings.Has(d.Replacement) {
+				return fmt.Errorf("module %s sets %s and the input %s that it replaces, remove %s",
+					m.ID, d.Replacement, d.Name, d.Name)
+			}
+			log.Print(deprecatedInputMsg(*m, d, fmt.Sprintf(", it was renamed to %s", d.Replacement)))
+			m.renameSetting(d.Name, d.Replacement)
+			if user, ok := dc.userSettings[m.ID]; ok {
+				if v, ok := user[d.Name]; ok {
+					delete(user, d.Name)
+					user[d.Replacement] = v
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func deprecatedInputMsg(m Module, d modulereader.DeprecatedInput, action string) string {
+	msg := fmt.Sprintf("module %s sets the deprecated input %s%s", m.ID, d.Name, action)
+	if d.Message != "" {
+		msg += ": " + d.Message
+	}
+	return msg
+}
+
+// renameSetting moves a setting, and its transforms, to a new name
+func (m *Module) renameSetting(from string, to string) {
+	settings := m.Settings.Items()
+	settings[to] = settings[from]
+	delete(settings, from)
+	m.Settings = NewDict(settings)
+	if t, ok := m.Transforms[from]; ok {
+		m.Transforms[to] = t
+		delete(m.Transforms, from)
+	}
+}
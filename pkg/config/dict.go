@@ -17,10 +17,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/zclconf/go-cty/cty"
 	"github.com/zclconf/go-cty/cty/gocty"
 	ctyJson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 )
 
@@ -80,11 +83,182 @@ func (d *Dict) Items() map[string]cty.Value {
 	return m
 }
 
+// IsZero returns true if the Dict is empty, so that Dict fields tagged
+// omitempty are not marshaled when empty
+func (d Dict) IsZero() bool {
+	return len(d.m) == 0
+}
+
 // AsObject returns Dict as cty.ObjectVal
 func (d *Dict) AsObject() cty.Value {
 	return cty.ObjectVal(d.Items())
 }
 
+// GetPath returns the value at a path of keys, the first of which is a key of
+// the Dict and the others attributes of objects or keys of maps, or
+// cty.NilVal if there is none. As with cty, the marks of objects and maps are
+// carried by the values within them.
+func (d *Dict) GetPath(path []string) cty.Value {
+	if len(path) == 0 {
+		return cty.NilVal
+	}
+	v := d.Get(path[0])
+	for _, k := range path[1:] {
+		raw, marks := v.Unmark()
+		if v == cty.NilVal || !isTraversable(raw) {
+			return cty.NilVal
+		}
+		el, ok := raw.AsValueMap()[k]
+		if !ok {
+			return cty.NilVal
+		}
+		v = el.WithMarks(marks)
+	}
+	return v
+}
+
+// SetPath sets the value at a path of keys, as read by GetPath, creating the
+// objects missing along the path. Objects and maps along the path keep their
+// marks. It errors if the path goes through a value that is neither an object
+// nor a map, such as an expression.
+func (d *Dict) SetPath(path []string, v cty.Value) error {
+	if len(path) == 0 {
+		return fmt.Errorf("cannot set a value at an empty path")
+	}
+	if len(path) == 1 {
+		d.Set(path[0], v)
+		return nil
+	}
+	cur := cty.EmptyObjectVal
+	if d.Has(path[0]) {
+		cur = d.Get(path[0])
+	}
+	nv, err := setPath(cur, path, 1, v)
+	if err != nil {
+		return err
+	}
+	d.Set(path[0], nv)
+	return nil
+}
+
+func setPath(obj cty.Value, path []string, i int, v cty.Value) (cty.Value, error) {
+	raw, marks := obj.Unmark()
+	if raw.IsKnown() && raw.IsNull() {
+		raw = cty.EmptyObjectVal
+	}
+	if !isTraversable(raw) {
+		return cty.NilVal, fmt.Errorf("cannot set %s: %s is %s rather than an object or a map",
+			strings.Join(path, "."), strings.Join(path[:i], "."), describeType(obj))
+	}
+	m := raw.AsValueMap()
+	if m == nil {
+		m = map[string]cty.Value{}
+	}
+	if i == len(path)-1 {
+		m[path[i]] = v
+	} else {
+		child, ok := m[path[i]]
+		if !ok {
+			child = cty.EmptyObjectVal
+		}
+		nc, err := setPath(child, path, i+1, v)
+		if err != nil {
+			return cty.NilVal, err
+		}
+		m[path[i]] = nc
+	}
+	return rebuild(raw, m).WithMarks(marks), nil
+}
+
+// MergeDeep sets the values of other in the Dict, merging the objects and
+// maps present in both key by key, at any depth, rather than replacing them;
+// other values of other replace those of the Dict. Merged objects and maps
+// carry the marks of both. Returns reference to Dict-self.
+func (d *Dict) MergeDeep(other Dict) *Dict {
+	for k, v := range other.Items() {
+		if d.Has(k) {
+			v = mergeDeep(d.Get(k), v)
+		}
+		d.Set(k, v)
+	}
+	return d
+}
+
+func mergeDeep(a cty.Value, b cty.Value) cty.Value {
+	ra, ma := a.Unmark()
+	rb, mb := b.Unmark()
+	if !isTraversable(ra) || !isTraversable(rb) {
+		return b
+	}
+	m := ra.AsValueMap()
+	if m == nil {
+		m = map[string]cty.Value{}
+	}
+	for k, bv := range rb.AsValueMap() {
+		if av, ok := m[k]; ok {
+			m[k] = mergeDeep(av, bv)
+		} else {
+			m[k] = bv
+		}
+	}
+	if !rb.Type().IsMapType() {
+		ra = cty.EmptyObjectVal
+	}
+	return rebuild(ra, m).WithMarks(ma, mb)
+}
+
+// Walk calls fn for every value of the Dict, in the order of the keys, and for
+// the elements of its objects, maps, lists and sets, depth first, with their
+// paths from the Dict. Values are passed with their marks, such as those of
+// expressions. The elements of a value are skipped if fn returns false for it.
+func (d *Dict) Walk(fn func(cty.Path, cty.Value) (bool, error)) error {
+	keys := maps.Keys(d.Items())
+	slices.Sort(keys)
+	for _, k := range keys {
+		err := cty.Walk(d.Get(k), func(p cty.Path, v cty.Value) (bool, error) {
+			return fn(append(cty.GetAttrPath(k), p...), v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTraversable returns true if an unmarked value is an object or a map whose
+// attributes or keys are known
+func isTraversable(v cty.Value) bool {
+	ty := v.Type()
+	return (ty.IsObjectType() || ty.IsMapType()) && v.IsKnown() && !v.IsNull()
+}
+
+// rebuild returns the object of m, or the map of m if orig is a map and the
+// values of m still have the same type
+func rebuild(orig cty.Value, m map[string]cty.Value) cty.Value {
+	if orig.Type().IsMapType() && len(m) > 0 {
+		var ty cty.Type
+		same := true
+		for _, v := range m {
+			if ty == cty.NilType {
+				ty = v.Type()
+			}
+			same = same && v.Type().Equals(ty)
+		}
+		if same {
+			return cty.MapVal(m)
+		}
+	}
+	return cty.ObjectVal(m)
+}
+
+// describeType names the type of a value, or tells that it is an expression
+func describeType(v cty.Value) string {
+	if _, is := IsExpressionValue(v); is {
+		return "an expression"
+	}
+	return "of type " + v.Type().FriendlyName()
+}
+
 // YamlValue is wrapper around cty.Value to handle YAML unmarshal.
 type YamlValue struct {
 	v cty.Value
@@ -95,6 +269,15 @@ func (y YamlValue) Unwrap() cty.Value {
 	return y.v
 }
 
+// MarshalYAML implements custom YAML marshaling.
+func (y YamlValue) MarshalYAML() (interface{}, error) {
+	g, err := NewDict(map[string]cty.Value{"v": y.v}).MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	return g.(map[string]interface{})["v"], nil
+}
+
 // UnmarshalYAML implements custom YAML unmarshaling.
 func (y *YamlValue) UnmarshalYAML(n *yaml.Node) error {
 	var err error
@@ -130,6 +313,8 @@ func (y *YamlValue) unmarshalScalar(n *yaml.Node) error {
 			return err
 		}
 		y.v = e.AsValue()
+	} else if y.v.Type() == cty.String && isFileFunction(y.v.AsString()) {
+		// kept as a string until expansion reads the file, see resolveFileFunctions
 	} else if y.v.Type() == cty.String && hasVariable(y.v.AsString()) { // "simple" variable
 		e, err := SimpleVarToExpression(y.v.AsString())
 		if err != nil {
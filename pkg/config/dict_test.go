@@ -15,6 +15,7 @@
 package config
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -209,3 +210,135 @@ func TestEval(t *testing.T) {
 		t.Errorf("diff (-want +got):\n%s", diff)
 	}
 }
+
+func TestGetAndSetPath(t *testing.T) {
+	d := NewDict(map[string]cty.Value{
+		"labels": cty.MapVal(map[string]cty.Value{"env": cty.StringVal("dev")}).Mark("user"),
+		"name":   cty.StringVal("cluster"),
+		"ref":    GlobalRef("network").AsExpression().AsValue(),
+	})
+
+	if err := d.SetPath([]string{"labels", "team"}, cty.StringVal("hpc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetPath([]string{"network", "subnet", "cidr"}, cty.StringVal("10.0.0.0/16")); err != nil {
+		t.Fatal(err)
+	}
+
+	// maps stay maps while their values share a type, and keep their marks
+	want := cty.MapVal(map[string]cty.Value{"env": cty.StringVal("dev"), "team": cty.StringVal("hpc")}).Mark("user")
+	if got := d.Get("labels"); !got.RawEquals(want) {
+		t.Errorf("got labels %#v, want %#v", got, want)
+	}
+	if got := d.GetPath([]string{"labels", "team"}); !got.RawEquals(cty.StringVal("hpc").Mark("user")) {
+		t.Errorf("got labels.team %#v", got)
+	}
+	if got := d.GetPath([]string{"network", "subnet", "cidr"}); !got.RawEquals(cty.StringVal("10.0.0.0/16")) {
+		t.Errorf("got network.subnet.cidr %#v", got)
+	}
+
+	for _, p := range [][]string{{}, {"missing"}, {"name", "first"}, {"labels", "owner"}, {"ref", "id"}} {
+		if got := d.GetPath(p); got != cty.NilVal {
+			t.Errorf("GetPath(%q) = %#v, want cty.NilVal", p, got)
+		}
+	}
+
+	if err := d.SetPath([]string{"name", "first"}, cty.True); err == nil || err.Error() != "cannot set name.first: name is of type string rather than an object or a map" {
+		t.Errorf("got error %v", err)
+	}
+	if err := d.SetPath([]string{"ref", "id"}, cty.True); err == nil || err.Error() != "cannot set ref.id: ref is an expression rather than an object or a map" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestMergeDeep(t *testing.T) {
+	d := NewDict(map[string]cty.Value{
+		"instance_image": cty.ObjectVal(map[string]cty.Value{
+			"family":  cty.StringVal("hpc-centos-7"),
+			"project": cty.StringVal("cloud-hpc-image-public"),
+		}),
+		"labels":        cty.MapVal(map[string]cty.Value{"env": cty.StringVal("dev")}).Mark("user"),
+		"machine_type":  cty.StringVal("c2-standard-60"),
+		"network_ids":   cty.TupleVal([]cty.Value{cty.StringVal("a")}),
+		"service_scope": cty.StringVal("cloud-platform"),
+	})
+	d.MergeDeep(NewDict(map[string]cty.Value{
+		"instance_image": cty.ObjectVal(map[string]cty.Value{"family": cty.StringVal("hpc-rocky-linux-8")}),
+		"labels":         cty.MapVal(map[string]cty.Value{"team": cty.StringVal("hpc")}).Mark("group"),
+		"machine_type":   cty.StringVal("c2d-standard-112"),
+		"network_ids":    cty.TupleVal([]cty.Value{cty.StringVal("b")}),
+	}))
+
+	want := map[string]cty.Value{
+		"instance_image": cty.ObjectVal(map[string]cty.Value{
+			"family":  cty.StringVal("hpc-rocky-linux-8"),
+			"project": cty.StringVal("cloud-hpc-image-public"),
+		}),
+		"labels": cty.MapVal(map[string]cty.Value{
+			"env": cty.StringVal("dev"), "team": cty.StringVal("hpc")}).WithMarks(cty.NewValueMarks("user", "group")),
+		"machine_type": cty.StringVal("c2d-standard-112"),
+		// lists are replaced rather than concatenated
+		"network_ids":   cty.TupleVal([]cty.Value{cty.StringVal("b")}),
+		"service_scope": cty.StringVal("cloud-platform"),
+	}
+	got := d.Items()
+	if len(got) != len(want) {
+		t.Errorf("got %d values, want %d", len(got), len(want))
+	}
+	for k, w := range want {
+		if !got[k].RawEquals(w) {
+			t.Errorf("got %s = %#v, want %#v", k, got[k], w)
+		}
+	}
+}
+
+func TestDictWalk(t *testing.T) {
+	ref := GlobalRef("zone").AsExpression().AsValue()
+	d := NewDict(map[string]cty.Value{
+		"zone": ref,
+		"disks": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"size": cty.NumberIntVal(100)}).Mark("used"),
+		}),
+	})
+
+	got := []string{}
+	err := d.Walk(func(p cty.Path, v cty.Value) (bool, error) {
+		_, expr := IsExpressionValue(v)
+		_, used := HasMark[string](v)
+		got = append(got, fmt.Sprintf("%s expression=%t marked=%t", pathString(p), expr, used))
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"disks expression=false marked=false",
+		"disks[0] expression=false marked=true",
+		"disks[0].size expression=false marked=false",
+		"zone expression=true marked=false",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+// pathString formats a path of attributes and indexes, e.g. disks[0].size
+func pathString(p cty.Path) string {
+	s := ""
+	for _, step := range p {
+		switch st := step.(type) {
+		case cty.GetAttrStep:
+			if s != "" {
+				s += "."
+			}
+			s += st.Name
+		case cty.IndexStep:
+			if st.Key.Type() == cty.String {
+				s += fmt.Sprintf("[%q]", st.Key.AsString())
+			} else {
+				s += fmt.Sprintf("[%s]", st.Key.AsBigFloat().String())
+			}
+		}
+	}
+	return s
+}
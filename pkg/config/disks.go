@@ -0,0 +1,149 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"math/big"
+	"strings"
+
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// diskRule holds the defaults of the boot disk settings of a module
+type diskRule struct {
+	diskSizeGB int64
+	image      map[string]string
+	// localSSDs is true if the module sets local_ssd_count
+	localSSDs bool
+}
+
+var slurmImage = map[string]string{
+	"family":  "slurm-gcp-5-7-hpc-centos-7",
+	"project": "projects/schedmd-slurm-public/global/images/family",
+}
+
+// diskRules are keyed by module path
+var diskRules = map[string]diskRule{
+	"compute/vm-instance": {
+		diskSizeGB: 200,
+		image:      map[string]string{"family": "hpc-centos-7", "project": "cloud-hpc-image-public"},
+		localSSDs:  true,
+	},
+	"compute/schedmd-slurm-gcp-v5-node-group":   {diskSizeGB: 50, image: slurmImage},
+	"scheduler/schedmd-slurm-gcp-v5-controller": {diskSizeGB: 50, image: slurmImage},
+	"scheduler/schedmd-slurm-gcp-v5-login":      {diskSizeGB: 50, image: slurmImage},
+}
+
+// Slurm settings that override instance_image
+var slurmSourceImageSettings = []string{"source_image", "source_image_family", "source_image_project"}
+
+func diskRuleFor(m Module) (diskRule, bool) {
+	for path, r := range diskRules {
+		if sourceIs(m.Source, path) {
+			return r, true
+		}
+	}
+	return diskRule{}, false
+}
+
+// setsDiskSizes returns true if any module sets the size of its boot disk,
+// its image or its local SSDs
+func (bp Blueprint) setsDiskSizes() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		if _, ok := diskRuleFor(*m); ok {
+			for _, s := range []string{"disk_size_gb", "instance_image", "local_ssd_count"} {
+				found = found || m.Settings.Has(s)
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// evalInt returns the value of an integer setting, or its default if unset
+func (bp Blueprint) evalInt(m Module, setting string, def int64) (int64, bool) {
+	if !m.Settings.Has(setting) {
+		return def, true
+	}
+	v, ok := evalIfKnown(m.Settings.Get(setting), bp)
+	if !ok || v.IsNull() || !v.IsKnown() || v.Type() != cty.Number {
+		return 0, false
+	}
+	n, acc := v.AsBigFloat().Int64()
+	return n, acc == big.Exact
+}
+
+// diskModule describes the boot disk and local SSDs of a module; ok is false
+// if the module creates no known VMs. The image is left empty if it, or the
+// size of the disk, is not known before deployment.
+func (bp Blueprint) diskModule(m Module) (validators.DiskModule, bool) {
+	r, ok := diskRuleFor(m)
+	if !ok {
+		return validators.DiskModule{}, false
+	}
+	dm := validators.DiskModule{Module: string(m.ID)}
+	if mt, ok := bp.moduleMachineType(m); ok {
+		dm.MachineType = mt
+	}
+	if r.localSSDs {
+		dm.LocalSSDCount, _ = bp.evalInt(m, "local_ssd_count", 0)
+	}
+
+	size, ok := bp.evalInt(m, "disk_size_gb", r.diskSizeGB)
+	if !ok {
+		return dm, true
+	}
+	if project, family, name, ok := bp.bootImage(m, r); ok {
+		dm.ImageProject, dm.ImageFamily, dm.ImageName = project, family, name
+		dm.DiskSizeGB = size
+	}
+	return dm, true
+}
+
+// bootImage returns the project and the family or name of the boot image of
+// a module; ok is false if the image is not known before deployment
+func (bp Blueprint) bootImage(m Module, r diskRule) (project string, family string, name string, ok bool) {
+	for _, s := range slurmSourceImageSettings {
+		if m.Settings.Has(s) {
+			return "", "", "", false
+		}
+	}
+	image := map[string]string{}
+	if !m.Settings.Has("instance_image") {
+		image = r.image
+	} else {
+		v, ok := evalIfKnown(m.Settings.Get("instance_image"), bp)
+		if !ok || v.IsNull() || !v.IsWhollyKnown() || !(v.Type().IsObjectType() || v.Type().IsMapType()) {
+			return "", "", "", false
+		}
+		for k, el := range v.AsValueMap() {
+			if isNonEmptyString(el) {
+				image[k] = el.AsString()
+			}
+		}
+	}
+	// Slurm modules accept the project as projects/PROJECT/global/images/...
+	project = image["project"]
+	if strings.HasPrefix(project, "projects/") {
+		project, _, _ = strings.Cut(strings.TrimPrefix(project, "projects/"), "/")
+	}
+	if project == "" || (image["family"] == "") == (image["name"] == "") {
+		return "", "", "", false
+	}
+	return project, image["family"], image["name"], true
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// isEnabled evaluates the enabled flag of a module in the context of the
+// deployment variables; modules without the flag are enabled
+func (bp Blueprint) isEnabled(m Module) (bool, error) {
+	if m.Enabled == nil {
+		return true, nil
+	}
+	d, err := NewDict(map[string]cty.Value{"enabled": m.Enabled.Unwrap()}).Eval(bp)
+	if err != nil {
+		return false, fmt.Errorf("module %s: enabled: %w", m.ID, err)
+	}
+	v := d.Get("enabled")
+	if v.Type() != cty.Bool || v.IsNull() || !v.IsKnown() {
+		return false, fmt.Errorf("module %s: enabled must be a boolean known before deployment, got %s", m.ID, v.Type().FriendlyName())
+	}
+	return v.True(), nil
+}
+
+// dropDisabledModules removes the modules whose enabled flag is false, and
+// the groups left without modules, from the blueprint. Modules that use or
+// depend upon disabled modules no longer do; settings that refer to the
+// outputs of disabled modules are errors. So are Terraform groups left without
+// modules that were already written to the deployment directory, deployDir,
+// as ghpc destroy would not destroy the resources of their state once they
+// are left out.
+func (bp *Blueprint) dropDisabledModules(deployDir string) error {
+	disabled := map[ModuleID]bool{}
+	err := bp.WalkModules(func(m *Module) error {
+		on, err := bp.isEnabled(*m)
+		if err != nil {
+			return err
+		}
+		if !on {
+			disabled[m.ID] = true
+		}
+		m.Enabled = nil
+		return nil
+	})
+	if err != nil || len(disabled) == 0 {
+		return err
+	}
+
+	droppedGroups := map[GroupName]bool{}
+	groups := []DeploymentGroup{}
+	for _, g := range bp.DeploymentGroups {
+		mods := []Module{}
+		for _, m := range g.Modules {
+			if disabled[m.ID] {
+				log.Printf("module %s is disabled and left out of the deployment", m.ID)
+				continue
+			}
+			mods = append(mods, m)
+		}
+		if len(mods) == 0 && len(g.Modules) > 0 {
+			if groupDir := filepath.Join(deployDir, string(g.Name)); deployDir != "" && isTerraformGroup(g) && isDir(groupDir) {
+				return fmt.Errorf("deployment group %s has no enabled modules, but was written to %s; destroy its resources first, "+
+					"e.g. with terraform -chdir=%s destroy, or keep one of its modules enabled", g.Name, groupDir, groupDir)
+			}
+			log.Printf("deployment group %s has no enabled modules and is left out of the deployment", g.Name)
+			droppedGroups[g.Name] = true
+			continue
+		}
+		g.Modules = mods
+		groups = append(groups, g)
+	}
+	bp.DeploymentGroups = groups
+
+	for i := range bp.Validators {
+		v := &bp.Validators[i]
+		v.IgnoreModules = without(v.IgnoreModules, disabled)
+		v.IgnoreGroups = without(v.IgnoreGroups, droppedGroups)
+	}
+
+	return bp.WalkModules(func(m *Module) error {
+		for _, id := range m.Use {
+			if disabled[id] {
+				log.Printf("module %s no longer uses module %s, which is disabled", m.ID, id)
+				delete(m.useMaps, id)
+				delete(m.useModes, id)
+			}
+		}
+		m.Use = without(m.Use, disabled)
+		m.Depends = without(m.Depends, disabled)
+
+		settings := maps.Keys(m.Settings.Items())
+		slices.Sort(settings)
+		for _, setting := range settings {
+			if id, ok := disabledReference(m.Settings.Get(setting), disabled); ok {
+				return fmt.Errorf("module %s: setting %s refers to module %s, which is disabled", m.ID, setting, id)
+			}
+		}
+		return nil
+	})
+}
+
+// isTerraformGroup returns true if the group has modules other than Packer
+// modules, whose kinds may not be set yet
+func isTerraformGroup(g DeploymentGroup) bool {
+	return slices.ContainsFunc(g.Modules, func(m Module) bool { return m.Kind != PackerKind })
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// without returns the elements of s that are not in dropped
+func without[T comparable](s []T, dropped map[T]bool) []T {
+	if s == nil {
+		return nil
+	}
+	kept := []T{}
+	for _, e := range s {
+		if !dropped[e] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// disabledReference returns a disabled module that the value refers to
+func disabledReference(v cty.Value, disabled map[ModuleID]bool) (ModuleID, bool) {
+	var found ModuleID
+	cty.Walk(v, func(p cty.Path, v cty.Value) (bool, error) {
+		e, is := IsExpressionValue(v)
+		if !is {
+			return true, nil
+		}
+		for _, r := range e.References() {
+			if !r.GlobalVar && disabled[r.Module] && found == "" {
+				found = r.Module
+			}
+		}
+		return true, nil
+	})
+	return found, found != ""
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode identifies the kind of a failure to import, expand or validate a
+// blueprint. Codes are stable across releases, unlike error messages, so
+// callers should match on them rather than on the text of errors.
+type ErrorCode string
+
+// Codes of the failures detected by ghpc. A code is never reused for another
+// kind of failure once released.
+const (
+	ErrCodeAppendToNonList      ErrorCode = "GHPC-CFG-001"
+	ErrCodeFileLoad             ErrorCode = "GHPC-CFG-002"
+	ErrCodeYamlUnmarshal        ErrorCode = "GHPC-CFG-003"
+	ErrCodeYamlMarshal          ErrorCode = "GHPC-CFG-004"
+	ErrCodeFileSave             ErrorCode = "GHPC-CFG-005"
+	ErrCodeMissingSetting       ErrorCode = "GHPC-CFG-006"
+	ErrCodeGlobalLabelType      ErrorCode = "GHPC-CFG-007"
+	ErrCodeSettingsLabelType    ErrorCode = "GHPC-CFG-008"
+	ErrCodeInvalidVar           ErrorCode = "GHPC-CFG-009"
+	ErrCodeInvalidMod           ErrorCode = "GHPC-CFG-010"
+	ErrCodeInvalidDeploymentRef ErrorCode = "GHPC-CFG-011"
+	ErrCodeVarNotFound          ErrorCode = "GHPC-CFG-012"
+	ErrCodeIntergroupOrder      ErrorCode = "GHPC-CFG-013"
+	ErrCodeReferenceCycle       ErrorCode = "GHPC-CFG-014"
+	ErrCodeReferenceWrongGroup  ErrorCode = "GHPC-CFG-015"
+	ErrCodeNoOutput             ErrorCode = "GHPC-CFG-016"
+	ErrCodeGroupNotFound        ErrorCode = "GHPC-CFG-017"
+	ErrCodeCannotUsePacker      ErrorCode = "GHPC-CFG-018"
+	ErrCodeBackendNotFound      ErrorCode = "GHPC-CFG-019"
+	ErrCodeBackendAndProfile    ErrorCode = "GHPC-CFG-020"
+	ErrCodeEmptyBackendType     ErrorCode = "GHPC-CFG-021"
+	ErrCodeUnscopedValidator    ErrorCode = "GHPC-CFG-022"
+	ErrCodeModulePolicy         ErrorCode = "GHPC-CFG-023"
+	ErrCodeEmptyID              ErrorCode = "GHPC-CFG-024"
+	ErrCodeEmptySource          ErrorCode = "GHPC-CFG-025"
+	ErrCodeWrongKind            ErrorCode = "GHPC-CFG-026"
+	ErrCodeExtraSetting         ErrorCode = "GHPC-CFG-027"
+	ErrCodeSettingWithPeriod    ErrorCode = "GHPC-CFG-028"
+	ErrCodeSettingInvalidChar   ErrorCode = "GHPC-CFG-029"
+	ErrCodeDuplicateGroup       ErrorCode = "GHPC-CFG-030"
+	ErrCodeDuplicateID          ErrorCode = "GHPC-CFG-031"
+	ErrCodeEmptyGroupName       ErrorCode = "GHPC-CFG-032"
+	ErrCodeIllegalChars         ErrorCode = "GHPC-CFG-033"
+	ErrCodeInvalidOutput        ErrorCode = "GHPC-CFG-034"
+	ErrCodeVarNotDefined        ErrorCode = "GHPC-CFG-035"
+	ErrCodeValueNotString       ErrorCode = "GHPC-CFG-036"
+	ErrCodeValueEmptyString     ErrorCode = "GHPC-CFG-037"
+	ErrCodeLabelNameReqs        ErrorCode = "GHPC-CFG-038"
+	ErrCodeLabelValueReqs       ErrorCode = "GHPC-CFG-039"
+	ErrCodeMultipleBlueprints   ErrorCode = "GHPC-CFG-040"
+	ErrCodeBlueprintNotFound    ErrorCode = "GHPC-CFG-041"
+	ErrCodeDuplicateBlueprint   ErrorCode = "GHPC-CFG-042"
+	ErrCodePrefixTemplate       ErrorCode = "GHPC-CFG-043"
+	ErrCodeSharedStatePrefix    ErrorCode = "GHPC-CFG-044"
+	ErrCodeGhpcVersion          ErrorCode = "GHPC-CFG-045"
+	ErrCodeFileFunction         ErrorCode = "GHPC-CFG-046"
+	ErrCodeDuplicateOutput      ErrorCode = "GHPC-CFG-047"
+
+	// ErrCodeImport is the code of the failures to import a blueprint that
+	// have no code of their own
+	ErrCodeImport ErrorCode = "GHPC-CFG-100"
+	// ErrCodeExpansion is the code of the failures to expand a blueprint
+	// that have no code of their own
+	ErrCodeExpansion ErrorCode = "GHPC-CFG-200"
+	// ErrCodeValidationFailed is the code of a blueprint that fails the
+	// validators at validation level ERROR
+	ErrCodeValidationFailed ErrorCode = "GHPC-CFG-300"
+	// ErrCodeValidatorTimeout is the code of ValidatorTimeoutError
+	ErrCodeValidatorTimeout ErrorCode = "GHPC-CFG-301"
+)
+
+// errorCodes are the codes of the errorMessages
+var errorCodes = map[string]ErrorCode{
+	"appendToNonList":      ErrCodeAppendToNonList,
+	"fileLoadError":        ErrCodeFileLoad,
+	"yamlUnmarshalError":   ErrCodeYamlUnmarshal,
+	"yamlMarshalError":     ErrCodeYamlMarshal,
+	"fileSaveError":        ErrCodeFileSave,
+	"missingSetting":       ErrCodeMissingSetting,
+	"globalLabelType":      ErrCodeGlobalLabelType,
+	"settingsLabelType":    ErrCodeSettingsLabelType,
+	"invalidVar":           ErrCodeInvalidVar,
+	"invalidMod":           ErrCodeInvalidMod,
+	"invalidDeploymentRef": ErrCodeInvalidDeploymentRef,
+	"varNotFound":          ErrCodeVarNotFound,
+	"intergroupOrder":      ErrCodeIntergroupOrder,
+	"referenceCycle":       ErrCodeReferenceCycle,
+	"referenceWrongGroup":  ErrCodeReferenceWrongGroup,
+	"noOutput":             ErrCodeNoOutput,
+	"groupNotFound":        ErrCodeGroupNotFound,
+	"cannotUsePacker":      ErrCodeCannotUsePacker,
+	"backendNotFound":      ErrCodeBackendNotFound,
+	"backendAndProfile":    ErrCodeBackendAndProfile,
+	"emptyBackendType":     ErrCodeEmptyBackendType,
+	"unscopedValidator":    ErrCodeUnscopedValidator,
+	"modulePolicy":         ErrCodeModulePolicy,
+	"emptyID":              ErrCodeEmptyID,
+	"emptySource":          ErrCodeEmptySource,
+	"wrongKind":            ErrCodeWrongKind,
+	"extraSetting":         ErrCodeExtraSetting,
+	"settingWithPeriod":    ErrCodeSettingWithPeriod,
+	"settingInvalidChar":   ErrCodeSettingInvalidChar,
+	"duplicateGroup":       ErrCodeDuplicateGroup,
+	"duplicateID":          ErrCodeDuplicateID,
+	"emptyGroupName":       ErrCodeEmptyGroupName,
+	"illegalChars":         ErrCodeIllegalChars,
+	"invalidOutput":        ErrCodeInvalidOutput,
+	"duplicateOutput":      ErrCodeDuplicateOutput,
+	"varNotDefined":        ErrCodeVarNotDefined,
+	"valueNotString":       ErrCodeValueNotString,
+	"valueEmptyString":     ErrCodeValueEmptyString,
+	"labelNameReqs":        ErrCodeLabelNameReqs,
+	"labelValueReqs":       ErrCodeLabelValueReqs,
+	"multipleBlueprints":   ErrCodeMultipleBlueprints,
+	"blueprintNotFound":    ErrCodeBlueprintNotFound,
+	"duplicateBlueprint":   ErrCodeDuplicateBlueprint,
+	"prefixTemplate":       ErrCodePrefixTemplate,
+	"sharedStatePrefix":    ErrCodeSharedStatePrefix,
+	"ghpcVersion":          ErrCodeGhpcVersion,
+	"fileFunction":         ErrCodeFileFunction,
+}
+
+// CodedError is implemented by the errors of this package that have a code
+type CodedError interface {
+	error
+	ErrorCode() ErrorCode
+}
+
+// Error is a failure to import, expand or validate a blueprint, with the code
+// of its kind
+type Error struct {
+	Code ErrorCode
+	err  error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error that caused the failure
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// ErrorCode returns the code of the failure
+func (e *Error) ErrorCode() ErrorCode {
+	return e.Code
+}
+
+// CodeOf returns the code of the first CodedError in the chain of err, or ""
+// if there is none
+func CodeOf(err error) ErrorCode {
+	var ce CodedError
+	if errors.As(err, &ce) {
+		return ce.ErrorCode()
+	}
+	return ""
+}
+
+// configErrorf returns the error of errorMessages[key] followed by the
+// details of format, with the code of key
+func configErrorf(key string, format string, args ...interface{}) error {
+	return &Error{Code: errorCodes[key], err: fmt.Errorf(errorMessages[key]+format, args...)}
+}
+
+// withCode gives err the code, unless it already has one
+func withCode(code ErrorCode, err error) error {
+	if err == nil || CodeOf(err) != "" {
+		return err
+	}
+	return &Error{Code: code, err: err}
+}
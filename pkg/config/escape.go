@@ -0,0 +1,39 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "strings"
+
+// Blueprint strings are read literally, except that "$(...)" is a variable
+// and a string wrapped in "((...))" is an HCL literal. A backslash before "$("
+// or "((" escapes them; no other character is escaped, so a backslash that is
+// not followed by "$(" or "((" is part of the string. Terraform template
+// sequences "${" and "%{" need no escaping in blueprints: they are written to
+// deployments as "$${" and "%%{", which Terraform reads literally.
+
+// EscapeBlueprintString returns the blueprint string that is read as s, with
+// neither variables nor HCL literals. UnescapeBlueprintString reverses it.
+func EscapeBlueprintString(s string) string {
+	s = strings.ReplaceAll(s, "$(", `\$(`)
+	return strings.ReplaceAll(s, "((", `\((`)
+}
+
+// UnescapeBlueprintString returns the string that a blueprint string which is
+// neither a variable nor an HCL literal stands for, i.e. the value written to
+// deployments. The order of replacements matters: `\$\((` is "$((".
+func UnescapeBlueprintString(s string) string {
+	s = strings.ReplaceAll(s, `\((`, "((")
+	return strings.ReplaceAll(s, `\$(`, "$(")
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// allStrings returns every string of up to n characters of the alphabet
+func allStrings(alphabet string, n int) []string {
+	all, last := []string{""}, []string{""}
+	for i := 0; i < n; i++ {
+		next := []string{}
+		for _, s := range last {
+			for _, c := range alphabet {
+				next = append(next, s+string(c))
+			}
+		}
+		all, last = append(all, next...), next
+	}
+	return all
+}
+
+func TestEscapeBlueprintString(t *testing.T) {
+	type test struct {
+		s    string
+		want string
+	}
+	tests := []test{
+		{"echo $(cat /tmp/file)", `echo \$(cat /tmp/file)`},
+		{"((not.literal))", `\((not.literal))`},
+		{"$((1 + 1))", `\$\((1 + 1))`},
+		{`C:\$(dir)`, `C:\\$(dir)`},
+		{"${var.region}", "${var.region}"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.s, func(t *testing.T) {
+			if got := EscapeBlueprintString(tc.s); got != tc.want {
+				t.Errorf("EscapeBlueprintString(%q) = %q, want %q", tc.s, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEscapeRoundTrip(t *testing.T) {
+	for _, s := range allStrings(`$()\a`, 6) {
+		e := EscapeBlueprintString(s)
+		if got := UnescapeBlueprintString(e); got != s {
+			t.Fatalf("UnescapeBlueprintString(%q) = %q, want %q", e, got, s)
+		}
+
+		// the escaped string survives import and export unchanged
+		var d Dict
+		if err := yaml.Unmarshal([]byte("s: "+yamlQuote(t, e)), &d); err != nil {
+			t.Fatalf("failed to import %q: %v", e, err)
+		}
+		v := d.Get("s")
+		if _, is := IsExpressionValue(v); is || v.Type() != cty.String || v.AsString() != e {
+			t.Fatalf("imported %q as %#v", e, v)
+		}
+		out, err := yaml.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "s: " + yamlQuote(t, e) + "\n"; string(out) != want {
+			t.Fatalf("exported %q as %q, want %q", e, out, want)
+		}
+	}
+}
+
+func yamlQuote(t *testing.T, s string) string {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b[:len(b)-1])
+}
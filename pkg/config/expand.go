@@ -35,6 +35,11 @@ const (
 	roleLabel       string = "ghpc_role"
 )
 
+// DeploymentIDLabel is the label that ghpc create sets to an id unique to the
+// deployment directory, as opposed to ghpc_deployment, its name, which other
+// deployments may share
+const DeploymentIDLabel string = "ghpc_deployment_id"
+
 var (
 	// Checks if a variable exists only as a substring, ex:
 	// Matches: "a$(vars.example)", "word $(vars.example)", "word$(vars.example)", "$(vars.example)"
@@ -45,37 +50,50 @@ var (
 
 // expand expands variables and strings in the yaml config. Used directly by
 // ExpandConfig for the create and expand commands.
-func (dc *DeploymentConfig) expand() {
+func (dc *DeploymentConfig) expand() error {
 	if err := dc.addMetadataToModules(); err != nil {
 		log.Printf("could not determine required APIs: %v", err)
 	}
 
 	if err := dc.expandBackends(); err != nil {
-		log.Fatalf("failed to apply default backend to deployment groups: %v", err)
+		return fmt.Errorf("failed to apply default backend to deployment groups: %w", err)
+	}
+	if err := checkStatePrefixes(dc.Config); err != nil {
+		return err
+	}
+
+	if err := dc.tracePhase("validator injection", dc.addDefaultValidators); err != nil {
+		return fmt.Errorf(
+			"failed to update validators when expanding the config: %w", err)
 	}
 
-	if err := dc.addDefaultValidators(); err != nil {
-		log.Fatalf(
-			"failed to update validators when expanding the config: %v", err)
+	if err := dc.tracePhase("label merging", dc.combineLabels); err != nil {
+		return fmt.Errorf(
+			"failed to update module labels when expanding the config: %w", err)
 	}
 
-	if err := dc.combineLabels(); err != nil {
-		log.Fatalf(
-			"failed to update module labels when expanding the config: %v", err)
+	if err := dc.tracePhase("scheduler linking", dc.applySchedulerLinks); err != nil {
+		return fmt.Errorf(
+			"failed to link scheduler modules when expanding the config: %w", err)
 	}
 
-	if err := dc.applyUseModules(); err != nil {
-		log.Fatalf(
-			"failed to apply \"use\" modules when expanding the config: %v", err)
+	if err := dc.tracePhase("use linking", dc.applyUseModules); err != nil {
+		return fmt.Errorf(
+			"failed to apply \"use\" modules when expanding the config: %w", err)
 	}
 
-	if err := dc.applyGlobalVariables(); err != nil {
-		log.Fatalf(
-			"failed to apply deployment variables in modules when expanding the config: %v",
+	if err := dc.tracePhase("global variable application", dc.applyGlobalVariables); err != nil {
+		return fmt.Errorf(
+			"failed to apply deployment variables in modules when expanding the config: %w",
 			err)
 	}
 
 	dc.Config.populateOutputs()
+	dc.Config.inheritOutputInfo()
+
+	// settings set by "use" can be transformed, so transforms are checked
+	// once the blueprint is expanded
+	return checkModuleTransforms(dc.Config)
 }
 
 func (dc *DeploymentConfig) addMetadataToModules() error {
@@ -83,7 +101,10 @@ func (dc *DeploymentConfig) addMetadataToModules() error {
 		if mod.RequiredApis != nil {
 			return nil
 		}
-		if dc.Config.Vars.Get("project_id").Type() != cty.String {
+		project := "$(vars.project_id)"
+		if g := dc.Config.ModuleGroupOrDie(mod.ID); g.ProjectID != "" {
+			project = g.ProjectID
+		} else if dc.Config.Vars.Get("project_id").Type() != cty.String {
 			return fmt.Errorf("global variable project_id must be defined")
 		}
 		requiredAPIs := mod.InfoOrDie().RequiredApis
@@ -91,7 +112,7 @@ func (dc *DeploymentConfig) addMetadataToModules() error {
 			requiredAPIs = []string{}
 		}
 		mod.RequiredApis = map[string][]string{
-			"$(vars.project_id)": requiredAPIs,
+			project: requiredAPIs,
 		}
 		return nil
 	})
@@ -100,32 +121,56 @@ func (dc *DeploymentConfig) addMetadataToModules() error {
 func (dc *DeploymentConfig) expandBackends() error {
 	// 1. DEFAULT: use TerraformBackend configuration (if supplied) in each
 	//    resource group
-	// 2. If top-level TerraformBackendDefaults is defined, insert that
+	// 2. If the group names a backend profile, insert the profile from
+	//    TerraformBackends into the group
+	// 3. If top-level TerraformBackendDefaults is defined, insert that
 	//    backend into resource groups which have no explicit
 	//    TerraformBackend
-	// 3. In all cases, add a prefix for GCS backends if one is not defined
+	// 4. In cases 2 and 3, add a prefix for GCS backends if one is not defined
+	// 5. Fill in the placeholders of templated prefixes
 	blueprint := &dc.Config
 	defaults := blueprint.TerraformBackendDefaults
-	if defaults.Type != "" {
-		for i := range blueprint.DeploymentGroups {
-			grp := &blueprint.DeploymentGroups[i]
-			be := &grp.TerraformBackend
-			if be.Type == "" {
-				be.Type = defaults.Type
-				be.Configuration = Dict{}
-				for k, v := range defaults.Configuration.Items() {
-					be.Configuration.Set(k, v)
-				}
-			}
-			if be.Type == "gcs" && !be.Configuration.Has("prefix") {
-				prefix := blueprint.BlueprintName
-				if deployment, err := blueprint.DeploymentName(); err == nil {
-					prefix += "/" + deployment
-				}
-				prefix += "/" + string(grp.Name)
-				be.Configuration.Set("prefix", cty.StringVal(prefix))
-			}
+	for i := range blueprint.DeploymentGroups {
+		grp := &blueprint.DeploymentGroups[i]
+		be := &grp.TerraformBackend
+		if err := blueprint.expandBackend(grp, defaults); err != nil {
+			return err
+		}
+		p := be.Configuration.Get("prefix")
+		if !be.Configuration.Has("prefix") || p.IsMarked() || !isNonEmptyString(p) || !isPrefixTemplate(p.AsString()) {
+			continue
 		}
+		prefix, err := blueprint.expandPrefixTemplate(p.AsString(), grp.Name)
+		if err != nil {
+			return err
+		}
+		be.Configuration.Set("prefix", cty.StringVal(prefix))
+	}
+	return nil
+}
+
+// expandBackend sets the backend of a group from its profile or the defaults
+func (bp *Blueprint) expandBackend(grp *DeploymentGroup, defaults TerraformBackend) error {
+	be := &grp.TerraformBackend
+	if grp.Backend != "" {
+		profile, ok := bp.TerraformBackends[grp.Backend]
+		if !ok {
+			return configErrorf("backendNotFound", ": group %s, backend %s", grp.Name, grp.Backend)
+		}
+		*be = profile.copy()
+		grp.Backend = ""
+	} else if defaults.Type == "" {
+		return nil
+	} else if be.Type == "" {
+		*be = defaults.copy()
+	}
+	if be.Type == "gcs" && !be.Configuration.Has("prefix") {
+		prefix := bp.BlueprintName
+		if deployment, err := bp.DeploymentName(); err == nil {
+			prefix += "/" + deployment
+		}
+		prefix += "/" + string(grp.Name)
+		be.Configuration.Set("prefix", cty.StringVal(prefix))
 	}
 	return nil
 }
@@ -143,14 +188,13 @@ func getModuleInputMap(inputs []modulereader.VarInfo) map[string]string {
 func (mod *Module) addListValue(settingName string, value cty.Value) error {
 	var cur []cty.Value
 	if !mod.Settings.Has(settingName) {
-		mod.createWrapSettingsWith()
-		mod.WrapSettingsWith[settingName] = []string{"flatten([", "])"}
+		mod.prependTransform(settingName, FlattenTransformer)
 		cur = []cty.Value{}
 	} else {
 		v := mod.Settings.Get(settingName)
 		ty := v.Type()
 		if !ty.IsTupleType() && !ty.IsSetType() && !ty.IsSetType() {
-			return fmt.Errorf("%s: module %s, setting %s", errorMessages["appendToNonList"], mod.ID, settingName)
+			return configErrorf("appendToNonList", ": module %s, setting %s", mod.ID, settingName)
 		}
 		cur = mod.Settings.Get(settingName).AsValueSlice()
 	}
@@ -159,11 +203,14 @@ func (mod *Module) addListValue(settingName string, value cty.Value) error {
 }
 
 // useModule matches input variables in a "using" module to output values
-// from a "used" module. It may be used iteratively to successively apply used
-// modules in order of precedence. New input variables are added to the using
-// module as Toolkit variable references (in same format as a blueprint). If
-// the input variable already has a setting, it is ignored, unless the value is
-// a list, in which case output values are appended and flattened using HCL.
+// from a "used" module under the mode, see MatchUse. It may be used
+// iteratively to successively apply used modules in order of precedence. New
+// input variables are added to the using module as Toolkit variable
+// references (in same format as a blueprint). If the input variable already
+// has a setting, it is ignored, unless the value is a list, in which case
+// output values are appended and flattened using HCL. It returns the matches,
+// telling why those that were not applied were skipped; mapped inputs are
+// applied by applyUseMap.
 //
 //	mod: "using" module as defined above
 //	useMod: "used" module as defined above
@@ -172,28 +219,29 @@ func (mod *Module) addListValue(settingName string, value cty.Value) error {
 func useModule(
 	mod *Module,
 	useMod Module,
+	mode UseMode,
 	settingsToIgnore []string,
-) error {
+) ([]UseMatch, error) {
 	modInputsMap := getModuleInputMap(mod.InfoOrDie().Inputs)
-	for _, useOutput := range useMod.InfoOrDie().Outputs {
-		settingName := useOutput.Name
+	matches := MatchUse(*mod, useMod, mode)
+	for i := range matches {
+		m := &matches[i]
+		settingName := m.Input
 
 		// explicitly ignore these settings (typically those in blueprint)
-		if slices.Contains(settingsToIgnore, settingName) {
-			continue
+		if m.Skipped == "" && slices.Contains(settingsToIgnore, settingName) {
+			m.Skipped = "the setting is set in the blueprint"
 		}
-
-		// Skip settings that do not have matching module inputs
-		inputType, ok := modInputsMap[settingName]
-		if !ok {
+		if m.Mapped || m.Skipped != "" {
 			continue
 		}
 
 		// skip settings that are not of list type, but already have a value
 		// these were probably added by a previous call to this function
 		alreadySet := mod.Settings.Has(settingName)
-		isList := strings.HasPrefix(inputType, "list")
+		isList := strings.HasPrefix(modInputsMap[settingName], "list")
 		if alreadySet && !isList {
+			m.Skipped = "the setting is already set from an earlier module in use"
 			continue
 		}
 
@@ -206,31 +254,51 @@ func useModule(
 			mod.Settings.Set(settingName, v)
 		} else {
 			if err := mod.addListValue(settingName, v); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
-	return nil
+	return matches, nil
 }
 
 // applyUseModules applies variables from modules listed in the "use" field
-// when/if applicable
+// when/if applicable, and records which inputs were set from which outputs
 func (dc *DeploymentConfig) applyUseModules() error {
+	dc.useReports = nil
 	return dc.Config.WalkModules(func(m *Module) error {
 		settingsInBlueprint := maps.Keys(m.Settings.Items())
+		if dc.Config.featureEnabled(strictUseFeature) {
+			if err := checkUseConflicts(dc.Config, *m, settingsInBlueprint); err != nil {
+				return err
+			}
+		}
 		for _, u := range m.Use {
 			used, err := dc.Config.Module(u)
 			if err != nil {
 				return err
 			}
-			if err := useModule(m, *used, settingsInBlueprint); err != nil {
+			// settings mapped from the outputs of the module take precedence
+			// over its outputs of the same name
+			mode := m.useMode(u)
+			matches, err := useModule(m, *used, mode, settingsInBlueprint)
+			if err != nil {
+				return err
+			}
+			if err := applyUseMap(dc.Config, m, *used, settingsInBlueprint); err != nil {
 				return err
 			}
+			dc.useReports = append(dc.useReports, UseReport{Module: m.ID, Used: u, Mode: mode, Matches: matches})
 		}
 		return nil
 	})
 }
 
+// UseReports tells, for every module in the use list of another, which
+// inputs of the other were set from which of its outputs by expansion
+func (dc DeploymentConfig) UseReports() []UseReport {
+	return dc.useReports
+}
+
 func moduleHasInput(m Module, n string) bool {
 	for _, input := range m.InfoOrDie().Inputs {
 		if input.Name == n {
@@ -275,11 +343,10 @@ func (dc *DeploymentConfig) combineLabels() error {
 }
 
 func combineModuleLabels(mod *Module, dc DeploymentConfig) error {
-	mod.createWrapSettingsWith()
 	labels := "labels"
 
-	// previously expanded blueprint, user written BPs do not use `WrapSettingsWith`
-	if _, ok := mod.WrapSettingsWith[labels]; ok {
+	// labels already merged, e.g. by a previous expansion of the blueprint
+	if slices.Contains(mod.Transforms[labels], MergeTransformer) {
 		return nil // Do nothing
 	}
 
@@ -294,8 +361,7 @@ func combineModuleLabels(mod *Module, dc DeploymentConfig) error {
 		v := mod.Settings.Get(labels)
 		ty := v.Type()
 		if !ty.IsObjectType() && !ty.IsMapType() {
-			return fmt.Errorf("%s, Module %s, labels type: %s",
-				errorMessages["settingsLabelType"], mod.ID, ty.FriendlyName())
+			return configErrorf("settingsLabelType", ", Module %s, labels type: %s", mod.ID, ty.FriendlyName())
 		}
 		if v.AsValueMap() != nil {
 			modLabels = v.AsValueMap()
@@ -306,10 +372,10 @@ func combineModuleLabels(mod *Module, dc DeploymentConfig) error {
 		modLabels[roleLabel] = cty.StringVal(getRole(mod.Source))
 	}
 
-	if mod.Kind == TerraformKind {
+	if mod.Kind.groupKind() == TerraformKind {
 		// Terraform module labels to be expressed as
 		// `merge(var.labels, { ghpc_role=..., **settings.labels })`
-		mod.WrapSettingsWith[labels] = []string{"merge(", ")"}
+		mod.prependTransform(labels, MergeTransformer)
 		ref := GlobalRef(labels).AsExpression()
 		args := []cty.Value{ref.AsValue(), cty.ObjectVal(modLabels)}
 		mod.Settings.Set(labels, cty.TupleVal(args))
@@ -343,6 +409,14 @@ func (bp Blueprint) applyGlobalVarsInModule(mod *Module) error {
 			continue
 		}
 
+		// The project of the group, if it overrides the deployment variable
+		if input.Name == "project_id" {
+			if g := bp.ModuleGroupOrDie(mod.ID); g.ProjectID != "" {
+				mod.Settings.Set(input.Name, cty.StringVal(g.ProjectID))
+				continue
+			}
+		}
+
 		// If it's not set, is there a global we can use?
 		if bp.Vars.Has(input.Name) {
 			ref := GlobalRef(input.Name)
@@ -353,8 +427,8 @@ func (bp Blueprint) applyGlobalVarsInModule(mod *Module) error {
 		if input.Required {
 			// It's not explicitly set, and not global is set
 			// Fail if no default has been set
-			return fmt.Errorf("%s: Module ID: %s Setting: %s",
-				errorMessages["missingSetting"], mod.ID, input.Name)
+			return &MissingVarError{Var: input.Name,
+				err: configErrorf("missingSetting", ": Module ID: %s Setting: %s", mod.ID, input.Name)}
 		}
 		// Default exists, the module will handle it
 	}
@@ -385,7 +459,7 @@ func validateModuleReference(bp Blueprint, from Module, toID ModuleID) error {
 	}
 
 	if to.Kind == PackerKind {
-		return fmt.Errorf("%s: %s", errorMessages["cannotUsePacker"], to.ID)
+		return configErrorf("cannotUsePacker", ": %s", to.ID)
 	}
 
 	fg := bp.ModuleGroupOrDie(from.ID)
@@ -393,7 +467,7 @@ func validateModuleReference(bp Blueprint, from Module, toID ModuleID) error {
 	fgi := slices.IndexFunc(bp.DeploymentGroups, func(g DeploymentGroup) bool { return g.Name == fg.Name })
 	tgi := slices.IndexFunc(bp.DeploymentGroups, func(g DeploymentGroup) bool { return g.Name == tg.Name })
 	if tgi > fgi {
-		return fmt.Errorf("%s: %s is in a later group", errorMessages["intergroupOrder"], to.ID)
+		return configErrorf("intergroupOrder", ": %s is in a later group", to.ID)
 	}
 	return nil
 }
@@ -406,7 +480,8 @@ func validateModuleSettingReference(bp Blueprint, mod Module, r Reference) error
 	// simplest case to evaluate is a deployment variable's existence
 	if r.GlobalVar {
 		if !bp.Vars.Has(r.Name) {
-			return fmt.Errorf("module %#v references unknown global variable %#v", mod.ID, r.Name)
+			return &MissingVarError{Var: r.Name,
+				err: fmt.Errorf("module %#v references unknown global variable %#v", mod.ID, r.Name)}
 		}
 		return nil
 	}
@@ -418,7 +493,7 @@ func validateModuleSettingReference(bp Blueprint, mod Module, r Reference) error
 	mi := tm.InfoOrDie()
 	found := slices.ContainsFunc(mi.Outputs, func(o modulereader.OutputInfo) bool { return o.Name == r.Name })
 	if !found {
-		return fmt.Errorf("%s: module %s did not have output %s", errorMessages["noOutput"], tm.ID, r.Name)
+		return configErrorf("noOutput", ": module %s did not have output %s", tm.ID, r.Name)
 	}
 	return nil
 }
@@ -450,8 +525,8 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 	zoneRef := GlobalRef("zone").AsExpression().AsValue()
 
 	defaults := []validatorConfig{
-		{Validator: testModuleNotUsedName.String()},
-		{Validator: testDeploymentVariableNotUsedName.String()}}
+		{Validator: testModuleNotUsedName.String(), reason: "always added"},
+		{Validator: testDeploymentVariableNotUsedName.String(), reason: "always added"}}
 
 	// always add the project ID validator before subsequent validators that can
 	// only succeed if credentials can access the project. If the project ID
@@ -460,13 +535,27 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 		defaults = append(defaults, validatorConfig{
 			Validator: testProjectExistsName.String(),
 			Inputs:    NewDict(map[string]cty.Value{"project_id": projectRef}),
+			reason:    "deployment variable project_id is set",
 		})
 	}
 
+	// groups that override the project are checked against their own project
+	groupProjects := []string{}
+	for _, g := range dc.Config.DeploymentGroups {
+		if g.ProjectID != "" && !slices.Contains(groupProjects, g.ProjectID) {
+			groupProjects = append(groupProjects, g.ProjectID)
+			defaults = append(defaults, validatorConfig{
+				Validator: testProjectExistsName.String(),
+				Inputs:    NewDict(map[string]cty.Value{"project_id": cty.StringVal(g.ProjectID)}),
+				reason:    fmt.Sprintf("group %s sets project_id", g.Name),
+			})
+		}
+	}
+
 	// it is safe to run this validator even if vars.project_id is undefined;
 	// it will likely fail but will do so helpfully to the user
 	defaults = append(defaults,
-		validatorConfig{Validator: "test_apis_enabled"})
+		validatorConfig{Validator: "test_apis_enabled", reason: "always added"})
 
 	if projectIDExists && regionExists {
 		defaults = append(defaults, validatorConfig{
@@ -474,8 +563,9 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 			Inputs: NewDict(map[string]cty.Value{
 				"project_id": projectRef,
 				"region":     regionRef,
-			},
-			)})
+			}),
+			reason: "deployment variables project_id and region are set",
+		})
 	}
 
 	if projectIDExists && zoneExists {
@@ -485,6 +575,7 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 				"project_id": projectRef,
 				"zone":       zoneRef,
 			}),
+			reason: "deployment variables project_id and zone are set",
 		})
 	}
 
@@ -496,6 +587,129 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 				"region":     regionRef,
 				"zone":       zoneRef,
 			}),
+			reason: "deployment variables project_id, region and zone are set",
+		})
+	}
+
+	if _, chosen := dc.defaultedVars["zone"]; projectIDExists && chosen {
+		defaults = append(defaults, validatorConfig{
+			Validator: testZoneAvailableName.String(),
+			Inputs: NewDict(map[string]cty.Value{
+				"project_id": projectRef,
+				"zone":       zoneRef,
+			}),
+			reason: "deployment variable zone was chosen by ghpc from region",
+		})
+	}
+
+	if dc.Config.enablesSlurmAccounting() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testSlurmAccountingName.String(),
+			reason:    fmt.Sprintf("a module sets or uses %s", slurmAccountingSetting),
+		})
+	}
+
+	if dc.Config.setsStartupScripts() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testStartupScriptsName.String(),
+			reason:    "a module sets startup-script runners or VM metadata",
+		})
+	}
+
+	if dc.Config.namesVMs() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testHostnamesName.String(),
+			reason:    "a module creates VMs named after the deployment or its settings",
+		})
+	}
+
+	if dc.Config.setsSSHKeys() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testOSLoginSSHKeysName.String(),
+			reason:    "a module sets instance-level SSH keys",
+		})
+	}
+
+	if dc.Config.usesSpotModules() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testSpotConfigurationName.String(),
+			reason:    "a module can create Spot or preemptible VMs",
+		})
+	}
+
+	if dc.Config.usesPlacementOrMTU() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testPlacementAndMTUName.String(),
+			reason:    "a module uses placement policies or creates a VPC network",
+		})
+	}
+	if dc.Config.usesSubnetCapacity() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testSubnetCapacityName.String(),
+			reason:    "a VPC network is created for VMs or Slurm partitions",
+		})
+	}
+
+	if dc.Config.installsOpsAgent() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testOpsAgentName.String(),
+			reason:    "a startup-script module installs the Ops Agent",
+		})
+	}
+
+	if dc.Config.usesContainerImages() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testContainerImagesName.String(),
+			reason:    "a module runs container images",
+		})
+	}
+
+	if dc.Config.setsDiskSizes() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testDiskSizesName.String(),
+			reason:    "a module sets the size or image of its boot disk, or its local SSDs",
+		})
+	}
+
+	if dc.Config.usesBatch() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testBatchPermissionsName.String(),
+			reason:    "a module submits Cloud Batch jobs",
+		})
+	}
+
+	if dc.Config.usesCMEK() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testCMEKKeysName.String(),
+			reason:    "a module encrypts its resources with a customer-managed key",
+		})
+	}
+
+	if dc.Config.attachesGPUs() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testGPUImagesName.String(),
+			reason:    "a module creates VMs with GPUs",
+		})
+	}
+
+	if dc.Config.createsExternalIPs() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testExternalIPsName.String(),
+			reason:    "a module creates VMs with external IPs",
+		})
+	}
+
+	if dc.Config.usesGPUNetworking() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testGPUNetworkingName.String(),
+			reason:    "a module creates A3 VMs that need a network interface per GPU network",
+		})
+	}
+
+	if dc.Config.setsModuleZones() {
+		defaults = append(defaults, validatorConfig{
+			Validator: testModuleZonesName.String(),
+			reason:    "a module sets a zone or project other than the deployment variables",
 		})
 	}
 
@@ -514,6 +728,48 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 	return nil
 }
 
+// enablesSlurmAccounting returns true if any module sets the Slurm accounting
+// database directly or uses a module that outputs it
+func (bp Blueprint) enablesSlurmAccounting() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		if m.Settings.Has(slurmAccountingSetting) {
+			found = true
+		}
+		for _, id := range m.Use {
+			used, err := bp.Module(id)
+			if err != nil {
+				continue
+			}
+			info, err := modulereader.GetModuleInfo(used.Source, used.Kind.String())
+			if err != nil {
+				continue
+			}
+			if _, ok := info.GetOutputsAsMap()[slurmAccountingSetting]; ok {
+				found = true
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// setsStartupScripts returns true if any module sets startup-script runners
+// or VM metadata directly
+func (bp Blueprint) setsStartupScripts() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		if m.Settings.Has(startupRunnersSetting) {
+			found = true
+		}
+		for _, s := range startupMetadataSettings {
+			found = found || m.Settings.Has(s)
+		}
+		return nil
+	})
+	return found
+}
+
 // FindAllIntergroupReferences finds all intergroup references within the group
 func (dg DeploymentGroup) FindAllIntergroupReferences(bp Blueprint) []Reference {
 	igcRefs := map[Reference]bool{}
@@ -574,6 +830,32 @@ func (bp *Blueprint) populateOutputs() {
 	})
 }
 
+// inheritOutputInfo completes the outputs listed by modules with what the
+// modules declare of them: outputs of sensitive module outputs are sensitive,
+// as Terraform requires, and outputs without a description take that of the
+// module output. Outputs that the module does not have are left to
+// validateOutputs.
+func (bp *Blueprint) inheritOutputInfo() {
+	bp.WalkModules(func(m *Module) error {
+		if len(m.Outputs) == 0 {
+			return nil
+		}
+		declared := m.InfoOrDie().GetOutputsAsMap()
+		for i := range m.Outputs {
+			o := &m.Outputs[i]
+			d, ok := declared[o.Name]
+			if !ok {
+				continue
+			}
+			o.Sensitive = o.Sensitive || d.Sensitive
+			if o.Description == "" {
+				o.Description = d.Description
+			}
+		}
+		return nil
+	})
+}
+
 // OutputNames returns the group-level output names constructed from module ID
 // and module-level output name; by construction, all elements are unique
 func (dg DeploymentGroup) OutputNames() []string {
@@ -624,3 +906,15 @@ func intersection(s1 []string, s2 []string) []string {
 	slices.Sort(is)
 	return is
 }
+
+// namesVMs returns true if any module creates VMs whose hostnames are derived
+// from the deployment name or module settings
+func (bp Blueprint) namesVMs() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, ok := hostnameRuleFor(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
@@ -15,17 +15,21 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"hpc-toolkit/pkg/modulereader"
+	"os"
+	"path/filepath"
 
 	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v3"
 )
 
 func (s *MySuite) TestExpand(c *C) {
 	dc := getDeploymentConfigForTest()
 	fmt.Println("TEST_DEBUG: If tests die without report, check TestExpand")
-	dc.expand()
+	c.Check(dc.expand(), IsNil)
 }
 
 func (s *MySuite) TestExpandBackends(c *C) {
@@ -59,6 +63,231 @@ func (s *MySuite) TestExpandBackends(c *C) {
 	c.Assert(gotPrefix, Equals, cty.StringVal(expPrefix))
 }
 
+func (s *MySuite) TestExpandPrefixTemplates(c *C) {
+	dc := getDeploymentConfigForTest()
+	deplName := dc.Config.Vars.Get("deployment_name").AsString()
+	defaults := TerraformBackend{Type: "gcs"}
+	defaults.Configuration.Set("bucket", cty.StringVal("state-bucket"))
+	defaults.Configuration.Set("prefix", cty.StringVal("clusters/{{deployment_name}}/{{ group }}"))
+	dc.Config.TerraformBackendDefaults = defaults
+	dc.Config.DeploymentGroups = append(dc.Config.DeploymentGroups, DeploymentGroup{Name: "group2"})
+
+	c.Assert(dc.expandBackends(), IsNil)
+	for _, g := range dc.Config.DeploymentGroups {
+		c.Check(g.TerraformBackend.Configuration.Get("prefix"), Equals,
+			cty.StringVal(fmt.Sprintf("clusters/%s/%s", deplName, g.Name)))
+	}
+	// the defaults keep the template
+	c.Check(dc.Config.TerraformBackendDefaults.Configuration.Get("prefix"), Equals,
+		cty.StringVal("clusters/{{deployment_name}}/{{ group }}"))
+	c.Check(checkStatePrefixes(dc.Config), IsNil)
+	c.Check(dc.Config.StatePrefixes(), DeepEquals, []StatePrefix{
+		{Group: dc.Config.DeploymentGroups[0].Name, Bucket: "state-bucket", Prefix: "clusters/" + deplName + "/" + string(dc.Config.DeploymentGroups[0].Name)},
+		{Group: "group2", Bucket: "state-bucket", Prefix: "clusters/" + deplName + "/group2"},
+	})
+
+	{ // prefixes without the group are shared by all groups
+		dc := getDeploymentConfigForTest()
+		defaults.Configuration.Set("prefix", cty.StringVal("{{blueprint_name}}/{{deployment_name}}"))
+		dc.Config.TerraformBackendDefaults = defaults
+		dc.Config.DeploymentGroups = append(dc.Config.DeploymentGroups, DeploymentGroup{Name: "group2"})
+		c.Assert(dc.expandBackends(), IsNil)
+		err := checkStatePrefixes(dc.Config)
+		c.Check(err, ErrorMatches, ".*groups .* and group2 both use gs://state-bucket/.*")
+		c.Check(CodeOf(err), Equals, ErrCodeSharedStatePrefix)
+	}
+
+	{ // unknown placeholders are rejected
+		dc := getDeploymentConfigForTest()
+		defaults.Configuration.Set("prefix", cty.StringVal("{{project}}/{{group}}"))
+		dc.Config.TerraformBackendDefaults = defaults
+		err := dc.expandBackends()
+		c.Check(err, ErrorMatches, ".*unknown placeholder \\{\\{project\\}\\}.*")
+		c.Check(CodeOf(err), Equals, ErrCodePrefixTemplate)
+	}
+}
+
+func (s *MySuite) TestExpandBackendProfiles(c *C) {
+	dc := getDeploymentConfigForTest()
+	deplName := dc.Config.Vars.Get("deployment_name").AsString()
+	scratch := TerraformBackend{Type: "gcs"}
+	scratch.Configuration.Set("bucket", cty.StringVal("scratch-bucket"))
+	dc.Config.TerraformBackends = map[string]TerraformBackend{
+		"scratch": scratch,
+		"local":   {Type: "local"},
+	}
+	dc.Config.DeploymentGroups[0].Backend = "scratch"
+	dc.Config.DeploymentGroups = append(dc.Config.DeploymentGroups,
+		DeploymentGroup{Name: "group2", Backend: "local"},
+		DeploymentGroup{Name: "group3"})
+
+	c.Assert(dc.expandBackends(), IsNil)
+	grp := dc.Config.DeploymentGroups[0]
+	c.Check(grp.Backend, Equals, "")
+	c.Check(grp.TerraformBackend.Type, Equals, "gcs")
+	c.Check(grp.TerraformBackend.Configuration.Get("bucket"), Equals, cty.StringVal("scratch-bucket"))
+	expPrefix := fmt.Sprintf("%s/%s/%s", dc.Config.BlueprintName, deplName, grp.Name)
+	c.Check(grp.TerraformBackend.Configuration.Get("prefix"), Equals, cty.StringVal(expPrefix))
+	// the profile itself is not modified
+	profile := dc.Config.TerraformBackends["scratch"]
+	c.Check(profile.Configuration.Has("prefix"), Equals, false)
+
+	c.Check(dc.Config.DeploymentGroups[1].TerraformBackend.Type, Equals, "local")
+	c.Check(dc.Config.DeploymentGroups[2].TerraformBackend.Type, Equals, "")
+
+	// FAIL. Unknown profile
+	dc.Config.DeploymentGroups[2].Backend = "prod"
+	c.Check(dc.expandBackends(), ErrorMatches, ".*group3, backend prod")
+}
+
+func (s *MySuite) TestSourceIs(c *C) {
+	c.Check(sourceIs("community/modules/scheduler/pbspro-server", "scheduler/pbspro-server"), Equals, true)
+	c.Check(sourceIs("./community/modules/scheduler/pbspro-server/", "scheduler/pbspro-server"), Equals, true)
+	c.Check(sourceIs("github.com/org/repo//community/modules/scheduler/pbspro-server?ref=v1.0.0", "scheduler/pbspro-server"), Equals, true)
+	c.Check(sourceIs("community/modules/scheduler/pbspro-server-fork", "scheduler/pbspro-server"), Equals, false)
+	c.Check(sourceIs("community/modules/scheduler/my-pbspro-server", "scheduler/pbspro-server"), Equals, false)
+}
+
+func (s *MySuite) TestApplySchedulerLinks(c *C) {
+	server := Module{ID: "server", Kind: TerraformKind, Source: "community/modules/scheduler/pbspro-server"}
+	client := Module{ID: "client", Kind: TerraformKind, Source: "community/modules/scheduler/pbspro-client"}
+	explicit := Module{
+		ID:       "explicit",
+		Kind:     TerraformKind,
+		Source:   "community/modules/compute/pbspro-execution",
+		Settings: NewDict(map[string]cty.Value{"pbs_server": cty.StringVal("10.0.0.2")}),
+	}
+	configure := Module{ID: "configure", Kind: TerraformKind, Source: "community/modules/scheduler/htcondor-configure"}
+	execute := Module{ID: "execute", Kind: TerraformKind, Source: "community/modules/compute/htcondor-execute-point"}
+
+	setTestModuleInfo(server, modulereader.ModuleInfo{
+		Outputs: []modulereader.OutputInfo{{Name: "pbs_server"}}})
+	pbsInputs := modulereader.ModuleInfo{
+		Inputs: []modulereader.VarInfo{{Name: "pbs_server", Type: "string"}}}
+	setTestModuleInfo(client, pbsInputs)
+	setTestModuleInfo(explicit, pbsInputs)
+	setTestModuleInfo(configure, modulereader.ModuleInfo{})
+	setTestModuleInfo(execute, modulereader.ModuleInfo{})
+
+	dc := DeploymentConfig{Config: Blueprint{
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{server, client, explicit, configure, execute}},
+		},
+	}}
+	c.Assert(dc.applySchedulerLinks(), IsNil)
+
+	got, _ := dc.Config.Module("client")
+	c.Check(got.Use, DeepEquals, []ModuleID{"server"})
+	// explicit settings are not overridden by use
+	got, _ = dc.Config.Module("explicit")
+	c.Check(got.Use, HasLen, 0)
+	got, _ = dc.Config.Module("execute")
+	c.Check(got.Settings.Has("service_account"), Equals, true)
+
+	// links are idempotent
+	c.Assert(dc.applySchedulerLinks(), IsNil)
+	got, _ = dc.Config.Module("client")
+	c.Check(got.Use, DeepEquals, []ModuleID{"server"})
+
+	// ambiguous producers are not linked
+	server2 := server
+	server2.ID = "server2"
+	client2 := client
+	client2.ID = "client2"
+	dc = DeploymentConfig{Config: Blueprint{
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{server, server2, client2}},
+		},
+	}}
+	c.Assert(dc.applySchedulerLinks(), IsNil)
+	got, _ = dc.Config.Module("client2")
+	c.Check(got.Use, HasLen, 0)
+}
+
+func (s *MySuite) TestExpandHTCondorBlueprint(c *C) {
+	// the modules of the repository are read, from the root of the repository
+	bp := `
+blueprint_name: htcondor
+vars:
+  project_id: test-project
+  deployment_name: htcondor
+  region: us-central1
+  zone: us-central1-c
+deployment_groups:
+- group: htcondor
+  modules:
+  - id: network1
+    source: ../../modules/network/vpc
+  - id: htcondor_install
+    source: ../../community/modules/scripts/htcondor-install
+  - id: htcondor_configure
+    source: ../../community/modules/scheduler/htcondor-configure
+    use: [network1]
+  - id: startup_cm
+    source: ../../modules/scripts/startup-script
+    settings:
+      runners:
+      - $(htcondor_install.install_htcondor_runner)
+      - $(htcondor_configure.central_manager_runner)
+  - id: htcondor_cm
+    source: ../../modules/compute/vm-instance
+    use: [network1, startup_cm]
+  - id: startup_ep
+    source: ../../modules/scripts/startup-script
+    settings:
+      runners:
+      - $(htcondor_install.install_htcondor_runner)
+      - $(htcondor_configure.execute_point_runner)
+  - id: htcondor_ep
+    source: ../../community/modules/compute/htcondor-execute-point
+    use: [network1, startup_ep]
+  - id: startup_ap
+    source: ../../modules/scripts/startup-script
+    settings:
+      runners:
+      - $(htcondor_install.install_htcondor_runner)
+      - $(htcondor_configure.access_point_runner)
+      - $(htcondor_ep.configure_autoscaler_runner)
+  - id: htcondor_access
+    source: ../../modules/compute/vm-instance
+    use: [network1, startup_ap]
+  - id: other_vm
+    source: ../../modules/compute/vm-instance
+    use: [network1]
+`
+	dc, err := NewDeploymentConfigFromData("test", []byte(bp), "")
+	c.Assert(err, IsNil)
+	dc.Config.ValidationLevel = ValidationIgnore
+	c.Assert(dc.ExpandConfig(context.Background()), IsNil)
+
+	serviceAccount := func(id ModuleID) cty.Value {
+		m, err := dc.Config.Module(id)
+		c.Assert(err, IsNil)
+		if !m.Settings.Has("service_account") {
+			return cty.NilVal
+		}
+		return m.Settings.Get("service_account").GetAttr("email")
+	}
+	c.Check(serviceAccount("htcondor_ep"), DeepEquals,
+		ModuleRef("htcondor_configure", "execute_point_service_account").AsExpression().AsValue())
+	c.Check(serviceAccount("htcondor_cm"), DeepEquals,
+		ModuleRef("htcondor_configure", "central_manager_service_account").AsExpression().AsValue())
+	c.Check(serviceAccount("htcondor_access"), DeepEquals,
+		ModuleRef("htcondor_configure", "access_point_service_account").AsExpression().AsValue())
+	c.Check(serviceAccount("other_vm"), Equals, cty.NilVal)
+
+	cm, err := dc.Config.Module("htcondor_cm")
+	c.Assert(err, IsNil)
+	nic := cm.Settings.Get("network_interfaces").Index(cty.NumberIntVal(0))
+	c.Check(nic.GetAttr("network_ip"), DeepEquals,
+		ModuleRef("htcondor_configure", "central_manager_internal_ip").AsExpression().AsValue())
+	c.Check(nic.GetAttr("subnetwork"), DeepEquals,
+		ModuleRef("network1", "subnetwork_self_link").AsExpression().AsValue())
+	other, err := dc.Config.Module("other_vm")
+	c.Assert(err, IsNil)
+	c.Check(other.Settings.Has("network_interfaces"), Equals, false)
+}
+
 func (s *MySuite) TestAddListValue(c *C) {
 	mod := Module{ID: "TestModule"}
 
@@ -96,7 +325,7 @@ func (s *MySuite) TestUseModule(c *C) {
 		setTestModuleInfo(mod, modulereader.ModuleInfo{})
 		setTestModuleInfo(usedMod, modulereader.ModuleInfo{})
 
-		err := useModule(&mod, usedMod, []string{})
+		_, err := useModule(&mod, usedMod, UseByName, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings, DeepEquals, Dict{})
 	}
@@ -108,7 +337,7 @@ func (s *MySuite) TestUseModule(c *C) {
 		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
-		err := useModule(&mod, usedMod, []string{})
+		_, err := useModule(&mod, usedMod, UseByName, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings, DeepEquals, Dict{})
 	}
@@ -122,7 +351,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{})
+		_, err := useModule(&mod, usedMod, UseByName, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": ref.Mark(useMark),
@@ -139,7 +368,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{"val1"})
+		_, err := useModule(&mod, usedMod, UseByName, []string{"val1"})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{"val1": ref})
 	}
@@ -155,7 +384,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{})
+		_, err := useModule(&mod, usedMod, UseByName, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{"val1": ref.Mark(useMark)})
 	}
@@ -168,7 +397,7 @@ func (s *MySuite) TestUseModule(c *C) {
 		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
-		err := useModule(&mod, usedMod, []string{})
+		_, err := useModule(&mod, usedMod, UseByName, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": cty.TupleVal([]cty.Value{
@@ -187,7 +416,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{})
+		_, err := useModule(&mod, usedMod, UseByName, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": cty.TupleVal([]cty.Value{
@@ -207,13 +436,75 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{"val1"})
+		_, err := useModule(&mod, usedMod, UseByName, []string{"val1"})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": cty.TupleVal([]cty.Value{ref})})
 	}
 }
 
+func (s *MySuite) TestApplyUseMap(c *C) {
+	var using Module
+	c.Assert(yaml.Unmarshal([]byte(`
+id: compute
+source: path/compute
+use:
+- network
+- module: storage
+  map:
+    subnetwork_self_link: $(element(storage.subnetwork_self_links, 0))
+    zones: ((slice(module.storage.zones, 0, 1)))
+`), &using), IsNil)
+	c.Check(using.Use, DeepEquals, []ModuleID{"network", "storage"})
+
+	network := Module{ID: "network", Source: "path/network"}
+	storage := Module{ID: "storage", Source: "path/storage"}
+	setTestModuleInfo(network, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+		{Name: "subnetwork_self_link"}}})
+	setTestModuleInfo(storage, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+		{Name: "subnetwork_self_links"}, {Name: "zones"}}})
+	setTestModuleInfo(using, modulereader.ModuleInfo{Inputs: []modulereader.VarInfo{
+		{Name: "subnetwork_self_link", Type: "string"},
+		{Name: "zones", Type: "list(string)"}}})
+
+	dc := DeploymentConfig{Config: Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "zero", Modules: []Module{network, storage, using}}}}}
+	c.Assert(dc.applyUseModules(), IsNil)
+
+	// the map takes precedence over the output of network of the same name
+	mark := ProductOfModuleUse{"storage"}
+	m := dc.Config.DeploymentGroups[0].Modules[2]
+	c.Check(m.Settings.Items(), DeepEquals, map[string]cty.Value{
+		"subnetwork_self_link": MustParseExpression(
+			"element(module.storage.subnetwork_self_links, 0)").AsValue().Mark(mark),
+		"zones": cty.TupleVal([]cty.Value{
+			MustParseExpression("slice(module.storage.zones, 0, 1)").AsValue().Mark(mark)}),
+	})
+	c.Check(m.listUnusedModules(), DeepEquals, []ModuleID{"network"})
+
+	{ // mapped expressions must use the used module
+		var bad Module
+		c.Assert(yaml.Unmarshal([]byte(`
+id: compute
+use:
+- module: storage
+  map:
+    subnetwork_self_link: $(network.subnetwork_self_link)
+`), &bad), IsNil)
+		bad.Source = using.Source
+		dc := DeploymentConfig{Config: Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "zero", Modules: []Module{network, storage, bad}}}}}
+		c.Check(dc.applyUseModules(), ErrorMatches, ".*uses no output of storage.*")
+	}
+
+	{ // modules are still decoded strictly
+		var bad Module
+		c.Check(yaml.Unmarshal([]byte("id: compute\nsorce: path\n"), &bad), ErrorMatches, ".*field sorce not found.*")
+		c.Check(yaml.Unmarshal([]byte("id: compute\nuse:\n- modul: storage\n"), &bad), ErrorMatches, ".*field modul not found.*")
+		c.Check(yaml.Unmarshal([]byte("id: compute\nuse:\n- module: storage\n  map: {a: b}\n"), &bad), ErrorMatches, ".*must map settings to expressions.*")
+	}
+}
+
 func (s *MySuite) TestApplyUseModules(c *C) {
 
 	{ // Simple Case
@@ -273,6 +564,124 @@ func (s *MySuite) TestApplyUseModules(c *C) {
 	}
 }
 
+func (s *MySuite) TestStrictUse(c *C) {
+	dc := getDeploymentConfigForTest()
+	g := &dc.Config.DeploymentGroups[0]
+	using := Module{ID: "compute", Source: "path/compute", Use: []ModuleID{"net1", "net2"}}
+	net1 := Module{ID: "net1", Source: "path/net1"}
+	net2 := Module{ID: "net2", Source: "path/net2"}
+	g.Modules = append(g.Modules, using, net1, net2)
+	setTestModuleInfo(using, modulereader.ModuleInfo{Inputs: []modulereader.VarInfo{
+		{Name: "network_self_link", Type: "string"},
+		{Name: "network_storage", Type: "list(any)"},
+	}})
+	for _, m := range []Module{net1, net2} {
+		setTestModuleInfo(m, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+			{Name: "network_self_link"}, {Name: "network_storage"}}})
+	}
+
+	// the first module in use provides the setting
+	c.Assert(dc.applyUseModules(), IsNil)
+	compute, err := dc.Config.Module("compute")
+	c.Assert(err, IsNil)
+	got := compute.Settings.Get("network_self_link")
+	c.Check(got.RawEquals(ModuleRef("net1", "network_self_link").AsExpression().AsValue().
+		Mark(ProductOfModuleUse{"net1"})), Equals, true)
+
+	dc = getDeploymentConfigForTest()
+	dc.Config.DeploymentGroups[0].Modules = append(dc.Config.DeploymentGroups[0].Modules, using, net1, net2)
+	dc.Config.ExperimentalFeatures = []string{strictUseFeature}
+	c.Check(dc.applyUseModules(), ErrorMatches,
+		"modules net1 and net2 in use of module compute both provide setting network_self_link; .*")
+
+	// settings of the blueprint are not ambiguous
+	compute, err = dc.Config.Module("compute")
+	c.Assert(err, IsNil)
+	compute.Settings.Set("network_self_link", cty.StringVal("default"))
+	c.Check(dc.applyUseModules(), IsNil)
+}
+
+func (s *MySuite) TestUseModes(c *C) {
+	var using Module
+	c.Assert(yaml.Unmarshal([]byte(`
+id: compute
+source: path/compute-modes
+use:
+- module: network
+  match: name_and_type
+- module: storage
+  match: map
+  map:
+    zones: $(storage.zones)
+`), &using), IsNil)
+	c.Check(using.Use, DeepEquals, []ModuleID{"network", "storage"})
+	c.Check(using.useMode("network"), Equals, UseByNameAndType)
+	c.Check(using.useMode("storage"), Equals, UseByMap)
+
+	network := Module{ID: "network", Source: "path/network-modes"}
+	storage := Module{ID: "storage", Source: "path/storage-modes"}
+	setTestModuleInfo(network, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+		{Name: "network_self_link", Type: "string"},
+		{Name: "subnetwork_self_link"},
+		{Name: "zones", Type: "string"}}})
+	setTestModuleInfo(storage, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+		{Name: "network_self_link"}, {Name: "zones"}}})
+	setTestModuleInfo(using, modulereader.ModuleInfo{Inputs: []modulereader.VarInfo{
+		{Name: "network_self_link", Type: "string"},
+		{Name: "subnetwork_self_link", Type: "string"},
+		{Name: "zones", Type: "list(string)"}}})
+
+	dc := DeploymentConfig{Config: Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "zero", Modules: []Module{network, storage, using}}}}}
+	c.Assert(dc.applyUseModules(), IsNil)
+
+	m := dc.Config.DeploymentGroups[0].Modules[2]
+	c.Check(m.Settings.Items(), DeepEquals, map[string]cty.Value{
+		"network_self_link": ModuleRef("network", "network_self_link").AsExpression().AsValue().
+			Mark(ProductOfModuleUse{"network"}),
+		"zones": cty.TupleVal([]cty.Value{
+			MustParseExpression("module.storage.zones").AsValue().Mark(ProductOfModuleUse{"storage"})}),
+	})
+	c.Check(dc.UseReports(), DeepEquals, []UseReport{
+		{Module: "compute", Used: "network", Mode: UseByNameAndType, Matches: []UseMatch{
+			{Input: "network_self_link", Output: "network_self_link"},
+			{Input: "subnetwork_self_link", Output: "subnetwork_self_link", Skipped: "the type of the output is not declared"},
+			{Input: "zones", Output: "zones", Skipped: "the output is of type string, the input of type list(string)"},
+		}},
+		{Module: "compute", Used: "storage", Mode: UseByMap, Matches: []UseMatch{
+			{Input: "network_self_link", Output: "network_self_link", Skipped: "outputs are not matched by name, only mapped"},
+			{Input: "zones", Output: "module.storage.zones", Mapped: true},
+		}},
+	})
+
+	{ // the matches do not depend on the settings of the module
+		c.Check(MatchUse(using, network, UseByName), DeepEquals, []UseMatch{
+			{Input: "network_self_link", Output: "network_self_link"},
+			{Input: "subnetwork_self_link", Output: "subnetwork_self_link"},
+			{Input: "zones", Output: "zones"},
+		})
+	}
+
+	{ // modes must be known
+		var bad Module
+		c.Check(yaml.Unmarshal([]byte("id: compute\nuse:\n- module: storage\n  match: type\n"), &bad),
+			ErrorMatches, ".*match of a used module must be one of name, name_and_type or map.*")
+	}
+}
+
+func (s *MySuite) TestCheckExperimentalFeatures(c *C) {
+	bp := Blueprint{}
+	c.Check(checkExperimentalFeatures(bp), IsNil)
+	c.Check(bp.featureEnabled(strictUseFeature), Equals, false)
+
+	bp.ExperimentalFeatures = []string{strictUseFeature}
+	c.Check(checkExperimentalFeatures(bp), IsNil)
+	c.Check(bp.featureEnabled(strictUseFeature), Equals, true)
+
+	bp.ExperimentalFeatures = []string{"warp_drive"}
+	c.Check(checkExperimentalFeatures(bp), ErrorMatches, `unknown experimental feature "warp_drive", must be one of: strict_use`)
+}
+
 func (s *MySuite) TestCombineLabels(c *C) {
 	infoWithLabels := modulereader.ModuleInfo{Inputs: []modulereader.VarInfo{{Name: "labels"}}}
 
@@ -330,7 +739,7 @@ func (s *MySuite) TestCombineLabels(c *C) {
 	lime := dc.Config.DeploymentGroups[0]
 	// Labels are set and override role
 	coral = lime.Modules[0]
-	c.Check(coral.WrapSettingsWith["labels"], DeepEquals, []string{"merge(", ")"})
+	c.Check(coral.Transforms["labels"], DeepEquals, []SettingTransformer{MergeTransformer})
 	c.Check(coral.Settings.Get("labels"), DeepEquals, cty.TupleVal([]cty.Value{
 		labelsRef,
 		cty.ObjectVal(map[string]cty.Value{
@@ -340,7 +749,7 @@ func (s *MySuite) TestCombineLabels(c *C) {
 	}))
 	// Labels are not set, infer role from module.source
 	khaki = lime.Modules[1]
-	c.Check(khaki.WrapSettingsWith["labels"], DeepEquals, []string{"merge(", ")"})
+	c.Check(khaki.Transforms["labels"], DeepEquals, []SettingTransformer{MergeTransformer})
 	c.Check(khaki.Settings.Get("labels"), DeepEquals, cty.TupleVal([]cty.Value{
 		labelsRef,
 		cty.ObjectVal(map[string]cty.Value{
@@ -348,13 +757,13 @@ func (s *MySuite) TestCombineLabels(c *C) {
 	}))
 	// No labels input
 	silver = lime.Modules[2]
-	c.Check(silver.WrapSettingsWith["labels"], IsNil)
+	c.Check(silver.Transforms["labels"], IsNil)
 	c.Check(silver.Settings.Get("labels"), DeepEquals, cty.NilVal)
 
 	// Packer, include global include explicitly
 	// Keep overridden ghpc_deployment=navy
 	orange = dc.Config.DeploymentGroups[1].Modules[0]
-	c.Check(orange.WrapSettingsWith["labels"], IsNil)
+	c.Check(orange.Transforms["labels"], IsNil)
 	c.Check(orange.Settings.Get("labels"), DeepEquals, cty.ObjectVal(map[string]cty.Value{
 		"ghpc_blueprint":  cty.StringVal("simple"),
 		"ghpc_deployment": cty.StringVal("navy"),
@@ -407,6 +816,18 @@ func (s *MySuite) TestApplyGlobalVariables(c *C) {
 	})
 	err = dc.applyGlobalVariables()
 	c.Assert(err, IsNil)
+
+	// Test project_id input, group overrides the project
+	setTestModuleInfo(*mod, modulereader.ModuleInfo{
+		Inputs: []modulereader.VarInfo{{
+			Name:     "project_id",
+			Type:     "string",
+			Required: true,
+		}},
+	})
+	dc.Config.DeploymentGroups[0].ProjectID = "service-project"
+	c.Check(dc.applyGlobalVariables(), IsNil)
+	c.Check(mod.Settings.Get("project_id"), DeepEquals, cty.StringVal("service-project"))
 }
 
 func (s *MySuite) TestIsSimpleVariable(c *C) {
@@ -498,6 +919,88 @@ func (s *MySuite) TestValidateModuleReference(c *C) {
 
 }
 
+func (s *MySuite) TestResolveImageReferences(c *C) {
+	imageRef := ModuleRef("image", "image").AsExpression().AsValue()
+	pkr := Module{ID: "image", Kind: PackerKind}
+	pkr.Settings.Set("image_family", cty.StringVal("hpc"))
+	vm := Module{ID: "vm", Kind: TerraformKind}
+	vm.Settings.Set("instance_image", imageRef)
+
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "build", Kind: PackerKind, Modules: []Module{pkr}},
+		{Name: "primary", Kind: TerraformKind, Modules: []Module{vm}},
+	}}
+	c.Assert(bp.resolveImageReferences(), IsNil)
+	got := bp.DeploymentGroups[1].Modules[0].Settings.Get("instance_image")
+	c.Check(got, DeepEquals, cty.ObjectVal(map[string]cty.Value{
+		"family":  cty.StringVal("hpc"),
+		"project": GlobalRef("project_id").AsExpression().AsValue(),
+	}))
+
+	{ // the image is built in a later group
+		vm := Module{ID: "vm", Kind: TerraformKind}
+		vm.Settings.Set("instance_image", imageRef)
+		bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Kind: TerraformKind, Modules: []Module{vm}},
+			{Name: "build", Kind: PackerKind, Modules: []Module{pkr}},
+		}}
+		c.Check(bp.resolveImageReferences(), ErrorMatches, fmt.Sprintf("%s: .*", errorMessages["intergroupOrder"]))
+	}
+
+	{ // the family must be a valid image family name
+		pkr := Module{ID: "image", Kind: PackerKind}
+		pkr.Settings.Set("image_family", cty.StringVal("HPC_image"))
+		vm := Module{ID: "vm", Kind: TerraformKind}
+		vm.Settings.Set("instance_image", imageRef)
+		bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "build", Kind: PackerKind, Modules: []Module{pkr}},
+			{Name: "primary", Kind: TerraformKind, Modules: []Module{vm}},
+		}}
+		c.Check(bp.resolveImageReferences(), ErrorMatches, `module image builds images of family cty.StringVal\("HPC_image"\), which is not a valid image family name.*`)
+	}
+}
+
+func (s *MySuite) TestBuiltImageFamily(c *C) {
+	dir := c.MkDir()
+	vars := `variable "deployment_name" {
+  type = string
+}
+
+variable "image_family" {
+  type    = string
+  default = null
+}
+`
+	c.Assert(os.WriteFile(filepath.Join(dir, "variables.pkr.hcl"), []byte(vars), 0644), IsNil)
+	pkr := Module{ID: "image", Kind: PackerKind, Source: dir}
+	bp := Blueprint{
+		Vars:             NewDict(map[string]cty.Value{"deployment_name": cty.StringVal("golden")}),
+		DeploymentGroups: []DeploymentGroup{{Name: "build", Kind: PackerKind, Modules: []Module{pkr}}},
+	}
+
+	// the family defaults to the deployment name
+	got, err := bp.BuiltImageFamily(pkr)
+	c.Assert(err, IsNil)
+	c.Check(got, Equals, "golden")
+
+	pkr.Settings.Set("deployment_name", cty.StringVal("silver"))
+	got, err = bp.BuiltImageFamily(pkr)
+	c.Assert(err, IsNil)
+	c.Check(got, Equals, "silver")
+
+	pkr.Settings.Set("image_family", cty.StringVal("hpc"))
+	got, err = bp.BuiltImageFamily(pkr)
+	c.Assert(err, IsNil)
+	c.Check(got, Equals, "hpc")
+
+	{ // templates without an image_family variable
+		dir := c.MkDir()
+		c.Assert(os.WriteFile(filepath.Join(dir, "variables.pkr.hcl"), []byte("variable \"zone\" {}\n"), 0644), IsNil)
+		_, err := bp.BuiltImageFamily(Module{ID: "other", Kind: PackerKind, Source: dir})
+		c.Check(err, ErrorMatches, "the family of the images built by module other is unknown.*")
+	}
+}
+
 func (s *MySuite) TestIntersection(c *C) {
 	is := intersection([]string{"A", "B", "C"}, []string{"A", "B", "C"})
 	c.Assert(is, DeepEquals, []string{"A", "B", "C"})
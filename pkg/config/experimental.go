@@ -0,0 +1,62 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// experimentalFeature gates an expansion behavior that is not enabled by
+// default; blueprints opt in by listing its name in experimental_features.
+// Features are removed from the registry once their behavior becomes the
+// default, or is abandoned.
+type experimentalFeature struct {
+	name        string
+	description string
+}
+
+const strictUseFeature = "strict_use"
+
+var experimentalFeatures = []experimentalFeature{
+	{
+		name: strictUseFeature,
+		description: "modules in use may not provide the same non-list setting of a module, " +
+			"which otherwise receives the output of the first of them",
+	},
+}
+
+// checkExperimentalFeatures errors if the blueprint enables features that are
+// not in the registry
+func checkExperimentalFeatures(bp Blueprint) error {
+	names := []string{}
+	for _, f := range experimentalFeatures {
+		names = append(names, f.name)
+	}
+	for _, n := range bp.ExperimentalFeatures {
+		if !slices.Contains(names, n) {
+			return fmt.Errorf("unknown experimental feature %q, must be one of: %s", n, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// featureEnabled returns true if the blueprint opts in to the experimental
+// feature
+func (bp Blueprint) featureEnabled(name string) bool {
+	return slices.Contains(bp.ExperimentalFeatures, name)
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// ValidatorExplanation tells why a validator runs, the values its inputs
+// resolve to and the Google Cloud API calls it makes with them
+type ValidatorExplanation struct {
+	Validator string
+	// Reason is empty for validators configured in the blueprint
+	Reason   string
+	Skipped  bool
+	Inputs   []ExplainedInput
+	APICalls []string
+}
+
+// ExplainedInput is an input of a validator; Expression is the Terraform
+// expression of the input, empty for inputs that are not expressions
+type ExplainedInput struct {
+	Name       string
+	Expression string
+	Value      string
+}
+
+// ExplainValidators explains the validators of an expanded blueprint without
+// running them
+func (dc DeploymentConfig) ExplainValidators() []ValidatorExplanation {
+	res := []ValidatorExplanation{}
+	for _, v := range dc.Config.Validators {
+		e := ValidatorExplanation{Validator: v.Validator, Reason: v.reason, Skipped: v.Skip}
+		resolved := map[string]string{}
+		names := maps.Keys(v.Inputs.Items())
+		slices.Sort(names)
+		for _, name := range names {
+			in := ExplainedInput{Name: name}
+			val := v.Inputs.Get(name)
+			if expr, ok := IsExpressionValue(val); ok {
+				in.Expression = string(hclwrite.Format(expr.Tokenize().Bytes()))
+			}
+			if r, ok := evalIfKnown(val, dc.Config); !ok {
+				in.Value = "(could not be resolved)"
+			} else if r.Type() == cty.String && !r.IsNull() {
+				in.Value = fmt.Sprintf("%q", r.AsString())
+				resolved[name] = r.AsString()
+			} else {
+				in.Value = fmt.Sprintf("(not a string: %s)", r.Type().FriendlyName())
+			}
+			e.Inputs = append(e.Inputs, in)
+		}
+		e.APICalls = dc.explainAPICalls(v, resolved)
+		res = append(res, e)
+	}
+	return res
+}
+
+// explainAPICalls describes the API calls of a validator given its resolved
+// inputs; validators that only inspect the blueprint make none
+func (dc DeploymentConfig) explainAPICalls(v validatorConfig, inputs map[string]string) []string {
+	in := func(name string) string {
+		if s, ok := inputs[name]; ok {
+			return s
+		}
+		return "<unresolved " + name + ">"
+	}
+	switch v.Validator {
+	case testProjectExistsName.String():
+		return []string{fmt.Sprintf("compute.projects.get project=%s", in("project_id"))}
+	case testCredentialsName.String():
+		calls := []string{"an access token request for the credentials that Terraform uses, impersonating impersonate_service_account if set"}
+		if v.Inputs.Has("project_id") {
+			calls = append(calls, fmt.Sprintf("cloudresourcemanager.projects.get project=%s", in("project_id")))
+		}
+		return calls
+	case testRegionExistsName.String(), testZoneExistsName.String(), testZoneInRegionName.String(), testZoneAvailableName.String():
+		// zones and regions are listed once for all validators of a project
+		return []string{
+			fmt.Sprintf("compute.zones.list and compute.regions.list project=%s, shared by the zone and region validators of the project", in("project_id")),
+		}
+	case testApisEnabledName.String():
+		apis, err := dc.requiredApisByProject(v)
+		if err != nil {
+			return []string{fmt.Sprintf("serviceusage.services.batchGet, for projects that could not be resolved: %v", err)}
+		}
+		projects := maps.Keys(apis)
+		slices.Sort(projects)
+		calls := []string{}
+		for _, p := range projects {
+			if len(apis[p]) > 0 {
+				calls = append(calls, fmt.Sprintf("serviceusage.services.batchGet project=%s services=%s", p, strings.Join(apis[p], ",")))
+			}
+		}
+		return calls
+	case testStartupScriptsName.String():
+		return []string{"storage.objects.get for each runner whose source is in Cloud Storage"}
+	case testOSLoginSSHKeysName.String():
+		return []string{
+			"compute.projects.get for the project of each module that sets SSH keys",
+			"cloudresourcemanager.projects.getEffectiveOrgPolicy for the same projects",
+		}
+	case testOpsAgentName.String():
+		return []string{"serviceusage.services.batchGet for the logging and monitoring APIs in the project of each module that installs the Ops Agent"}
+	case testContainerImagesName.String():
+		return []string{
+			"artifactregistry.tags.get or artifactregistry.versions.get for each Artifact Registry image",
+			"cloudresourcemanager.projects.get for the project of each module whose node pools use the Compute Engine default service account",
+			"artifactregistry.repositories.getIamPolicy and cloudresourcemanager.projects.getIamPolicy for the repository and project of each image",
+		}
+	case testComputeQuotasName.String():
+		return []string{
+			"compute.machineTypes.get for the machine type and zone of each module that creates VMs",
+			"compute.regions.get for the region of each such module",
+		}
+	case testDiskSizesName.String():
+		return []string{
+			"compute.images.get or compute.images.getFromFamily for the boot image of each module",
+		}
+	case testBatchPermissionsName.String():
+		return []string{
+			"serviceusage.services.batchGet for the Batch API in the project of each module that submits Batch jobs",
+			"cloudresourcemanager.projects.get and cloudresourcemanager.projects.getIamPolicy for the same projects",
+		}
+	case testCMEKKeysName.String():
+		return []string{
+			"cloudkms.cryptoKeys.get for the key of each module that sets a customer-managed key",
+			"cloudresourcemanager.projects.get for the project of each such module",
+			"cloudkms.cryptoKeys.getIamPolicy, cloudkms.keyRings.getIamPolicy and cloudresourcemanager.projects.getIamPolicy for the key, its key ring and its project",
+		}
+	case testGPUImagesName.String():
+		return []string{
+			"compute.images.get or compute.images.getFromFamily for the boot image of each module with GPUs",
+		}
+	case testExternalIPsName.String():
+		return []string{
+			"cloudresourcemanager.projects.getEffectiveOrgPolicy for the project of each module that creates VMs with external IPs",
+		}
+	case testGPUNetworkingName.String():
+		return []string{
+			"compute.subnetworks.get or compute.networks.get for each existing network of the network interfaces of modules with A3 VMs",
+			"compute.firewalls.list for the projects of those networks",
+		}
+	case testModuleZonesName.String():
+		return []string{
+			"compute.zones.list and compute.regions.list for the project of each module that sets its own zone, shared by the zone and region validators of the project",
+		}
+	case execName.String():
+		return []string{fmt.Sprintf("none; runs %s on the machine running ghpc", in("command"))}
+	default:
+		return nil
+	}
+}
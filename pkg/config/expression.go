@@ -15,8 +15,10 @@
 package config
 
 import (
+	"container/list"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
@@ -78,7 +80,7 @@ func extractSimpleVarExpression(s string) (string, error) {
 	}
 	contents := simpleVariableExp.FindStringSubmatch(s)
 	if len(contents) != 2 { // Should always be (match, contents) here
-		return "", fmt.Errorf("%s %s, failed to extract contents: %v", errorMessages["invalidVar"], s, contents)
+		return "", configErrorf("invalidVar", " %s, failed to extract contents: %v", s, contents)
 	}
 	return contents[1], nil
 }
@@ -208,11 +210,57 @@ type Expression interface {
 	key() expressionKey
 }
 
+// maxParsedExpressions bounds the number of expressions that ParseExpression
+// keeps parsed, so that long-lived processes, e.g. ghpc serve, do not grow
+// with every distinct expression they are given
+const maxParsedExpressions = 4096
+
+// parsedExpressions caches the expressions returned by ParseExpression, as
+// expansion parses the same references for every module that uses them. The
+// least recently used expressions are evicted past maxParsedExpressions.
+var parsedExpressions = struct {
+	sync.Mutex
+	m     map[string]*list.Element
+	order *list.List // of parsedExpression, most recently used first
+}{m: map[string]*list.Element{}, order: list.New()}
+
+type parsedExpression struct {
+	src string
+	e   BaseExpression
+}
+
 // ParseExpression returns Expression
 func ParseExpression(s string) (Expression, error) {
+	parsedExpressions.Lock()
+	el, ok := parsedExpressions.m[s]
+	if ok {
+		parsedExpressions.order.MoveToFront(el)
+	}
+	parsedExpressions.Unlock()
+	if ok {
+		return el.Value.(parsedExpression).e, nil
+	}
+
+	e, err := parseExpression(s)
+	if err != nil {
+		return nil, err
+	}
+	parsedExpressions.Lock()
+	defer parsedExpressions.Unlock()
+	if _, ok := parsedExpressions.m[s]; !ok {
+		parsedExpressions.m[s] = parsedExpressions.order.PushFront(parsedExpression{src: s, e: e})
+		for parsedExpressions.order.Len() > maxParsedExpressions {
+			last := parsedExpressions.order.Remove(parsedExpressions.order.Back()).(parsedExpression)
+			delete(parsedExpressions.m, last.src)
+		}
+	}
+	return e, nil
+}
+
+func parseExpression(s string) (BaseExpression, error) {
 	e, diag := hclsyntax.ParseExpression([]byte(s), "", hcl.Pos{})
 	if diag.HasErrors() {
-		return nil, diag
+		return BaseExpression{}, diag
 	}
 	sToks, _ := hclsyntax.LexExpression([]byte(s), "", hcl.Pos{})
 	wToks := make(hclwrite.Tokens, len(sToks))
@@ -220,13 +268,18 @@ func ParseExpression(s string) (Expression, error) {
 		wToks[i] = &hclwrite.Token{Type: st.Type, Bytes: st.Bytes}
 	}
 
-	ts := e.Variables()
-	rs := make([]Reference, len(ts))
-	for i, t := range ts {
-		var err error
-		if rs[i], err = TraversalToReference(t); err != nil {
-			return nil, err
+	rs := []Reference{}
+	for _, t := range e.Variables() {
+		// data sources, e.g. the remote states of external deployments, refer
+		// to nothing in the blueprint
+		if t.RootName() == "data" {
+			continue
+		}
+		r, err := TraversalToReference(t)
+		if err != nil {
+			return BaseExpression{}, err
 		}
+		rs = append(rs, r)
 	}
 	return BaseExpression{e: e, toks: wToks, rs: rs}, nil
 }
@@ -263,7 +316,14 @@ func (e BaseExpression) Eval(bp Blueprint) (cty.Value, error) {
 
 // Tokenize returns Tokens to be used for marshalling HCL
 func (e BaseExpression) Tokenize() hclwrite.Tokens {
-	return e.toks
+	// hclwrite updates the spacing of the tokens it formats, give each caller
+	// its own so that writing HCL does not change the expression
+	toks := make(hclwrite.Tokens, len(e.toks))
+	for i, t := range e.toks {
+		c := *t
+		toks[i] = &c
+	}
+	return toks
 }
 
 // References return Reference for all variables used in the expression
@@ -15,6 +15,7 @@
 package config
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -141,3 +142,35 @@ func TestSimpleVarToExpression(t *testing.T) {
 		})
 	}
 }
+
+func TestParseExpressionEvicts(t *testing.T) {
+	first := "var.first_of_many"
+	if _, err := ParseExpression(first); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxParsedExpressions; i++ {
+		if _, err := ParseExpression(fmt.Sprintf("var.v%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parsedExpressions.Lock()
+	n := len(parsedExpressions.m)
+	_, kept := parsedExpressions.m[first]
+	parsedExpressions.Unlock()
+	if n > maxParsedExpressions {
+		t.Errorf("got %d parsed expressions, want at most %d", n, maxParsedExpressions)
+	}
+	if kept {
+		t.Errorf("least recently used expression %q was not evicted", first)
+	}
+
+	// evicted expressions are parsed anew
+	exp, err := ParseExpression(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(exp.Tokenize().Bytes()); got != first {
+		t.Errorf("got %q, want %q", got, first)
+	}
+}
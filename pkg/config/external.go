@@ -0,0 +1,261 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// externalRoot is the root of references to the outputs of the groups of
+// external deployments, e.g. $(external.network.primary.network_name); such
+// references are parsed as references to the outputs of a module with this ID
+const externalRoot ModuleID = "external"
+
+// remoteStateData is the type of the Terraform data sources that read the
+// states of the groups of external deployments
+const remoteStateData = "terraform_remote_state"
+
+// ExternalDeployment is another deployment whose group outputs modules can
+// read, from the Terraform states of its groups
+type ExternalDeployment struct {
+	// TerraformBackend is the backend of the groups of the deployment; the
+	// {{group}} placeholder of its configuration is replaced by the name of
+	// each group read, e.g. prefix: hpc-network/shared/{{group}}
+	TerraformBackend TerraformBackend `yaml:"terraform_backend"`
+}
+
+// RemoteState is a terraform_remote_state data source, which reads the
+// states of the groups of an external deployment that a group uses
+type RemoteState struct {
+	// Name is the name of the external deployment
+	Name    string
+	Backend string
+	// Configs are the backend configurations of the states of the groups
+	// read, by group name
+	Configs map[GroupName]Dict
+}
+
+// checkExternalDeployments errors if external deployments cannot be read, or
+// cannot be told apart from the outputs of a module
+func checkExternalDeployments(bp Blueprint) error {
+	if len(bp.ExternalDeployments) == 0 {
+		return nil
+	}
+	if _, err := bp.Module(externalRoot); err == nil {
+		return fmt.Errorf("module ID %s is reserved for references to external deployments, $(%s.deployment.group.output), "+
+			"and cannot be used when the blueprint has external_deployments", externalRoot, externalRoot)
+	}
+	for name, d := range bp.ExternalDeployments {
+		if !hclsyntax.ValidIdentifier(name) {
+			return fmt.Errorf("external deployment %q must be named by a letter followed by letters, digits, underscores or dashes", name)
+		}
+		if d.TerraformBackend.Type == "" {
+			return fmt.Errorf("external deployment %s must set the type of its terraform_backend", name)
+		}
+		if err := checkBackend(d.TerraformBackend); err != nil {
+			return fmt.Errorf("external deployment %s: %w", name, err)
+		}
+		for k, v := range d.TerraformBackend.Configuration.Items() {
+			if v.Type() != cty.String || v.IsNull() || !v.IsKnown() {
+				continue
+			}
+			for _, m := range prefixPlaceholder.FindAllStringSubmatch(v.AsString(), -1) {
+				if m[1] != "group" {
+					return fmt.Errorf("external deployment %s: %s can only use the placeholder {{group}}, got %s", name, k, m[0])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyExternalReferences replaces the references to the outputs of external
+// deployments in module settings by references to the terraform_remote_state
+// data sources that read them
+func (bp *Blueprint) applyExternalReferences() error {
+	if err := checkExternalDeployments(*bp); err != nil {
+		return err
+	}
+	if len(bp.ExternalDeployments) == 0 {
+		return nil // references are to the outputs of module "external"
+	}
+	for gi := range bp.DeploymentGroups {
+		g := &bp.DeploymentGroups[gi]
+		for mi := range g.Modules {
+			m := &g.Modules[mi]
+			for name, v := range m.Settings.Items() {
+				r, err := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+					return bp.substituteExternalReference(v, *m)
+				})
+				if err != nil {
+					return fmt.Errorf("module %s setting %s: %w", m.ID, name, err)
+				}
+				m.Settings.Set(name, r)
+			}
+		}
+	}
+	return nil
+}
+
+// substituteExternalReference returns the reference to the remote state of an
+// expression that refers to the output of an external deployment, or the
+// value itself if it does not refer to one
+func (bp Blueprint) substituteExternalReference(v cty.Value, m Module) (cty.Value, error) {
+	e, is := IsExpressionValue(v)
+	if !is {
+		return v, nil
+	}
+	refs := e.References()
+	if !slices.ContainsFunc(refs, func(r Reference) bool { return !r.GlobalVar && r.Module == externalRoot }) {
+		return v, nil
+	}
+	const usage = "the outputs of external deployments can only be referenced on their own, as $(external.deployment.group.output)"
+	if len(refs) > 1 {
+		return cty.NilVal, fmt.Errorf(usage)
+	}
+	hexp, diag := hclsyntax.ParseExpression(e.Tokenize().Bytes(), "", hcl.Pos{})
+	if diag.HasErrors() {
+		return cty.NilVal, diag
+	}
+	texp, ok := hexp.(*hclsyntax.ScopeTraversalExpr)
+	if !ok || len(texp.Traversal) < 5 {
+		return cty.NilVal, fmt.Errorf(usage)
+	}
+	// module.external.deployment.group.output
+	names := []string{}
+	for _, step := range texp.Traversal[2:5] {
+		a, ok := step.(hcl.TraverseAttr)
+		if !ok {
+			return cty.NilVal, fmt.Errorf(usage)
+		}
+		names = append(names, a.Name)
+	}
+	deployment, group, output := names[0], names[1], names[2]
+	if _, ok := bp.ExternalDeployments[deployment]; !ok {
+		return cty.NilVal, fmt.Errorf("external deployment %s is not defined in external_deployments, expected one of %s",
+			deployment, strings.Join(bp.externalDeploymentNames(), ", "))
+	}
+	if m.Kind == PackerKind {
+		return cty.NilVal, fmt.Errorf("packer modules cannot refer to the outputs of external deployments")
+	}
+
+	t := hcl.Traversal{
+		hcl.TraverseRoot{Name: "data"},
+		hcl.TraverseAttr{Name: remoteStateData},
+		hcl.TraverseAttr{Name: deployment},
+		hcl.TraverseIndex{Key: cty.StringVal(group)},
+		hcl.TraverseAttr{Name: "outputs"},
+		hcl.TraverseAttr{Name: output},
+	}
+	t = append(t, texp.Traversal[5:]...)
+	r, err := ParseExpression(string(hclwrite.TokensForTraversal(t).Bytes()))
+	if err != nil {
+		return cty.NilVal, err
+	}
+	return r.AsValue(), nil
+}
+
+// externalDeploymentNames returns the sorted names of the external
+// deployments
+func (bp Blueprint) externalDeploymentNames() []string {
+	names := maps.Keys(bp.ExternalDeployments)
+	slices.Sort(names)
+	return names
+}
+
+// remoteStateGroups returns the groups of external deployments whose states
+// the expression reads, by deployment
+func remoteStateGroups(e Expression, found map[string][]GroupName) {
+	hexp, diags := hclsyntax.ParseExpression(e.Tokenize().Bytes(), "", hcl.Pos{})
+	if diags.HasErrors() {
+		return
+	}
+	for _, t := range hexp.Variables() {
+		if t.RootName() != "data" || len(t) < 4 {
+			continue
+		}
+		kind, ok := t[1].(hcl.TraverseAttr)
+		if !ok || kind.Name != remoteStateData {
+			continue
+		}
+		name, ok := t[2].(hcl.TraverseAttr)
+		if !ok {
+			continue
+		}
+		idx, ok := t[3].(hcl.TraverseIndex)
+		if !ok || !idx.Key.Type().Equals(cty.String) || !idx.Key.IsKnown() || idx.Key.IsNull() {
+			continue
+		}
+		g := GroupName(idx.Key.AsString())
+		if !slices.Contains(found[name.Name], g) {
+			found[name.Name] = append(found[name.Name], g)
+		}
+	}
+}
+
+// RemoteStates returns the remote states of the external deployments whose
+// outputs the modules of the group read, sorted by name
+func (bp Blueprint) RemoteStates(g DeploymentGroup) ([]RemoteState, error) {
+	found := map[string][]GroupName{}
+	for _, m := range g.Modules {
+		cty.Walk(m.Settings.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
+			if e, is := IsExpressionValue(v); is {
+				remoteStateGroups(e, found)
+			}
+			return true, nil
+		})
+	}
+
+	states := []RemoteState{}
+	for _, name := range maps.Keys(found) {
+		d, ok := bp.ExternalDeployments[name]
+		if !ok {
+			return nil, fmt.Errorf("group %s reads the state of external deployment %s, which is not defined in external_deployments", g.Name, name)
+		}
+		s := RemoteState{Name: name, Backend: d.TerraformBackend.Type, Configs: map[GroupName]Dict{}}
+		for _, grp := range found[name] {
+			s.Configs[grp] = externalBackendConfig(d.TerraformBackend, grp)
+		}
+		states = append(states, s)
+	}
+	slices.SortFunc(states, func(a, b RemoteState) bool { return a.Name < b.Name })
+	return states, nil
+}
+
+// externalBackendConfig returns the backend configuration of a group of an
+// external deployment, whose {{group}} placeholders are replaced by its name
+func externalBackendConfig(b TerraformBackend, g GroupName) Dict {
+	c := Dict{}
+	for k, v := range b.Configuration.Items() {
+		if v.Type() == cty.String && !v.IsNull() && v.IsKnown() {
+			v = cty.StringVal(prefixPlaceholder.ReplaceAllStringFunc(v.AsString(), func(p string) string {
+				if prefixPlaceholder.FindStringSubmatch(p)[1] == "group" {
+					return string(g)
+				}
+				return p
+			}))
+		}
+		c.Set(k, v)
+	}
+	return c
+}
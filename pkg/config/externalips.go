@@ -0,0 +1,165 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"math/big"
+
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// maxExternalLoginNodesInput is the input of test_external_ips that sets the
+// number of login nodes with external IPs above which it warns
+const maxExternalLoginNodesInput = "max_external_login_nodes"
+
+const defaultMaxExternalLoginNodes = 1
+
+// externalIPRule describes how a module gives external IPs to its VMs
+type externalIPRule struct {
+	// setting adds external IPs when true, or removes them when disables is
+	// true; def is its default
+	setting  string
+	disables bool
+	def      bool
+	// accessConfig is true for modules whose non-empty access_config setting
+	// adds external IPs regardless of setting
+	accessConfig bool
+	// count is the setting of the number of VMs, which defaults to 1
+	count string
+	// login is true for modules whose VMs are the login nodes of a cluster
+	login bool
+}
+
+// externalIPRules describe the modules that can create VMs with external IPs,
+// by module path
+var externalIPRules = map[string]externalIPRule{
+	"compute/vm-instance":                       {setting: "disable_public_ips", disables: true, count: "instance_count"},
+	"compute/pbspro-execution":                  {setting: "enable_public_ips", def: true, count: "instance_count"},
+	"scheduler/pbspro-client":                   {setting: "enable_public_ips", def: true, count: "instance_count", login: true},
+	"scheduler/pbspro-server":                   {setting: "enable_public_ips", def: true, count: "instance_count"},
+	"remote-desktop/chrome-remote-desktop":      {setting: "enable_public_ips", def: true, count: "instance_count"},
+	"scheduler/schedmd-slurm-gcp-v5-controller": {setting: "disable_controller_public_ips", disables: true, def: true, accessConfig: true},
+	"scheduler/schedmd-slurm-gcp-v5-login": {
+		setting: "disable_login_public_ips", disables: true, def: true, accessConfig: true, count: "num_instances", login: true},
+	"scheduler/SchedMD-slurm-on-gcp-controller": {setting: "disable_controller_public_ips", disables: true},
+	"scheduler/SchedMD-slurm-on-gcp-login-node": {
+		setting: "disable_login_public_ips", disables: true, count: "login_node_count", login: true},
+}
+
+// externalIPRuleFor returns the external IP rule of the module, if it has one
+func externalIPRuleFor(m Module) (externalIPRule, bool) {
+	for path, rule := range externalIPRules {
+		if sourceIs(m.Source, path) {
+			return rule, true
+		}
+	}
+	return externalIPRule{}, false
+}
+
+// externalIPModule describes the VMs with external IPs of a module; ok is
+// false if the module creates none, or if its settings depend upon module
+// outputs
+func (bp Blueprint) externalIPModule(m Module) (validators.ExternalIPModule, bool) {
+	rule, ok := externalIPRuleFor(m)
+	if !ok {
+		return validators.ExternalIPModule{}, false
+	}
+	setting := func(name string) (cty.Value, bool) {
+		if !m.Settings.Has(name) {
+			return cty.NilVal, true
+		}
+		v, ok := evalIfKnown(m.Settings.Get(name), bp)
+		if !ok || !v.IsWhollyKnown() {
+			return cty.NilVal, false
+		}
+		return v, true
+	}
+
+	external := false
+	if rule.accessConfig {
+		ac, ok := setting("access_config")
+		if !ok {
+			return validators.ExternalIPModule{}, false
+		}
+		external = ac != cty.NilVal && !ac.IsNull() && ac.CanIterateElements() && ac.LengthInt() > 0
+	}
+	if !external {
+		v, ok := setting(rule.setting)
+		if !ok {
+			return validators.ExternalIPModule{}, false
+		}
+		on := rule.def
+		if v != cty.NilVal && !v.IsNull() && v.Type() == cty.Bool {
+			on = v.True()
+		}
+		external = on != rule.disables
+	}
+	if !external {
+		return validators.ExternalIPModule{}, false
+	}
+
+	em := validators.ExternalIPModule{Module: string(m.ID), VMs: 1, Login: rule.login}
+	if rule.disables {
+		em.Fix = fmt.Sprintf("set %s: true", rule.setting)
+	} else {
+		em.Fix = fmt.Sprintf("set %s: false", rule.setting)
+	}
+	if rule.accessConfig {
+		em.Fix += " and leave access_config empty"
+	}
+	if rule.count != "" {
+		if n, ok := setting(rule.count); ok && n != cty.NilVal && !n.IsNull() && n.Type() == cty.Number {
+			if i, acc := n.AsBigFloat().Int64(); acc == big.Exact {
+				em.VMs = int(i)
+			}
+		}
+	}
+	if em.VMs <= 0 {
+		return validators.ExternalIPModule{}, false
+	}
+	if project, ok := bp.moduleProject(m); ok {
+		em.ProjectID = project
+	}
+	return em, true
+}
+
+// createsExternalIPs returns true if any module creates VMs with external IPs
+func (bp Blueprint) createsExternalIPs() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, ok := bp.externalIPModule(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
+
+// maxExternalLoginNodes returns the max_external_login_nodes input of
+// test_external_ips, or its default
+func (bp Blueprint) maxExternalLoginNodes(c validatorConfig) (int, error) {
+	if !c.Inputs.Has(maxExternalLoginNodesInput) {
+		return defaultMaxExternalLoginNodes, nil
+	}
+	v, ok := evalIfKnown(c.Inputs.Get(maxExternalLoginNodesInput), bp)
+	if ok && !v.IsNull() && v.IsKnown() && v.Type() == cty.Number {
+		if i, acc := v.AsBigFloat().Int64(); acc == big.Exact && i >= 0 {
+			return int(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%s of %s must be a whole number, at least 0", maxExternalLoginNodesInput, testExternalIPsName)
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// fileFunctionExp matches the blueprint strings that are a call of file or
+// templatefile, e.g. $(file("scripts/epilog.sh")) or
+// $(templatefile("scripts/epilog.sh.tpl", { zone = vars.zone }))
+var fileFunctionExp = regexp.MustCompile(`^\$\(((?:file|templatefile)\(.*\))\)$`)
+
+// isFileFunction checks if the string is a call of file or templatefile
+func isFileFunction(s string) bool {
+	return fileFunctionExp.MatchString(s)
+}
+
+// HasFileFunctions returns whether deployment variables, group variables or
+// module settings of the blueprint call file or templatefile, which read files
+// on the machine running ghpc
+func (bp Blueprint) HasFileFunctions() bool {
+	found := false
+	walk := func(d *Dict) {
+		d.Walk(func(_ cty.Path, v cty.Value) (bool, error) {
+			if !v.IsMarked() && v.IsKnown() && !v.IsNull() && v.Type() == cty.String && isFileFunction(v.AsString()) {
+				found = true
+			}
+			return !found, nil
+		})
+	}
+	walk(&bp.Vars)
+	for ig := range bp.DeploymentGroups {
+		walk(&bp.DeploymentGroups[ig].Vars)
+	}
+	bp.WalkModules(func(m *Module) error {
+		walk(&m.Settings)
+		return nil
+	})
+	return found
+}
+
+// resolveFileFunctions replaces the calls of file and templatefile in
+// deployment variables, group variables and module settings by the contents
+// of their files, read relative to the directory of the blueprint. Files are
+// read once, when the blueprint is expanded, so that long scripts need not be
+// inlined into it; the expanded blueprint holds their contents.
+func (dc *DeploymentConfig) resolveFileFunctions() error {
+	bp := &dc.Config
+	resolve := func(d *Dict, where string) error {
+		for k, v := range d.Items() {
+			r, err := cty.Transform(v, func(_ cty.Path, v cty.Value) (cty.Value, error) {
+				if v.IsMarked() || !v.IsKnown() || v.IsNull() || v.Type() != cty.String || !isFileFunction(v.AsString()) {
+					return v, nil
+				}
+				s, err := dc.callFileFunction(v.AsString())
+				if err != nil {
+					return cty.NilVal, configErrorf("fileFunction", ": %s %s: %v", where, k, err)
+				}
+				return cty.StringVal(EscapeBlueprintString(s)), nil
+			})
+			if err != nil {
+				return err
+			}
+			d.Set(k, r)
+		}
+		return nil
+	}
+
+	if err := resolve(&bp.Vars, "deployment variable"); err != nil {
+		return err
+	}
+	for ig := range bp.DeploymentGroups {
+		g := &bp.DeploymentGroups[ig]
+		if err := resolve(&g.Vars, fmt.Sprintf("group %s variable", g.Name)); err != nil {
+			return err
+		}
+	}
+	return bp.WalkModules(func(m *Module) error {
+		return resolve(&m.Settings, fmt.Sprintf("module %s setting", m.ID))
+	})
+}
+
+// callFileFunction evaluates a call of file or templatefile, whose arguments
+// can only refer to deployment variables
+func (dc DeploymentConfig) callFileFunction(s string) (string, error) {
+	src := fileFunctionExp.FindStringSubmatch(s)[1]
+	expr, diags := hclsyntax.ParseExpression([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", diags
+	}
+
+	// the names of templatefile variables are traversals too, which are kept
+	keys := map[hclsyntax.Expression]bool{}
+	hclsyntax.VisitAll(expr, func(n hclsyntax.Node) hcl.Diagnostics {
+		if k, ok := n.(*hclsyntax.ObjectConsKeyExpr); ok {
+			keys[k.Wrapped] = true
+		}
+		return nil
+	})
+	var refErr error
+	hclsyntax.VisitAll(expr, func(n hclsyntax.Node) hcl.Diagnostics {
+		t, ok := n.(*hclsyntax.ScopeTraversalExpr)
+		if !ok || keys[t] {
+			return nil
+		}
+		if t.Traversal.RootName() != "vars" {
+			refErr = fmt.Errorf("%s refers to %s; the arguments of file and templatefile can only refer to deployment variables, as vars.name",
+				src, t.Traversal.RootName())
+			return nil
+		}
+		t.Traversal[0] = hcl.TraverseRoot{Name: "var", SrcRange: t.Traversal[0].SourceRange()}
+		return nil
+	})
+	if refErr != nil {
+		return "", refErr
+	}
+
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{"var": dc.Config.Vars.AsObject()},
+		Functions: map[string]function.Function{
+			"file":         fileFunc(dc.blueprintDir),
+			"templatefile": templateFileFunc(dc.blueprintDir),
+		},
+	}
+	v, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	if !v.IsWhollyKnown() || v.IsNull() || v.Type() != cty.String {
+		return "", fmt.Errorf("%s is not a string", src)
+	}
+	return v.AsString(), nil
+}
+
+// readBlueprintFile reads a file whose path is relative to dir. Absolute paths
+// and paths that leave dir are refused, so that blueprints only read the files
+// that come with them.
+func readBlueprintFile(dir string, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s is an absolute path; paths must be relative to the directory of the blueprint", path)
+	}
+	full := filepath.Join(dir, path)
+	rel, err := filepath.Rel(dir, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the directory of the blueprint", path)
+	}
+	b, err := os.ReadFile(full)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// fileFunc returns the contents of a file, like the Terraform function of
+// the same name
+func fileFunc(dir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{{Name: "path", Type: cty.String}},
+		Type:   function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+			s, err := readBlueprintFile(dir, args[0].AsString())
+			if err != nil {
+				return cty.NilVal, err
+			}
+			return cty.StringVal(s), nil
+		},
+	})
+}
+
+// templateFileFunc renders a file as a Terraform template, whose variables
+// are the attributes of an object, like the Terraform function of the same
+// name; templates can call no functions
+func templateFileFunc(dir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+			{Name: "vars", Type: cty.DynamicPseudoType},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+			path, vars := args[0].AsString(), args[1]
+			if !vars.Type().IsObjectType() && !vars.Type().IsMapType() {
+				return cty.NilVal, fmt.Errorf("the variables of template %s must be an object", path)
+			}
+			s, err := readBlueprintFile(dir, path)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			tmpl, diags := hclsyntax.ParseTemplate([]byte(s), path, hcl.Pos{Line: 1, Column: 1})
+			if diags.HasErrors() {
+				return cty.NilVal, diags
+			}
+			ctx := &hcl.EvalContext{Variables: map[string]cty.Value{}}
+			if !vars.IsNull() && vars.LengthInt() > 0 {
+				ctx.Variables = vars.AsValueMap()
+			}
+			v, diags := tmpl.Value(ctx)
+			if diags.HasErrors() {
+				return cty.NilVal, diags
+			}
+			if !v.IsWhollyKnown() {
+				return cty.NilVal, fmt.Errorf("template %s depends on values that are only known on deployment", path)
+			}
+			return convert.Convert(v, cty.String)
+		},
+	})
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// canonical order of keys in blueprints; keys that are not listed keep their
+// relative order after the listed keys
+var (
+	blueprintKeyOrder = []string{
+		"blueprint_name", "ghpc_version", "minimum_ghpc_version", "ghpc_metadata", "validation_level",
+		"validation_timeout", "validators", "module_aliases", "module_policy", "vars", "var_sources",
+		"terraform_backend_defaults", "terraform_backends", "external_deployments", "notifications", "deployment_groups", "secrets",
+	}
+	validatorKeyOrder = []string{
+		"validator", "inputs", "skip", "skip_reason", "timeout", "ignore_modules", "ignore_groups",
+	}
+	groupKeyOrder = []string{
+		"group", "kind", "subgroup_of", "backend", "terraform_backend", "project_id", "retry", "modules",
+	}
+	moduleKeyOrder = []string{
+		"id", "source", "source_hash", "kind", "enabled", "use", "depends", "transforms", "settings", "imports", "outputs",
+		"required_apis", "mock",
+	}
+)
+
+// FormatBlueprint returns the blueprint YAML in src in canonical form: keys
+// are in a stable order, expressions are formatted as HCL and strings are
+// only quoted when YAML requires it. Comments are preserved, as is everything
+// up to and including a leading document start marker ("---"), which usually
+// holds the license header.
+func FormatBlueprint(src []byte) ([]byte, error) {
+	header, doc, err := parseBlueprintNode(src, "formatted")
+	if err != nil {
+		return nil, err
+	}
+
+	root := doc.Content[0]
+	sortMapping(root, blueprintKeyOrder)
+	for _, v := range mappingValue(root, "validators").Content {
+		sortMapping(v, validatorKeyOrder)
+	}
+	for _, g := range mappingValue(root, "deployment_groups").Content {
+		sortMapping(g, groupKeyOrder)
+		for _, m := range mappingValue(g, "modules").Content {
+			sortMapping(m, moduleKeyOrder)
+		}
+	}
+	normalizeScalars(doc)
+	return encodeBlueprintNode(header, doc)
+}
+
+// parseBlueprintNode parses the blueprint YAML in src, which is rewritten
+// as the action tells, into its header, see splitDocumentHeader, and the
+// document node of the rest
+func parseBlueprintNode(src []byte, action string) ([]byte, *yaml.Node, error) {
+	// rewriting the first blueprint of a stream would drop the others
+	if len(splitStream(src)) > 1 {
+		return nil, nil, fmt.Errorf("files of several blueprints cannot be %s", action)
+	}
+	header, body := splitDocumentHeader(src)
+
+	// rewriting would invalidate the message authentication code of sops
+	var encrypted yaml.Node
+	if yaml.Unmarshal(body, &encrypted) == nil && isSopsEncrypted(&encrypted) {
+		return nil, nil, fmt.Errorf("the blueprint is encrypted with sops and cannot be %s", action)
+	}
+
+	var bp Blueprint
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&bp); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the blueprint, check YAML syntax for errors: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("a blueprint must be a YAML mapping")
+	}
+	return header, &doc, nil
+}
+
+// encodeBlueprintNode returns the YAML of a blueprint document node, after
+// its header
+func encodeBlueprintNode(header []byte, doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(header)
+	if len(header) > 0 {
+		buf.WriteString("\n")
+	}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// splitDocumentHeader splits src after a "---" line that is only preceded by
+// comments and blank lines
+func splitDocumentHeader(src []byte) ([]byte, []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	offset := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		offset += len(line) + 1
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "---":
+			if offset > len(src) {
+				offset = len(src)
+			}
+			return src[:offset], src[offset:]
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			continue
+		default:
+			return nil, src
+		}
+	}
+	return nil, src
+}
+
+// mappingValue returns the value of key in a mapping node, or an empty node
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if i := mappingKeyIndex(n, key); i != -1 {
+		return n.Content[i+1]
+	}
+	return &yaml.Node{}
+}
+
+// sortMapping orders the keys of a mapping node, carrying their values and
+// comments along
+func sortMapping(n *yaml.Node, order []string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	type pair struct{ key, value *yaml.Node }
+	pairs := []pair{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		pairs = append(pairs, pair{n.Content[i], n.Content[i+1]})
+	}
+	rank := func(p pair) int {
+		if r := slices.Index(order, p.key.Value); r != -1 {
+			return r
+		}
+		return len(order)
+	}
+	slices.SortStableFunc(pairs, func(a, b pair) bool { return rank(a) < rank(b) })
+
+	n.Content = n.Content[:0]
+	for _, p := range pairs {
+		n.Content = append(n.Content, p.key, p.value)
+	}
+}
+
+// normalizeScalars formats the expressions in string scalars and clears their
+// quoting style so that the encoder only quotes strings that need it
+func normalizeScalars(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!str" && n.Style&yaml.TaggedStyle == 0 {
+		n.Value = normalizeExpression(n.Value)
+		if strings.Contains(n.Value, "\n") {
+			n.Style = yaml.LiteralStyle
+		} else {
+			n.Style = 0
+		}
+	}
+	for _, c := range n.Content {
+		normalizeScalars(c)
+	}
+}
+
+// normalizeExpression formats the HCL inside "$(...)" and "((...))" strings;
+// other strings, and expressions that do not parse, are returned unchanged
+func normalizeExpression(s string) string {
+	for _, delims := range [][2]string{{"$(", ")"}, {"((", "))"}} {
+		open, end := delims[0], delims[1]
+		if len(s) <= len(open)+len(end) || !strings.HasPrefix(s, open) || !strings.HasSuffix(s, end) {
+			continue
+		}
+		inner := s[len(open) : len(s)-len(end)]
+		if _, diags := hclsyntax.ParseExpression([]byte(inner), "", hcl.Pos{}); diags.HasErrors() {
+			return s
+		}
+		return open + strings.TrimSpace(string(hclwrite.Format([]byte(inner)))) + end
+	}
+	return s
+}
@@ -0,0 +1,250 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// gpuNICRule is the network interfaces that the VMs of a GPU machine type
+// need for GPUDirect: a primary interface and one per GPU network, each in a
+// VPC network of its own
+type gpuNICRule struct {
+	nics  int
+	stack string
+}
+
+// gpuNICRules are the GPU machine types whose GPUs communicate through
+// networks of their own, by machine type
+var gpuNICRules = map[string]gpuNICRule{
+	"a3-highgpu-8g": {nics: 5, stack: "GPUDirect-TCPX"},
+	"a3-megagpu-8g": {nics: 9, stack: "GPUDirect-TCPXO"},
+}
+
+// gpuNIC is a network interface of the VMs of a module; its network and
+// subnetwork are cty.NilVal if unset, and may refer to module outputs
+type gpuNIC struct {
+	network    cty.Value
+	subnetwork cty.Value
+	project    cty.Value
+	nicType    cty.Value
+}
+
+// gpuNICRuleFor returns the network interfaces that the VMs of the module
+// need, if they have a GPU machine type that needs several
+func (bp Blueprint) gpuNICRuleFor(m Module) (string, gpuNICRule, bool) {
+	mt, ok := bp.moduleMachineType(m)
+	if !ok {
+		return "", gpuNICRule{}, false
+	}
+	r, ok := gpuNICRules[mt]
+	return mt, r, ok
+}
+
+// usesGPUNetworking returns true if any module creates VMs whose GPUs need
+// networks of their own
+func (bp Blueprint) usesGPUNetworking() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, _, ok := bp.gpuNICRuleFor(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
+
+// moduleNICs returns the network interfaces of the VMs of a vm-instance
+// module; ok is false if they depend upon module outputs
+func (bp Blueprint) moduleNICs(m Module) ([]gpuNIC, bool) {
+	if !m.Settings.Has("network_interfaces") {
+		// the default interface only uses gVNIC with a bandwidth tier
+		nic := gpuNIC{nicType: cty.NullVal(cty.String)}
+		if tier, ok := evalIfKnown(m.Settings.Get("bandwidth_tier"), bp); ok && isNonEmptyString(tier) && tier.AsString() != "not_enabled" {
+			nic.nicType = cty.StringVal("GVNIC")
+		}
+		nic.network, nic.subnetwork = m.Settings.Get("network_self_link"), m.Settings.Get("subnetwork_self_link")
+		return []gpuNIC{nic}, true
+	}
+
+	v := m.Settings.Get("network_interfaces")
+	if _, is := IsExpressionValue(v); is {
+		var ok bool
+		if v, ok = evalIfKnown(v, bp); !ok {
+			return nil, false
+		}
+	}
+	if v.IsNull() || !v.IsKnown() || !(v.Type().IsListType() || v.Type().IsTupleType()) {
+		return nil, false
+	}
+	nics := []gpuNIC{}
+	for _, el := range v.AsValueSlice() {
+		if _, is := IsExpressionValue(el); is {
+			var ok bool
+			if el, ok = evalIfKnown(el, bp); !ok {
+				return nil, false
+			}
+		}
+		if el.IsNull() || !(el.Type().IsObjectType() || el.Type().IsMapType()) {
+			return nil, false
+		}
+		attrs := el.AsValueMap()
+		nic := gpuNIC{}
+		for name, f := range map[string]*cty.Value{
+			"network": &nic.network, "subnetwork": &nic.subnetwork, "subnetwork_project": &nic.project, "nic_type": &nic.nicType,
+		} {
+			if a, ok := attrs[name]; ok && !a.IsNull() {
+				*f = a
+			}
+		}
+		nics = append(nics, nic)
+	}
+	return nics, true
+}
+
+// nicNetwork identifies the VPC network of an interface, to tell whether two
+// interfaces share one: the VPC module that its subnetwork or network refers
+// to, or its literal subnetwork or network; it is empty if it is not known
+func (bp Blueprint) nicNetwork(nic gpuNIC) (string, *Module) {
+	for _, v := range []cty.Value{nic.subnetwork, nic.network} {
+		if v == cty.NilVal || v.IsNull() {
+			continue
+		}
+		if e, is := IsExpressionValue(v); is {
+			for _, r := range e.References() {
+				if vpc, err := bp.Module(r.Module); !r.GlobalVar && err == nil && sourceIs(vpc.Source, vpcModule) {
+					return "module " + string(vpc.ID), vpc
+				}
+			}
+			if ev, ok := evalIfKnown(v, bp); ok && isNonEmptyString(ev) {
+				return ev.AsString(), nil
+			}
+			return "", nil
+		}
+		if isNonEmptyString(v) {
+			return v.AsString(), nil
+		}
+	}
+	return "", nil
+}
+
+// gpuNetworkModule describes the network interfaces of a module whose GPUs
+// need networks of their own: the settings that Compute Engine rejects, the
+// settings that leave GPUDirect unusable, and the existing networks whose
+// firewall rules are checked. ok is false if the module creates no such VMs.
+func (bp Blueprint) gpuNetworkModule(m Module) (validators.GPUNetworkModule, bool) {
+	mt, rule, ok := bp.gpuNICRuleFor(m)
+	if !ok {
+		return validators.GPUNetworkModule{}, false
+	}
+	gm := validators.GPUNetworkModule{Module: string(m.ID), MachineType: mt, Stack: rule.stack}
+	if !sourceIs(m.Source, vmInstanceModule) {
+		gm.Warnings = append(gm.Warnings, fmt.Sprintf(
+			"its %s VMs need %d network interfaces for %s, but it cannot attach network interfaces; "+
+				"use %s with network_interfaces", mt, rule.nics, rule.stack, vmInstanceModule))
+		return gm, true
+	}
+	nics, ok := bp.moduleNICs(m)
+	if !ok {
+		return gm, true
+	}
+
+	switch {
+	case len(nics) > rule.nics:
+		gm.Problems = append(gm.Problems, fmt.Sprintf(
+			"it has %d network interfaces, but %s VMs support at most %d", len(nics), mt, rule.nics))
+	case len(nics) < rule.nics:
+		gm.Warnings = append(gm.Warnings, fmt.Sprintf(
+			"it has %d network interfaces, but %s VMs need %d, each in a VPC network of its own, for %s; "+
+				"without them GPUs communicate over the primary network, e.g. NCCL falls back to slow sockets",
+			len(nics), mt, rule.nics, rule.stack))
+	}
+
+	project, _ := bp.moduleProject(m)
+	region, _ := bp.moduleRegion(m)
+	networks := map[string]int{}
+	for i, nic := range nics {
+		set := func(v cty.Value) bool { return v != cty.NilVal && !v.IsNull() }
+		if len(nics) > 1 && set(nic.network) && !set(nic.subnetwork) {
+			gm.Problems = append(gm.Problems, fmt.Sprintf(
+				"network interface %d sets a network but no subnetwork; every network interface of %s VMs needs a subnetwork of its own", i, mt))
+		}
+		if isNonEmptyString(nic.nicType) && nic.nicType.AsString() != "GVNIC" {
+			gm.Problems = append(gm.Problems, fmt.Sprintf(
+				"network interface %d has nic_type %s, but %s VMs only support GVNIC", i, nic.nicType.AsString(), mt))
+		} else if !set(nic.nicType) {
+			fix := "set its nic_type to GVNIC"
+			if !m.Settings.Has("network_interfaces") {
+				fix = "set bandwidth_tier to gvnic_enabled or tier_1_enabled"
+			}
+			gm.Warnings = append(gm.Warnings, fmt.Sprintf(
+				"network interface %d does not use gVNIC, which %s VMs require; %s", i, mt, fix))
+		}
+
+		network, vpc := bp.nicNetwork(nic)
+		if network == "" {
+			continue
+		}
+		if j, dup := networks[network]; dup {
+			gm.Problems = append(gm.Problems, fmt.Sprintf(
+				"network interfaces %d and %d are both in network %s; every network interface must be in a VPC network of its own", j, i, network))
+			continue
+		}
+		networks[network] = i
+
+		switch {
+		case vpc != nil:
+			if w, ok := bp.vpcFirewallWarning(*vpc, i, rule.stack); ok {
+				gm.Warnings = append(gm.Warnings, w)
+			}
+		case isNonEmptyString(nic.subnetwork):
+			gn := validators.GPUNetwork{Interface: i, Subnetwork: nic.subnetwork.AsString(), ProjectID: project, Region: region}
+			if isNonEmptyString(nic.project) {
+				gn.ProjectID = nic.project.AsString()
+			}
+			gm.Networks = append(gm.Networks, gn)
+		case isNonEmptyString(nic.network):
+			gm.Networks = append(gm.Networks, validators.GPUNetwork{Interface: i, Network: nic.network.AsString(), ProjectID: project})
+		}
+	}
+	return gm, true
+}
+
+// vpcFirewallWarning warns if a VPC module creates no firewall rule that
+// lets the VMs of a network interface reach each other
+func (bp Blueprint) vpcFirewallWarning(vpc Module, nic int, stack string) (string, bool) {
+	internal := cty.True
+	if vpc.Settings.Has("enable_internal_traffic") {
+		v, ok := evalIfKnown(vpc.Settings.Get("enable_internal_traffic"), bp)
+		if !ok || v.IsNull() || v.Type() != cty.Bool {
+			return "", false
+		}
+		internal = v
+	}
+	if internal.True() {
+		return "", false
+	}
+	if vpc.Settings.Has("firewall_rules") {
+		v, ok := evalIfKnown(vpc.Settings.Get("firewall_rules"), bp)
+		if !ok || (!v.IsNull() && v.CanIterateElements() && v.LengthInt() > 0) {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("network %s of network interface %d sets enable_internal_traffic to false and has no firewall_rules; "+
+		"%s traffic between the VMs would be blocked", vpc.ID, nic, stack), true
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// bundledGPUs are the GPUs of the machine families that come with GPUs rather
+// than attaching them with guest_accelerator
+var bundledGPUs = map[string]string{
+	"a2": "nvidia-tesla-a100",
+	"a3": "nvidia-h100-80gb",
+	"g2": "nvidia-l4",
+}
+
+// moduleAccelerator returns the type of the GPUs of the VMs of a module, from
+// its guest_accelerator setting or its machine type
+func (bp Blueprint) moduleAccelerator(m Module) (string, bool) {
+	if m.Settings.Has("guest_accelerator") {
+		v, ok := evalIfKnown(m.Settings.Get("guest_accelerator"), bp)
+		if ok && !v.IsNull() && v.IsWhollyKnown() && (v.Type().IsListType() || v.Type().IsTupleType()) {
+			for _, ga := range v.AsValueSlice() {
+				if !(ga.Type().IsObjectType() || ga.Type().IsMapType()) || ga.IsNull() {
+					continue
+				}
+				attrs := ga.AsValueMap()
+				count, ty := attrs["count"], attrs["type"]
+				if count == cty.NilVal || count.IsNull() || count.Type() != cty.Number || count.LessThanOrEqualTo(cty.Zero).True() {
+					continue
+				}
+				if isNonEmptyString(ty) {
+					return ty.AsString(), true
+				}
+			}
+		}
+	}
+	mt, ok := bp.moduleMachineType(m)
+	if !ok {
+		return "", false
+	}
+	family, _, _ := strings.Cut(mt, "-")
+	gpu, ok := bundledGPUs[family]
+	if family == "a2" && strings.HasPrefix(mt, "a2-ultragpu") {
+		gpu = "nvidia-a100-80gb"
+	}
+	return gpu, ok
+}
+
+// gpuModule describes the GPUs and boot image of a module; ok is false if the
+// module creates no known VMs with GPUs
+func (bp Blueprint) gpuModule(m Module) (validators.GPUModule, bool) {
+	r, ok := diskRuleFor(m)
+	if !ok {
+		return validators.GPUModule{}, false
+	}
+	gpu, ok := bp.moduleAccelerator(m)
+	if !ok {
+		return validators.GPUModule{}, false
+	}
+	gm := validators.GPUModule{Module: string(m.ID), Accelerator: gpu}
+	if project, family, name, ok := bp.bootImage(m, r); ok {
+		gm.ImageProject, gm.ImageFamily, gm.ImageName = project, family, name
+	}
+	return gm, true
+}
+
+// attachesGPUs returns true if any module creates VMs with GPUs
+func (bp Blueprint) attachesGPUs() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, ok := bp.gpuModule(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// groupVarsRoot is the root of references to the variables of a deployment
+// group, e.g. $(group.zone); such references are parsed as references to the
+// outputs of a module with this ID
+const groupVarsRoot ModuleID = "group"
+
+// definesGroupVars returns true if any deployment group defines variables
+func (bp Blueprint) definesGroupVars() bool {
+	for _, g := range bp.DeploymentGroups {
+		if !g.Vars.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGroupVars errors if group variables shadow deployment variables, are
+// expressions, or cannot be told apart from the outputs of a module
+func checkGroupVars(bp Blueprint) error {
+	if !bp.definesGroupVars() {
+		return nil
+	}
+	if _, err := bp.Module(groupVarsRoot); err == nil {
+		return fmt.Errorf("module ID %s is reserved for references to group variables, $(%s.name), and cannot be used when groups define vars", groupVarsRoot, groupVarsRoot)
+	}
+	for _, g := range bp.DeploymentGroups {
+		for name, v := range g.Vars.Items() {
+			if bp.Vars.Has(name) {
+				return fmt.Errorf("group %s defines variable %s, which is already a deployment variable; group variables must have unique names", g.Name, name)
+			}
+			err := cty.Walk(v, func(p cty.Path, v cty.Value) (bool, error) {
+				if _, is := IsExpressionValue(v); is {
+					return false, fmt.Errorf("group %s variable %s: can not use expressions in vars block", g.Name, name)
+				}
+				return true, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyGroupVars replaces references to group variables in module settings
+// by the values of the variables of the group of the module. Modules cannot
+// refer to the variables of other groups.
+func (bp *Blueprint) applyGroupVars() error {
+	if err := checkGroupVars(*bp); err != nil {
+		return err
+	}
+	if _, err := bp.Module(groupVarsRoot); err == nil {
+		return nil // references are to the outputs of module "group"
+	}
+	for gi := range bp.DeploymentGroups {
+		g := &bp.DeploymentGroups[gi]
+		for mi := range g.Modules {
+			m := &g.Modules[mi]
+			for name, v := range m.Settings.Items() {
+				r, err := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+					return substituteGroupVars(v, *g)
+				})
+				if err != nil {
+					return fmt.Errorf("module %s setting %s: %w", m.ID, name, err)
+				}
+				m.Settings.Set(name, r)
+			}
+		}
+	}
+	return nil
+}
+
+// substituteGroupVars returns the value of an expression that refers to a
+// variable of group g, or the value itself if it does not refer to one
+func substituteGroupVars(v cty.Value, g DeploymentGroup) (cty.Value, error) {
+	e, is := IsExpressionValue(v)
+	if !is {
+		return v, nil
+	}
+	refs := e.References()
+	found := false
+	for _, r := range refs {
+		if r.GlobalVar || r.Module != groupVarsRoot {
+			continue
+		}
+		if !g.Vars.Has(r.Name) {
+			return cty.NilVal, fmt.Errorf("group %s has no variable %s; modules can only refer to the vars of their own group", g.Name, r.Name)
+		}
+		found = true
+	}
+	if !found {
+		return v, nil
+	}
+	if len(refs) > 1 {
+		return cty.NilVal, fmt.Errorf("group variables can only be referenced on their own, as $(%s.name)", groupVarsRoot)
+	}
+
+	hexp, diag := hclsyntax.ParseExpression(e.Tokenize().Bytes(), "", hcl.Pos{})
+	if diag.HasErrors() {
+		return cty.NilVal, diag
+	}
+	ctx := hcl.EvalContext{Variables: map[string]cty.Value{
+		"module": cty.ObjectVal(map[string]cty.Value{string(groupVarsRoot): g.Vars.AsObject()}),
+	}}
+	r, diag := hexp.Value(&ctx)
+	if diag.HasErrors() {
+		return cty.NilVal, diag
+	}
+	return r, nil
+}
@@ -0,0 +1,275 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// maxHostnameLength is the longest hostname, and RFC 1035 label, accepted by
+// Compute Engine for a VM
+const maxHostnameLength = 63
+
+var rfc1035LabelExp = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// settingFunc returns the value of a module setting, or def if it is not set;
+// ok is false if the value depends upon module outputs
+type settingFunc func(name string, def cty.Value) (v cty.Value, ok bool)
+
+// resourceName is the longest name given by a module to its VMs, or to
+// another kind of resource named after them, such as their boot disks
+type resourceName struct {
+	kind string // e.g. "VM hostname"
+	name string
+	// fix tells which settings to shorten if the name is too long
+	fix string
+}
+
+const shortenVMName = "shorten deployment_name or name_prefix"
+
+// hostnameRule returns the longest hostname given to the VMs of a module, or
+// false if it cannot be determined before deployment
+type hostnameRule func(setting settingFunc) (string, bool)
+
+// vmNameRule returns the longest names of the VMs of a module and of the
+// resources named after them, or false if they cannot be determined before
+// deployment
+type vmNameRule func(bp Blueprint, m Module) ([]resourceName, bool)
+
+// hostnameRules describe how compute modules name their VMs, by module path
+var hostnameRules = map[string]vmNameRule{
+	"compute/vm-instance": func(bp Blueprint, m Module) ([]resourceName, bool) {
+		setting := moduleSetting(bp, m)
+		deployment, dok := stringSetting(setting, "deployment_name")
+		prefix, pok := stringSetting(setting, "name_prefix")
+		add, aok := setting("add_deployment_name_before_prefix", cty.False)
+		if !pok || !aok {
+			return nil, false
+		}
+		switch {
+		case prefix == "":
+			prefix, dok = deployment, dok && deployment != ""
+		case add.Type() == cty.Bool && add.True():
+			prefix = deployment + "-" + prefix
+		default:
+			dok = true
+		}
+		if !dok {
+			return nil, false
+		}
+		last, ok := lastIndex(setting)
+		if !ok {
+			return nil, false
+		}
+		return []resourceName{
+			{kind: "VM hostname", name: fmt.Sprintf("%s-%d", prefix, last), fix: shortenVMName},
+			{kind: "boot disk name", name: fmt.Sprintf("%s-boot-disk-%d", prefix, last), fix: shortenVMName},
+		}, true
+	},
+	"compute/pbspro-execution": hostnameOnly(vmInstanceWrapperRule("-exec")),
+	"scheduler/pbspro-client":  hostnameOnly(vmInstanceWrapperRule("-client")),
+	"scheduler/pbspro-server":  hostnameOnly(vmInstanceWrapperRule("-server")),
+	"compute/htcondor-execute-point": hostnameOnly(func(setting settingFunc) (string, bool) {
+		deployment, ok := stringSetting(setting, "deployment_name")
+		spot, sok := setting("spot", cty.False)
+		if !ok || !sok || deployment == "" {
+			return "", false
+		}
+		name := deployment + "-xp"
+		if spot.Type() == cty.Bool && spot.True() {
+			name = deployment + "-spot-xp"
+		}
+		// managed instance groups append a hyphen and 4 random characters
+		return name + "-abcd", true
+	}),
+	slurmControllerV5: func(bp Blueprint, m Module) ([]resourceName, bool) {
+		cluster, _, ok := bp.slurmClusterName(m)
+		if !ok {
+			return nil, false
+		}
+		return []resourceName{{kind: "VM hostname", name: cluster + "-controller",
+			fix: "shorten slurm_cluster_name or deployment_name"}}, true
+	},
+	slurmPartitionV5: slurmNodeNames,
+}
+
+// hostnameOnly adapts a rule that only names the VMs of a module
+func hostnameOnly(rule hostnameRule) vmNameRule {
+	return func(bp Blueprint, m Module) ([]resourceName, bool) {
+		name, ok := rule(moduleSetting(bp, m))
+		if !ok {
+			return nil, false
+		}
+		return []resourceName{{kind: "VM hostname", name: name, fix: shortenVMName}}, true
+	}
+}
+
+// slurmNodeNames returns the names of the last nodes of the node groups of a
+// Slurm partition, which are named cluster-partition-group-index, counting
+// the dynamic nodes as if all were up. Node groups whose names or sizes are
+// not known before deployment are left out.
+func slurmNodeNames(bp Blueprint, m Module) ([]resourceName, bool) {
+	cluster, _, ok := bp.slurmClusterName(m)
+	if !ok {
+		return nil, false
+	}
+	partition, ok := stringSetting(moduleSetting(bp, m), "partition_name")
+	if !ok || partition == "" {
+		return nil, false
+	}
+	groups := append(slices.Clone(m.Use), referencedModules(m)...)
+	names := []resourceName{}
+	seen := map[ModuleID]bool{}
+	for _, id := range groups {
+		group, err := bp.Module(id)
+		if seen[id] || err != nil || !sourceIs(group.Source, slurmNodeGroupV5) {
+			continue
+		}
+		seen[id] = true
+		name, ok := stringSetting(moduleSetting(bp, *group), "name")
+		static, sok := bp.evalInt(*group, "node_count_static", 0)
+		dynamic, dok := bp.evalInt(*group, "node_count_dynamic_max", defaultNodeCountDynamicMax)
+		if !ok || !sok || !dok {
+			continue
+		}
+		if name == "" {
+			name = "ghpc"
+		}
+		last := static + dynamic - 1
+		if last < 0 {
+			last = 0
+		}
+		names = append(names, resourceName{kind: "VM hostname",
+			name: fmt.Sprintf("%s-%s-%s-%d", cluster, partition, name, last),
+			fix:  fmt.Sprintf("shorten slurm_cluster_name, partition_name or the name of node group %s", id)})
+	}
+	return names, len(names) > 0
+}
+
+// vmInstanceWrapperRule describes modules that name vm-instance VMs after
+// name_prefix, or the deployment name followed by suffix
+func vmInstanceWrapperRule(suffix string) hostnameRule {
+	return func(setting settingFunc) (string, bool) {
+		prefix, ok := stringSetting(setting, "name_prefix")
+		if !ok {
+			return "", false
+		}
+		if prefix == "" {
+			deployment, ok := stringSetting(setting, "deployment_name")
+			if !ok || deployment == "" {
+				return "", false
+			}
+			prefix = deployment + suffix
+		}
+		return indexedHostname(prefix, setting)
+	}
+}
+
+// indexedHostname returns the name of the last of instance_count VMs named
+// after prefix and their index
+func indexedHostname(prefix string, setting settingFunc) (string, bool) {
+	last, ok := lastIndex(setting)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s-%d", prefix, last), true
+}
+
+// lastIndex returns the index of the last of instance_count VMs
+func lastIndex(setting settingFunc) (int64, bool) {
+	count, ok := setting("instance_count", cty.NumberIntVal(1))
+	if !ok || count.IsNull() || count.Type() != cty.Number {
+		return 0, false
+	}
+	n, _ := count.AsBigFloat().Int64()
+	if n < 1 {
+		n = 1
+	}
+	return n - 1, true
+}
+
+// moduleSetting returns the settingFunc of a module, which evaluates its
+// settings that are known before deployment
+func moduleSetting(bp Blueprint, m Module) settingFunc {
+	return func(name string, def cty.Value) (cty.Value, bool) {
+		if !m.Settings.Has(name) {
+			return def, true
+		}
+		return evalIfKnown(m.Settings.Get(name), bp)
+	}
+}
+
+// stringSetting returns the value of a string setting, or "" if it is unset or
+// null; ok is false if the value is not yet known or is not a string
+func stringSetting(setting settingFunc, name string) (string, bool) {
+	v, ok := setting(name, cty.NullVal(cty.String))
+	if !ok {
+		return "", false
+	}
+	if v.IsNull() {
+		return "", true
+	}
+	if v.Type() != cty.String {
+		return "", false
+	}
+	return v.AsString(), true
+}
+
+// hostnameRuleFor returns the hostname rule of the module, if it has one;
+// rules are matched in the order of their paths, so that the result does not
+// depend upon the iteration order of the map
+func hostnameRuleFor(m Module) (vmNameRule, bool) {
+	paths := maps.Keys(hostnameRules)
+	slices.Sort(paths)
+	for _, path := range paths {
+		if sourceIs(m.Source, path) {
+			return hostnameRules[path], true
+		}
+	}
+	return nil, false
+}
+
+// hostnameProblems describes why the hostnames of the VMs of a module, or the
+// names of resources named after them, would be rejected by Compute Engine
+func (bp Blueprint) hostnameProblems(m Module) []string {
+	rule, ok := hostnameRuleFor(m)
+	if !ok {
+		return nil
+	}
+	names, ok := rule(bp, m)
+	if !ok {
+		return nil
+	}
+
+	problems := []string{}
+	for _, n := range names {
+		if len(n.name) > maxHostnameLength {
+			problems = append(problems, fmt.Sprintf(
+				"%s %q is %d characters long; names are limited to %d characters, %s",
+				n.kind, n.name, len(n.name), maxHostnameLength, n.fix))
+		}
+		if !rfc1035LabelExp.MatchString(n.name) {
+			problems = append(problems, fmt.Sprintf(
+				"%s %q must start with a lowercase letter and contain only lowercase letters, digits and hyphens (RFC 1035)",
+				n.kind, n.name))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// builtImageOutput is the name by which modules refer to the image built by a
+// Packer module, e.g. $(image_builder.image)
+const builtImageOutput = "image"
+
+// resolveImageReferences replaces settings that are exactly a reference to
+// the image of a Packer module by the latest image of the family it builds,
+// as an {family, project} object accepted by the instance_image setting of
+// compute modules. Packer modules do not have outputs, but the family of
+// their images is known before they are built; it is checked against the
+// build manifest when the Packer group is deployed.
+func (bp *Blueprint) resolveImageReferences() error {
+	return bp.WalkModules(func(m *Module) error {
+		for name, v := range m.Settings.Items() {
+			expr, ok := IsExpressionValue(v)
+			if !ok {
+				continue
+			}
+			refs := expr.References()
+			if len(refs) != 1 || refs[0].GlobalVar || refs[0].Name != builtImageOutput {
+				continue
+			}
+			// only whole-setting references are replaced
+			if expr.key() != refs[0].AsExpression().key() {
+				continue
+			}
+			builder, err := bp.Module(refs[0].Module)
+			if err != nil || builder.Kind != PackerKind {
+				continue
+			}
+			if bp.GroupIndex(bp.ModuleGroupOrDie(builder.ID).Name) > bp.GroupIndex(bp.ModuleGroupOrDie(m.ID).Name) {
+				return configErrorf("intergroupOrder", ": module %s uses the image of %s, which is built in a later group", m.ID, builder.ID)
+			}
+			image, err := bp.builtImage(*builder)
+			if err != nil {
+				return err
+			}
+			m.Settings.Set(name, image)
+		}
+		return nil
+	})
+}
+
+// builtImage returns the family and project of the images built by a Packer
+// module
+func (bp Blueprint) builtImage(m Module) (cty.Value, error) {
+	family, err := builtImageFamily(m)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	if v, ok := evalIfKnown(family, bp); ok && !v.IsNull() {
+		if v.Type() != cty.String || !imageFamilyExp.MatchString(v.AsString()) {
+			return cty.NilVal, fmt.Errorf("module %s builds images of family %s, which is not a valid image family name: "+
+				"it must be 1 to 63 lowercase letters, digits or hyphens, starting with a letter and not ending with a hyphen",
+				m.ID, v.GoString())
+		}
+	}
+
+	project := GlobalRef("project_id").AsExpression().AsValue()
+	if m.Settings.Has("project_id") {
+		project = m.Settings.Get("project_id")
+	} else if g := bp.ModuleGroupOrDie(m.ID); g.ProjectID != "" {
+		project = cty.StringVal(g.ProjectID)
+	}
+	return cty.ObjectVal(map[string]cty.Value{
+		"family":  family,
+		"project": project,
+	}), nil
+}
+
+// imageFamilyExp matches the names of image families
+var imageFamilyExp = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// builtImageFamily returns the family of the images built by a Packer module:
+// its image_family setting, else the default of its image_family variable,
+// else the deployment name, as in custom-image
+func builtImageFamily(m Module) (cty.Value, error) {
+	if m.Settings.Has("image_family") {
+		return m.Settings.Get("image_family"), nil
+	}
+	mi, err := modulereader.GetModuleInfo(m.Source, m.Kind.String())
+	if err != nil {
+		return cty.NilVal, err
+	}
+	i := slices.IndexFunc(mi.Inputs, func(v modulereader.VarInfo) bool { return v.Name == "image_family" })
+	if i < 0 {
+		return cty.NilVal, fmt.Errorf("the family of the images built by module %s is unknown, "+
+			"as it has no image_family variable; refer to the images by family rather than $(%s.%s)",
+			m.ID, m.ID, builtImageOutput)
+	}
+	if def, ok := mi.Inputs[i].Default.(string); ok && def != "" {
+		return cty.StringVal(def), nil
+	}
+	if m.Settings.Has("deployment_name") {
+		return m.Settings.Get("deployment_name"), nil
+	}
+	return GlobalRef("deployment_name").AsExpression().AsValue(), nil
+}
+
+// BuiltImageFamily returns the family of the images built by a Packer module
+// of the expanded blueprint
+func (bp Blueprint) BuiltImageFamily(m Module) (string, error) {
+	family, err := builtImageFamily(m)
+	if err != nil {
+		return "", err
+	}
+	v, ok := evalIfKnown(family, bp)
+	if !ok || v.Type() != cty.String || v.IsNull() {
+		return "", fmt.Errorf("the family of the images built by module %s is not a known string", m.ID)
+	}
+	return v.AsString(), nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ImpersonatedServiceAccount returns the service account that the providers
+// of the group impersonate: that of the group, or else that of the blueprint.
+// It is empty if the providers use the credentials of the user.
+func (bp Blueprint) ImpersonatedServiceAccount(g DeploymentGroup) string {
+	if g.ImpersonateServiceAccount != "" {
+		return g.ImpersonateServiceAccount
+	}
+	return bp.ImpersonateServiceAccount
+}
+
+// checkImpersonation errors if the impersonated service accounts are not
+// service account emails
+func checkImpersonation(bp Blueprint) error {
+	if err := checkServiceAccountEmail(bp.ImpersonateServiceAccount); err != nil {
+		return err
+	}
+	for _, g := range bp.DeploymentGroups {
+		if g.ImpersonateServiceAccount == "" {
+			continue
+		}
+		if err := checkServiceAccountEmail(g.ImpersonateServiceAccount); err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+func checkServiceAccountEmail(sa string) error {
+	if sa == "" {
+		return nil
+	}
+	if hasVariable(sa) {
+		return fmt.Errorf("impersonate_service_account %s can not use variables", sa)
+	}
+	if !strings.Contains(sa, "@") || !strings.HasSuffix(sa, ".gserviceaccount.com") {
+		return fmt.Errorf("impersonate_service_account %s is not a service account email", sa)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// checkModuleImports verifies the imports of modules, which adopt existing
+// resources rather than creating them. Imports map addresses of resources
+// within a Terraform module, e.g. google_compute_network.network, to the IDs
+// of the existing resources, which may refer to deployment variables.
+func checkModuleImports(bp Blueprint) error {
+	return bp.WalkModules(func(m *Module) error {
+		if len(m.Imports.Items()) == 0 {
+			return nil
+		}
+		if m.Kind != TerraformKind {
+			return fmt.Errorf("module %s imports resources, which only Terraform modules can do", m.ID)
+		}
+		for addr, v := range m.Imports.Items() {
+			if err := checkImportAddress(addr); err != nil {
+				return fmt.Errorf("module %s imports %q: %w", m.ID, addr, err)
+			}
+			if e, is := IsExpressionValue(v); is {
+				for _, r := range e.References() {
+					if !r.GlobalVar {
+						return fmt.Errorf("module %s imports %s with an ID that refers to module %s; IDs can only refer to deployment variables", m.ID, addr, r.Module)
+					}
+				}
+			}
+		}
+		_, err := m.ResolvedImports(bp)
+		return err
+	})
+}
+
+// checkImportAddress verifies that an address names a managed resource, or an
+// instance of one, in a module
+func checkImportAddress(addr string) error {
+	t, diags := hclsyntax.ParseTraversalAbs([]byte(addr), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("not a resource address: %s", diags.Error())
+	}
+	if len(t) < 2 {
+		return fmt.Errorf("not a resource address, expected TYPE.NAME")
+	}
+	if r := t.RootName(); r == "data" || r == "var" || r == "local" {
+		return fmt.Errorf("only managed resources can be imported")
+	}
+	return nil
+}
+
+// ResolvedImports returns the IDs of the resources imported by the module, by
+// their addresses within the module, with deployment variables evaluated
+func (m Module) ResolvedImports(bp Blueprint) (map[string]string, error) {
+	ev, err := m.Imports.Eval(bp)
+	if err != nil {
+		return nil, fmt.Errorf("module %s imports: %w", m.ID, err)
+	}
+	res := map[string]string{}
+	for addr, v := range ev.Items() {
+		if v.Type() != cty.String || v.IsNull() || v.AsString() == "" {
+			return nil, fmt.Errorf("module %s imports %s with an ID that is not a string", m.ID, addr)
+		}
+		res[addr] = v.AsString()
+	}
+	return res, nil
+}
@@ -0,0 +1,331 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+
+	"hpc-toolkit/pkg/sourcereader"
+)
+
+var tfRootSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "variable", LabelNames: []string{"name"}},
+		{Type: "module", LabelNames: []string{"name"}},
+		{Type: "output", LabelNames: []string{"name"}},
+		{Type: "resource", LabelNames: []string{"type", "name"}},
+		{Type: "data", LabelNames: []string{"type", "name"}},
+		{Type: "locals"},
+		{Type: "provider", LabelNames: []string{"name"}},
+		{Type: "terraform"},
+	},
+}
+
+// arguments of module blocks that are not inputs of the module
+var tfModuleMetaArguments = []string{"source", "version", "count", "for_each", "providers", "depends_on"}
+
+type importedModule struct {
+	ID       string      `yaml:"id"`
+	Source   string      `yaml:"source"`
+	Depends  []string    `yaml:"depends,omitempty"`
+	Settings interface{} `yaml:"settings,omitempty"`
+	Outputs  []string    `yaml:"outputs,omitempty"`
+}
+
+type importedGroup struct {
+	Group   string           `yaml:"group"`
+	Modules []importedModule `yaml:"modules"`
+}
+
+type importedBlueprint struct {
+	BlueprintName    string          `yaml:"blueprint_name"`
+	Vars             interface{}     `yaml:"vars"`
+	DeploymentGroups []importedGroup `yaml:"deployment_groups"`
+}
+
+// BlueprintFromTerraform generates a starting blueprint from the Terraform
+// root module in dir. The modules it calls become the modules of a single
+// deployment group, keeping their sources, and its variables become
+// deployment variables. Module arguments that refer to variables and to the
+// outputs of other modules become blueprint variables and other expressions
+// HCL literals. Resources, data sources and locals of the root module have no
+// blueprint equivalent; they are reported, along with everything else that
+// needs attention, in the returned warnings.
+func BlueprintFromTerraform(dir string) ([]byte, []string, error) {
+	parser := hclparse.NewParser()
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("%s holds no Terraform files", dir)
+	}
+
+	warnings := []string{}
+	vars := map[string]cty.Value{}
+	modules := map[string]*importedModule{}
+	// outputs of the root module, by the module whose output they expose
+	outputs := map[string][]string{}
+	for _, name := range files {
+		f, diags := parser.ParseHCLFile(name)
+		if diags.HasErrors() {
+			return nil, nil, diags
+		}
+		content, diags := f.Body.Content(tfRootSchema)
+		if diags.HasErrors() {
+			return nil, nil, diags
+		}
+		for _, b := range content.Blocks {
+			switch b.Type {
+			case "variable":
+				v, w := importTfVariable(b)
+				vars[b.Labels[0]] = v
+				warnings = append(warnings, w...)
+			case "module":
+				m, w, err := importTfModule(b, f.Bytes, dir)
+				if err != nil {
+					return nil, nil, err
+				}
+				modules[m.ID] = m
+				warnings = append(warnings, w...)
+			case "output":
+				mod, out, ok := tfModuleOutput(b)
+				if !ok {
+					warnings = append(warnings, fmt.Sprintf("output %s is not the output of a module and is not converted", b.Labels[0]))
+					continue
+				}
+				outputs[mod] = append(outputs[mod], out)
+				if out != b.Labels[0] {
+					warnings = append(warnings, fmt.Sprintf("output %s is exposed as output %s of module %s", b.Labels[0], out, mod))
+				}
+			case "resource", "data":
+				warnings = append(warnings, fmt.Sprintf("%s %s.%s is not converted; move it to a module", b.Type, b.Labels[0], b.Labels[1]))
+			case "locals":
+				warnings = append(warnings, fmt.Sprintf("locals at %s are not converted; module settings that refer to them must be rewritten", b.DefRange))
+			}
+		}
+	}
+
+	for mod, outs := range outputs {
+		m, ok := modules[mod]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("outputs %v refer to module %s, which is not called", outs, mod))
+			continue
+		}
+		sort.Strings(outs)
+		m.Outputs = outs
+	}
+
+	if _, ok := vars["deployment_name"]; !ok {
+		vars["deployment_name"] = cty.StringVal(filepath.Base(filepath.Clean(dir)))
+	}
+	varsYaml, err := NewDict(vars).MarshalYAML()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	group := importedGroup{Group: "primary"}
+	ids := maps.Keys(modules)
+	sort.Strings(ids)
+	for _, id := range ids {
+		group.Modules = append(group.Modules, *modules[id])
+	}
+	bp := importedBlueprint{
+		BlueprintName:    filepath.Base(filepath.Clean(dir)),
+		Vars:             varsYaml,
+		DeploymentGroups: []importedGroup{group},
+	}
+
+	b, err := yaml.Marshal(bp)
+	if err != nil {
+		return nil, nil, configErrorf("yamlMarshalError", ": %w", err)
+	}
+	// formatting also checks that the blueprint can be read
+	b, err = FormatBlueprint(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, warnings, nil
+}
+
+// importTfVariable returns the default value of a variable
+func importTfVariable(b *hcl.Block) (cty.Value, []string) {
+	name := b.Labels[0]
+	attrs, _ := b.Body.JustAttributes()
+	def, ok := attrs["default"]
+	if !ok {
+		return cty.NullVal(cty.DynamicPseudoType), []string{fmt.Sprintf("variable %s has no default; set deployment variable %s", name, name)}
+	}
+	v, diags := def.Expr.Value(nil)
+	if diags.HasErrors() {
+		return cty.NullVal(cty.DynamicPseudoType), []string{fmt.Sprintf("the default of variable %s is not a constant; set deployment variable %s", name, name)}
+	}
+	return escapeStrings(v), nil
+}
+
+func importTfModule(b *hcl.Block, src []byte, dir string) (*importedModule, []string, error) {
+	id := b.Labels[0]
+	warnings := []string{}
+	attrs, diags := b.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, nil, fmt.Errorf("module %s: %w", id, diags)
+	}
+
+	m := &importedModule{ID: id}
+	if a, ok := attrs["source"]; ok {
+		v, diags := a.Expr.Value(nil)
+		if diags.HasErrors() || v.Type() != cty.String {
+			return nil, nil, fmt.Errorf("module %s: source must be a string", id)
+		}
+		m.Source = v.AsString()
+	}
+	switch {
+	case sourcereader.IsLocalPath(m.Source) && !filepath.IsAbs(m.Source):
+		// local sources are relative to the root module, those of blueprints
+		// to the working directory
+		source, err := sourcereader.LocalSource(filepath.Join(dir, m.Source))
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Source = source
+	case !sourcereader.IsLocalPath(m.Source) && !sourcereader.IsGitPath(m.Source):
+		warnings = append(warnings, fmt.Sprintf("module %s has source %s, which ghpc cannot read; replace it with a git or local source", id, m.Source))
+	}
+	if _, ok := attrs["version"]; ok {
+		warnings = append(warnings, fmt.Sprintf("module %s has a version, which git sources select with ?ref=", id))
+	}
+	for _, meta := range []string{"count", "for_each", "providers"} {
+		if _, ok := attrs[meta]; ok {
+			warnings = append(warnings, fmt.Sprintf("module %s sets %s, which blueprints do not support", id, meta))
+		}
+	}
+	if a, ok := attrs["depends_on"]; ok {
+		for _, t := range a.Expr.Variables() {
+			if a, ok := t[len(t)-1].(hcl.TraverseAttr); ok && t.RootName() == "module" && len(t) == 2 {
+				m.Depends = append(m.Depends, a.Name)
+			} else {
+				warnings = append(warnings, fmt.Sprintf("module %s depends on %s, which is not a module", id, tfTraversalString(t)))
+			}
+		}
+	}
+
+	settings := map[string]cty.Value{}
+	for name, a := range attrs {
+		if slices.Contains(tfModuleMetaArguments, name) {
+			continue
+		}
+		v, w := importTfExpression(a.Expr, src)
+		settings[name] = v
+		if w != "" {
+			warnings = append(warnings, fmt.Sprintf("setting %s of module %s %s", name, id, w))
+		}
+	}
+	if len(settings) > 0 {
+		s, err := NewDict(settings).MarshalYAML()
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Settings = s
+	}
+	sort.Strings(warnings)
+	return m, warnings, nil
+}
+
+// importTfExpression converts a Terraform expression to a blueprint value:
+// constants are values, references to a variable or to the output of a module
+// are blueprint variables and other expressions HCL literals. A warning is
+// returned for expressions that refer to anything but variables and modules,
+// which are imported as strings.
+func importTfExpression(expr hcl.Expression, src []byte) (cty.Value, string) {
+	refs := expr.Variables()
+	if len(refs) == 0 {
+		if v, diags := expr.Value(nil); !diags.HasErrors() {
+			return escapeStrings(v), ""
+		}
+	}
+
+	if t, diags := hcl.AbsTraversalForExpr(expr); !diags.HasErrors() {
+		if r, err := TraversalToReference(t); err == nil {
+			if r.GlobalVar && len(t) == 2 {
+				return cty.StringVal(fmt.Sprintf("$(vars.%s)", r.Name)), ""
+			}
+			if !r.GlobalVar && len(t) == 3 {
+				return cty.StringVal(fmt.Sprintf("$(%s.%s)", r.Module, r.Name)), ""
+			}
+		}
+	}
+
+	text := "((" + string(expr.Range().SliceBytes(src)) + "))"
+	for _, t := range refs {
+		// blueprints cannot parse such literals, so they are imported as
+		// strings holding the literal, to be rewritten
+		if _, err := TraversalToReference(t); err != nil {
+			return cty.StringVal(EscapeBlueprintString(text)),
+				fmt.Sprintf("refers to %s, which is not a variable nor a module output; it is imported as the string %q", tfTraversalString(t), text)
+		}
+	}
+	return cty.StringVal(text), ""
+}
+
+// tfModuleOutput returns the module and output of an output of the root
+// module whose value is the output of a module
+func tfModuleOutput(b *hcl.Block) (string, string, bool) {
+	attrs, _ := b.Body.JustAttributes()
+	a, ok := attrs["value"]
+	if !ok {
+		return "", "", false
+	}
+	t, diags := hcl.AbsTraversalForExpr(a.Expr)
+	if diags.HasErrors() || len(t) != 3 {
+		return "", "", false
+	}
+	r, err := TraversalToReference(t)
+	if err != nil || r.GlobalVar {
+		return "", "", false
+	}
+	return string(r.Module), r.Name, true
+}
+
+// escapeStrings escapes the strings of a constant so that blueprints read
+// them literally
+func escapeStrings(v cty.Value) cty.Value {
+	e, _ := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		if v.Type() == cty.String && v.IsKnown() && !v.IsNull() {
+			return cty.StringVal(EscapeBlueprintString(v.AsString())), nil
+		}
+		return v, nil
+	})
+	return e
+}
+
+func tfTraversalString(t hcl.Traversal) string {
+	parts := []string{t.RootName()}
+	for _, s := range t[1:] {
+		if a, ok := s.(hcl.TraverseAttr); ok {
+			parts = append(parts, a.Name)
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,180 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// userModule records the fields of a module written in the blueprint that
+// expansion may also fill in
+type userModule struct {
+	outputs      []string
+	transforms   []string
+	requiredApis bool
+}
+
+// recordUserFields remembers the deployment variables, module outputs,
+// transforms and required APIs of the blueprint as written by the user, so that those added
+// by expansion can be elided from the minimized blueprint
+func (dc *DeploymentConfig) recordUserFields() {
+	dc.userVars = dc.Config.Vars.Items()
+	dc.userModules = map[ModuleID]userModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		um := userModule{outputs: []string{}, transforms: maps.Keys(m.Transforms), requiredApis: m.RequiredApis != nil}
+		for _, o := range m.Outputs {
+			um.outputs = append(um.outputs, o.Name)
+		}
+		dc.userModules[m.ID] = um
+		return nil
+	})
+}
+
+// minimize removes from the YAML encoding of the expanded blueprint what the
+// toolkit adds to every blueprint and would add again if the result were
+// expanded: default validators, deployment variables, module settings and
+// transforms applied by the toolkit, inferred kinds, required APIs, outputs
+// wired between groups and backends copied from terraform_backend_defaults. What
+// remains is what the blueprint, or the use of modules, sets.
+func (dc DeploymentConfig) minimize(doc *yaml.Node) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+
+	validators := mappingValue(root, "validators")
+	kept := []*yaml.Node{}
+	for i, v := range dc.Config.Validators {
+		if i >= len(validators.Content) || v.reason != "" {
+			continue
+		}
+		item := validators.Content[i]
+		if !v.Skip {
+			deleteMappingKey(item, "skip")
+		}
+		kept = append(kept, item)
+	}
+	validators.Content = kept
+	if len(kept) == 0 {
+		deleteMappingKey(root, "validators")
+	}
+
+	if dc.userVars != nil {
+		vars := mappingValue(root, "vars")
+		for name := range dc.Config.Vars.Items() {
+			// variables chosen by expansion keep the decision
+			_, defaulted := dc.defaultedVars[name]
+			if _, ok := dc.userVars[name]; !ok && !defaulted {
+				deleteMappingKey(vars, name)
+			}
+		}
+		// labels added by the toolkit to those of the user
+		labels, userLabels := mappingValue(vars, "labels"), dc.userVars["labels"]
+		if labels.Kind == yaml.MappingNode && (userLabels.Type().IsObjectType() || userLabels.Type().IsMapType()) {
+			user := userLabels.AsValueMap()
+			for i := len(labels.Content) - 2; i >= 0; i -= 2 {
+				if _, ok := user[labels.Content[i].Value]; !ok {
+					labels.Content = append(labels.Content[:i], labels.Content[i+2:]...)
+				}
+			}
+		}
+	}
+
+	defaults := dc.Config.TerraformBackendDefaults
+	if defaults.Type == "" {
+		deleteMappingKey(root, "terraform_backend_defaults")
+	}
+
+	groups := mappingValue(root, "deployment_groups").Content
+	for ig, g := range dc.Config.DeploymentGroups {
+		if ig >= len(groups) {
+			break
+		}
+		gn := groups[ig]
+		deleteMappingKey(gn, "kind")
+		be := g.TerraformBackend
+		if be.Type == "" || (be.Type == defaults.Type && be.Configuration.AsObject().RawEquals(defaults.Configuration.AsObject())) {
+			deleteMappingKey(gn, "terraform_backend")
+		}
+		modules := mappingValue(gn, "modules").Content
+		for im, m := range g.Modules {
+			if im >= len(modules) {
+				break
+			}
+			dc.minimizeModule(modules[im], m, g)
+		}
+	}
+}
+
+func (dc DeploymentConfig) minimizeModule(n *yaml.Node, m Module, g DeploymentGroup) {
+	deleteMappingKey(n, "kind")
+	if len(m.Use) == 0 {
+		deleteMappingKey(n, "use")
+	}
+
+	settings := mappingValue(n, "settings")
+	elided := []string{}
+	for name, p := range dc.settingProvenance(m, g) {
+		if !strings.HasPrefix(p, "user setting") && !strings.HasPrefix(p, "use of") {
+			deleteMappingKey(settings, name)
+			elided = append(elided, name)
+		}
+	}
+	if settings.Kind == yaml.MappingNode && len(settings.Content) == 0 {
+		deleteMappingKey(n, "settings")
+	}
+
+	user, recorded := dc.userModules[m.ID]
+	if !recorded {
+		return
+	}
+	if !user.requiredApis {
+		deleteMappingKey(n, "required_apis")
+	}
+
+	outputs := mappingValue(n, "outputs")
+	kept := []*yaml.Node{}
+	for i, o := range m.Outputs {
+		if i < len(outputs.Content) && slices.Contains(user.outputs, o.Name) {
+			kept = append(kept, outputs.Content[i])
+		}
+	}
+	outputs.Content = kept
+	if len(kept) == 0 {
+		deleteMappingKey(n, "outputs")
+	}
+
+	// transforms of elided settings are applied again with them
+	transforms := mappingValue(n, "transforms")
+	for name := range m.Transforms {
+		if slices.Contains(elided, name) && !slices.Contains(user.transforms, name) {
+			deleteMappingKey(transforms, name)
+		}
+	}
+	if transforms.Kind == yaml.MappingNode && len(transforms.Content) == 0 {
+		deleteMappingKey(n, "transforms")
+	}
+}
+
+// deleteMappingKey removes key and its value from a mapping node
+func deleteMappingKey(n *yaml.Node, key string) {
+	if i := mappingKeyIndex(n, key); i != -1 {
+		n.Content = append(n.Content[:i], n.Content[i+2:]...)
+	}
+}
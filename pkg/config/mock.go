@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MockInterface declares the inputs and outputs of a mock module. Mock
+// modules stand in for Terraform modules, so that the use-wiring and
+// expressions of blueprints can be validated without their sources.
+type MockInterface struct {
+	Inputs  []MockInput               `yaml:"inputs,omitempty"`
+	Outputs []modulereader.OutputInfo `yaml:"outputs,omitempty"`
+}
+
+// MockInput is an input of a mock module; inputs of no type accept any value
+type MockInput struct {
+	Name        string      `yaml:"name"`
+	Type        string      `yaml:"type,omitempty"`
+	Description string      `yaml:"description,omitempty"`
+	Default     interface{} `yaml:"default,omitempty"`
+	Required    bool        `yaml:"required,omitempty"`
+}
+
+// UnmarshalYAML supports declaring inputs by their name alone, as outputs
+func (in *MockInput) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&in.Name)
+	}
+	type plain MockInput
+	return n.Decode((*plain)(in))
+}
+
+// info returns the module info of the declared inputs and outputs
+func (mi MockInterface) info() modulereader.ModuleInfo {
+	inputs := make([]modulereader.VarInfo, len(mi.Inputs))
+	for i, in := range mi.Inputs {
+		inputs[i] = modulereader.VarInfo{
+			Name: in.Name, Type: in.Type, Description: in.Description,
+			Default: in.Default, Required: in.Required,
+		}
+		if in.Type == "" {
+			inputs[i].Type = "any"
+		}
+	}
+	return modulereader.ModuleInfo{Inputs: inputs, Outputs: mi.Outputs}
+}
+
+// groupKind returns the kind of the deployment groups of modules of kind mk;
+// mock modules are grouped as the Terraform modules they stand in for
+func (mk ModuleKind) groupKind() ModuleKind {
+	if mk == MockKind {
+		return TerraformKind
+	}
+	return mk
+}
+
+// MockModules returns the IDs of the mock modules of the blueprint
+func (bp Blueprint) MockModules() []ModuleID {
+	ids := []ModuleID{}
+	bp.WalkModules(func(m *Module) error {
+		if m.Kind == MockKind {
+			ids = append(ids, m.ID)
+		}
+		return nil
+	})
+	return ids
+}
+
+// registerMockModules makes the declared inputs and outputs of mock modules
+// the module info of their sources, so that they are never read. Mock modules
+// of the same source must declare the same inputs and outputs.
+func (bp Blueprint) registerMockModules() error {
+	declared := map[string]ModuleID{}
+	return bp.WalkModules(func(m *Module) error {
+		if m.Kind != MockKind {
+			if m.Mock != nil {
+				return fmt.Errorf("module %s: mock inputs and outputs are only supported by modules of kind mock", m.ID)
+			}
+			return nil
+		}
+		if m.SourceHash != "" {
+			return fmt.Errorf("module %s: mock modules are not fetched, so they cannot pin a source_hash", m.ID)
+		}
+		mi := MockInterface{}
+		if m.Mock != nil {
+			mi = *m.Mock
+		}
+		info := mi.info()
+		if other, ok := declared[m.Source]; ok {
+			if prev, _ := modulereader.GetModuleInfo(m.Source, MockKind.String()); !reflect.DeepEqual(prev, info) {
+				return fmt.Errorf("mock modules %s and %s have source %s but declare different inputs or outputs", other, m.ID, m.Source)
+			}
+			return nil
+		}
+		declared[m.Source] = m.ID
+		modulereader.SetModuleInfo(m.Source, MockKind.String(), info)
+		return nil
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Deployment variables whose values are maps group related variables under a
+// namespace, e.g. vars.network.cidr. Expressions may use a whole namespace or
+// any path into it, and the leaves of namespaces are validated separately.
+
+// globalVarPaths returns the paths into deployment variables that the
+// expression uses, e.g. ["network", "cidr"] for var.network.cidr. Paths end
+// at the first component that is not an attribute or a string key.
+func globalVarPaths(e Expression) [][]string {
+	hexp, diags := hclsyntax.ParseExpression(e.Tokenize().Bytes(), "", hcl.Pos{})
+	if diags.HasErrors() {
+		return nil
+	}
+	paths := [][]string{}
+	for _, t := range hexp.Variables() {
+		if t.RootName() != "var" {
+			continue
+		}
+		path := []string{}
+	loop:
+		for _, step := range t[1:] {
+			switch s := step.(type) {
+			case hcl.TraverseAttr:
+				path = append(path, s.Name)
+			case hcl.TraverseIndex:
+				if !s.Key.Type().Equals(cty.String) || !s.Key.IsKnown() {
+					break loop
+				}
+				path = append(path, s.Key.AsString())
+			default:
+				break loop
+			}
+		}
+		if len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// isNamespace returns true if the value groups variables by name
+func isNamespace(v cty.Value) bool {
+	if _, is := IsExpressionValue(v); is || !v.IsKnown() || v.IsNull() {
+		return false
+	}
+	ty := v.Type()
+	return ty.IsObjectType() || ty.IsMapType()
+}
+
+// checkGlobalVarPath confirms that the path into a deployment variable
+// exists, as far as the variable is a namespace
+func checkGlobalVarPath(bp Blueprint, mod Module, path []string) error {
+	v := bp.Vars.Get(path[0])
+	for i, name := range path[1:] {
+		if !isNamespace(v) {
+			return nil
+		}
+		if !hasKey(v, name) {
+			return fmt.Errorf("module %#v references unknown global variable %#v: %s has no %#v",
+				mod.ID, strings.Join(path, "."), strings.Join(path[:i+1], "."), name)
+		}
+		v = getKey(v, name)
+	}
+	return nil
+}
+
+func hasKey(namespace cty.Value, name string) bool {
+	if namespace.Type().IsObjectType() {
+		return namespace.Type().HasAttribute(name)
+	}
+	return namespace.HasIndex(cty.StringVal(name)).True()
+}
+
+func getKey(namespace cty.Value, name string) cty.Value {
+	if namespace.Type().IsObjectType() {
+		return namespace.GetAttr(name)
+	}
+	return namespace.Index(cty.StringVal(name))
+}
+
+// varUsage records which parts of a deployment variable are used; a used
+// node uses the whole value below it
+type varUsage struct {
+	used     bool
+	children map[string]*varUsage
+}
+
+func (u *varUsage) add(path []string) {
+	for _, name := range path {
+		if u.used {
+			return
+		}
+		if u.children == nil {
+			u.children = map[string]*varUsage{}
+		}
+		if u.children[name] == nil {
+			u.children[name] = &varUsage{}
+		}
+		u = u.children[name]
+	}
+	u.used = true
+}
+
+// unused returns the dotted paths of the leaves of the value, or of whole
+// namespaces, that are not used
+func (u *varUsage) unused(prefix string, v cty.Value) []string {
+	if u.used {
+		return nil
+	}
+	if !isNamespace(v) {
+		// a path into a value that is not a namespace, e.g. a list, uses it
+		return nil
+	}
+	names := []string{}
+	for it := v.ElementIterator(); it.Next(); {
+		k, _ := it.Element()
+		names = append(names, k.AsString())
+	}
+	slices.Sort(names)
+	res := []string{}
+	for _, name := range names {
+		path := prefix + "." + name
+		child, ok := u.children[name]
+		if !ok {
+			res = append(res, path)
+			continue
+		}
+		res = append(res, child.unused(path, getKey(v, name))...)
+	}
+	return res
+}
+
+// unusedGlobalVars returns the deployment variables, and the leaves of
+// namespaces, that are not in the used paths
+func unusedGlobalVars(vars Dict, used [][]string) []string {
+	root := &varUsage{}
+	for _, p := range used {
+		root.add(p)
+	}
+	names := maps.Keys(vars.Items())
+	slices.Sort(names)
+	res := []string{}
+	for _, name := range names {
+		u, ok := root.children[name]
+		if !ok {
+			res = append(res, name)
+			continue
+		}
+		res = append(res, u.unused(name, vars.Get(name))...)
+	}
+	return res
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"golang.org/x/exp/slices"
+)
+
+// types of notification
+const (
+	PubSubNotification = "pubsub"
+	SlackNotification  = "slack"
+	HTTPNotification   = "http"
+)
+
+// events of the deployment of a group
+const (
+	DeployStarted   = "start"
+	DeploySucceeded = "success"
+	DeployFailed    = "failure"
+)
+
+var deployEvents = []string{DeployStarted, DeploySucceeded, DeployFailed}
+
+var pubsubTopicExp = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// Notification sends the events of the deployment of every group to a Pub/Sub
+// topic, a Slack incoming webhook or an HTTP endpoint. All events are sent
+// unless Events lists some of them.
+type Notification struct {
+	Type   string   `yaml:"type"`
+	Topic  string   `yaml:"topic,omitempty"`
+	URL    string   `yaml:"url,omitempty"`
+	Events []string `yaml:"events,flow,omitempty"`
+}
+
+// Notifies returns true if the event is sent by the notification
+func (n Notification) Notifies(event string) bool {
+	return len(n.Events) == 0 || slices.Contains(n.Events, event)
+}
+
+func (n Notification) check() error {
+	switch n.Type {
+	case PubSubNotification:
+		if !pubsubTopicExp.MatchString(n.Topic) {
+			return fmt.Errorf("pubsub notification requires a topic of the form projects/PROJECT/topics/TOPIC, got %q", n.Topic)
+		}
+	case SlackNotification, HTTPNotification:
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%s notification requires an http or https url, got %q", n.Type, n.URL)
+		}
+	default:
+		return fmt.Errorf("unknown notification type %q, must be one of %s, %s or %s",
+			n.Type, PubSubNotification, SlackNotification, HTTPNotification)
+	}
+	for _, e := range n.Events {
+		if !slices.Contains(deployEvents, e) {
+			return fmt.Errorf("unknown notification event %q, must be one of %v", e, deployEvents)
+		}
+	}
+	return nil
+}
+
+// checkNotifications verifies that every notification can be sent
+func checkNotifications(bp Blueprint) error {
+	for i, n := range bp.Notifications {
+		if err := n.check(); err != nil {
+			return fmt.Errorf("notification %d: %w", i, err)
+		}
+	}
+	return nil
+}
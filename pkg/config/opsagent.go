@@ -0,0 +1,130 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// opsAgentSetting is the variable of startup-script modules that installs the
+// Ops Agent on the VMs that run the script
+const opsAgentSetting = "install_cloud_ops_agent"
+
+// scopes that allow the Ops Agent to write logs and metrics
+var (
+	loggingScopes    = []string{"https://www.googleapis.com/auth/cloud-platform", "https://www.googleapis.com/auth/logging.write"}
+	monitoringScopes = []string{"https://www.googleapis.com/auth/cloud-platform", "https://www.googleapis.com/auth/monitoring.write", "https://www.googleapis.com/auth/monitoring"}
+)
+
+// installsOpsAgent returns true if any startup-script module installs the Ops
+// Agent
+func (bp Blueprint) installsOpsAgent() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		found = found || bp.isOpsAgentScript(*m)
+		return nil
+	})
+	return found
+}
+
+// isOpsAgentScript returns true if the module is a startup-script that
+// installs the Ops Agent
+func (bp Blueprint) isOpsAgentScript(m Module) bool {
+	if !sourceIs(m.Source, startupScriptModule) || !m.Settings.Has(opsAgentSetting) {
+		return false
+	}
+	v, ok := evalIfKnown(m.Settings.Get(opsAgentSetting), bp)
+	return ok && v.Type() == cty.Bool && !v.IsNull() && v.True()
+}
+
+// opsAgentModule describes a module whose VMs run a startup-script that
+// installs the Ops Agent; ok is false if the module runs no such script or its
+// project cannot be determined before deployment
+func (bp Blueprint) opsAgentModule(m Module) (validators.OpsAgentModule, bool) {
+	scripts := []string{}
+	cty.Walk(m.Settings.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
+		e, is := IsExpressionValue(v)
+		if !is {
+			return true, nil
+		}
+		for _, r := range e.References() {
+			if r.GlobalVar || slices.Contains(scripts, string(r.Module)) {
+				continue
+			}
+			if s, err := bp.Module(r.Module); err == nil && bp.isOpsAgentScript(*s) {
+				scripts = append(scripts, string(r.Module))
+			}
+		}
+		return true, nil
+	})
+	if len(scripts) == 0 {
+		return validators.OpsAgentModule{}, false
+	}
+	project, ok := bp.moduleProject(m)
+	if !ok {
+		return validators.OpsAgentModule{}, false
+	}
+	slices.Sort(scripts)
+	om := validators.OpsAgentModule{Module: string(m.ID), ProjectID: project, Scripts: scripts}
+
+	// the default service accounts of modules can write logs and metrics
+	scopes, ok := bp.serviceAccountScopes(m)
+	if !ok {
+		return om, true
+	}
+	if !containsAny(scopes, loggingScopes) {
+		om.MissingScopes = append(om.MissingScopes, loggingScopes[1])
+	}
+	if !containsAny(scopes, monitoringScopes) {
+		om.MissingScopes = append(om.MissingScopes, monitoringScopes[1])
+	}
+	return om, true
+}
+
+// serviceAccountScopes returns the scopes of the service_account setting of a
+// module, if they are known before deployment
+func (bp Blueprint) serviceAccountScopes(m Module) ([]string, bool) {
+	if !m.Settings.Has("service_account") {
+		return nil, false
+	}
+	sa, ok := evalIfKnown(m.Settings.Get("service_account"), bp)
+	if !ok || sa.IsNull() || !sa.IsWhollyKnown() || !(sa.Type().IsObjectType() || sa.Type().IsMapType()) {
+		return nil, false
+	}
+	v, ok := sa.AsValueMap()["scopes"]
+	if !ok || v.IsNull() || !v.CanIterateElements() {
+		return nil, false
+	}
+	scopes := []string{}
+	for it := v.ElementIterator(); it.Next(); {
+		_, el := it.Element()
+		if isNonEmptyString(el) {
+			scopes = append(scopes, el.AsString())
+		}
+	}
+	return scopes, true
+}
+
+func containsAny(s []string, values []string) bool {
+	for _, v := range values {
+		if slices.Contains(s, v) {
+			return true
+		}
+	}
+	return false
+}
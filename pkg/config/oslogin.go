@@ -0,0 +1,124 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/validators"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// metadata keys of instance-level SSH keys
+var sshKeysMetadata = []string{"ssh-keys", "sshKeys"}
+
+const (
+	osLoginMetadata = "enable-oslogin"
+	// osLoginSetting is the variable of compute modules that sets
+	// enable-oslogin to "ENABLE", "DISABLE" or "INHERIT" from the project
+	osLoginSetting = "enable_oslogin"
+)
+
+// setsSSHKeys returns true if any module sets instance-level SSH keys
+func (bp Blueprint) setsSSHKeys() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, ok := bp.sshKeysModule(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
+
+// sshKeysModule describes the OS Login configuration of a module that sets
+// instance-level SSH keys in its metadata; ok is false if the module does not
+// set SSH keys or its project cannot be determined before deployment
+func (bp Blueprint) sshKeysModule(m Module) (validators.SSHKeysModule, bool) {
+	if !m.Settings.Has("metadata") {
+		return validators.SSHKeysModule{}, false
+	}
+	md, ok := evalIfKnown(m.Settings.Get("metadata"), bp)
+	if !ok || md.IsNull() || !md.IsWhollyKnown() || !(md.Type().IsObjectType() || md.Type().IsMapType()) {
+		return validators.SSHKeysModule{}, false
+	}
+	items := md.AsValueMap()
+	hasKeys := false
+	for _, k := range sshKeysMetadata {
+		v, ok := items[k]
+		hasKeys = hasKeys || (ok && !v.IsNull())
+	}
+	if !hasKeys {
+		return validators.SSHKeysModule{}, false
+	}
+
+	project, ok := bp.moduleProject(m)
+	if !ok {
+		return validators.SSHKeysModule{}, false
+	}
+	sm := validators.SSHKeysModule{Module: string(m.ID), ProjectID: project}
+
+	// metadata set by the user takes precedence over enable_oslogin
+	if v, ok := items[osLoginMetadata]; ok && isNonEmptyString(v) {
+		enabled := strings.EqualFold(v.AsString(), "true")
+		sm.OSLogin = &enabled
+		return sm, true
+	}
+	var mode cty.Value
+	if m.Settings.Has(osLoginSetting) {
+		mode, _ = evalIfKnown(m.Settings.Get(osLoginSetting), bp)
+	} else if def, ok := osLoginDefault(m); ok {
+		mode = cty.StringVal(def)
+	}
+	if isNonEmptyString(mode) && mode.AsString() != "INHERIT" {
+		enabled := mode.AsString() == "ENABLE"
+		sm.OSLogin = &enabled
+	}
+	return sm, true
+}
+
+// osLoginDefault returns the default of the enable_oslogin variable of the
+// module, if it has one
+func osLoginDefault(m Module) (string, bool) {
+	info, err := modulereader.GetModuleInfo(m.Source, m.Kind.String())
+	if err != nil {
+		return "", false
+	}
+	for _, in := range info.Inputs {
+		if in.Name == osLoginSetting {
+			def, ok := in.Default.(string)
+			return def, ok
+		}
+	}
+	return "", false
+}
+
+// moduleProject returns the project of the VMs of a module: its project_id
+// setting, or that of its deployment group or of the deployment
+func (bp Blueprint) moduleProject(m Module) (string, bool) {
+	v := GlobalRef("project_id").AsExpression().AsValue()
+	if m.Settings.Has("project_id") {
+		v = m.Settings.Get("project_id")
+	} else if g, err := bp.ModuleGroup(m.ID); err == nil && g.ProjectID != "" {
+		return g.ProjectID, true
+	} else if !bp.Vars.Has("project_id") {
+		return "", false
+	}
+	v, ok := evalIfKnown(v, bp)
+	if !ok || !isNonEmptyString(v) {
+		return "", false
+	}
+	return v.AsString(), true
+}
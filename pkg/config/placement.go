@@ -0,0 +1,193 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	vpcModule           = "network/vpc"
+	vmInstanceModule    = "compute/vm-instance"
+	slurmPartitionV5    = "compute/schedmd-slurm-gcp-v5-partition"
+	slurmNodeGroupV5    = "compute/schedmd-slurm-gcp-v5-node-group"
+	defaultVPCMTU       = 8896
+	minVPCMTU           = 1300
+	maxVPCMTU           = 8896
+	maxSlurmPlacementVM = 150
+)
+
+// machine families that support compact placement policies
+var compactPlacementFamilies = []string{"a2", "a3", "c2", "c2d", "c3", "c3d", "g2", "h3", "n2", "n2d"}
+
+// usesPlacementOrMTU returns true if any module places VMs with a placement
+// policy, runs Slurm partitions, which use placement groups by default, or
+// creates a VPC
+func (bp Blueprint) usesPlacementOrMTU() bool {
+	found := len(bp.modulesWithSource(slurmPartitionV5)) > 0 || len(bp.modulesWithSource(vpcModule)) > 0
+	bp.WalkModules(func(m *Module) error {
+		found = found || (sourceIs(m.Source, vmInstanceModule) && m.Settings.Has("placement_policy"))
+		return nil
+	})
+	return found
+}
+
+// placementProblems describes the compact placement and MTU settings of a
+// module that Compute Engine would reject, and warns of settings that leave
+// HPC jobs with a slower network than they expect. Settings that depend upon
+// module outputs are not checked.
+func (bp Blueprint) placementProblems(m Module) (problems []string, warnings []string) {
+	switch {
+	case sourceIs(m.Source, vmInstanceModule):
+		return bp.vmPlacementProblems(m)
+	case sourceIs(m.Source, slurmPartitionV5):
+		return bp.partitionPlacementProblems(m)
+	case sourceIs(m.Source, vpcModule):
+		return bp.mtuProblems(m)
+	}
+	return nil, nil
+}
+
+// compactPlacementProblem returns why VMs of a machine type cannot be placed
+// with a compact placement policy
+func compactPlacementProblem(machineType string) (string, bool) {
+	family := strings.SplitN(machineType, "-", 2)[0]
+	if slices.Contains(compactPlacementFamilies, family) {
+		return "", false
+	}
+	return fmt.Sprintf("machine type %s does not support compact placement; use one of the %s families",
+		machineType, strings.Join(compactPlacementFamilies, ", ")), true
+}
+
+func (bp Blueprint) vmPlacementProblems(m Module) (problems []string, warnings []string) {
+	if !m.Settings.Has("placement_policy") {
+		return nil, nil
+	}
+	p, ok := evalIfKnown(m.Settings.Get("placement_policy"), bp)
+	if !ok || p.IsNull() || !p.IsWhollyKnown() || !(p.Type().IsObjectType() || p.Type().IsMapType()) {
+		return nil, nil
+	}
+	policy := p.AsValueMap()
+	if c := policy["collocation"]; !isNonEmptyString(c) || c.AsString() != "COLLOCATED" {
+		return nil, nil
+	}
+
+	if mt, ok := bp.moduleMachineType(m); ok {
+		if p, bad := compactPlacementProblem(mt); bad {
+			problems = append(problems, p)
+		}
+	}
+	count, ok := bp.evalInt(m, "instance_count", 1)
+	if vc := policy["vm_count"]; ok && vc != cty.NilVal && !vc.IsNull() && vc.Type() == cty.Number {
+		if n, _ := vc.AsBigFloat().Int64(); n != count {
+			problems = append(problems, fmt.Sprintf(
+				"placement_policy.vm_count is %d but instance_count is %d; the VMs cannot be created until exactly vm_count VMs join the policy, set vm_count to null or to instance_count", n, count))
+		}
+	}
+	for _, id := range referencedModules(m) {
+		if vpc, err := bp.Module(id); err == nil && sourceIs(vpc.Source, vpcModule) {
+			warnings = append(warnings, bp.smallMTUWarnings(*vpc, "VMs with compact placement")...)
+		}
+	}
+	return problems, warnings
+}
+
+func (bp Blueprint) partitionPlacementProblems(m Module) (problems []string, warnings []string) {
+	enabled, ok := cty.True, true
+	if m.Settings.Has("enable_placement") {
+		enabled, ok = evalIfKnown(m.Settings.Get("enable_placement"), bp)
+	}
+	if !ok || enabled.IsNull() || enabled.Type() != cty.Bool || enabled.False() {
+		return nil, nil
+	}
+
+	vpcs := []ModuleID{}
+	for _, id := range referencedModules(m) {
+		used, err := bp.Module(id)
+		if err != nil {
+			continue
+		}
+		if sourceIs(used.Source, vpcModule) && !slices.Contains(vpcs, id) {
+			vpcs = append(vpcs, id)
+		}
+		if !sourceIs(used.Source, slurmNodeGroupV5) {
+			continue
+		}
+		for _, id := range referencedModules(*used) {
+			if vpc, err := bp.Module(id); err == nil && sourceIs(vpc.Source, vpcModule) && !slices.Contains(vpcs, id) {
+				vpcs = append(vpcs, id)
+			}
+		}
+
+		if mt, ok := bp.moduleMachineType(*used); ok {
+			if p, bad := compactPlacementProblem(mt); bad {
+				problems = append(problems, fmt.Sprintf("node group %s: %s, or set enable_placement to false", used.ID, p))
+			}
+		}
+		ohm, hok := cty.StringVal("TERMINATE"), true
+		if used.Settings.Has("on_host_maintenance") {
+			ohm, hok = evalIfKnown(used.Settings.Get("on_host_maintenance"), bp)
+		}
+		if hok && isNonEmptyString(ohm) && ohm.AsString() == "MIGRATE" {
+			warnings = append(warnings, fmt.Sprintf(
+				"node group %s sets on_host_maintenance to MIGRATE, which turns off enable_placement for its nodes", used.ID))
+		}
+		static, sok := bp.evalInt(*used, "node_count_static", 0)
+		dynamic, dok := bp.evalInt(*used, "node_count_dynamic_max", 10)
+		if sok && dok && static+dynamic > maxSlurmPlacementVM {
+			warnings = append(warnings, fmt.Sprintf(
+				"node group %s has up to %d nodes, but Slurm places at most %d nodes in a placement group; larger jobs span several groups",
+				used.ID, static+dynamic, maxSlurmPlacementVM))
+		}
+	}
+	for _, id := range vpcs {
+		vpc, _ := bp.Module(id)
+		warnings = append(warnings, bp.smallMTUWarnings(*vpc, "Slurm partitions with placement groups")...)
+	}
+	return problems, warnings
+}
+
+// vpcMTU returns the MTU of the network created by a VPC module; 0 selects
+// the default of Compute Engine
+func (bp Blueprint) vpcMTU(m Module) (int64, bool) {
+	return bp.evalInt(m, "mtu", defaultVPCMTU)
+}
+
+func (bp Blueprint) mtuProblems(m Module) (problems []string, warnings []string) {
+	mtu, ok := bp.vpcMTU(m)
+	if ok && mtu != 0 && (mtu < minVPCMTU || mtu > maxVPCMTU) {
+		problems = append(problems, fmt.Sprintf("mtu is %d, but VPC networks accept 0 or an MTU from %d to %d", mtu, minVPCMTU, maxVPCMTU))
+	}
+	return problems, nil
+}
+
+// smallMTUWarnings warns if a VPC does not use jumbo frames, which the MPI
+// and Slurm jobs of tightly coupled VMs expect
+func (bp Blueprint) smallMTUWarnings(vpc Module, users string) []string {
+	mtu, ok := bp.vpcMTU(vpc)
+	if !ok || mtu == maxVPCMTU || (mtu != 0 && (mtu < minVPCMTU || mtu > maxVPCMTU)) {
+		return nil
+	}
+	shown := fmt.Sprint(mtu)
+	if mtu == 0 {
+		shown = "the Compute Engine default of 1460"
+	}
+	return []string{fmt.Sprintf(
+		"network %s has an MTU of %s; %s perform best with an MTU of %d", vpc.ID, shown, users, maxVPCMTU)}
+}
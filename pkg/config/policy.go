@@ -0,0 +1,139 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ModulePolicy restricts the modules that a blueprint may use. A module is
+// permitted if it matches an exempt rule, or if it matches no deny rule and
+// either there are no allow rules or it matches one of them.
+type ModulePolicy struct {
+	Allow  []ModulePolicyRule `yaml:"allow,omitempty"`
+	Deny   []ModulePolicyRule `yaml:"deny,omitempty"`
+	Exempt []ModulePolicyRule `yaml:"exempt,omitempty"`
+}
+
+// ModulePolicyRule matches modules by source and kind. Source is a glob in
+// which "*" and "?" do not match "/" and "**" matches any sequence of
+// characters. Empty fields match all modules.
+type ModulePolicyRule struct {
+	Source string     `yaml:"source,omitempty"`
+	Kind   ModuleKind `yaml:"kind,omitempty"`
+}
+
+// LoadModulePolicy reads a module policy from a YAML file
+func LoadModulePolicy(filename string) (ModulePolicy, error) {
+	var p ModulePolicy
+	reader, err := os.Open(filename)
+	if err != nil {
+		return p, configErrorf("fileLoadError", ", filename=%s: %v", filename, err)
+	}
+	defer reader.Close()
+
+	decoder := yaml.NewDecoder(reader)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil {
+		return p, fmt.Errorf("failed to parse the module policy in %s: %w", filename, err)
+	}
+	return p, nil
+}
+
+func (r ModulePolicyRule) matches(m Module) bool {
+	if r.Kind != UnknownKind && r.Kind != m.Kind {
+		return false
+	}
+	return r.Source == "" || globToRegexp(r.Source).MatchString(strings.TrimPrefix(m.Source, "./"))
+}
+
+func matchesAny(rules []ModulePolicyRule, m Module) bool {
+	for _, r := range rules {
+		if r.matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p ModulePolicy) permits(m Module) bool {
+	if matchesAny(p.Exempt, m) {
+		return true
+	}
+	if matchesAny(p.Deny, m) {
+		return false
+	}
+	return len(p.Allow) == 0 || matchesAny(p.Allow, m)
+}
+
+// globToRegexp converts a source glob into an anchored regular expression
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			sb.WriteString("[^/]*")
+		case glob[i] == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// EnforceModulePolicy adds a module policy that every module must satisfy in
+// addition to the module policy of the blueprint, such as one set by an
+// administrator. The exemptions of the blueprint policy do not relax it.
+func (dc *DeploymentConfig) EnforceModulePolicy(p ModulePolicy) {
+	dc.enforcedPolicies = append(dc.enforcedPolicies, p)
+}
+
+// checkModulePolicy returns an error naming every module that is not
+// permitted by the module policy of the blueprint or by any of the enforced
+// policies
+func (bp Blueprint) checkModulePolicy(enforced []ModulePolicy) error {
+	policies := append([]ModulePolicy{}, enforced...)
+	if bp.ModulePolicy != nil {
+		policies = append(policies, *bp.ModulePolicy)
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	denied := []string{}
+	bp.WalkModules(func(m *Module) error {
+		for _, p := range policies {
+			if !p.permits(*m) {
+				denied = append(denied, fmt.Sprintf("%s (%s source %s)", m.ID, m.Kind, m.Source))
+				break
+			}
+		}
+		return nil
+	})
+	if len(denied) > 0 {
+		return configErrorf("modulePolicy", ": %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// recordUserSettings remembers the module settings of the blueprint as
+// written by the user, so that the provenance of expanded settings can be told
+func (dc *DeploymentConfig) recordUserSettings() {
+	dc.userSettings = map[ModuleID]map[string]cty.Value{}
+	dc.Config.WalkModules(func(m *Module) error {
+		dc.userSettings[m.ID] = m.Settings.Items()
+		return nil
+	})
+}
+
+// settingProvenance describes where each setting of an expanded module came
+// from: the blueprint, a used module, a deployment variable or the toolkit
+func (dc DeploymentConfig) settingProvenance(m Module, g DeploymentGroup) map[string]string {
+	user := dc.userSettings[m.ID]
+	prov := map[string]string{}
+	for name, v := range m.Settings.Items() {
+		used := usedModules(v)
+		uv, isUser := user[name]
+		switch {
+		case isUser && uv.RawEquals(v):
+			prov[name] = "user setting"
+		case isUser && len(used) > 0:
+			prov[name] = "user setting, extended by use of " + strings.Join(used, ", ")
+		case isUser:
+			prov[name] = "user setting, modified by the toolkit"
+		case len(used) > 0:
+			prov[name] = "use of " + strings.Join(used, ", ")
+		case name == "project_id" && g.ProjectID != "" && v.RawEquals(cty.StringVal(g.ProjectID)):
+			prov[name] = fmt.Sprintf("project_id of group %s", g.Name)
+		case v.RawEquals(GlobalRef(name).AsExpression().AsValue()):
+			prov[name] = "deployment variable " + name
+		default:
+			prov[name] = "set by the toolkit"
+		}
+	}
+	return prov
+}
+
+// usedModules returns the sorted IDs of the modules whose use produced parts
+// of the value
+func usedModules(v cty.Value) []string {
+	ids := map[string]bool{}
+	cty.Walk(v, func(p cty.Path, v cty.Value) (bool, error) {
+		if mark, has := HasMark[ProductOfModuleUse](v); has {
+			ids[string(mark.Module)] = true
+		}
+		return true, nil
+	})
+	sorted := maps.Keys(ids)
+	slices.Sort(sorted)
+	return sorted
+}
+
+// annotateProvenance adds a "provenance:" line comment to every module setting
+// in the YAML encoding of the expanded blueprint
+func (dc DeploymentConfig) annotateProvenance(doc *yaml.Node) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	groups := mappingValue(root, "deployment_groups").Content
+	for ig, g := range dc.Config.DeploymentGroups {
+		if ig >= len(groups) {
+			break
+		}
+		modules := mappingValue(groups[ig], "modules").Content
+		for im, m := range g.Modules {
+			if im >= len(modules) {
+				break
+			}
+			settings := mappingValue(modules[im], "settings")
+			for name, p := range dc.settingProvenance(m, g) {
+				i := mappingKeyIndex(settings, name)
+				if i == -1 {
+					continue
+				}
+				// line comments are only written after scalar or flow values;
+				// those of block collections belong to the key
+				node := settings.Content[i+1]
+				if node.Kind != yaml.ScalarNode && node.Style&yaml.FlowStyle == 0 {
+					node = settings.Content[i]
+				}
+				comment := "provenance: " + p
+				if node.LineComment != "" {
+					comment = node.LineComment + "; " + comment
+				}
+				node.LineComment = comment
+			}
+		}
+	}
+}
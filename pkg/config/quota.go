@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"hpc-toolkit/pkg/quota"
+)
+
+// quotaRule describes the VMs created by a module: the default of its
+// machine_type setting and the settings, with their defaults, that add up to
+// the number of VMs. A module without count settings creates one VM.
+type quotaRule struct {
+	machineType string
+	counts      map[string]int64
+}
+
+// quotaRules are keyed by module path
+var quotaRules = map[string]quotaRule{
+	"compute/vm-instance": {
+		machineType: "c2-standard-60",
+		counts:      map[string]int64{"instance_count": 1},
+	},
+	"compute/schedmd-slurm-gcp-v5-node-group": {
+		machineType: "c2-standard-60",
+		counts:      map[string]int64{"node_count_static": 0, "node_count_dynamic_max": 10},
+	},
+	"scheduler/schedmd-slurm-gcp-v5-controller": {
+		machineType: "c2-standard-4",
+	},
+	"scheduler/schedmd-slurm-gcp-v5-login": {
+		machineType: "n2-standard-2",
+		counts:      map[string]int64{"num_instances": 1},
+	},
+}
+
+func quotaRuleFor(m Module) (quotaRule, bool) {
+	for path, r := range quotaRules {
+		if sourceIs(m.Source, path) {
+			return r, true
+		}
+	}
+	return quotaRule{}, false
+}
+
+// moduleMachineType returns the machine type of the VMs of a module, which
+// defaults to that of its quota rule
+func (bp Blueprint) moduleMachineType(m Module) (string, bool) {
+	r, ok := quotaRuleFor(m)
+	if !m.Settings.Has("machine_type") {
+		return r.machineType, ok
+	}
+	v, ok := evalIfKnown(m.Settings.Get("machine_type"), bp)
+	if !ok || !isNonEmptyString(v) {
+		return "", false
+	}
+	return v.AsString(), true
+}
+
+// quotaDemand returns the VMs that a module creates; ok is false if the
+// module creates no known VMs or its project, zone, machine type or count
+// cannot be determined before deployment. The zone is the zone setting of the
+// module or the zone deployment variable.
+func (bp Blueprint) quotaDemand(m Module) (quota.Demand, bool) {
+	r, ok := quotaRuleFor(m)
+	if !ok {
+		return quota.Demand{}, false
+	}
+	project, ok := bp.moduleProject(m)
+	if !ok {
+		return quota.Demand{}, false
+	}
+
+	zone := GlobalRef("zone").AsExpression().AsValue()
+	if m.Settings.Has("zone") {
+		zone = m.Settings.Get("zone")
+	} else if !bp.Vars.Has("zone") {
+		return quota.Demand{}, false
+	}
+	zone, ok = evalIfKnown(zone, bp)
+	if !ok || !isNonEmptyString(zone) {
+		return quota.Demand{}, false
+	}
+
+	machineType, ok := bp.moduleMachineType(m)
+	if !ok {
+		return quota.Demand{}, false
+	}
+
+	count := int64(1)
+	if len(r.counts) > 0 {
+		count = 0
+	}
+	for s, def := range r.counts {
+		n, ok := bp.evalInt(m, s, def)
+		if !ok || n < 0 {
+			return quota.Demand{}, false
+		}
+		count += n
+	}
+	if count == 0 {
+		return quota.Demand{}, false
+	}
+
+	return quota.Demand{
+		Module: string(m.ID), ProjectID: project, Zone: zone.AsString(),
+		MachineType: machineType, Count: count}, true
+}
+
+// QuotaDemands returns the VMs created by the modules of the blueprint that
+// are known before deployment
+func (dc DeploymentConfig) QuotaDemands() []quota.Demand {
+	demands := []quota.Demand{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if d, ok := dc.Config.quotaDemand(*m); ok {
+			demands = append(demands, d)
+		}
+		return nil
+	})
+	return demands
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultRetryableErrors match the errors of Compute Engine that are usually
+// transient: stockouts of a zone, exceeded quotas and rate limits
+var DefaultRetryableErrors = []string{
+	"ZONE_RESOURCE_POOL_EXHAUSTED",
+	"does not have enough resources available to fulfill the request",
+	"Quota '[A-Z_0-9]+' exceeded",
+	"rateLimitExceeded",
+}
+
+const defaultRetryDelay = time.Minute
+
+// RetryPolicy is the policy of ghpc deploy for the failures of a group that
+// are likely transient. The group is deployed again, as a whole, after each
+// failure whose error matches one of RetryableErrors.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the group is deployed before its
+	// failure is reported, including the first
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// RetryableErrors are the regular expressions of the errors that are
+	// retried; DefaultRetryableErrors if unset
+	RetryableErrors []string `yaml:"retryable_errors,omitempty"`
+	// Delay is the time waited between attempts, one minute if unset
+	Delay time.Duration `yaml:"delay,omitempty"`
+}
+
+// Attempts returns the number of times that the group is deployed at most
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// RetryDelay returns the time to wait before the next attempt
+func (p RetryPolicy) RetryDelay() time.Duration {
+	if p.Delay <= 0 {
+		return defaultRetryDelay
+	}
+	return p.Delay
+}
+
+// Retryable returns true if err matches one of the retryable errors
+func (p RetryPolicy) Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	exps := p.RetryableErrors
+	if len(exps) == 0 {
+		exps = DefaultRetryableErrors
+	}
+	for _, e := range exps {
+		if re, cerr := regexp.Compile(e); cerr == nil && re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRetryPolicies errors if the retry policy of a group is invalid or set
+// for a group that is not deployed by Terraform
+func checkRetryPolicies(bp Blueprint) error {
+	for _, g := range bp.DeploymentGroups {
+		p := g.Retry
+		if p.MaxAttempts == 0 && len(p.RetryableErrors) == 0 && p.Delay == 0 {
+			continue
+		}
+		if g.Kind != TerraformKind {
+			return fmt.Errorf("group %s: retry is only supported by groups of Terraform modules", g.Name)
+		}
+		if p.MaxAttempts < 0 {
+			return fmt.Errorf("group %s: retry max_attempts must not be negative, got %d", g.Name, p.MaxAttempts)
+		}
+		if p.Delay < 0 {
+			return fmt.Errorf("group %s: retry delay must not be negative, got %s", g.Name, p.Delay)
+		}
+		for _, e := range p.RetryableErrors {
+			if _, err := regexp.Compile(e); err != nil {
+				return fmt.Errorf("group %s: retryable error %q is not a regular expression: %w", g.Name, e, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// savedVarsSuffix ends the name of the vars file of a blueprint file
+const savedVarsSuffix = ".vars.yaml"
+
+// MissingVarError is the failure of expansion because a deployment variable
+// that the blueprint requires is not set
+type MissingVarError struct {
+	Var string
+	err error
+}
+
+func (e *MissingVarError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error that reported the missing variable
+func (e *MissingVarError) Unwrap() error {
+	return e.err
+}
+
+// MissingVar returns the deployment variable whose absence failed the
+// expansion of a blueprint with err, if that is why it failed
+func MissingVar(err error) (string, bool) {
+	var mv *MissingVarError
+	if errors.As(err, &mv) {
+		return mv.Var, true
+	}
+	return "", false
+}
+
+// savedVarsFile is the vars file in which ghpc create saves the answers to
+// its prompts for deployment variables
+type savedVarsFile struct {
+	Vars Dict `yaml:"vars"`
+}
+
+// SavedVarsPath returns the vars file of a blueprint file, next to it:
+// hpc-slurm.vars.yaml for hpc-slurm.yaml
+func SavedVarsPath(blueprintFile string) string {
+	stem := strings.TrimSuffix(blueprintFile, filepath.Ext(blueprintFile))
+	return stem + savedVarsSuffix
+}
+
+// ReadSavedVars reads the deployment variables of a vars file; there are none
+// if it does not exist
+func ReadSavedVars(path string) (Dict, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Dict{}, nil
+	}
+	if err != nil {
+		return Dict{}, err
+	}
+	var f savedVarsFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return Dict{}, fmt.Errorf("failed to read the deployment variables of %s: %w", path, err)
+	}
+	return f.Vars, nil
+}
+
+// SaveVars adds the deployment variables to the vars file, replacing those of
+// the same name that it holds
+func SaveVars(path string, vars Dict) error {
+	saved, err := ReadSavedVars(path)
+	if err != nil {
+		return err
+	}
+	for name, v := range vars.Items() {
+		saved.Set(name, v)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Deployment variables saved by ghpc create, which loads those that the\n")
+	buf.WriteString("# blueprint and --vars leave unset; it may hold secrets, do not commit it.\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(savedVarsFile{Vars: saved}); err != nil {
+		return configErrorf("yamlMarshalError", ": %w", err)
+	}
+	encoder.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return configErrorf("fileSaveError", ", Filename: %s: %w", path, err)
+	}
+	return nil
+}
+
+// SetSavedVars sets the deployment variables of the vars file that are not
+// set yet and returns their names, in order
+func (bp *Blueprint) SetSavedVars(saved Dict) []string {
+	set := []string{}
+	for name, v := range saved.Items() {
+		if bp.Vars.Has(name) {
+			continue
+		}
+		bp.Vars.Set(name, v)
+		set = append(set, name)
+	}
+	slices.Sort(set)
+	return set
+}
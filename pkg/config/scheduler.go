@@ -0,0 +1,252 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"hpc-toolkit/pkg/modulereader"
+	"path"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// schedulerLink connects the modules of a scheduler that are commonly used
+// together. Each consumer module either uses the producer module or, for
+// settings that cannot be matched by name, has them set from its outputs.
+type schedulerLink struct {
+	producer  string
+	consumers []string
+	// runner, if set, is the output of the producer that a startup-script
+	// module used by the consumer must run, telling the role of its VMs; this
+	// singles out consumers of generic sources such as compute/vm-instance
+	runner string
+	// settings of the consumer derived from the producer, which are skipped
+	// when a function returns false; when empty the producer is added to the
+	// use list of the consumer
+	settings map[string]schedulerSetting
+}
+
+// schedulerSetting derives a setting of a consumer module from a producer
+type schedulerSetting func(bp Blueprint, producer ModuleID, consumer Module) (cty.Value, bool)
+
+// htcondorServiceAccount sets the service_account of the VMs of an HTCondor
+// role to the service account created for it by htcondor-configure
+func htcondorServiceAccount(output string) schedulerSetting {
+	return func(_ Blueprint, p ModuleID, _ Module) (cty.Value, bool) {
+		return cty.ObjectVal(map[string]cty.Value{
+			"email": ModuleRef(p, output).AsExpression().AsValue(),
+			"scopes": cty.TupleVal([]cty.Value{
+				cty.StringVal("https://www.googleapis.com/auth/cloud-platform")}),
+		}), true
+	}
+}
+
+// htcondorCentralManagerAddress gives the central manager VM the internal IP
+// address reserved for it by htcondor-configure, which the other HTCondor
+// roles are configured to reach, on the subnetwork of the network module it
+// uses
+func htcondorCentralManagerAddress(bp Blueprint, p ModuleID, consumer Module) (cty.Value, bool) {
+	for _, id := range consumer.Use {
+		m, err := bp.Module(id)
+		if err != nil || !slices.ContainsFunc(m.InfoOrDie().Outputs, func(o modulereader.OutputInfo) bool {
+			return o.Name == "subnetwork_self_link"
+		}) {
+			continue
+		}
+		null := cty.NullVal(cty.String)
+		return cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+			"network":            null,
+			"subnetwork":         ModuleRef(id, "subnetwork_self_link").AsExpression().AsValue(),
+			"subnetwork_project": GlobalRef("project_id").AsExpression().AsValue(),
+			"network_ip":         ModuleRef(p, "central_manager_internal_ip").AsExpression().AsValue(),
+			"nic_type":           null,
+			"stack_type":         null,
+			"queue_count":        cty.NullVal(cty.Number),
+			"access_config":      cty.EmptyTupleVal,
+			"ipv6_access_config": cty.EmptyTupleVal,
+			"alias_ip_range":     cty.EmptyTupleVal,
+		})}), true
+	}
+	return cty.NilVal, false
+}
+
+// schedulerLinks describe how the modules of the HTCondor and PBS Pro
+// schedulers are linked
+var schedulerLinks = []schedulerLink{
+	// PBS Pro
+	{
+		producer: "scripts/pbspro-preinstall",
+		consumers: []string{
+			"scheduler/pbspro-server",
+			"scheduler/pbspro-client",
+			"compute/pbspro-execution",
+		},
+	},
+	{
+		producer: "scheduler/pbspro-server",
+		consumers: []string{
+			"scheduler/pbspro-client",
+			"compute/pbspro-execution",
+		},
+	},
+	// HTCondor
+	{
+		producer:  "scheduler/htcondor-configure",
+		consumers: []string{"compute/htcondor-execute-point"},
+		settings: map[string]schedulerSetting{
+			"service_account": htcondorServiceAccount("execute_point_service_account"),
+		},
+	},
+	{
+		producer:  "scheduler/htcondor-configure",
+		consumers: []string{"compute/vm-instance"},
+		runner:    "central_manager_runner",
+		settings: map[string]schedulerSetting{
+			"service_account":    htcondorServiceAccount("central_manager_service_account"),
+			"network_interfaces": htcondorCentralManagerAddress,
+		},
+	},
+	{
+		producer:  "scheduler/htcondor-configure",
+		consumers: []string{"compute/vm-instance"},
+		runner:    "access_point_runner",
+		settings: map[string]schedulerSetting{
+			"service_account": htcondorServiceAccount("access_point_service_account"),
+		},
+	},
+}
+
+// sourceIs returns true if the module source refers to the module at the
+// given path relative to the modules or community/modules directories
+func sourceIs(source string, modPath string) bool {
+	if i := strings.Index(source, "?"); i != -1 {
+		source = source[:i]
+	}
+	source = strings.TrimSuffix(path.Clean(source), "/")
+	return source == modPath || strings.HasSuffix(source, "/"+modPath)
+}
+
+// modulesWithSource returns the IDs of all modules whose source refers to the
+// module at modPath
+func (bp Blueprint) modulesWithSource(modPath string) []ModuleID {
+	ids := []ModuleID{}
+	bp.WalkModules(func(m *Module) error {
+		if sourceIs(m.Source, modPath) {
+			ids = append(ids, m.ID)
+		}
+		return nil
+	})
+	return ids
+}
+
+// applySchedulerLinks links the modules of HTCondor and PBS Pro
+// deployments. Links are only made when the blueprint contains exactly one
+// producer module that the consumer is allowed to reference, and never
+// override settings or use lists supplied in the blueprint.
+func (dc *DeploymentConfig) applySchedulerLinks() error {
+	bp := &dc.Config
+	for _, link := range schedulerLinks {
+		producers := bp.modulesWithSource(link.producer)
+		if len(producers) != 1 {
+			continue
+		}
+		producer, err := bp.Module(producers[0])
+		if err != nil {
+			return err
+		}
+		for _, consumerPath := range link.consumers {
+			for _, id := range bp.modulesWithSource(consumerPath) {
+				consumer, err := bp.Module(id)
+				if err != nil {
+					return err
+				}
+				if validateModuleReference(*bp, *consumer, producer.ID) != nil {
+					continue
+				}
+				if link.runner != "" && !bp.runsOutput(*consumer, producer.ID, link.runner) {
+					continue
+				}
+				linkSchedulerModules(*bp, consumer, *producer, link)
+			}
+		}
+	}
+	return nil
+}
+
+// runsOutput returns true if a startup-script module that the consumer uses,
+// or refers to in its settings, runs the output of the producer
+func (bp Blueprint) runsOutput(consumer Module, producer ModuleID, output string) bool {
+	scripts := append([]ModuleID{}, consumer.Use...)
+	for _, r := range valueReferences(consumer.Settings.AsObject()) {
+		scripts = append(scripts, r.Module)
+	}
+	for _, id := range scripts {
+		m, err := bp.Module(id)
+		if err != nil || !sourceIs(m.Source, startupScriptModule) {
+			continue
+		}
+		if slices.Contains(valueReferences(m.Settings.AsObject()), ModuleRef(producer, output)) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueReferences returns the references to module outputs in a value
+func valueReferences(v cty.Value) []Reference {
+	refs := []Reference{}
+	cty.Walk(v, func(_ cty.Path, v cty.Value) (bool, error) {
+		if e, is := IsExpressionValue(v); is {
+			for _, r := range e.References() {
+				if !r.GlobalVar {
+					refs = append(refs, r)
+				}
+			}
+		}
+		return true, nil
+	})
+	return refs
+}
+
+func linkSchedulerModules(bp Blueprint, consumer *Module, producer Module, link schedulerLink) {
+	if len(link.settings) > 0 {
+		// settings are set in sorted order, so that expansion is deterministic
+		names := maps.Keys(link.settings)
+		slices.Sort(names)
+		for _, setting := range names {
+			if consumer.Settings.Has(setting) {
+				continue
+			}
+			if v, ok := link.settings[setting](bp, producer.ID, *consumer); ok {
+				consumer.Settings.Set(setting, v)
+			}
+		}
+		return
+	}
+
+	if slices.Contains(consumer.Use, producer.ID) {
+		return
+	}
+	// only use the producer if it supplies a setting that is not already set
+	// so that test_module_not_used is not triggered
+	for _, output := range producer.InfoOrDie().Outputs {
+		if moduleHasInput(*consumer, output.Name) && !consumer.Settings.Has(output.Name) {
+			consumer.Use = append(consumer.Use, producer.ID)
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"hpc-toolkit/pkg/modulereader"
+	"os"
+	"path/filepath"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// redactedSensitive replaces the values of sensitive deployment variables
+// that are not encrypted with sops when the blueprint is exported
+const redactedSensitive = "<sensitive>"
+
+// SensitiveTfvarsFilename names the file of each Terraform deployment group
+// that holds the values of its sensitive deployment variables, which
+// Terraform loads automatically
+const SensitiveTfvarsFilename = "sensitive.auto.tfvars"
+
+// SensitiveVars returns the sorted names of the deployment variables that are
+// encrypted with sops or used by sensitive inputs of Terraform modules. They
+// are written to the sensitive variable files of deployment groups and
+// redacted from exported blueprints.
+func (bp Blueprint) SensitiveVars() []string {
+	names := []string{}
+	for _, s := range bp.Secrets {
+		names = append(names, s.Vars...)
+	}
+	for _, name := range bp.moduleSensitiveVars() {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// moduleSensitiveVars returns the deployment variables used by the settings
+// of sensitive Terraform module inputs; modules that cannot be read are
+// skipped
+func (bp Blueprint) moduleSensitiveVars() []string {
+	names := []string{}
+	bp.WalkModules(func(m *Module) error {
+		if m.Kind != TerraformKind {
+			return nil
+		}
+		info, err := modulereader.GetModuleInfo(m.Source, m.Kind.String())
+		if err != nil {
+			return nil
+		}
+		for _, in := range info.Inputs {
+			if !in.Sensitive || !m.Settings.Has(in.Name) {
+				continue
+			}
+			for _, name := range GetUsedDeploymentVars(m.Settings.Get(in.Name)) {
+				if bp.Vars.Has(name) && !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+		return nil
+	})
+	return names
+}
+
+// redactSensitiveVars returns a copy of the deployment variables in which
+// sensitive ones are replaced by a placeholder. Unlike those encrypted with
+// sops, their values cannot be restored when the blueprint is read.
+func (bp Blueprint) redactSensitiveVars() Dict {
+	vars := bp.Vars.Items()
+	for _, name := range bp.moduleSensitiveVars() {
+		vars[name] = cty.StringVal(redactedSensitive)
+	}
+	return NewDict(vars)
+}
+
+// RestoreSensitiveVars sets the deployment variables that the expanded
+// blueprint of a deployment redacts to their values in the sensitive variable
+// files of its Terraform groups, so that commands reading the expanded
+// blueprint, e.g. to evaluate Packer settings, use the real values. Variables
+// that no Terraform group holds keep the placeholder.
+func (bp *Blueprint) RestoreSensitiveVars(deploymentRoot string) error {
+	redacted := []string{}
+	for name, v := range bp.Vars.Items() {
+		if v.RawEquals(cty.StringVal(redactedSensitive)) {
+			redacted = append(redacted, name)
+		}
+	}
+	if len(redacted) == 0 {
+		return nil
+	}
+	for _, g := range bp.DeploymentGroups {
+		if g.Kind != TerraformKind {
+			continue
+		}
+		file := filepath.Join(deploymentRoot, string(g.Name), SensitiveTfvarsFilename)
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		values, err := modulereader.ReadHclAttributes(file)
+		if err != nil {
+			return err
+		}
+		for _, name := range redacted {
+			if v, ok := values[name]; ok {
+				bp.Vars.Set(name, v)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	slurmControllerV5 = "scheduler/schedmd-slurm-gcp-v5-controller"
+	slurmHybridV5     = "scheduler/schedmd-slurm-gcp-v5-hybrid"
+	// slurmCleanupSetting makes a Slurm controller delete its compute nodes
+	// and placement groups when it is destroyed
+	slurmCleanupSetting = "enable_cleanup_compute"
+)
+
+// slurmClusterNameRe matches the characters that Slurm modules remove from
+// the deployment name to make the default cluster name
+var slurmClusterNameRe = regexp.MustCompile(`^[^a-z]*|[^a-z0-9]`)
+
+// SlurmCluster is a Slurm cluster whose controller is a module of a
+// deployment. The controller creates compute nodes, and placement groups for
+// them, on demand; Terraform does not know about them.
+type SlurmCluster struct {
+	Module    ModuleID
+	Name      string
+	ProjectID string
+	// Region is where the controller creates placement groups; it is empty if
+	// the region depends upon module outputs
+	Region string
+	// DeploymentLabel is the ghpc_deployment label of the compute nodes
+	DeploymentLabel string
+	// DeploymentID is the ghpc_deployment_id label of the compute nodes, empty
+	// for deployments written before ghpc create set it
+	DeploymentID string
+	// Truncated is true if the name is the deployment name cut to 10
+	// characters, which other deployments may share
+	Truncated bool
+	// Hybrid is true if the controller runs outside of Google Cloud
+	Hybrid bool
+	// CleansUp is true if the module deletes the compute nodes and placement
+	// groups itself when it is destroyed
+	CleansUp bool
+}
+
+// SlurmClusters returns the Slurm clusters whose controllers are modules of
+// the group; clusters whose name or project depend upon module outputs are
+// skipped
+func (bp Blueprint) SlurmClusters(group GroupName) []SlurmCluster {
+	g, err := bp.Group(group)
+	if err != nil {
+		return nil
+	}
+	label, err := bp.DeploymentLabel()
+	if err != nil {
+		return nil
+	}
+	clusters := []SlurmCluster{}
+	for _, m := range g.Modules {
+		hybrid := sourceIs(m.Source, slurmHybridV5)
+		if !hybrid && !sourceIs(m.Source, slurmControllerV5) {
+			continue
+		}
+		name, truncated, ok := bp.slurmClusterName(m)
+		if !ok {
+			continue
+		}
+		project, ok := bp.moduleProject(m)
+		if !ok {
+			continue
+		}
+		region, _ := bp.moduleRegion(m)
+		c := SlurmCluster{Module: m.ID, Name: name, ProjectID: project, Region: region,
+			DeploymentLabel: label, DeploymentID: bp.DeploymentID(), Truncated: truncated, Hybrid: hybrid}
+		if m.Settings.Has(slurmCleanupSetting) {
+			v, ok := evalIfKnown(m.Settings.Get(slurmCleanupSetting), bp)
+			c.CleansUp = ok && v.Type() == cty.Bool && v.IsKnown() && !v.IsNull() && v.True()
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// slurmClusterName returns the slurm_cluster_name setting of a Slurm module,
+// or the name that the module derives from the deployment name, as in
+// substr(replace(lower(var.deployment_name), "/^[^a-z]*|[^a-z0-9]/", ""), 0, 10),
+// and whether the derived name was truncated
+func (bp Blueprint) slurmClusterName(m Module) (string, bool, bool) {
+	if m.Settings.Has("slurm_cluster_name") {
+		v, ok := evalIfKnown(m.Settings.Get("slurm_cluster_name"), bp)
+		if !ok || !v.IsNull() && !isNonEmptyString(v) {
+			return "", false, false
+		}
+		if !v.IsNull() {
+			return v.AsString(), false, true
+		}
+	}
+	v := GlobalRef("deployment_name").AsExpression().AsValue()
+	if m.Settings.Has("deployment_name") {
+		v = m.Settings.Get("deployment_name")
+	}
+	v, ok := evalIfKnown(v, bp)
+	if !ok || !isNonEmptyString(v) {
+		return "", false, false
+	}
+	name, truncated := defaultSlurmClusterName(v.AsString())
+	return name, truncated, true
+}
+
+func defaultSlurmClusterName(deploymentName string) (string, bool) {
+	// only ASCII letters and digits remain, so bytes are characters
+	name := slurmClusterNameRe.ReplaceAllString(strings.ToLower(deploymentName), "")
+	if len(name) > 10 {
+		return name[:10], true
+	}
+	return name, false
+}
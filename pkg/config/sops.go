@@ -0,0 +1,262 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// sopsMetadataKey is the top-level key under which sops records how a file
+// was encrypted
+const sopsMetadataKey = "sops"
+
+// redactedSecret replaces the values of encrypted deployment variables when
+// the blueprint is exported
+const redactedSecret = "<encrypted with sops>"
+
+var sopsEncryptedExp = regexp.MustCompile(`^ENC\[[A-Z0-9_]+,data:`)
+
+// decrypted caches the output of sops by file, so that KMS is only called once
+// per file and command
+var decrypted = struct {
+	sync.Mutex
+	m map[string][]byte
+}{m: map[string][]byte{}}
+
+// SecretsSource is a blueprint encrypted with sops whose deployment variables
+// named in Vars are decrypted whenever the blueprint, or its expansion, is read
+type SecretsSource struct {
+	File string   `yaml:"file"`
+	Vars []string `yaml:"vars,flow"`
+}
+
+// decryptBlueprint returns the content of a blueprint file, decrypted with
+// sops if it is encrypted, and the deployment variables that were encrypted.
+// doc is the parsed data, nil if it has syntax errors.
+func decryptBlueprint(filename string, data []byte, doc *yaml.Node) ([]byte, *SecretsSource, error) {
+	if doc == nil || !isSopsEncrypted(doc) {
+		// syntax errors are reported when the blueprint is decoded
+		return data, nil, nil
+	}
+	vars, err := encryptedVars(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := sopsDecrypt(abs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plain, &SecretsSource{File: abs, Vars: vars}, nil
+}
+
+// IsSopsEncrypted returns true if the YAML data is a blueprint encrypted with
+// sops, i.e. has sops metadata
+func IsSopsEncrypted(data []byte) bool {
+	var doc yaml.Node
+	return bytes.Contains(data, []byte(sopsMetadataKey)) && yaml.Unmarshal(data, &doc) == nil && isSopsEncrypted(&doc)
+}
+
+// isSopsEncrypted returns true if the document has sops metadata
+func isSopsEncrypted(doc *yaml.Node) bool {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return false
+	}
+	return mappingValue(root, sopsMetadataKey).Kind == yaml.MappingNode
+}
+
+// encryptedVars returns the sorted names of the deployment variables whose
+// values are, or contain, encrypted values. Only deployment variables may be
+// encrypted, so that the rest of the blueprint can be exported.
+func encryptedVars(doc *yaml.Node) ([]string, error) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	vars := []string{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		switch key {
+		case sopsMetadataKey:
+			continue
+		case "vars":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				if hasEncryptedValue(value.Content[j+1]) {
+					vars = append(vars, value.Content[j].Value)
+				}
+			}
+		default:
+			if hasEncryptedValue(value) {
+				return nil, fmt.Errorf(
+					"%s is encrypted, but only deployment variables may be encrypted with sops; encrypt them with --encrypted-regex '^vars$'", key)
+			}
+		}
+	}
+	slices.Sort(vars)
+	return vars, nil
+}
+
+func hasEncryptedValue(n *yaml.Node) bool {
+	if n.Kind == yaml.ScalarNode {
+		return sopsEncryptedExp.MatchString(n.Value)
+	}
+	for _, c := range n.Content {
+		if hasEncryptedValue(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// sopsDecrypt runs sops to decrypt a YAML file, which calls Cloud KMS with
+// the credentials of the user
+func sopsDecrypt(filename string) ([]byte, error) {
+	decrypted.Lock()
+	plain, ok := decrypted.m[filename]
+	decrypted.Unlock()
+	if ok {
+		return plain, nil
+	}
+	if _, err := exec.LookPath("sops"); err != nil {
+		return nil, fmt.Errorf("%s is encrypted with sops, which must be installed in PATH to decrypt it: %w", filename, err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("sops", "--decrypt", "--input-type", "yaml", "--output-type", "yaml", filename)
+	cmd.Stderr = &stderr
+	plain, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s with sops: %w\n%s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+	decrypted.Lock()
+	decrypted.m[filename] = plain
+	decrypted.Unlock()
+	return plain, nil
+}
+
+// SecretsRecordFilename names the file in which ghpc records the secrets of a
+// blueprint exported to blueprintFilename, e.g. blueprint.secrets.yaml for
+// blueprint.yaml
+func SecretsRecordFilename(blueprintFilename string) string {
+	ext := filepath.Ext(blueprintFilename)
+	return strings.TrimSuffix(blueprintFilename, ext) + ".secrets" + ext
+}
+
+// secretsRecord is the content of the file named by SecretsRecordFilename
+type secretsRecord struct {
+	Secrets []SecretsSource `yaml:"secrets"`
+}
+
+// writeSecretsRecord records the secrets of a blueprint exported to
+// blueprintFilename, or removes the record of a previous export if it has
+// none
+func (bp Blueprint) writeSecretsRecord(blueprintFilename string) error {
+	filename := SecretsRecordFilename(blueprintFilename)
+	if len(bp.Secrets) == 0 {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	d, err := yaml.Marshal(secretsRecord{Secrets: bp.Secrets})
+	if err != nil {
+		return configErrorf("yamlMarshalError", ": %w", err)
+	}
+	header := "# Written by ghpc: the blueprints whose deployment variables are decrypted\n" +
+		"# when " + filepath.Base(blueprintFilename) + " is read.\n"
+	if err := os.WriteFile(filename, append([]byte(header), d...), 0644); err != nil {
+		return configErrorf("fileSaveError", ", Filename: %s: %w", filename, err)
+	}
+	return nil
+}
+
+// readSecretsRecord returns the secrets recorded for the blueprint in
+// blueprintFilename, if any
+func readSecretsRecord(blueprintFilename string) ([]SecretsSource, error) {
+	filename := SecretsRecordFilename(blueprintFilename)
+	d, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, configErrorf("fileLoadError", ", filename=%s: %v", filename, err)
+	}
+	var r secretsRecord
+	if err := yaml.Unmarshal(d, &r); err != nil {
+		return nil, configErrorf("yamlUnmarshalError", "", filename, err)
+	}
+	return r.Secrets, nil
+}
+
+// restoreSecrets sets the encrypted deployment variables of an expanded
+// blueprint by decrypting the blueprints they came from. Variables whose
+// source cannot be decrypted keep their redacted value.
+func (bp *Blueprint) restoreSecrets(filename string) {
+	abs, _ := filepath.Abs(filename)
+	for _, s := range bp.Secrets {
+		if s.File == abs {
+			continue
+		}
+		plain, err := sopsDecrypt(s.File)
+		if err != nil {
+			log.Printf("warning: deployment variables %s are not available: %v", strings.Join(s.Vars, ", "), err)
+			continue
+		}
+		var src struct {
+			Vars Dict `yaml:"vars"`
+		}
+		if err := yaml.Unmarshal(plain, &src); err != nil {
+			log.Printf("warning: deployment variables %s are not available: failed to read %s: %v", strings.Join(s.Vars, ", "), s.File, err)
+			continue
+		}
+		for _, name := range s.Vars {
+			if src.Vars.Has(name) {
+				bp.Vars.Set(name, src.Vars.Get(name))
+			}
+		}
+	}
+}
+
+// redactSecrets returns a copy of the deployment variables in which encrypted
+// ones are replaced by a placeholder
+func (bp Blueprint) redactSecrets() Dict {
+	vars := bp.Vars.Items()
+	for _, s := range bp.Secrets {
+		for _, name := range s.Vars {
+			if _, ok := vars[name]; ok {
+				vars[name] = cty.StringVal(redactedSecret)
+			}
+		}
+	}
+	return NewDict(vars)
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// spotRule describes how a module requests Spot or preemptible VMs
+type spotRule struct {
+	// settings that make the VMs of the module preemptible when true
+	settings []string
+	// onHostMaintenance is the default of the on_host_maintenance setting; it
+	// is empty if the module chooses TERMINATE itself for Spot VMs
+	onHostMaintenance string
+	// service is true for modules whose VMs run the services of a cluster,
+	// e.g. controllers and login nodes, which should not be preempted
+	service bool
+}
+
+// spotRules describe the modules that can create Spot or preemptible VMs, by
+// module path
+var spotRules = map[string]spotRule{
+	"compute/vm-instance":                       {settings: []string{"spot"}},
+	"compute/pbspro-execution":                  {settings: []string{"spot"}},
+	"scheduler/pbspro-client":                   {settings: []string{"spot"}, service: true},
+	"scheduler/pbspro-server":                   {settings: []string{"spot"}, service: true},
+	"remote-desktop/chrome-remote-desktop":      {settings: []string{"spot"}, onHostMaintenance: "TERMINATE"},
+	"compute/schedmd-slurm-gcp-v5-node-group":   {settings: []string{"preemptible", "enable_spot_vm"}, onHostMaintenance: "TERMINATE"},
+	"scheduler/schedmd-slurm-gcp-v5-controller": {settings: []string{"preemptible"}, onHostMaintenance: "MIGRATE", service: true},
+	"scheduler/schedmd-slurm-gcp-v5-login":      {settings: []string{"preemptible"}, onHostMaintenance: "MIGRATE", service: true},
+}
+
+// machine families that cannot attach local SSDs
+var noLocalSSDFamilies = []string{"e2", "t2d", "t2a"}
+
+// numbers of local SSDs that can be attached to N1 VMs
+var n1LocalSSDCounts = []int64{1, 2, 3, 4, 5, 6, 7, 8, 16, 24}
+
+// spotRuleFor returns the Spot rule of the module, if it has one
+func spotRuleFor(m Module) (spotRule, bool) {
+	for path, rule := range spotRules {
+		if sourceIs(m.Source, path) {
+			return rule, true
+		}
+	}
+	return spotRule{}, false
+}
+
+// usesSpotModules returns true if any module can create Spot or preemptible
+// VMs
+func (bp Blueprint) usesSpotModules() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, ok := spotRuleFor(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
+
+// spotProblems describes the scheduling and local SSD settings of a module
+// that Compute Engine would reject, and warns of preemptible VMs that run the
+// services of a cluster. Settings that depend upon module outputs are not
+// checked.
+func (bp Blueprint) spotProblems(m Module) (problems []string, warnings []string) {
+	rule, ok := spotRuleFor(m)
+	if !ok {
+		return nil, nil
+	}
+	setting := func(name string, def cty.Value) (cty.Value, bool) {
+		if !m.Settings.Has(name) {
+			return def, true
+		}
+		return evalIfKnown(m.Settings.Get(name), bp)
+	}
+
+	preemptible := []string{}
+	for _, s := range rule.settings {
+		if v, ok := setting(s, cty.False); ok && v.Type() == cty.Bool && !v.IsNull() && v.True() {
+			preemptible = append(preemptible, s)
+		}
+	}
+	spotConfig, ok := setting("spot_instance_config", cty.NullVal(cty.DynamicPseudoType))
+	if ok && !spotConfig.IsNull() && !slices.Contains(preemptible, "enable_spot_vm") {
+		warnings = append(warnings, "spot_instance_config has no effect unless enable_spot_vm is true")
+	}
+
+	if len(preemptible) > 0 {
+		ohm, ok := stringSetting(setting, "on_host_maintenance")
+		if ok && ohm == "" {
+			ohm = rule.onHostMaintenance
+		}
+		if ok && ohm == "MIGRATE" {
+			problems = append(problems, fmt.Sprintf(
+				"VMs with %s set cannot be live migrated; Compute Engine requires on_host_maintenance to be TERMINATE",
+				strings.Join(preemptible, " and ")))
+		}
+		if rule.service {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s is set on VMs that run cluster services; they can be stopped at any time, interrupting every job and user of the cluster",
+				strings.Join(preemptible, " and ")))
+		}
+	}
+
+	return append(problems, localSSDProblems(setting)...), warnings
+}
+
+// localSSDProblems describes why the local SSDs requested by a module cannot
+// be attached to its machine type
+func localSSDProblems(setting settingFunc) []string {
+	count, ok := setting("local_ssd_count", cty.NumberIntVal(0))
+	if !ok || count.IsNull() || count.Type() != cty.Number {
+		return nil
+	}
+	n, _ := count.AsBigFloat().Int64()
+	machineType, ok := stringSetting(setting, "machine_type")
+	if n <= 0 || !ok || machineType == "" {
+		return nil
+	}
+
+	family := strings.SplitN(machineType, "-", 2)[0]
+	if slices.Contains(noLocalSSDFamilies, family) {
+		return []string{fmt.Sprintf(
+			"machine type %s cannot attach local SSDs; set local_ssd_count to 0 or choose another machine type", machineType)}
+	}
+	if family == "n1" && !slices.Contains(n1LocalSSDCounts, n) {
+		return []string{fmt.Sprintf(
+			"N1 VMs can attach 1 to 8, 16 or 24 local SSDs, not %d", n)}
+	}
+	return nil
+}
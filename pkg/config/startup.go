@@ -0,0 +1,170 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hpc-toolkit/pkg/sourcereader"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	// StartupScriptBucketVar is the deployment variable naming the Cloud
+	// Storage bucket, and optional folder, that hosts startup-script runners
+	StartupScriptBucketVar = "startup_script_bucket"
+	startupScriptModule    = "scripts/startup-script"
+)
+
+// StartupScript is the content of a startup-script runner that must be
+// uploaded to Cloud Storage before the deployment is applied
+type StartupScript struct {
+	Module  ModuleID
+	URI     string
+	Content []byte
+}
+
+// HostStartupScripts rewrites the runners of startup-script modules to
+// download their content from the bucket named by the startup_script_bucket
+// deployment variable and returns the scripts that must be uploaded there.
+// Only runners whose content, or absolute source path, is a literal string are
+// hosted; all others are left for the startup-script module to upload.
+func (dc *DeploymentConfig) HostStartupScripts() ([]StartupScript, error) {
+	bp := &dc.Config
+	if !bp.Vars.Has(StartupScriptBucketVar) {
+		return nil, nil
+	}
+	bucket := bp.Vars.Get(StartupScriptBucketVar)
+	if !isNonEmptyString(bucket) {
+		return nil, fmt.Errorf("deployment variable %s must be a non-empty string", StartupScriptBucketVar)
+	}
+	deployment, err := bp.DeploymentName()
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(bucket.AsString(), "/")
+	if !strings.HasPrefix(prefix, "gs://") {
+		prefix = "gs://" + prefix
+	}
+
+	scripts := []StartupScript{}
+	err = bp.WalkModules(func(m *Module) error {
+		if !sourceIs(m.Source, startupScriptModule) || !m.Settings.Has(startupRunnersSetting) {
+			return nil
+		}
+		runners := m.Settings.Get(startupRunnersSetting)
+		if _, is := IsExpressionValue(runners); is || runners.IsNull() || !runners.IsKnown() || !runners.CanIterateElements() {
+			return nil
+		}
+
+		folder := fmt.Sprintf("%s/%s/%s", prefix, deployment, m.ID)
+		hosted := []cty.Value{}
+		for it := runners.ElementIterator(); it.Next(); {
+			_, r := it.Element()
+			hr, script, err := hostRunner(r, folder)
+			if err != nil {
+				return fmt.Errorf("module %s: %w", m.ID, err)
+			}
+			if script != nil {
+				script.Module = m.ID
+				scripts = append(scripts, *script)
+			}
+			hosted = append(hosted, hr)
+		}
+		if len(hosted) > 0 {
+			m.Settings.Set(startupRunnersSetting, cty.TupleVal(hosted))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scripts, nil
+}
+
+// hostRunner replaces the content or local source of a runner with an object
+// in folder that is named after the runner destination and a hash of the
+// content, so that changed content produces a new object
+func hostRunner(r cty.Value, folder string) (cty.Value, *StartupScript, error) {
+	if _, is := IsExpressionValue(r); is || r.IsNull() || !r.IsKnown() || !(r.Type().IsObjectType() || r.Type().IsMapType()) {
+		return r, nil, nil
+	}
+	attrs := r.AsValueMap()
+	dst, ok := attrs["destination"]
+	if !ok || !isLiteralString(dst) {
+		return r, nil, nil
+	}
+
+	var content []byte
+	if c, ok := attrs["content"]; ok && isLiteralString(c) {
+		content = []byte(c.AsString())
+		delete(attrs, "content")
+	} else if s, ok := attrs["source"]; ok && isLiteralString(s) && filepath.IsAbs(s.AsString()) {
+		b, err := os.ReadFile(s.AsString())
+		if err != nil {
+			return cty.NilVal, nil, err
+		}
+		content = b
+	} else {
+		return r, nil, nil
+	}
+
+	sum := md5.Sum(content)
+	uri := fmt.Sprintf("%s/%s-%s", folder, path.Base(dst.AsString()), hex.EncodeToString(sum[:])[:8])
+	attrs["source"] = cty.StringVal(uri)
+	return cty.ObjectVal(attrs), &StartupScript{URI: uri, Content: content}, nil
+}
+
+// LocalPaths returns the paths of the files on the machine running ghpc that
+// the deployment reads: the sources of local modules, which are copied into
+// it, and the absolute sources of startup-script runners, which
+// HostStartupScripts uploads
+func (bp Blueprint) LocalPaths() []string {
+	paths := []string{}
+	bp.WalkModules(func(m *Module) error {
+		if sourcereader.IsLocalPath(m.Source) {
+			paths = append(paths, m.Source)
+		}
+		if !sourceIs(m.Source, startupScriptModule) || !m.Settings.Has(startupRunnersSetting) {
+			return nil
+		}
+		runners := m.Settings.Get(startupRunnersSetting)
+		if _, is := IsExpressionValue(runners); is || runners.IsNull() || !runners.IsKnown() || !runners.CanIterateElements() {
+			return nil
+		}
+		for it := runners.ElementIterator(); it.Next(); {
+			_, r := it.Element()
+			if _, is := IsExpressionValue(r); is || r.IsNull() || !r.IsKnown() || !(r.Type().IsObjectType() || r.Type().IsMapType()) {
+				continue
+			}
+			if src, ok := r.AsValueMap()["source"]; ok && isLiteralString(src) && filepath.IsAbs(src.AsString()) {
+				paths = append(paths, src.AsString())
+			}
+		}
+		return nil
+	})
+	return paths
+}
+
+func isLiteralString(v cty.Value) bool {
+	_, is := IsExpressionValue(v)
+	return !is && isNonEmptyString(v)
+}
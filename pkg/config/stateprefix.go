@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// prefixPlaceholder matches the placeholders of templated backend prefixes,
+// e.g. {{deployment_name}}/{{group}}
+var prefixPlaceholder = regexp.MustCompile(`{{\s*([^{}\s]*)\s*}}`)
+
+// isPrefixTemplate returns true if a backend prefix has placeholders
+func isPrefixTemplate(prefix string) bool {
+	return strings.Contains(prefix, "{{")
+}
+
+// namesGroup returns true if a templated prefix has the {{group}} placeholder
+func namesGroup(prefix string) bool {
+	for _, m := range prefixPlaceholder.FindAllStringSubmatch(prefix, -1) {
+		if m[1] == "group" {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPrefixTemplate replaces the {{blueprint_name}}, {{deployment_name}}
+// and {{group}} placeholders of the backend prefix of a group
+func (bp Blueprint) expandPrefixTemplate(prefix string, g GroupName) (string, error) {
+	values := map[string]string{
+		"blueprint_name": bp.BlueprintName,
+		"group":          string(g),
+	}
+	if deployment, err := bp.DeploymentName(); err == nil {
+		values["deployment_name"] = deployment
+	}
+	var err error
+	expanded := prefixPlaceholder.ReplaceAllStringFunc(prefix, func(p string) string {
+		name := prefixPlaceholder.FindStringSubmatch(p)[1]
+		v, ok := values[name]
+		if !ok && err == nil {
+			err = configErrorf("prefixTemplate", ": group %s, prefix %q: unknown placeholder {{%s}}, "+
+				"expected {{blueprint_name}}, {{deployment_name}} or {{group}}", g, prefix, name)
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(expanded, "{{") || strings.Contains(expanded, "}}") {
+		return "", configErrorf("prefixTemplate", ": group %s, prefix %q: unbalanced braces", g, prefix)
+	}
+	return expanded, nil
+}
+
+// StatePrefix is where a deployment group keeps its Terraform state in a
+// Cloud Storage bucket
+type StatePrefix struct {
+	Group  GroupName
+	Bucket string
+	Prefix string
+}
+
+// StateObject returns the name of the object of the state of the default
+// Terraform workspace
+func (s StatePrefix) StateObject() string {
+	if s.Prefix == "" {
+		return "default.tfstate"
+	}
+	return s.Prefix + "/default.tfstate"
+}
+
+// StatePrefixes returns the state locations of the groups with a gcs backend
+// whose bucket and prefix are known
+func (bp Blueprint) StatePrefixes() []StatePrefix {
+	prefixes := []StatePrefix{}
+	for _, g := range bp.DeploymentGroups {
+		be := g.TerraformBackend
+		if be.Type != "gcs" || !be.Configuration.Has("bucket") {
+			continue
+		}
+		bucket := be.Configuration.Get("bucket")
+		prefix := cty.StringVal("")
+		if be.Configuration.Has("prefix") {
+			prefix = be.Configuration.Get("prefix")
+		}
+		if bucket.IsMarked() || prefix.IsMarked() || !isNonEmptyString(bucket) ||
+			prefix.Type() != cty.String || prefix.IsNull() || !prefix.IsKnown() {
+			continue
+		}
+		prefixes = append(prefixes, StatePrefix{
+			Group:  g.Name,
+			Bucket: bucket.AsString(),
+			Prefix: strings.Trim(prefix.AsString(), "/"),
+		})
+	}
+	return prefixes
+}
+
+// checkStatePrefixes errors if two groups keep their Terraform state at the
+// same prefix of a bucket, where each would overwrite the state of the other
+func checkStatePrefixes(bp Blueprint) error {
+	seen := map[StatePrefix]GroupName{}
+	for _, s := range bp.StatePrefixes() {
+		key := StatePrefix{Bucket: s.Bucket, Prefix: s.Prefix}
+		if other, ok := seen[key]; ok {
+			return configErrorf("sharedStatePrefix", ": groups %s and %s both use gs://%s/%s; "+
+				"give them distinct prefixes, e.g. with the {{group}} placeholder", other, s.Group, s.Bucket, s.Prefix)
+		}
+		seen[key] = s.Group
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// documentStart matches the lines that start a document of a YAML stream
+var documentStart = regexp.MustCompile(`(?m)^---(?:[ \t].*)?$`)
+
+// streamDocument is a blueprint of a file of several blueprints
+type streamDocument struct {
+	name string
+	// data is preceded by the newlines of the lines before the document, so
+	// that the lines of errors are those of the file
+	data []byte
+	// node is the parsed data, nil if it has syntax errors
+	node *yaml.Node
+}
+
+// splitStream returns the documents of a YAML stream, leaving out those
+// without content, such as a license header before the first separator
+func splitStream(data []byte) []streamDocument {
+	// the document i is between the separators i-1 and i
+	seps := documentStart.FindAllIndex(data, -1)
+	docs := []streamDocument{}
+	for i := 0; i <= len(seps); i++ {
+		start, end := 0, len(data)
+		if i > 0 {
+			start = seps[i-1][1]
+		}
+		if i < len(seps) {
+			end = seps[i][0]
+		}
+		padding := bytes.Repeat([]byte("\n"), bytes.Count(data[:start], []byte("\n")))
+		doc := streamDocument{data: append(padding, data[start:end]...)}
+		var n yaml.Node
+		if err := yaml.Unmarshal(doc.data, &n); err == nil {
+			if n.Kind == 0 {
+				continue // only comments and whitespace
+			}
+			doc.node = &n
+			var header struct {
+				BlueprintName string `yaml:"blueprint_name"`
+			}
+			n.Decode(&header) // type errors are reported when the blueprint is decoded
+			doc.name = header.BlueprintName
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// selectBlueprint returns the document of the blueprint named name in a file
+// of `---`-separated blueprints. Files of a single blueprint are returned as
+// they are; an empty name selects the blueprint only in such files. The
+// document is returned parsed if it could be, nil otherwise.
+func selectBlueprint(filename string, data []byte, name string) ([]byte, *yaml.Node, error) {
+	docs := splitStream(data)
+	names := make([]string, len(docs))
+	for i, d := range docs {
+		names[i] = d.name
+	}
+
+	if len(docs) <= 1 {
+		if name != "" && (len(docs) == 0 || docs[0].name != name) {
+			return nil, nil, configErrorf("blueprintNotFound", ": %s in %s, which has %s", name, filename, strings.Join(names, ", "))
+		}
+		if len(docs) == 1 && bytes.Equal(docs[0].data, data) {
+			return data, docs[0].node, nil
+		}
+		return data, nil, nil
+	}
+	if name == "" {
+		return nil, nil, configErrorf("multipleBlueprints", ": %s has %s", filename, strings.Join(names, ", "))
+	}
+
+	var selected *streamDocument
+	for i, d := range docs {
+		if d.name != name {
+			continue
+		}
+		if selected != nil {
+			return nil, nil, configErrorf("duplicateBlueprint", ": %s in %s", name, filename)
+		}
+		selected = &docs[i]
+	}
+	if selected == nil {
+		return nil, nil, configErrorf("blueprintNotFound", ": %s in %s, which has %s", name, filename, strings.Join(names, ", "))
+	}
+
+	if selected.node != nil && isSopsEncrypted(selected.node) {
+		return nil, nil, fmt.Errorf("%s: sops-encrypted blueprints cannot share a file with other blueprints", filename)
+	}
+	return selected.data, selected.node, nil
+}
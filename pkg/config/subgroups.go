@@ -0,0 +1,97 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// splitMixedGroups replaces every group that mixes Packer and Terraform
+// modules, and does not set its kind, by sub-groups of consecutive modules of
+// the same kind, in the order of its modules. Every Packer module gets a
+// sub-group of its own. Sub-groups are named after the group, e.g. image-1 and
+// image-2, and names that another group of the blueprint already has are
+// rejected. Sub-groups share the backend, project and, if Terraform, the retry
+// policy of the group; an explicit GCS prefix is extended by the name of the
+// sub-group so that their states do not collide. References between modules
+// of the group become references to earlier groups, whose outputs are wired as
+// for any other group; references to modules of later sub-groups are rejected.
+func (bp *Blueprint) splitMixedGroups() error {
+	groups := []DeploymentGroup{}
+	for _, g := range bp.DeploymentGroups {
+		if g.Kind != UnknownKind || !g.isMixed() {
+			groups = append(groups, g)
+			continue
+		}
+		for _, sub := range g.split() {
+			if bp.GroupIndex(sub.Name) != -1 {
+				return configErrorf("duplicateGroup", ": group %s is split into sub-groups of Packer and Terraform modules, "+
+					"and sub-group %s has the name of another group; rename that group", g.Name, sub.Name)
+			}
+			groups = append(groups, sub)
+		}
+	}
+	bp.DeploymentGroups = groups
+	return nil
+}
+
+// isMixed returns true if the group has modules of differing kinds
+func (g DeploymentGroup) isMixed() bool {
+	for _, m := range g.Modules {
+		if m.Kind.groupKind() != g.Modules[0].Kind.groupKind() {
+			return true
+		}
+	}
+	return false
+}
+
+func (g DeploymentGroup) split() []DeploymentGroup {
+	subgroups := []DeploymentGroup{}
+	for _, m := range g.Modules {
+		last := len(subgroups) - 1
+		kind := m.Kind.groupKind()
+		if last >= 0 && kind == TerraformKind && subgroups[last].Kind == TerraformKind {
+			subgroups[last].Modules = append(subgroups[last].Modules, m)
+			continue
+		}
+		name := GroupName(fmt.Sprintf("%s-%d", g.Name, len(subgroups)+1))
+		be := g.TerraformBackend.copy()
+		// templated prefixes that name the group get that of the sub-group
+		if p := be.Configuration.Get("prefix"); be.Type == "gcs" && !p.IsMarked() && isNonEmptyString(p) &&
+			!namesGroup(p.AsString()) {
+			be.Configuration.Set("prefix", cty.StringVal(p.AsString()+"/"+string(name)))
+		}
+		// retries are only supported by Terraform groups
+		retry := RetryPolicy{}
+		if kind == TerraformKind {
+			retry = g.Retry
+		}
+		subgroups = append(subgroups, DeploymentGroup{
+			Name:                      name,
+			TerraformBackend:          be,
+			Backend:                   g.Backend,
+			ProjectID:                 g.ProjectID,
+			ImpersonateServiceAccount: g.ImpersonateServiceAccount,
+			SubgroupOf:                g.Name,
+			Retry:                     retry,
+			Vars:                      g.Vars,
+			Modules:                   []Module{m},
+			Kind:                      kind,
+		})
+	}
+	return subgroups
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Compute Engine reserves the network, gateway, second-to-last and
+	// broadcast addresses of the primary range of every subnetwork
+	reservedSubnetAddresses      = 4
+	defaultVPCAddressRange       = "10.0.0.0/9"
+	defaultPrimarySubnetworkSize = 15
+	defaultNodeCountDynamicMax   = 10
+)
+
+// usesSubnetCapacity returns true if the blueprint creates a VPC network for
+// VMs or Slurm partitions
+func (bp Blueprint) usesSubnetCapacity() bool {
+	return len(bp.modulesWithSource(vpcModule)) > 0 &&
+		(len(bp.modulesWithSource(vmInstanceModule)) > 0 || len(bp.modulesWithSource(slurmPartitionV5)) > 0)
+}
+
+// primarySubnetwork returns the CIDR range of the primary subnetwork of a VPC
+// module, the first of its subnetworks, and the number of addresses that VMs
+// can use in it
+func (bp Blueprint) primarySubnetwork(vpc Module) (string, int64, bool) {
+	rng := cty.StringVal(defaultVPCAddressRange)
+	if vpc.Settings.Has("network_address_range") {
+		var ok bool
+		if rng, ok = evalIfKnown(vpc.Settings.Get("network_address_range"), bp); !ok || !isNonEmptyString(rng) {
+			return "", 0, false
+		}
+	}
+	_, network, err := net.ParseCIDR(rng.AsString())
+	if err != nil {
+		return "", 0, false
+	}
+	newBits, ok := bp.evalInt(vpc, "default_primary_subnetwork_size", defaultPrimarySubnetworkSize)
+	if !ok {
+		return "", 0, false
+	}
+
+	if vpc.Settings.Has("subnetworks") {
+		subnets, ok := evalIfKnown(vpc.Settings.Get("subnetworks"), bp)
+		if !ok || subnets.IsNull() || !subnets.IsWhollyKnown() || !subnets.CanIterateElements() {
+			return "", 0, false
+		}
+		if subnets.LengthInt() > 0 {
+			first := subnets.AsValueSlice()[0]
+			if !first.Type().IsObjectType() && !first.Type().IsMapType() {
+				return "", 0, false
+			}
+			attrs := first.AsValueMap()
+			if ip := attrs["subnet_ip"]; isNonEmptyString(ip) {
+				return usableAddresses(ip.AsString())
+			}
+			nb := attrs["new_bits"]
+			if nb == cty.NilVal || nb.IsNull() || nb.Type() != cty.Number {
+				return "", 0, false
+			}
+			newBits, _ = nb.AsBigFloat().Int64()
+		}
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits != 32 || int64(ones)+newBits > 32 {
+		return "", 0, false
+	}
+	return usableAddresses(fmt.Sprintf("%s/%d", network.IP, int64(ones)+newBits))
+}
+
+// usableAddresses returns the number of addresses of an IPv4 CIDR range that
+// are not reserved by Compute Engine
+func usableAddresses(cidr string) (string, int64, bool) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", 0, false
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits != 32 {
+		return "", 0, false
+	}
+	usable := int64(1)<<(bits-ones) - reservedSubnetAddresses
+	if usable < 0 {
+		usable = 0
+	}
+	return ipnet.String(), usable, true
+}
+
+// referencedVPCs returns the VPC modules whose outputs the settings of a
+// module refer to
+func (bp Blueprint) referencedVPCs(m Module) []ModuleID {
+	vpcs := []ModuleID{}
+	for _, id := range referencedModules(m) {
+		if vpc, err := bp.Module(id); err == nil && sourceIs(vpc.Source, vpcModule) {
+			vpcs = append(vpcs, id)
+		}
+	}
+	return vpcs
+}
+
+// subnetDemand returns the most VMs that a module may create in the primary
+// subnetwork of each VPC module it refers to. The nodes of a Slurm partition
+// are those of its node groups, counting the dynamic nodes as if all were up.
+// VMs whose number is not known before deployment are not counted.
+func (bp Blueprint) subnetDemand(m Module) map[ModuleID]int64 {
+	demand := map[ModuleID]int64{}
+	switch {
+	case sourceIs(m.Source, vmInstanceModule):
+		if count, ok := bp.evalInt(m, "instance_count", 1); ok {
+			for _, vpc := range bp.referencedVPCs(m) {
+				demand[vpc] += count
+			}
+		}
+	case sourceIs(m.Source, slurmPartitionV5):
+		vpcs := bp.referencedVPCs(m)
+		for _, id := range referencedModules(m) {
+			group, err := bp.Module(id)
+			if err != nil || !sourceIs(group.Source, slurmNodeGroupV5) {
+				continue
+			}
+			static, sok := bp.evalInt(*group, "node_count_static", 0)
+			dynamic, dok := bp.evalInt(*group, "node_count_dynamic_max", defaultNodeCountDynamicMax)
+			if !sok || !dok {
+				continue
+			}
+			for _, vpc := range append(slices.Clone(vpcs), bp.referencedVPCs(*group)...) {
+				demand[vpc] += static + dynamic
+			}
+		}
+	}
+	return demand
+}
+
+// subnetCapacityWarnings warns, by VPC module, of primary subnetworks that
+// have fewer usable addresses than the VMs that modules may create in them;
+// modules for which ignores returns true are left out
+func (bp Blueprint) subnetCapacityWarnings(ignores func(Module) bool) map[string][]string {
+	demand := map[ModuleID]map[ModuleID]int64{}
+	bp.WalkModules(func(m *Module) error {
+		if ignores(*m) {
+			return nil
+		}
+		for vpc, n := range bp.subnetDemand(*m) {
+			if demand[vpc] == nil {
+				demand[vpc] = map[ModuleID]int64{}
+			}
+			demand[vpc][m.ID] += n
+		}
+		return nil
+	})
+
+	warnings := map[string][]string{}
+	for vpcID, users := range demand {
+		vpc, err := bp.Module(vpcID)
+		if err != nil || ignores(*vpc) {
+			continue
+		}
+		cidr, usable, ok := bp.primarySubnetwork(*vpc)
+		if !ok {
+			continue
+		}
+		ids := maps.Keys(users)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		total := int64(0)
+		counts := []string{}
+		for _, id := range ids {
+			total += users[id]
+			counts = append(counts, fmt.Sprintf("%s (%d)", id, users[id]))
+		}
+		if total <= usable {
+			continue
+		}
+		warnings[string(vpcID)] = append(warnings[string(vpcID)], fmt.Sprintf(
+			"up to %d VMs of %s may be created in primary subnetwork %s, which has %d usable addresses after the %d reserved by Compute Engine; "+
+				"VMs beyond that fail to start once the cluster scales up",
+			total, strings.Join(counts, ", "), cidr, usable, reservedSubnetAddresses))
+	}
+	return warnings
+}
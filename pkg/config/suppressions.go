@@ -0,0 +1,162 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// skipValidatorExp matches annotations in the comments of a module, e.g.
+//
+//	# ghpc:skip-validator=test_apis_enabled reason=APIs are enabled by the org
+var skipValidatorExp = regexp.MustCompile(`ghpc:skip-validator=(\S*)(?:\s+reason=(.*))?`)
+
+// ValidationReport records the validators that were skipped and the modules
+// whose findings were suppressed, with their justifications
+type ValidationReport struct {
+	SkippedValidators  []SkippedValidator  `yaml:"skipped_validators"`
+	SuppressedFindings []SuppressedFinding `yaml:"suppressed_findings"`
+}
+
+// SkippedValidator is a validator that was not run
+type SkippedValidator struct {
+	Validator string `yaml:"validator"`
+	Reason    string `yaml:"reason,omitempty"`
+}
+
+// SuppressedFinding is a module excluded from a validator by an annotation
+type SuppressedFinding struct {
+	Validator string   `yaml:"validator"`
+	Module    ModuleID `yaml:"module"`
+	Reason    string   `yaml:"reason"`
+}
+
+// readSkipAnnotations sets the validators skipped by the modules of the
+// blueprint from the ghpc:skip-validator annotations in the comments of the
+// YAML document. Annotations are read from the comment above a module and
+// from the line comment of its id.
+func (bp *Blueprint) readSkipAnnotations(doc *yaml.Node) error {
+	if doc == nil {
+		return nil
+	}
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	for _, g := range mappingValue(root, "deployment_groups").Content {
+		for _, item := range mappingValue(g, "modules").Content {
+			id := mappingValue(item, "id")
+			if id.Kind != yaml.ScalarNode {
+				continue
+			}
+			comments := item.HeadComment + "\n" + id.LineComment
+			if i := mappingKeyIndex(item, "id"); i != -1 {
+				comments += "\n" + item.Content[i].LineComment
+			}
+			skips, err := parseSkipAnnotations(comments)
+			if err != nil {
+				return fmt.Errorf("module %s: %w", id.Value, err)
+			}
+			if len(skips) == 0 {
+				continue
+			}
+			m, err := bp.Module(ModuleID(id.Value))
+			if err != nil {
+				return err
+			}
+			m.skipValidators = skips
+		}
+	}
+	return nil
+}
+
+// parseSkipAnnotations returns the reasons given by the annotations in the
+// comments by validator name
+func parseSkipAnnotations(comments string) (map[string]string, error) {
+	skips := map[string]string{}
+	for _, line := range strings.Split(comments, "\n") {
+		match := skipValidatorExp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		name, reason := match[1], strings.Trim(strings.TrimSpace(match[2]), `"'`)
+		if !isModuleScoped(name) {
+			return nil, configErrorf("unscopedValidator", ": %s", name)
+		}
+		if reason == "" {
+			return nil, fmt.Errorf("the annotation that skips validator %s must give a reason=", name)
+		}
+		skips[name] = reason
+	}
+	return skips, nil
+}
+
+// checkSkipReason confirms that only skipped validators give a reason
+func (v validatorConfig) checkSkipReason() error {
+	if v.SkipReason != "" && !v.Skip {
+		return fmt.Errorf("validator %s gives a skip_reason but is not skipped", v.Validator)
+	}
+	return nil
+}
+
+// ValidationReport returns the validators skipped by the blueprint and the
+// findings suppressed by module annotations
+func (dc DeploymentConfig) ValidationReport() ValidationReport {
+	r := ValidationReport{SkippedValidators: []SkippedValidator{}, SuppressedFindings: []SuppressedFinding{}}
+	if dc.Config.ValidationLevel == ValidationIgnore {
+		r.SkippedValidators = append(r.SkippedValidators, SkippedValidator{
+			Validator: "all", Reason: "validation_level is IGNORE"})
+	}
+	for _, v := range dc.Config.Validators {
+		if v.Skip {
+			r.SkippedValidators = append(r.SkippedValidators, SkippedValidator{Validator: v.Validator, Reason: v.SkipReason})
+		}
+	}
+	dc.Config.WalkModules(func(m *Module) error {
+		names := maps.Keys(m.skipValidators)
+		slices.Sort(names)
+		for _, n := range names {
+			r.SuppressedFindings = append(r.SuppressedFindings, SuppressedFinding{
+				Validator: n, Module: m.ID, Reason: m.skipValidators[n]})
+		}
+		return nil
+	})
+	return r
+}
+
+// ExportValidationReport writes the validation report as YAML
+func (dc DeploymentConfig) ExportValidationReport(outputFilename string) error {
+	var buf bytes.Buffer
+	buf.WriteString(YamlLicense)
+	buf.WriteString("\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(dc.ValidationReport()); err != nil {
+		return configErrorf("yamlMarshalError", ": %w", err)
+	}
+	encoder.Close()
+	if err := os.WriteFile(outputFilename, buf.Bytes(), 0644); err != nil {
+		return configErrorf("fileSaveError", ", Filename: %s: %w", outputFilename, err)
+	}
+	return nil
+}
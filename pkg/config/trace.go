@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyJson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// expansionState is the part of a blueprint that expansion phases change
+type expansionState struct {
+	ids        []ModuleID
+	kinds      map[ModuleID]ModuleKind
+	settings   map[ModuleID]map[string]cty.Value
+	validators []validatorConfig
+}
+
+func (bp Blueprint) expansionState() expansionState {
+	s := expansionState{
+		kinds:      map[ModuleID]ModuleKind{},
+		settings:   map[ModuleID]map[string]cty.Value{},
+		validators: slices.Clone(bp.Validators),
+	}
+	bp.WalkModules(func(m *Module) error {
+		s.ids = append(s.ids, m.ID)
+		s.kinds[m.ID] = m.Kind
+		s.settings[m.ID] = m.Settings.Items()
+		return nil
+	})
+	return s
+}
+
+// TraceExpansion writes every phase of the expansion of the blueprint, with
+// the changes it makes to the kinds and settings of modules and to the
+// validators, to w
+func (dc *DeploymentConfig) TraceExpansion(w io.Writer) {
+	dc.trace = w
+}
+
+// tracePhase runs a phase of expansion, tracing its changes if enabled
+func (dc *DeploymentConfig) tracePhase(name string, phase func() error) error {
+	if dc.trace == nil {
+		return phase()
+	}
+	before := dc.Config.expansionState()
+	err := phase()
+	after := dc.Config.expansionState()
+
+	fmt.Fprintf(dc.trace, "== %s\n", name)
+	if n := writeStateDiff(dc.trace, before, after); n == 0 {
+		fmt.Fprintln(dc.trace, "  no changes")
+	}
+	if err != nil {
+		fmt.Fprintf(dc.trace, "  failed: %v\n", err)
+	}
+	return err
+}
+
+// writeStateDiff writes the changes between two states and returns their
+// number
+func writeStateDiff(w io.Writer, before expansionState, after expansionState) int {
+	n := 0
+	for _, id := range after.ids {
+		lines := []string{}
+		if bk, ak := before.kinds[id], after.kinds[id]; bk != ak {
+			lines = append(lines, fmt.Sprintf("kind: %q -> %q", bk.String(), ak.String()))
+		}
+		bs, as := before.settings[id], after.settings[id]
+		names := maps.Keys(bs)
+		for name := range as {
+			if _, ok := bs[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			bv, inBefore := bs[name]
+			av, inAfter := as[name]
+			switch {
+			case !inBefore:
+				lines = append(lines, fmt.Sprintf("+ %s = %s", name, traceValue(av)))
+			case !inAfter:
+				lines = append(lines, fmt.Sprintf("- %s = %s", name, traceValue(bv)))
+			case !bv.RawEquals(av):
+				lines = append(lines, fmt.Sprintf("~ %s = %s -> %s", name, traceValue(bv), traceValue(av)))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  module %s:\n", id)
+		for _, l := range lines {
+			fmt.Fprintf(w, "    %s\n", l)
+		}
+		n += len(lines)
+	}
+
+	// validators are only ever appended by expansion
+	added := []validatorConfig{}
+	if len(after.validators) > len(before.validators) {
+		added = after.validators[len(before.validators):]
+	}
+	for _, v := range added {
+		if v.reason != "" {
+			fmt.Fprintf(w, "  + validator %s: %s\n", v.Validator, v.reason)
+		} else {
+			fmt.Fprintf(w, "  + validator %s\n", v.Validator)
+		}
+		n++
+	}
+	return n
+}
+
+// traceValue renders a setting as compact JSON, with references written as
+// in blueprints, e.g. $(vars.zone), and other expressions as HCL literals
+func traceValue(v cty.Value) string {
+	o, _ := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		e, is := IsExpressionValue(v)
+		if !is {
+			return v, nil
+		}
+		if refs := e.References(); len(refs) == 1 && e.key() == refs[0].AsExpression().key() {
+			r := refs[0]
+			if r.GlobalVar {
+				return cty.StringVal(fmt.Sprintf("$(vars.%s)", r.Name)), nil
+			}
+			return cty.StringVal(fmt.Sprintf("$(%s.%s)", r.Module, r.Name)), nil
+		}
+		return e.makeYamlExpressionValue(), nil
+	})
+	b, err := ctyJson.SimpleJSONValue{Value: o}.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("(%s)", v.Type().FriendlyName())
+	}
+	return string(b)
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// SettingTransformer is a Terraform function applied to the value of a module
+// setting when the deployment is written. The transformers of a setting form a
+// pipeline, each applied to the result of the previous one.
+type SettingTransformer string
+
+// transformers of module settings
+const (
+	// WrapTransformer wraps a value in a list: [v]
+	WrapTransformer SettingTransformer = "wrap"
+	// MergeTransformer merges a list of maps or objects: merge(a, b)
+	MergeTransformer SettingTransformer = "merge"
+	// FlattenTransformer flattens a list of lists: flatten([a, b])
+	FlattenTransformer SettingTransformer = "flatten"
+	// JSONEncodeTransformer encodes a value as a JSON string: jsonencode(v)
+	JSONEncodeTransformer SettingTransformer = "jsonencode"
+)
+
+// valueShape is what is known of a value before deployment
+type valueShape int
+
+const (
+	// anyShape is the shape of expressions, whose values are not known
+	anyShape valueShape = iota
+	listShape
+	objectShape
+	stringShape
+	scalarShape
+)
+
+func (s valueShape) String() string {
+	return map[valueShape]string{
+		anyShape:    "any",
+		listShape:   "list",
+		objectShape: "map or object",
+		stringShape: "string",
+		scalarShape: "number or bool",
+	}[s]
+}
+
+func shapeOf(v cty.Value) valueShape {
+	if _, is := IsExpressionValue(v); is {
+		return anyShape
+	}
+	ty := v.Type()
+	switch {
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		return listShape
+	case ty.IsMapType() || ty.IsObjectType():
+		return objectShape
+	case ty == cty.String:
+		return stringShape
+	case ty == cty.DynamicPseudoType:
+		return anyShape
+	default:
+		return scalarShape
+	}
+}
+
+// settingTransformer implements a SettingTransformer
+type settingTransformer struct {
+	// accepts lists the shapes of the values that can be transformed; nil
+	// accepts any value
+	accepts []valueShape
+	returns valueShape
+	// tokens returns the transformed value; elems are the tokens of the
+	// elements of the value if it is a literal list, and nil otherwise
+	tokens func(v hclwrite.Tokens, elems []hclwrite.Tokens) hclwrite.Tokens
+}
+
+var settingTransformers = map[SettingTransformer]settingTransformer{
+	WrapTransformer: {
+		returns: listShape,
+		tokens: func(v hclwrite.Tokens, _ []hclwrite.Tokens) hclwrite.Tokens {
+			return hclwrite.TokensForTuple([]hclwrite.Tokens{v})
+		},
+	},
+	MergeTransformer: {
+		accepts: []valueShape{listShape},
+		returns: objectShape,
+		tokens: func(v hclwrite.Tokens, elems []hclwrite.Tokens) hclwrite.Tokens {
+			if elems == nil {
+				// expand a list that is not known until deployment into
+				// the arguments of merge
+				v = append(v, &hclwrite.Token{Type: hclsyntax.TokenEllipsis, Bytes: []byte("...")})
+				return hclwrite.TokensForFunctionCall("merge", v)
+			}
+			return hclwrite.TokensForFunctionCall("merge", elems...)
+		},
+	},
+	FlattenTransformer: {
+		accepts: []valueShape{listShape},
+		returns: listShape,
+		tokens: func(v hclwrite.Tokens, _ []hclwrite.Tokens) hclwrite.Tokens {
+			return hclwrite.TokensForFunctionCall("flatten", v)
+		},
+	},
+	JSONEncodeTransformer: {
+		returns: stringShape,
+		tokens: func(v hclwrite.Tokens, _ []hclwrite.Tokens) hclwrite.Tokens {
+			return hclwrite.TokensForFunctionCall("jsonencode", v)
+		},
+	},
+}
+
+// SettingTransformers returns the names of all transformers
+func SettingTransformers() []SettingTransformer {
+	names := maps.Keys(settingTransformers)
+	slices.Sort(names)
+	return names
+}
+
+// checkTransforms verifies that the transformers of a setting exist and can be
+// applied to its value, and to the results of one another
+func checkTransforms(ts []SettingTransformer, v cty.Value) error {
+	shape := shapeOf(v)
+	for _, t := range ts {
+		impl, ok := settingTransformers[t]
+		if !ok {
+			return fmt.Errorf("unknown transformer %q, must be one of %v", t, SettingTransformers())
+		}
+		if shape != anyShape && impl.accepts != nil && !slices.Contains(impl.accepts, shape) {
+			return fmt.Errorf("transformer %s cannot be applied to a %s", t, shape)
+		}
+		shape = impl.returns
+	}
+	return nil
+}
+
+// checkModuleTransforms verifies the transforms of every module setting
+func checkModuleTransforms(bp Blueprint) error {
+	return bp.WalkModules(func(m *Module) error {
+		settings := maps.Keys(m.Transforms)
+		slices.Sort(settings)
+		for _, s := range settings {
+			if m.Kind == PackerKind {
+				return fmt.Errorf("module %s: transforms are not supported by Packer modules", m.ID)
+			}
+			if !m.Settings.Has(s) {
+				return fmt.Errorf("module %s: transforms of setting %s, which is not set", m.ID, s)
+			}
+			if err := checkTransforms(m.Transforms[s], m.Settings.Get(s)); err != nil {
+				return fmt.Errorf("module %s, setting %s: %w", m.ID, s, err)
+			}
+		}
+		return nil
+	})
+}
+
+// TokensForTransformed returns the tokens of a value transformed by a pipeline
+// of transformers; tokensForValue returns the tokens of untransformed values
+func TokensForTransformed(ts []SettingTransformer, v cty.Value, tokensForValue func(cty.Value) hclwrite.Tokens) (hclwrite.Tokens, error) {
+	if err := checkTransforms(ts, v); err != nil {
+		return nil, err
+	}
+	toks := tokensForValue(v)
+	var elems []hclwrite.Tokens
+	if shapeOf(v) == listShape {
+		elems = []hclwrite.Tokens{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, el := it.Element()
+			elems = append(elems, tokensForValue(el))
+		}
+	}
+	for _, t := range ts {
+		toks = settingTransformers[t].tokens(toks, elems)
+		elems = nil
+	}
+	return toks, nil
+}
+
+// prependTransform adds a transformer to the start of the pipeline of a
+// setting, so that the transformers set in the blueprint apply to its result
+func (m *Module) prependTransform(setting string, t SettingTransformer) {
+	if m.Transforms == nil {
+		m.Transforms = map[string][]SettingTransformer{}
+	}
+	m.Transforms[setting] = append([]SettingTransformer{t}, m.Transforms[setting]...)
+}
+
+// legacyWrappers are the prefixes and suffixes of the wrapsettingswith field
+// of blueprints expanded by earlier versions, and their transformers
+var legacyWrappers = map[[2]string]SettingTransformer{
+	{"merge(", ")"}:     MergeTransformer,
+	{"flatten([", "])"}: FlattenTransformer,
+}
+
+// migrateWrapSettingsWith replaces the wrapsettingswith field of modules of
+// blueprints expanded by earlier versions with transforms
+func (bp *Blueprint) migrateWrapSettingsWith() error {
+	return bp.WalkModules(func(m *Module) error {
+		for s, wrap := range m.LegacyWrapSettingsWith {
+			if len(wrap) != 2 {
+				return fmt.Errorf("module %s: invalid wrapsettingswith of %s, expected 2 strings got %d", m.ID, s, len(wrap))
+			}
+			t, ok := legacyWrappers[[2]string{wrap[0], wrap[1]}]
+			if !ok {
+				return fmt.Errorf("module %s: wrapsettingswith %q of %s is not supported, use transforms", m.ID, wrap, s)
+			}
+			m.prependTransform(s, t)
+		}
+		m.LegacyWrapSettingsWith = nil
+		return nil
+	})
+}
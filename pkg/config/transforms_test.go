@@ -0,0 +1,117 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+// tokensForTestValue tokenizes expressions, also as elements of tuples
+func tokensForTestValue(v cty.Value) hclwrite.Tokens {
+	if e, is := IsExpressionValue(v); is {
+		return e.Tokenize()
+	}
+	if v.Type().IsTupleType() {
+		elems := []hclwrite.Tokens{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, el := it.Element()
+			elems = append(elems, tokensForTestValue(el))
+		}
+		return hclwrite.TokensForTuple(elems)
+	}
+	return hclwrite.TokensForValue(v)
+}
+
+func (s *MySuite) TestTokensForTransformed(c *C) {
+	labels := cty.TupleVal([]cty.Value{
+		GlobalRef("labels").AsExpression().AsValue(),
+		cty.ObjectVal(map[string]cty.Value{"ghpc_role": cty.StringVal("compute")}),
+	})
+	runners := cty.TupleVal([]cty.Value{
+		ModuleRef("script", "runners").AsExpression().AsValue(),
+		cty.TupleVal([]cty.Value{cty.StringVal("a")}),
+	})
+	for _, tc := range []struct {
+		ts   []SettingTransformer
+		v    cty.Value
+		want string
+	}{
+		{[]SettingTransformer{MergeTransformer}, labels, "merge(var.labels, {\n  ghpc_role = \"compute\"\n})"},
+		{[]SettingTransformer{FlattenTransformer}, runners, `flatten([module.script.runners, ["a"]])`},
+		{[]SettingTransformer{WrapTransformer}, cty.StringVal("a"), `["a"]`},
+		{[]SettingTransformer{JSONEncodeTransformer}, cty.NumberIntVal(1), `jsonencode(1)`},
+		// merge expands lists that are not literals
+		{[]SettingTransformer{WrapTransformer, MergeTransformer}, ModuleRef("vm", "labels").AsExpression().AsValue(),
+			`merge([module.vm.labels]...)`},
+		{[]SettingTransformer{MergeTransformer, JSONEncodeTransformer}, labels,
+			"jsonencode(merge(var.labels, {\n  ghpc_role = \"compute\"\n}))"},
+	} {
+		toks, err := TokensForTransformed(tc.ts, tc.v, tokensForTestValue)
+		c.Assert(err, IsNil)
+		c.Check(string(hclwrite.Format(toks.Bytes())), Equals, tc.want)
+	}
+
+	_, err := TokensForTransformed([]SettingTransformer{JSONEncodeTransformer, FlattenTransformer}, labels, tokensForTestValue)
+	c.Check(err, ErrorMatches, "transformer flatten cannot be applied to a string")
+}
+
+func (s *MySuite) TestCheckTransforms(c *C) {
+	obj := cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("b")})
+	c.Check(checkTransforms([]SettingTransformer{WrapTransformer, MergeTransformer}, obj), IsNil)
+	c.Check(checkTransforms([]SettingTransformer{MergeTransformer}, obj),
+		ErrorMatches, "transformer merge cannot be applied to a map or object")
+	c.Check(checkTransforms([]SettingTransformer{"upper"}, obj),
+		ErrorMatches, `unknown transformer "upper", must be one of \[flatten jsonencode merge wrap\]`)
+	// values of expressions are not known until deployment
+	c.Check(checkTransforms([]SettingTransformer{FlattenTransformer}, GlobalRef("x").AsExpression().AsValue()), IsNil)
+
+	mod := Module{
+		ID:         "vm",
+		Kind:       TerraformKind,
+		Settings:   NewDict(map[string]cty.Value{"metadata": obj}),
+		Transforms: map[string][]SettingTransformer{"metadata": {JSONEncodeTransformer}},
+	}
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{mod}}}}
+	c.Check(checkModuleTransforms(bp), IsNil)
+
+	bp.DeploymentGroups[0].Modules[0].Transforms = map[string][]SettingTransformer{"labels": {MergeTransformer}}
+	c.Check(checkModuleTransforms(bp), ErrorMatches, "module vm: transforms of setting labels, which is not set")
+
+	bp.DeploymentGroups[0].Modules[0].Kind = PackerKind
+	c.Check(checkModuleTransforms(bp), ErrorMatches, "module vm: transforms are not supported by Packer modules")
+}
+
+func (s *MySuite) TestMigrateWrapSettingsWith(c *C) {
+	mod := Module{
+		ID: "vm",
+		LegacyWrapSettingsWith: map[string][]string{
+			"labels":  {"merge(", ")"},
+			"runners": {"flatten([", "])"},
+		},
+	}
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{mod}}}}
+	c.Assert(bp.migrateWrapSettingsWith(), IsNil)
+	got := bp.DeploymentGroups[0].Modules[0]
+	c.Check(got.LegacyWrapSettingsWith, IsNil)
+	c.Check(got.Transforms, DeepEquals, map[string][]SettingTransformer{
+		"labels":  {MergeTransformer},
+		"runners": {FlattenTransformer},
+	})
+
+	bp.DeploymentGroups[0].Modules[0].LegacyWrapSettingsWith = map[string][]string{"ids": {"toset(", ")"}}
+	c.Check(bp.migrateWrapSettingsWith(), ErrorMatches, `.*wrapsettingswith \["toset\(" "\)"\] of ids is not supported, use transforms`)
+}
@@ -0,0 +1,383 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// UseMode is how the outputs of a module in the use list of another are
+// matched to the inputs of the other module
+type UseMode string
+
+const (
+	// UseByName matches outputs to the inputs of the same name; it is the
+	// default
+	UseByName UseMode = "name"
+	// UseByNameAndType matches outputs to the inputs of the same name and
+	// type; outputs whose type is not declared are not matched
+	UseByNameAndType UseMode = "name_and_type"
+	// UseByMap matches no output, only setting the inputs mapped from the
+	// used module
+	UseByMap UseMode = "map"
+)
+
+var allUseModes = []UseMode{UseByName, UseByNameAndType, UseByMap}
+
+// UseMatch is an input of a module matched to an output of a module in its
+// use list, or mapped to an expression of its outputs
+type UseMatch struct {
+	Input string
+	// Output is the name of the output, or the expression of a mapped input
+	Output string
+	Mapped bool
+	// Skipped tells why the input is not set from the output, if it is not
+	Skipped string
+}
+
+// UseReport lists the matches of the outputs of a used module to the inputs
+// of the module that uses it
+type UseReport struct {
+	Module  ModuleID
+	Used    ModuleID
+	Mode    UseMode
+	Matches []UseMatch
+}
+
+// useMode returns the mode by which the module matches the outputs of a
+// module in its use list
+func (m Module) useMode(used ModuleID) UseMode {
+	if mode, ok := m.useModes[used]; ok {
+		return mode
+	}
+	return UseByName
+}
+
+// MatchUse matches the outputs of used to the inputs of mod under the mode,
+// along with the inputs that mod maps to expressions of the outputs of used.
+// Outputs that match no input by name are left out. It does not consider the
+// settings of mod, which take precedence over the matches when the use list
+// is applied.
+func MatchUse(mod Module, used Module, mode UseMode) []UseMatch {
+	inputs := getModuleInputMap(mod.InfoOrDie().Inputs)
+	mapped := mod.useMaps[used.ID]
+	matches := []UseMatch{}
+	for _, o := range used.InfoOrDie().Outputs {
+		inputType, ok := inputs[o.Name]
+		if !ok || mapped.Has(o.Name) {
+			continue
+		}
+		m := UseMatch{Input: o.Name, Output: o.Name}
+		switch mode {
+		case UseByMap:
+			m.Skipped = "outputs are not matched by name, only mapped"
+		case UseByNameAndType:
+			if o.Type == "" {
+				m.Skipped = "the type of the output is not declared"
+			} else if !typesMatch(inputType, o.Type) {
+				m.Skipped = fmt.Sprintf("the output is of type %s, the input of type %s", o.Type, inputType)
+			}
+		}
+		matches = append(matches, m)
+	}
+
+	names := maps.Keys(mapped.Items())
+	slices.Sort(names)
+	for _, name := range names {
+		e, _ := IsExpressionValue(mapped.Get(name))
+		out := ""
+		if e != nil {
+			out = strings.TrimSpace(string(e.Tokenize().Bytes()))
+		}
+		matches = append(matches, UseMatch{Input: name, Output: out, Mapped: true})
+	}
+	return matches
+}
+
+// typesMatch returns true if an output of the type can be given to an input
+// of the type, which accepts any type if it is not declared
+func typesMatch(inputType string, outputType string) bool {
+	if inputType == "" || inputType == "any" {
+		return true
+	}
+	return modulereader.NormalizeType(inputType) == modulereader.NormalizeType(outputType)
+}
+
+// moduleKeys are the keys of modules in blueprints
+var moduleKeys = yamlKeys(reflect.TypeOf(Module{}))
+
+// yamlKeys returns the keys by which the exported fields of a struct are
+// decoded from YAML
+func yamlKeys(t reflect.Type) []string {
+	keys := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+// UnmarshalYAML reads a module of a blueprint. Items of its use list are
+// either module IDs or objects that also map settings of the module to
+// expressions of the outputs of the used module, or choose how its outputs
+// are matched to the inputs of the module, e.g.
+//
+//	use:
+//	- module: network1
+//	  match: name_and_type
+//	  map:
+//	    subnetwork_self_link: $(element(network1.subnetwork_self_links, 0))
+func (m *Module) UnmarshalYAML(n *yaml.Node) error {
+	type plain Module
+	if n.Kind != yaml.MappingNode {
+		return n.Decode((*plain)(m))
+	}
+
+	// the decoder does not reject unknown keys below a custom unmarshaler, so
+	// they are rejected here; the node is copied as it also holds comments
+	// read from the blueprint
+	cp := *n
+	cp.Content = slices.Clone(n.Content)
+	var useMaps map[ModuleID]Dict
+	var useModes map[ModuleID]UseMode
+	for i := 0; i+1 < len(cp.Content); i += 2 {
+		k := cp.Content[i]
+		if !slices.Contains(moduleKeys, k.Value) {
+			return fmt.Errorf("line %d: field %s not found in type config.Module", k.Line, k.Value)
+		}
+		if k.Value != "use" || cp.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		use, um, modes, err := readUseList(cp.Content[i+1])
+		if err != nil {
+			return err
+		}
+		cp.Content[i+1] = use
+		if len(um) > 0 {
+			useMaps = um
+		}
+		if len(modes) > 0 {
+			useModes = modes
+		}
+	}
+	if err := cp.Decode((*plain)(m)); err != nil {
+		return err
+	}
+	m.useMaps = useMaps
+	m.useModes = useModes
+	return nil
+}
+
+// readUseList replaces the objects of a use list by the IDs of their modules
+// and returns the maps and the match modes of the objects by module ID
+func readUseList(seq *yaml.Node) (*yaml.Node, map[ModuleID]Dict, map[ModuleID]UseMode, error) {
+	res := *seq
+	res.Content = slices.Clone(seq.Content)
+	useMaps := map[ModuleID]Dict{}
+	useModes := map[ModuleID]UseMode{}
+	for i, item := range seq.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		var id ModuleID
+		var mapped Dict
+		var mode UseMode
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			k, v := item.Content[j], item.Content[j+1]
+			switch k.Value {
+			case "module":
+				if err := v.Decode(&id); err != nil {
+					return nil, nil, nil, err
+				}
+			case "map":
+				if v.Kind != yaml.MappingNode {
+					return nil, nil, nil, fmt.Errorf("line %d: map of a used module must map settings to expressions", v.Line)
+				}
+				for s := 0; s+1 < len(v.Content); s += 2 {
+					e, err := parseUseMapValue(v.Content[s+1])
+					if err != nil {
+						return nil, nil, nil, err
+					}
+					mapped.Set(v.Content[s].Value, e.AsValue())
+				}
+			case "match":
+				mode = UseMode(v.Value)
+				if v.Kind != yaml.ScalarNode || !slices.Contains(allUseModes, mode) {
+					return nil, nil, nil, fmt.Errorf("line %d: match of a used module must be one of %s, %s or %s",
+						v.Line, UseByName, UseByNameAndType, UseByMap)
+				}
+			default:
+				return nil, nil, nil, fmt.Errorf("line %d: field %s not found in used module, expected module, match or map", k.Line, k.Value)
+			}
+		}
+		if id == "" {
+			return nil, nil, nil, fmt.Errorf("line %d: used module must set module", item.Line)
+		}
+		useMaps[id] = mapped
+		if mode != "" {
+			useModes[id] = mode
+		}
+		res.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(id), Line: item.Line, Column: item.Column}
+	}
+	return &res, useMaps, useModes, nil
+}
+
+// parseUseMapValue reads an expression of a use map, either as "$(...)", in
+// which any HCL expression may refer to deployment variables as vars.NAME and
+// to outputs of modules as ID.OUTPUT, or as an HCL literal "((...))"
+func parseUseMapValue(n *yaml.Node) (Expression, error) {
+	if n.Kind == yaml.ScalarNode {
+		if match := simpleVariableExp.FindStringSubmatch(n.Value); match != nil {
+			e, err := blueprintToExpression(match[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n.Line, err)
+			}
+			return e, nil
+		}
+		if l, is := IsYamlExpressionLiteral(cty.StringVal(n.Value)); is {
+			e, err := ParseExpression(l)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n.Line, err)
+			}
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("line %d: map of a used module must map settings to expressions, $(...) or ((...))", n.Line)
+}
+
+// blueprintToExpression translates an HCL expression in which deployment
+// variables are vars.NAME and module outputs ID.OUTPUT into an Expression
+func blueprintToExpression(s string) (Expression, error) {
+	e, diags := hclsyntax.ParseExpression([]byte(s), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	roots := []hcl.Range{}
+	for _, t := range e.Variables() {
+		roots = append(roots, t[0].SourceRange())
+	}
+	// rewrite from the end so that earlier offsets stay valid
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Start.Byte > roots[j].Start.Byte })
+	b := []byte(s)
+	for _, r := range roots {
+		root := string(b[r.Start.Byte:r.End.Byte])
+		repl := "module." + root
+		if root == "vars" {
+			repl = "var"
+		}
+		b = append(b[:r.Start.Byte], append([]byte(repl), b[r.End.Byte:]...)...)
+	}
+	return ParseExpression(string(b))
+}
+
+// applyUseMap sets the settings that a module maps to expressions of the
+// outputs of a used module. As for outputs that match inputs, settings of the
+// blueprint take precedence and list settings are appended to.
+func applyUseMap(bp Blueprint, mod *Module, useMod Module, settingsToIgnore []string) error {
+	mapped := mod.useMaps[useMod.ID]
+	inputs := getModuleInputMap(mod.InfoOrDie().Inputs)
+	names := maps.Keys(mapped.Items())
+	slices.Sort(names)
+	for _, name := range names {
+		if slices.Contains(settingsToIgnore, name) {
+			continue
+		}
+		inputType, ok := inputs[name]
+		if !ok {
+			return fmt.Errorf("module %s maps outputs of %s to %s, which is not an input of the module", mod.ID, useMod.ID, name)
+		}
+		v := mapped.Get(name)
+		e, _ := IsExpressionValue(v)
+		if !refersToModule(e, useMod.ID) {
+			return fmt.Errorf("module %s maps %s to an expression that uses no output of %s", mod.ID, name, useMod.ID)
+		}
+		for _, r := range e.References() {
+			if err := validateModuleSettingReference(bp, *mod, r); err != nil {
+				return err
+			}
+		}
+
+		v = v.Mark(ProductOfModuleUse{Module: useMod.ID})
+		if !strings.HasPrefix(inputType, "list") {
+			mod.Settings.Set(name, v)
+		} else if err := mod.addListValue(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refersToModule returns true if the expression refers to an output of the
+// module
+func refersToModule(e Expression, id ModuleID) bool {
+	return slices.ContainsFunc(e.References(), func(r Reference) bool { return !r.GlobalVar && r.Module == id })
+}
+
+// checkUseConflicts errors if several modules in use provide an output to the
+// same non-list input of mod, which the blueprint and use maps leave unset;
+// without the strict_use feature, the output of the first of them is used
+func checkUseConflicts(bp Blueprint, mod Module, settingsInBlueprint []string) error {
+	inputs := getModuleInputMap(mod.InfoOrDie().Inputs)
+	providers := map[string][]ModuleID{}
+	for _, u := range mod.Use {
+		used, err := bp.Module(u)
+		if err != nil {
+			return err
+		}
+		for _, m := range MatchUse(mod, *used, mod.useMode(u)) {
+			if m.Mapped || m.Skipped != "" || strings.HasPrefix(inputs[m.Input], "list") || slices.Contains(settingsInBlueprint, m.Input) {
+				continue
+			}
+			if slices.ContainsFunc(mod.Use, func(id ModuleID) bool {
+				mapped := mod.useMaps[id]
+				return mapped.Has(m.Input)
+			}) {
+				continue
+			}
+			providers[m.Input] = append(providers[m.Input], u)
+		}
+	}
+	names := maps.Keys(providers)
+	slices.Sort(names)
+	for _, name := range names {
+		if ids := providers[name]; len(ids) > 1 {
+			return fmt.Errorf("modules %s and %s in use of module %s both provide setting %s; "+
+				"set it in the blueprint or map it from one of them (%s)", ids[0], ids[1], mod.ID, name, strictUseFeature)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"hpc-toolkit/pkg/deploymentio"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// UserConfigEnv names an alternative user configuration file
+const UserConfigEnv = "GHPC_CONFIG"
+
+// UserConfig holds the persistent defaults of a user, which apply to every
+// command unless overridden by flags or blueprints
+type UserConfig struct {
+	// BackendBucket is the Cloud Storage bucket of the gcs Terraform backend
+	// of blueprints that do not configure a backend
+	BackendBucket string `yaml:"backend_bucket,omitempty"`
+	// Project is the project_id of blueprints that do not set it
+	Project         string `yaml:"project,omitempty"`
+	ValidationLevel string `yaml:"validation_level,omitempty"`
+	// CacheDir holds caches shared by deployments, e.g. Terraform providers
+	CacheDir string `yaml:"cache_dir,omitempty"`
+	// Telemetry, when false, stops Terraform and Packer from reporting to
+	// HashiCorp
+	Telemetry *bool `yaml:"telemetry,omitempty"`
+	// CatalogURL is the URL of a remote index of blueprints listed by
+	// "ghpc catalog" in addition to the embedded examples
+	CatalogURL string `yaml:"catalog_url,omitempty"`
+	// Umask restricts the permissions of the files and directories of the
+	// deployments that ghpc writes, e.g. 0002 to share them with a group
+	Umask string `yaml:"umask,omitempty"`
+	// Notifications are sent in addition to those of blueprints
+	Notifications []Notification `yaml:"notifications,omitempty"`
+}
+
+// UserConfigKeys are the keys of the user configuration that can be read and
+// set one at a time
+var UserConfigKeys = []string{"backend_bucket", "project", "validation_level", "cache_dir", "telemetry", "catalog_url", "umask"}
+
+var validationLevels = []string{"ERROR", "WARNING", "IGNORE"}
+
+// UserConfigPath returns the path of the user configuration file,
+// ~/.config/ghpc/config.yaml on Linux, unless GHPC_CONFIG is set
+func UserConfigPath() (string, error) {
+	if p := os.Getenv(UserConfigEnv); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the user configuration directory, set %s: %w", UserConfigEnv, err)
+	}
+	return filepath.Join(dir, "ghpc", "config.yaml"), nil
+}
+
+// LoadUserConfig reads the user configuration file; a missing file is an
+// empty configuration
+func LoadUserConfig(path string) (UserConfig, error) {
+	var c UserConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		return c, fmt.Errorf("failed to read user configuration %s: %w", path, err)
+	}
+	if err := c.check(); err != nil {
+		return c, fmt.Errorf("user configuration %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the user configuration file, creating its directory
+func (c UserConfig) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return configErrorf("yamlMarshalError", ": %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return configErrorf("fileSaveError", ", Filename: %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c UserConfig) check() error {
+	if c.ValidationLevel != "" && !slices.Contains(validationLevels, c.ValidationLevel) {
+		return fmt.Errorf("validation_level must be one of %v, got %q", validationLevels, c.ValidationLevel)
+	}
+	if c.CatalogURL != "" {
+		if u, err := url.Parse(c.CatalogURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("catalog_url must be an http or https url, got %q", c.CatalogURL)
+		}
+	}
+	if c.Umask != "" {
+		if _, err := deploymentio.ParseUmask(c.Umask); err != nil {
+			return err
+		}
+	}
+	for i, n := range c.Notifications {
+		if err := n.check(); err != nil {
+			return fmt.Errorf("notification %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Get returns the value of a key, or "" if it is not set
+func (c UserConfig) Get(key string) (string, error) {
+	switch key {
+	case "backend_bucket":
+		return c.BackendBucket, nil
+	case "project":
+		return c.Project, nil
+	case "validation_level":
+		return c.ValidationLevel, nil
+	case "cache_dir":
+		return c.CacheDir, nil
+	case "telemetry":
+		if c.Telemetry == nil {
+			return "", nil
+		}
+		return strconv.FormatBool(*c.Telemetry), nil
+	case "catalog_url":
+		return c.CatalogURL, nil
+	case "umask":
+		return c.Umask, nil
+	default:
+		return "", fmt.Errorf("unknown user configuration key %q, must be one of %v", key, UserConfigKeys)
+	}
+}
+
+// Set sets the value of a key; the empty value unsets it. The configuration
+// is left unchanged if the value is invalid.
+func (c *UserConfig) Set(key string, value string) error {
+	n := *c
+	switch key {
+	case "backend_bucket":
+		n.BackendBucket = value
+	case "project":
+		n.Project = value
+	case "validation_level":
+		n.ValidationLevel = value
+	case "cache_dir":
+		if value != "" {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				return err
+			}
+			value = abs
+		}
+		n.CacheDir = value
+	case "telemetry":
+		if value == "" {
+			n.Telemetry = nil
+			break
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("telemetry must be true or false, got %q", value)
+		}
+		n.Telemetry = &b
+	case "catalog_url":
+		n.CatalogURL = value
+	case "umask":
+		n.Umask = value
+	default:
+		return fmt.Errorf("unknown user configuration key %q, must be one of %v", key, UserConfigKeys)
+	}
+	if err := n.check(); err != nil {
+		return err
+	}
+	*c = n
+	return nil
+}
@@ -17,12 +17,15 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
 	"strings"
+	"time"
 
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/quota"
 	"hpc-toolkit/pkg/validators"
 
 	"github.com/pkg/errors"
@@ -37,53 +40,237 @@ const (
 	validationErrorMsg   = "validation failed due to the issues listed above"
 	funcErrorMsgTemplate = "validator %s failed"
 	maxLabels            = 64
+	// defaultValidatorTimeout bounds validators that do not set a timeout so
+	// that an unresponsive API cannot block blueprint creation indefinitely
+	defaultValidatorTimeout = 2 * time.Minute
+	// slurmAccountingSetting is the Slurm controller setting that enables
+	// accounting against an external database
+	slurmAccountingSetting = "cloudsql"
+	// startupRunnersSetting is the setting of startup-script and VM modules
+	// that lists the runners executed when a VM boots
+	startupRunnersSetting = "runners"
+)
+
+var slurmAccountingFields = []string{"server_ip", "user", "password", "db_name"}
+
+// settings that are written directly to the metadata of VMs
+var startupMetadataSettings = []string{"startup_script", "metadata"}
+
+var (
+	illegalCharsExp = regexp.MustCompile(`^[\w\+]+(\s*)[\w-\+\.]+$`)
+	settingNameExp  = regexp.MustCompile(`^[a-zA-Z-_][a-zA-Z0-9-_]*$`)
 )
 
 // InvalidSettingError signifies a problem with the supplied setting name in a
 // module definition.
 type InvalidSettingError struct {
+	code  ErrorCode
 	cause string
 }
 
+// newInvalidSettingError returns the InvalidSettingError of errorMessages[key]
+// for the setting described by details
+func newInvalidSettingError(key string, details string) *InvalidSettingError {
+	return &InvalidSettingError{code: errorCodes[key], cause: errorMessages[key] + "\n" + details}
+}
+
 func (err *InvalidSettingError) Error() string {
 	return fmt.Sprintf("invalid setting provided to a module, cause: %v", err.cause)
 }
 
+// ErrorCode returns the code of the problem with the setting
+func (err *InvalidSettingError) ErrorCode() ErrorCode {
+	return err.code
+}
+
+// ValidatorTimeoutError signifies that a validator did not complete before
+// its own timeout or the overall validation deadline expired.
+type ValidatorTimeoutError struct {
+	Validator string
+	Timeout   time.Duration
+	cause     error
+}
+
+func (err *ValidatorTimeoutError) Error() string {
+	if errors.Is(err.cause, context.DeadlineExceeded) && err.Timeout > 0 {
+		return fmt.Sprintf("validator %s did not complete within %s", err.Validator, err.Timeout)
+	}
+	return fmt.Sprintf("validator %s did not complete before the validation deadline: %v", err.Validator, err.cause)
+}
+
+// ErrorCode returns ErrCodeValidatorTimeout
+func (err *ValidatorTimeoutError) ErrorCode() ErrorCode {
+	return ErrCodeValidatorTimeout
+}
+
+// runValidator executes the validator, returning ValidatorTimeoutError if it
+// does not complete before the validator timeout or the context is done.
+// The validator is passed a context that is done at the same time, which
+// aborts its API calls.
+func runValidator(ctx context.Context, f func(context.Context, validatorConfig) error, v validatorConfig) error {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultValidatorTimeout
+	}
+	vctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- f(vctx, v) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-vctx.Done():
+		if ctx.Err() != nil { // overall deadline expired or validation was cancelled
+			return &ValidatorTimeoutError{Validator: v.Validator, cause: ctx.Err()}
+		}
+		return &ValidatorTimeoutError{Validator: v.Validator, Timeout: timeout, cause: vctx.Err()}
+	}
+}
+
 // validate is the top-level function for running the validation suite.
-func (dc DeploymentConfig) validate() {
+func (dc DeploymentConfig) validate(ctx context.Context) error {
 	// Drop the flags for log to improve readability only for running the validation suite
 	log.SetFlags(0)
+	// Set it back to the initial value
+	defer log.SetFlags(log.LstdFlags)
 
 	// variables should be validated before running validators
-	if err := dc.executeValidators(); err != nil {
-		log.Fatal(err)
+	if err := dc.executeValidators(ctx); err != nil {
+		return err
 	}
 
 	if err := dc.validateModules(); err != nil {
-		log.Fatal(err)
+		return err
+	}
+	return dc.validateModuleSettings()
+}
+
+// locationValidators check zones and regions, which they list for each
+// project rather than get one by one
+var locationValidators = []validatorName{testRegionExistsName, testZoneExistsName, testZoneInRegionName}
+
+// locationProjects returns the projects whose zones and regions are checked
+// by the validators of the blueprint, including those of the modules checked
+// by test_module_zones; projects that cannot be resolved before the
+// validators run are left out
+func (dc DeploymentConfig) locationProjects() []string {
+	projects := []string{}
+	for _, v := range dc.Config.Validators {
+		if !v.Skip && v.Validator == testModuleZonesName.String() {
+			dc.Config.WalkModules(func(m *Module) error {
+				if zm, ok := dc.Config.zoneModule(*m); ok && !v.ignores(*m, dc.Config) && !slices.Contains(projects, zm.ProjectID) {
+					projects = append(projects, zm.ProjectID)
+				}
+				return nil
+			})
+			continue
+		}
+		if v.Skip || !slices.ContainsFunc(locationValidators, func(n validatorName) bool { return n.String() == v.Validator }) {
+			continue
+		}
+		if !v.Inputs.Has("project_id") {
+			continue
+		}
+		p, ok := evalIfKnown(v.Inputs.Get("project_id"), dc.Config)
+		if ok && isNonEmptyString(p) && !slices.Contains(projects, p.AsString()) {
+			projects = append(projects, p.AsString())
+		}
+	}
+	return projects
+}
+
+// failedProjects records the projects for which test_credentials or
+// test_project_exists failed, whose validators are not run as they would fail
+// for the same reason; all is set when the failure concerns every project,
+// such as invalid credentials or a project that could not be resolved
+type failedProjects struct {
+	all      bool
+	projects []string
+}
+
+// validatorProject returns the project_id input of a validator, if it has one,
+// and its value when it can be resolved before the validators run
+func validatorProject(v validatorConfig, bp Blueprint) (project string, targets bool) {
+	if !v.Inputs.Has("project_id") {
+		return "", false
+	}
+	if p, ok := evalIfKnown(v.Inputs.Get("project_id"), bp); ok && isNonEmptyString(p) {
+		return p.AsString(), true
 	}
-	if err := dc.validateModuleSettings(); err != nil {
-		log.Fatal(err)
+	return "", true
+}
+
+// record adds the project of a failed validator; only the failures of
+// test_credentials and test_project_exists are recorded
+func (f *failedProjects) record(v validatorConfig, bp Blueprint) {
+	if v.Validator != testCredentialsName.String() && v.Validator != testProjectExistsName.String() {
+		return
 	}
+	if p, _ := validatorProject(v, bp); p != "" {
+		f.projects = append(f.projects, p)
+	} else {
+		f.all = true
+	}
+}
 
-	// Set it back to the initial value
-	log.SetFlags(log.LstdFlags)
+// skips reports whether v targets a failed project, and names the project
+func (f failedProjects) skips(v validatorConfig, bp Blueprint) (string, bool) {
+	p, targets := validatorProject(v, bp)
+	if !targets {
+		return "", false
+	}
+	if slices.Contains(f.projects, p) {
+		return p, true
+	}
+	return p, f.all
 }
 
-// performs validation of global variables
-func (dc DeploymentConfig) executeValidators() error {
+func (dc DeploymentConfig) executeValidators(ctx context.Context) error {
 	var errored, warned bool
+	var failed failedProjects
 	implementedValidators := dc.getValidators()
 
 	if dc.Config.ValidationLevel == ValidationIgnore {
 		return nil
 	}
 
+	if dc.Config.ValidationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dc.Config.ValidationTimeout)
+		defer cancel()
+	}
+	ctx = validators.WithImpersonation(ctx, dc.Config.ImpersonateServiceAccount)
+	ctx = validators.WithLocationCache(ctx)
+	validators.PrefetchLocations(ctx, dc.locationProjects())
+
 	for _, validator := range dc.Config.Validators {
 		if validator.Skip {
 			continue
 		}
 
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return fmt.Errorf("validation was cancelled: %w", ctx.Err())
+		}
+		if ctx.Err() != nil {
+			warned = true
+			log.Printf("warning: validator %s was not run because the validation deadline of %s expired",
+				validator.Validator, dc.Config.ValidationTimeout)
+			log.Println()
+			continue
+		}
+
+		if p, skip := failed.skips(validator, dc.Config); skip {
+			if p == "" {
+				p = "of the blueprint"
+			}
+			log.Printf("validator %s was not run because the credentials or project %s could not be validated",
+				validator.Validator, p)
+			log.Println()
+			continue
+		}
+
 		f, ok := implementedValidators[validator.Validator]
 		if !ok {
 			errored = true
@@ -91,7 +278,21 @@ func (dc DeploymentConfig) executeValidators() error {
 			continue
 		}
 
-		if err := f(validator); err != nil {
+		err := runValidator(ctx, f, validator)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return fmt.Errorf("validation was cancelled: %w", ctx.Err())
+		}
+		var timeoutErr *ValidatorTimeoutError
+		if errors.As(err, &timeoutErr) {
+			// timeouts are likely caused by the environment rather than the
+			// blueprint, so they are never treated as validation errors
+			warned = true
+			log.Print("warning: ", err)
+			log.Println()
+			continue
+		}
+
+		if err != nil {
 			var prefix string
 			switch dc.Config.ValidationLevel {
 			case ValidationWarning:
@@ -104,10 +305,9 @@ func (dc DeploymentConfig) executeValidators() error {
 			log.Print(prefix, err)
 			log.Println()
 
-			// do not bother running further validators if project ID could not be found
-			if validator.Validator == testProjectExistsName.String() {
-				break
-			}
+			// do not bother running further validators of the project if the
+			// credentials are invalid or the project could not be found
+			failed.record(validator, dc.Config)
 		}
 
 	}
@@ -132,12 +332,13 @@ func (dc DeploymentConfig) executeValidators() error {
 	}
 
 	if errored {
-		return fmt.Errorf(validationErrorMsg)
+		return &Error{Code: ErrCodeValidationFailed, err: errors.New(validationErrorMsg)}
 	}
 	return nil
 }
 
-// validateVars checks the global variables for viable types
+// validateVars performs validation of global variables: it checks them for
+// viable types
 func (dc DeploymentConfig) validateVars() error {
 	vars := dc.Config.Vars
 	nilErr := "deployment variable %s was not set"
@@ -163,13 +364,11 @@ func (dc DeploymentConfig) validateVars() error {
 
 			// Check that label names are valid
 			if !isValidLabelName(labelName) {
-				return errors.Errorf("%s: '%s: %s'",
-					errorMessages["labelNameReqs"], labelName, labelValue)
+				return configErrorf("labelNameReqs", ": '%s: %s'", labelName, labelValue)
 			}
 			// Check that label values are valid
 			if !isValidLabelValue(labelValue) {
-				return errors.Errorf("%s: '%s: %s'",
-					errorMessages["labelValueReqs"], labelName, labelValue)
+				return configErrorf("labelValueReqs", ": '%s: %s'", labelName, labelValue)
 			}
 		}
 	}
@@ -201,19 +400,19 @@ func module2String(c Module) string {
 
 func validateModule(c Module) error {
 	if c.ID == "" {
-		return fmt.Errorf("%s\n%s", errorMessages["emptyID"], module2String(c))
+		return configErrorf("emptyID", "\n%s", module2String(c))
 	}
 	if c.Source == "" {
-		return fmt.Errorf("%s\n%s", errorMessages["emptySource"], module2String(c))
+		return configErrorf("emptySource", "\n%s", module2String(c))
 	}
 	if !IsValidModuleKind(c.Kind.String()) {
-		return fmt.Errorf("%s\n%s", errorMessages["wrongKind"], module2String(c))
+		return configErrorf("wrongKind", "\n%s", module2String(c))
 	}
 	return nil
 }
 
 func hasIllegalChars(name string) bool {
-	return !regexp.MustCompile(`^[\w\+]+(\s*)[\w-\+\.]+$`).MatchString(name)
+	return !illegalCharsExp.MatchString(name)
 }
 
 func validateOutputs(mod Module) error {
@@ -224,12 +423,17 @@ func validateOutputs(mod Module) error {
 		outputsMap = modInfo.GetOutputsAsMap()
 	}
 
-	// Ensure output exists in the underlying modules
+	// Ensure output exists in the underlying modules and is listed once, as
+	// each becomes an output block of the group
+	listed := map[string]bool{}
 	for _, output := range mod.Outputs {
 		if _, ok := outputsMap[output.Name]; !ok {
-			return fmt.Errorf("%s, module: %s output: %s",
-				errorMessages["invalidOutput"], mod.ID, output.Name)
+			return configErrorf("invalidOutput", ", module: %s output: %s", mod.ID, output.Name)
+		}
+		if listed[output.Name] {
+			return configErrorf("duplicateOutput", ", module: %s output: %s", mod.ID, output.Name)
 		}
+		listed[output.Name] = true
 	}
 	return nil
 }
@@ -269,21 +473,15 @@ func validateSettings(
 		// HCL does not support periods in variables names either:
 		// https://hcl.readthedocs.io/en/latest/language_design.html#language-keywords-and-identifiers
 		if strings.Contains(k, ".") {
-			return &InvalidSettingError{
-				fmt.Sprintf("%s\n%s", errorMessages["settingWithPeriod"], errData),
-			}
+			return newInvalidSettingError("settingWithPeriod", errData)
 		}
 		// Setting includes invalid characters
-		if !regexp.MustCompile(`^[a-zA-Z-_][a-zA-Z0-9-_]*$`).MatchString(k) {
-			return &InvalidSettingError{
-				fmt.Sprintf("%s\n%s", errorMessages["settingInvalidChar"], errData),
-			}
+		if !settingNameExp.MatchString(k) {
+			return newInvalidSettingError("settingInvalidChar", errData)
 		}
 		// Module not found
 		if _, ok := cVars.Inputs[k]; !ok {
-			return &InvalidSettingError{
-				fmt.Sprintf("%s\n%s", errorMessages["extraSetting"], errData),
-			}
+			return newInvalidSettingError("extraSetting", errData)
 		}
 
 	}
@@ -309,8 +507,8 @@ func (dc DeploymentConfig) validateModuleSettings() error {
 	return nil
 }
 
-func (dc *DeploymentConfig) getValidators() map[string]func(validatorConfig) error {
-	allValidators := map[string]func(validatorConfig) error{
+func (dc *DeploymentConfig) getValidators() map[string]func(context.Context, validatorConfig) error {
+	allValidators := map[string]func(context.Context, validatorConfig) error{
 		testApisEnabledName.String():               dc.testApisEnabled,
 		testProjectExistsName.String():             dc.testProjectExists,
 		testRegionExistsName.String():              dc.testRegionExists,
@@ -318,6 +516,26 @@ func (dc *DeploymentConfig) getValidators() map[string]func(validatorConfig) err
 		testZoneInRegionName.String():              dc.testZoneInRegion,
 		testModuleNotUsedName.String():             dc.testModuleNotUsed,
 		testDeploymentVariableNotUsedName.String(): dc.testDeploymentVariableNotUsed,
+		testSlurmAccountingName.String():           dc.testSlurmAccounting,
+		testStartupScriptsName.String():            dc.testStartupScripts,
+		testHostnamesName.String():                 dc.testHostnames,
+		testOSLoginSSHKeysName.String():            dc.testOSLoginSSHKeys,
+		testSpotConfigurationName.String():         dc.testSpotConfiguration,
+		testOpsAgentName.String():                  dc.testOpsAgent,
+		testContainerImagesName.String():           dc.testContainerImages,
+		testComputeQuotasName.String():             dc.testComputeQuotas,
+		testDiskSizesName.String():                 dc.testDiskSizes,
+		testBatchPermissionsName.String():          dc.testBatchPermissions,
+		execName.String():                          dc.testExec,
+		testPlacementAndMTUName.String():           dc.testPlacementAndMTU,
+		testSubnetCapacityName.String():            dc.testSubnetCapacity,
+		testCMEKKeysName.String():                  dc.testCMEKKeys,
+		testZoneAvailableName.String():             dc.testZoneAvailable,
+		testGPUImagesName.String():                 dc.testGPUImages,
+		testExternalIPsName.String():               dc.testExternalIPs,
+		testCredentialsName.String():               dc.testCredentials,
+		testGPUNetworkingName.String():             dc.testGPUNetworking,
+		testModuleZonesName.String():               dc.testModuleZones,
 	}
 	return allValidators
 }
@@ -347,48 +565,66 @@ func mergeBlueprintRequirements(base map[string][]string, new map[string][]strin
 	return dest
 }
 
-func (dc *DeploymentConfig) testApisEnabled(c validatorConfig) error {
+func (dc *DeploymentConfig) testApisEnabled(ctx context.Context, c validatorConfig) error {
 	if err := c.check(testApisEnabledName, []string{}); err != nil {
 		return err
 	}
 
+	requiredApis, err := dc.requiredApisByProject(c)
+	if err != nil {
+		return err
+	}
+
+	var errored bool
+	for project, apis := range requiredApis {
+		err := validators.TestApisEnabled(ctx, project, apis)
+		if err != nil {
+			log.Println(err)
+			errored = true
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(funcErrorMsgTemplate, testApisEnabledName.String())
+	}
+	return nil
+}
+
+// requiredApisByProject returns the APIs required by the modules that the
+// validator does not ignore, by the resolved ID of their project
+func (dc DeploymentConfig) requiredApisByProject(c validatorConfig) (map[string][]string, error) {
 	requiredApis := make(map[string][]string)
 	for _, grp := range dc.Config.DeploymentGroups {
 		for _, mod := range grp.Modules {
+			if c.ignores(mod, dc.Config) {
+				continue
+			}
 			requiredApis = mergeBlueprintRequirements(requiredApis, mod.RequiredApis)
 		}
 	}
 
-	var errored bool
+	resolved := make(map[string][]string)
 	for project, apis := range requiredApis {
 		if hasVariable(project) {
 			expr, err := SimpleVarToExpression(project)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			v, err := expr.Eval(dc.Config)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if v.Type() != cty.String {
-				return fmt.Errorf("the deployment variable %s is not a string", project)
+				return nil, fmt.Errorf("the deployment variable %s is not a string", project)
 			}
 			project = v.AsString()
 		}
-		err := validators.TestApisEnabled(project, apis)
-		if err != nil {
-			log.Println(err)
-			errored = true
-		}
+		resolved = mergeBlueprintRequirements(resolved, map[string][]string{project: apis})
 	}
-
-	if errored {
-		return fmt.Errorf(funcErrorMsgTemplate, testApisEnabledName.String())
-	}
-	return nil
+	return resolved, nil
 }
 
-func (dc *DeploymentConfig) testProjectExists(c validatorConfig) error {
+func (dc *DeploymentConfig) testProjectExists(ctx context.Context, c validatorConfig) error {
 	funcName := testProjectExistsName.String()
 	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
 
@@ -401,14 +637,37 @@ func (dc *DeploymentConfig) testProjectExists(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestProjectExists(m["project_id"]); err != nil {
+	if err = validators.TestProjectExists(ctx, m["project_id"]); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testCredentials(ctx context.Context, c validatorConfig) error {
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, testCredentialsName.String())
+
+	inputs := []string{}
+	if c.Inputs.Has("project_id") {
+		inputs = append(inputs, "project_id")
+	}
+	if err := c.check(testCredentialsName, inputs); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	if err = validators.TestCredentials(ctx, m["project_id"]); err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
 	return nil
 }
 
-func (dc *DeploymentConfig) testRegionExists(c validatorConfig) error {
+func (dc *DeploymentConfig) testRegionExists(ctx context.Context, c validatorConfig) error {
 	funcName := testRegionExistsName.String()
 	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
 
@@ -421,14 +680,14 @@ func (dc *DeploymentConfig) testRegionExists(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestRegionExists(m["project_id"], m["region"]); err != nil {
+	if err = validators.TestRegionExists(ctx, m["project_id"], m["region"]); err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
 	return nil
 }
 
-func (dc *DeploymentConfig) testZoneExists(c validatorConfig) error {
+func (dc *DeploymentConfig) testZoneExists(ctx context.Context, c validatorConfig) error {
 	funcName := testZoneExistsName.String()
 	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
 
@@ -441,14 +700,14 @@ func (dc *DeploymentConfig) testZoneExists(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestZoneExists(m["project_id"], m["zone"]); err != nil {
+	if err = validators.TestZoneExists(ctx, m["project_id"], m["zone"]); err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
 	return nil
 }
 
-func (dc *DeploymentConfig) testZoneInRegion(c validatorConfig) error {
+func (dc *DeploymentConfig) testZoneInRegion(ctx context.Context, c validatorConfig) error {
 	funcName := testZoneInRegionName.String()
 	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
 
@@ -461,20 +720,42 @@ func (dc *DeploymentConfig) testZoneInRegion(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestZoneInRegion(m["project_id"], m["zone"], m["region"]); err != nil {
+	if err = validators.TestZoneInRegion(ctx, m["project_id"], m["zone"], m["region"]); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testZoneAvailable(ctx context.Context, c validatorConfig) error {
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, testZoneAvailableName.String())
+
+	if err := c.check(testZoneAvailableName, []string{"project_id", "zone"}); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	if err = validators.TestZoneAvailable(ctx, m["project_id"], m["zone"]); err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
 	return nil
 }
 
-func (dc *DeploymentConfig) testModuleNotUsed(c validatorConfig) error {
+func (dc *DeploymentConfig) testModuleNotUsed(_ context.Context, c validatorConfig) error {
 	if err := c.check(testModuleNotUsedName, []string{}); err != nil {
 		return err
 	}
 
 	acc := map[string][]string{}
 	dc.Config.WalkModules(func(m *Module) error {
+		if c.ignores(*m, dc.Config) {
+			return nil
+		}
 		ids := m.listUnusedModules()
 		sids := make([]string, len(ids))
 		for i, id := range ids {
@@ -491,7 +772,7 @@ func (dc *DeploymentConfig) testModuleNotUsed(c validatorConfig) error {
 	return nil
 }
 
-func (dc *DeploymentConfig) testDeploymentVariableNotUsed(c validatorConfig) error {
+func (dc *DeploymentConfig) testDeploymentVariableNotUsed(_ context.Context, c validatorConfig) error {
 	if err := c.check(testDeploymentVariableNotUsedName, []string{}); err != nil {
 		return err
 	}
@@ -503,6 +784,514 @@ func (dc *DeploymentConfig) testDeploymentVariableNotUsed(c validatorConfig) err
 	return nil
 }
 
+func (dc *DeploymentConfig) testSlurmAccounting(_ context.Context, c validatorConfig) error {
+	if err := c.check(testSlurmAccountingName, []string{}); err != nil {
+		return err
+	}
+
+	acc := map[string][]string{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if m.Settings.Has(slurmAccountingSetting) && !c.ignores(*m, dc.Config) {
+			acc[string(m.ID)] = dc.Config.slurmAccountingProblems(m.Settings.Get(slurmAccountingSetting))
+		}
+		return nil
+	})
+
+	if err := validators.TestSlurmAccounting(acc); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testSlurmAccountingName.String())
+	}
+	return nil
+}
+
+// slurmAccountingProblems describes why the value of the cloudsql setting of
+// a Slurm controller would produce a broken slurmdbd configuration
+func (bp Blueprint) slurmAccountingProblems(v cty.Value) []string {
+	problems := []string{}
+	if e, is := IsExpressionValue(v); is {
+		for _, ref := range e.References() {
+			if ref.GlobalVar {
+				continue
+			}
+			mod, err := bp.Module(ref.Module)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("database module %s was not found", ref.Module))
+				continue
+			}
+			problems = append(problems, cloudsqlModuleProblems(*mod, bp)...)
+		}
+		if len(problems) > 0 {
+			return problems
+		}
+	}
+
+	ev, ok := evalIfKnown(v, bp)
+	if !ok {
+		return problems
+	}
+	if ev.IsNull() {
+		// accounting is explicitly disabled
+		return problems
+	}
+	if !ev.Type().IsObjectType() && !ev.Type().IsMapType() {
+		return append(problems, fmt.Sprintf("%s must be an object with the fields %s",
+			slurmAccountingSetting, strings.Join(slurmAccountingFields, ", ")))
+	}
+	fields := ev.AsValueMap()
+	for _, f := range slurmAccountingFields {
+		fv, ok := fields[f]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s.%s is not set", slurmAccountingSetting, f))
+		} else if !isNonEmptyString(fv) {
+			problems = append(problems, fmt.Sprintf("%s.%s must be a non-empty string", slurmAccountingSetting, f))
+		}
+	}
+	return problems
+}
+
+// cloudsqlModuleProblems checks the settings of a database module that
+// supplies the cloudsql output consumed by a Slurm controller
+func cloudsqlModuleProblems(mod Module, bp Blueprint) []string {
+	problems := []string{}
+	if !mod.Settings.Has("nat_ips") {
+		problems = append(problems, fmt.Sprintf(
+			"database module %s does not set nat_ips; the controller will not be allowed to connect to the database", mod.ID))
+	}
+	for _, s := range []string{"sql_username", "sql_password"} {
+		if !mod.Settings.Has(s) {
+			continue
+		}
+		v, ok := evalIfKnown(mod.Settings.Get(s), bp)
+		if !ok || (s == "sql_password" && v.IsNull()) {
+			// a null password is replaced by a generated one
+			continue
+		}
+		if !isNonEmptyString(v) {
+			problems = append(problems, fmt.Sprintf("database module %s setting %s must be a non-empty string", mod.ID, s))
+		}
+	}
+	return problems
+}
+
+func (dc *DeploymentConfig) testStartupScripts(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testStartupScriptsName, []string{}); err != nil {
+		return err
+	}
+
+	runners := []validators.Runner{}
+	sizes := map[string]int{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if c.ignores(*m, dc.Config) {
+			return nil
+		}
+		runners = append(runners, knownRunners(*m, dc.Config)...)
+		if size := knownMetadataSize(*m, dc.Config); size > 0 {
+			sizes[string(m.ID)] = size
+		}
+		return nil
+	})
+
+	if err := validators.TestStartupScripts(ctx, dc.blueprintDir, runners, sizes); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testStartupScriptsName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testHostnames(_ context.Context, c validatorConfig) error {
+	if err := c.check(testHostnamesName, []string{}); err != nil {
+		return err
+	}
+
+	problems := map[string][]string{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if !c.ignores(*m, dc.Config) {
+			problems[string(m.ID)] = dc.Config.hostnameProblems(*m)
+		}
+		return nil
+	})
+
+	if err := validators.TestHostnames(problems); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testHostnamesName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testOSLoginSSHKeys(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testOSLoginSSHKeysName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.SSHKeysModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if sm, ok := dc.Config.sshKeysModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, sm)
+		}
+		return nil
+	})
+
+	if err := validators.TestOSLoginSSHKeys(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testOSLoginSSHKeysName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testSpotConfiguration(_ context.Context, c validatorConfig) error {
+	if err := c.check(testSpotConfigurationName, []string{}); err != nil {
+		return err
+	}
+
+	problems := map[string][]string{}
+	warnings := map[string][]string{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if !c.ignores(*m, dc.Config) {
+			problems[string(m.ID)], warnings[string(m.ID)] = dc.Config.spotProblems(*m)
+		}
+		return nil
+	})
+
+	if err := validators.TestSpotConfiguration(problems, warnings); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testSpotConfigurationName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testPlacementAndMTU(_ context.Context, c validatorConfig) error {
+	if err := c.check(testPlacementAndMTUName, []string{}); err != nil {
+		return err
+	}
+
+	problems := map[string][]string{}
+	warnings := map[string][]string{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if !c.ignores(*m, dc.Config) {
+			problems[string(m.ID)], warnings[string(m.ID)] = dc.Config.placementProblems(*m)
+		}
+		return nil
+	})
+
+	if err := validators.TestPlacementAndMTU(problems, warnings); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testPlacementAndMTUName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testSubnetCapacity(_ context.Context, c validatorConfig) error {
+	if err := c.check(testSubnetCapacityName, []string{}); err != nil {
+		return err
+	}
+	warnings := dc.Config.subnetCapacityWarnings(func(m Module) bool { return c.ignores(m, dc.Config) })
+	return validators.TestSubnetCapacity(warnings)
+}
+
+func (dc *DeploymentConfig) testOpsAgent(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testOpsAgentName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.OpsAgentModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if om, ok := dc.Config.opsAgentModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, om)
+		}
+		return nil
+	})
+
+	if err := validators.TestOpsAgent(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testOpsAgentName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testContainerImages(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testContainerImagesName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.ContainerImageModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if cm, ok := dc.Config.containerImageModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, cm)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+
+	if err := validators.TestContainerImages(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testContainerImagesName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testComputeQuotas(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testComputeQuotasName, []string{}); err != nil {
+		return err
+	}
+
+	demands := []quota.Demand{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if d, ok := dc.Config.quotaDemand(*m); ok && !c.ignores(*m, dc.Config) {
+			demands = append(demands, d)
+		}
+		return nil
+	})
+	if len(demands) == 0 {
+		return nil
+	}
+
+	if err := validators.TestComputeQuotas(ctx, demands); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testComputeQuotasName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testDiskSizes(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testDiskSizesName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.DiskModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if dm, ok := dc.Config.diskModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, dm)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+
+	if err := validators.TestDiskSizes(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testDiskSizesName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testBatchPermissions(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testBatchPermissionsName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.BatchModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if bm, ok := dc.Config.batchModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, bm)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+
+	if err := validators.TestBatchPermissions(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testBatchPermissionsName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testCMEKKeys(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testCMEKKeysName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.CMEKModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if cm, ok := dc.Config.cmekModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, cm)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+
+	if err := validators.TestCMEKKeys(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testCMEKKeysName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testGPUImages(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testGPUImagesName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.GPUModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if gm, ok := dc.Config.gpuModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, gm)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+
+	if err := validators.TestGPUImages(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testGPUImagesName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testExternalIPs(ctx context.Context, c validatorConfig) error {
+	inputs := []string{}
+	if c.Inputs.Has(maxExternalLoginNodesInput) {
+		inputs = append(inputs, maxExternalLoginNodesInput)
+	}
+	if err := c.check(testExternalIPsName, inputs); err != nil {
+		return err
+	}
+	maxLoginNodes, err := dc.Config.maxExternalLoginNodes(c)
+	if err != nil {
+		return err
+	}
+
+	modules := []validators.ExternalIPModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if em, ok := dc.Config.externalIPModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, em)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+	return validators.TestExternalIPs(ctx, modules, maxLoginNodes)
+}
+
+func (dc *DeploymentConfig) testGPUNetworking(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testGPUNetworkingName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.GPUNetworkModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if gm, ok := dc.Config.gpuNetworkModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, gm)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+	if err := validators.TestGPUNetworking(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testGPUNetworkingName.String())
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testModuleZones(ctx context.Context, c validatorConfig) error {
+	if err := c.check(testModuleZonesName, []string{}); err != nil {
+		return err
+	}
+
+	modules := []validators.ZoneModule{}
+	dc.Config.WalkModules(func(m *Module) error {
+		if zm, ok := dc.Config.zoneModule(*m); ok && !c.ignores(*m, dc.Config) {
+			modules = append(modules, zm)
+		}
+		return nil
+	})
+	if len(modules) == 0 {
+		return nil
+	}
+	if err := validators.TestModuleZones(ctx, modules); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, testModuleZonesName.String())
+	}
+	return nil
+}
+
+// knownRunners returns the startup-script runners of a module that do not
+// depend upon the outputs of other modules
+func knownRunners(m Module, bp Blueprint) []validators.Runner {
+	runners := []validators.Runner{}
+	if !m.Settings.Has(startupRunnersSetting) {
+		return runners
+	}
+	v := m.Settings.Get(startupRunnersSetting)
+	if _, is := IsExpressionValue(v); is || v.IsNull() || !v.IsKnown() || !v.CanIterateElements() {
+		return runners
+	}
+
+	for it := v.ElementIterator(); it.Next(); {
+		_, el := it.Element()
+		ev, ok := evalIfKnown(el, bp)
+		if !ok || ev.IsNull() || !(ev.Type().IsObjectType() || ev.Type().IsMapType()) {
+			continue
+		}
+		fields := ev.AsValueMap()
+		r := validators.Runner{Module: string(m.ID)}
+		for name, field := range map[string]*string{
+			"type":        &r.Type,
+			"destination": &r.Destination,
+			"source":      &r.Source,
+			"content":     &r.Content,
+		} {
+			if fv, ok := fields[name]; ok && isNonEmptyString(fv) {
+				*field = fv.AsString()
+			}
+		}
+		runners = append(runners, r)
+	}
+	return runners
+}
+
+// knownMetadataSize returns the number of bytes of VM metadata set directly
+// by a module, counting only values known before deployment
+func knownMetadataSize(m Module, bp Blueprint) int {
+	size := 0
+	for _, s := range startupMetadataSettings {
+		if !m.Settings.Has(s) {
+			continue
+		}
+		v, ok := evalIfKnown(m.Settings.Get(s), bp)
+		if !ok || v.IsNull() || !v.IsWhollyKnown() {
+			continue
+		}
+		switch {
+		case v.Type() == cty.String:
+			size += len(v.AsString())
+		case v.Type().IsObjectType() || v.Type().IsMapType():
+			for k, mv := range v.AsValueMap() {
+				size += len(k)
+				if isNonEmptyString(mv) {
+					size += len(mv.AsString())
+				}
+			}
+		}
+	}
+	return size
+}
+
+// evalIfKnown evaluates v in the context of the deployment variables; values
+// that depend upon module outputs are not known until deployment
+func evalIfKnown(v cty.Value, bp Blueprint) (cty.Value, bool) {
+	d, err := NewDict(map[string]cty.Value{"v": v}).Eval(bp)
+	if err != nil {
+		return cty.NilVal, false
+	}
+	return d.Get("v"), true
+}
+
+func isNonEmptyString(v cty.Value) bool {
+	return v.Type() == cty.String && !v.IsNull() && v.IsKnown() && v.AsString() != ""
+}
+
 // Helper function to evaluate validator inputs and make sure that all values are strings.
 func evalValidatorInputsAsStrings(inputs Dict, bp Blueprint) (map[string]string, error) {
 	ev, err := inputs.Eval(bp)
@@ -518,3 +1307,76 @@ func evalValidatorInputsAsStrings(inputs Dict, bp Blueprint) (map[string]string,
 	}
 	return ms, nil
 }
+
+// execInputs returns the command, arguments and environment of an exec
+// validator; only command is required
+func execInputs(c validatorConfig, bp Blueprint) (string, []string, map[string]string, error) {
+	for name := range c.Inputs.Items() {
+		if !slices.Contains([]string{"command", "args", "env"}, name) {
+			return "", nil, nil, fmt.Errorf("validator %s does not accept input %s; it accepts command, args and env", c.Validator, name)
+		}
+	}
+	if !c.Inputs.Has("command") {
+		return "", nil, nil, fmt.Errorf("at least one required input was not provided to %s", c.Validator)
+	}
+	in, err := c.Inputs.Eval(bp)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	cmd := in.Get("command")
+	if cmd.IsNull() || cmd.Type() != cty.String || cmd.AsString() == "" {
+		return "", nil, nil, fmt.Errorf("input command of %s must be a non-empty string", c.Validator)
+	}
+
+	args := []string{}
+	if a := in.Get("args"); in.Has("args") && !a.IsNull() {
+		if !(a.Type().IsListType() || a.Type().IsTupleType()) {
+			return "", nil, nil, fmt.Errorf("input args of %s must be a list of strings", c.Validator)
+		}
+		for it := a.ElementIterator(); it.Next(); {
+			_, el := it.Element()
+			if el.IsNull() || el.Type() != cty.String {
+				return "", nil, nil, fmt.Errorf("input args of %s must be a list of strings", c.Validator)
+			}
+			args = append(args, el.AsString())
+		}
+	}
+
+	env := map[string]string{}
+	if e := in.Get("env"); in.Has("env") && !e.IsNull() {
+		if !(e.Type().IsMapType() || e.Type().IsObjectType()) {
+			return "", nil, nil, fmt.Errorf("input env of %s must be a map of strings", c.Validator)
+		}
+		for k, v := range e.AsValueMap() {
+			if v.IsNull() || v.Type() != cty.String {
+				return "", nil, nil, fmt.Errorf("input env of %s must be a map of strings, but %s is not a string", c.Validator, k)
+			}
+			env[k] = v.AsString()
+		}
+	}
+	return cmd.AsString(), args, env, nil
+}
+
+func (dc *DeploymentConfig) testExec(ctx context.Context, c validatorConfig) error {
+	if c.Validator != execName.String() {
+		return fmt.Errorf("passed wrong validator to %s implementation", execName.String())
+	}
+	command, args, env, err := execInputs(c, dc.Config)
+	if err != nil {
+		return err
+	}
+	if err := validators.TestExec(ctx, dc.blueprintDir, command, args, env); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, execName.String())
+	}
+	return nil
+}
+
+// HasExecValidators returns whether the blueprint runs exec validators, which
+// run arbitrary commands on the machine running ghpc
+func (bp Blueprint) HasExecValidators() bool {
+	return slices.ContainsFunc(bp.Validators, func(v validatorConfig) bool {
+		return v.Validator == execName.String() && !v.Skip
+	})
+}
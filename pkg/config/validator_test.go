@@ -15,11 +15,17 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/quota"
+	"hpc-toolkit/pkg/validators"
 
 	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
@@ -174,12 +180,46 @@ func (s *MySuite) TestValidateOutputs(c *C) {
 		{Name: "waldo"}}
 	expErr := fmt.Sprintf("%s.*", errorMessages["invalidOutput"])
 	c.Assert(validateOutputs(mod), ErrorMatches, expErr)
+
+	// Output listed twice would write two output blocks of the same name
+	mod.Outputs = []modulereader.OutputInfo{
+		{Name: "velvet"},
+		{Name: "velvet", Sensitive: true}}
+	err := validateOutputs(mod)
+	c.Check(err, ErrorMatches, fmt.Sprintf("%s.*", errorMessages["duplicateOutput"]))
+	c.Check(CodeOf(err), Equals, ErrCodeDuplicateOutput)
+}
+
+func (s *MySuite) TestInheritOutputInfo(c *C) {
+	mod := Module{ID: "db", Source: "test::db", Kind: TerraformKind, Outputs: []modulereader.OutputInfo{
+		{Name: "host"},
+		{Name: "password"},
+		{Name: "user", Description: "Login of the database"},
+		{Name: "missing"}}}
+	modulereader.SetModuleInfo(mod.Source, mod.Kind.String(), modulereader.ModuleInfo{
+		Outputs: []modulereader.OutputInfo{
+			{Name: "host", Description: "Address of the database"},
+			{Name: "password", Description: "Password of the database", Sensitive: true},
+			{Name: "user", Description: "User of the database"}}})
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{mod}}}}
+
+	bp.inheritOutputInfo()
+	c.Check(bp.DeploymentGroups[0].Modules[0].Outputs, DeepEquals, []modulereader.OutputInfo{
+		{Name: "host", Description: "Address of the database"},
+		{Name: "password", Description: "Password of the database", Sensitive: true},
+		{Name: "user", Description: "Login of the database"},
+		{Name: "missing"}})
 }
 
 func (s *MySuite) TestAddDefaultValidators(c *C) {
 	dc := getDeploymentConfigForTest()
 	dc.addDefaultValidators()
 	c.Assert(dc.Config.Validators, HasLen, 4)
+	// the credentials validator calls Google Cloud APIs and is only run if
+	// the blueprint enables it
+	for _, v := range dc.Config.Validators {
+		c.Check(v.Validator, Not(Equals), testCredentialsName.String())
+	}
 
 	dc.Config.Validators = nil
 	dc.Config.Vars.Set("region", cty.StringVal("us-central1"))
@@ -190,6 +230,566 @@ func (s *MySuite) TestAddDefaultValidators(c *C) {
 	dc.Config.Vars.Set("zone", cty.StringVal("us-central1-c"))
 	dc.addDefaultValidators()
 	c.Assert(dc.Config.Validators, HasLen, 7)
+
+	dc.Config.Validators = nil
+	dc.Config.DeploymentGroups[0].Modules[0].Settings.Set("cloudsql", cty.NullVal(cty.DynamicPseudoType))
+	dc.addDefaultValidators()
+	c.Assert(dc.Config.Validators, HasLen, 8)
+
+	dc.Config.Validators = nil
+	dc.Config.DeploymentGroups[0].Modules[0].Settings.Set("startup_script", cty.StringVal("echo hello"))
+	dc.addDefaultValidators()
+	c.Assert(dc.Config.Validators, HasLen, 9)
+
+	dc.Config.Validators = nil
+	dc.Config.DeploymentGroups[0].Modules[0].Source = "modules/compute/vm-instance"
+	dc.addDefaultValidators()
+	c.Assert(dc.Config.Validators, HasLen, 12)
+	c.Check(dc.Config.Validators[10].Validator, Equals, testSpotConfigurationName.String())
+	c.Check(dc.Config.Validators[11].Validator, Equals, testExternalIPsName.String())
+
+	// groups that override the project check that it exists
+	dc.Config.Validators = nil
+	dc.Config.DeploymentGroups[0].ProjectID = "service-project"
+	dc.addDefaultValidators()
+	c.Assert(dc.Config.Validators, HasLen, 13)
+	c.Check(dc.Config.Validators[3].Validator, Equals, testProjectExistsName.String())
+	c.Check(dc.Config.Validators[3].Inputs.Get("project_id"), DeepEquals, cty.StringVal("service-project"))
+
+	dc.Config.Validators = nil
+	dc.Config.DeploymentGroups[0].Modules[0].Settings.Set("machine_type", cty.StringVal("a3-highgpu-8g"))
+	dc.addDefaultValidators()
+	last := dc.Config.Validators[len(dc.Config.Validators)-1]
+	c.Check(last.Validator, Equals, testGPUNetworkingName.String())
+}
+
+func (s *MySuite) TestExplainValidators(c *C) {
+	dc := getDeploymentConfigForTest()
+	dc.Config.Vars.Set("region", cty.StringVal("us-central1"))
+	dc.Config.Validators = []validatorConfig{
+		{Validator: testModuleNotUsedName.String(), Skip: true},
+		{Validator: testCredentialsName.String(), Inputs: NewDict(map[string]cty.Value{
+			"project_id": GlobalRef("project_id").AsExpression().AsValue()})},
+	}
+	dc.Config.DeploymentGroups[0].Modules[0].RequiredApis = map[string][]string{
+		"$(vars.project_id)": {"compute.googleapis.com"}}
+	dc.addDefaultValidators()
+
+	explained := map[string]ValidatorExplanation{}
+	for _, e := range dc.ExplainValidators() {
+		explained[e.Validator] = e
+	}
+
+	e := explained[testModuleNotUsedName.String()]
+	c.Check(e.Reason, Equals, "")
+	c.Check(e.Skipped, Equals, true)
+
+	e = explained[testRegionExistsName.String()]
+	c.Check(e.Reason, Equals, "deployment variables project_id and region are set")
+	c.Check(e.Inputs, DeepEquals, []ExplainedInput{
+		{Name: "project_id", Expression: "var.project_id", Value: `"test-project"`},
+		{Name: "region", Expression: "var.region", Value: `"us-central1"`},
+	})
+	c.Check(e.APICalls, DeepEquals, []string{
+		"compute.zones.list and compute.regions.list project=test-project, shared by the zone and region validators of the project"})
+
+	e = explained[testApisEnabledName.String()]
+	c.Check(e.APICalls, DeepEquals, []string{
+		"serviceusage.services.batchGet project=test-project services=compute.googleapis.com"})
+
+	e = explained[testCredentialsName.String()]
+	c.Check(e.APICalls, DeepEquals, []string{
+		"an access token request for the credentials that Terraform uses, impersonating impersonate_service_account if set",
+		"cloudresourcemanager.projects.get project=test-project"})
+
+	e = explained[testDeploymentVariableNotUsedName.String()]
+	c.Check(e.APICalls, IsNil)
+}
+
+func (s *MySuite) TestKnownRunners(c *C) {
+	mod := Module{
+		ID:       "script",
+		Settings: NewDict(map[string]cty.Value{}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"script": cty.StringVal("echo hello")}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{mod}},
+		},
+	}
+	c.Check(knownRunners(mod, bp), HasLen, 0)
+
+	mod.Settings.Set("runners", cty.TupleVal([]cty.Value{
+		cty.ObjectVal(map[string]cty.Value{
+			"type":        cty.StringVal("shell"),
+			"destination": cty.StringVal("hello.sh"),
+			"content":     GlobalRef("script").AsExpression().AsValue(),
+		}),
+		// runners built from module outputs are not known before deployment
+		ModuleRef("install", "runner").AsExpression().AsValue(),
+		cty.ObjectVal(map[string]cty.Value{
+			"type":        cty.StringVal("ansible-local"),
+			"destination": cty.StringVal("play.yml"),
+			"source":      cty.StringVal("gs://bucket/play.yml"),
+		}),
+	}))
+	c.Check(knownRunners(mod, bp), DeepEquals, []validators.Runner{
+		{Module: "script", Type: "shell", Destination: "hello.sh", Content: "echo hello"},
+		{Module: "script", Type: "ansible-local", Destination: "play.yml", Source: "gs://bucket/play.yml"},
+	})
+
+	// a list of runners supplied by a module output is ignored
+	mod.Settings.Set("runners", ModuleRef("install", "runners").AsExpression().AsValue())
+	c.Check(knownRunners(mod, bp), HasLen, 0)
+}
+
+func (s *MySuite) TestKnownMetadataSize(c *C) {
+	mod := Module{
+		ID: "vm",
+		Settings: NewDict(map[string]cty.Value{
+			"startup_script": GlobalRef("script").AsExpression().AsValue(),
+			"metadata": cty.ObjectVal(map[string]cty.Value{
+				"key":   cty.StringVal("value"),
+				"other": ModuleRef("network", "name").AsExpression().AsValue(),
+			}),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"script": cty.StringVal("echo hello")}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{mod}},
+		},
+	}
+	// metadata depending upon module outputs is not counted
+	c.Check(knownMetadataSize(mod, bp), Equals, len("echo hello"))
+
+	mod.Settings.Set("metadata", cty.ObjectVal(map[string]cty.Value{
+		"key": cty.StringVal("value"),
+	}))
+	c.Check(knownMetadataSize(mod, bp), Equals, len("echo hello")+len("key")+len("value"))
+}
+
+func (s *MySuite) TestHostnameProblems(c *C) {
+	vm := Module{
+		ID:     "vm",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"deployment_name": GlobalRef("deployment_name").AsExpression().AsValue(),
+			"instance_count":  cty.NumberIntVal(10),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"deployment_name": cty.StringVal("hpc")}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{vm}},
+		},
+	}
+	c.Check(bp.hostnameProblems(vm), HasLen, 0)
+
+	{ // 62 characters plus "-9" is too long
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"name_prefix":    cty.StringVal(strings.Repeat("a", 62)),
+			"instance_count": cty.NumberIntVal(10),
+		})
+		c.Check(bp.hostnameProblems(vm), DeepEquals, []string{
+			fmt.Sprintf("VM hostname %q is 64 characters long; names are limited to 63 characters, shorten deployment_name or name_prefix",
+				strings.Repeat("a", 62)+"-9"),
+			fmt.Sprintf("boot disk name %q is 74 characters long; names are limited to 63 characters, shorten deployment_name or name_prefix",
+				strings.Repeat("a", 62)+"-boot-disk-9")})
+	}
+
+	{ // boot disks are named after the VM, and may be too long although it is not
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"name_prefix": cty.StringVal(strings.Repeat("a", 55)),
+		})
+		c.Check(bp.hostnameProblems(vm), DeepEquals, []string{fmt.Sprintf(
+			"boot disk name %q is 67 characters long; names are limited to 63 characters, shorten deployment_name or name_prefix",
+			strings.Repeat("a", 55)+"-boot-disk-0")})
+	}
+
+	{ // deployment name is prepended to the prefix
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"deployment_name":                   GlobalRef("deployment_name").AsExpression().AsValue(),
+			"name_prefix":                       cty.StringVal("Login"),
+			"add_deployment_name_before_prefix": cty.True,
+		})
+		c.Check(bp.hostnameProblems(vm), DeepEquals, []string{
+			`VM hostname "hpc-Login-0" must start with a lowercase letter and contain only lowercase letters, digits and hyphens (RFC 1035)`,
+			`boot disk name "hpc-Login-boot-disk-0" must start with a lowercase letter and contain only lowercase letters, digits and hyphens (RFC 1035)`})
+	}
+
+	{ // pbspro suffixes the deployment name
+		exec := Module{
+			ID:     "exec",
+			Source: "community/modules/compute/pbspro-execution",
+			Settings: NewDict(map[string]cty.Value{
+				"deployment_name": cty.StringVal("1pbs"),
+			}),
+		}
+		c.Check(bp.hostnameProblems(exec), DeepEquals, []string{
+			`VM hostname "1pbs-exec-0" must start with a lowercase letter and contain only lowercase letters, digits and hyphens (RFC 1035)`})
+	}
+
+	{ // Slurm nodes are named after the cluster, partition and node group
+		group := Module{
+			ID:     "group",
+			Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+			Settings: NewDict(map[string]cty.Value{
+				"name":              cty.StringVal("bignodes"),
+				"node_count_static": cty.NumberIntVal(100),
+			}),
+		}
+		partition := Module{
+			ID:     "partition",
+			Source: "community/modules/compute/schedmd-slurm-gcp-v5-partition",
+			Use:    []ModuleID{"group"},
+			Settings: NewDict(map[string]cty.Value{
+				"slurm_cluster_name": cty.StringVal(strings.Repeat("c", 45)),
+				"partition_name":     cty.StringVal("debug"),
+			}),
+		}
+		controller := Module{
+			ID:       "controller",
+			Source:   "community/modules/scheduler/schedmd-slurm-gcp-v5-controller",
+			Settings: NewDict(map[string]cty.Value{"slurm_cluster_name": cty.StringVal("Hpc")}),
+		}
+		bp := Blueprint{
+			Vars: bp.Vars,
+			DeploymentGroups: []DeploymentGroup{
+				{Name: "primary", Modules: []Module{group, partition, controller}},
+			},
+		}
+		name := strings.Repeat("c", 45) + "-debug-bignodes-109"
+		c.Check(bp.hostnameProblems(partition), DeepEquals, []string{fmt.Sprintf(
+			"VM hostname %q is 64 characters long; names are limited to 63 characters, "+
+				"shorten slurm_cluster_name, partition_name or the name of node group group", name)})
+		c.Check(bp.hostnameProblems(controller), DeepEquals, []string{
+			`VM hostname "Hpc-controller" must start with a lowercase letter and contain only lowercase letters, digits and hyphens (RFC 1035)`})
+	}
+
+	{ // settings depending upon module outputs are not checked
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"name_prefix": ModuleRef("network", "name").AsExpression().AsValue(),
+		})
+		c.Check(bp.hostnameProblems(vm), HasLen, 0)
+	}
+
+	{ // modules that do not name VMs are not checked
+		net := Module{ID: "net", Source: "modules/network/vpc"}
+		c.Check(bp.hostnameProblems(net), IsNil)
+	}
+}
+
+func (s *MySuite) TestPlacementProblems(c *C) {
+	net := Module{
+		ID:       "net",
+		Source:   "modules/network/vpc",
+		Settings: NewDict(map[string]cty.Value{"mtu": cty.NumberIntVal(1460)}),
+	}
+	compact := cty.ObjectVal(map[string]cty.Value{
+		"vm_count":                  cty.NullVal(cty.Number),
+		"availability_domain_count": cty.NullVal(cty.Number),
+		"collocation":               cty.StringVal("COLLOCATED"),
+	})
+	vm := Module{
+		ID:     "vm",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type":      cty.StringVal("c2-standard-60"),
+			"instance_count":    cty.NumberIntVal(4),
+			"placement_policy":  compact,
+			"network_self_link": ModuleRef("net", "network_self_link").AsExpression().AsValue(),
+		}),
+	}
+	group := Module{
+		ID:     "group",
+		Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type":           cty.StringVal("e2-standard-8"),
+			"node_count_dynamic_max": cty.NumberIntVal(200),
+		}),
+	}
+	partition := Module{
+		ID:     "partition",
+		Source: "community/modules/compute/schedmd-slurm-gcp-v5-partition",
+		Settings: NewDict(map[string]cty.Value{
+			"node_groups": cty.TupleVal([]cty.Value{ModuleRef("group", "node_groups").AsExpression().AsValue()}),
+		}),
+	}
+	bp := Blueprint{
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{net, vm, group, partition}},
+		},
+	}
+	with := func(m Module, k string, v cty.Value) Dict {
+		items := m.Settings.Items()
+		items[k] = v
+		return NewDict(items)
+	}
+	c.Check(bp.usesPlacementOrMTU(), Equals, true)
+
+	problems, warnings := bp.placementProblems(vm)
+	c.Check(problems, HasLen, 0)
+	c.Check(warnings, DeepEquals, []string{
+		"network net has an MTU of 1460; VMs with compact placement perform best with an MTU of 8896"})
+
+	{ // E2 VMs cannot use compact placement
+		vm := vm
+		vm.Settings = with(vm, "machine_type", cty.StringVal("e2-standard-8"))
+		problems, _ := bp.placementProblems(vm)
+		c.Check(problems, DeepEquals, []string{
+			"machine type e2-standard-8 does not support compact placement; use one of the a2, a3, c2, c2d, c3, c3d, g2, h3, n2, n2d families"})
+	}
+
+	{ // vm_count must match the number of VMs
+		vm := vm
+		vm.Settings = with(vm, "placement_policy", cty.ObjectVal(map[string]cty.Value{
+			"vm_count":                  cty.NumberIntVal(2),
+			"availability_domain_count": cty.NullVal(cty.Number),
+			"collocation":               cty.StringVal("COLLOCATED"),
+		}))
+		problems, _ := bp.placementProblems(vm)
+		c.Check(problems, HasLen, 1)
+		c.Check(problems[0], Matches, "placement_policy.vm_count is 2 but instance_count is 4;.*")
+	}
+
+	{ // spread placement is not checked
+		vm := vm
+		vm.Settings = with(vm, "placement_policy", cty.ObjectVal(map[string]cty.Value{
+			"vm_count":                  cty.NullVal(cty.Number),
+			"availability_domain_count": cty.NumberIntVal(2),
+			"collocation":               cty.NullVal(cty.String),
+		}))
+		problems, warnings := bp.placementProblems(vm)
+		c.Check(problems, HasLen, 0)
+		c.Check(warnings, HasLen, 0)
+	}
+
+	problems, warnings = bp.placementProblems(partition)
+	c.Check(problems, DeepEquals, []string{
+		"node group group: machine type e2-standard-8 does not support compact placement; use one of the a2, a3, c2, c2d, c3, c3d, g2, h3, n2, n2d families, or set enable_placement to false"})
+	c.Check(warnings, DeepEquals, []string{
+		"node group group has up to 200 nodes, but Slurm places at most 150 nodes in a placement group; larger jobs span several groups"})
+
+	{ // partitions without placement groups are not checked
+		partition := partition
+		partition.Settings = with(partition, "enable_placement", cty.False)
+		problems, warnings := bp.placementProblems(partition)
+		c.Check(problems, HasLen, 0)
+		c.Check(warnings, HasLen, 0)
+	}
+
+	problems, _ = bp.placementProblems(net)
+	c.Check(problems, HasLen, 0)
+	net.Settings = NewDict(map[string]cty.Value{"mtu": cty.NumberIntVal(9000)})
+	problems, _ = bp.placementProblems(net)
+	c.Check(problems, DeepEquals, []string{"mtu is 9000, but VPC networks accept 0 or an MTU from 1300 to 8896"})
+}
+
+func (s *MySuite) TestSpotProblems(c *C) {
+	vm := Module{
+		ID:     "vm",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"spot":         GlobalRef("spot").AsExpression().AsValue(),
+			"machine_type": cty.StringVal("c2-standard-60"),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"spot": cty.True}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{vm}},
+		},
+	}
+	with := func(k string, v cty.Value) Dict {
+		items := vm.Settings.Items()
+		items[k] = v
+		return NewDict(items)
+	}
+
+	problems, warnings := bp.spotProblems(vm)
+	c.Check(problems, HasLen, 0)
+	c.Check(warnings, HasLen, 0)
+	c.Check(bp.usesSpotModules(), Equals, true)
+
+	{ // Spot VMs cannot be live migrated
+		vm := vm
+		vm.Settings = with("on_host_maintenance", cty.StringVal("MIGRATE"))
+		problems, _ := bp.spotProblems(vm)
+		c.Check(problems, DeepEquals, []string{
+			"VMs with spot set cannot be live migrated; Compute Engine requires on_host_maintenance to be TERMINATE"})
+	}
+
+	{ // E2 VMs cannot attach local SSDs
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"machine_type":    cty.StringVal("e2-standard-8"),
+			"local_ssd_count": cty.NumberIntVal(1),
+		})
+		problems, _ := bp.spotProblems(vm)
+		c.Check(problems, DeepEquals, []string{
+			"machine type e2-standard-8 cannot attach local SSDs; set local_ssd_count to 0 or choose another machine type"})
+	}
+
+	{ // N1 VMs attach specific numbers of local SSDs
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"machine_type":    cty.StringVal("n1-standard-16"),
+			"local_ssd_count": cty.NumberIntVal(10),
+		})
+		problems, _ := bp.spotProblems(vm)
+		c.Check(problems, DeepEquals, []string{"N1 VMs can attach 1 to 8, 16 or 24 local SSDs, not 10"})
+	}
+
+	{ // preemptible Slurm controllers default to MIGRATE and run cluster services
+		ctrl := Module{
+			ID:       "slurm_controller",
+			Source:   "community/modules/scheduler/schedmd-slurm-gcp-v5-controller",
+			Settings: NewDict(map[string]cty.Value{"preemptible": cty.True}),
+		}
+		problems, warnings := bp.spotProblems(ctrl)
+		c.Check(problems, HasLen, 1)
+		c.Check(warnings, DeepEquals, []string{
+			"preemptible is set on VMs that run cluster services; they can be stopped at any time, interrupting every job and user of the cluster"})
+	}
+
+	{ // Slurm node groups ignore spot_instance_config without enable_spot_vm
+		ng := Module{
+			ID:     "node_group",
+			Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+			Settings: NewDict(map[string]cty.Value{
+				"spot_instance_config": cty.ObjectVal(map[string]cty.Value{"termination_action": cty.StringVal("STOP")}),
+			}),
+		}
+		problems, warnings := bp.spotProblems(ng)
+		c.Check(problems, HasLen, 0)
+		c.Check(warnings, DeepEquals, []string{"spot_instance_config has no effect unless enable_spot_vm is true"})
+	}
+
+	{ // settings depending upon module outputs are not checked
+		vm := vm
+		vm.Settings = with("on_host_maintenance", ModuleRef("policy", "maintenance").AsExpression().AsValue())
+		problems, _ := bp.spotProblems(vm)
+		c.Check(problems, HasLen, 0)
+	}
+}
+
+func (s *MySuite) TestSSHKeysModule(c *C) {
+	vm := Module{
+		ID:     "vm",
+		Source: "test::vm",
+		Kind:   TerraformKind,
+		Settings: NewDict(map[string]cty.Value{
+			"project_id": GlobalRef("project_id").AsExpression().AsValue(),
+			"metadata":   cty.ObjectVal(map[string]cty.Value{"ssh-keys": cty.StringVal("alice:ssh-ed25519 AAAA")}),
+		}),
+	}
+	modulereader.SetModuleInfo(vm.Source, vm.Kind.String(), modulereader.ModuleInfo{
+		Inputs: []modulereader.VarInfo{{Name: "enable_oslogin", Type: "string", Default: "ENABLE"}},
+	})
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"project_id": cty.StringVal("hpc-project")}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{vm}},
+		},
+	}
+	enabled, disabled := true, false
+	with := func(k string, v cty.Value) Dict {
+		items := vm.Settings.Items()
+		items[k] = v
+		return NewDict(items)
+	}
+
+	got, ok := bp.sshKeysModule(vm)
+	c.Check(ok, Equals, true)
+	c.Check(got, DeepEquals, validators.SSHKeysModule{Module: "vm", ProjectID: "hpc-project", OSLogin: &enabled})
+	c.Check(bp.setsSSHKeys(), Equals, true)
+
+	{ // VMs that inherit OS Login from the project
+		vm := vm
+		vm.Settings = with("enable_oslogin", cty.StringVal("INHERIT"))
+		got, _ := bp.sshKeysModule(vm)
+		c.Check(got.OSLogin, IsNil)
+	}
+
+	{ // metadata takes precedence over enable_oslogin
+		vm := vm
+		vm.Settings = with("metadata", cty.ObjectVal(map[string]cty.Value{
+			"sshKeys":        cty.StringVal("alice:ssh-ed25519 AAAA"),
+			"enable-oslogin": cty.StringVal("FALSE"),
+		}))
+		got, _ := bp.sshKeysModule(vm)
+		c.Check(got.OSLogin, DeepEquals, &disabled)
+	}
+
+	{ // modules without SSH keys are not checked
+		vm := vm
+		vm.Settings = with("metadata", cty.ObjectVal(map[string]cty.Value{"foo": cty.StringVal("bar")}))
+		_, ok := bp.sshKeysModule(vm)
+		c.Check(ok, Equals, false)
+	}
+
+	{ // metadata depending upon module outputs is not checked
+		vm := vm
+		vm.Settings = with("metadata", ModuleRef("keys", "metadata").AsExpression().AsValue())
+		_, ok := bp.sshKeysModule(vm)
+		c.Check(ok, Equals, false)
+	}
+}
+
+func (s *MySuite) TestSlurmAccountingProblems(c *C) {
+	sql := Module{
+		ID:     "sql",
+		Source: "test::sql",
+		Kind:   TerraformKind,
+		Settings: NewDict(map[string]cty.Value{
+			"nat_ips": ModuleRef("network", "nat_ips").AsExpression().AsValue(),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"db_password": cty.StringVal("")}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{sql}},
+		},
+	}
+	ref := ModuleRef("sql", "cloudsql").AsExpression().AsValue()
+
+	// database module is configured correctly
+	c.Check(bp.slurmAccountingProblems(ref), HasLen, 0)
+
+	// database module cannot be reached and has an empty password
+	bp.DeploymentGroups[0].Modules[0].Settings = NewDict(map[string]cty.Value{
+		"sql_password": GlobalRef("db_password").AsExpression().AsValue(),
+	})
+	c.Check(bp.slurmAccountingProblems(ref), HasLen, 2)
+
+	// unknown database module
+	c.Check(bp.slurmAccountingProblems(ModuleRef("nope", "cloudsql").AsExpression().AsValue()), HasLen, 1)
+
+	// explicit settings
+	explicit := cty.ObjectVal(map[string]cty.Value{
+		"server_ip": cty.StringVal("10.0.0.2"),
+		"user":      cty.StringVal("slurm"),
+		"password":  cty.StringVal("hunter2"),
+		"db_name":   cty.StringVal("slurm_accounting"),
+	})
+	c.Check(bp.slurmAccountingProblems(explicit), HasLen, 0)
+	c.Check(bp.slurmAccountingProblems(cty.NullVal(explicit.Type())), HasLen, 0)
+
+	incomplete := cty.ObjectVal(map[string]cty.Value{
+		"server_ip": cty.StringVal("10.0.0.2"),
+		"user":      cty.StringVal("slurm"),
+		"password":  GlobalRef("db_password").AsExpression().AsValue(),
+	})
+	c.Check(bp.slurmAccountingProblems(incomplete), DeepEquals, []string{
+		"cloudsql.password must be a non-empty string",
+		"cloudsql.db_name is not set",
+	})
+	c.Check(bp.slurmAccountingProblems(cty.StringVal("10.0.0.2")), HasLen, 1)
 }
 
 func (s *MySuite) TestMergeBlueprintRequirements(c *C) {
@@ -244,14 +844,86 @@ func (s *MySuite) TestExecuteValidators(c *C) {
 	dc.Config.Validators = []validatorConfig{
 		{Validator: "unimplemented-validator"}}
 
-	err := dc.executeValidators()
+	err := dc.executeValidators(context.Background())
 	c.Assert(err, ErrorMatches, validationErrorMsg)
 
 	dc.Config.Validators = []validatorConfig{
 		{Validator: testProjectExistsName.String()}}
 
-	err = dc.executeValidators()
+	err = dc.executeValidators(context.Background())
 	c.Assert(err, ErrorMatches, validationErrorMsg)
+
+	// cancelled validation is an error whatever the validation level
+	dc.Config.ValidationLevel = ValidationWarning
+	dc.Config.Validators = []validatorConfig{
+		{Validator: testModuleNotUsedName.String()}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = dc.executeValidators(ctx)
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+}
+
+func (s *MySuite) TestFailedProjects(c *C) {
+	bp := Blueprint{Vars: NewDict(map[string]cty.Value{"project_id": cty.StringVal("p1")})}
+	inProject := func(name string, p cty.Value) validatorConfig {
+		return validatorConfig{Validator: name, Inputs: NewDict(map[string]cty.Value{"project_id": p})}
+	}
+	p1 := GlobalRef("project_id").AsExpression().AsValue()
+	p2 := cty.StringVal("p2")
+
+	var f failedProjects
+	f.record(inProject(testProjectExistsName.String(), p1), bp)
+	f.record(inProject(testApisEnabledName.String(), p2), bp) // not recorded
+
+	p, skip := f.skips(inProject(testRegionExistsName.String(), p1), bp)
+	c.Check(skip, Equals, true)
+	c.Check(p, Equals, "p1")
+	_, skip = f.skips(inProject(testRegionExistsName.String(), p2), bp)
+	c.Check(skip, Equals, false)
+	_, skip = f.skips(validatorConfig{Validator: testModuleNotUsedName.String()}, bp)
+	c.Check(skip, Equals, false)
+
+	// credentials that failed without a project fail every project
+	f.record(validatorConfig{Validator: testCredentialsName.String()}, bp)
+	_, skip = f.skips(inProject(testRegionExistsName.String(), p2), bp)
+	c.Check(skip, Equals, true)
+	_, skip = f.skips(validatorConfig{Validator: testModuleNotUsedName.String()}, bp)
+	c.Check(skip, Equals, false)
+}
+
+func (s *MySuite) TestRunValidatorTimeout(c *C) {
+	ctx := context.Background()
+	fast := func(context.Context, validatorConfig) error { return errors.New("fast failure") }
+	aborted := make(chan bool, 2)
+	slow := func(ctx context.Context, _ validatorConfig) error {
+		select {
+		case <-time.After(time.Second):
+			aborted <- false
+		case <-ctx.Done():
+			aborted <- true
+		}
+		return nil
+	}
+	v := validatorConfig{Validator: "test_slow", Timeout: 10 * time.Millisecond}
+
+	// errors of validators that complete in time are returned as-is
+	c.Assert(runValidator(ctx, fast, v), ErrorMatches, "fast failure")
+
+	// validators exceeding their own timeout
+	var timeoutErr *ValidatorTimeoutError
+	err := runValidator(ctx, slow, v)
+	c.Assert(errors.As(err, &timeoutErr), Equals, true)
+	c.Check(err, ErrorMatches, "validator test_slow did not complete within 10ms")
+	c.Check(<-aborted, Equals, true)
+
+	// validators exceeding the overall deadline
+	v.Timeout = time.Minute
+	dctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = runValidator(dctx, slow, v)
+	c.Assert(errors.As(err, &timeoutErr), Equals, true)
+	c.Check(err, ErrorMatches, "validator test_slow did not complete before the validation deadline.*")
+	c.Check(<-aborted, Equals, true)
 }
 
 func (s *MySuite) TestApisEnabledValidator(c *C) {
@@ -260,7 +932,7 @@ func (s *MySuite) TestApisEnabledValidator(c *C) {
 	emptyValidator := validatorConfig{}
 
 	// test validator fails for config without validator id
-	err = dc.testApisEnabled(emptyValidator)
+	err = dc.testApisEnabled(context.Background(), emptyValidator)
 	c.Assert(err, ErrorMatches, passedWrongValidatorRegex)
 
 	apisEnabledValidator := validatorConfig{
@@ -270,12 +942,12 @@ func (s *MySuite) TestApisEnabledValidator(c *C) {
 	// Deployment Config is empty; no actual API calls get made in this case.
 	// When full automation of required API detection is implemented, we may
 	// need to modify this test
-	err = dc.testApisEnabled(apisEnabledValidator)
+	err = dc.testApisEnabled(context.Background(), apisEnabledValidator)
 	c.Assert(err, IsNil)
 
 	// this validator reads blueprint directly so 1 inputs should fail
 	apisEnabledValidator.Inputs.Set("foo", cty.StringVal("bar"))
-	err = dc.testApisEnabled(apisEnabledValidator)
+	err = dc.testApisEnabled(context.Background(), apisEnabledValidator)
 	c.Assert(err, ErrorMatches, tooManyInputRegex)
 }
 
@@ -289,17 +961,17 @@ func (s *MySuite) TestProjectExistsValidator(c *C) {
 	emptyValidator := validatorConfig{}
 
 	// test validator fails for config without validator id
-	err = dc.testProjectExists(emptyValidator)
+	err = dc.testProjectExists(context.Background(), emptyValidator)
 	c.Assert(err, ErrorMatches, passedWrongValidatorRegex)
 
 	// test validator fails for config without any inputs
 	projectValidator := validatorConfig{Validator: testProjectExistsName.String()}
-	err = dc.testProjectExists(projectValidator)
+	err = dc.testProjectExists(context.Background(), projectValidator)
 	c.Assert(err, ErrorMatches, missingRequiredInputRegex)
 
 	// test validators fail when input global variables are undefined
 	projectValidator.Inputs.Set("project_id", MustParseExpression("var.undefined").AsValue())
-	c.Assert(dc.testProjectExists(projectValidator), NotNil)
+	c.Assert(dc.testProjectExists(context.Background(), projectValidator), NotNil)
 
 	// TODO: implement a mock client to test success of test_project_exists
 }
@@ -310,22 +982,22 @@ func (s *MySuite) TestRegionExistsValidator(c *C) {
 	emptyValidator := validatorConfig{}
 
 	// test validator fails for config without validator id
-	err = dc.testRegionExists(emptyValidator)
+	err = dc.testRegionExists(context.Background(), emptyValidator)
 	c.Assert(err, ErrorMatches, passedWrongValidatorRegex)
 
 	// test validator fails for config without any inputs
 	regionValidator := validatorConfig{Validator: testRegionExistsName.String()}
-	err = dc.testRegionExists(regionValidator)
+	err = dc.testRegionExists(context.Background(), regionValidator)
 	c.Assert(err, ErrorMatches, missingRequiredInputRegex)
 
 	// test validators fail when input global variables are undefined
 	regionValidator.Inputs.
 		Set("project_id", MustParseExpression("var.project_id").AsValue()).
 		Set("region", MustParseExpression("var.region").AsValue())
-	c.Assert(dc.testRegionExists(regionValidator), NotNil)
+	c.Assert(dc.testRegionExists(context.Background(), regionValidator), NotNil)
 
 	dc.Config.Vars.Set("project_id", cty.StringVal("invalid-project"))
-	c.Assert(dc.testRegionExists(regionValidator), NotNil)
+	c.Assert(dc.testRegionExists(context.Background(), regionValidator), NotNil)
 
 	// TODO: implement a mock client to test success of test_region_exists
 }
@@ -336,22 +1008,22 @@ func (s *MySuite) TestZoneExistsValidator(c *C) {
 	emptyValidator := validatorConfig{}
 
 	// test validator fails for config without validator id
-	err = dc.testZoneExists(emptyValidator)
+	err = dc.testZoneExists(context.Background(), emptyValidator)
 	c.Assert(err, ErrorMatches, passedWrongValidatorRegex)
 
 	// test validator fails for config without any inputs
 	zoneValidator := validatorConfig{Validator: testZoneExistsName.String()}
-	err = dc.testZoneExists(zoneValidator)
+	err = dc.testZoneExists(context.Background(), zoneValidator)
 	c.Assert(err, ErrorMatches, missingRequiredInputRegex)
 
 	// test validators fail when input global variables are undefined
 	zoneValidator.Inputs.
 		Set("project_id", MustParseExpression("var.project_id").AsValue()).
 		Set("zone", MustParseExpression("var.zone").AsValue())
-	c.Assert(dc.testZoneExists(zoneValidator), NotNil)
+	c.Assert(dc.testZoneExists(context.Background(), zoneValidator), NotNil)
 
 	dc.Config.Vars.Set("project_id", cty.StringVal("invalid-project"))
-	c.Assert(dc.testZoneExists(zoneValidator), NotNil)
+	c.Assert(dc.testZoneExists(context.Background(), zoneValidator), NotNil)
 
 	// TODO: implement a mock client to test success of test_zone_exists
 }
@@ -362,12 +1034,12 @@ func (s *MySuite) TestZoneInRegionValidator(c *C) {
 	emptyValidator := validatorConfig{}
 
 	// test validator fails for config without validator id
-	err = dc.testZoneInRegion(emptyValidator)
+	err = dc.testZoneInRegion(context.Background(), emptyValidator)
 	c.Assert(err, ErrorMatches, passedWrongValidatorRegex)
 
 	// test validator fails for config without any inputs
 	zoneInRegionValidator := validatorConfig{Validator: testZoneInRegionName.String()}
-	err = dc.testZoneInRegion(zoneInRegionValidator)
+	err = dc.testZoneInRegion(context.Background(), zoneInRegionValidator)
 	c.Assert(err, ErrorMatches, missingRequiredInputRegex)
 
 	// test validators fail when input global variables are undefined
@@ -375,13 +1047,790 @@ func (s *MySuite) TestZoneInRegionValidator(c *C) {
 		Set("project_id", MustParseExpression("var.project_id").AsValue()).
 		Set("region", MustParseExpression("var.region").AsValue()).
 		Set("zone", MustParseExpression("var.zone").AsValue())
-	c.Assert(dc.testZoneInRegion(zoneInRegionValidator), NotNil)
+	c.Assert(dc.testZoneInRegion(context.Background(), zoneInRegionValidator), NotNil)
 
 	dc.Config.Vars.Set("project_id", cty.StringVal("invalid-project"))
-	c.Assert(dc.testZoneInRegion(zoneInRegionValidator), NotNil)
+	c.Assert(dc.testZoneInRegion(context.Background(), zoneInRegionValidator), NotNil)
 
 	dc.Config.Vars.Set("zone", cty.StringVal("invalid-zone"))
-	c.Assert(dc.testZoneInRegion(zoneInRegionValidator), NotNil)
+	c.Assert(dc.testZoneInRegion(context.Background(), zoneInRegionValidator), NotNil)
 
 	// TODO: implement a mock client to test success of test_zone_in_region
 }
+
+func (s *MySuite) TestOpsAgentModule(c *C) {
+	script := Module{
+		ID:       "script",
+		Source:   "modules/scripts/startup-script",
+		Settings: NewDict(map[string]cty.Value{"install_cloud_ops_agent": GlobalRef("ops_agent").AsExpression().AsValue()}),
+	}
+	vm := Module{
+		ID:     "vm",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"startup_script": ModuleRef("script", "startup_script").AsExpression().AsValue(),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("test-project"),
+			"ops_agent":  cty.True,
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{script, vm}},
+		},
+	}
+	c.Check(bp.installsOpsAgent(), Equals, true)
+
+	om, ok := bp.opsAgentModule(vm)
+	c.Check(ok, Equals, true)
+	c.Check(om, DeepEquals, validators.OpsAgentModule{
+		Module: "vm", ProjectID: "test-project", Scripts: []string{"script"}})
+
+	{ // the service account must be able to write logs and metrics
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"startup_script": ModuleRef("script", "startup_script").AsExpression().AsValue(),
+			"service_account": cty.ObjectVal(map[string]cty.Value{
+				"email": cty.NullVal(cty.String),
+				"scopes": cty.SetVal([]cty.Value{
+					cty.StringVal("https://www.googleapis.com/auth/monitoring.write"),
+				}),
+			}),
+		})
+		om, ok := bp.opsAgentModule(vm)
+		c.Check(ok, Equals, true)
+		c.Check(om.MissingScopes, DeepEquals, []string{"https://www.googleapis.com/auth/logging.write"})
+	}
+
+	{ // the script is ignored unless it installs the agent
+		bp := bp
+		bp.Vars = NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("test-project"),
+			"ops_agent":  cty.False,
+		})
+		c.Check(bp.installsOpsAgent(), Equals, false)
+		_, ok := bp.opsAgentModule(vm)
+		c.Check(ok, Equals, false)
+	}
+
+	{ // modules that do not run the script are not checked
+		_, ok := bp.opsAgentModule(script)
+		c.Check(ok, Equals, false)
+	}
+}
+
+func (s *MySuite) TestContainerImageModule(c *C) {
+	pool := Module{
+		ID:     "pool",
+		Source: "community/modules/compute/gke-node-pool",
+		Settings: NewDict(map[string]cty.Value{
+			"service_account": cty.ObjectVal(map[string]cty.Value{
+				"email":  cty.StringVal("nodes@test-project.iam.gserviceaccount.com"),
+				"scopes": cty.SetVal([]cty.Value{cty.StringVal("https://www.googleapis.com/auth/cloud-platform")}),
+			}),
+		}),
+	}
+	defaultPool := Module{ID: "default_pool", Source: "community/modules/compute/gke-node-pool"}
+	job := Module{
+		ID:     "job",
+		Source: "community/modules/compute/gke-job-template",
+		Settings: NewDict(map[string]cty.Value{
+			"image": GlobalRef("image").AsExpression().AsValue(),
+			"node_pool_name": cty.TupleVal([]cty.Value{
+				ModuleRef("pool", "node_pool_name").AsExpression().AsValue(),
+				ModuleRef("default_pool", "node_pool_name").AsExpression().AsValue(),
+			}),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("test-project"),
+			"image":      cty.StringVal("us-docker.pkg.dev/test-project/jobs/solver:v1"),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{pool, defaultPool, job}},
+		},
+	}
+	c.Check(bp.usesContainerImages(), Equals, true)
+
+	cm, ok := bp.containerImageModule(job)
+	c.Check(ok, Equals, true)
+	c.Check(cm, DeepEquals, validators.ContainerImageModule{
+		Module:    "job",
+		ProjectID: "test-project",
+		Images:    []string{"us-docker.pkg.dev/test-project/jobs/solver:v1"},
+		ServiceAccounts: []string{
+			validators.DefaultComputeServiceAccount,
+			"nodes@test-project.iam.gserviceaccount.com",
+		},
+	})
+
+	{ // images that depend upon module outputs are not checked
+		job := job
+		job.Settings = NewDict(map[string]cty.Value{
+			"image": ModuleRef("build", "image").AsExpression().AsValue(),
+		})
+		_, ok := bp.containerImageModule(job)
+		c.Check(ok, Equals, false)
+	}
+
+	{ // modules that do not run containers are not checked
+		_, ok := bp.containerImageModule(pool)
+		c.Check(ok, Equals, false)
+	}
+}
+
+func (s *MySuite) TestQuotaDemand(c *C) {
+	vm := Module{
+		ID:     "vm",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"instance_count": GlobalRef("count").AsExpression().AsValue(),
+			"machine_type":   cty.StringVal("n2-standard-8"),
+		}),
+	}
+	nodes := Module{
+		ID:     "nodes",
+		Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+		Settings: NewDict(map[string]cty.Value{
+			"node_count_static": cty.NumberIntVal(2),
+			"zone":              cty.StringVal("us-east1-b"),
+		}),
+	}
+	controller := Module{ID: "controller", Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-controller"}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("test-project"),
+			"zone":       cty.StringVal("us-central1-a"),
+			"count":      cty.NumberIntVal(4),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{vm, nodes, controller}},
+		},
+	}
+
+	d, ok := bp.quotaDemand(vm)
+	c.Check(ok, Equals, true)
+	c.Check(d, DeepEquals, quota.Demand{
+		Module: "vm", ProjectID: "test-project", Zone: "us-central1-a", MachineType: "n2-standard-8", Count: 4})
+
+	// static and dynamic nodes, with module defaults
+	d, ok = bp.quotaDemand(nodes)
+	c.Check(ok, Equals, true)
+	c.Check(d, DeepEquals, quota.Demand{
+		Module: "nodes", ProjectID: "test-project", Zone: "us-east1-b", MachineType: "c2-standard-60", Count: 12})
+
+	d, ok = bp.quotaDemand(controller)
+	c.Check(ok, Equals, true)
+	c.Check(d.Count, Equals, int64(1))
+
+	{ // counts that depend upon module outputs are not checked
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"instance_count": ModuleRef("sizer", "count").AsExpression().AsValue(),
+		})
+		_, ok := bp.quotaDemand(vm)
+		c.Check(ok, Equals, false)
+	}
+
+	{ // modules that create no VMs are not checked
+		_, ok := bp.quotaDemand(Module{ID: "net", Source: "modules/network/vpc"})
+		c.Check(ok, Equals, false)
+	}
+}
+
+func (s *MySuite) TestDiskModule(c *C) {
+	vm := Module{
+		ID:     "vm",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"disk_size_gb":    cty.NumberIntVal(20),
+			"local_ssd_count": cty.NumberIntVal(3),
+			"machine_type":    cty.StringVal("c2-standard-8"),
+			"instance_image": cty.ObjectVal(map[string]cty.Value{
+				"name":    cty.StringVal("solver-image"),
+				"project": GlobalRef("project_id").AsExpression().AsValue(),
+			}),
+		}),
+	}
+	login := Module{ID: "login", Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-login"}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"project_id": cty.StringVal("test-project")}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{vm, login}},
+		},
+	}
+	c.Check(bp.setsDiskSizes(), Equals, true)
+
+	dm, ok := bp.diskModule(vm)
+	c.Check(ok, Equals, true)
+	c.Check(dm, DeepEquals, validators.DiskModule{
+		Module:        "vm",
+		ImageProject:  "test-project",
+		ImageName:     "solver-image",
+		DiskSizeGB:    20,
+		MachineType:   "c2-standard-8",
+		LocalSSDCount: 3,
+	})
+
+	// module defaults, with the project of Slurm images given as a path
+	dm, ok = bp.diskModule(login)
+	c.Check(ok, Equals, true)
+	c.Check(dm, DeepEquals, validators.DiskModule{
+		Module:       "login",
+		ImageProject: "schedmd-slurm-public",
+		ImageFamily:  "slurm-gcp-5-7-hpc-centos-7",
+		DiskSizeGB:   50,
+		MachineType:  "n2-standard-2",
+	})
+
+	{ // images that depend upon module outputs are not checked
+		vm := vm
+		vm.Settings = NewDict(map[string]cty.Value{
+			"instance_image": ModuleRef("builder", "image").AsExpression().AsValue(),
+		})
+		dm, ok := bp.diskModule(vm)
+		c.Check(ok, Equals, true)
+		c.Check(dm.ImageProject, Equals, "")
+	}
+
+	{ // modules that create no VMs are not checked
+		_, ok := bp.diskModule(Module{ID: "net", Source: "modules/network/vpc"})
+		c.Check(ok, Equals, false)
+	}
+}
+
+func (s *MySuite) TestBatchModule(c *C) {
+	job := Module{ID: "job", Source: "modules/scheduler/batch-job-template"}
+	custom := Module{
+		ID:     "custom",
+		Source: "modules/scheduler/batch-job-template",
+		Settings: NewDict(map[string]cty.Value{
+			"service_account": cty.ObjectVal(map[string]cty.Value{
+				"email":  cty.StringVal("jobs@test-project.iam.gserviceaccount.com"),
+				"scopes": cty.SetVal([]cty.Value{cty.StringVal("https://www.googleapis.com/auth/cloud-platform")}),
+			}),
+		}),
+	}
+	templated := Module{
+		ID:     "templated",
+		Source: "modules/scheduler/batch-job-template",
+		Settings: NewDict(map[string]cty.Value{
+			"instance_template": ModuleRef("template", "self_link").AsExpression().AsValue(),
+		}),
+	}
+	login := Module{ID: "login", Source: "modules/scheduler/batch-login-node"}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"project_id": cty.StringVal("test-project")}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{job, custom, templated, login}},
+		},
+	}
+	c.Check(bp.usesBatch(), Equals, true)
+
+	bm, ok := bp.batchModule(job)
+	c.Check(ok, Equals, true)
+	c.Check(bm, DeepEquals, validators.BatchModule{
+		Module: "job", ProjectID: "test-project", ServiceAccount: validators.DefaultComputeServiceAccount})
+
+	bm, ok = bp.batchModule(custom)
+	c.Check(ok, Equals, true)
+	c.Check(bm.ServiceAccount, Equals, "jobs@test-project.iam.gserviceaccount.com")
+
+	// the service account of instance templates is not known
+	bm, ok = bp.batchModule(templated)
+	c.Check(ok, Equals, true)
+	c.Check(bm.ServiceAccount, Equals, "")
+
+	_, ok = bp.batchModule(login)
+	c.Check(ok, Equals, false)
+
+	bp.DeploymentGroups[0].Modules = []Module{login}
+	c.Check(bp.usesBatch(), Equals, false)
+}
+
+func (s *MySuite) TestCMEKModule(c *C) {
+	key := "projects/kms-project/locations/us-central1/keyRings/ring/cryptoKeys/key"
+	disk := Module{
+		ID:     "disk",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"disk_kms_key_name": cty.StringVal(key),
+			"zone":              cty.StringVal("us-east4-a"),
+		}),
+	}
+	filestore := Module{
+		ID:     "homefs",
+		Source: "modules/file-system/filestore",
+		Settings: NewDict(map[string]cty.Value{
+			"kms_key_name": GlobalRef("key").AsExpression().AsValue(),
+		}),
+	}
+	unknown := Module{
+		ID:     "unknown",
+		Source: "modules/file-system/filestore",
+		Settings: NewDict(map[string]cty.Value{
+			"kms_key_name": ModuleRef("kms", "key_id").AsExpression().AsValue(),
+		}),
+	}
+	plain := Module{ID: "plain", Source: "modules/compute/vm-instance"}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("test-project"),
+			"region":     cty.StringVal("us-central1"),
+			"key":        cty.StringVal(key),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{disk, filestore, unknown, plain}},
+		},
+	}
+	c.Check(bp.usesCMEK(), Equals, true)
+
+	// the region of a zonal module is that of its zone
+	cm, ok := bp.cmekModule(disk)
+	c.Check(ok, Equals, true)
+	c.Check(cm, DeepEquals, validators.CMEKModule{
+		Module: "disk", ProjectID: "test-project", Region: "us-east4", Key: key})
+
+	cm, ok = bp.cmekModule(filestore)
+	c.Check(ok, Equals, true)
+	c.Check(cm, DeepEquals, validators.CMEKModule{
+		Module: "homefs", ProjectID: "test-project", Region: "us-central1", Key: key, Filestore: true})
+
+	// keys created by other modules are not known before deployment
+	_, ok = bp.cmekModule(unknown)
+	c.Check(ok, Equals, false)
+
+	_, ok = bp.cmekModule(plain)
+	c.Check(ok, Equals, false)
+
+	bp.DeploymentGroups[0].Modules = []Module{plain}
+	c.Check(bp.usesCMEK(), Equals, false)
+}
+
+func (s *MySuite) TestExecValidator(c *C) {
+	dc := getDeploymentConfigForTest()
+	dc.Config.Vars.Set("license_server", cty.StringVal("lic.example.com"))
+
+	c.Check(dc.testExec(context.Background(), validatorConfig{}), ErrorMatches, passedWrongValidatorRegex)
+
+	v := validatorConfig{Validator: execName.String()}
+	c.Check(dc.testExec(context.Background(), v), ErrorMatches, missingRequiredInputRegex)
+
+	v.Inputs.
+		Set("command", cty.StringVal("sh")).
+		Set("args", cty.TupleVal([]cty.Value{cty.StringVal("-c"), cty.StringVal(`test "$SERVER" = lic.example.com`)})).
+		Set("env", cty.ObjectVal(map[string]cty.Value{
+			"SERVER": GlobalRef("license_server").AsExpression().AsValue()}))
+	c.Check(dc.testExec(context.Background(), v), IsNil)
+
+	// a nonzero exit status fails the validator
+	v.Inputs.Set("args", cty.TupleVal([]cty.Value{cty.StringVal("-c"), cty.StringVal("exit 3")}))
+	c.Check(dc.testExec(context.Background(), v), ErrorMatches, "validator exec failed")
+
+	// relative commands are run from the blueprint directory
+	dc.blueprintDir = c.MkDir()
+	c.Assert(os.WriteFile(filepath.Join(dc.blueprintDir, "check.sh"), []byte("#!/bin/sh\ntest -f check.sh\n"), 0755), IsNil)
+	rel := validatorConfig{Validator: execName.String()}
+	rel.Inputs.Set("command", cty.StringVal("./check.sh"))
+	c.Check(dc.testExec(context.Background(), rel), IsNil)
+
+	v.Inputs.Set("args", cty.StringVal("-c"))
+	c.Check(dc.testExec(context.Background(), v), ErrorMatches, "input args of exec must be a list of strings")
+
+	v.Inputs.Set("timeout", cty.StringVal("1m"))
+	c.Check(dc.testExec(context.Background(), v), ErrorMatches, "validator exec does not accept input timeout.*")
+
+	c.Check(dc.Config.HasExecValidators(), Equals, false)
+	dc.Config.Validators = append(dc.Config.Validators, v)
+	c.Check(dc.Config.HasExecValidators(), Equals, true)
+}
+
+func (s *MySuite) TestLocationProjects(c *C) {
+	dc := getDeploymentConfigForTest()
+	dc.Config.Vars.Set("project_id", cty.StringVal("test-project"))
+	zone := func(project cty.Value, skip bool) validatorConfig {
+		v := validatorConfig{Validator: testZoneExistsName.String(), Skip: skip}
+		v.Inputs.Set("project_id", project).Set("zone", cty.StringVal("us-central1-a"))
+		return v
+	}
+	dc.Config.Validators = []validatorConfig{
+		zone(GlobalRef("project_id").AsExpression().AsValue(), false),
+		zone(cty.StringVal("test-project"), false),
+		zone(cty.StringVal("other-project"), false),
+		zone(cty.StringVal("skipped-project"), true),
+		zone(ModuleRef("project", "project_id").AsExpression().AsValue(), false),
+		{Validator: testModuleNotUsedName.String()},
+	}
+	c.Check(dc.locationProjects(), DeepEquals, []string{"test-project", "other-project"})
+}
+
+func (s *MySuite) TestZoneModule(c *C) {
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("test-project"),
+			"zone":       cty.StringVal("us-central1-a"),
+		}),
+		DeploymentGroups: []DeploymentGroup{{Name: "zero", Modules: []Module{
+			{ID: "net"},
+			{ID: "var", Settings: NewDict(map[string]cty.Value{"zone": GlobalRef("zone").AsExpression().AsValue()})},
+			{ID: "same", Settings: NewDict(map[string]cty.Value{"zone": cty.StringVal("us-central1-a")})},
+			{ID: "other", Settings: NewDict(map[string]cty.Value{"zone": cty.StringVal("us-central1-z")})},
+			{ID: "elsewhere", Settings: NewDict(map[string]cty.Value{
+				"zone":       cty.StringVal("us-central1-a"),
+				"project_id": cty.StringVal("other-project"),
+			})},
+			{ID: "output", Settings: NewDict(map[string]cty.Value{
+				"zone": ModuleRef("net", "zone").AsExpression().AsValue(),
+			})},
+		}}},
+	}
+	found := []validators.ZoneModule{}
+	bp.WalkModules(func(m *Module) error {
+		if zm, ok := bp.zoneModule(*m); ok {
+			found = append(found, zm)
+		}
+		return nil
+	})
+	c.Check(found, DeepEquals, []validators.ZoneModule{
+		{Module: "other", ProjectID: "test-project", Zone: "us-central1-z"},
+		{Module: "elsewhere", ProjectID: "other-project", Zone: "us-central1-a"},
+	})
+	c.Check(bp.setsModuleZones(), Equals, true)
+
+	dc := DeploymentConfig{Config: bp}
+	dc.Config.Validators = []validatorConfig{{Validator: testModuleZonesName.String()}}
+	c.Check(dc.locationProjects(), DeepEquals, []string{"test-project", "other-project"})
+	dc.Config.Validators[0].IgnoreModules = []ModuleID{"elsewhere"}
+	c.Check(dc.locationProjects(), DeepEquals, []string{"test-project"})
+
+	bp.DeploymentGroups[0].Modules = bp.DeploymentGroups[0].Modules[:3]
+	c.Check(bp.setsModuleZones(), Equals, false)
+}
+
+func (s *MySuite) TestSubnetCapacityWarnings(c *C) {
+	netRef := ModuleRef("net", "subnetwork_self_link").AsExpression().AsValue()
+	net := Module{ID: "net", Source: "modules/network/vpc"}
+	vm := Module{
+		ID:       "login",
+		Source:   "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{"instance_count": cty.NumberIntVal(2), "subnetwork_self_link": netRef}),
+	}
+	group := Module{
+		ID:     "group",
+		Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+		Settings: NewDict(map[string]cty.Value{
+			"node_count_static":      cty.NumberIntVal(50),
+			"node_count_dynamic_max": cty.NumberIntVal(200),
+		}),
+	}
+	partition := Module{
+		ID:     "partition",
+		Source: "community/modules/compute/schedmd-slurm-gcp-v5-partition",
+		Settings: NewDict(map[string]cty.Value{
+			"subnetwork_self_link": netRef,
+			"node_groups":          cty.TupleVal([]cty.Value{ModuleRef("group", "node_groups").AsExpression().AsValue()}),
+		}),
+	}
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "primary", Modules: []Module{net, vm, group, partition}},
+	}}
+	none := func(Module) bool { return false }
+	c.Check(bp.usesSubnetCapacity(), Equals, true)
+
+	// the default primary subnetwork is 10.0.0.0/24
+	cidr, usable, ok := bp.primarySubnetwork(net)
+	c.Check([]interface{}{cidr, usable, ok}, DeepEquals, []interface{}{"10.0.0.0/24", int64(252), true})
+	// exactly full
+	c.Check(bp.subnetCapacityWarnings(none), HasLen, 0)
+
+	bp.DeploymentGroups[0].Modules[1].Settings.Set("instance_count", cty.NumberIntVal(3))
+	warnings := bp.subnetCapacityWarnings(none)
+	c.Assert(warnings["net"], HasLen, 1)
+	c.Check(warnings["net"][0], Matches, "up to 253 VMs of login \\(3\\), partition \\(250\\) may be created in primary subnetwork 10.0.0.0/24, which has 252 usable addresses.*")
+	c.Check(bp.subnetCapacityWarnings(func(m Module) bool { return m.ID == "login" }), HasLen, 0)
+
+	// the first subnetwork is the primary subnetwork
+	subnets := cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+		"subnet_name":   cty.StringVal("primary"),
+		"subnet_region": cty.StringVal("us-central1"),
+		"new_bits":      cty.NumberIntVal(7),
+	})})
+	bp.DeploymentGroups[0].Modules[0].Settings.Set("subnetworks", subnets)
+	cidr, usable, _ = bp.primarySubnetwork(bp.DeploymentGroups[0].Modules[0])
+	c.Check([]interface{}{cidr, usable}, DeepEquals, []interface{}{"10.0.0.0/16", int64(65532)})
+	c.Check(bp.subnetCapacityWarnings(none), HasLen, 0)
+
+	subnets = cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+		"subnet_ip": cty.StringVal("192.168.0.0/28"),
+	})})
+	bp.DeploymentGroups[0].Modules[0].Settings.Set("subnetworks", subnets)
+	cidr, usable, _ = bp.primarySubnetwork(bp.DeploymentGroups[0].Modules[0])
+	c.Check([]interface{}{cidr, usable}, DeepEquals, []interface{}{"192.168.0.0/28", int64(12)})
+}
+
+func (s *MySuite) TestGPUModule(c *C) {
+	t4 := Module{
+		ID:     "t4",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type": cty.StringVal("n1-standard-8"),
+			"guest_accelerator": cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+				"type":  cty.StringVal("nvidia-tesla-t4"),
+				"count": cty.NumberIntVal(1),
+			})}),
+			"instance_image": cty.ObjectVal(map[string]cty.Value{
+				"family":  cty.StringVal("common-cu113"),
+				"project": cty.StringVal("deeplearning-platform-release"),
+			}),
+		}),
+	}
+	a2 := Module{
+		ID:     "a2",
+		Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type": cty.StringVal("a2-ultragpu-1g"),
+		}),
+	}
+	none := Module{
+		ID:     "none",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"guest_accelerator": cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+				"type":  cty.StringVal("nvidia-tesla-t4"),
+				"count": cty.NumberIntVal(0),
+			})}),
+		}),
+	}
+	bp := Blueprint{
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{t4, a2, none}}},
+	}
+	c.Check(bp.attachesGPUs(), Equals, true)
+
+	gm, ok := bp.gpuModule(t4)
+	c.Check(ok, Equals, true)
+	c.Check(gm, DeepEquals, validators.GPUModule{Module: "t4", Accelerator: "nvidia-tesla-t4",
+		ImageProject: "deeplearning-platform-release", ImageFamily: "common-cu113"})
+
+	// machine types that come with GPUs use the default image of the module
+	gm, ok = bp.gpuModule(a2)
+	c.Check(ok, Equals, true)
+	c.Check(gm, DeepEquals, validators.GPUModule{Module: "a2", Accelerator: "nvidia-a100-80gb",
+		ImageProject: "schedmd-slurm-public", ImageFamily: "slurm-gcp-5-7-hpc-centos-7"})
+
+	_, ok = bp.gpuModule(none)
+	c.Check(ok, Equals, false)
+
+	bp.DeploymentGroups[0].Modules = []Module{none}
+	c.Check(bp.attachesGPUs(), Equals, false)
+}
+
+func (s *MySuite) TestGPUNetworkModule(c *C) {
+	vpcs := []Module{}
+	nics := []cty.Value{}
+	for _, id := range []ModuleID{"net0", "net1", "net2", "net3", "net4"} {
+		vpcs = append(vpcs, Module{ID: id, Source: "modules/network/vpc", Settings: NewDict(map[string]cty.Value{})})
+		nics = append(nics, cty.ObjectVal(map[string]cty.Value{
+			"network":    cty.NullVal(cty.String),
+			"subnetwork": ModuleRef(id, "subnetwork_self_link").AsExpression().AsValue(),
+			"nic_type":   cty.StringVal("GVNIC"),
+		}))
+	}
+	vpcs[4].Settings.Set("enable_internal_traffic", cty.False)
+	a3 := Module{
+		ID:     "a3",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type":       cty.StringVal("a3-highgpu-8g"),
+			"network_interfaces": cty.TupleVal(nics),
+		}),
+	}
+	mega := Module{
+		ID:     "mega",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type": cty.StringVal("a3-megagpu-8g"),
+			"network_interfaces": cty.TupleVal([]cty.Value{
+				cty.ObjectVal(map[string]cty.Value{
+					"subnetwork": cty.StringVal("gpu-0"),
+					"nic_type":   cty.StringVal("VIRTIO_NET"),
+				}),
+				cty.ObjectVal(map[string]cty.Value{
+					"subnetwork": cty.StringVal("gpu-0"),
+					"nic_type":   cty.StringVal("GVNIC"),
+				}),
+			}),
+		}),
+	}
+	single := Module{
+		ID:     "single",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type": cty.StringVal("a3-highgpu-8g"),
+		}),
+	}
+	slurm := Module{
+		ID:     "slurm",
+		Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type": cty.StringVal("a3-highgpu-8g"),
+		}),
+	}
+	unknown := Module{
+		ID:     "unknown",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type":       cty.StringVal("a3-highgpu-8g"),
+			"network_interfaces": ModuleRef("net0", "interfaces").AsExpression().AsValue(),
+		}),
+	}
+	n2 := Module{
+		ID:     "n2",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"machine_type": cty.StringVal("n2-standard-8"),
+		}),
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("hpc-project"),
+			"region":     cty.StringVal("us-central1"),
+		}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: append(vpcs, a3, mega, single, slurm, unknown, n2)}},
+	}
+	c.Check(bp.usesGPUNetworking(), Equals, true)
+
+	gm, ok := bp.gpuNetworkModule(a3)
+	c.Check(ok, Equals, true)
+	c.Check(gm.Stack, Equals, "GPUDirect-TCPX")
+	c.Check(gm.Problems, HasLen, 0)
+	c.Check(gm.Networks, HasLen, 0)
+	c.Assert(gm.Warnings, HasLen, 1)
+	c.Check(gm.Warnings[0], Matches, "network net4 of network interface 4 sets enable_internal_traffic to false .*")
+
+	// firewall rules make up for the internal traffic
+	vpcs[4].Settings.Set("firewall_rules", cty.TupleVal([]cty.Value{cty.StringVal("allow-gpu")}))
+	bp.DeploymentGroups[0].Modules[4] = vpcs[4]
+	gm, _ = bp.gpuNetworkModule(a3)
+	c.Check(gm.Warnings, HasLen, 0)
+
+	gm, ok = bp.gpuNetworkModule(mega)
+	c.Check(ok, Equals, true)
+	c.Assert(gm.Problems, HasLen, 2)
+	c.Check(gm.Problems[0], Matches, "network interface 0 has nic_type VIRTIO_NET, .*")
+	c.Check(gm.Problems[1], Matches, "network interfaces 0 and 1 are both in network gpu-0; .*")
+	c.Assert(gm.Warnings, HasLen, 1)
+	c.Check(gm.Warnings[0], Matches, "it has 2 network interfaces, but a3-megagpu-8g VMs need 9, .*")
+	c.Check(gm.Networks, DeepEquals, []validators.GPUNetwork{
+		{Interface: 0, Subnetwork: "gpu-0", ProjectID: "hpc-project", Region: "us-central1"}})
+
+	// the default network interface only uses gVNIC with a bandwidth tier
+	gm, _ = bp.gpuNetworkModule(single)
+	c.Check(gm.Problems, HasLen, 0)
+	c.Assert(gm.Warnings, HasLen, 2)
+	c.Check(gm.Warnings[1], Matches, ".*set bandwidth_tier to gvnic_enabled or tier_1_enabled")
+	single.Settings.Set("bandwidth_tier", cty.StringVal("gvnic_enabled"))
+	gm, _ = bp.gpuNetworkModule(single)
+	c.Check(gm.Warnings, HasLen, 1)
+
+	gm, ok = bp.gpuNetworkModule(slurm)
+	c.Check(ok, Equals, true)
+	c.Assert(gm.Warnings, HasLen, 1)
+	c.Check(gm.Warnings[0], Matches, ".*cannot attach network interfaces.*")
+
+	// settings that depend upon module outputs are not checked
+	gm, ok = bp.gpuNetworkModule(unknown)
+	c.Check(ok, Equals, true)
+	c.Check(gm.Problems, HasLen, 0)
+	c.Check(gm.Warnings, HasLen, 0)
+
+	_, ok = bp.gpuNetworkModule(n2)
+	c.Check(ok, Equals, false)
+
+	bp.DeploymentGroups[0].Modules = []Module{n2}
+	c.Check(bp.usesGPUNetworking(), Equals, false)
+}
+
+func (s *MySuite) TestExternalIPModule(c *C) {
+	login := Module{
+		ID:     "login",
+		Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-login",
+		Settings: NewDict(map[string]cty.Value{
+			"disable_login_public_ips": cty.False,
+			"num_instances":            cty.NumberIntVal(2),
+		}),
+	}
+	vm := Module{
+		ID:     "vm",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"project_id": GlobalRef("project_id").AsExpression().AsValue(),
+		}),
+	}
+	private := Module{
+		ID:     "private",
+		Source: "modules/compute/vm-instance",
+		Settings: NewDict(map[string]cty.Value{
+			"disable_public_ips": cty.True,
+		}),
+	}
+	unknown := Module{
+		ID:     "unknown",
+		Source: "community/modules/scheduler/pbspro-client",
+		Settings: NewDict(map[string]cty.Value{
+			"enable_public_ips": ModuleRef("network", "public").AsExpression().AsValue(),
+		}),
+	}
+	bp := Blueprint{
+		Vars:             NewDict(map[string]cty.Value{"project_id": cty.StringVal("hpc-project")}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{login, vm, private, unknown}}},
+	}
+	c.Check(bp.createsExternalIPs(), Equals, true)
+
+	em, ok := bp.externalIPModule(login)
+	c.Check(ok, Equals, true)
+	c.Check(em, DeepEquals, validators.ExternalIPModule{Module: "login", ProjectID: "hpc-project", VMs: 2, Login: true,
+		Fix: "set disable_login_public_ips: true and leave access_config empty"})
+
+	// vm-instance has external IPs by default
+	em, ok = bp.externalIPModule(vm)
+	c.Check(ok, Equals, true)
+	c.Check(em, DeepEquals, validators.ExternalIPModule{Module: "vm", ProjectID: "hpc-project", VMs: 1,
+		Fix: "set disable_public_ips: true"})
+
+	_, ok = bp.externalIPModule(private)
+	c.Check(ok, Equals, false)
+
+	// settings that depend upon module outputs are not checked
+	_, ok = bp.externalIPModule(unknown)
+	c.Check(ok, Equals, false)
+
+	{ // access_config adds external IPs to Slurm login nodes
+		login := login
+		login.Settings = NewDict(map[string]cty.Value{
+			"access_config": cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+				"nat_ip":       cty.NullVal(cty.String),
+				"network_tier": cty.StringVal("PREMIUM"),
+			})}),
+		})
+		em, ok := bp.externalIPModule(login)
+		c.Check(ok, Equals, true)
+		c.Check(em.VMs, Equals, 1)
+	}
+
+	v := validatorConfig{Validator: testExternalIPsName.String()}
+	n, err := bp.maxExternalLoginNodes(v)
+	c.Check(err, IsNil)
+	c.Check(n, Equals, defaultMaxExternalLoginNodes)
+	v.Inputs = NewDict(map[string]cty.Value{maxExternalLoginNodesInput: cty.NumberIntVal(3)})
+	n, err = bp.maxExternalLoginNodes(v)
+	c.Check(err, IsNil)
+	c.Check(n, Equals, 3)
+	v.Inputs = NewDict(map[string]cty.Value{maxExternalLoginNodesInput: cty.StringVal("many")})
+	_, err = bp.maxExternalLoginNodes(v)
+	c.Check(err, ErrorMatches, "max_external_login_nodes of test_external_ips must be .*")
+
+	bp.DeploymentGroups[0].Modules = []Module{private, unknown}
+	c.Check(bp.createsExternalIPs(), Equals, false)
+}
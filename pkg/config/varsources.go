@@ -0,0 +1,128 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"hpc-toolkit/pkg/varsources"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// VarSource reads deployment variables from a central store when the
+// blueprint is expanded. Vars maps the names of deployment variables to their
+// keys in the store.
+type VarSource struct {
+	Type string `yaml:"type"`
+	// Project of the store, which defaults to the project_id deployment
+	// variable
+	Project  string            `yaml:"project,omitempty"`
+	Settings map[string]string `yaml:"settings,omitempty"`
+	Vars     map[string]string `yaml:"vars"`
+}
+
+// ResolvedVar records a deployment variable read from a var source
+type ResolvedVar struct {
+	Var      string    `yaml:"var"`
+	Source   string    `yaml:"source"`
+	Location string    `yaml:"location"`
+	Value    cty.Value `yaml:"-"`
+}
+
+// resolveVarSources sets the deployment variables read from the var sources
+// of the blueprint. Variables that are already set, in the blueprint or with
+// --vars, take precedence over their sources and are not read.
+func (dc *DeploymentConfig) resolveVarSources(ctx context.Context) error {
+	for i, vs := range dc.Config.VarSources {
+		names := maps.Keys(vs.Vars)
+		slices.Sort(names)
+		var src varsources.Source
+		for _, name := range names {
+			if dc.Config.Vars.Has(name) {
+				continue
+			}
+			if src == nil {
+				project, err := dc.Config.varSourceProject(vs)
+				if err == nil {
+					src, err = varsources.New(vs.Type, project, vs.Settings)
+				}
+				if err != nil {
+					return fmt.Errorf("var source %d (%s): %w", i, vs.Type, err)
+				}
+			}
+			v, location, err := src.Get(ctx, vs.Vars[name])
+			if err != nil {
+				return fmt.Errorf("failed to read deployment variable %s: %w", name, err)
+			}
+			dc.Config.Vars.Set(name, v)
+			dc.resolvedVars = append(dc.resolvedVars, ResolvedVar{
+				Var: name, Source: vs.Type, Location: location, Value: v})
+		}
+	}
+	return nil
+}
+
+// varSourceProject returns the project of a var source
+func (bp Blueprint) varSourceProject(vs VarSource) (string, error) {
+	if vs.Project != "" {
+		return vs.Project, nil
+	}
+	if !bp.Vars.Has("project_id") {
+		return "", fmt.Errorf("project is not set and there is no project_id deployment variable")
+	}
+	v, ok := evalIfKnown(bp.Vars.Get("project_id"), bp)
+	if !ok || !isNonEmptyString(v) {
+		return "", fmt.Errorf("project is not set and project_id is not a string")
+	}
+	return v.AsString(), nil
+}
+
+// ExportResolvedVars writes the deployment variables read from var sources,
+// with their values, as YAML
+func (dc DeploymentConfig) ExportResolvedVars(outputFilename string) error {
+	type record struct {
+		ResolvedVar `yaml:",inline"`
+		Value       interface{} `yaml:"value"`
+	}
+	records := []record{}
+	for _, r := range dc.resolvedVars {
+		v, err := NewDict(map[string]cty.Value{"value": r.Value}).MarshalYAML()
+		if err != nil {
+			return configErrorf("yamlMarshalError", ": %w", err)
+		}
+		records = append(records, record{ResolvedVar: r, Value: v.(map[string]interface{})["value"]})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(YamlLicense)
+	buf.WriteString("\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string]interface{}{"resolved_vars": records}); err != nil {
+		return configErrorf("yamlMarshalError", ": %w", err)
+	}
+	encoder.Close()
+	if err := os.WriteFile(outputFilename, buf.Bytes(), 0644); err != nil {
+		return configErrorf("fileSaveError", ", Filename: %s: %w", outputFilename, err)
+	}
+	return nil
+}
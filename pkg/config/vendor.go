@@ -0,0 +1,96 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VendorFunc copies a remote module source, pinned by a source hash if it is
+// not empty, to the local file system and returns the local source of the
+// copy and the source hash that pins it
+type VendorFunc func(source string, hash string) (string, string, error)
+
+// VendorBlueprint returns the blueprint YAML in src with the git sources of
+// its modules and module aliases replaced by the local sources that vendor
+// copies them to. Comments are preserved, like FormatBlueprint does; src is
+// returned unchanged if it has no git sources.
+func VendorBlueprint(src []byte, vendor VendorFunc) ([]byte, error) {
+	header, doc, err := parseBlueprintNode(src, "vendored")
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+
+	// the sources of modules that name an alias are vendored in the alias
+	aliases := mappingValue(root, "module_aliases")
+	aliasSources := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(aliases.Content); i += 2 {
+		aliasSources[aliases.Content[i].Value] = aliases.Content[i+1]
+	}
+
+	// the git sources of the source nodes that were vendored
+	original := map[*yaml.Node]string{}
+	vendorNode := func(source *yaml.Node, hash *yaml.Node) error {
+		git, rewritten := original[source]
+		if !rewritten {
+			if source.Kind != yaml.ScalarNode || !sourcereader.IsGitPath(source.Value) {
+				return nil
+			}
+			git = source.Value
+		}
+		local, localHash, err := vendor(git, hash.Value)
+		if err != nil {
+			return err
+		}
+		if rewritten && source.Value != local {
+			return fmt.Errorf("%s is vendored to both %s and %s, as the modules using it pin different source hashes",
+				git, source.Value, local)
+		}
+		original[source] = git
+		source.Value, source.Style = local, 0
+		if hash.Value != "" {
+			hash.Value, hash.Style = localHash, 0
+		}
+		return nil
+	}
+
+	for _, g := range mappingValue(root, "deployment_groups").Content {
+		for _, m := range mappingValue(g, "modules").Content {
+			source := mappingValue(m, "source")
+			if alias, ok := aliasSources[source.Value]; ok {
+				source = alias
+			}
+			if err := vendorNode(source, mappingValue(m, "source_hash")); err != nil {
+				return nil, fmt.Errorf("module %s: %w", mappingValue(m, "id").Value, err)
+			}
+		}
+	}
+	for i := 0; i+1 < len(aliases.Content); i += 2 {
+		if _, ok := original[aliases.Content[i+1]]; ok {
+			continue
+		}
+		if err := vendorNode(aliases.Content[i+1], &yaml.Node{}); err != nil {
+			return nil, fmt.Errorf("module alias %s: %w", aliases.Content[i].Value, err)
+		}
+	}
+	if len(original) == 0 {
+		return src, nil
+	}
+	return encodeBlueprintNode(header, doc)
+}
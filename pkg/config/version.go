@@ -0,0 +1,61 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"log"
+	"regexp"
+
+	"github.com/hashicorp/go-version"
+)
+
+// ToolkitVersion is the release of the running ghpc, e.g. v1.19.1, which is
+// set by the ghpc command. Blueprints are not checked against it when empty.
+var ToolkitVersion string
+
+// releaseVersion matches the release at the start of the output of git
+// describe, which ghpc records as the ghpc_version of expanded blueprints
+var releaseVersion = regexp.MustCompile(`^v?\d+\.\d+\.\d+`)
+
+// checkGhpcVersion errors if the blueprint sets a minimum_ghpc_version newer
+// than the running release, and warns if it was expanded by a newer release,
+// whose features the running release may not know
+func (bp Blueprint) checkGhpcVersion(running string) error {
+	if running == "" {
+		return nil
+	}
+	current, err := version.NewVersion(running)
+	if err != nil {
+		return nil // development builds are not checked
+	}
+
+	if bp.MinimumGhpcVersion != "" {
+		minimum, err := version.NewVersion(bp.MinimumGhpcVersion)
+		if err != nil {
+			return configErrorf("ghpcVersion", ": minimum_ghpc_version %q is not a version: %v", bp.MinimumGhpcVersion, err)
+		}
+		if current.LessThan(minimum) {
+			return configErrorf("ghpcVersion", ": the blueprint requires ghpc %s or later, this is ghpc %s; "+
+				"upgrade ghpc, see `ghpc version --check-update`", bp.MinimumGhpcVersion, running)
+		}
+	}
+
+	if r := releaseVersion.FindString(bp.GhpcVersion); r != "" {
+		if expanded, err := version.NewVersion(r); err == nil && current.LessThan(expanded) {
+			log.Printf("WARNING: the blueprint was expanded by ghpc %s, which is newer than this ghpc %s", bp.GhpcVersion, running)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/validators"
+	"log"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// fallbackZoneSuffixes are the first zones of the regions that have no zone
+// "a"; the first zone of every other region is "a"
+var fallbackZoneSuffixes = map[string]string{
+	"europe-west1": "b",
+	"us-east1":     "b",
+}
+
+// ZoneSelector chooses a zone of a region in a project
+type ZoneSelector func(ctx context.Context, projectID string, region string) (string, error)
+
+// SelectZonesWith makes expansion choose the zone of blueprints that only set
+// their region with s, rather than by the deterministic fallback. The fallback
+// is still used if s fails.
+func (dc *DeploymentConfig) SelectZonesWith(s ZoneSelector) {
+	dc.selectZone = s
+}
+
+// fallbackZone returns the first zone of a region
+func fallbackZone(region string) string {
+	if s, ok := fallbackZoneSuffixes[region]; ok {
+		return region + "-" + s
+	}
+	return region + "-a"
+}
+
+// zoneRegion returns the region of a zone
+func zoneRegion(zone string) (string, bool) {
+	i := strings.LastIndex(zone, "-")
+	if i <= 0 {
+		return "", false
+	}
+	return zone[:i], true
+}
+
+// requiresVar returns true if a module has a required input of the name that
+// it does not set, so that it can only be set by the deployment variable
+func (bp Blueprint) requiresVar(name string) bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		if m.Settings.Has(name) {
+			return nil
+		}
+		for _, in := range m.InfoOrDie().Inputs {
+			found = found || (in.Name == name && in.Required)
+		}
+		return nil
+	})
+	return found
+}
+
+// stringVar returns the value of a deployment variable that is a string
+func (bp Blueprint) stringVar(name string) (string, bool) {
+	if !bp.Vars.Has(name) {
+		return "", false
+	}
+	v := bp.Vars.Get(name)
+	if !isNonEmptyString(v) {
+		return "", false
+	}
+	return v.AsString(), true
+}
+
+// zoneModule returns the project and zone of a module that sets a zone of its
+// own, i.e. one that the zone validators of the deployment variables do not
+// check. Zones and projects that depend upon module outputs are not known.
+func (bp Blueprint) zoneModule(m Module) (validators.ZoneModule, bool) {
+	if !m.Settings.Has("zone") {
+		return validators.ZoneModule{}, false
+	}
+	v, ok := evalIfKnown(m.Settings.Get("zone"), bp)
+	if !ok || !isNonEmptyString(v) {
+		return validators.ZoneModule{}, false
+	}
+	project, ok := bp.moduleProject(m)
+	if !ok {
+		return validators.ZoneModule{}, false
+	}
+	zone := v.AsString()
+	varZone, _ := bp.stringVar("zone")
+	varProject, _ := bp.stringVar("project_id")
+	if zone == varZone && project == varProject {
+		return validators.ZoneModule{}, false
+	}
+	return validators.ZoneModule{Module: string(m.ID), ProjectID: project, Zone: zone}, true
+}
+
+// setsModuleZones returns true if any module sets a zone of its own
+func (bp Blueprint) setsModuleZones() bool {
+	found := false
+	bp.WalkModules(func(m *Module) error {
+		_, ok := bp.zoneModule(*m)
+		found = found || ok
+		return nil
+	})
+	return found
+}
+
+// defaultLocation sets the zone deployment variable of a blueprint that only
+// sets its region, and the region of one that only sets its zone, when modules
+// require them. The zone of an existing deployment is the zone that its
+// previous expansion chose in the region, so that its resources are not
+// recreated elsewhere. The zone of a new deployment is the first zone of the
+// region that is up, if a zone selector is set, or else the first zone of the
+// region. The decisions are recorded as comments of the variables in the
+// expanded blueprint.
+func (dc *DeploymentConfig) defaultLocation(ctx context.Context) error {
+	region, hasRegion := dc.Config.stringVar("region")
+	zone, hasZone := dc.Config.stringVar("zone")
+
+	if hasRegion && !dc.Config.Vars.Has("zone") && dc.Config.requiresVar("zone") {
+		zone = fallbackZone(region)
+		reason := fmt.Sprintf("chosen by ghpc as the first zone of region %s", region)
+		if z, ok := dc.previousZone(region); ok {
+			zone = z
+			reason = fmt.Sprintf("kept by ghpc from the previous expansion of the deployment in region %s", region)
+		} else if project, ok := dc.Config.stringVar("project_id"); ok && dc.selectZone != nil {
+			if z, err := dc.selectZone(ctx, project, region); err != nil {
+				log.Printf("WARNING: could not list the zones of region %s, choosing zone %s: %v", region, zone, err)
+			} else {
+				zone = z
+				reason = fmt.Sprintf("chosen by ghpc as the first zone of region %s that is up in project %s", region, project)
+			}
+		}
+		dc.Config.Vars.Set("zone", cty.StringVal(zone))
+		dc.recordDefaultedVar("zone", reason)
+	}
+
+	if hasZone && !dc.Config.Vars.Has("region") && dc.Config.requiresVar("region") {
+		if region, ok := zoneRegion(zone); ok {
+			dc.Config.Vars.Set("region", cty.StringVal(region))
+			dc.recordDefaultedVar("region", fmt.Sprintf("set by ghpc to the region of zone %s", zone))
+		}
+	}
+	return nil
+}
+
+// previousZone returns the zone of the previous expansion of the deployment,
+// if it is in the region
+func (dc DeploymentConfig) previousZone(region string) (string, bool) {
+	zone, ok := Blueprint{Vars: dc.previousVars}.stringVar("zone")
+	if !ok {
+		return "", false
+	}
+	if r, ok := zoneRegion(zone); !ok || r != region {
+		return "", false
+	}
+	return zone, true
+}
+
+func (dc *DeploymentConfig) recordDefaultedVar(name string, reason string) {
+	if dc.defaultedVars == nil {
+		dc.defaultedVars = map[string]string{}
+	}
+	dc.defaultedVars[name] = reason
+}
+
+// annotateDefaultedVars adds a line comment telling how ghpc chose their
+// values to the deployment variables set by defaultLocation in the YAML
+// encoding of the expanded blueprint
+func (dc DeploymentConfig) annotateDefaultedVars(doc *yaml.Node) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	vars := mappingValue(root, "vars")
+	for name, reason := range dc.defaultedVars {
+		if i := mappingKeyIndex(vars, name); i != -1 {
+			vars.Content[i+1].LineComment = reason
+		}
+	}
+}
@@ -28,7 +28,7 @@ import (
 type Local struct{}
 
 func mkdirWrapper(directory string) error {
-	if err := os.MkdirAll(directory, 0755); err != nil {
+	if err := MkdirAll(directory); err != nil {
 		return fmt.Errorf("Failed to create the directory %s: %v", directory, err)
 	}
 
@@ -58,20 +58,25 @@ func (b *Local) CreateDirectory(directory string) error {
 	return mkdirWrapper(directory)
 }
 
-// CopyFromPath copyes the source file to the destination file
+// CopyFromPath copyes the source file to the destination file, preserving
+// its execute bits
 func (b *Local) CopyFromPath(src string, dst string) error {
 	absPath := getAbsSourcePath(src)
-	return copy.Copy(absPath, dst)
+	if err := copy.Copy(absPath, dst); err != nil {
+		return err
+	}
+	return applyModes(dst)
 }
 
-// CopyFromFS copies the embedded source file to the destination file
+// CopyFromFS copies the embedded source file to the destination file, which
+// is executable if it is a script
 func (b *Local) CopyFromFS(fs BaseFS, src string, dst string) error {
 	data, err := fs.ReadFile(src)
 	if err != nil {
 		return fmt.Errorf("Failed to read source file %s: err=%w", src, err)
 	}
 
-	if err := os.WriteFile(dst, data, 0644); err != nil {
+	if err := WriteFile(dst, data, IsScript(data)); err != nil {
 		return fmt.Errorf("Failed to write data in destination file %s: err=%w", dst, err)
 	}
 
@@ -0,0 +1,183 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploymentio
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// umask, if set, restricts the permissions of the files and directories of
+// deployments in place of the umask of the process, e.g. 0002 makes them
+// writable by the group of a service account shared on a bastion
+var umask *fs.FileMode
+
+// SetUmask sets the umask of the files and directories of deployments, which
+// are then chmod-ed to their exact modes
+func SetUmask(u fs.FileMode) {
+	u &= fs.ModePerm
+	umask = &u
+}
+
+// ResetUmask restores the default modes, restricted by the umask of the
+// process
+func ResetUmask() {
+	umask = nil
+}
+
+// ParseUmask parses an octal umask, e.g. 0002 or 022
+func ParseUmask(s string) (fs.FileMode, error) {
+	u, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || u > uint64(fs.ModePerm) {
+		return 0, fmt.Errorf("umask must be an octal number between 0000 and 0777, got %q", s)
+	}
+	return fs.FileMode(u), nil
+}
+
+// FileMode returns the permissions of the files of deployments; executable
+// files, e.g. scripts, get the execute bits of directories
+func FileMode(exec bool) fs.FileMode {
+	if exec {
+		return DirMode()
+	}
+	if umask == nil {
+		return 0644
+	}
+	return 0666 &^ *umask
+}
+
+// DirMode returns the permissions of the directories of deployments
+func DirMode() fs.FileMode {
+	if umask == nil {
+		return 0755
+	}
+	return fs.ModePerm &^ *umask
+}
+
+// PrivateDirMode returns the permissions of the directories of deployments
+// that others must not read, e.g. artifacts that may hold secrets; the group
+// bits of a umask still apply, so that a group may share a deployment
+func PrivateDirMode() fs.FileMode {
+	if umask == nil {
+		return 0700
+	}
+	return DirMode() &^ 0007
+}
+
+// IsScript returns true if the content of a file starts with a shebang, so
+// that its copy must be executable even if the mode of the original is not
+// known, e.g. in an embedded file system
+func IsScript(content []byte) bool {
+	return bytes.HasPrefix(content, []byte("#!"))
+}
+
+// fixMode sets the exact mode of a file written by a deployment if a umask is
+// set, as the umask of the process may remove more permissions
+func fixMode(name string, mode fs.FileMode) error {
+	if umask == nil {
+		return nil
+	}
+	return os.Chmod(name, mode)
+}
+
+// WriteFile writes a file of a deployment, executable if exec is true
+func WriteFile(name string, data []byte, exec bool) error {
+	mode := FileMode(exec)
+	if err := os.WriteFile(name, data, mode); err != nil {
+		return err
+	}
+	return fixMode(name, mode)
+}
+
+// Create creates, or truncates, a file of a deployment
+func Create(name string) (*os.File, error) {
+	mode := FileMode(false)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := fixMode(name, mode); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Mkdir creates a directory of a deployment, whose parent must exist
+func Mkdir(dir string) error {
+	if err := os.Mkdir(dir, DirMode()); err != nil {
+		return err
+	}
+	return fixMode(dir, DirMode())
+}
+
+// MkdirAll creates a directory of a deployment and its missing parents
+func MkdirAll(dir string) error {
+	return mkdirAllMode(dir, DirMode())
+}
+
+// MkdirPrivate creates a directory of a deployment, and its missing parents,
+// with PrivateDirMode
+func MkdirPrivate(dir string) error {
+	return mkdirAllMode(dir, PrivateDirMode())
+}
+
+func mkdirAllMode(dir string, mode fs.FileMode) error {
+	if umask == nil {
+		return os.MkdirAll(dir, mode)
+	}
+	// the parents that are missing are created, and chmod-ed, one at a time
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAllMode(parent, DirMode()); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, mode); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return fixMode(dir, mode)
+}
+
+// applyModes sets the modes of the files and directories of a copied tree if
+// a umask is set; files keep being executable if their originals were
+func applyModes(root string) error {
+	if umask == nil {
+		return nil
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			return nil
+		case d.IsDir():
+			return os.Chmod(p, DirMode())
+		default:
+			return os.Chmod(p, FileMode(info.Mode()&0111 != 0))
+		}
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploymentio
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func mode(c *C, name string) fs.FileMode {
+	info, err := os.Stat(name)
+	c.Assert(err, IsNil)
+	return info.Mode().Perm()
+}
+
+func (s *MySuite) TestParseUmask(c *C) {
+	u, err := ParseUmask("0002")
+	c.Check(err, IsNil)
+	c.Check(u, Equals, fs.FileMode(0002))
+	u, err = ParseUmask("27")
+	c.Check(err, IsNil)
+	c.Check(u, Equals, fs.FileMode(0027))
+
+	for _, bad := range []string{"", "8", "01000", "rwx"} {
+		_, err := ParseUmask(bad)
+		c.Check(err, ErrorMatches, "umask must be an octal number .*")
+	}
+}
+
+func (s *MySuite) TestModes(c *C) {
+	defer ResetUmask()
+	c.Check(FileMode(false), Equals, fs.FileMode(0644))
+	c.Check(FileMode(true), Equals, fs.FileMode(0755))
+	c.Check(PrivateDirMode(), Equals, fs.FileMode(0700))
+
+	SetUmask(0002)
+	c.Check(FileMode(false), Equals, fs.FileMode(0664))
+	c.Check(FileMode(true), Equals, fs.FileMode(0775))
+	c.Check(DirMode(), Equals, fs.FileMode(0775))
+	c.Check(PrivateDirMode(), Equals, fs.FileMode(0770))
+
+	c.Check(IsScript([]byte("#!/bin/bash\necho hello\n")), Equals, true)
+	c.Check(IsScript([]byte("echo hello\n")), Equals, false)
+}
+
+func (s *MySuite) TestWriteWithUmask(c *C) {
+	defer ResetUmask()
+	SetUmask(0002)
+	dir := c.MkDir()
+
+	// the modes are exact whatever the umask of the process
+	nested := filepath.Join(dir, "a", "b")
+	c.Assert(MkdirAll(nested), IsNil)
+	c.Check(mode(c, filepath.Join(dir, "a")), Equals, fs.FileMode(0775))
+	c.Check(mode(c, nested), Equals, fs.FileMode(0775))
+
+	private := filepath.Join(dir, "artifacts")
+	c.Assert(MkdirPrivate(private), IsNil)
+	c.Check(mode(c, private), Equals, fs.FileMode(0770))
+
+	file := filepath.Join(nested, "main.tf")
+	c.Assert(WriteFile(file, []byte("# main\n"), false), IsNil)
+	c.Check(mode(c, file), Equals, fs.FileMode(0664))
+	f, err := Create(filepath.Join(nested, "instructions.txt"))
+	c.Assert(err, IsNil)
+	f.Close()
+	c.Check(mode(c, filepath.Join(nested, "instructions.txt")), Equals, fs.FileMode(0664))
+
+	// copies keep the execute bits of their originals
+	src := filepath.Join(dir, "src")
+	c.Assert(os.MkdirAll(filepath.Join(src, "scripts"), 0700), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(src, "main.tf"), []byte("# main\n"), 0600), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(src, "scripts", "install.sh"), []byte("#!/bin/sh\n"), 0700), IsNil)
+	dst := filepath.Join(dir, "dst")
+	c.Assert(GetDeploymentioLocal().CopyFromPath(src, dst), IsNil)
+	c.Check(mode(c, filepath.Join(dst, "scripts")), Equals, fs.FileMode(0775))
+	c.Check(mode(c, filepath.Join(dst, "main.tf")), Equals, fs.FileMode(0664))
+	c.Check(mode(c, filepath.Join(dst, "scripts", "install.sh")), Equals, fs.FileMode(0775))
+}
@@ -67,6 +67,7 @@ func getHCLInfo(source string) (ModuleInfo, error) {
 			Description: v.Description,
 			Default:     v.Default,
 			Required:    v.Required,
+			Sensitive:   v.Sensitive,
 		}
 		vars = append(vars, vInfo)
 	}
@@ -75,10 +76,12 @@ func getHCLInfo(source string) (ModuleInfo, error) {
 		oInfo := OutputInfo{
 			Name:        v.Name,
 			Description: v.Description,
+			Sensitive:   v.Sensitive,
 		}
 		outs = append(outs, oInfo)
 	}
 	ret.Outputs = outs
+	ret.RequiredCore = module.RequiredCore
 	return ret, nil
 }
 
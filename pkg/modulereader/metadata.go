@@ -0,0 +1,93 @@
+/**
+ * Copyright 2022 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modulereader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"hpc-toolkit/pkg/sourcereader"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MetadataFilename is the file in which module authors describe their modules
+// to the toolkit
+const MetadataFilename = "metadata.yaml"
+
+// DeprecatedInput is an input that module authors intend to remove. If it has
+// a replacement, blueprints that set it are migrated to the replacement when
+// expanded.
+type DeprecatedInput struct {
+	Name        string `yaml:"name"`
+	Replacement string `yaml:"replacement,omitempty"`
+	Message     string `yaml:"message,omitempty"`
+}
+
+// moduleMetadata is the content of the metadata file of a module
+type moduleMetadata struct {
+	DeprecatedInputs []DeprecatedInput `yaml:"deprecated_inputs"`
+	// OutputTypes declares the types of outputs by name, which are matched
+	// to the types of inputs by modules that use the module
+	OutputTypes map[string]string `yaml:"output_types"`
+}
+
+// readMetadata reads the metadata file of the module at a local or embedded
+// path; modules without one have no metadata
+func readMetadata(modPath string) (moduleMetadata, error) {
+	var md moduleMetadata
+	var data []byte
+	var err error
+	if sourcereader.IsEmbeddedPath(modPath) {
+		if sourcereader.ModuleFS == nil {
+			return md, nil
+		}
+		data, err = sourcereader.ModuleFS.ReadFile(filepath.ToSlash(filepath.Join(modPath, MetadataFilename)))
+	} else {
+		data, err = os.ReadFile(filepath.Join(modPath, MetadataFilename))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return md, nil
+	}
+	if err != nil {
+		return md, err
+	}
+
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&md); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		return md, fmt.Errorf("failed to read %s of module %s: %w", MetadataFilename, modPath, err)
+	}
+	seen := map[string]bool{}
+	for i, d := range md.DeprecatedInputs {
+		if d.Name == "" {
+			return md, fmt.Errorf("%s of module %s: deprecated input %d has no name", MetadataFilename, modPath, i)
+		}
+		if d.Name == d.Replacement || seen[d.Name] {
+			return md, fmt.Errorf("%s of module %s: deprecated input %s is declared more than once or replaces itself", MetadataFilename, modPath, d.Name)
+		}
+		seen[d.Name] = true
+	}
+	for name, t := range md.OutputTypes {
+		if _, err := getCtyType(t); err != nil {
+			return md, fmt.Errorf("%s of module %s: type %q of output %s is not a type: %v", MetadataFilename, modPath, t, name, err)
+		}
+	}
+	return md, nil
+}
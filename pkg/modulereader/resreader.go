@@ -18,11 +18,10 @@
 package modulereader
 
 import (
+	"context"
 	"fmt"
 	"hpc-toolkit/pkg/sourcereader"
-	"io/ioutil"
 	"log"
-	"path"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -35,6 +34,8 @@ type VarInfo struct {
 	Description string
 	Default     interface{}
 	Required    bool
+	// Sensitive inputs are hidden from Terraform plans and outputs
+	Sensitive bool
 }
 
 // OutputInfo stores information about module output values
@@ -42,6 +43,10 @@ type OutputInfo struct {
 	Name        string
 	Description string `yaml:",omitempty"`
 	Sensitive   bool   `yaml:",omitempty"`
+	// Type is the type of the output, if declared; Terraform does not declare
+	// the types of outputs, which module authors may declare in the metadata
+	// file of the module
+	Type string `yaml:",omitempty"`
 	// DependsOn   []string `yaml:"depends_on,omitempty"`
 }
 
@@ -60,11 +65,11 @@ func (mo *OutputInfo) UnmarshalYAML(value *yaml.Node) error {
 	var fields map[string]interface{}
 	err = value.Decode(&fields)
 	if err != nil {
-		return fmt.Errorf(yamlErrorMsg, value.Line, "outputs must each be a string or a map{name: string, description: string, sensitive: bool}; "+err.Error())
+		return fmt.Errorf(yamlErrorMsg, value.Line, "outputs must each be a string or a map{name: string, description: string, sensitive: bool, type: string}; "+err.Error())
 	}
 
 	err = enforceMapKeys(fields, map[string]bool{
-		"name": true, "description": false, "sensitive": false},
+		"name": true, "description": false, "sensitive": false, "type": false},
 	)
 	if err != nil {
 		return fmt.Errorf(yamlErrorMsg, value.Line, err)
@@ -100,6 +105,11 @@ type ModuleInfo struct {
 	Inputs       []VarInfo
 	Outputs      []OutputInfo
 	RequiredApis []string
+	// DeprecatedInputs are declared in the metadata file of the module
+	DeprecatedInputs []DeprecatedInput
+	// RequiredCore are the required_version constraints of a Terraform
+	// module
+	RequiredCore []string
 }
 
 // GetOutputsAsMap returns the outputs list as a map for quicker access
@@ -123,23 +133,28 @@ var modInfoCache = map[sourceAndKind]ModuleInfo{}
 // enabled for that module.
 // There is a cache to avoid re-reading the module info for the same source and kind.
 func GetModuleInfo(source string, kind string) (ModuleInfo, error) {
+	return GetModuleInfoContext(context.Background(), source, kind)
+}
+
+// GetModuleInfoContext is GetModuleInfo with a context that aborts the
+// cloning of git modules
+func GetModuleInfoContext(ctx context.Context, source string, kind string) (ModuleInfo, error) {
 	key := sourceAndKind{source, kind}
 	if mi, ok := modInfoCache[key]; ok {
 		return mi, nil
 	}
+	if kind == "mock" {
+		return ModuleInfo{}, fmt.Errorf("the inputs and outputs of mock module %s are not declared by its blueprint", source)
+	}
 
 	var modPath string
 	switch {
 	case sourcereader.IsGitPath(source):
-		tmpDir, err := ioutil.TempDir("", "module-*")
-		if err != nil {
+		// the clone is reused when the module is copied into the deployment
+		var err error
+		if modPath, err = sourcereader.FetchGitModule(ctx, source); err != nil {
 			return ModuleInfo{}, err
 		}
-		modPath = path.Join(tmpDir, "module")
-		sourceReader := sourcereader.Factory(source)
-		if err = sourceReader.GetModule(source, modPath); err != nil {
-			return ModuleInfo{}, fmt.Errorf("failed to clone git module at %s: %v", source, err)
-		}
 
 	case sourcereader.IsEmbeddedPath(source) || sourcereader.IsLocalPath(source):
 		modPath = source
@@ -153,6 +168,16 @@ func GetModuleInfo(source string, kind string) (ModuleInfo, error) {
 	if err != nil {
 		return ModuleInfo{}, err
 	}
+	md, err := readMetadata(modPath)
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+	mi.DeprecatedInputs = md.DeprecatedInputs
+	for i, o := range mi.Outputs {
+		if t, ok := md.OutputTypes[o.Name]; ok {
+			mi.Outputs[i].Type = t
+		}
+	}
 
 	// add APIs required by the module, if known
 	if sourcereader.IsEmbeddedPath(source) {
@@ -169,8 +194,8 @@ func GetModuleInfo(source string, kind string) (ModuleInfo, error) {
 	return mi, nil
 }
 
-// SetModuleInfo sets the ModuleInfo for a given source and kind
-// NOTE: This is only used for testing
+// SetModuleInfo sets the ModuleInfo for a given source and kind; it is used by
+// tests and for mock modules, whose info is declared in their blueprints
 func SetModuleInfo(source string, kind string, info ModuleInfo) {
 	modInfoCache[sourceAndKind{source, kind}] = info
 }
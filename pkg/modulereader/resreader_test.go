@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -51,6 +52,11 @@ output "test_output" {
 	description = "This is just a test"
 	value       = "test_value"
 }
+
+output "test_secret" {
+	value     = "test_value"
+	sensitive = true
+}
 `
 )
 
@@ -111,6 +117,7 @@ func (s *MySuite) TestGetModuleInfo_Embedded(c *C) {
 	moduleInfo, err := GetModuleInfo("modules/test_role/test_module", tfKindString)
 	c.Assert(err, IsNil)
 	c.Assert(moduleInfo.Inputs[0].Name, Equals, "test_variable")
+	sortOutputs(moduleInfo.Outputs)
 	c.Assert(moduleInfo.Outputs[0].Name, Equals, "test_output")
 
 	// Invalid: No embedded modules
@@ -147,6 +154,7 @@ func (s *MySuite) TestGetModuleInfo_Local(c *C) {
 	moduleInfo, err := GetModuleInfo(terraformDir, tfKindString)
 	c.Assert(err, IsNil)
 	c.Assert(moduleInfo.Inputs[0].Name, Equals, "test_variable")
+	sortOutputs(moduleInfo.Outputs)
 	c.Assert(moduleInfo.Outputs[0].Name, Equals, "test_output")
 
 	// Invalid source path - path does not exists
@@ -199,13 +207,21 @@ func (s *MySuite) TestGetInfo_TFReder(c *C) {
 	reader := NewTFReader()
 	info, err := reader.GetInfo(terraformDir)
 	c.Assert(err, IsNil)
+	sortOutputs(info.Outputs)
 	c.Check(info, DeepEquals, ModuleInfo{
-		Inputs:  []VarInfo{{Name: "test_variable", Type: "string", Description: "This is just a test", Required: true}},
-		Outputs: []OutputInfo{{Name: "test_output", Description: "This is just a test"}},
+		Inputs: []VarInfo{{Name: "test_variable", Type: "string", Description: "This is just a test", Required: true}},
+		Outputs: []OutputInfo{
+			{Name: "test_output", Description: "This is just a test"},
+			{Name: "test_secret", Sensitive: true}},
 	})
 
 }
 
+// sortOutputs sorts outputs by name, as tfconfig reads them into a map
+func sortOutputs(outputs []OutputInfo) {
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Name < outputs[j].Name })
+}
+
 // packerreader.go
 func (s *MySuite) TestGetInfo_PackerReader(c *C) {
 	// Didn't already exist, succeeds
@@ -360,3 +376,46 @@ func TestMain(m *testing.M) {
 	teardownTmpModule()
 	os.Exit(code)
 }
+
+func (s *MySuite) TestReadMetadata(c *C) {
+	dir := c.MkDir()
+
+	// modules without metadata
+	md, err := readMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Check(md.DeprecatedInputs, HasLen, 0)
+
+	metadata := `
+deprecated_inputs:
+- name: machine_size
+  replacement: machine_type
+- name: legacy_mode
+  message: it has no effect
+`
+	c.Assert(os.WriteFile(filepath.Join(dir, MetadataFilename), []byte(metadata), 0644), IsNil)
+	md, err = readMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Check(md.DeprecatedInputs, DeepEquals, []DeprecatedInput{
+		{Name: "machine_size", Replacement: "machine_type"},
+		{Name: "legacy_mode", Message: "it has no effect"},
+	})
+
+	// inputs cannot replace themselves
+	metadata = "deprecated_inputs: [{name: zone, replacement: zone}]"
+	c.Assert(os.WriteFile(filepath.Join(dir, MetadataFilename), []byte(metadata), 0644), IsNil)
+	_, err = readMetadata(dir)
+	c.Check(err, NotNil)
+
+	// the types of outputs must be types
+	metadata = "output_types:\n  network_self_link: string\n  subnetworks: list(object({name = string}))\n"
+	c.Assert(os.WriteFile(filepath.Join(dir, MetadataFilename), []byte(metadata), 0644), IsNil)
+	md, err = readMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Check(md.OutputTypes, DeepEquals, map[string]string{
+		"network_self_link": "string", "subnetworks": "list(object({name = string}))"})
+
+	metadata = "output_types: {network_self_link: str1ng}"
+	c.Assert(os.WriteFile(filepath.Join(dir, MetadataFilename), []byte(metadata), 0644), IsNil)
+	_, err = readMetadata(dir)
+	c.Check(err, ErrorMatches, `.*type "str1ng" of output network_self_link is not a type.*`)
+}
@@ -0,0 +1,617 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/deploymentio"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// languages of CDKTF projects
+const (
+	CDKTFTypeScript = "typescript"
+	CDKTFPython     = "python"
+)
+
+// CDKTFLanguages are the languages of the CDKTF projects that can be written
+var CDKTFLanguages = []string{CDKTFTypeScript, CDKTFPython}
+
+// CDKTFDirName is the directory of the CDKTF project in a deployment
+const CDKTFDirName = "cdktf"
+
+const (
+	cdktfVersion      = "^0.19.0"
+	constructsVersion = "^10.3.0"
+)
+
+// cdktfLang holds the syntax that differs between the languages of CDKTF
+// projects
+type cdktfLang struct {
+	name    string
+	app     string
+	main    string
+	comment string
+	null    string
+	boolean [2]string
+	// end terminates statements
+	end      string
+	preamble string
+	// statements of a group are enclosed in groupStart and groupEnd
+	groupStart, groupEnd, indent string
+	newStack                     string
+	dependOnPrevious             string
+	moduleFn                     string
+	sensitiveVariable            string
+	override                     string
+	// source returns an expression of the absolute path of a path relative to
+	// the deployment directory
+	source  func(string) string
+	backend func(args map[string]string) string
+	files   map[string]string
+	trailer string
+}
+
+var cdktfLangs = map[string]cdktfLang{
+	CDKTFTypeScript: {
+		name:    CDKTFTypeScript,
+		app:     "npx ts-node main.ts",
+		main:    "main.ts",
+		comment: "//",
+		null:    "null",
+		boolean: [2]string{"false", "true"},
+		end:     ";",
+		preamble: `import * as path from "path";
+import {
+  App,
+  GcsBackend,
+  TerraformHclModule,
+  TerraformLocal,
+  TerraformOutput,
+  TerraformStack,
+  TerraformVariable,
+} from "cdktf";
+
+const app = new App();
+// modules by "<group>.<module id>", for references across groups
+const modules: Record<string, TerraformHclModule> = {};
+let previous: TerraformStack | undefined;
+
+// the logical IDs of variables, locals, modules and outputs are those of the
+// Terraform deployment groups, so that expressions can refer to them
+
+function variable(stack: TerraformStack, name: string, value: any) {
+  new TerraformVariable(stack, name, { default: value }).overrideLogicalId(name);
+}
+
+// sensitive variables have no default, set them with TF_VAR_name
+function sensitiveVariable(stack: TerraformStack, name: string) {
+  new TerraformVariable(stack, name, { sensitive: true }).overrideLogicalId(name);
+}
+
+function local(stack: TerraformStack, name: string, value: any) {
+  new TerraformLocal(stack, name, value).overrideLogicalId(name);
+}
+
+function hclModule(stack: TerraformStack, id: string, source: string, variables: Record<string, any>): TerraformHclModule {
+  const m = new TerraformHclModule(stack, id, { source, variables });
+  m.overrideLogicalId(id);
+  return m;
+}
+
+function output(stack: TerraformStack, name: string, value: any, description: string, sensitive: boolean) {
+  new TerraformOutput(stack, name, { value, description, sensitive }).overrideLogicalId(name);
+}
+`,
+		groupStart:        "{\n",
+		groupEnd:          "}\n",
+		indent:            "  ",
+		newStack:          "const stack = new TerraformStack(app, %s)",
+		dependOnPrevious:  "if (previous) stack.addDependency(previous)",
+		moduleFn:          "hclModule",
+		sensitiveVariable: "sensitiveVariable",
+		override:          "addOverride",
+		source: func(p string) string {
+			return fmt.Sprintf("path.resolve(__dirname, %s)", cdktfQuote(filepath.ToSlash(filepath.Join("..", p))))
+		},
+		backend: func(args map[string]string) string {
+			props := []string{}
+			for _, k := range orderKeys(args) {
+				props = append(props, fmt.Sprintf("%s: %s", snakeToCamel(k), args[k]))
+			}
+			return fmt.Sprintf("new GcsBackend(stack, { %s })", strings.Join(props, ", "))
+		},
+		files: map[string]string{
+			"package.json": `{
+  "name": "ghpc-deployment",
+  "version": "1.0.0",
+  "private": true,
+  "main": "main.js",
+  "scripts": {
+    "synth": "cdktf synth"
+  },
+  "dependencies": {
+    "cdktf": "` + cdktfVersion + `",
+    "constructs": "` + constructsVersion + `"
+  },
+  "devDependencies": {
+    "@types/node": "^20.0.0",
+    "ts-node": "^10.9.1",
+    "typescript": "^5.2.2"
+  }
+}
+`,
+			"tsconfig.json": `{
+  "compilerOptions": {
+    "target": "es2018",
+    "module": "commonjs",
+    "strict": true,
+    "esModuleInterop": true
+  },
+  "exclude": ["node_modules", "cdktf.out"]
+}
+`,
+		},
+		trailer: "app.synth();\n",
+	},
+	CDKTFPython: {
+		name:    CDKTFPython,
+		app:     "python3 main.py",
+		main:    "main.py",
+		comment: "#",
+		null:    "None",
+		boolean: [2]string{"False", "True"},
+		preamble: `import os
+
+from cdktf import (
+    App,
+    GcsBackend,
+    TerraformHclModule,
+    TerraformLocal,
+    TerraformOutput,
+    TerraformStack,
+    TerraformVariable,
+)
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+app = App()
+# modules by "<group>.<module id>", for references across groups
+modules = {}
+previous = None
+
+# the logical IDs of variables, locals, modules and outputs are those of the
+# Terraform deployment groups, so that expressions can refer to them
+
+
+def variable(stack, name, value):
+    TerraformVariable(stack, name, default=value).override_logical_id(name)
+
+
+# sensitive variables have no default, set them with TF_VAR_name
+def sensitive_variable(stack, name):
+    TerraformVariable(stack, name, sensitive=True).override_logical_id(name)
+
+
+def local(stack, name, value):
+    TerraformLocal(stack, name, value).override_logical_id(name)
+
+
+def hcl_module(stack, module_id, source, variables):
+    m = TerraformHclModule(stack, module_id, source=source, variables=variables)
+    m.override_logical_id(module_id)
+    return m
+
+
+def output(stack, name, value, description, sensitive):
+    TerraformOutput(stack, name, value=value, description=description,
+                    sensitive=sensitive).override_logical_id(name)
+`,
+		newStack:          "stack = TerraformStack(app, %s)",
+		dependOnPrevious:  "if previous:\n    stack.add_dependency(previous)",
+		moduleFn:          "hcl_module",
+		sensitiveVariable: "sensitive_variable",
+		override:          "add_override",
+		source: func(p string) string {
+			return fmt.Sprintf("os.path.join(HERE, %s)", cdktfQuote(filepath.ToSlash(filepath.Join("..", p))))
+		},
+		backend: func(args map[string]string) string {
+			kwargs := []string{}
+			for _, k := range orderKeys(args) {
+				kwargs = append(kwargs, fmt.Sprintf("%s=%s", k, args[k]))
+			}
+			return fmt.Sprintf("GcsBackend(stack, %s)", strings.Join(kwargs, ", "))
+		},
+		files: map[string]string{
+			"requirements.txt": "cdktf" + strings.Replace(cdktfVersion, "^", "~=", 1) + "\n" +
+				"constructs" + strings.Replace(constructsVersion, "^", "~=", 1) + "\n",
+		},
+		trailer: "app.synth()\n",
+	},
+}
+
+// WriteCDKTFProject writes a CDKTF project in the given language to the cdktf
+// directory of a deployment written by WriteDeployment. Each Terraform group
+// is a stack calling the modules copied to the group directory with the same
+// settings; references to other groups are cross-stack references and every
+// stack depends on the previous one, so that cdktf deploys the groups in
+// order. Packer groups are not part of the project.
+func WriteCDKTFProject(dc config.DeploymentConfig, deploymentDir string, lang string) error {
+	l, ok := cdktfLangs[lang]
+	if !ok {
+		return fmt.Errorf("unsupported CDKTF language %q, must be one of %v", lang, CDKTFLanguages)
+	}
+	dir := filepath.Join(deploymentDir, CDKTFDirName)
+	if err := deploymentio.MkdirAll(dir); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString(l.license())
+	b.WriteString("\n")
+	b.WriteString(l.preamble)
+	for grpIdx, grp := range dc.Config.DeploymentGroups {
+		b.WriteString("\n")
+		if grp.Kind != config.TerraformKind {
+			fmt.Fprintf(&b, "%s group %s is of kind %s, deploy it with ghpc deploy\n", l.comment, grp.Name, grp.Kind)
+			continue
+		}
+		if err := l.writeGroup(&b, dc, grpIdx); err != nil {
+			return fmt.Errorf("error writing deployment group %s to the CDKTF project: %w", grp.Name, err)
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(l.trailer)
+
+	files := map[string]string{l.main: b.String()}
+	maps.Copy(files, l.files)
+	cdktfJSON, err := json.MarshalIndent(map[string]interface{}{
+		"language":           l.name,
+		"app":                l.app,
+		"terraformProviders": []string{},
+		"terraformModules":   []string{},
+		"context":            map[string]interface{}{},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	files["cdktf.json"] = string(cdktfJSON) + "\n"
+	files[".gitignore"] = "cdktf.out/\nnode_modules/\n__pycache__/\n*.js\n*.d.ts\n"
+	for name, content := range files {
+		if err := deploymentio.WriteFile(filepath.Join(dir, name), []byte(content), false); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	// the project is part of the deployment that ghpc verify checks
+	return writeManifest(deploymentDir, dc)
+}
+
+func (l cdktfLang) writeGroup(b *strings.Builder, dc config.DeploymentConfig, grpIdx int) error {
+	grp := dc.Config.DeploymentGroups[grpIdx]
+	vars := getUsedDeploymentVars(grp, dc.Config)
+	igcVars := FindIntergroupVariables(grp, dc.Config)
+	stmt := func(format string, a ...interface{}) {
+		lines := strings.Split(fmt.Sprintf(format, a...), "\n")
+		b.WriteString(l.indent + strings.Join(lines, "\n"+l.indent) + l.end + "\n")
+	}
+	modKey := func(g config.GroupName, id config.ModuleID) string {
+		return cdktfQuote(fmt.Sprintf("%s.%s", g, id))
+	}
+
+	fmt.Fprintf(b, "%s deployment group %s\n", l.comment, grp.Name)
+	b.WriteString(l.groupStart)
+	stmt(l.newStack, cdktfQuote(string(grp.Name)))
+	stmt("%s", l.dependOnPrevious)
+
+	if states, err := dc.Config.RemoteStates(grp); err != nil {
+		return err
+	} else if len(states) > 0 {
+		return fmt.Errorf("references to the outputs of external deployment %s are not supported by CDKTF projects", states[0].Name)
+	}
+
+	overrides, err := l.stackOverrides(grp, dc.Config, vars)
+	if err != nil {
+		return err
+	}
+	for _, o := range overrides {
+		stmt("%s", o)
+	}
+	if be := grp.TerraformBackend; be.Type != "" {
+		if be.Type != "gcs" {
+			return fmt.Errorf("terraform_backend of type %s is not supported by CDKTF projects, use gcs", be.Type)
+		}
+		items := be.Configuration.Items()
+		args := map[string]string{}
+		for k, v := range items {
+			args[k] = l.literal(v)
+		}
+		stmt("%s", l.backend(args))
+	}
+
+	sensitive := dc.Config.SensitiveVars()
+	for _, name := range orderKeys(vars) {
+		if slices.Contains(sensitive, name) {
+			stmt("%s(stack, %s)", l.sensitiveVariable, cdktfQuote(name))
+		} else {
+			stmt("variable(stack, %s, %s)", cdktfQuote(name), l.literal(vars[name]))
+		}
+	}
+
+	// values of other groups are locals named like the intergroup variables
+	// of Terraform groups
+	refs := maps.Keys(igcVars)
+	slices.SortFunc(refs, func(a, b config.Reference) bool { return igcVars[a].Name < igcVars[b].Name })
+	for _, r := range refs {
+		producer := dc.Config.ModuleGroupOrDie(r.Module).Name
+		stmt("local(stack, %s, modules[%s].get(%s))",
+			cdktfQuote(igcVars[r].Name), modKey(producer, r.Module), cdktfQuote(r.Name))
+	}
+
+	aliased := deploymentProjectModules(grp)
+	for _, mod := range substituteIgcReferences(grp.Modules, igcVars, "local") {
+		key := modKey(grp.Name, mod.ID)
+		source, err := l.moduleSource(grp.Name, mod)
+		if err != nil {
+			return err
+		}
+		settings, err := l.settings(mod)
+		if err != nil {
+			return fmt.Errorf("module %s: %w", mod.ID, err)
+		}
+		stmt("modules[%s] = %s(stack, %s, %s, %s)", key, l.moduleFn, cdktfQuote(string(mod.ID)), source, settings)
+
+		deps := []cty.Value{}
+		for _, dep := range mod.Depends {
+			if slices.ContainsFunc(grp.Modules, func(m config.Module) bool { return m.ID == dep }) {
+				deps = append(deps, cty.StringVal("module."+string(dep)))
+			}
+		}
+		if len(deps) > 0 {
+			stmt("modules[%s].%s(\"depends_on\", %s)", key, l.override, l.literal(cty.TupleVal(deps)))
+		}
+		if slices.Contains(aliased, mod.ID) {
+			p := map[string]cty.Value{}
+			for _, prov := range googleProviders {
+				p[prov] = cty.StringVal(prov + "." + deploymentProviderAlias)
+			}
+			stmt("modules[%s].%s(\"providers\", %s)", key, l.override, l.literal(cty.ObjectVal(p)))
+		}
+	}
+
+	for _, mod := range grp.Modules {
+		for _, out := range mod.Outputs {
+			desc := out.Description
+			if desc == "" {
+				desc = fmt.Sprintf("Generated output from module '%s'", mod.ID)
+			}
+			stmt("output(stack, %s, modules[%s].get(%s), %s, %s)",
+				cdktfQuote(config.AutomaticOutputName(out.Name, mod.ID)),
+				modKey(grp.Name, mod.ID), cdktfQuote(out.Name), cdktfQuote(desc), l.bool(out.Sensitive))
+		}
+	}
+	stmt("previous = stack")
+	b.WriteString(l.groupEnd)
+	return nil
+}
+
+// stackOverrides returns the statements setting the Terraform and provider
+// configuration of a stack, which match versions.tf, providers.tf and
+// imports.tf of the Terraform group
+func (l cdktfLang) stackOverrides(grp config.DeploymentGroup, bp config.Blueprint, vars map[string]cty.Value) ([]string, error) {
+	imports := []cty.Value{}
+	for _, mod := range grp.Modules {
+		ids, err := mod.ResolvedImports(bp)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range orderKeys(ids) {
+			imports = append(imports, cty.ObjectVal(map[string]cty.Value{
+				"to": cty.StringVal(fmt.Sprintf("module.%s.%s", mod.ID, addr)),
+				"id": cty.StringVal(ids[addr]),
+			}))
+		}
+	}
+
+	providers := map[string]cty.Value{}
+	for _, prov := range googleProviders {
+		providers[prov] = cty.ObjectVal(map[string]cty.Value{
+			"source":  cty.StringVal("hashicorp/" + prov),
+			"version": cty.StringVal(googleProviderVersion),
+		})
+	}
+
+	_, usesDeploymentProject := vars["project_id"]
+	providerConfig := func(project string, alias string) cty.Value {
+		c := map[string]cty.Value{}
+		if project != "" {
+			c["project"] = cty.StringVal(project)
+		} else if usesDeploymentProject {
+			c["project"] = config.GlobalRef("project_id").AsExpression().AsValue()
+		}
+		for _, v := range []string{"zone", "region"} {
+			if _, ok := vars[v]; ok {
+				c[v] = config.GlobalRef(v).AsExpression().AsValue()
+			}
+		}
+		if sa := bp.ImpersonatedServiceAccount(grp); sa != "" {
+			c["impersonate_service_account"] = cty.StringVal(sa)
+		}
+		if alias != "" {
+			c["alias"] = cty.StringVal(alias)
+		}
+		return cty.ObjectVal(c)
+	}
+	configs := map[string][]cty.Value{}
+	for _, prov := range googleProviders {
+		configs[prov] = []cty.Value{providerConfig(grp.ProjectID, "")}
+		if grp.ProjectID != "" && usesDeploymentProject {
+			configs[prov] = append(configs[prov], providerConfig("", deploymentProviderAlias))
+		}
+	}
+	provVals := map[string]cty.Value{}
+	for prov, c := range configs {
+		provVals[prov] = cty.TupleVal(c)
+	}
+
+	stmts := []string{}
+	add := func(path string, v cty.Value) {
+		stmts = append(stmts, fmt.Sprintf("stack.%s(%s, %s)", l.override, cdktfQuote(path), l.literal(v)))
+	}
+	if len(imports) > 0 {
+		add("terraform.required_version", cty.StringVal(importsTerraformVersion))
+		add("import", cty.TupleVal(imports))
+	} else {
+		add("terraform.required_version", cty.StringVal(terraformVersion))
+	}
+	add("terraform.required_providers", cty.ObjectVal(providers))
+	add("provider", cty.ObjectVal(provVals))
+	return stmts, nil
+}
+
+// moduleSource returns an expression of the source of a module; local
+// sources are the copies in the group directory
+func (l cdktfLang) moduleSource(grp config.GroupName, mod config.Module) (string, error) {
+	ds, err := deploymentSource(mod)
+	if err != nil {
+		return "", err
+	}
+	if sourcereader.IsGitPath(mod.Source) {
+		return cdktfQuote(ds), nil
+	}
+	return l.source(filepath.Join(string(grp), ds)), nil
+}
+
+// settings returns the module settings as a dictionary
+func (l cdktfLang) settings(mod config.Module) (string, error) {
+	items := mod.Settings.Items()
+	parts := []string{}
+	for _, k := range orderKeys(items) {
+		v := items[k]
+		var s string
+		if ts, ok := mod.Transforms[k]; ok {
+			toks, err := config.TokensForTransformed(ts, v, TokensForValue)
+			if err != nil {
+				return "", fmt.Errorf("failed to process %s: %v", k, err)
+			}
+			s = interpolation(toks)
+		} else {
+			s = l.literal(v)
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", cdktfQuote(k), s))
+	}
+	return "{" + strings.Join(parts, ", ") + "}", nil
+}
+
+// literal returns a value in the syntax of the language; expressions are
+// Terraform interpolations, e.g. "${var.zone}", which Terraform evaluates in
+// the JSON configuration synthesized by cdktf
+func (l cdktfLang) literal(v cty.Value) string {
+	if v.IsNull() {
+		return l.null
+	}
+	if _, is := config.IsExpressionValue(v); is {
+		return interpolation(TokensForValue(v))
+	}
+	if _, is := config.IsYamlExpressionLiteral(v); is {
+		return interpolation(TokensForValue(v))
+	}
+	t := v.Type()
+	switch {
+	case t == cty.String:
+		return cdktfQuote(cdktfEscape(config.UnescapeBlueprintString(v.AsString())))
+	case t == cty.Number:
+		return v.AsBigFloat().Text('f', -1)
+	case t == cty.Bool:
+		return l.bool(v.True())
+	case t.IsListType() || t.IsTupleType() || t.IsSetType():
+		els := []string{}
+		for _, e := range v.AsValueSlice() {
+			els = append(els, l.literal(e))
+		}
+		return "[" + strings.Join(els, ", ") + "]"
+	default: // maps and objects
+		m := v.AsValueMap()
+		els := []string{}
+		for _, k := range orderKeys(m) {
+			els = append(els, fmt.Sprintf("%s: %s", cdktfQuote(k), l.literal(m[k])))
+		}
+		return "{" + strings.Join(els, ", ") + "}"
+	}
+}
+
+func (l cdktfLang) bool(b bool) string {
+	if b {
+		return l.boolean[1]
+	}
+	return l.boolean[0]
+}
+
+func (l cdktfLang) license() string {
+	if l.name == CDKTFTypeScript {
+		return license
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(license), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "/**" || line == "*/" {
+			continue
+		}
+		line = strings.TrimPrefix(strings.TrimPrefix(line, "*"), " ")
+		b.WriteString(strings.TrimSpace("# " + line))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// interpolation returns the Terraform JSON string of an HCL expression
+func interpolation(toks hclwrite.Tokens) string {
+	return cdktfQuote("${" + strings.TrimSpace(string(hclwrite.Format(toks.Bytes()))) + "}")
+}
+
+// cdktfEscape escapes the template sequences of a literal string, which
+// Terraform would otherwise interpret in JSON configuration
+func cdktfEscape(s string) string {
+	s = strings.ReplaceAll(s, "${", "$${")
+	return strings.ReplaceAll(s, "%{", "%%{")
+}
+
+// cdktfQuote returns a string literal that is valid in TypeScript and Python
+func cdktfQuote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -17,7 +17,6 @@ package modulewriter
 import (
 	"fmt"
 	"path/filepath"
-	"regexp"
 
 	"hpc-toolkit/pkg/config"
 
@@ -26,24 +25,22 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
-func escapeBlueprintVariables(s string) string {
-	// Convert \$(not.variable) to $(not.variable)
-	re := regexp.MustCompile(`\\\$\(`)
-	return re.ReplaceAllString(s, `$(`)
-}
-
-func escapeLiteralVariables(s string) string {
-	// Convert \((not.variable)) to ((not.variable))
-	re := regexp.MustCompile(`\\\(\(`)
-	return re.ReplaceAllString(s, `((`)
-}
-
 // WriteHclAttributes writes tfvars/pkvars.hcl files
 func WriteHclAttributes(vars map[string]cty.Value, dst string) error {
 	if err := createBaseFile(dst); err != nil {
 		return fmt.Errorf("error creating variables file %v: %v", filepath.Base(dst), err)
 	}
 
+	err := appendHCLToFile(dst, renderHclAttributes(vars))
+	if err != nil {
+		return fmt.Errorf("error writing HCL to %v: %v", filepath.Base(dst), err)
+	}
+	return err
+}
+
+// renderHclAttributes returns the HCL of the attributes of a tfvars/pkvars.hcl
+// file, in the order of their names
+func renderHclAttributes(vars map[string]cty.Value) []byte {
 	hclFile := hclwrite.NewEmptyFile()
 	hclBody := hclFile.Body()
 	for _, k := range orderKeys(vars) {
@@ -51,13 +48,7 @@ func WriteHclAttributes(vars map[string]cty.Value, dst string) error {
 		toks := TokensForValue(vars[k])
 		hclBody.SetAttributeRaw(k, toks)
 	}
-
-	hclBytes := hclFile.Bytes()
-	err := appendHCLToFile(dst, hclBytes)
-	if err != nil {
-		return fmt.Errorf("error writing HCL to %v: %v", filepath.Base(dst), err)
-	}
-	return err
+	return hclFile.Bytes()
 }
 
 // TokensForValue is a modification of hclwrite.TokensForValue.
@@ -76,11 +67,7 @@ func TokensForValue(val cty.Value) hclwrite.Tokens {
 
 	ty := val.Type()
 	if ty == cty.String {
-		s := val.AsString()
-		// The order of application matters, for an edge cases like: `\$\((` -> `$((`
-		s = escapeLiteralVariables(s)
-		s = escapeBlueprintVariables(s)
-		return hclwrite.TokensForValue(cty.StringVal(s))
+		return hclwrite.TokensForValue(cty.StringVal(config.UnescapeBlueprintString(val.AsString())))
 	}
 
 	if ty.IsListType() || ty.IsSetType() || ty.IsTupleType() {
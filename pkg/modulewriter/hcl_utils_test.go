@@ -22,6 +22,8 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/zclconf/go-cty/cty"
 )
@@ -68,6 +70,36 @@ func TestTokensForValueWithLiteral(t *testing.T) {
 	}
 }
 
+func TestTokensForValueEscaped(t *testing.T) {
+	// every escaped blueprint string is written as an HCL string whose value
+	// is the original string, including Terraform template sequences
+	all, last := []string{""}, []string{""}
+	for i := 0; i < 5; i++ {
+		next := []string{}
+		for _, s := range last {
+			for _, c := range `$(){%\a` {
+				next = append(next, s+string(c))
+			}
+		}
+		all, last = append(all, next...), next
+	}
+
+	for _, s := range all {
+		src := TokensForValue(cty.StringVal(config.EscapeBlueprintString(s))).Bytes()
+		expr, diags := hclsyntax.ParseExpression(src, "", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			t.Fatalf("failed to parse %s written for %q: %v", src, s, diags)
+		}
+		got, diags := expr.Value(nil)
+		if diags.HasErrors() {
+			t.Fatalf("failed to evaluate %s written for %q: %v", src, s, diags)
+		}
+		if got.AsString() != s {
+			t.Fatalf("%s written for %q evaluates to %q", src, s, got.AsString())
+		}
+	}
+}
+
 func TestHclAtttributesRW(t *testing.T) {
 	want := make(map[string]cty.Value)
 	// test that a string that needs escaping when written is read correctly
@@ -0,0 +1,196 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/deploymentio"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const manifestName = "manifest.yaml"
+
+// Manifest records the SHA-256 hashes of the files written by ghpc create,
+// by their slash-separated path relative to the deployment directory, and
+// the versions of Terraform that can deploy them
+type Manifest struct {
+	Created          time.Time         `yaml:"created"`
+	TerraformVersion string            `yaml:"terraform_version,omitempty"`
+	Files            map[string]string `yaml:"files"`
+}
+
+// ManifestDiff lists the files of a deployment directory that diverge from
+// its manifest
+type ManifestDiff struct {
+	Modified []string
+	Missing  []string
+	Added    []string
+}
+
+// Empty returns true if no file diverges from the manifest
+func (d ManifestDiff) Empty() bool {
+	return len(d.Modified) == 0 && len(d.Missing) == 0 && len(d.Added) == 0
+}
+
+// ManifestPath returns the path of the manifest of a deployment directory
+func ManifestPath(depDir string) string {
+	return filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName, manifestName)
+}
+
+// isRecorded returns false for the files of a deployment directory that are
+// not written by ghpc create, such as Terraform state and the inputs imported
+// by ghpc deploy, and for the contents of the .ghpc directory other than the
+// expanded blueprint and the record of its secrets, which drive ghpc deploy
+func isRecorded(rel string) bool {
+	if strings.HasPrefix(rel, HiddenGhpcDirName+"/") {
+		return rel == path.Join(HiddenGhpcDirName, ArtifactsDirName, expandedBlueprintName) ||
+			rel == path.Join(HiddenGhpcDirName, ArtifactsDirName, config.SecretsRecordFilename(expandedBlueprintName))
+	}
+	for _, dir := range strings.Split(path.Dir(rel), "/") {
+		switch dir {
+		case ".terraform", ".terragrunt-cache", "packer_cache", "cdktf.out", "node_modules", "__pycache__":
+			return false
+		}
+	}
+	base := path.Base(rel)
+	for _, pattern := range []string{
+		"*.tfstate", "*.tfstate.*", ".terraform.lock.hcl", "crash*.log",
+		"*_inputs.auto.tfvars", "*_inputs.auto.pkrvars.hcl", "packer-manifest.json",
+	} {
+		if ok, _ := path.Match(pattern, base); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// hashDeployment returns the hashes of the recorded files of a deployment
+// directory
+func hashDeployment(depDir string) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := filepath.WalkDir(depDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(depDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !isRecorded(rel) {
+			return nil
+		}
+		h, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		hashes[rel] = h
+		return nil
+	})
+	return hashes, err
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeManifest records the hashes of the files of a deployment directory and
+// the versions of Terraform that can deploy it
+func writeManifest(depDir string, dc config.DeploymentConfig) error {
+	tfVersion, err := TerraformVersion(dc)
+	if err != nil {
+		return err
+	}
+	hashes, err := hashDeployment(depDir)
+	if err != nil {
+		return fmt.Errorf("failed to hash the files of deployment %s: %w", depDir, err)
+	}
+	b, err := yaml.Marshal(Manifest{Created: time.Now().UTC(), TerraformVersion: tfVersion, Files: hashes})
+	if err != nil {
+		return err
+	}
+	return deploymentio.WriteFile(ManifestPath(depDir), b, false)
+}
+
+// ReadManifest reads the manifest of a deployment directory
+func ReadManifest(depDir string) (Manifest, error) {
+	var m Manifest
+	b, err := os.ReadFile(ManifestPath(depDir))
+	if err != nil {
+		return m, fmt.Errorf("failed to read the manifest of deployment %s: %w", depDir, err)
+	}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("failed to read the manifest of deployment %s: %w", depDir, err)
+	}
+	return m, nil
+}
+
+// VerifyDeployment compares the files of a deployment directory with the
+// hashes recorded in its manifest when it was written
+func VerifyDeployment(depDir string) (ManifestDiff, error) {
+	var diff ManifestDiff
+	m, err := ReadManifest(depDir)
+	if err != nil {
+		return diff, err
+	}
+	hashes, err := hashDeployment(depDir)
+	if err != nil {
+		return diff, fmt.Errorf("failed to hash the files of deployment %s: %w", depDir, err)
+	}
+
+	for rel, want := range m.Files {
+		got, ok := hashes[rel]
+		switch {
+		case !ok:
+			diff.Missing = append(diff.Missing, rel)
+		case got != want:
+			diff.Modified = append(diff.Modified, rel)
+		}
+	}
+	for _, rel := range maps.Keys(hashes) {
+		if _, ok := m.Files[rel]; !ok {
+			diff.Added = append(diff.Added, rel)
+		}
+	}
+	slices.Sort(diff.Modified)
+	slices.Sort(diff.Missing)
+	slices.Sort(diff.Added)
+	return diff, nil
+}
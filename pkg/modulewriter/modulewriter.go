@@ -42,8 +42,23 @@ const (
 	gitignoreTemplate          = "deployment.gitignore.tmpl"
 	artifactsWarningFilename   = "DO_NOT_MODIFY_THIS_DIRECTORY"
 	expandedBlueprintName      = "expanded_blueprint.yaml"
+	validationReportName       = "validation_report.yaml"
+	resolvedVarsName           = "resolved_vars.yaml"
+	instructionsFilename       = "instructions.txt"
 )
 
+// OutputsFilename names the artifact in which the outputs of a group are
+// exported for the groups that use them
+func OutputsFilename(group config.GroupName) string {
+	return fmt.Sprintf("%s_outputs.tfvars", string(group))
+}
+
+// OutputTypesFilename names the artifact that records the Terraform types of
+// the exported outputs of a group
+func OutputTypesFilename(group config.GroupName) string {
+	return fmt.Sprintf("%s_output_types.json", string(group))
+}
+
 // ModuleWriter interface for writing modules to a deployment
 type ModuleWriter interface {
 	getNumModules() int
@@ -54,6 +69,9 @@ type ModuleWriter interface {
 		deployDir string,
 		instructionsFile io.Writer,
 	) error
+	// renderDeploymentGroup returns the generated files of a group in
+	// memory, by path relative to the group directory
+	renderDeploymentGroup(dc config.DeploymentConfig, grpIdx int) (map[string][]byte, error)
 	restoreState(deploymentDir string) error
 	kind() config.ModuleKind
 }
@@ -79,71 +97,137 @@ func factory(kind string) ModuleWriter {
 // WriteDeployment writes a deployment directory using modules defined the
 // environment blueprint.
 func WriteDeployment(dc config.DeploymentConfig, outputDir string, overwriteFlag bool) error {
-	deploymentName, err := dc.Config.DeploymentName()
+	return WriteDeploymentGroups(dc, outputDir, overwriteFlag, nil)
+}
+
+// WriteDeploymentGroups writes a deployment directory in which only the named
+// deployment groups are regenerated. The remaining groups must already exist
+// in the deployment directory and are left untouched. All groups are written
+// when groups is empty.
+func WriteDeploymentGroups(dc config.DeploymentConfig, outputDir string, overwriteFlag bool, groups []config.GroupName) error {
+	deploymentDir, err := writeDeployment(dc, outputDir, overwriteFlag, groups)
 	if err != nil {
 		return err
 	}
+
+	fmt.Println("To deploy your infrastructure please run:")
+	fmt.Println()
+	fmt.Printf("./ghpc deploy %s\n", deploymentDir)
+	fmt.Println()
+	fmt.Println("Find instructions for cleanly destroying infrastructure and advanced manual")
+	fmt.Println("deployment instructions at:")
+	fmt.Println()
+	fmt.Printf("%s\n", filepath.Join(deploymentDir, instructionsFilename))
+
+	return nil
+}
+
+// writeDeployment writes the deployment directory and returns its path
+func writeDeployment(dc config.DeploymentConfig, outputDir string, overwriteFlag bool, groups []config.GroupName) (string, error) {
+	if mocks := dc.Config.MockModules(); len(mocks) > 0 {
+		return "", fmt.Errorf("the blueprint has mock modules %v, which can be expanded and validated but not deployed", mocks)
+	}
+	deploymentName, err := dc.Config.DeploymentName()
+	if err != nil {
+		return "", err
+	}
 	deploymentDir := filepath.Join(outputDir, deploymentName)
 
-	overwrite := isOverwriteAllowed(deploymentDir, &dc.Config, overwriteFlag)
-	if err := prepDepDir(deploymentDir, overwrite); err != nil {
-		return err
+	write := map[config.GroupName]bool{}
+	for _, grp := range dc.Config.DeploymentGroups {
+		write[grp.Name] = len(groups) == 0
+	}
+	for _, g := range groups {
+		if _, ok := write[g]; !ok {
+			return "", fmt.Errorf("could not find group %s in blueprint", g)
+		}
+		write[g] = true
 	}
 
-	if err := copySource(deploymentDir, &dc.Config.DeploymentGroups); err != nil {
-		return err
+	overwrite := isOverwriteAllowed(deploymentDir, &dc.Config, overwriteFlag)
+	if err := checkSkippedGroups(deploymentDir, dc.Config.DeploymentGroups, write, overwrite); err != nil {
+		return "", err
+	}
+	skipped := []config.GroupName{}
+	for _, grp := range dc.Config.DeploymentGroups {
+		if !write[grp.Name] {
+			skipped = append(skipped, grp.Name)
+		}
+	}
+	if err := prepDepDir(deploymentDir, overwrite, skipped); err != nil {
+		return "", err
 	}
 
-	if err := createGroupDirs(deploymentDir, &dc.Config.DeploymentGroups); err != nil {
-		return err
+	for iGrp, grp := range dc.Config.DeploymentGroups {
+		if !write[grp.Name] {
+			if err := restoreGroupDir(deploymentDir, grp.Name); err != nil {
+				return "", err
+			}
+			continue
+		}
+		// re-slice so that module changes are made to the blueprint groups
+		grps := dc.Config.DeploymentGroups[iGrp : iGrp+1]
+		if err := copySource(deploymentDir, &grps); err != nil {
+			return "", err
+		}
+		if err := createGroupDirs(deploymentDir, &grps); err != nil {
+			return "", err
+		}
 	}
 
-	advancedDeployInstructions := filepath.Join(deploymentDir, "instructions.txt")
-	f, err := os.Create(advancedDeployInstructions)
+	advancedDeployInstructions := filepath.Join(deploymentDir, instructionsFilename)
+	f, err := deploymentio.Create(advancedDeployInstructions)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer f.Close()
 	fmt.Fprintln(f, "Advanced Deployment Instructions")
 	fmt.Fprintln(f, "================================")
 
 	for grpIdx, grp := range dc.Config.DeploymentGroups {
+		if !write[grp.Name] {
+			fmt.Fprintf(f, "\nDeployment group %s was not regenerated\n", grp.Name)
+			continue
+		}
 		writer, ok := kinds[grp.Kind.String()]
 		if !ok {
-			return fmt.Errorf(
+			return "", fmt.Errorf(
 				"invalid kind in deployment group %s, got '%s'", grp.Name, grp.Kind)
 		}
 
 		err := writer.writeDeploymentGroup(dc, grpIdx, deploymentDir, f)
 		if err != nil {
-			return fmt.Errorf("error writing deployment group %s: %w", grp.Name, err)
+			return "", fmt.Errorf("error writing deployment group %s: %w", grp.Name, err)
 		}
 	}
 
 	writeDestroyInstructions(f, dc, deploymentDir)
 
 	if err := writeExpandedBlueprint(deploymentDir, dc); err != nil {
-		return err
+		return "", err
+	}
+
+	if err := writeValidationReport(deploymentDir, dc); err != nil {
+		return "", err
+	}
+
+	if err := writeResolvedVars(deploymentDir, dc); err != nil {
+		return "", err
 	}
 
 	for _, writer := range kinds {
 		if writer.getNumModules() > 0 {
 			if err := writer.restoreState(deploymentDir); err != nil {
-				return fmt.Errorf("error trying to restore terraform state: %w", err)
+				return "", fmt.Errorf("error trying to restore terraform state: %w", err)
 			}
 		}
 	}
 
-	fmt.Println("To deploy your infrastructure please run:")
-	fmt.Println()
-	fmt.Printf("./ghpc deploy %s\n", deploymentDir)
-	fmt.Println()
-	fmt.Println("Find instructions for cleanly destroying infrastructure and advanced manual")
-	fmt.Println("deployment instructions at:")
-	fmt.Println()
-	fmt.Printf("%s\n", f.Name())
+	if err := writeManifest(deploymentDir, dc); err != nil {
+		return "", err
+	}
 
-	return nil
+	return deploymentDir, nil
 }
 
 func createGroupDirs(deploymentPath string, deploymentGroups *[]config.DeploymentGroup) error {
@@ -151,7 +235,7 @@ func createGroupDirs(deploymentPath string, deploymentGroups *[]config.Deploymen
 		groupPath := filepath.Join(deploymentPath, string(grp.Name))
 		// Create the deployment group directory if not already created.
 		if _, err := os.Stat(groupPath); errors.Is(err, os.ErrNotExist) {
-			if err := os.Mkdir(groupPath, 0755); err != nil {
+			if err := deploymentio.Mkdir(groupPath); err != nil {
 				return fmt.Errorf("failed to create directory at %s for deployment group %s: err=%w",
 					groupPath, grp.Name, err)
 			}
@@ -163,7 +247,7 @@ func createGroupDirs(deploymentPath string, deploymentGroups *[]config.Deploymen
 // Get module source within deployment group
 // Rules are following:
 //   - git source
-//     => keep the same source
+//     => keep the same source, selecting the commit of a git: source hash
 //   - packer
 //     => <mod.ID>
 //   - embedded (source starts with "modules" or "comunity/modules")
@@ -172,7 +256,7 @@ func createGroupDirs(deploymentPath string, deploymentGroups *[]config.Deploymen
 //     => ./modules/<basename(source)>-<hash(abs(source))>
 func deploymentSource(mod config.Module) (string, error) {
 	if sourcereader.IsGitPath(mod.Source) && mod.Kind == config.TerraformKind {
-		return mod.Source, nil
+		return sourcereader.PinnedSource(mod.Source, mod.SourceHash), nil
 	}
 	if mod.Kind == config.PackerKind {
 		return string(mod.ID), nil
@@ -206,7 +290,7 @@ func copyEmbeddedModules(base string) error {
 	r := sourcereader.EmbeddedSourceReader{}
 	for _, src := range []string{"modules", "community/modules"} {
 		dst := filepath.Join(base, "modules/embedded", src)
-		if err := os.MkdirAll(dst, 0755); err != nil {
+		if err := deploymentio.MkdirAll(dst); err != nil {
 			return err
 		}
 		if err := r.CopyDir(src, dst); err != nil {
@@ -259,6 +343,36 @@ func copySource(deploymentPath string, deploymentGroups *[]config.DeploymentGrou
 	return nil
 }
 
+// Confirms that every group that will not be written was written by a prior
+// invocation so that the deployment directory remains complete
+func checkSkippedGroups(depDir string, groups []config.DeploymentGroup, write map[config.GroupName]bool, overwrite bool) error {
+	for _, grp := range groups {
+		if write[grp.Name] {
+			continue
+		}
+		if !overwrite {
+			return &OverwriteDeniedError{
+				fmt.Errorf("deployment group %s can only be skipped when updating an existing deployment", grp.Name)}
+		}
+		groupPath := filepath.Join(depDir, string(grp.Name))
+		if info, err := os.Stat(groupPath); err != nil || !info.IsDir() {
+			return fmt.Errorf(
+				"deployment group %s cannot be skipped because it was not found in the existing deployment at %s", grp.Name, depDir)
+		}
+	}
+	return nil
+}
+
+// Moves a deployment group saved by prepDepDir back into the deployment
+func restoreGroupDir(depDir string, group config.GroupName) error {
+	src := filepath.Join(depDir, HiddenGhpcDirName, prevDeploymentGroupDirName, string(group))
+	dest := filepath.Join(depDir, string(group))
+	if err := os.Rename(src, dest); err != nil {
+		return fmt.Errorf("Error while restoring deployment group %s: %w", group, err)
+	}
+	return nil
+}
+
 // Determines if overwrite is allowed
 func isOverwriteAllowed(depDir string, overwritingConfig *config.Blueprint, overwriteFlag bool) bool {
 	if !overwriteFlag {
@@ -316,14 +430,14 @@ func (err *OverwriteDeniedError) Error() string {
 }
 
 // Prepares a deployment directory to be written to.
-func prepDepDir(depDir string, overwrite bool) error {
-	deploymentio := deploymentio.GetDeploymentioLocal()
+func prepDepDir(depDir string, overwrite bool, skipped []config.GroupName) error {
+	local := deploymentio.GetDeploymentioLocal()
 	ghpcDir := filepath.Join(depDir, HiddenGhpcDirName)
 	artifactsDir := filepath.Join(ghpcDir, ArtifactsDirName)
 	gitignoreFile := filepath.Join(depDir, ".gitignore")
 
 	// create deployment directory
-	if err := deploymentio.CreateDirectory(depDir); err != nil {
+	if err := local.CreateDirectory(depDir); err != nil {
 		if !overwrite {
 			return &OverwriteDeniedError{err}
 		}
@@ -334,23 +448,23 @@ func prepDepDir(depDir string, overwrite bool) error {
 				"while trying to update the deployment directory at %s, the '.ghpc/' dir could not be found", depDir)
 		}
 	} else {
-		if err := deploymentio.CreateDirectory(ghpcDir); err != nil {
+		if err := local.CreateDirectory(ghpcDir); err != nil {
 			return fmt.Errorf("failed to create directory at %s: err=%w", ghpcDir, err)
 		}
 
-		if err := deploymentio.CopyFromFS(templatesFS, gitignoreTemplate, gitignoreFile); err != nil {
+		if err := local.CopyFromFS(templatesFS, gitignoreTemplate, gitignoreFile); err != nil {
 			return fmt.Errorf("failed to copy template.gitignore file to %s: err=%w", gitignoreFile, err)
 		}
 	}
 
-	if err := prepArtifactsDir(artifactsDir); err != nil {
+	if err := prepArtifactsDir(artifactsDir, skipped); err != nil {
 		return err
 	}
 
 	// remove any existing backups of deployment group
 	prevGroupDir := filepath.Join(ghpcDir, prevDeploymentGroupDirName)
 	os.RemoveAll(prevGroupDir)
-	if err := os.MkdirAll(prevGroupDir, 0755); err != nil {
+	if err := deploymentio.MkdirAll(prevGroupDir); err != nil {
 		return fmt.Errorf("failed to create directory to save previous deployment groups at %s: %w", prevGroupDir, err)
 	}
 
@@ -372,19 +486,35 @@ func prepDepDir(depDir string, overwrite bool) error {
 	return nil
 }
 
-func prepArtifactsDir(artifactsDir string) error {
-	// cleanup previous artifacts on every write
-	if err := os.RemoveAll(artifactsDir); err != nil {
-		return fmt.Errorf(
-			"error while removing the artifacts directory at %s; %s", artifactsDir, err.Error())
+// prepArtifactsDir cleans up the artifacts of previous writes, except the
+// exported outputs of the groups that are not written again, which later
+// groups still need
+func prepArtifactsDir(artifactsDir string, skipped []config.GroupName) error {
+	keep := map[string]bool{}
+	for _, g := range skipped {
+		keep[OutputsFilename(g)] = true
+		keep[OutputTypesFilename(g)] = true
+	}
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while reading the artifacts directory at %s; %w", artifactsDir, err)
+	}
+	for _, e := range entries {
+		if keep[e.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(artifactsDir, e.Name())); err != nil {
+			return fmt.Errorf(
+				"error while removing %s from the artifacts directory at %s; %s", e.Name(), artifactsDir, err.Error())
+		}
 	}
 
-	if err := os.MkdirAll(artifactsDir, 0700); err != nil {
+	if err := deploymentio.MkdirPrivate(artifactsDir); err != nil {
 		return err
 	}
 
 	artifactsWarningFile := path.Join(artifactsDir, artifactsWarningFilename)
-	f, err := os.Create(artifactsWarningFile)
+	f, err := deploymentio.Create(artifactsWarningFile)
 	if err != nil {
 		return err
 	}
@@ -403,6 +533,16 @@ func writeExpandedBlueprint(depDir string, dc config.DeploymentConfig) error {
 	return dc.ExportBlueprint(blueprintFile)
 }
 
+func writeValidationReport(depDir string, dc config.DeploymentConfig) error {
+	artifactsDir := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName)
+	return dc.ExportValidationReport(filepath.Join(artifactsDir, validationReportName))
+}
+
+func writeResolvedVars(depDir string, dc config.DeploymentConfig) error {
+	artifactsDir := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName)
+	return dc.ExportResolvedVars(filepath.Join(artifactsDir, resolvedVarsName))
+}
+
 func writeDestroyInstructions(w io.Writer, dc config.DeploymentConfig, deploymentDir string) {
 	packerManifests := []string{}
 	fmt.Fprintln(w)
@@ -425,7 +565,7 @@ func writeDestroyInstructions(w io.Writer, dc config.DeploymentConfig, deploymen
 			fmt.Fprintf(w, "terraform -chdir=%s destroy\n", grpPath)
 		}
 		if grp.Kind == config.PackerKind {
-			packerManifests = append(packerManifests, filepath.Join(grpPath, string(grp.Modules[0].ID), "packer-manifest.json"))
+			packerManifests = append(packerManifests, filepath.Join(grpPath, string(grp.PackerModule().ID), "packer-manifest.json"))
 
 		}
 	}
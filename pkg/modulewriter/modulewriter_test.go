@@ -67,6 +67,11 @@ func setup() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	versions := "terraform {\n  required_version = \">= 1.3\"\n}\n"
+	err = os.WriteFile(filepath.Join(testDir, terraformModuleDir, "versions.tf"), []byte(versions), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func teardown() {
@@ -146,21 +151,44 @@ func (s *MySuite) TestPrepDepDir(c *C) {
 	depDir := filepath.Join(testDir, "dep_prep_test_dir")
 
 	// Prep a dir that does not yet exist
-	err := prepDepDir(depDir, false /* overwrite */)
+	err := prepDepDir(depDir, false /* overwrite */, nil)
 	c.Check(err, IsNil)
 	c.Check(isDeploymentDirPrepped(depDir), IsNil)
 
 	// Prep of existing dir fails with overwrite set to false
-	err = prepDepDir(depDir, false /* overwrite */)
+	err = prepDepDir(depDir, false /* overwrite */, nil)
 	var e *OverwriteDeniedError
 	c.Check(errors.As(err, &e), Equals, true)
 
 	// Prep of existing dir succeeds when overwrite set true
-	err = prepDepDir(depDir, true) /* overwrite */
+	err = prepDepDir(depDir, true /* overwrite */, nil)
 	c.Check(err, IsNil)
 	c.Check(isDeploymentDirPrepped(depDir), IsNil)
 }
 
+func (s *MySuite) TestPrepDepDirKeepsSkippedOutputs(c *C) {
+	depDir := filepath.Join(testDir, "dep_prep_skipped_dir")
+	artifactsDir := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName)
+	c.Assert(prepDepDir(depDir, false /* overwrite */, nil), IsNil)
+
+	files := []string{
+		OutputsFilename("net"), OutputTypesFilename("net"),
+		OutputsFilename("cluster"), OutputTypesFilename("cluster"),
+		expandedBlueprintName,
+	}
+	for _, f := range files {
+		c.Assert(os.WriteFile(filepath.Join(artifactsDir, f), []byte("x"), 0644), IsNil)
+	}
+
+	c.Assert(prepDepDir(depDir, true /* overwrite */, []config.GroupName{"net"}), IsNil)
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(artifactsDir, f))
+		c.Check(err == nil, Equals, f == OutputsFilename("net") || f == OutputTypesFilename("net"), Commentf(f))
+	}
+	_, err := os.Stat(filepath.Join(artifactsDir, artifactsWarningFilename))
+	c.Check(err, IsNil)
+}
+
 func (s *MySuite) TestPrepDepDir_OverwriteRealDep(c *C) {
 	// Test with a real deployment previously written
 	testDC := getDeploymentConfigForTest()
@@ -174,7 +202,7 @@ func (s *MySuite) TestPrepDepDir_OverwriteRealDep(c *C) {
 	files, _ := ioutil.ReadDir(realDepDir)
 	c.Check(len(files) > 1, Equals, true)
 
-	err := prepDepDir(realDepDir, true /* overwrite */)
+	err := prepDepDir(realDepDir, true /* overwrite */, nil)
 	c.Check(err, IsNil)
 	c.Check(isDeploymentDirPrepped(realDepDir), IsNil)
 
@@ -249,6 +277,241 @@ func (s *MySuite) TestWriteDeployment(c *C) {
 	c.Check(err, IsNil)
 }
 
+func (s *MySuite) TestVerifyDeployment(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_verify_deployment"))
+	depDir := filepath.Join(testDir, "test_verify_deployment")
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+
+	diff, err := VerifyDeployment(depDir)
+	c.Assert(err, IsNil)
+	c.Check(diff.Empty(), Equals, true)
+
+	m, err := ReadManifest(depDir)
+	c.Assert(err, IsNil)
+	c.Check(m.TerraformVersion, Equals, ">= 1.3")
+
+	// files written when deploying are not compared
+	group := filepath.Join(depDir, string(testDC.Config.DeploymentGroups[0].Name))
+	c.Assert(os.WriteFile(filepath.Join(group, "terraform.tfstate"), []byte("{}"), 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(group, ".terraform"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(group, ".terraform", "modules.json"), []byte("{}"), 0644), IsNil)
+	diff, err = VerifyDeployment(depDir)
+	c.Assert(err, IsNil)
+	c.Check(diff.Empty(), Equals, true)
+
+	c.Assert(os.WriteFile(filepath.Join(group, "main.tf"), []byte("# edited"), 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(group, "variables.tf")), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(group, "extra.tf"), []byte(""), 0644), IsNil)
+	blueprint := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName, expandedBlueprintName)
+	c.Assert(os.WriteFile(blueprint, []byte("blueprint_name: edited"), 0644), IsNil)
+	diff, err = VerifyDeployment(depDir)
+	c.Assert(err, IsNil)
+	c.Check(diff, DeepEquals, ManifestDiff{
+		Modified: []string{".ghpc/artifacts/expanded_blueprint.yaml", "test_resource_group/main.tf"},
+		Missing:  []string{"test_resource_group/variables.tf"},
+		Added:    []string{"test_resource_group/extra.tf"},
+	})
+
+	_, err = VerifyDeployment(testDir)
+	c.Check(err, ErrorMatches, "failed to read the manifest of deployment .*")
+}
+
+func (s *MySuite) TestWriteDeployment_Provenance(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_provenance"))
+	testDC.Config.GhpcVersion = "v1.19.1-2-gabcdef"
+	depDir := filepath.Join(testDir, "test_provenance")
+	main := filepath.Join(depDir, "test_resource_group", "main.tf")
+
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	sum, err := hashFile(filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName, expandedBlueprintName))
+	c.Assert(err, IsNil)
+	for _, want := range []string{
+		"#   blueprint:        simple\n",
+		"#   blueprint sha256: " + sum + " (of ../.ghpc/artifacts/expanded_blueprint.yaml)\n",
+		"#   deployment group: test_resource_group\n",
+		"#   ghpc version:     v1.19.1-2-gabcdef\n",
+		"# ghpc module testModule, source " + testDC.Config.DeploymentGroups[0].Modules[0].Source + "\n",
+	} {
+		exists, err := stringExistsInFile(want, main)
+		c.Assert(err, IsNil)
+		c.Check(exists, Equals, true, Commentf("missing %q", want))
+	}
+	exists, err := stringExistsInFile("ghpc_metadata", main)
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, false)
+
+	// the local is only added when the blueprint asks for it
+	testDC.Config.GhpcMetadata = true
+	c.Assert(WriteDeployment(testDC, testDir, true /* overwriteFlag */), IsNil)
+	for _, want := range []string{
+		`blueprint_name   = "simple"`,
+		`deployment_group = "test_resource_group"`,
+		`module_ids       = ["testModule", "testModuleWithLabels"]`,
+	} {
+		exists, err := stringExistsInFile(want, main)
+		c.Assert(err, IsNil)
+		c.Check(exists, Equals, true, Commentf("missing %q", want))
+	}
+}
+
+func (s *MySuite) TestIntersectVersions(c *C) {
+	v, err := intersectVersions(nil)
+	c.Assert(err, IsNil)
+	c.Check(v, Equals, "")
+
+	v, err = intersectVersions([]string{">= 1.2", ">= 0.13.0", ">= 1.5, < 2.0", "~> 1.3", "< 2.0"})
+	c.Assert(err, IsNil)
+	c.Check(v, Equals, ">= 1.5, < 2.0, ~> 1.3")
+
+	_, err = intersectVersions([]string{"latest"})
+	c.Check(err, ErrorMatches, `invalid Terraform version constraint "latest".*`)
+}
+
+func (s *MySuite) TestWriteCDKTFProject(c *C) {
+	testDC := getDeploymentConfigForTest()
+	second := testDC.Config.DeploymentGroups[0]
+	second.Name = "second_group"
+	mod := second.Modules[1]
+	mod.ID = "consumer"
+	mod.Settings = config.NewDict(map[string]cty.Value{
+		"input": config.ModuleRef("testModule", "test-output").AsExpression().AsValue(),
+		"zone":  config.GlobalRef("zone").AsExpression().AsValue(),
+		"name":  cty.StringVal("${not_interpolated}"),
+	})
+	second.Modules = []config.Module{mod}
+	testDC.Config.DeploymentGroups = append(testDC.Config.DeploymentGroups, second)
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_cdktf_project"))
+	testDC.Config.Vars.Set("zone", cty.StringVal("us-central1-a"))
+	depDir := filepath.Join(testDir, "test_write_cdktf_project")
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+
+	c.Check(WriteCDKTFProject(testDC, depDir, "java"), ErrorMatches, "unsupported CDKTF language.*")
+
+	c.Assert(WriteCDKTFProject(testDC, depDir, CDKTFTypeScript), IsNil)
+	b, err := os.ReadFile(filepath.Join(depDir, CDKTFDirName, "main.ts"))
+	c.Assert(err, IsNil)
+	main := string(b)
+	for _, want := range []string{
+		`const stack = new TerraformStack(app, "second_group");`,
+		`if (previous) stack.addDependency(previous);`,
+		`variable(stack, "zone", "us-central1-a");`,
+		`local(stack, "test-output_testModule", modules["test_resource_group.testModule"].get("test-output"));`,
+		`"input": "${local.test-output_testModule}"`,
+		`"name": "$${not_interpolated}"`,
+		`"zone": "${var.zone}"`,
+		`path.resolve(__dirname, "../second_group/modules/`,
+		`output(stack, "test-output_testModule", modules["test_resource_group.testModule"].get("test-output")`,
+	} {
+		c.Check(strings.Contains(main, want), Equals, true, Commentf("%q not in\n%s", want, main))
+	}
+	for _, f := range []string{"cdktf.json", "package.json", "tsconfig.json"} {
+		_, err := os.Stat(filepath.Join(depDir, CDKTFDirName, f))
+		c.Check(err, IsNil)
+	}
+
+	c.Assert(WriteCDKTFProject(testDC, depDir, CDKTFPython), IsNil)
+	b, err = os.ReadFile(filepath.Join(depDir, CDKTFDirName, "main.py"))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Matches, `(?s).*stack = TerraformStack\(app, "second_group"\)\nif previous:\n    stack.add_dependency\(previous\)\n.*`)
+
+	// the project is recorded in the manifest
+	diff, err := VerifyDeployment(depDir)
+	c.Assert(err, IsNil)
+	c.Check(diff.Empty(), Equals, true)
+}
+
+func (s *MySuite) TestWriteTerragruntProject(c *C) {
+	testDC := getDeploymentConfigForTest()
+	second := testDC.Config.DeploymentGroups[0]
+	second.Name = "second_group"
+	mod := second.Modules[1]
+	mod.ID = "consumer"
+	mod.Settings = config.NewDict(map[string]cty.Value{
+		"input": config.ModuleRef("testModule", "test-output").AsExpression().AsValue(),
+	})
+	second.Modules = []config.Module{mod}
+	second.TerraformBackend = config.TerraformBackend{
+		Type:          "gcs",
+		Configuration: config.NewDict(map[string]cty.Value{"bucket": cty.StringVal("a_bucket")}),
+	}
+	third := testDC.Config.DeploymentGroups[0]
+	third.Name = "third_group"
+	third.Modules = []config.Module{{ID: "unrelated", Source: mod.Source, Kind: config.TerraformKind}}
+	testDC.Config.DeploymentGroups = append(testDC.Config.DeploymentGroups, second, third)
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_terragrunt_project"))
+	depDir := filepath.Join(testDir, "test_write_terragrunt_project")
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	c.Assert(WriteTerragruntProject(testDC, depDir), IsNil)
+
+	read := func(g string) string {
+		b, err := os.ReadFile(filepath.Join(depDir, g, TerragruntFileName))
+		c.Assert(err, IsNil)
+		return string(b)
+	}
+	first := read("test_resource_group")
+	c.Check(strings.Contains(first, "dependenc"), Equals, false, Commentf("unexpected dependencies in\n%s", first))
+	c.Check(strings.Contains(first, "remote_state"), Equals, false, Commentf("unexpected remote_state in\n%s", first))
+
+	got := read("second_group")
+	for _, want := range []string{
+		"dependency \"test_resource_group\" {\n  config_path = \"../test_resource_group\"\n}",
+		"remote_state {\n  backend = \"gcs\"\n  config = {\n    bucket = \"a_bucket\"\n  }\n}",
+		`"test-output_testModule" = dependency.test_resource_group.outputs.test-output_testModule`,
+	} {
+		c.Check(strings.Contains(got, want), Equals, true, Commentf("%q not in\n%s", want, got))
+	}
+	c.Check(strings.Contains(got, "dependencies {"), Equals, false)
+
+	// groups that use no outputs still follow the previous group
+	got = read("third_group")
+	want := "dependencies {\n  paths = [\"../second_group\"]\n}"
+	c.Check(strings.Contains(got, want), Equals, true, Commentf("%q not in\n%s", want, got))
+
+	// the configuration is recorded in the manifest
+	diff, err := VerifyDeployment(depDir)
+	c.Assert(err, IsNil)
+	c.Check(diff.Empty(), Equals, true)
+}
+
+func (s *MySuite) TestWriteDeploymentGroups(c *C) {
+	testDC := getDeploymentConfigForTest()
+	second := testDC.Config.DeploymentGroups[0]
+	second.Name = "second_group"
+	second.Modules = []config.Module{second.Modules[1]}
+	testDC.Config.DeploymentGroups = append(testDC.Config.DeploymentGroups, second)
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_deployment_groups"))
+	depDir := filepath.Join(testDir, "test_write_deployment_groups")
+	only := []config.GroupName{"second_group"}
+
+	// Skipping a group fails for a new deployment
+	err := WriteDeploymentGroups(testDC, testDir, true /* overwriteFlag */, only)
+	c.Check(err, NotNil)
+
+	err = WriteDeploymentGroups(testDC, testDir, false /* overwriteFlag */, nil)
+	c.Assert(err, IsNil)
+	marker := filepath.Join(depDir, "test_resource_group", "marker")
+	c.Assert(os.WriteFile(marker, []byte("keep"), 0644), IsNil)
+
+	// Skipped groups are restored untouched
+	err = WriteDeploymentGroups(testDC, testDir, true /* overwriteFlag */, only)
+	c.Assert(err, IsNil)
+	_, err = os.Stat(marker)
+	c.Check(err, IsNil)
+	_, err = os.Stat(filepath.Join(depDir, "second_group", "main.tf"))
+	c.Check(err, IsNil)
+
+	// Unknown groups are rejected
+	err = WriteDeploymentGroups(testDC, testDir, true /* overwriteFlag */, []config.GroupName{"nope"})
+	c.Check(err, ErrorMatches, ".*could not find group nope.*")
+
+	// Skipped groups must already exist in the deployment
+	c.Assert(os.RemoveAll(filepath.Join(depDir, "test_resource_group")), IsNil)
+	err = WriteDeploymentGroups(testDC, testDir, true /* overwriteFlag */, only)
+	c.Check(err, ErrorMatches, ".*test_resource_group cannot be skipped.*")
+}
+
 func (s *MySuite) TestCreateGroupDirs(c *C) {
 	// Setup
 	testDeployDir := filepath.Join(testDir, "test_createGroupDirs")
@@ -486,7 +749,7 @@ func (s *MySuite) TestWriteMain(c *C) {
 	// Simple success
 	testModules := []config.Module{}
 	testBackend := config.TerraformBackend{}
-	err := writeMain(testModules, testBackend, testMainDir)
+	err := writeMain(testModules, testBackend, nil, nil, testMainDir)
 	c.Assert(err, IsNil)
 
 	// Test with modules
@@ -498,7 +761,7 @@ func (s *MySuite) TestWriteMain(c *C) {
 		}),
 	}
 	testModules = append(testModules, testModule)
-	err = writeMain(testModules, testBackend, testMainDir)
+	err = writeMain(testModules, testBackend, nil, nil, testMainDir)
 	c.Assert(err, IsNil)
 	exists, err := stringExistsInFile("testSetting", mainFilePath)
 	c.Assert(err, IsNil)
@@ -516,17 +779,26 @@ func (s *MySuite) TestWriteMain(c *C) {
 	testBackend.Type = "gcs"
 	testBackend.Configuration.Set("bucket", cty.StringVal("a_bucket"))
 
-	err = writeMain(testModules, testBackend, testMainDir)
+	err = writeMain(testModules, testBackend, nil, nil, testMainDir)
 	c.Assert(err, IsNil)
 	exists, err = stringExistsInFile("a_bucket", mainFilePath)
 	c.Assert(err, IsNil)
 	c.Assert(exists, Equals, true)
 
-	// Test with WrapSettingsWith
+	// escapes are removed from backend configuration as from settings
+	testBackend.Configuration.Set("prefix", cty.StringVal(`\$(not.var)`))
+	err = writeMain(testModules, testBackend, nil, nil, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`prefix = "$(not.var)"`, mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	testBackend.Configuration = config.NewDict(map[string]cty.Value{"bucket": cty.StringVal("a_bucket")})
+
+	// Test with Transforms
 	testModuleWithWrap := config.Module{
 		ID: "test_module_with_wrap",
-		WrapSettingsWith: map[string][]string{
-			"wrappedSetting": {"list(flatten(", "))"},
+		Transforms: map[string][]config.SettingTransformer{
+			"wrappedSetting": {config.FlattenTransformer, config.JSONEncodeTransformer},
 		},
 		Settings: config.NewDict(map[string]cty.Value{
 			"wrappedSetting": cty.TupleVal([]cty.Value{
@@ -535,9 +807,29 @@ func (s *MySuite) TestWriteMain(c *C) {
 		}),
 	}
 	testModules = append(testModules, testModuleWithWrap)
-	err = writeMain(testModules, testBackend, testMainDir)
+	err = writeMain(testModules, testBackend, nil, nil, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("jsonencode(flatten([", mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Test with providers of the deployment project
+	err = writeMain(testModules, testBackend, []config.ModuleID{"test_module"}, nil, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("google-beta = google-beta.deployment", mainFilePath)
 	c.Assert(err, IsNil)
-	exists, err = stringExistsInFile("list(flatten(", mainFilePath)
+	c.Assert(exists, Equals, true)
+
+	// Test with ordering-only dependencies, of which only those on modules of
+	// the group are written
+	testModuleWithDepends := config.Module{
+		ID:      "test_module_with_depends",
+		Depends: []config.ModuleID{"test_module", "module_of_earlier_group"},
+	}
+	testModules = append(testModules, testModuleWithDepends)
+	err = writeMain(testModules, testBackend, nil, nil, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("depends_on = [module.test_module]", mainFilePath)
 	c.Assert(err, IsNil)
 	c.Assert(exists, Equals, true)
 }
@@ -590,17 +882,17 @@ func (s *MySuite) TestWriteVariables(c *C) {
 
 	// Simple success, empty vars
 	testVars := make(map[string]cty.Value)
-	err := writeVariables(testVars, noIntergroupVars, testVarDir)
+	err := writeVariables(testVars, nil, noIntergroupVars, testVarDir)
 	c.Assert(err, IsNil)
 
 	// Failure: Bad path
-	err = writeVariables(testVars, noIntergroupVars, "not/a/real/path")
+	err = writeVariables(testVars, nil, noIntergroupVars, "not/a/real/path")
 	c.Assert(err, ErrorMatches, "error creating variables.tf file: .*")
 
 	// Success, common vars
 	testVars["deployment_name"] = cty.StringVal("test_deployment")
 	testVars["project_id"] = cty.StringVal("test_project")
-	err = writeVariables(testVars, noIntergroupVars, testVarDir)
+	err = writeVariables(testVars, nil, noIntergroupVars, testVarDir)
 	c.Assert(err, IsNil)
 	exists, err := stringExistsInFile("\"deployment_name\"", varsFilePath)
 	c.Assert(err, IsNil)
@@ -609,8 +901,39 @@ func (s *MySuite) TestWriteVariables(c *C) {
 	// Success, "dynamic type"
 	testVars = make(map[string]cty.Value)
 	testVars["project_id"] = cty.NullVal(cty.DynamicPseudoType)
-	err = writeVariables(testVars, noIntergroupVars, testVarDir)
+	err = writeVariables(testVars, nil, noIntergroupVars, testVarDir)
 	c.Assert(err, IsNil)
+
+	// Success, sensitive vars
+	testVars["db_password"] = cty.StringVal("hunter2")
+	err = writeVariables(testVars, []string{"db_password"}, noIntergroupVars, testVarDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("sensitive", varsFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+}
+
+func (s *MySuite) TestWriteTfvars(c *C) {
+	dir := c.MkDir()
+	vars := map[string]cty.Value{
+		"project_id":  cty.StringVal("test_project"),
+		"db_password": cty.StringVal("hunter2"),
+	}
+
+	c.Assert(writeTfvars(vars, []string{"db_password"}, dir), IsNil)
+	plain, err := os.ReadFile(filepath.Join(dir, tfvarsFilename))
+	c.Assert(err, IsNil)
+	c.Check(string(plain), Matches, `(?s).*project_id.*`)
+	c.Check(string(plain), Not(Matches), `(?s).*hunter2.*`)
+	secret, err := os.ReadFile(filepath.Join(dir, config.SensitiveTfvarsFilename))
+	c.Assert(err, IsNil)
+	c.Check(string(secret), Matches, `(?s).*db_password = "hunter2".*`)
+
+	// no sensitive file without sensitive vars
+	dir = c.MkDir()
+	c.Assert(writeTfvars(vars, nil, dir), IsNil)
+	_, err = os.Stat(filepath.Join(dir, config.SensitiveTfvarsFilename))
+	c.Check(os.IsNotExist(err), Equals, true)
 }
 
 func (s *MySuite) TestWriteProviders(c *C) {
@@ -623,7 +946,7 @@ func (s *MySuite) TestWriteProviders(c *C) {
 
 	// Simple success, empty vars
 	testVars := make(map[string]cty.Value)
-	err := writeProviders(testVars, testProvDir)
+	err := writeProviders(testVars, "", "", testProvDir)
 	c.Assert(err, IsNil)
 	exists, err := stringExistsInFile("google-beta", provFilePath)
 	c.Assert(err, IsNil)
@@ -633,18 +956,124 @@ func (s *MySuite) TestWriteProviders(c *C) {
 	c.Assert(exists, Equals, false)
 
 	// Failure: Bad Path
-	err = writeProviders(testVars, "not/a/real/path")
+	err = writeProviders(testVars, "", "", "not/a/real/path")
 	c.Assert(err, ErrorMatches, "error creating providers.tf file: .*")
 
 	// Success: All vars
 	testVars["project_id"] = cty.StringVal("test_project")
 	testVars["zone"] = cty.StringVal("test_zone")
 	testVars["region"] = cty.StringVal("test_region")
-	err = writeProviders(testVars, testProvDir)
+	err = writeProviders(testVars, "", "", testProvDir)
 	c.Assert(err, IsNil)
 	exists, err = stringExistsInFile("var.region", provFilePath)
 	c.Assert(err, IsNil)
 	c.Assert(exists, Equals, true)
+
+	// Success: group overrides the project
+	err = writeProviders(testVars, "service-project", "", testProvDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`project = "service-project"`, provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile(`"deployment"`, provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile("impersonate_service_account", provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	// Success: providers impersonate a service account
+	sa := "deployer@test_project.iam.gserviceaccount.com"
+	err = writeProviders(testVars, "service-project", sa, testProvDir)
+	c.Assert(err, IsNil)
+	content, err := os.ReadFile(provFilePath)
+	c.Assert(err, IsNil)
+	c.Check(strings.Count(string(content), fmt.Sprintf("impersonate_service_account = %q", sa)), Equals, 4)
+}
+
+func (s *MySuite) TestWriteImports(c *C) {
+	testImportsDir := filepath.Join(testDir, "TestWriteImports")
+	importsFilePath := filepath.Join(testImportsDir, "imports.tf")
+	if err := os.Mkdir(testImportsDir, 0755); err != nil {
+		log.Fatal("Failed to create test directory for creating imports.tf file")
+	}
+	bp := config.Blueprint{Vars: config.NewDict(map[string]cty.Value{
+		"project_id": cty.StringVal("brownfield")})}
+
+	// no imports, no file
+	modules := []config.Module{{ID: "network1"}}
+	c.Assert(writeImports(modules, bp, testImportsDir), IsNil)
+	_, err := os.Stat(importsFilePath)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	network := config.Module{ID: "network1", Imports: config.NewDict(map[string]cty.Value{
+		"google_compute_network.network": config.MustParseExpression(
+			`"projects/${var.project_id}/global/networks/hpc"`).AsValue(),
+	})}
+	c.Assert(writeImports([]config.Module{network}, bp, testImportsDir), IsNil)
+	b, err := os.ReadFile(importsFilePath)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Matches, `(?s).*
+import {
+  to = module.network1.google_compute_network.network
+  id = "projects/brownfield/global/networks/hpc"
+}
+.*required_version = ">= 1.5".*`)
+}
+
+func (s *MySuite) TestWriteRemoteStates(c *C) {
+	testRemoteStateDir := filepath.Join(testDir, "TestWriteRemoteStates")
+	remoteStateFilePath := filepath.Join(testRemoteStateDir, "remote_state.tf")
+	if err := os.Mkdir(testRemoteStateDir, 0755); err != nil {
+		log.Fatal("Failed to create test directory for creating remote_state.tf file")
+	}
+	bp := config.Blueprint{ExternalDeployments: map[string]config.ExternalDeployment{
+		"network": {TerraformBackend: config.TerraformBackend{Type: "gcs", Configuration: config.NewDict(map[string]cty.Value{
+			"bucket": cty.StringVal("tf-state"),
+			"prefix": cty.StringVal("hpc-network/shared/{{group}}"),
+		})}},
+	}}
+
+	// no references to external deployments, no file
+	group := config.DeploymentGroup{Name: "primary", Modules: []config.Module{{ID: "vm"}}}
+	c.Assert(writeRemoteStates(group, bp, testRemoteStateDir), IsNil)
+	_, err := os.Stat(remoteStateFilePath)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	vm := config.Module{ID: "vm", Settings: config.NewDict(map[string]cty.Value{
+		"network": config.MustParseExpression(`data.terraform_remote_state.network["primary"].outputs.network_name`).AsValue(),
+	})}
+	group = config.DeploymentGroup{Name: "primary", Modules: []config.Module{vm}}
+	c.Assert(writeRemoteStates(group, bp, testRemoteStateDir), IsNil)
+	b, err := os.ReadFile(remoteStateFilePath)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Matches, `(?s).*
+data "terraform_remote_state" "network" {
+  for_each = {
+    primary = {
+      bucket = "tf-state"
+      prefix = "hpc-network/shared/primary"
+    }
+  }
+  backend = "gcs"
+  config  = each.value
+}
+.*`)
+}
+
+func (s *MySuite) TestDeploymentProjectModules(c *C) {
+	group := config.DeploymentGroup{
+		Modules: []config.Module{
+			{ID: "host", Settings: config.NewDict(map[string]cty.Value{
+				"project_id": config.GlobalRef("project_id").AsExpression().AsValue()})},
+			{ID: "service", Settings: config.NewDict(map[string]cty.Value{
+				"project_id": cty.StringVal("service-project")})},
+		},
+	}
+	c.Check(deploymentProjectModules(group), HasLen, 0)
+
+	group.ProjectID = "service-project"
+	c.Check(deploymentProjectModules(group), DeepEquals, []config.ModuleID{"host"})
 }
 
 // packerwriter.go
@@ -773,6 +1202,12 @@ func (s *MySuite) TestDeploymentSource(c *C) {
 		c.Check(err, IsNil)
 		c.Check(s, Equals, "github.com/x/y.git")
 	}
+	{ // git pinned to a commit
+		m := config.Module{Kind: config.TerraformKind, Source: "github.com/x/y.git//mod?ref=v1", SourceHash: "git:0a1b2c3d"}
+		s, err := deploymentSource(m)
+		c.Check(err, IsNil)
+		c.Check(s, Equals, "github.com/x/y.git//mod?ref=0a1b2c3d")
+	}
 	{ // packer
 		m := config.Module{Kind: config.PackerKind, Source: "modules/packer/custom-image", ID: "custom-image"}
 		s, err := deploymentSource(m)
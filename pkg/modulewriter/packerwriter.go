@@ -73,37 +73,55 @@ func (w PackerWriter) writeDeploymentGroup(
 ) error {
 	depGroup := dc.Config.DeploymentGroups[grpIdx]
 	groupPath := filepath.Join(deployDir, string(depGroup.Name))
-	igcInputs := map[string]bool{}
 
 	for _, mod := range depGroup.Modules {
-		pure := config.Dict{}
-		for setting, v := range mod.Settings.Items() {
-			igcRefs := config.FindIntergroupReferences(v, mod, dc.Config)
-			if len(igcRefs) == 0 {
-				pure.Set(setting, v)
-			}
-			for _, r := range igcRefs {
-				n := config.AutomaticOutputName(r.Name, r.Module)
-				igcInputs[n] = true
-			}
-		}
-
-		av, err := pure.Eval(dc.Config)
+		av, hasIgc, err := packerAutovars(mod, dc.Config)
 		if err != nil {
 			return err
 		}
 
 		modPath := filepath.Join(groupPath, mod.DeploymentSource)
-		if err = writePackerAutovars(av.Items(), modPath); err != nil {
+		if err = writePackerAutovars(av, modPath); err != nil {
 			return err
 		}
-		hasIgc := len(pure.Items()) < len(mod.Settings.Items())
 		printPackerInstructions(instructionsFile, modPath, mod.ID, hasIgc)
 	}
 
 	return nil
 }
 
+// renderDeploymentGroup returns the HCL of the autovars file of every module
+// of a packer deployment group, without the license, by path in the group
+func (w PackerWriter) renderDeploymentGroup(dc config.DeploymentConfig, grpIdx int) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, mod := range dc.Config.DeploymentGroups[grpIdx].Modules {
+		av, _, err := packerAutovars(mod, dc.Config)
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.Join(mod.DeploymentSource, packerAutoVarFilename)] = renderHclAttributes(av)
+	}
+	return files, nil
+}
+
+// packerAutovars evaluates the settings of a packer module that do not refer
+// to other groups; hasIgc reports whether any setting does, in which case it
+// is set by ghpc import-inputs
+func packerAutovars(mod config.Module, bp config.Blueprint) (vars map[string]cty.Value, hasIgc bool, err error) {
+	pure := config.Dict{}
+	for setting, v := range mod.Settings.Items() {
+		if len(config.FindIntergroupReferences(v, mod, bp)) == 0 {
+			pure.Set(setting, v)
+		}
+	}
+
+	av, err := pure.Eval(bp)
+	if err != nil {
+		return nil, false, err
+	}
+	return av.Items(), len(pure.Items()) < len(mod.Settings.Items()), nil
+}
+
 func (w PackerWriter) restoreState(deploymentDir string) error {
 	// TODO: restore packer-manifest.json if it exists
 	return nil
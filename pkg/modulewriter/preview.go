@@ -0,0 +1,273 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"bytes"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"golang.org/x/exp/slices"
+)
+
+// ANSI escape sequences used to highlight previewed files
+const (
+	ansiReset   = "\033[0m"
+	ansiComment = "\033[90m"
+	ansiString  = "\033[32m"
+	ansiNumber  = "\033[35m"
+	ansiKeyword = "\033[36m"
+	ansiHeader  = "\033[1m"
+	ansiRemoved = "\033[31m"
+	ansiAdded   = "\033[32m"
+)
+
+// DefaultPreviewFiles are the generated files shown by PreviewDeployment when
+// no files are chosen
+var DefaultPreviewFiles = []string{"main.tf", "variables.tf", "providers.tf", packerAutoVarFilename}
+
+// previewContext is the number of unchanged lines shown around the changes
+// to a file of an existing deployment
+const previewContext = 3
+
+// PreviewDeployment renders the named files of every deployment group in
+// memory, without copying or fetching module sources, and writes them to w.
+// Files that already exist in the deployment directory under outputDir are
+// written as a line diff against the existing file instead. Files that are
+// not generated for a group are ignored. When highlight is true, HCL syntax
+// and changed lines are colored with ANSI escape sequences.
+func PreviewDeployment(dc config.DeploymentConfig, outputDir string, w io.Writer, files []string, highlight bool) error {
+	if len(files) == 0 {
+		files = DefaultPreviewFiles
+	}
+	if mocks := dc.Config.MockModules(); len(mocks) > 0 {
+		return fmt.Errorf("the blueprint has mock modules %v, which can be expanded and validated but not deployed", mocks)
+	}
+	deploymentName, err := dc.Config.DeploymentName()
+	if err != nil {
+		return err
+	}
+	deploymentDir := filepath.Join(outputDir, deploymentName)
+
+	// the groups are copied so that setting the deployment sources does not
+	// change the blueprint of the caller
+	groups := make([]config.DeploymentGroup, len(dc.Config.DeploymentGroups))
+	for iGrp, grp := range dc.Config.DeploymentGroups {
+		grp.Modules = slices.Clone(grp.Modules)
+		for iMod := range grp.Modules {
+			ds, err := deploymentSource(grp.Modules[iMod])
+			if err != nil {
+				return err
+			}
+			grp.Modules[iMod].DeploymentSource = ds
+		}
+		groups[iGrp] = grp
+	}
+	dc.Config.DeploymentGroups = groups
+
+	found := false
+	for iGrp, grp := range groups {
+		rendered, err := factory(grp.Kind.String()).renderDeploymentGroup(dc, iGrp)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			for _, path := range orderKeys(rendered) {
+				if filepath.Base(path) != file {
+					continue
+				}
+				found = true
+				rel := filepath.Join(string(grp.Name), path)
+				src := append([]byte(license), rendered[path]...)
+				if err := previewFile(w, deploymentDir, rel, src, highlight); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("none of the files %v are generated in any deployment group", files)
+	}
+	return nil
+}
+
+// previewFile writes src, the content of the file rel of the deployment, to w;
+// if the file exists in deploymentDir, only the lines that change are written
+func previewFile(w io.Writer, deploymentDir string, rel string, src []byte, highlight bool) error {
+	header := func(s string) {
+		if highlight {
+			s = ansiHeader + s + ansiReset
+		}
+		fmt.Fprintln(w, s)
+	}
+
+	old, err := os.ReadFile(filepath.Join(deploymentDir, rel))
+	if os.IsNotExist(err) {
+		header(fmt.Sprintf("# ==> %s <==", rel))
+		if highlight {
+			src = highlightHCL(src, rel)
+		}
+		fmt.Fprintf(w, "%s\n", src)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(old, src) {
+		header(fmt.Sprintf("# ==> %s (unchanged) <==", rel))
+		fmt.Fprintln(w)
+		return nil
+	}
+	header(fmt.Sprintf("# ==> %s (changes to %s) <==", rel, filepath.Join(deploymentDir, rel)))
+	writeLineDiff(w, old, src, highlight)
+	fmt.Fprintln(w)
+	return nil
+}
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// lineDiff returns the lines removed from a and added in b, and the lines
+// they have in common, computed from their longest common subsequence
+func lineDiff(a []string, b []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// writeLineDiff writes the lines removed from old and added in src, prefixed
+// with - and +, with up to previewContext unchanged lines around them;
+// skipped unchanged lines are marked with ...
+func writeLineDiff(w io.Writer, old []byte, src []byte, highlight bool) {
+	split := func(b []byte) []string {
+		return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	lines := lineDiff(split(old), split(src))
+
+	nearChange := func(i int) bool {
+		for j := i - previewContext; j <= i+previewContext; j++ {
+			if j >= 0 && j < len(lines) && lines[j].op != ' ' {
+				return true
+			}
+		}
+		return false
+	}
+
+	skipped := false
+	for i, l := range lines {
+		if l.op == ' ' && !nearChange(i) {
+			skipped = true
+			continue
+		}
+		if skipped {
+			fmt.Fprintln(w, "...")
+			skipped = false
+		}
+		line := fmt.Sprintf("%c %s", l.op, l.text)
+		if highlight && l.op != ' ' {
+			color := ansiRemoved
+			if l.op == '+' {
+				color = ansiAdded
+			}
+			line = color + line + ansiReset
+		}
+		fmt.Fprintln(w, line)
+	}
+	if skipped {
+		fmt.Fprintln(w, "...")
+	}
+}
+
+// highlightHCL returns src with ANSI colors applied to comments, strings,
+// numbers and keywords; src is returned unchanged if it cannot be lexed
+func highlightHCL(src []byte, filename string) []byte {
+	tokens, diags := hclsyntax.LexConfig(src, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return src
+	}
+
+	var out []byte
+	start := 0
+	for _, t := range tokens {
+		var color string
+		switch t.Type {
+		case hclsyntax.TokenComment:
+			color = ansiComment
+		case hclsyntax.TokenOQuote, hclsyntax.TokenCQuote, hclsyntax.TokenQuotedLit,
+			hclsyntax.TokenOHeredoc, hclsyntax.TokenCHeredoc, hclsyntax.TokenStringLit:
+			color = ansiString
+		case hclsyntax.TokenNumberLit:
+			color = ansiNumber
+		case hclsyntax.TokenIdent:
+			switch string(t.Bytes) {
+			case "module", "variable", "output", "provider", "terraform", "locals",
+				"source", "true", "false", "null":
+				color = ansiKeyword
+			}
+		}
+		if color == "" {
+			continue
+		}
+		out = append(out, src[start:t.Range.Start.Byte]...)
+		out = append(out, color...)
+		out = append(out, t.Bytes...)
+		out = append(out, ansiReset...)
+		start = t.Range.End.Byte
+	}
+	return append(out, src[start:]...)
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modulewriter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestHighlightHCL(t *testing.T) {
+	src := []byte("# comment\nmodule \"net\" {\n  count = 2\n}\n")
+	got := string(highlightHCL(src, "main.tf"))
+	want := ansiComment + "# comment\n" + ansiReset +
+		ansiKeyword + "module" + ansiReset + " " +
+		ansiString + "\"" + ansiReset + ansiString + "net" + ansiReset + ansiString + "\"" + ansiReset +
+		" {\n  count = " + ansiNumber + "2" + ansiReset + "\n}\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// invalid HCL is returned unchanged
+	bad := []byte("module \"net {\n")
+	if got := highlightHCL(bad, "main.tf"); !bytes.Equal(got, bad) {
+		t.Errorf("got %q, want %q", got, bad)
+	}
+}
+
+func TestPreviewDeployment(t *testing.T) {
+	dc := getDeploymentConfigForTest()
+	dc.Config.Vars.Set("deployment_name", cty.StringVal("test_preview_deployment"))
+	outDir := t.TempDir()
+
+	var buf bytes.Buffer
+	if err := PreviewDeployment(dc, outDir, &buf, []string{"main.tf"}, false); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "# ==> test_resource_group/main.tf <==\n") {
+		t.Errorf("unexpected preview header in %q", out)
+	}
+	if !strings.Contains(out, "module \"testModule\"") {
+		t.Errorf("module block missing from preview %q", out)
+	}
+	if strings.Contains(out, "\033[") {
+		t.Errorf("preview without highlighting contains escape sequences %q", out)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "test_preview_deployment")); !os.IsNotExist(err) {
+		t.Errorf("preview wrote the deployment to the output directory")
+	}
+	if dc.Config.DeploymentGroups[0].Modules[0].DeploymentSource != "" {
+		t.Errorf("preview changed the modules of the blueprint")
+	}
+
+	// files of an existing deployment are diffed against the new ones
+	mainTf := strings.TrimPrefix(out, "# ==> test_resource_group/main.tf <==\n")
+	mainTf = strings.TrimSuffix(mainTf, "\n")
+	groupDir := filepath.Join(outDir, "test_preview_deployment", "test_resource_group")
+	if err := os.MkdirAll(groupDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(groupDir, "main.tf"), []byte(mainTf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := PreviewDeployment(dc, outDir, &buf, []string{"main.tf"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "# ==> test_resource_group/main.tf (unchanged) <==\n\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	old := strings.Replace(mainTf, "module \"testModule\"", "module \"oldModule\"", 1)
+	if err := os.WriteFile(filepath.Join(groupDir, "main.tf"), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := PreviewDeployment(dc, outDir, &buf, []string{"main.tf"}, false); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, "- module \"oldModule\" {\n+ module \"testModule\" {\n") {
+		t.Errorf("changed module block missing from diff %q", out)
+	}
+	if strings.Contains(out, "Licensed under the Apache License") {
+		t.Errorf("diff contains unchanged lines far from the change %q", out)
+	}
+
+	if err := PreviewDeployment(dc, outDir, &buf, []string{"missing.tf"}, false); err == nil {
+		t.Errorf("expected an error when no files were generated")
+	}
+}
@@ -0,0 +1,116 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+
+	"hpc-toolkit/pkg/config"
+)
+
+// provenance identifies the blueprint and the ghpc that generated a
+// deployment group, so that its Terraform code and state can be traced back
+// to them
+type provenance struct {
+	blueprintName string
+	// blueprintSHA256 is the hash of the expanded blueprint, which is written
+	// to the artifacts of the deployment
+	blueprintSHA256 string
+	group           config.GroupName
+	ghpcVersion     string
+	// metadata adds the ghpc_metadata local to main.tf
+	metadata bool
+}
+
+func newProvenance(dc config.DeploymentConfig, group config.GroupName) (provenance, error) {
+	bp, err := dc.MarshalBlueprint(config.ExportOptions{})
+	if err != nil {
+		return provenance{}, err
+	}
+	sum := sha256.Sum256(bp)
+
+	version := dc.Config.GhpcVersion
+	if version == "" {
+		version = config.ToolkitVersion
+	}
+	return provenance{
+		blueprintName:   dc.Config.BlueprintName,
+		blueprintSHA256: hex.EncodeToString(sum[:]),
+		group:           group,
+		ghpcVersion:     version,
+		metadata:        dc.Config.GhpcMetadata,
+	}, nil
+}
+
+// headerTokens returns the comment that opens main.tf
+func (p provenance) headerTokens() hclwrite.Tokens {
+	lines := []string{
+		"Generated by ghpc, do not edit; change the blueprint and run ghpc create instead",
+		fmt.Sprintf("  blueprint:        %s", p.blueprintName),
+		fmt.Sprintf("  blueprint sha256: %s (of %s)", p.blueprintSHA256,
+			path.Join("..", HiddenGhpcDirName, ArtifactsDirName, expandedBlueprintName)),
+		fmt.Sprintf("  deployment group: %s", p.group),
+	}
+	if p.ghpcVersion != "" {
+		lines = append(lines, fmt.Sprintf("  ghpc version:     %s", p.ghpcVersion))
+	}
+	return tokensForComment(lines...)
+}
+
+// metadataValue returns the value of the ghpc_metadata local
+func (p provenance) metadataValue(modules []config.Module) cty.Value {
+	ids := []cty.Value{}
+	for _, mod := range modules {
+		ids = append(ids, cty.StringVal(string(mod.ID)))
+	}
+	modIDs := cty.ListValEmpty(cty.String)
+	if len(ids) > 0 {
+		modIDs = cty.ListVal(ids)
+	}
+	return cty.ObjectVal(map[string]cty.Value{
+		"blueprint_name":   cty.StringVal(p.blueprintName),
+		"blueprint_sha256": cty.StringVal(p.blueprintSHA256),
+		"deployment_group": cty.StringVal(string(p.group)),
+		"ghpc_version":     cty.StringVal(p.ghpcVersion),
+		"module_ids":       modIDs,
+	})
+}
+
+// tokensForModuleComment returns the comment above the block of a module,
+// which names its ID and source in the blueprint
+func tokensForModuleComment(mod config.Module) hclwrite.Tokens {
+	return tokensForComment(fmt.Sprintf("ghpc module %s, source %s", mod.ID, mod.Source))
+}
+
+func tokensForComment(lines ...string) hclwrite.Tokens {
+	toks := hclwrite.Tokens{}
+	for _, l := range lines {
+		toks = append(toks, &hclwrite.Token{
+			Type:  hclsyntax.TokenComment,
+			Bytes: []byte(strings.TrimRight("# "+l, " ") + "\n"),
+		})
+	}
+	return toks
+}
@@ -0,0 +1,54 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// UploadStartupScripts copies startup-script runners hosted by
+// config.HostStartupScripts to Cloud Storage. Objects are named after a hash
+// of their content, so objects that already exist are not uploaded again.
+func UploadStartupScripts(ctx context.Context, scripts []config.StartupScript) error {
+	if len(scripts) == 0 {
+		return nil
+	}
+
+	s, err := storage.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Storage client to upload startup scripts: %w", err)
+	}
+
+	for _, sc := range scripts {
+		bucket, object, _ := strings.Cut(strings.TrimPrefix(sc.URI, "gs://"), "/")
+		if _, err := s.Objects.Get(bucket, object).Context(ctx).Do(); err == nil {
+			continue
+		}
+		obj := &storage.Object{Name: object}
+		if _, err := s.Objects.Insert(bucket, obj).Media(bytes.NewReader(sc.Content)).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to upload startup script of module %s to %s: %w", sc.Module, sc.URI, err)
+		}
+		fmt.Printf("Uploaded startup script of module %s to %s\n", sc.Module, sc.URI)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"log"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// CheckNewStatePrefixes errors if a group of a deployment that is created for
+// the first time would keep its Terraform state where a state already exists,
+// which is most likely that of another deployment using the same bucket and
+// prefix. States that cannot be looked up are reported as warnings.
+func CheckNewStatePrefixes(ctx context.Context, prefixes []config.StatePrefix) error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	s, err := storage.NewService(ctx)
+	if err != nil {
+		log.Printf("WARNING: could not create Cloud Storage client to look for existing Terraform states: %v", err)
+		return nil
+	}
+
+	for _, p := range prefixes {
+		_, err := s.Objects.Get(p.Bucket, p.StateObject()).Context(ctx).Do()
+		var herr *googleapi.Error
+		switch {
+		case err == nil:
+			return fmt.Errorf("group %s would use the Terraform state gs://%s/%s, which already exists and may belong to another deployment; "+
+				"give the group a distinct prefix, e.g. with the {{deployment_name}} placeholder, "+
+				"or use --allow-existing-state if the state is that of this deployment", p.Group, p.Bucket, p.StateObject())
+		case errors.As(err, &herr) && herr.Code == http.StatusNotFound:
+			continue
+		default:
+			log.Printf("WARNING: could not look for an existing Terraform state of group %s at gs://%s/%s: %v",
+				p.Group, p.Bucket, p.StateObject(), err)
+		}
+	}
+	return nil
+}
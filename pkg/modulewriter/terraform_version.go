@@ -0,0 +1,90 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"fmt"
+	"strings"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/hashicorp/go-version"
+	"golang.org/x/exp/slices"
+)
+
+// TerraformVersion returns the range of Terraform versions that can deploy
+// the Terraform groups of a deployment, as a version constraint: the
+// intersection of the required_version of the groups and of their modules.
+// Lower bounds are reduced to the highest one. It is empty if the deployment
+// has no Terraform groups.
+func TerraformVersion(dc config.DeploymentConfig) (string, error) {
+	required := []string{}
+	for _, grp := range dc.Config.DeploymentGroups {
+		if grp.Kind != config.TerraformKind {
+			continue
+		}
+		required = append(required, terraformVersion)
+		for _, mod := range grp.Modules {
+			ids, err := mod.ResolvedImports(dc.Config)
+			if err != nil {
+				return "", err
+			}
+			if len(ids) > 0 {
+				required = append(required, importsTerraformVersion)
+			}
+			mi, err := modulereader.GetModuleInfo(mod.Source, mod.Kind.String())
+			if err != nil {
+				return "", err
+			}
+			required = append(required, mi.RequiredCore...)
+		}
+	}
+	return intersectVersions(required)
+}
+
+// intersectVersions joins version constraints, dropping duplicates and all
+// but the highest ">=" bound
+func intersectVersions(required []string) (string, error) {
+	var lower *version.Version
+	others := []string{}
+	for _, r := range required {
+		cs, err := version.NewConstraint(r)
+		if err != nil {
+			return "", fmt.Errorf("invalid Terraform version constraint %q: %w", r, err)
+		}
+		for _, c := range cs {
+			s := strings.TrimSpace(c.String())
+			if strings.HasPrefix(s, ">=") {
+				if v, err := version.NewVersion(strings.TrimSpace(strings.TrimPrefix(s, ">="))); err == nil {
+					if lower == nil || v.GreaterThan(lower) {
+						lower = v
+					}
+					continue
+				}
+			}
+			if !slices.Contains(others, s) {
+				others = append(others, s)
+			}
+		}
+	}
+	slices.Sort(others)
+	if lower != nil {
+		others = append([]string{">= " + lower.Original()}, others...)
+	}
+	return strings.Join(others, ", "), nil
+}
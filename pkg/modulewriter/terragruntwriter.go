@@ -0,0 +1,125 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"hpc-toolkit/pkg/config"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// TerragruntFileName is the Terragrunt configuration of a Terraform group
+const TerragruntFileName = "terragrunt.hcl"
+
+// WriteTerragruntProject writes a terragrunt.hcl to every Terraform group of a
+// deployment written by WriteDeployment, so that terragrunt run-all deploys
+// the groups in order. References to modules of earlier groups are inputs
+// read from dependency blocks on their groups, replacing ghpc export-outputs
+// and ghpc import-inputs, and the remote state is configured from the
+// terraform_backend of the group. Packer groups are not part of the project.
+func WriteTerragruntProject(dc config.DeploymentConfig, deploymentDir string) error {
+	previous := config.GroupName("")
+	for _, grp := range dc.Config.DeploymentGroups {
+		if grp.Kind != config.TerraformKind {
+			continue
+		}
+		dst := filepath.Join(deploymentDir, string(grp.Name), TerragruntFileName)
+		if err := writeTerragruntConfig(grp, dc.Config, previous, dst); err != nil {
+			return fmt.Errorf("error writing %s for deployment group %s: %w", TerragruntFileName, grp.Name, err)
+		}
+		previous = grp.Name
+	}
+
+	// the Terragrunt configuration is part of the deployment that ghpc verify
+	// checks
+	return writeManifest(deploymentDir, dc)
+}
+
+func writeTerragruntConfig(grp config.DeploymentGroup, bp config.Blueprint, previous config.GroupName, dst string) error {
+	if err := createBaseFile(dst); err != nil {
+		return err
+	}
+	hclFile := hclwrite.NewEmptyFile()
+	hclBody := hclFile.Body()
+
+	// an input for every output of earlier groups used by the group, which
+	// Terragrunt reads from the state of the producing group
+	igcVars := FindIntergroupVariables(grp, bp)
+	producers := map[config.GroupName]bool{}
+	inputs := map[string]hclwrite.Tokens{}
+	for r, v := range igcVars {
+		producer := bp.ModuleGroupOrDie(r.Module).Name
+		producers[producer] = true
+		inputs[v.Name] = hclwrite.TokensForTraversal(hcl.Traversal{
+			hcl.TraverseRoot{Name: "dependency"},
+			hcl.TraverseAttr{Name: string(producer)},
+			hcl.TraverseAttr{Name: "outputs"},
+			hcl.TraverseAttr{Name: v.Name},
+		})
+	}
+
+	// groups are deployed in the order of the blueprint, as by ghpc deploy,
+	// even if they use no output of the previous group
+	if previous != "" && !producers[previous] {
+		hclBody.AppendNewline()
+		deps := hclBody.AppendNewBlock("dependencies", []string{}).Body()
+		deps.SetAttributeValue("paths", cty.ListVal([]cty.Value{cty.StringVal(groupConfigPath(previous))}))
+	}
+
+	names := maps.Keys(producers)
+	slices.Sort(names)
+	for _, p := range names {
+		hclBody.AppendNewline()
+		dep := hclBody.AppendNewBlock("dependency", []string{string(p)}).Body()
+		dep.SetAttributeValue("config_path", cty.StringVal(groupConfigPath(p)))
+	}
+
+	if be := grp.TerraformBackend; be.Type != "" {
+		hclBody.AppendNewline()
+		rs := hclBody.AppendNewBlock("remote_state", []string{}).Body()
+		rs.SetAttributeValue("backend", cty.StringVal(be.Type))
+		rs.SetAttributeRaw("config", TokensForValue(be.Configuration.AsObject()))
+	}
+
+	if len(inputs) > 0 {
+		attrs := []hclwrite.ObjectAttrTokens{}
+		for _, name := range orderKeys(inputs) {
+			attrs = append(attrs, hclwrite.ObjectAttrTokens{
+				Name:  hclwrite.TokensForValue(cty.StringVal(name)),
+				Value: inputs[name],
+			})
+		}
+		hclBody.AppendNewline()
+		hclBody.SetAttributeRaw("inputs", hclwrite.TokensForObject(attrs))
+	}
+
+	return appendHCLToFile(dst, hclwrite.Format(hclFile.Bytes()))
+}
+
+// groupConfigPath returns the path of the directory of a group relative to
+// the directory of another group
+func groupConfigPath(grp config.GroupName) string {
+	return path.Join("..", string(grp))
+}
@@ -14,18 +14,29 @@
 
 package modulewriter
 
+// googleProviderVersion constrains the versions of the Google providers of
+// Terraform groups
+const googleProviderVersion = "~> 4.65.2"
+
+// terraformVersion constrains the version of Terraform of Terraform groups
+const terraformVersion = ">= 1.2"
+
+// importsTerraformVersion constrains the version of Terraform of groups that
+// import resources, as import blocks require Terraform 1.5
+const importsTerraformVersion = ">= 1.5"
+
 const tfversions string = `
 terraform {
-  required_version = ">= 1.2"
+  required_version = "` + terraformVersion + `"
 
   required_providers {
     google = {
       source  = "hashicorp/google"
-      version = "~> 4.65.2"
+      version = "` + googleProviderVersion + `"
     }
     google-beta = {
       source  = "hashicorp/google-beta"
-      version = "~> 4.65.2"
+      version = "` + googleProviderVersion + `"
     }
   }
 }
@@ -25,6 +25,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/ext/typeexpr"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
@@ -33,14 +34,21 @@ import (
 	"golang.org/x/exp/slices"
 
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/deploymentio"
 	"hpc-toolkit/pkg/modulereader"
 )
 
 const (
 	tfStateFileName       = "terraform.tfstate"
 	tfStateBackupFileName = "terraform.tfstate.backup"
+	tfvarsFilename        = "terraform.tfvars"
+	// deploymentProviderAlias names the providers of the deployment project in
+	// groups that override the project
+	deploymentProviderAlias = "deployment"
 )
 
+var googleProviders = []string{"google", "google-beta"}
+
 // TFWriter writes terraform to the blueprint folder
 type TFWriter struct {
 	numModules int
@@ -59,7 +67,7 @@ func (w *TFWriter) addNumModules(value int) {
 // createBaseFile creates a baseline file for all terraform/hcl including a
 // license and any other boilerplate
 func createBaseFile(path string) error {
-	baseFile, err := os.Create(path)
+	baseFile, err := deploymentio.Create(path)
 	if err != nil {
 		return err
 	}
@@ -80,10 +88,32 @@ func appendHCLToFile(path string, hclBytes []byte) error {
 	return nil
 }
 
+// writeHCLFile writes a generated file with the license followed by hclBytes
+func writeHCLFile(path string, hclBytes []byte) error {
+	name := filepath.Base(path)
+	if err := createBaseFile(path); err != nil {
+		return fmt.Errorf("error creating %s file: %v", name, err)
+	}
+	if err := appendHCLToFile(path, hclBytes); err != nil {
+		return fmt.Errorf("error writing HCL to %s file: %v", name, err)
+	}
+	return nil
+}
+
 func writeOutputs(
 	modules []config.Module,
 	dst string,
 ) error {
+	hclBytes := renderOutputs(modules)
+	if hclBytes == nil {
+		return nil
+	}
+	return writeHCLFile(filepath.Join(dst, "outputs.tf"), hclBytes)
+}
+
+// renderOutputs returns the output blocks of the modules of a group, or nil
+// if the modules have no outputs
+func renderOutputs(modules []config.Module) []byte {
 	// Create hcl body
 	hclFile := hclwrite.NewEmptyFile()
 	hclBody := hclFile.Body()
@@ -115,23 +145,51 @@ func writeOutputs(
 	if len(outputs) == 0 {
 		return nil
 	}
-	hclBytes := hclFile.Bytes()
-	outputsPath := filepath.Join(dst, "outputs.tf")
-	if err := createBaseFile(outputsPath); err != nil {
-		return fmt.Errorf("error creating outputs.tf file: %v", err)
+	return hclFile.Bytes()
+}
+
+// tokensForDependsOn returns the modules of the group that mod depends on;
+// modules of earlier groups are already created when the group is applied
+func tokensForDependsOn(mod config.Module, group []config.Module) hclwrite.Tokens {
+	deps := []hclwrite.Tokens{}
+	for _, dep := range mod.Depends {
+		if slices.ContainsFunc(group, func(m config.Module) bool { return m.ID == dep }) {
+			deps = append(deps, hclwrite.TokensForTraversal(hcl.Traversal{
+				hcl.TraverseRoot{Name: "module"},
+				hcl.TraverseAttr{Name: string(dep)},
+			}))
+		}
 	}
-	err := appendHCLToFile(outputsPath, hclBytes)
-	if err != nil {
-		return fmt.Errorf("error writing HCL to outputs.tf file: %v", err)
+	if len(deps) == 0 {
+		return nil
 	}
-	return nil
+	return hclwrite.TokensForTuple(deps)
 }
 
-func writeTfvars(vars map[string]cty.Value, dst string) error {
-	// Create file
-	tfvarsPath := filepath.Join(dst, "terraform.tfvars")
-	err := WriteHclAttributes(vars, tfvarsPath)
-	return err
+// writeTfvars writes the values of deployment variables to terraform.tfvars,
+// except for sensitive ones, which are written to sensitive.auto.tfvars
+func writeTfvars(vars map[string]cty.Value, sensitive []string, dst string) error {
+	plain, secret := splitSensitiveVars(vars, sensitive)
+	if err := WriteHclAttributes(plain, filepath.Join(dst, tfvarsFilename)); err != nil {
+		return err
+	}
+	if len(secret) == 0 {
+		return nil
+	}
+	return WriteHclAttributes(secret, filepath.Join(dst, config.SensitiveTfvarsFilename))
+}
+
+func splitSensitiveVars(vars map[string]cty.Value, sensitive []string) (plain, secret map[string]cty.Value) {
+	plain = map[string]cty.Value{}
+	secret = map[string]cty.Value{}
+	for k, v := range vars {
+		if slices.Contains(sensitive, k) {
+			secret[k] = v
+		} else {
+			plain[k] = v
+		}
+	}
+	return plain, secret
 }
 
 func getHclType(t cty.Type) string {
@@ -148,13 +206,11 @@ func getTypeTokens(v cty.Value) hclwrite.Tokens {
 	return simpleTokens(getHclType(v.Type()))
 }
 
-func writeVariables(vars map[string]cty.Value, extraVars []modulereader.VarInfo, dst string) error {
-	// Create file
-	variablesPath := filepath.Join(dst, "variables.tf")
-	if err := createBaseFile(variablesPath); err != nil {
-		return fmt.Errorf("error creating variables.tf file: %v", err)
-	}
+func writeVariables(vars map[string]cty.Value, sensitive []string, extraVars []modulereader.VarInfo, dst string) error {
+	return writeHCLFile(filepath.Join(dst, "variables.tf"), renderVariables(vars, sensitive, extraVars))
+}
 
+func renderVariables(vars map[string]cty.Value, sensitive []string, extraVars []modulereader.VarInfo) []byte {
 	var inputs []modulereader.VarInfo
 	for k, v := range vars {
 		typeStr := getHclType(v.Type())
@@ -162,6 +218,7 @@ func writeVariables(vars map[string]cty.Value, extraVars []modulereader.VarInfo,
 			Name:        k,
 			Type:        typeStr,
 			Description: fmt.Sprintf("Toolkit deployment variable: %s", k),
+			Sensitive:   slices.Contains(sensitive, k),
 		}
 		inputs = append(inputs, newInput)
 	}
@@ -179,30 +236,48 @@ func writeVariables(vars map[string]cty.Value, extraVars []modulereader.VarInfo,
 		blockBody := hclBlock.Body()
 		blockBody.SetAttributeValue("description", cty.StringVal(k.Description))
 		blockBody.SetAttributeRaw("type", simpleTokens(k.Type))
+		if k.Sensitive {
+			blockBody.SetAttributeValue("sensitive", cty.True)
+		}
 	}
-
-	// Write file
-	if err := appendHCLToFile(variablesPath, hclFile.Bytes()); err != nil {
-		return fmt.Errorf("error writing HCL to variables.tf file: %v", err)
-	}
-	return nil
+	return hclFile.Bytes()
 }
 
 func writeMain(
 	modules []config.Module,
 	tfBackend config.TerraformBackend,
+	aliased []config.ModuleID,
+	prov *provenance,
 	dst string,
 ) error {
-	// Create file
-	mainPath := filepath.Join(dst, "main.tf")
-	if err := createBaseFile(mainPath); err != nil {
-		return fmt.Errorf("error creating main.tf file: %v", err)
+	hclBytes, err := renderMain(modules, tfBackend, aliased, prov)
+	if err != nil {
+		return err
 	}
+	return writeHCLFile(filepath.Join(dst, "main.tf"), hclBytes)
+}
 
+func renderMain(
+	modules []config.Module,
+	tfBackend config.TerraformBackend,
+	aliased []config.ModuleID,
+	prov *provenance,
+) ([]byte, error) {
 	// Create HCL Body
 	hclFile := hclwrite.NewEmptyFile()
 	hclBody := hclFile.Body()
 
+	// Record the blueprint the group was generated from
+	if prov != nil {
+		hclBody.AppendNewline()
+		hclBody.AppendUnstructuredTokens(prov.headerTokens())
+		if prov.metadata {
+			hclBody.AppendNewline()
+			locals := hclBody.AppendNewBlock("locals", []string{}).Body()
+			locals.SetAttributeValue("ghpc_metadata", prov.metadataValue(modules))
+		}
+	}
+
 	// Write Terraform backend if needed
 	if tfBackend.Type != "" {
 		hclBody.AppendNewline()
@@ -211,12 +286,15 @@ func writeMain(
 		backendBody := backendBlock.Body()
 		vals := tfBackend.Configuration.Items()
 		for _, setting := range orderKeys(vals) {
-			backendBody.SetAttributeValue(setting, vals[setting])
+			backendBody.SetAttributeRaw(setting, TokensForValue(vals[setting]))
 		}
 	}
 
 	for _, mod := range modules {
 		hclBody.AppendNewline()
+		if prov != nil {
+			hclBody.AppendUnstructuredTokens(tokensForModuleComment(mod))
+		}
 		// Add block
 		moduleBlock := hclBody.AppendNewBlock("module", []string{string(mod.ID)})
 		moduleBody := moduleBlock.Body()
@@ -224,105 +302,208 @@ func writeMain(
 		// Add source attribute
 		moduleBody.SetAttributeValue("source", cty.StringVal(mod.DeploymentSource))
 
+		// Pass the providers of the deployment project, if the group uses
+		// another project by default
+		if slices.Contains(aliased, mod.ID) {
+			moduleBody.SetAttributeRaw("providers", tokensForProviders(deploymentProviderAlias))
+		}
+
 		// For each Setting
 		for _, setting := range orderKeys(mod.Settings.Items()) {
 			value := mod.Settings.Get(setting)
-			if wrap, ok := mod.WrapSettingsWith[setting]; ok {
-				if len(wrap) != 2 {
-					return fmt.Errorf(
-						"invalid length of WrapSettingsWith for %s.%s, expected 2 got %d",
-						mod.ID, setting, len(wrap))
-				}
-				toks, err := tokensForWrapped(wrap[0], value, wrap[1])
+			if ts, ok := mod.Transforms[setting]; ok {
+				toks, err := config.TokensForTransformed(ts, value, TokensForValue)
 				if err != nil {
-					return fmt.Errorf("failed to process %s.%s: %v", mod.ID, setting, err)
+					return nil, fmt.Errorf("failed to process %s.%s: %v", mod.ID, setting, err)
 				}
 				moduleBody.SetAttributeRaw(setting, toks)
 			} else {
 				moduleBody.SetAttributeRaw(setting, TokensForValue(value))
 			}
 		}
-	}
-	// Write file
-	hclBytes := hclFile.Bytes()
-	hclBytes = hclwrite.Format(hclBytes)
-	if err := appendHCLToFile(mainPath, hclBytes); err != nil {
-		return fmt.Errorf("error writing HCL to main.tf file: %v", err)
-	}
-	return nil
-}
 
-func tokensForWrapped(pref string, val cty.Value, suf string) (hclwrite.Tokens, error) {
-	var toks hclwrite.Tokens
-	if !val.Type().IsListType() && !val.Type().IsTupleType() {
-		return toks, fmt.Errorf(
-			"invalid value for wrapped setting, expected sequence, got %#v", val.Type())
-	}
-	toks = append(toks, simpleTokens(pref)...)
-
-	it, first := val.ElementIterator(), true
-	for it.Next() {
-		if !first {
-			toks = append(toks, &hclwrite.Token{
-				Type:  hclsyntax.TokenComma,
-				Bytes: []byte{','}})
+		if deps := tokensForDependsOn(mod, modules); deps != nil {
+			moduleBody.SetAttributeRaw("depends_on", deps)
 		}
-		_, el := it.Element()
-		toks = append(toks, TokensForValue(el)...)
-		first = false
 	}
-	toks = append(toks, simpleTokens(suf)...)
-
-	return toks, nil
+	return hclwrite.Format(hclFile.Bytes()), nil
 }
 
 var simpleTokens = hclwrite.TokensForIdentifier
 
-func writeProviders(vars map[string]cty.Value, dst string) error {
-	// Create file
-	providersPath := filepath.Join(dst, "providers.tf")
-	if err := createBaseFile(providersPath); err != nil {
-		return fmt.Errorf("error creating providers.tf file: %v", err)
+// tokensForProviders returns a providers map that replaces the default
+// Google providers with those named alias
+func tokensForProviders(alias string) hclwrite.Tokens {
+	attrs := []hclwrite.ObjectAttrTokens{}
+	for _, prov := range googleProviders {
+		attrs = append(attrs, hclwrite.ObjectAttrTokens{
+			Name: simpleTokens(prov),
+			Value: hclwrite.TokensForTraversal(hcl.Traversal{
+				hcl.TraverseRoot{Name: prov},
+				hcl.TraverseAttr{Name: alias},
+			}),
+		})
+	}
+	return hclwrite.TokensForObject(attrs)
+}
+
+// deploymentProjectModules returns the modules of a group that overrides the
+// project whose project_id setting refers to the deployment variable instead
+func deploymentProjectModules(group config.DeploymentGroup) []config.ModuleID {
+	ids := []config.ModuleID{}
+	if group.ProjectID == "" {
+		return ids
 	}
+	for _, mod := range group.Modules {
+		if !mod.Settings.Has("project_id") {
+			continue
+		}
+		e, is := config.IsExpressionValue(mod.Settings.Get("project_id"))
+		if !is {
+			continue
+		}
+		if slices.Contains(e.References(), config.GlobalRef("project_id")) {
+			ids = append(ids, mod.ID)
+		}
+	}
+	return ids
+}
 
+func writeProviders(vars map[string]cty.Value, projectID string, serviceAccount string, dst string) error {
+	return writeHCLFile(filepath.Join(dst, "providers.tf"), renderProviders(vars, projectID, serviceAccount))
+}
+
+func renderProviders(vars map[string]cty.Value, projectID string, serviceAccount string) []byte {
 	// Create HCL Body
 	hclFile := hclwrite.NewEmptyFile()
 	hclBody := hclFile.Body()
 
-	for _, prov := range []string{"google", "google-beta"} {
+	_, usesDeploymentProject := vars["project_id"]
+	for _, prov := range googleProviders {
 		hclBody.AppendNewline()
 		provBlock := hclBody.AppendNewBlock("provider", []string{prov})
 		provBody := provBlock.Body()
-		if _, ok := vars["project_id"]; ok {
+		if projectID != "" {
+			provBody.SetAttributeValue("project", cty.StringVal(projectID))
+		} else if usesDeploymentProject {
+			provBody.SetAttributeRaw("project", simpleTokens("var.project_id"))
+		}
+		setProviderLocation(provBody, vars)
+		setProviderImpersonation(provBody, serviceAccount)
+	}
+
+	// a group that overrides the project can still reach the deployment
+	// project through aliased providers
+	if projectID != "" && usesDeploymentProject {
+		for _, prov := range googleProviders {
+			hclBody.AppendNewline()
+			provBody := hclBody.AppendNewBlock("provider", []string{prov}).Body()
+			provBody.SetAttributeRaw("alias", TokensForValue(cty.StringVal(deploymentProviderAlias)))
 			provBody.SetAttributeRaw("project", simpleTokens("var.project_id"))
+			setProviderLocation(provBody, vars)
+			setProviderImpersonation(provBody, serviceAccount)
 		}
-		if _, ok := vars["zone"]; ok {
-			provBody.SetAttributeRaw("zone", simpleTokens("var.zone"))
+	}
+
+	return hclFile.Bytes()
+}
+
+func setProviderLocation(provBody *hclwrite.Body, vars map[string]cty.Value) {
+	if _, ok := vars["zone"]; ok {
+		provBody.SetAttributeRaw("zone", simpleTokens("var.zone"))
+	}
+	if _, ok := vars["region"]; ok {
+		provBody.SetAttributeRaw("region", simpleTokens("var.region"))
+	}
+}
+
+func setProviderImpersonation(provBody *hclwrite.Body, serviceAccount string) {
+	if serviceAccount != "" {
+		provBody.SetAttributeValue("impersonate_service_account", cty.StringVal(serviceAccount))
+	}
+}
+
+// writeImports writes the import blocks of the resources adopted by the
+// modules of a group to imports.tf; the file is not written if no module
+// imports resources. Import blocks require Terraform 1.5.
+func writeImports(modules []config.Module, bp config.Blueprint, dst string) error {
+	hclBytes, err := renderImports(modules, bp)
+	if err != nil || hclBytes == nil {
+		return err
+	}
+	return writeHCLFile(filepath.Join(dst, "imports.tf"), hclBytes)
+}
+
+func renderImports(modules []config.Module, bp config.Blueprint) ([]byte, error) {
+	hclFile := hclwrite.NewEmptyFile()
+	hclBody := hclFile.Body()
+	imported := false
+	for _, mod := range modules {
+		ids, err := mod.ResolvedImports(bp)
+		if err != nil {
+			return nil, err
 		}
-		if _, ok := vars["region"]; ok {
-			provBody.SetAttributeRaw("region", simpleTokens("var.region"))
+		addrs := maps.Keys(ids)
+		slices.Sort(addrs)
+		for _, addr := range addrs {
+			to, diags := hclsyntax.ParseTraversalAbs(
+				[]byte(fmt.Sprintf("module.%s.%s", mod.ID, addr)), "", hcl.InitialPos)
+			if diags.HasErrors() {
+				return nil, diags
+			}
+			hclBody.AppendNewline()
+			importBody := hclBody.AppendNewBlock("import", nil).Body()
+			importBody.SetAttributeTraversal("to", to)
+			importBody.SetAttributeValue("id", cty.StringVal(ids[addr]))
+			imported = true
 		}
 	}
+	if !imported {
+		return nil, nil
+	}
+	hclBody.AppendNewline()
+	hclBody.AppendNewBlock("terraform", nil).Body().
+		SetAttributeValue("required_version", cty.StringVal(importsTerraformVersion))
+	return hclFile.Bytes(), nil
+}
 
-	// Write file
-	hclBytes := hclFile.Bytes()
-	if err := appendHCLToFile(providersPath, hclBytes); err != nil {
-		return fmt.Errorf("error writing HCL to providers.tf file: %v", err)
+// writeRemoteStates writes the terraform_remote_state data sources that read
+// the outputs of the groups of external deployments used by the group, one
+// per deployment with an instance per group read
+func writeRemoteStates(group config.DeploymentGroup, bp config.Blueprint, dst string) error {
+	hclBytes, err := renderRemoteStates(group, bp)
+	if err != nil || hclBytes == nil {
+		return err
 	}
-	return nil
+	return writeHCLFile(filepath.Join(dst, "remote_state.tf"), hclBytes)
 }
 
-func writeVersions(dst string) error {
-	// Create file
-	versionsPath := filepath.Join(dst, "versions.tf")
-	if err := createBaseFile(versionsPath); err != nil {
-		return fmt.Errorf("error creating versions.tf file: %v", err)
+func renderRemoteStates(group config.DeploymentGroup, bp config.Blueprint) ([]byte, error) {
+	states, err := bp.RemoteStates(group)
+	if err != nil {
+		return nil, err
 	}
-	// Write hard-coded version information
-	if err := appendHCLToFile(versionsPath, []byte(tfversions)); err != nil {
-		return fmt.Errorf("error writing HCL to versions.tf file: %v", err)
+	if len(states) == 0 {
+		return nil, nil
 	}
-	return nil
+
+	hclFile := hclwrite.NewEmptyFile()
+	hclBody := hclFile.Body()
+	for _, s := range states {
+		configs := map[string]cty.Value{}
+		for g, c := range s.Configs {
+			configs[string(g)] = c.AsObject()
+		}
+		hclBody.AppendNewline()
+		dataBody := hclBody.AppendNewBlock("data", []string{"terraform_remote_state", s.Name}).Body()
+		dataBody.SetAttributeRaw("for_each", TokensForValue(cty.ObjectVal(configs)))
+		dataBody.SetAttributeValue("backend", cty.StringVal(s.Backend))
+		dataBody.SetAttributeTraversal("config", hcl.Traversal{
+			hcl.TraverseRoot{Name: "each"},
+			hcl.TraverseAttr{Name: "value"},
+		})
+	}
+	return hclFile.Bytes(), nil
 }
 
 func writeTerraformInstructions(w io.Writer, grpPath string, n config.GroupName, printExportOutputs bool, printImportInputs bool) {
@@ -354,66 +535,95 @@ func (w TFWriter) writeDeploymentGroup(
 	instructionsFile io.Writer,
 ) error {
 	depGroup := dc.Config.DeploymentGroups[groupIndex]
-	deploymentVars := getUsedDeploymentVars(depGroup, dc.Config)
-	intergroupVars := FindIntergroupVariables(depGroup, dc.Config)
-	intergroupInputs := make(map[string]bool)
-	for _, igVar := range intergroupVars {
-		intergroupInputs[igVar.Name] = true
-	}
-
 	groupPath := filepath.Join(deploymentDir, string(depGroup.Name))
 
-	// Write main.tf file
-	doctoredModules := substituteIgcReferences(depGroup.Modules, intergroupVars)
-	if err := writeMain(
-		doctoredModules, depGroup.TerraformBackend, groupPath,
-	); err != nil {
-		return fmt.Errorf("error writing main.tf file for deployment group %s: %v",
-			depGroup.Name, err)
+	files, err := w.renderDeploymentGroup(dc, groupIndex)
+	if err != nil {
+		return err
+	}
+	for _, name := range orderKeys(files) {
+		if err := writeHCLFile(filepath.Join(groupPath, name), files[name]); err != nil {
+			return fmt.Errorf("error writing %s file for deployment group %s: %v",
+				name, depGroup.Name, err)
+		}
 	}
 
-	// Write variables.tf file
-	if err := writeVariables(deploymentVars, maps.Values(intergroupVars), groupPath); err != nil {
-		return fmt.Errorf(
-			"error writing variables.tf file for deployment group %s: %v",
+	multiGroupDeployment := len(dc.Config.DeploymentGroups) > 1
+	printImportInputs := multiGroupDeployment && groupIndex > 0
+	printExportOutputs := multiGroupDeployment && groupIndex < len(dc.Config.DeploymentGroups)-1
+
+	writeTerraformInstructions(instructionsFile, groupPath, depGroup.Name, printExportOutputs, printImportInputs)
+
+	return nil
+}
+
+// renderDeploymentGroup returns the HCL of the files of a terraform
+// deployment group, without the license, by file name. Files that the group
+// does not need, such as outputs.tf for a group without outputs, are omitted.
+func (w TFWriter) renderDeploymentGroup(dc config.DeploymentConfig, groupIndex int) (map[string][]byte, error) {
+	depGroup := dc.Config.DeploymentGroups[groupIndex]
+	deploymentVars := getUsedDeploymentVars(depGroup, dc.Config)
+	intergroupVars := FindIntergroupVariables(depGroup, dc.Config)
+	files := map[string][]byte{}
+
+	// main.tf
+	doctoredModules := substituteIgcReferences(depGroup.Modules, intergroupVars, "var")
+	prov, err := newProvenance(dc, depGroup.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error recording the provenance of deployment group %s: %v", depGroup.Name, err)
+	}
+	files["main.tf"], err = renderMain(
+		doctoredModules, depGroup.TerraformBackend, deploymentProjectModules(depGroup), &prov)
+	if err != nil {
+		return nil, fmt.Errorf("error writing main.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
 
-	// Write outputs.tf file
-	if err := writeOutputs(depGroup.Modules, groupPath); err != nil {
-		return fmt.Errorf(
-			"error writing outputs.tf file for deployment group %s: %v",
-			depGroup.Name, err)
+	// variables.tf
+	sensitiveVars := dc.Config.SensitiveVars()
+	files["variables.tf"] = renderVariables(deploymentVars, sensitiveVars, maps.Values(intergroupVars))
+
+	// outputs.tf
+	if outputs := renderOutputs(depGroup.Modules); outputs != nil {
+		files["outputs.tf"] = outputs
 	}
 
-	// Write terraform.tfvars file
-	if err := writeTfvars(deploymentVars, groupPath); err != nil {
-		return fmt.Errorf(
-			"error writing terraform.tfvars file for deployment group %s: %v",
-			depGroup.Name, err)
+	// terraform.tfvars and sensitive.auto.tfvars
+	plain, secret := splitSensitiveVars(deploymentVars, sensitiveVars)
+	files[tfvarsFilename] = renderHclAttributes(plain)
+	if len(secret) > 0 {
+		files[config.SensitiveTfvarsFilename] = renderHclAttributes(secret)
 	}
 
-	// Write providers.tf file
-	if err := writeProviders(deploymentVars, groupPath); err != nil {
-		return fmt.Errorf(
-			"error writing providers.tf file for deployment group %s: %v",
+	// providers.tf
+	files["providers.tf"] = renderProviders(
+		deploymentVars, depGroup.ProjectID, dc.Config.ImpersonatedServiceAccount(depGroup))
+
+	// versions.tf holds hard-coded version information
+	files["versions.tf"] = []byte(tfversions)
+
+	// imports.tf
+	imports, err := renderImports(depGroup.Modules, dc.Config)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error writing imports.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
+	if imports != nil {
+		files["imports.tf"] = imports
+	}
 
-	// Write versions.tf file
-	if err := writeVersions(groupPath); err != nil {
-		return fmt.Errorf(
-			"error writing versions.tf file for deployment group %s: %v",
+	// remote_state.tf
+	states, err := renderRemoteStates(depGroup, dc.Config)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error writing remote_state.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
-
-	multiGroupDeployment := len(dc.Config.DeploymentGroups) > 1
-	printImportInputs := multiGroupDeployment && groupIndex > 0
-	printExportOutputs := multiGroupDeployment && groupIndex < len(dc.Config.DeploymentGroups)-1
-
-	writeTerraformInstructions(instructionsFile, groupPath, depGroup.Name, printExportOutputs, printImportInputs)
-
-	return nil
+	if states != nil {
+		files["remote_state.tf"] = states
+	}
+	return files, nil
 }
 
 // Transfers state files from previous resource groups (in .ghpc/) to a newly written blueprint
@@ -434,7 +644,7 @@ func (w TFWriter) restoreState(deploymentDir string) error {
 			dest := filepath.Join(deploymentDir, f.Name(), stateFile)
 
 			if bytesRead, err := ioutil.ReadFile(src); err == nil {
-				err = ioutil.WriteFile(dest, bytesRead, 0644)
+				err = deploymentio.WriteFile(dest, bytesRead, false)
 				if err != nil {
 					return fmt.Errorf("failed to write previous state file %s, %w", dest, err)
 				}
@@ -475,10 +685,13 @@ func getUsedDeploymentVars(group config.DeploymentGroup, bp config.Blueprint) ma
 	return filteredVars
 }
 
-func substituteIgcReferences(mods []config.Module, igcRefs map[config.Reference]modulereader.VarInfo) []config.Module {
+// substituteIgcReferences replaces the references to other groups with
+// references to root.<IGC var name>, where root is var or, for CDKTF
+// projects, local
+func substituteIgcReferences(mods []config.Module, igcRefs map[config.Reference]modulereader.VarInfo, root string) []config.Module {
 	doctoredMods := make([]config.Module, len(mods))
 	for i, mod := range mods {
-		doctoredMods[i] = SubstituteIgcReferencesInModule(mod, igcRefs)
+		doctoredMods[i] = substituteIgcReferencesInModule(mod, igcRefs, root)
 	}
 	return doctoredMods
 }
@@ -486,6 +699,10 @@ func substituteIgcReferences(mods []config.Module, igcRefs map[config.Reference]
 // SubstituteIgcReferencesInModule updates expressions in Module settings to use
 // special IGC var name instead of the module reference
 func SubstituteIgcReferencesInModule(mod config.Module, igcRefs map[config.Reference]modulereader.VarInfo) config.Module {
+	return substituteIgcReferencesInModule(mod, igcRefs, "var")
+}
+
+func substituteIgcReferencesInModule(mod config.Module, igcRefs map[config.Reference]modulereader.VarInfo, root string) config.Module {
 	v, _ := cty.Transform(mod.Settings.AsObject(), func(p cty.Path, v cty.Value) (cty.Value, error) {
 		e, is := config.IsExpressionValue(v)
 		if !is {
@@ -498,9 +715,14 @@ func SubstituteIgcReferencesInModule(mod config.Module, igcRefs map[config.Refer
 				continue
 			}
 			s := fmt.Sprintf("module.%s.%s", r.Module, r.Name)
-			rs := fmt.Sprintf("var.%s", oi.Name)
+			rs := fmt.Sprintf("%s.%s", root, oi.Name)
 			ue = strings.ReplaceAll(ue, s, rs)
 		}
+		if root != "var" {
+			// expressions cannot refer to locals, which are only written
+			// to CDKTF projects, so the result is kept as an HCL literal
+			return cty.StringVal("((" + ue + "))"), nil
+		}
 		return config.MustParseExpression(ue).AsValue(), nil
 	})
 	mod.Settings = config.NewDict(v.AsValueMap())
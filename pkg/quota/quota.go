@@ -0,0 +1,237 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quota finds the Compute Engine quotas that a blueprint exceeds and
+// requests their increase with the Cloud Quotas API
+package quota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+const cloudQuotasEndpoint = "https://cloudquotas.googleapis.com/v1/"
+
+const computeService = "compute.googleapis.com"
+
+// machine families whose CPUs count against their own regional quota; the
+// CPUs of other families count against CPUS
+var familyCPUQuotas = []string{
+	"a2", "c2", "c2d", "c3", "c3d", "g2", "h3", "m1", "m2", "m3", "n2", "n2d", "t2a", "t2d",
+}
+
+// Demand is the number of VMs of a machine type that a module creates in a
+// zone
+type Demand struct {
+	Module      string
+	ProjectID   string
+	Zone        string
+	MachineType string
+	Count       int64
+}
+
+// Shortfall is a regional quota of a project that is too low for the VMs of
+// a blueprint
+type Shortfall struct {
+	ProjectID string
+	Region    string
+	// Metric is the Compute Engine quota metric, e.g. N2_CPUS
+	Metric string
+	Limit  float64
+	Usage  float64
+	// Required is the amount of the quota needed by Modules
+	Required int64
+	Modules  []string
+}
+
+// Desired returns the limit that accommodates the current usage and the VMs
+// of the blueprint
+func (s Shortfall) Desired() int64 {
+	return int64(math.Ceil(s.Usage)) + s.Required
+}
+
+// cpuMetric returns the quota metric of the CPUs of a machine type
+func cpuMetric(machineType string) string {
+	family, _, _ := strings.Cut(machineType, "-")
+	if slices.Contains(familyCPUQuotas, family) {
+		return strings.ToUpper(family) + "_CPUS"
+	}
+	return "CPUS"
+}
+
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+type regionKey struct{ project, region string }
+
+type metricKey struct {
+	regionKey
+	metric string
+}
+
+// Shortfalls returns the regional CPU quotas that are too low for the VMs of
+// the demands to be created in addition to the current usage, sorted by
+// project, region and metric. The Compute API client is created with opts.
+func Shortfalls(ctx context.Context, demands []Demand, opts ...option.ClientOption) ([]Shortfall, error) {
+	if len(demands) == 0 {
+		return nil, nil
+	}
+	s, err := compute.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	required := map[metricKey]int64{}
+	modules := map[metricKey][]string{}
+	cpus := map[string]int64{}
+	for _, d := range demands {
+		key := d.ProjectID + "/" + d.Zone + "/" + d.MachineType
+		n, ok := cpus[key]
+		if !ok {
+			mt, err := s.MachineTypes.Get(d.ProjectID, d.Zone, d.MachineType).Context(ctx).Do()
+			if err != nil {
+				return nil, fmt.Errorf("module %s: failed to get machine type %s in zone %s: %w", d.Module, d.MachineType, d.Zone, err)
+			}
+			n = mt.GuestCpus
+			cpus[key] = n
+		}
+		mk := metricKey{regionKey{d.ProjectID, zoneRegion(d.Zone)}, cpuMetric(d.MachineType)}
+		required[mk] += n * d.Count
+		if !slices.Contains(modules[mk], d.Module) {
+			modules[mk] = append(modules[mk], d.Module)
+		}
+	}
+
+	quotas := map[regionKey][]*compute.Quota{}
+	shortfalls := []Shortfall{}
+	for mk, req := range required {
+		q, ok := quotas[mk.regionKey]
+		if !ok {
+			r, err := s.Regions.Get(mk.project, mk.region).Context(ctx).Do()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the quotas of region %s in project %s: %w", mk.region, mk.project, err)
+			}
+			q = r.Quotas
+			quotas[mk.regionKey] = q
+		}
+		i := slices.IndexFunc(q, func(q *compute.Quota) bool { return q.Metric == mk.metric })
+		if i == -1 { // the family is not offered in the region
+			continue
+		}
+		if q[i].Usage+float64(req) <= q[i].Limit {
+			continue
+		}
+		mods := modules[mk]
+		sort.Strings(mods)
+		shortfalls = append(shortfalls, Shortfall{
+			ProjectID: mk.project, Region: mk.region, Metric: mk.metric,
+			Limit: q[i].Limit, Usage: q[i].Usage, Required: req, Modules: mods,
+		})
+	}
+	sort.Slice(shortfalls, func(i, j int) bool {
+		a, b := shortfalls[i], shortfalls[j]
+		if a.ProjectID != b.ProjectID {
+			return a.ProjectID < b.ProjectID
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Metric < b.Metric
+	})
+	return shortfalls, nil
+}
+
+// Preference is a quota preference of the Cloud Quotas API, which requests
+// the limit of a quota
+type Preference struct {
+	Name          string            `json:"name"`
+	Service       string            `json:"service"`
+	QuotaID       string            `json:"quotaId"`
+	QuotaConfig   PreferenceConfig  `json:"quotaConfig"`
+	Dimensions    map[string]string `json:"dimensions"`
+	Justification string            `json:"justification,omitempty"`
+	ContactEmail  string            `json:"contactEmail,omitempty"`
+}
+
+// PreferenceConfig holds the requested limit
+type PreferenceConfig struct {
+	// PreferredValue is an int64, which the API encodes as a string
+	PreferredValue string `json:"preferredValue"`
+}
+
+// Preference returns the quota preference that requests the desired limit;
+// the preference is named after the quota and region, so that filing it again
+// updates the previous request
+func (s Shortfall) Preference(justification string, contactEmail string) Preference {
+	// e.g. N2_CPUS is N2-CPUS-per-project-region
+	quotaID := strings.ReplaceAll(s.Metric, "_", "-") + "-per-project-region"
+	id := fmt.Sprintf("ghpc-%s-%s", strings.ToLower(strings.ReplaceAll(s.Metric, "_", "-")), s.Region)
+	return Preference{
+		Name:          fmt.Sprintf("projects/%s/locations/global/quotaPreferences/%s", s.ProjectID, id),
+		Service:       computeService,
+		QuotaID:       quotaID,
+		QuotaConfig:   PreferenceConfig{PreferredValue: strconv.FormatInt(s.Desired(), 10)},
+		Dimensions:    map[string]string{"region": s.Region},
+		Justification: justification,
+		ContactEmail:  contactEmail,
+	}
+}
+
+// File creates or updates the quota preference with the credentials of the
+// user, or those given by opts
+func (p Preference) File(ctx context.Context, opts ...option.ClientOption) error {
+	opts = append([]option.ClientOption{option.WithScopes("https://www.googleapis.com/auth/cloud-platform")}, opts...)
+	client, _, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	u := cloudQuotasEndpoint + p.Name + "?" + url.Values{"allowMissing": {"true"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to file quota preference %s: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to file quota preference %s: %s\n%s", p.Name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scaffold generates a minimal blueprint and tests that exercise a
+// Terraform module, from the inputs and outputs of the module
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"golang.org/x/exp/slices"
+)
+
+// TestsDir is the directory of the module in which `terraform test` looks
+// for test files, and into which the scaffold is written
+const TestsDir = "tests"
+
+// placeholder is the value of the string inputs that must be set by hand
+const placeholder = "CHANGE_ME"
+
+// deploymentVars are the deployment variables of the blueprint that are
+// passed to the module inputs of the same name; deployment_name is always set
+var deploymentVars = []string{"project_id", "region", "zone"}
+
+// toolkitInputs are set by ghpc from the deployment name and labels, so the
+// blueprint leaves them out of the module settings
+var toolkitInputs = []string{"deployment_name", "labels"}
+
+// File is a file of the scaffold, whose path is relative to the module
+type File struct {
+	Path    string
+	Content []byte
+}
+
+// Options select the tests to generate in addition to the blueprint and the
+// `terraform test` file
+type Options struct {
+	// Terratest also generates a Go test with its own Go module
+	Terratest bool
+}
+
+// Generate returns the files of the scaffold of the module at source, whose
+// directory is named name
+func Generate(source string, name string, info modulereader.ModuleInfo, opts Options) ([]File, error) {
+	if name == "" || name == "." || name == "/" {
+		return nil, fmt.Errorf("cannot name the tests of module %s", source)
+	}
+	files := []File{
+		{Path: path.Join(TestsDir, "blueprint.yaml"), Content: blueprint(source, name, info)},
+		{Path: path.Join(TestsDir, name+".tftest.hcl"), Content: terraformTest(source, info)},
+	}
+	if opts.Terratest {
+		test, err := terratest(source, name, info)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files,
+			File{Path: path.Join(TestsDir, "terratest", "go.mod"), Content: terratestModule(name)},
+			File{Path: path.Join(TestsDir, "terratest", identifier(name, "_")+"_test.go"), Content: test})
+	}
+	return files, nil
+}
+
+// requiredInputs returns the inputs without defaults, which the tests set
+func requiredInputs(info modulereader.ModuleInfo) []modulereader.VarInfo {
+	inputs := []modulereader.VarInfo{}
+	for _, in := range info.Inputs {
+		if in.Required {
+			inputs = append(inputs, in)
+		}
+	}
+	return inputs
+}
+
+func hasInput(info modulereader.ModuleInfo, name string) bool {
+	for _, in := range info.Inputs {
+		if in.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholderValue returns a value of the Terraform type that is valid YAML
+// and HCL; strings are set to placeholder
+func placeholderValue(ty string) string {
+	ty = strings.ReplaceAll(ty, " ", "")
+	switch {
+	case ty == "number":
+		return "0"
+	case ty == "bool":
+		return "false"
+	case strings.HasPrefix(ty, "list("), strings.HasPrefix(ty, "set("), strings.HasPrefix(ty, "tuple("):
+		return "[]"
+	case strings.HasPrefix(ty, "map("), strings.HasPrefix(ty, "object("):
+		return "{}"
+	default:
+		return fmt.Sprintf("%q", placeholder)
+	}
+}
+
+// goValue is placeholderValue as a Go literal of the terratest variables
+func goValue(ty string) string {
+	switch v := placeholderValue(ty); v {
+	case "[]":
+		return "[]interface{}{}"
+	case "{}":
+		return "map[string]interface{}{}"
+	default:
+		return v
+	}
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// identifier joins the words of the module name with sep, e.g. vm_instance
+func identifier(name string, sep string) string {
+	return strings.ToLower(strings.Trim(nonIdentifier.ReplaceAllString(name, sep), sep))
+}
+
+// funcName is the name of the terratest function, e.g. TestVmInstance
+func funcName(name string) string {
+	var b strings.Builder
+	b.WriteString("Test")
+	for _, w := range strings.Split(identifier(name, " "), " ") {
+		if w != "" {
+			b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+		}
+	}
+	return b.String()
+}
+
+func blueprint(source string, name string, info modulereader.ModuleInfo) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by ghpc module test-scaffold from %s.\n", source)
+	fmt.Fprintf(&b, "# Replace the %s values, then create the deployment from the directory\n", placeholder)
+	fmt.Fprintf(&b, "# in which the scaffold was generated:\n")
+	fmt.Fprintf(&b, "#   ghpc create %s\n\n", filepath.ToSlash(filepath.Join(source, TestsDir, "blueprint.yaml")))
+	fmt.Fprintf(&b, "blueprint_name: test-%s\n\nvars:\n", identifier(name, "-"))
+	fmt.Fprintf(&b, "  deployment_name: test-%s\n", identifier(name, "-"))
+	for _, v := range deploymentVars {
+		if hasInput(info, v) {
+			fmt.Fprintf(&b, "  %s: %q\n", v, placeholder)
+		}
+	}
+	fmt.Fprintf(&b, "\ndeployment_groups:\n- group: primary\n  modules:\n")
+	fmt.Fprintf(&b, "  - id: %s\n    source: %s\n", identifier(name, "_"), source)
+
+	settings := []modulereader.VarInfo{}
+	for _, in := range requiredInputs(info) {
+		if !slices.Contains(toolkitInputs, in.Name) && !slices.Contains(deploymentVars, in.Name) {
+			settings = append(settings, in)
+		}
+	}
+	if len(settings) > 0 {
+		fmt.Fprintf(&b, "    settings:\n")
+		for _, in := range settings {
+			fmt.Fprintf(&b, "      %s: %s # %s\n", in.Name, placeholderValue(in.Type), in.Type)
+		}
+	}
+	return b.Bytes()
+}
+
+func terraformTest(source string, info modulereader.ModuleInfo) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by ghpc module test-scaffold from %s.\n", source)
+	fmt.Fprintf(&b, "# Replace the %s values, then run `terraform test` in the module directory.\n", placeholder)
+	fmt.Fprintf(&b, "# The apply run creates, and then destroys, the resources of the module.\n\n")
+
+	if inputs := requiredInputs(info); len(inputs) > 0 {
+		fmt.Fprintf(&b, "variables {\n")
+		for _, in := range inputs {
+			fmt.Fprintf(&b, "  %s = %s\n", in.Name, placeholderValue(in.Type))
+		}
+		fmt.Fprintf(&b, "}\n\n")
+	}
+
+	fmt.Fprintf(&b, "run \"plan\" {\n  command = plan\n}\n\n")
+	fmt.Fprintf(&b, "run \"apply\" {\n")
+	for i, out := range info.Outputs {
+		if i > 0 {
+			fmt.Fprintf(&b, "\n")
+		}
+		fmt.Fprintf(&b, "  assert {\n")
+		fmt.Fprintf(&b, "    condition = output.%s != null\n", out.Name)
+		fmt.Fprintf(&b, "    error_message = %q\n", fmt.Sprintf("output %s is null", out.Name))
+		fmt.Fprintf(&b, "  }\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+	return hclwrite.Format(b.Bytes())
+}
+
+func terratestModule(name string) []byte {
+	return []byte(fmt.Sprintf(`// Generated by ghpc module test-scaffold; run "go mod tidy" to add the
+// dependencies of the test before running "go test".
+module %s/test
+
+go 1.18
+`, identifier(name, "-")))
+}
+
+func terratest(source string, name string, info modulereader.ModuleInfo) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Generated by ghpc module test-scaffold from %s.\n\n", source)
+	fmt.Fprintf(&b, "package test\n\n")
+	fmt.Fprintf(&b, "import (\n\"testing\"\n\n\"github.com/gruntwork-io/terratest/modules/terraform\"\n)\n\n")
+	fmt.Fprintf(&b, "// %s applies the module, checks its outputs and destroys it; replace\n", funcName(name))
+	fmt.Fprintf(&b, "// the %s values before running it\n", placeholder)
+	fmt.Fprintf(&b, "func %s(t *testing.T) {\n", funcName(name))
+	fmt.Fprintf(&b, "opts := terraform.WithDefaultRetryableErrors(t, &terraform.Options{\n")
+	fmt.Fprintf(&b, "TerraformDir: %q,\n", "../..")
+	if inputs := requiredInputs(info); len(inputs) > 0 {
+		fmt.Fprintf(&b, "Vars: map[string]interface{}{\n")
+		for _, in := range inputs {
+			fmt.Fprintf(&b, "%q: %s,\n", in.Name, goValue(in.Type))
+		}
+		fmt.Fprintf(&b, "},\n")
+	}
+	fmt.Fprintf(&b, "})\n")
+	fmt.Fprintf(&b, "defer terraform.Destroy(t, opts)\n")
+	fmt.Fprintf(&b, "terraform.InitAndApply(t, opts)\n")
+	for _, out := range info.Outputs {
+		fmt.Fprintf(&b, "\nif v := terraform.OutputJSON(t, opts, %q); v == \"null\" {\n", out.Name)
+		fmt.Fprintf(&b, "t.Errorf(%q)\n}\n", fmt.Sprintf("output %s is null", out.Name))
+	}
+	fmt.Fprintf(&b, "}\n")
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaffold
+
+import (
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"gopkg.in/yaml.v3"
+
+	. "gopkg.in/check.v1"
+)
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var testInfo = modulereader.ModuleInfo{
+	Inputs: []modulereader.VarInfo{
+		{Name: "project_id", Type: "string", Required: true},
+		{Name: "deployment_name", Type: "string", Required: true},
+		{Name: "labels", Type: "map(string)", Required: true},
+		{Name: "zone", Type: "string", Required: true},
+		{Name: "node_count", Type: "number", Required: true},
+		{Name: "subnets", Type: "list(object({ name = string }))", Required: true},
+		{Name: "machine_type", Type: "string", Default: "n2-standard-2"},
+	},
+	Outputs: []modulereader.OutputInfo{{Name: "instance_ids"}},
+}
+
+func (s *MySuite) TestPlaceholderValue(c *C) {
+	c.Check(placeholderValue("string"), Equals, `"CHANGE_ME"`)
+	c.Check(placeholderValue("number"), Equals, "0")
+	c.Check(placeholderValue("bool"), Equals, "false")
+	c.Check(placeholderValue("set(string)"), Equals, "[]")
+	c.Check(placeholderValue("object({ a = string })"), Equals, "{}")
+	c.Check(placeholderValue("any"), Equals, `"CHANGE_ME"`)
+	c.Check(funcName("vm-instance"), Equals, "TestVmInstance")
+	c.Check(identifier("Slurm--node_group", "_"), Equals, "slurm_node_group")
+}
+
+func (s *MySuite) TestGenerate(c *C) {
+	files, err := Generate("./modules/cluster", "cluster", testInfo, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+	c.Check(files[0].Path, Equals, "tests/blueprint.yaml")
+	c.Check(files[1].Path, Equals, "tests/cluster.tftest.hcl")
+
+	var bp struct {
+		Vars             map[string]string
+		DeploymentGroups []struct {
+			Modules []struct {
+				ID       string
+				Source   string
+				Settings map[string]interface{}
+			}
+		} `yaml:"deployment_groups"`
+	}
+	c.Assert(yaml.Unmarshal(files[0].Content, &bp), IsNil)
+	c.Check(bp.Vars, DeepEquals, map[string]string{
+		"deployment_name": "test-cluster", "project_id": "CHANGE_ME", "zone": "CHANGE_ME"})
+	mod := bp.DeploymentGroups[0].Modules[0]
+	c.Check(mod.ID, Equals, "cluster")
+	c.Check(mod.Source, Equals, "./modules/cluster")
+	c.Check(mod.Settings, DeepEquals, map[string]interface{}{"node_count": 0, "subnets": []interface{}{}})
+
+	tftest := string(files[1].Content)
+	c.Check(strings.Contains(tftest, "node_count      = 0\n"), Equals, true)
+	c.Check(strings.Contains(tftest, "machine_type"), Equals, false)
+	c.Check(strings.Contains(tftest, "condition     = output.instance_ids != null"), Equals, true)
+
+	files, err = Generate("./modules/cluster", "cluster", testInfo, Options{Terratest: true})
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 4)
+	c.Check(files[2].Path, Equals, "tests/terratest/go.mod")
+	c.Check(files[3].Path, Equals, "tests/terratest/cluster_test.go")
+	c.Check(strings.Contains(string(files[3].Content), "func TestCluster(t *testing.T) {"), Equals, true)
+
+	_, err = Generate(".", ".", testInfo, Options{})
+	c.Check(err, NotNil)
+}
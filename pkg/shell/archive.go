@@ -0,0 +1,208 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hpc-toolkit/pkg/modulewriter"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+	storage "google.golang.org/api/storage/v1"
+	"gopkg.in/yaml.v3"
+)
+
+const archiveManifestName = "archive.yaml"
+
+// ArchiveManifest records the SHA-256 hashes of the files of an archived
+// deployment, by their slash-separated path relative to the deployment
+// directory, so that restored deployments can be verified
+type ArchiveManifest struct {
+	Archived time.Time         `yaml:"archived"`
+	Files    map[string]string `yaml:"files"`
+}
+
+// WriteDeploymentArchive records the hashes of the files of a deployment
+// directory, including Terraform state, in its archive manifest and writes
+// the directory, without the Terraform working directories of its groups, as
+// a gzipped tarball
+func WriteDeploymentArchive(deploymentRoot string, w io.Writer) error {
+	root := filepath.Clean(deploymentRoot)
+	hashes, err := hashArchivedFiles(root)
+	if err != nil {
+		return fmt.Errorf("failed to hash the files of deployment %s: %w", root, err)
+	}
+	b, err := yaml.Marshal(ArchiveManifest{Archived: time.Now().UTC(), Files: hashes})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(archiveManifest(root), b, 0644); err != nil {
+		return err
+	}
+	return archiveDeployment(root, w, isTerraformDir)
+}
+
+// RestoreDeploymentArchive writes the deployment directory of an archive to
+// dir, which must not exist, and verifies it against the archive manifest
+func RestoreDeploymentArchive(r io.Reader, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+	if err := extractDeployment(r, dir); err != nil {
+		return err
+	}
+	if problems := VerifyArchivedDeployment(dir); len(problems) > 0 {
+		return fmt.Errorf("deployment restored in %s does not match its archive manifest:\n%s", dir, strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// VerifyArchivedDeployment returns the files of a restored deployment that
+// are missing, modified or not recorded in its archive manifest
+func VerifyArchivedDeployment(deploymentRoot string) []string {
+	b, err := os.ReadFile(archiveManifest(deploymentRoot))
+	if err != nil {
+		return []string{fmt.Sprintf("%s was not restored from an archive: %v", deploymentRoot, err)}
+	}
+	var m ArchiveManifest
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return []string{fmt.Sprintf("failed to read archive manifest: %v", err)}
+	}
+	hashes, err := hashArchivedFiles(deploymentRoot)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	problems := []string{}
+	for _, p := range sortedKeys(m.Files) {
+		if h, ok := hashes[p]; !ok {
+			problems = append(problems, fmt.Sprintf("%s is missing", p))
+		} else if h != m.Files[p] {
+			problems = append(problems, fmt.Sprintf("%s was modified", p))
+		}
+	}
+	for _, p := range sortedKeys(hashes) {
+		if _, ok := m.Files[p]; !ok {
+			problems = append(problems, fmt.Sprintf("%s is not in the archive manifest", p))
+		}
+	}
+	return problems
+}
+
+func archiveManifest(deploymentRoot string) string {
+	return filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, archiveManifestName)
+}
+
+// hashArchivedFiles returns the hashes of the files of a deployment that are
+// archived, but for the archive manifest
+func hashArchivedFiles(root string) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if isTerraformDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || p == archiveManifest(root) {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		hashes[rel] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return hashes, err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := maps.Keys(m)
+	sort.Strings(keys)
+	return keys
+}
+
+// IsObjectURL returns whether a location is a Cloud Storage object,
+// gs://BUCKET/OBJECT
+func IsObjectURL(location string) bool {
+	return strings.HasPrefix(location, "gs://")
+}
+
+func splitObjectURL(url string) (string, string, error) {
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(url, "gs://"), "/")
+	if bucket == "" || object == "" || strings.HasSuffix(object, "/") {
+		return "", "", fmt.Errorf("invalid Cloud Storage object %q, must be gs://BUCKET/OBJECT", url)
+	}
+	return bucket, object, nil
+}
+
+// UploadObject writes the content of r to a Cloud Storage object
+func UploadObject(ctx context.Context, url string, r io.Reader) error {
+	bucket, object, err := splitObjectURL(url)
+	if err != nil {
+		return err
+	}
+	s, err := storage.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Storage client: %w", err)
+	}
+	if _, err := s.Objects.Insert(bucket, &storage.Object{Name: object}).Media(r).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", url, err)
+	}
+	return nil
+}
+
+// DownloadObject returns the content of a Cloud Storage object; the caller
+// closes it
+func DownloadObject(ctx context.Context, url string) (io.ReadCloser, error) {
+	bucket, object, err := splitObjectURL(url)
+	if err != nil {
+		return nil, err
+	}
+	s, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Storage client: %w", err)
+	}
+	resp, err := s.Objects.Get(bucket, object).Context(ctx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestDeploymentArchive(c *C) {
+	root := filepath.Join(c.MkDir(), "deployment")
+	files := map[string]string{
+		"primary/main.tf":                   "module {}",
+		"primary/terraform.tfstate":         `{"version": 4}`,
+		".ghpc/artifacts/expanded.yaml":     "blueprint_name: test",
+		"primary/.terraform/providers/lock": "ignored",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(os.WriteFile(p, []byte(content), 0644), IsNil)
+	}
+
+	var buf bytes.Buffer
+	c.Assert(WriteDeploymentArchive(root, &buf), IsNil)
+	archive := buf.Bytes()
+
+	restored := filepath.Join(c.MkDir(), "restored")
+	c.Assert(RestoreDeploymentArchive(bytes.NewReader(archive), restored), IsNil)
+	got, err := os.ReadFile(filepath.Join(restored, "primary", "terraform.tfstate"))
+	c.Assert(err, IsNil)
+	c.Check(string(got), Equals, files["primary/terraform.tfstate"])
+	_, err = os.Stat(filepath.Join(restored, "primary", ".terraform"))
+	c.Check(os.IsNotExist(err), Equals, true)
+	c.Check(VerifyArchivedDeployment(restored), HasLen, 0)
+
+	// deployments are not restored over existing directories
+	c.Check(RestoreDeploymentArchive(bytes.NewReader(archive), restored), ErrorMatches, ".* already exists")
+
+	c.Assert(os.WriteFile(filepath.Join(restored, "primary", "main.tf"), []byte("module {}\n"), 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(restored, "primary", "terraform.tfstate")), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(restored, "primary", "extra.tf"), nil, 0644), IsNil)
+	c.Check(VerifyArchivedDeployment(restored), DeepEquals, []string{
+		"primary/main.tf was modified",
+		"primary/terraform.tfstate is missing",
+		"primary/extra.tf is not in the archive manifest",
+	})
+
+	c.Check(VerifyArchivedDeployment(root+"-missing"), HasLen, 1)
+}
+
+func (s *MySuite) TestSplitObjectURL(c *C) {
+	bucket, object, err := splitObjectURL("gs://archives/hpc/deployment.tgz")
+	c.Assert(err, IsNil)
+	c.Check(bucket, Equals, "archives")
+	c.Check(object, Equals, "hpc/deployment.tgz")
+
+	for _, url := range []string{"gs://archives", "gs://archives/", "gs://archives/hpc/", "gs:///deployment.tgz"} {
+		_, _, err := splitObjectURL(url)
+		c.Check(err, NotNil, Commentf("%s", url))
+	}
+}
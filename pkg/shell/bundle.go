@@ -0,0 +1,232 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	bundleManifestName = "bundle.yaml"
+	tfLockFileName     = ".terraform.lock.hcl"
+	tfModulesManifest  = ".terraform/modules/modules.json"
+)
+
+// Bundle describes a deployment packaged for sites without Internet access
+type Bundle struct {
+	Created time.Time `yaml:"created"`
+	// Platforms are those of the mirrored providers, e.g. linux_amd64
+	Platforms []string `yaml:"platforms"`
+}
+
+// PrepareBundle downloads everything the deployment needs from the Internet
+// into the deployment directory: the modules of Terraform groups, in their
+// .terraform directories, the providers, in the providers mirror, and the
+// packer plugins of packer groups
+func PrepareBundle(deploymentRoot string, groups []config.DeploymentGroup, platforms []string) error {
+	if len(platforms) == 0 {
+		platforms = []string{runtime.GOOS + "_" + runtime.GOARCH}
+	}
+	if err := ConfigureProviderInstallation(deploymentRoot); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(PackerPluginsDir(deploymentRoot), 0755); err != nil {
+		return err
+	}
+	if err := ConfigurePackerPlugins(deploymentRoot); err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		groupDir := filepath.Join(deploymentRoot, string(g.Name))
+		switch g.Kind {
+		case config.TerraformKind:
+			tf, err := ConfigureTerraform(groupDir)
+			if err != nil {
+				return err
+			}
+			// init installs the modules and writes the dependency lock file
+			if err := tf.Init(context.Background(), tfexec.Backend(false)); err != nil {
+				return &TfError{
+					help: fmt.Sprintf("initialization of %s failed; manually resolve errors below", groupDir),
+					err:  err,
+				}
+			}
+			if err := MirrorProviders(tf, ProvidersMirrorDir(deploymentRoot), platforms); err != nil {
+				return err
+			}
+		case config.PackerKind:
+			if err := ConfigurePacker(); err != nil {
+				return err
+			}
+			moduleDir := filepath.Join(groupDir, string(g.PackerModule().ID))
+			if err := ExecPackerCmd(moduleDir, true, "init", "."); err != nil {
+				return fmt.Errorf("failed to install the packer plugins of %s: %w", moduleDir, err)
+			}
+		}
+	}
+
+	b, err := yaml.Marshal(Bundle{Created: time.Now().UTC(), Platforms: platforms})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(bundleManifest(deploymentRoot), b, 0644)
+}
+
+// WriteBundle writes the deployment directory, prepared by PrepareBundle, as
+// a gzipped tarball. Installed providers are left out in favor of the mirror.
+func WriteBundle(deploymentRoot string, w io.Writer) error {
+	return archiveDeployment(filepath.Clean(deploymentRoot), w, func(rel string) bool {
+		return path.Base(rel) == "providers" && path.Base(path.Dir(rel)) == ".terraform"
+	})
+}
+
+// ExtractBundle writes the deployment directory of a bundle to dir
+func ExtractBundle(r io.Reader, dir string) error {
+	return extractDeployment(r, dir)
+}
+
+// VerifyBundle returns what the deployment is missing to be deployed without
+// Internet access
+func VerifyBundle(deploymentRoot string, groups []config.DeploymentGroup) []string {
+	b, err := os.ReadFile(bundleManifest(deploymentRoot))
+	if err != nil {
+		return []string{fmt.Sprintf("%s is not a bundle: %v", deploymentRoot, err)}
+	}
+	var bundle Bundle
+	if err := yaml.Unmarshal(b, &bundle); err != nil {
+		return []string{fmt.Sprintf("failed to read bundle manifest: %v", err)}
+	}
+
+	problems := []string{}
+	for _, g := range groups {
+		groupDir := filepath.Join(deploymentRoot, string(g.Name))
+		switch g.Kind {
+		case config.TerraformKind:
+			problems = append(problems, missingModules(groupDir)...)
+			problems = append(problems, missingProviders(groupDir, ProvidersMirrorDir(deploymentRoot), bundle.Platforms)...)
+		case config.PackerKind:
+			if entries, _ := os.ReadDir(PackerPluginsDir(deploymentRoot)); len(entries) == 0 {
+				problems = append(problems, fmt.Sprintf("packer plugins of group %s are not installed in %s", g.Name, PackerPluginsDir(deploymentRoot)))
+			}
+		}
+	}
+	return problems
+}
+
+func bundleManifest(deploymentRoot string) string {
+	return filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, bundleManifestName)
+}
+
+// missingModules reports the modules of a Terraform group that init has not
+// installed
+func missingModules(groupDir string) []string {
+	b, err := os.ReadFile(filepath.Join(groupDir, tfModulesManifest))
+	if err != nil {
+		return []string{fmt.Sprintf("modules of %s are not installed: %v", groupDir, err)}
+	}
+	var manifest struct {
+		Modules []struct {
+			Key    string
+			Source string
+			Dir    string
+		}
+	}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return []string{fmt.Sprintf("failed to read %s: %v", filepath.Join(groupDir, tfModulesManifest), err)}
+	}
+
+	problems := []string{}
+	for _, m := range manifest.Modules {
+		if isDir, _ := DirInfo(filepath.Join(groupDir, m.Dir)); !isDir {
+			problems = append(problems, fmt.Sprintf("module %s of %s, from %s, is missing from %s", m.Key, groupDir, m.Source, m.Dir))
+		}
+	}
+	return problems
+}
+
+// missingProviders reports the providers locked by a Terraform group that
+// are not in the mirror for every platform
+func missingProviders(groupDir string, mirror string, platforms []string) []string {
+	locked, err := lockedProviders(filepath.Join(groupDir, tfLockFileName))
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	problems := []string{}
+	for addr, version := range locked {
+		for _, p := range platforms {
+			pkg := fmt.Sprintf("terraform-provider-%s_%s_%s.zip", path.Base(addr), version, p)
+			if _, err := os.Stat(filepath.Join(mirror, filepath.FromSlash(addr), pkg)); errors.Is(err, os.ErrNotExist) {
+				problems = append(problems, fmt.Sprintf("provider %s %s for %s, used by %s, is missing from %s", addr, version, p, groupDir, mirror))
+			}
+		}
+	}
+	return problems
+}
+
+// lockedProviders returns the versions of the providers in a dependency lock
+// file, by provider address
+func lockedProviders(lockFile string) (map[string]string, error) {
+	b, err := os.ReadFile(lockFile)
+	if err != nil {
+		return nil, fmt.Errorf("providers are not locked: %w", err)
+	}
+	f, diags := hclsyntax.ParseConfig(b, lockFile, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	locked := map[string]string{}
+	for _, block := range f.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "provider" || len(block.Labels) != 1 {
+			continue
+		}
+		attr, ok := block.Body.Attributes["version"]
+		if !ok {
+			return nil, fmt.Errorf("%s: provider %s has no version", lockFile, block.Labels[0])
+		}
+		v, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		locked[block.Labels[0]] = v.AsString()
+	}
+	return locked, nil
+}
+
+// WriteArchive writes a deployment directory, without the Terraform working
+// directories of its groups, as a gzipped tarball
+func WriteArchive(deploymentRoot string, w io.Writer) error {
+	return archiveDeployment(filepath.Clean(deploymentRoot), w, isTerraformDir)
+}
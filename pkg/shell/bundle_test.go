@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"bytes"
+	"hpc-toolkit/pkg/config"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+const testLockFile = `provider "registry.terraform.io/hashicorp/google" {
+  version     = "4.69.1"
+  constraints = ">= 3.83.0"
+  hashes = [
+    "h1:abc=",
+  ]
+}
+`
+
+func writeTestFiles(c *C, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(os.WriteFile(p, []byte(content), 0644), IsNil)
+	}
+}
+
+func (s *MySuite) TestVerifyBundle(c *C) {
+	root := filepath.Join(c.MkDir(), "deployment")
+	groups := []config.DeploymentGroup{{Name: "primary", Kind: config.TerraformKind}}
+	writeTestFiles(c, root, map[string]string{
+		"primary/.terraform.lock.hcl": testLockFile,
+		"primary/" + tfModulesManifest: `{"Modules":[{"Key":"","Source":"","Dir":"."},` +
+			`{"Key":"network","Source":"github.com/example/network","Dir":".terraform/modules/network"}]}`,
+		"primary/.terraform/modules/network/main.tf": "",
+	})
+
+	c.Check(VerifyBundle(root, groups), DeepEquals, []string{
+		root + " is not a bundle: open " + bundleManifest(root) + ": no such file or directory"})
+
+	writeTestFiles(c, root, map[string]string{
+		".ghpc/" + bundleManifestName: "platforms: [linux_amd64]\n",
+	})
+	problems := VerifyBundle(root, groups)
+	c.Assert(problems, HasLen, 1)
+	c.Check(problems[0], Matches, "provider registry.terraform.io/hashicorp/google 4.69.1 for linux_amd64, used by .*, is missing from .*")
+
+	writeTestFiles(c, root, map[string]string{
+		".ghpc/providers/registry.terraform.io/hashicorp/google/terraform-provider-google_4.69.1_linux_amd64.zip": "",
+	})
+	c.Check(VerifyBundle(root, groups), HasLen, 0)
+
+	c.Assert(os.RemoveAll(filepath.Join(root, "primary/.terraform/modules/network")), IsNil)
+	problems = VerifyBundle(root, groups)
+	c.Assert(problems, HasLen, 1)
+	c.Check(problems[0], Matches, "module network of .*, from github.com/example/network, is missing from .terraform/modules/network")
+}
+
+func (s *MySuite) TestLockedProviders(c *C) {
+	lock := filepath.Join(c.MkDir(), tfLockFileName)
+	c.Assert(os.WriteFile(lock, []byte(testLockFile), 0644), IsNil)
+	got, err := lockedProviders(lock)
+	c.Assert(err, IsNil)
+	c.Check(got, DeepEquals, map[string]string{"registry.terraform.io/hashicorp/google": "4.69.1"})
+}
+
+func (s *MySuite) TestWriteBundle(c *C) {
+	root := filepath.Join(c.MkDir(), "deployment")
+	writeTestFiles(c, root, map[string]string{
+		"primary/.terraform/modules/network/main.tf":       "module",
+		"primary/.terraform/providers/google/provider":     "binary",
+		".ghpc/providers/registry.terraform.io/index.json": "{}",
+	})
+
+	var buf bytes.Buffer
+	c.Assert(WriteBundle(root, &buf), IsNil)
+	restored := c.MkDir()
+	c.Assert(ExtractBundle(&buf, restored), IsNil)
+
+	_, err := os.Stat(filepath.Join(restored, "primary/.terraform/modules/network/main.tf"))
+	c.Check(err, IsNil)
+	_, err = os.Stat(filepath.Join(restored, ".ghpc/providers/registry.terraform.io/index.json"))
+	c.Check(err, IsNil)
+	_, err = os.Stat(filepath.Join(restored, "primary/.terraform/providers/google/provider"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,156 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/exp/slices"
+)
+
+// Artifact is a file or directory of a deployment directory that is
+// regenerated on demand, by ghpc deploy, terraform init or packer
+type Artifact struct {
+	// Path is slash-separated and relative to the deployment directory
+	Path string
+	Size int64
+}
+
+// cachedDirs are the working directories of Terraform, with the installed
+// modules and providers, and the download cache of packer
+var cachedDirs = []string{".terraform", "packer_cache"}
+
+// keptFiles are the files of cached directories that are not artifacts;
+// .terraform/environment names the selected Terraform workspace, which
+// terraform init does not restore
+var keptFiles = map[string][]string{".terraform": {"environment"}}
+
+// planFiles match the names of saved Terraform plans
+var planFiles = []string{"*.tfplan", "tfplan", "*.plan"}
+
+// stateFiles match the names of Terraform state and its backups, which are
+// never artifacts
+var stateFiles = []string{"*.tfstate", "*.tfstate.*"}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DeploymentArtifacts returns the Terraform working directories, packer caches
+// and saved plans of a deployment directory. The .terraform directories only
+// hold the backend configuration, never the state of the deployment, which
+// Terraform keeps beside them or in its remote backend. A directory that holds
+// one of its keptFiles, such as the selected workspace, is not returned;
+// its other entries are.
+func DeploymentArtifacts(deploymentRoot string) ([]Artifact, error) {
+	root := filepath.Clean(deploymentRoot)
+	artifacts := []Artifact{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		name := d.Name()
+		switch {
+		case d.IsDir() && matchesAny(name, cachedDirs):
+			entries, err := cachedEntries(p, keptFiles[name])
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				size, err := dirSize(filepath.Join(p, e))
+				if err != nil {
+					return err
+				}
+				artifacts = append(artifacts, Artifact{Path: path.Join(rel, e), Size: size})
+			}
+			return filepath.SkipDir
+		case d.Type().IsRegular() && matchesAny(name, planFiles) && !matchesAny(name, stateFiles):
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			artifacts = append(artifacts, Artifact{Path: rel, Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the artifacts of deployment %s: %w", root, err)
+	}
+	return artifacts, nil
+}
+
+// RemoveArtifacts removes artifacts listed by DeploymentArtifacts
+func RemoveArtifacts(deploymentRoot string, artifacts []Artifact) error {
+	for _, a := range artifacts {
+		if err := os.RemoveAll(filepath.Join(deploymentRoot, filepath.FromSlash(a.Path))); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", a.Path, err)
+		}
+	}
+	return nil
+}
+
+// cachedEntries returns "." for a cached directory that holds none of the
+// kept files, else the names of its other entries
+func cachedEntries(dir string, kept []string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	keeps := false
+	for _, e := range entries {
+		if slices.Contains(kept, e.Name()) {
+			keeps = true
+		} else {
+			names = append(names, e.Name())
+		}
+	}
+	if !keeps {
+		return []string{"."}, nil
+	}
+	return names, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestDeploymentArtifacts(c *C) {
+	root := filepath.Join(c.MkDir(), "deployment")
+	files := map[string]string{
+		"primary/main.tf":                        "module {}",
+		"primary/terraform.tfstate":              `{"version": 4}`,
+		"primary/terraform.tfstate.backup":       `{"version": 4}`,
+		"primary/.terraform/terraform.tfstate":   `{"backend": {}}`,
+		"primary/.terraform/modules/vpc/main.tf": "resource {}",
+		"primary/destroy.tfplan":                 "plan",
+		"secondary/.terraform/environment":       "blue",
+		"secondary/.terraform/providers/google":  "binary",
+		"secondary/.terraform/terraform.tfstate": `{}`,
+		"image/builder/packer_cache/port":        "8080",
+		"image/builder/image.pkr.hcl":            "source {}",
+		".ghpc/artifacts/expanded.yaml":          "blueprint_name: test",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(os.WriteFile(p, []byte(content), 0644), IsNil)
+	}
+
+	artifacts, err := DeploymentArtifacts(root)
+	c.Assert(err, IsNil)
+	c.Check(artifacts, DeepEquals, []Artifact{
+		{Path: "image/builder/packer_cache", Size: 4},
+		{Path: "primary/.terraform", Size: 26},
+		{Path: "primary/destroy.tfplan", Size: 4},
+		{Path: "secondary/.terraform/providers", Size: 6},
+		{Path: "secondary/.terraform/terraform.tfstate", Size: 2},
+	})
+
+	c.Assert(RemoveArtifacts(root, artifacts), IsNil)
+	for _, name := range []string{"primary/main.tf", "primary/terraform.tfstate", "primary/terraform.tfstate.backup",
+		"image/builder/image.pkr.hcl", ".ghpc/artifacts/expanded.yaml", "secondary/.terraform/environment"} {
+		_, err := os.Stat(filepath.Join(root, name))
+		c.Check(err, IsNil)
+	}
+	for _, a := range artifacts {
+		_, err := os.Stat(filepath.Join(root, a.Path))
+		c.Check(os.IsNotExist(err), Equals, true)
+	}
+}
@@ -0,0 +1,278 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"golang.org/x/oauth2/google"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	oauth2 "google.golang.org/api/oauth2/v2"
+	"google.golang.org/api/option"
+)
+
+// DiagnosticStatus is the outcome of a check of the environment
+type DiagnosticStatus int
+
+// warnings do not prevent deployments, but may cause some of them to fail
+const (
+	DiagnosticOK DiagnosticStatus = iota
+	DiagnosticWarning
+	DiagnosticFailure
+)
+
+func (s DiagnosticStatus) String() string {
+	switch s {
+	case DiagnosticOK:
+		return "OK"
+	case DiagnosticWarning:
+		return "WARN"
+	default:
+		return "FAIL"
+	}
+}
+
+// Diagnostic is the result of a check of the environment, with the action
+// that fixes it if it did not pass
+type Diagnostic struct {
+	Check  string
+	Status DiagnosticStatus
+	Detail string
+	Fix    string
+}
+
+// toolRequirement is a command that ghpc runs and the versions it supports;
+// optional tools are only needed by some deployments
+type toolRequirement struct {
+	name       string
+	constraint string
+	optional   bool
+	fix        string
+}
+
+var toolRequirements = []toolRequirement{
+	{
+		name:       "terraform",
+		constraint: ">= 1.2",
+		fix:        "install Terraform 1.2 or later: https://developer.hashicorp.com/terraform/downloads",
+	},
+	{
+		name:       "packer",
+		constraint: ">= 1.7.9",
+		optional:   true,
+		fix:        "install Packer 1.7.9 or later to build images: https://developer.hashicorp.com/packer/downloads",
+	},
+	{
+		name:     "gcloud",
+		optional: true,
+		fix:      "install the Google Cloud CLI to log in and run the commands printed by ghpc: https://cloud.google.com/sdk/docs/install",
+	},
+}
+
+// endpoints that deployments reach to call Google Cloud APIs and download
+// Terraform providers, Packer plugins and modules
+var requiredEndpoints = []string{
+	"oauth2.googleapis.com",
+	"compute.googleapis.com",
+	"storage.googleapis.com",
+	"cloudresourcemanager.googleapis.com",
+	"serviceusage.googleapis.com",
+	"registry.terraform.io",
+	"releases.hashicorp.com",
+	"github.com",
+}
+
+const defaultSAGrantsConstraint = "constraints/iam.automaticIamGrantsForDefaultServiceAccounts"
+
+var toolVersionExp = regexp.MustCompile(`v?(\d+\.\d+\.\d+)`)
+
+// Diagnose checks the environment of ghpc in one pass. The organization
+// policies of project are checked if it is not empty.
+func Diagnose(project string) []Diagnostic {
+	ctx := context.Background()
+	diags := []Diagnostic{}
+	for _, t := range toolRequirements {
+		diags = append(diags, checkTool(t))
+	}
+	creds := checkCredentials(ctx)
+	diags = append(diags, creds)
+	if creds.Status == DiagnosticOK {
+		diags = append(diags, checkDefaultServiceAccount(ctx, project))
+	}
+	return append(diags, checkEndpoints(requiredEndpoints, 5*time.Second)...)
+}
+
+func checkTool(t toolRequirement) Diagnostic {
+	d := Diagnostic{Check: t.name, Fix: t.fix}
+	notOK := DiagnosticFailure
+	if t.optional {
+		notOK = DiagnosticWarning
+	}
+	path, err := exec.LookPath(t.name)
+	if err != nil {
+		d.Status, d.Detail = notOK, "not found in PATH"
+		return d
+	}
+	out, err := exec.Command(path, "version").Output()
+	if err != nil {
+		d.Status, d.Detail = notOK, fmt.Sprintf("%s version failed: %v", path, err)
+		return d
+	}
+	v, err := parseToolVersion(string(out))
+	if err != nil {
+		d.Status, d.Detail = notOK, fmt.Sprintf("%s: %v", path, err)
+		return d
+	}
+	d.Detail = fmt.Sprintf("%s %s", path, v)
+	if t.constraint != "" {
+		c, err := version.NewConstraint(t.constraint)
+		if err != nil {
+			panic(err)
+		}
+		if !c.Check(v) {
+			d.Status = notOK
+			d.Detail = fmt.Sprintf("%s is not supported (%s required)", d.Detail, t.constraint)
+			return d
+		}
+	}
+	d.Status, d.Fix = DiagnosticOK, ""
+	return d
+}
+
+// parseToolVersion reads the first version printed by the version command of
+// a tool, e.g. "Terraform v1.5.2"
+func parseToolVersion(out string) (*version.Version, error) {
+	match := toolVersionExp.FindStringSubmatch(out)
+	if match == nil {
+		return nil, fmt.Errorf("could not find a version in %q", strings.TrimSpace(out))
+	}
+	return version.NewVersion(match[1])
+}
+
+// checkCredentials confirms that Application Default Credentials, which
+// ghpc, Terraform and Packer use, exist and can be refreshed
+func checkCredentials(ctx context.Context) Diagnostic {
+	d := Diagnostic{
+		Check: "application default credentials",
+		Fix:   "run \"gcloud auth application-default login\"",
+	}
+	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		d.Status, d.Detail = DiagnosticFailure, "not found"
+		return d
+	}
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		d.Status, d.Detail = DiagnosticFailure, fmt.Sprintf("could not be refreshed: %v", err)
+		return d
+	}
+	d.Status, d.Detail, d.Fix = DiagnosticOK, "identity "+credentialsIdentity(ctx, creds.JSON, token.AccessToken), ""
+	return d
+}
+
+// credentialsIdentity returns the email of the credentials, read from the
+// service account key or asked to the token info endpoint
+func credentialsIdentity(ctx context.Context, credsJSON []byte, accessToken string) string {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if err := json.Unmarshal(credsJSON, &key); err == nil && key.ClientEmail != "" {
+		return key.ClientEmail
+	}
+	s, err := oauth2.NewService(ctx, option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		return "unknown"
+	}
+	info, err := s.Tokeninfo().AccessToken(accessToken).Context(ctx).Do()
+	if err != nil || info.Email == "" {
+		return "unknown"
+	}
+	return info.Email
+}
+
+// checkDefaultServiceAccount warns if an organization policy prevents the
+// default service accounts of the project from being granted roles, in which
+// case modules that run VMs as the default compute service account cannot
+// reach other services
+func checkDefaultServiceAccount(ctx context.Context, project string) Diagnostic {
+	d := Diagnostic{Check: "default service account policy"}
+	if project == "" {
+		d.Status, d.Detail = DiagnosticWarning, "not checked"
+		d.Fix = "pass --project to check the organization policies of a project"
+		return d
+	}
+	s, err := cloudresourcemanager.NewService(ctx)
+	if err != nil {
+		d.Status, d.Detail = DiagnosticWarning, err.Error()
+		return d
+	}
+	req := &cloudresourcemanager.GetEffectiveOrgPolicyRequest{Constraint: defaultSAGrantsConstraint}
+	policy, err := s.Projects.GetEffectiveOrgPolicy("projects/"+project, req).Context(ctx).Do()
+	if err != nil {
+		d.Status = DiagnosticWarning
+		d.Detail = fmt.Sprintf("could not read %s of project %s: %v", defaultSAGrantsConstraint, project, err)
+		d.Fix = "confirm that the project exists and that you may read its organization policies"
+		return d
+	}
+	if policy.BooleanPolicy != nil && policy.BooleanPolicy.Enforced {
+		d.Status = DiagnosticWarning
+		d.Detail = fmt.Sprintf("%s is enforced in project %s, so the default compute service account has no roles", defaultSAGrantsConstraint, project)
+		d.Fix = "grant the default compute service account the roles its VMs need, or set service_account in the modules that create VMs"
+		return d
+	}
+	d.Status, d.Detail = DiagnosticOK, fmt.Sprintf("%s is not enforced in project %s", defaultSAGrantsConstraint, project)
+	return d
+}
+
+// checkEndpoints confirms that a TCP connection can be opened to port 443 of
+// every host
+func checkEndpoints(hosts []string, timeout time.Duration) []Diagnostic {
+	diags := make([]Diagnostic, len(hosts))
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h string) {
+			defer wg.Done()
+			diags[i] = checkEndpoint(net.JoinHostPort(h, "443"), timeout)
+		}(i, h)
+	}
+	wg.Wait()
+	return diags
+}
+
+func checkEndpoint(addr string, timeout time.Duration) Diagnostic {
+	d := Diagnostic{Check: "reach " + addr}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		d.Status, d.Detail = DiagnosticFailure, err.Error()
+		d.Fix = "allow connections to " + addr + ", e.g. through a proxy or Private Google Access, or deploy from a bundle (ghpc bundle)"
+		return d
+	}
+	conn.Close()
+	d.Status, d.Detail = DiagnosticOK, "reachable"
+	return d
+}
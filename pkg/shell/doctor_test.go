@@ -0,0 +1,77 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestParseToolVersion(c *C) {
+	v, err := parseToolVersion("Terraform v1.5.2\non linux_amd64\n")
+	c.Assert(err, IsNil)
+	c.Check(v.String(), Equals, "1.5.2")
+
+	v, err = parseToolVersion("Google Cloud SDK 440.0.0\nbq 2.0.95\n")
+	c.Assert(err, IsNil)
+	c.Check(v.String(), Equals, "440.0.0")
+
+	_, err = parseToolVersion("unknown command")
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestCheckTool(c *C) {
+	dir := c.MkDir()
+	script := filepath.Join(dir, "faketool")
+	pathEnv := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", pathEnv)
+
+	req := toolRequirement{name: "faketool", constraint: ">= 1.2", fix: "install faketool"}
+	d := checkTool(req)
+	c.Check(d.Status, Equals, DiagnosticFailure)
+	c.Check(d.Fix, Equals, "install faketool")
+
+	req.optional = true
+	c.Check(checkTool(req).Status, Equals, DiagnosticWarning)
+
+	c.Assert(os.WriteFile(script, []byte("#!/bin/sh\necho Faketool v1.1.9\n"), 0755), IsNil)
+	d = checkTool(req)
+	c.Check(d.Status, Equals, DiagnosticWarning)
+	c.Check(d.Detail, Matches, ".*1.1.9 is not supported.*")
+
+	c.Assert(os.WriteFile(script, []byte("#!/bin/sh\necho Faketool v1.5.0\n"), 0755), IsNil)
+	d = checkTool(req)
+	c.Check(d.Status, Equals, DiagnosticOK)
+	c.Check(d.Fix, Equals, "")
+}
+
+func (s *MySuite) TestCheckEndpoint(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	addr := l.Addr().String()
+	c.Check(checkEndpoint(addr, time.Second).Status, Equals, DiagnosticOK)
+
+	l.Close()
+	d := checkEndpoint(addr, time.Second)
+	c.Check(d.Status, Equals, DiagnosticFailure)
+	c.Check(d.Fix, Not(Equals), "")
+}
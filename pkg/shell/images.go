@@ -0,0 +1,228 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const defaultPackerManifest = "packer-manifest.json"
+
+// BuiltImage is an image built by a Packer module of the deployment, as
+// recorded in the manifest written by packer
+type BuiltImage struct {
+	Group    config.GroupName
+	Module   config.ModuleID
+	Name     string
+	Family   string
+	Project  string
+	Built    time.Time
+	manifest string
+}
+
+// packerBuild holds the fields of a build recorded by the manifest
+// post-processor that are read; other fields are kept when the manifest is
+// rewritten
+type packerBuild struct {
+	ArtifactID string            `json:"artifact_id"`
+	BuildTime  int64             `json:"build_time"`
+	CustomData map[string]string `json:"custom_data"`
+}
+
+// PackerManifest returns the manifest of the images built by a Packer module
+func PackerManifest(deploymentRoot string, g config.DeploymentGroup, m config.Module) string {
+	name := defaultPackerManifest
+	if v := m.Settings.Get("manifest_file"); v.Type() == cty.String && v.IsKnown() && !v.IsNull() {
+		name = v.AsString()
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(deploymentRoot, string(g.Name), string(m.ID), name)
+}
+
+// BuiltImages returns the images built by the Packer groups of the deployment,
+// newest first
+func BuiltImages(deploymentRoot string, groups []config.DeploymentGroup) ([]BuiltImage, error) {
+	images := []BuiltImage{}
+	for _, g := range groups {
+		if g.Kind != config.PackerKind {
+			continue
+		}
+		m := g.PackerModule()
+		manifest := PackerManifest(deploymentRoot, g, m)
+		builds, err := readPackerBuilds(manifest)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range builds {
+			images = append(images, BuiltImage{
+				Group:    g.Name,
+				Module:   m.ID,
+				Name:     imageName(b.ArtifactID),
+				Family:   b.CustomData["image-family"],
+				Project:  b.CustomData["project-id"],
+				Built:    time.Unix(b.BuildTime, 0).UTC(),
+				manifest: manifest,
+			})
+		}
+	}
+	slices.SortStableFunc(images, func(a, b BuiltImage) bool { return a.Built.After(b.Built) })
+	return images, nil
+}
+
+// imageName strips the project that prefixes some artifact IDs
+func imageName(artifactID string) string {
+	if i := strings.LastIndex(artifactID, ":"); i != -1 {
+		return artifactID[i+1:]
+	}
+	return artifactID
+}
+
+func readPackerBuilds(manifest string) ([]packerBuild, error) {
+	b, err := os.ReadFile(manifest)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m struct {
+		Builds []packerBuild `json:"builds"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to read packer manifest %s: %w", manifest, err)
+	}
+	return m.Builds, nil
+}
+
+// CheckBuiltImageFamily errors if the newest image recorded in the manifest of
+// a Packer module is not of the family that the modules using its image were
+// given when the blueprint was expanded. Manifests without builds or that do
+// not record families, as written by templates other than custom-image, are
+// not checked.
+func CheckBuiltImageFamily(deploymentRoot string, g config.DeploymentGroup, m config.Module, family string) error {
+	manifest := PackerManifest(deploymentRoot, g, m)
+	builds, err := readPackerBuilds(manifest)
+	if err != nil || len(builds) == 0 {
+		return err
+	}
+	newest := builds[0]
+	for _, b := range builds[1:] {
+		if b.BuildTime > newest.BuildTime {
+			newest = b
+		}
+	}
+	if built := newest.CustomData["image-family"]; built != "" && built != family {
+		return fmt.Errorf("module %s built image %s of family %s according to %s, but the deployment uses images of family %s",
+			m.ID, imageName(newest.ArtifactID), built, manifest, family)
+	}
+	return nil
+}
+
+// ImagesToPrune returns all but the newest keep images built by each module
+func ImagesToPrune(images []BuiltImage, keep int) []BuiltImage {
+	kept := map[config.ModuleID]int{}
+	prune := []BuiltImage{}
+	for _, img := range images {
+		if kept[img.Module] < keep {
+			kept[img.Module]++
+			continue
+		}
+		prune = append(prune, img)
+	}
+	return prune
+}
+
+// DeleteImages deletes the images from their projects and removes them from
+// the packer manifests; images that no longer exist are only removed from
+// the manifests
+func DeleteImages(images []BuiltImage) error {
+	ctx := context.Background()
+	s, err := compute.NewService(ctx)
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		if img.Project == "" {
+			log.Printf("skipping image %s of module %s, whose project is not recorded in %s", img.Name, img.Module, img.manifest)
+			continue
+		}
+		log.Printf("deleting image %s from project %s", img.Name, img.Project)
+		op, err := s.Images.Delete(img.Project, img.Name).Context(ctx).Do()
+		var herr *googleapi.Error
+		if errors.As(err, &herr) && herr.Code == 404 {
+			log.Printf("image %s no longer exists", img.Name)
+		} else if err != nil {
+			return fmt.Errorf("failed to delete image %s from project %s: %w", img.Name, img.Project, err)
+		} else if _, err := s.GlobalOperations.Wait(img.Project, op.Name).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to wait for deletion of image %s: %w", img.Name, err)
+		}
+		if err := forgetImage(img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetImage removes the builds of an image from its packer manifest
+func forgetImage(img BuiltImage) error {
+	b, err := os.ReadFile(img.manifest)
+	if err != nil {
+		return err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	var builds []json.RawMessage
+	if err := json.Unmarshal(m["builds"], &builds); err != nil {
+		return err
+	}
+	kept := []json.RawMessage{}
+	for _, raw := range builds {
+		var build packerBuild
+		if err := json.Unmarshal(raw, &build); err != nil {
+			return err
+		}
+		if imageName(build.ArtifactID) != img.Name {
+			kept = append(kept, raw)
+		}
+	}
+	if m["builds"], err = json.Marshal(kept); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(img.manifest, out, 0644)
+}
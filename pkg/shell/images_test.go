@@ -0,0 +1,108 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"hpc-toolkit/pkg/config"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const testPackerManifest = `{
+  "builds": [
+    {
+      "name": "hpc",
+      "builder_type": "googlecompute",
+      "build_time": 1690000000,
+      "artifact_id": "hpc-20230722t043320z",
+      "packer_run_uuid": "a",
+      "custom_data": {"built-by": "cloud-hpc-toolkit", "image-family": "hpc", "project-id": "hpc-project"}
+    },
+    {
+      "name": "hpc",
+      "builder_type": "googlecompute",
+      "build_time": 1690100000,
+      "artifact_id": "hpc-20230723t082000z",
+      "packer_run_uuid": "b",
+      "custom_data": {"built-by": "cloud-hpc-toolkit", "image-family": "hpc", "project-id": "hpc-project"}
+    }
+  ],
+  "last_run_uuid": "b"
+}`
+
+func (s *MySuite) TestBuiltImages(c *C) {
+	root := c.MkDir()
+	groups := []config.DeploymentGroup{
+		{Name: "primary", Kind: config.TerraformKind},
+		{Name: "packer", Kind: config.PackerKind, Modules: []config.Module{{ID: "image"}}},
+	}
+	manifest := PackerManifest(root, groups[1], groups[1].Modules[0])
+	c.Check(manifest, Equals, filepath.Join(root, "packer", "image", "packer-manifest.json"))
+
+	images, err := BuiltImages(root, groups)
+	c.Assert(err, IsNil)
+	c.Check(images, HasLen, 0)
+
+	c.Assert(os.MkdirAll(filepath.Dir(manifest), 0755), IsNil)
+	c.Assert(os.WriteFile(manifest, []byte(testPackerManifest), 0644), IsNil)
+	images, err = BuiltImages(root, groups)
+	c.Assert(err, IsNil)
+	c.Assert(images, HasLen, 2)
+	c.Check(images[0], DeepEquals, BuiltImage{
+		Group:    "packer",
+		Module:   "image",
+		Name:     "hpc-20230723t082000z",
+		Family:   "hpc",
+		Project:  "hpc-project",
+		Built:    time.Unix(1690100000, 0).UTC(),
+		manifest: manifest,
+	})
+
+	prune := ImagesToPrune(images, 1)
+	c.Assert(prune, HasLen, 1)
+	c.Check(prune[0].Name, Equals, "hpc-20230722t043320z")
+	c.Check(ImagesToPrune(images, 2), HasLen, 0)
+
+	c.Assert(forgetImage(prune[0]), IsNil)
+	images, err = BuiltImages(root, groups)
+	c.Assert(err, IsNil)
+	c.Assert(images, HasLen, 1)
+	c.Check(images[0].Name, Equals, "hpc-20230723t082000z")
+}
+
+func (s *MySuite) TestCheckBuiltImageFamily(c *C) {
+	root := c.MkDir()
+	g := config.DeploymentGroup{Name: "packer", Kind: config.PackerKind, Modules: []config.Module{{ID: "image"}}}
+	m := g.Modules[0]
+	// nothing was built yet
+	c.Check(CheckBuiltImageFamily(root, g, m, "hpc"), IsNil)
+
+	manifest := PackerManifest(root, g, m)
+	c.Assert(os.MkdirAll(filepath.Dir(manifest), 0755), IsNil)
+	c.Assert(os.WriteFile(manifest, []byte(testPackerManifest), 0644), IsNil)
+	c.Check(CheckBuiltImageFamily(root, g, m, "hpc"), IsNil)
+	c.Check(CheckBuiltImageFamily(root, g, m, "golden"), ErrorMatches,
+		"module image built image hpc-20230723t082000z of family hpc according to .*, but the deployment uses images of family golden")
+}
+
+func (s *MySuite) TestImageName(c *C) {
+	c.Check(imageName("hpc-20230723t082000z"), Equals, "hpc-20230723t082000z")
+	c.Check(imageName("hpc-project:hpc-20230723t082000z"), Equals, "hpc-20230723t082000z")
+}
@@ -0,0 +1,412 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
+	"golang.org/x/exp/slices"
+	cloudasset "google.golang.org/api/cloudasset/v1"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Owners of the resources of an inventory
+const (
+	// TerraformOwner resources are recorded in the Terraform state of a group
+	TerraformOwner = "terraform"
+	// PackerOwner resources are images recorded in a Packer manifest
+	PackerOwner = "packer"
+	// InstanceGroupOwner resources are instances that a managed instance
+	// group created, and recreates if they are deleted
+	InstanceGroupOwner = "instance-group"
+	// InstanceTemplateOwner resources are instances created from an instance
+	// template other than by a Slurm controller, e.g. by a service that
+	// manages them
+	InstanceTemplateOwner = "instance-template"
+	// Orphaned resources are labeled with the deployment but recorded
+	// nowhere, e.g. Slurm nodes created on demand or images whose builds
+	// were removed from their manifests
+	Orphaned = "orphaned"
+)
+
+// InventoryResource is a resource labeled with the name of a deployment, as
+// found by Cloud Asset Inventory
+type InventoryResource struct {
+	// Name is the full resource name, e.g.
+	// //compute.googleapis.com/projects/p/zones/z/instances/i
+	Name      string
+	AssetType string
+	Project   string
+	Location  string
+	Created   string
+	Labels    map[string]string
+	// Owner is TerraformOwner, PackerOwner, InstanceGroupOwner,
+	// InstanceTemplateOwner or Orphaned
+	Owner string
+	// Group is the deployment group whose Terraform state or Packer manifest
+	// records the resource
+	Group string
+}
+
+// ShortName returns the resource name without its service, e.g.
+// projects/p/zones/z/instances/i
+func (r InventoryResource) ShortName() string {
+	n := strings.TrimPrefix(r.Name, "//")
+	if i := strings.Index(n, "/"); i != -1 {
+		return n[i+1:]
+	}
+	return n
+}
+
+// LabeledResources searches the projects with Cloud Asset Inventory for the
+// resources whose label key has the value, e.g. whose ghpc_deployment_id is
+// that of a deployment
+func LabeledResources(ctx context.Context, projects []string, key string, value string) ([]InventoryResource, error) {
+	s, err := cloudasset.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := []InventoryResource{}
+	for _, p := range projects {
+		req := s.V1.SearchAllResources("projects/" + p).
+			Query(fmt.Sprintf("labels.%s=%q", key, value)).
+			Context(ctx)
+		err := req.Pages(ctx, func(res *cloudasset.SearchAllResourcesResponse) error {
+			for _, r := range res.Results {
+				// the query also matches labels that contain the value
+				if r.Labels[key] != value {
+					continue
+				}
+				resources = append(resources, InventoryResource{
+					Name:      r.Name,
+					AssetType: r.AssetType,
+					Project:   p,
+					Location:  r.Location,
+					Created:   r.CreateTime,
+					Labels:    r.Labels,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search the resources of project %s with Cloud Asset Inventory: %w", p, err)
+		}
+	}
+	return resources, nil
+}
+
+// StateResourceIDs returns the identifiers of the managed resources recorded
+// in the Terraform state of the module working directory, as resourceKey
+// normalizes them: their ids and self links, and the sources of the disks of
+// instances, which are created along with them
+func StateResourceIDs(tf *tfexec.Terraform) ([]string, error) {
+	if err := initModule(tf); err != nil {
+		return nil, err
+	}
+	state, err := tf.Show(context.Background())
+	if err != nil {
+		return nil, &TfError{
+			help: fmt.Sprintf("reading the state of %s failed; manually resolve errors below", tf.WorkingDir()),
+			err:  err,
+		}
+	}
+	return resourceIDs(state), nil
+}
+
+// diskAttributes are the blocks of google_compute_instance whose sources are
+// the disks of the instance
+var diskAttributes = []string{"boot_disk", "attached_disk"}
+
+func resourceIDs(state *tfjson.State) []string {
+	if state == nil || state.Values == nil {
+		return nil
+	}
+	ids := []string{}
+	var walk func(m *tfjson.StateModule)
+	walk = func(m *tfjson.StateModule) {
+		if m == nil {
+			return
+		}
+		for _, r := range m.Resources {
+			if r.Mode != tfjson.ManagedResourceMode {
+				continue
+			}
+			for _, attr := range []string{"id", "self_link"} {
+				if v, ok := r.AttributeValues[attr].(string); ok && v != "" {
+					ids = append(ids, resourceKey(v))
+				}
+			}
+			for _, attr := range diskAttributes {
+				blocks, _ := r.AttributeValues[attr].([]interface{})
+				for _, b := range blocks {
+					disk, _ := b.(map[string]interface{})
+					if v, ok := disk["source"].(string); ok && v != "" {
+						ids = append(ids, resourceKey(v))
+					}
+				}
+			}
+		}
+		for _, c := range m.ChildModules {
+			walk(c)
+		}
+	}
+	walk(state.Values.RootModule)
+	return ids
+}
+
+// resourceKey normalizes the names of a resource in Cloud Asset Inventory
+// and in Terraform states, e.g.
+// //compute.googleapis.com/projects/p/zones/z/instances/i,
+// https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/i and
+// projects/p/zones/z/instances/i, to the path that starts with its project,
+// or the last part of names without project, such as those of buckets
+func resourceKey(name string) string {
+	if i := strings.Index(name, "projects/"); i != -1 {
+		return name[i:]
+	}
+	return path.Base(name)
+}
+
+// ClassifyResources sets the owners of the resources of an inventory: the
+// group whose Terraform state, by group, records the resource or the Packer
+// module that built an image; all other resources are orphaned
+func ClassifyResources(resources []InventoryResource, stateIDs map[string][]string, images []BuiltImage) {
+	owners := map[string]string{}
+	for group, ids := range stateIDs {
+		for _, id := range ids {
+			owners[id] = group
+		}
+	}
+	builtBy := map[string]string{}
+	for _, img := range images {
+		builtBy[fmt.Sprintf("projects/%s/global/images/%s", img.Project, img.Name)] = string(img.Group)
+	}
+
+	for i := range resources {
+		r := &resources[i]
+		key := resourceKey(r.Name)
+		if g, ok := owners[key]; ok {
+			r.Owner, r.Group = TerraformOwner, g
+		} else if g, ok := builtBy[key]; ok {
+			r.Owner, r.Group = PackerOwner, g
+		} else {
+			r.Owner, r.Group = Orphaned, ""
+		}
+	}
+}
+
+// ClassifyInstances sets the owners of the orphaned instances of an inventory
+// that a managed instance group or an instance template created, as their
+// created-by and instance-template metadata tell. Instances that Slurm
+// controllers create from templates remain orphaned.
+func ClassifyInstances(ctx context.Context, resources []InventoryResource) error {
+	var s *compute.Service
+	for i := range resources {
+		r := &resources[i]
+		if r.Owner != Orphaned || r.AssetType != "compute.googleapis.com/Instance" {
+			continue
+		}
+		if s == nil {
+			var err error
+			if s, err = compute.NewService(ctx); err != nil {
+				return err
+			}
+		}
+		loc, err := parseComputeName(r.ShortName())
+		if err != nil || loc.zone == "" {
+			return fmt.Errorf("unexpected instance name %s", r.Name)
+		}
+		inst, err := s.Instances.Get(loc.project, loc.zone, loc.name).Context(ctx).Do()
+		var herr *googleapi.Error
+		if errors.As(err, &herr) && herr.Code == 404 {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read instance %s: %w", r.ShortName(), err)
+		}
+		r.Owner = instanceOwner(inst, r.Labels)
+	}
+	return nil
+}
+
+// instanceOwner returns the owner of an instance that no Terraform state
+// records: the managed instance group named by its created-by metadata, the
+// template named by its instance-template metadata, unless it is a Slurm
+// node, or else none
+func instanceOwner(inst *compute.Instance, labels map[string]string) string {
+	meta := map[string]string{}
+	if inst.Metadata != nil {
+		for _, it := range inst.Metadata.Items {
+			if it != nil && it.Value != nil {
+				meta[it.Key] = *it.Value
+			}
+		}
+	}
+	if strings.Contains(meta["created-by"], "/instanceGroupManagers/") {
+		return InstanceGroupOwner
+	}
+	if _, slurm := labels["slurm_cluster_name"]; meta["instance-template"] != "" && !slurm {
+		return InstanceTemplateOwner
+	}
+	return Orphaned
+}
+
+// Orphans returns the orphaned resources of an inventory
+func Orphans(resources []InventoryResource) []InventoryResource {
+	orphans := []InventoryResource{}
+	for _, r := range resources {
+		if r.Owner == Orphaned {
+			orphans = append(orphans, r)
+		}
+	}
+	return orphans
+}
+
+// deletableAssetTypes are the asset types of the orphans that DeleteOrphans
+// deletes, in the order it deletes them, so that instances are deleted before
+// the templates, disks, images and placement policies they use; orphans of
+// other types must be deleted manually
+var deletableAssetTypes = []string{
+	"compute.googleapis.com/Instance",
+	"compute.googleapis.com/InstanceTemplate",
+	"compute.googleapis.com/Disk",
+	"compute.googleapis.com/Image",
+	"compute.googleapis.com/ResourcePolicy",
+}
+
+// IsDeletable returns true if DeleteOrphans can delete the resource
+func (r InventoryResource) IsDeletable() bool {
+	return slices.Contains(deletableAssetTypes, r.AssetType)
+}
+
+// DeleteOrphans deletes the orphaned Compute Engine instances, instance
+// templates, disks, images and resource policies, in that order; other orphans
+// are logged to be deleted manually, and resources that no longer exist are
+// skipped
+func DeleteOrphans(ctx context.Context, orphans []InventoryResource) error {
+	s, err := compute.NewService(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range deletionOrder(orphans) {
+		if !r.IsDeletable() {
+			log.Printf("skipping %s of type %s, which must be deleted manually", r.ShortName(), r.AssetType)
+			continue
+		}
+		log.Printf("deleting %s", r.ShortName())
+		err := deleteComputeResource(ctx, s, r)
+		var herr *googleapi.Error
+		if errors.As(err, &herr) && herr.Code == 404 {
+			log.Printf("%s no longer exists", r.ShortName())
+		} else if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", r.ShortName(), err)
+		}
+	}
+	return nil
+}
+
+// deletionOrder returns the orphans sorted by the order of deletableAssetTypes,
+// followed by those that cannot be deleted
+func deletionOrder(orphans []InventoryResource) []InventoryResource {
+	rank := func(r InventoryResource) int {
+		if i := slices.Index(deletableAssetTypes, r.AssetType); i != -1 {
+			return i
+		}
+		return len(deletableAssetTypes)
+	}
+	sorted := slices.Clone(orphans)
+	slices.SortStableFunc(sorted, func(a, b InventoryResource) bool { return rank(a) < rank(b) })
+	return sorted
+}
+
+// computeName is the location of a Compute Engine resource, as its name
+// tells, e.g. projects/PROJECT/zones/ZONE/instances/NAME,
+// projects/PROJECT/regions/REGION/resourcePolicies/NAME or
+// projects/PROJECT/global/images/NAME; zone and region are empty for global
+// resources
+type computeName struct {
+	project, zone, region, name string
+}
+
+func parseComputeName(shortName string) (computeName, error) {
+	parts := strings.Split(shortName, "/")
+	if len(parts) < 5 || parts[0] != "projects" {
+		return computeName{}, fmt.Errorf("unexpected resource name %s", shortName)
+	}
+	n := computeName{project: parts[1], name: parts[len(parts)-1]}
+	switch {
+	case parts[2] == "zones" && len(parts) == 6:
+		n.zone = parts[3]
+	case parts[2] == "regions" && len(parts) == 6:
+		n.region = parts[3]
+	case parts[2] == "global" && len(parts) == 5:
+	default:
+		return computeName{}, fmt.Errorf("unexpected resource name %s", shortName)
+	}
+	return n, nil
+}
+
+// deleteComputeResource deletes a Compute Engine resource of a deletable
+// asset type with the API of its location, zonal, regional or global, and
+// waits for the deletion to complete. Resources in locations that the API of
+// their type does not have are errors, so that they are not reported deleted.
+func deleteComputeResource(ctx context.Context, s *compute.Service, r InventoryResource) error {
+	n, err := parseComputeName(r.ShortName())
+	if err != nil {
+		return err
+	}
+	zonal, regional, global := n.zone != "", n.region != "", n.zone == "" && n.region == ""
+
+	var op *compute.Operation
+	switch {
+	case r.AssetType == "compute.googleapis.com/Instance" && zonal:
+		op, err = s.Instances.Delete(n.project, n.zone, n.name).Context(ctx).Do()
+	case r.AssetType == "compute.googleapis.com/Disk" && zonal:
+		op, err = s.Disks.Delete(n.project, n.zone, n.name).Context(ctx).Do()
+	case r.AssetType == "compute.googleapis.com/Disk" && regional:
+		op, err = s.RegionDisks.Delete(n.project, n.region, n.name).Context(ctx).Do()
+	case r.AssetType == "compute.googleapis.com/Image" && global:
+		op, err = s.Images.Delete(n.project, n.name).Context(ctx).Do()
+	case r.AssetType == "compute.googleapis.com/InstanceTemplate" && global:
+		op, err = s.InstanceTemplates.Delete(n.project, n.name).Context(ctx).Do()
+	case r.AssetType == "compute.googleapis.com/InstanceTemplate" && regional:
+		op, err = s.RegionInstanceTemplates.Delete(n.project, n.region, n.name).Context(ctx).Do()
+	case r.AssetType == "compute.googleapis.com/ResourcePolicy" && regional:
+		op, err = s.ResourcePolicies.Delete(n.project, n.region, n.name).Context(ctx).Do()
+	default:
+		return fmt.Errorf("cannot delete resources of type %s named %s", r.AssetType, r.ShortName())
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case zonal:
+		_, err = s.ZoneOperations.Wait(n.project, n.zone, op.Name).Context(ctx).Do()
+	case regional:
+		_, err = s.RegionOperations.Wait(n.project, n.region, op.Name).Context(ctx).Do()
+	default:
+		_, err = s.GlobalOperations.Wait(n.project, op.Name).Context(ctx).Do()
+	}
+	return err
+}
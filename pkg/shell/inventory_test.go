@@ -0,0 +1,162 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"path"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	compute "google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestResourceKey(c *C) {
+	want := "projects/p/zones/z/instances/i"
+	for _, name := range []string{
+		"//compute.googleapis.com/projects/p/zones/z/instances/i",
+		"https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/i",
+		"projects/p/zones/z/instances/i",
+	} {
+		c.Check(resourceKey(name), Equals, want)
+	}
+	// buckets have no project in their names
+	c.Check(resourceKey("//storage.googleapis.com/hpc-bucket"), Equals, "hpc-bucket")
+	c.Check(resourceKey("hpc-bucket"), Equals, "hpc-bucket")
+
+	r := InventoryResource{Name: "//compute.googleapis.com/projects/p/global/images/hpc"}
+	c.Check(r.ShortName(), Equals, "projects/p/global/images/hpc")
+}
+
+func (s *MySuite) TestResourceIDs(c *C) {
+	c.Check(resourceIDs(nil), IsNil)
+
+	state := &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{
+		Resources: []*tfjson.StateResource{
+			{Address: "google_storage_bucket.b", Mode: tfjson.ManagedResourceMode,
+				AttributeValues: map[string]interface{}{"id": "hpc-bucket"}},
+			{Address: "data.google_compute_image.i", Mode: tfjson.DataResourceMode,
+				AttributeValues: map[string]interface{}{"id": "projects/p/global/images/hpc"}},
+		},
+		ChildModules: []*tfjson.StateModule{{
+			Resources: []*tfjson.StateResource{
+				{Address: "module.vm.google_compute_instance.vm", Mode: tfjson.ManagedResourceMode,
+					AttributeValues: map[string]interface{}{
+						"id":        "projects/p/zones/z/instances/vm",
+						"self_link": "https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/vm",
+						"boot_disk": []interface{}{map[string]interface{}{
+							"source": "https://www.googleapis.com/compute/v1/projects/p/zones/z/disks/vm",
+						}},
+						"attached_disk": []interface{}{map[string]interface{}{
+							"source": "https://www.googleapis.com/compute/v1/projects/p/zones/z/disks/vm-data",
+						}},
+					}},
+			},
+		}},
+	}}}
+	c.Check(resourceIDs(state), DeepEquals, []string{
+		"hpc-bucket", "projects/p/zones/z/instances/vm", "projects/p/zones/z/instances/vm",
+		"projects/p/zones/z/disks/vm", "projects/p/zones/z/disks/vm-data"})
+}
+
+func (s *MySuite) TestClassifyResources(c *C) {
+	resources := []InventoryResource{
+		{Name: "//compute.googleapis.com/projects/p/zones/z/instances/login", AssetType: "compute.googleapis.com/Instance"},
+		{Name: "//compute.googleapis.com/projects/p/zones/z/instances/hpc-compute-0", AssetType: "compute.googleapis.com/Instance"},
+		{Name: "//compute.googleapis.com/projects/p/global/images/hpc-1", AssetType: "compute.googleapis.com/Image"},
+		{Name: "//compute.googleapis.com/projects/p/global/images/hpc-0", AssetType: "compute.googleapis.com/Image"},
+		{Name: "//storage.googleapis.com/hpc-bucket", AssetType: "storage.googleapis.com/Bucket"},
+	}
+	states := map[string][]string{"primary": {"projects/p/zones/z/instances/login"}}
+	images := []BuiltImage{{Group: "packer", Module: "image", Name: "hpc-1", Project: "p"}}
+	ClassifyResources(resources, states, images)
+
+	c.Check(resources[0].Owner, Equals, TerraformOwner)
+	c.Check(resources[0].Group, Equals, "primary")
+	c.Check(resources[2].Owner, Equals, PackerOwner)
+	c.Check(resources[2].Group, Equals, "packer")
+
+	orphans := Orphans(resources)
+	c.Assert(orphans, HasLen, 3)
+	c.Check(orphans[0].ShortName(), Equals, "projects/p/zones/z/instances/hpc-compute-0")
+	c.Check(orphans[0].IsDeletable(), Equals, true)
+	c.Check(orphans[1].ShortName(), Equals, "projects/p/global/images/hpc-0")
+	c.Check(orphans[2].IsDeletable(), Equals, false)
+}
+
+func (s *MySuite) TestDeletionOrder(c *C) {
+	orphans := []InventoryResource{
+		{Name: "//compute.googleapis.com/projects/p/zones/z/disks/a", AssetType: "compute.googleapis.com/Disk"},
+		{Name: "//storage.googleapis.com/b", AssetType: "storage.googleapis.com/Bucket"},
+		{Name: "//compute.googleapis.com/projects/p/regions/r/resourcePolicies/c", AssetType: "compute.googleapis.com/ResourcePolicy"},
+		{Name: "//compute.googleapis.com/projects/p/global/images/d", AssetType: "compute.googleapis.com/Image"},
+		{Name: "//compute.googleapis.com/projects/p/zones/z/instances/e", AssetType: "compute.googleapis.com/Instance"},
+		{Name: "//compute.googleapis.com/projects/p/global/instanceTemplates/f", AssetType: "compute.googleapis.com/InstanceTemplate"},
+		{Name: "//compute.googleapis.com/projects/p/zones/z/instances/g", AssetType: "compute.googleapis.com/Instance"},
+	}
+	names := []string{}
+	for _, r := range deletionOrder(orphans) {
+		names = append(names, path.Base(r.Name))
+	}
+	c.Check(names, DeepEquals, []string{"e", "g", "f", "a", "d", "c", "b"})
+	// the orphans are left in their order
+	c.Check(path.Base(orphans[0].Name), Equals, "a")
+}
+
+func (s *MySuite) TestParseComputeName(c *C) {
+	for _, tc := range []struct {
+		name string
+		want computeName
+		err  bool
+	}{
+		{"projects/p/zones/z/instances/i", computeName{project: "p", zone: "z", name: "i"}, false},
+		{"projects/p/regions/r/disks/d", computeName{project: "p", region: "r", name: "d"}, false},
+		{"projects/p/regions/r/instanceTemplates/t", computeName{project: "p", region: "r", name: "t"}, false},
+		{"projects/p/global/images/i", computeName{project: "p", name: "i"}, false},
+		{"projects/p/locations/l/things/t", computeName{}, true},
+		{"projects/p/zones/instances/i", computeName{}, true},
+		{"buckets/b", computeName{}, true},
+	} {
+		got, err := parseComputeName(tc.name)
+		c.Check(err != nil, Equals, tc.err, Commentf(tc.name))
+		c.Check(got, Equals, tc.want, Commentf(tc.name))
+	}
+}
+
+func (s *MySuite) TestInstanceOwner(c *C) {
+	instance := func(meta map[string]string) *compute.Instance {
+		items := []*compute.MetadataItems{}
+		for k, v := range meta {
+			v := v
+			items = append(items, &compute.MetadataItems{Key: k, Value: &v})
+		}
+		return &compute.Instance{Metadata: &compute.Metadata{Items: items}}
+	}
+	slurm := map[string]string{"slurm_cluster_name": "hpc"}
+
+	c.Check(instanceOwner(&compute.Instance{}, nil), Equals, Orphaned)
+	c.Check(instanceOwner(instance(map[string]string{
+		"created-by":        "projects/1/zones/z/instanceGroupManagers/workers",
+		"instance-template": "projects/1/global/instanceTemplates/workers",
+	}), nil), Equals, InstanceGroupOwner)
+	c.Check(instanceOwner(instance(map[string]string{
+		"instance-template": "projects/1/global/instanceTemplates/batch",
+	}), nil), Equals, InstanceTemplateOwner)
+	// Slurm controllers create their compute nodes from templates
+	c.Check(instanceOwner(instance(map[string]string{
+		"instance-template": "projects/1/global/instanceTemplates/hpc-compute",
+	}), slurm), Equals, Orphaned)
+}
@@ -0,0 +1,232 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// JobIDEnv is set in the environment of a detached ghpc process to the ID
+	// of the job it runs
+	JobIDEnv = "GHPC_JOB_ID"
+
+	jobsManifestName = "jobs.yaml"
+	jobsLockName     = ".jobs.lock"
+	jobsLogDirName   = "jobs"
+)
+
+// JobStatus is the state of a detached job
+type JobStatus string
+
+// statuses of detached jobs
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	// JobLost is a job whose process exited without recording its result
+	JobLost JobStatus = "lost"
+)
+
+// Job is a ghpc command running detached from the terminal that started it,
+// as recorded in the jobs manifest of the artifacts directory
+type Job struct {
+	ID       string    `yaml:"id"`
+	PID      int       `yaml:"pid"`
+	Args     []string  `yaml:"args"`
+	Log      string    `yaml:"log"`
+	Started  time.Time `yaml:"started"`
+	Finished time.Time `yaml:"finished,omitempty"`
+	Error    string    `yaml:"error,omitempty"`
+}
+
+// Status reports the state of the job, checking that the process of an
+// unfinished job is still alive
+func (j Job) Status() JobStatus {
+	switch {
+	case !j.Finished.IsZero() && j.Error != "":
+		return JobFailed
+	case !j.Finished.IsZero():
+		return JobSucceeded
+	case j.PID <= 0:
+		return JobLost
+	}
+	// signal 0 only checks that the process exists
+	if err := unix.Kill(j.PID, 0); err == nil || errors.Is(err, unix.EPERM) {
+		return JobRunning
+	}
+	return JobLost
+}
+
+// StartDetachedJob runs ghpc with args in a new session, so that it survives
+// the exit of the terminal, and records it in the jobs manifest. The output
+// of the job is written to a log file in the artifacts directory.
+func StartDetachedJob(artifactsDir string, args []string) (Job, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return Job{}, err
+	}
+
+	logDir := filepath.Join(artifactsDir, jobsLogDirName)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return Job{}, err
+	}
+	started := time.Now().UTC()
+	job := Job{
+		ID:      started.Format("20060102-150405.000"),
+		Args:    args,
+		Started: started,
+	}
+	job.Log = filepath.Join(logDir, job.ID+".log")
+	logFile, err := os.OpenFile(job.Log, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return Job{}, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", JobIDEnv, job.ID))
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	// the job starts while the manifest is locked, so it cannot record its
+	// result before it is itself recorded
+	err = updateJobs(artifactsDir, func(jobs []Job) ([]Job, error) {
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		job.PID = cmd.Process.Pid
+		return append(jobs, job), nil
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	return job, cmd.Process.Release()
+}
+
+// FinishJob records the result of a detached job in the jobs manifest
+func FinishJob(artifactsDir string, id string, result error) error {
+	return updateJobs(artifactsDir, func(jobs []Job) ([]Job, error) {
+		i := slices.IndexFunc(jobs, func(j Job) bool { return j.ID == id })
+		if i == -1 {
+			return nil, fmt.Errorf("job %s is not recorded in %s", id, filepath.Join(artifactsDir, jobsManifestName))
+		}
+		jobs[i].Finished = time.Now().UTC()
+		if result != nil {
+			jobs[i].Error = result.Error()
+		}
+		return jobs, nil
+	})
+}
+
+// ListJobs returns the jobs recorded in the jobs manifest, oldest first
+func ListJobs(artifactsDir string) ([]Job, error) {
+	jobs := []Job{}
+	err := withJobsLock(artifactsDir, func() error {
+		var err error
+		jobs, err = readJobs(artifactsDir)
+		return err
+	})
+	return jobs, err
+}
+
+// FindJob returns the job with the given ID
+func FindJob(artifactsDir string, id string) (Job, error) {
+	jobs, err := ListJobs(artifactsDir)
+	if err != nil {
+		return Job{}, err
+	}
+	for _, j := range jobs {
+		if j.ID == id {
+			return j, nil
+		}
+	}
+	return Job{}, fmt.Errorf("job %s is not recorded in %s", id, filepath.Join(artifactsDir, jobsManifestName))
+}
+
+// WaitJob polls the jobs manifest until the job is no longer running
+func WaitJob(artifactsDir string, id string, interval time.Duration) (Job, error) {
+	for {
+		job, err := FindJob(artifactsDir, id)
+		if err != nil || job.Status() != JobRunning {
+			return job, err
+		}
+		time.Sleep(interval)
+	}
+}
+
+func readJobs(artifactsDir string) ([]Job, error) {
+	jobs := []Job{}
+	b, err := os.ReadFile(filepath.Join(artifactsDir, jobsManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return jobs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to read jobs manifest: %w", err)
+	}
+	return jobs, nil
+}
+
+// updateJobs replaces the jobs manifest with the result of f while holding a
+// lock, as detached jobs update the manifest concurrently
+func updateJobs(artifactsDir string, f func([]Job) ([]Job, error)) error {
+	return withJobsLock(artifactsDir, func() error {
+		jobs, err := readJobs(artifactsDir)
+		if err != nil {
+			return err
+		}
+		if jobs, err = f(jobs); err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(jobs)
+		if err != nil {
+			return err
+		}
+		manifest := filepath.Join(artifactsDir, jobsManifestName)
+		if err := os.WriteFile(manifest+".tmp", b, 0644); err != nil {
+			return err
+		}
+		return os.Rename(manifest+".tmp", manifest)
+	})
+}
+
+func withJobsLock(artifactsDir string, f func() error) error {
+	lock, err := os.OpenFile(filepath.Join(artifactsDir, jobsLockName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+	return f()
+}
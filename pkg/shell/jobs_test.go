@@ -0,0 +1,65 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestJobsManifest(c *C) {
+	dir := c.MkDir()
+
+	jobs, err := ListJobs(dir)
+	c.Assert(err, IsNil)
+	c.Check(jobs, HasLen, 0)
+
+	// this process stands in for a running job
+	running := Job{ID: "running", PID: os.Getpid(), Started: time.Now().UTC()}
+	failed := Job{ID: "failed", PID: os.Getpid(), Started: time.Now().UTC()}
+	c.Assert(updateJobs(dir, func(jobs []Job) ([]Job, error) {
+		return append(jobs, running, failed), nil
+	}), IsNil)
+
+	c.Assert(FinishJob(dir, "failed", errors.New("apply failed")), IsNil)
+	c.Check(FinishJob(dir, "unknown", nil), ErrorMatches, "job unknown is not recorded in .*")
+
+	got, err := FindJob(dir, "running")
+	c.Assert(err, IsNil)
+	c.Check(got.Status(), Equals, JobRunning)
+
+	got, err = FindJob(dir, "failed")
+	c.Assert(err, IsNil)
+	c.Check(got.Status(), Equals, JobFailed)
+	c.Check(got.Error, Equals, "apply failed")
+
+	got, err = WaitJob(dir, "failed", time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Check(got.ID, Equals, "failed")
+
+	c.Assert(FinishJob(dir, "running", nil), IsNil)
+	got, err = FindJob(dir, "running")
+	c.Assert(err, IsNil)
+	c.Check(got.Status(), Equals, JobSucceeded)
+}
+
+func (s *MySuite) TestJobStatusLost(c *C) {
+	c.Check(Job{}.Status(), Equals, JobLost)
+}
@@ -0,0 +1,141 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+const notificationTimeout = 10 * time.Second
+
+// DeployEvent describes a change in the deployment of a group
+type DeployEvent struct {
+	Deployment string    `json:"deployment"`
+	Blueprint  string    `json:"blueprint"`
+	Group      string    `json:"group"`
+	Kind       string    `json:"kind"`
+	Event      string    `json:"event"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Notify sends the event by every notification that subscribes to it.
+// Failures to notify are logged, so that they never interrupt a deployment.
+func Notify(notifications []config.Notification, e DeployEvent) {
+	for _, n := range notifications {
+		if !n.Notifies(e.Event) {
+			continue
+		}
+		if err := sendNotification(n, e); err != nil {
+			log.Printf("warning: failed to send %s notification of %s of group %s: %v", n.Type, e.Event, e.Group, err)
+		}
+	}
+}
+
+func sendNotification(n config.Notification, e DeployEvent) error {
+	switch n.Type {
+	case config.PubSubNotification:
+		return publishEvent(n.Topic, e)
+	case config.SlackNotification:
+		return postJSON(n.URL, map[string]string{"text": slackText(e)})
+	case config.HTTPNotification:
+		return postJSON(n.URL, e)
+	default:
+		return fmt.Errorf("unknown notification type %q", n.Type)
+	}
+}
+
+// slackText summarizes the event in a Slack message
+func slackText(e DeployEvent) string {
+	var verb string
+	switch e.Event {
+	case config.DeployStarted:
+		verb = "started deploying"
+	case config.DeploySucceeded:
+		verb = "deployed"
+	default:
+		verb = "failed to deploy"
+	}
+	text := fmt.Sprintf("Deployment %s %s group %s (%s)", e.Deployment, verb, e.Group, e.Kind)
+	if e.Error != "" {
+		text += fmt.Sprintf(":\n```%s```", e.Error)
+	}
+	return text
+}
+
+// publishEvent publishes the event as JSON to a Pub/Sub topic, with
+// attributes that subscriptions can filter on
+func publishEvent(topic string, e DeployEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+	s, err := pubsub.NewService(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req := &pubsub.PublishRequest{Messages: []*pubsub.PubsubMessage{{
+		Data: base64.StdEncoding.EncodeToString(data),
+		Attributes: map[string]string{
+			"deployment": e.Deployment,
+			"group":      e.Group,
+			"event":      e.Event,
+		},
+	}}}
+	_, err = s.Projects.Topics.Publish(topic, req).Context(ctx).Do()
+	return err
+}
+
+// postJSON posts the body as JSON and errors unless the response succeeds
+func postJSON(endpoint string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: notificationTimeout}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		// webhook URLs are secrets, so they are left out of the error
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"encoding/json"
+	"hpc-toolkit/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestNotify(c *C) {
+	var mu sync.Mutex
+	received := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		c.Check(json.NewDecoder(r.Body).Decode(&body), IsNil)
+		mu.Lock()
+		received = append(received, body)
+		mu.Unlock()
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	e := DeployEvent{
+		Deployment: "hpc",
+		Blueprint:  "hpc-slurm",
+		Group:      "primary",
+		Kind:       "terraform",
+		Event:      config.DeployFailed,
+		Error:      "apply failed",
+		Time:       time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC),
+	}
+	Notify([]config.Notification{
+		{Type: config.HTTPNotification, URL: server.URL + "/hook"},
+		{Type: config.SlackNotification, URL: server.URL + "/slack"},
+		// only notifies of successes
+		{Type: config.HTTPNotification, URL: server.URL + "/success", Events: []string{config.DeploySucceeded}},
+		// failures to notify are not returned
+		{Type: config.HTTPNotification, URL: server.URL + "/broken"},
+	}, e)
+
+	mu.Lock()
+	got := received
+	mu.Unlock()
+	c.Assert(got, HasLen, 3)
+	c.Check(got[0], DeepEquals, map[string]interface{}{
+		"deployment": "hpc",
+		"blueprint":  "hpc-slurm",
+		"group":      "primary",
+		"kind":       "terraform",
+		"event":      "failure",
+		"error":      "apply failed",
+		"time":       "2023-07-01T00:00:00Z",
+	})
+	c.Check(got[1], DeepEquals, map[string]interface{}{
+		"text": "Deployment hpc failed to deploy group primary (terraform):\n```apply failed```",
+	})
+
+	c.Check(postJSON(server.URL+"/broken", e), ErrorMatches, "500 Internal Server Error.*")
+}
+
+func (s *MySuite) TestSlackText(c *C) {
+	e := DeployEvent{Deployment: "hpc", Group: "packer", Kind: "packer", Event: config.DeployStarted}
+	c.Check(slackText(e), Equals, "Deployment hpc started deploying group packer (packer)")
+	e.Event = config.DeploySucceeded
+	c.Check(slackText(e), Equals, "Deployment hpc deployed group packer (packer)")
+}
@@ -17,8 +17,22 @@
 package shell
 
 import (
+	"fmt"
+	"hpc-toolkit/pkg/modulewriter"
 	"os"
 	"os/exec"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+const (
+	packerPluginPathEnv  = "PACKER_PLUGIN_PATH"
+	packerPluginsDirName = "packer-plugins"
 )
 
 // ConfigurePacker errors if packer is not in the user PATH
@@ -48,3 +62,93 @@ func ExecPackerCmd(workingDir string, printToScreen bool, args ...string) error
 	}
 	return nil
 }
+
+// PackerPluginsDir returns the directory of the deployment in which the
+// packer plugins of a bundle are installed
+func PackerPluginsDir(deploymentRoot string) string {
+	return filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, packerPluginsDirName)
+}
+
+// ConfigurePackerPlugins makes packer use the plugins installed in the
+// deployment, if any, unless PACKER_PLUGIN_PATH is already set
+func ConfigurePackerPlugins(deploymentRoot string) error {
+	if os.Getenv(packerPluginPathEnv) != "" {
+		return nil
+	}
+	dir, err := filepath.Abs(PackerPluginsDir(deploymentRoot))
+	if err != nil {
+		return err
+	}
+	if isDir, _ := DirInfo(dir); isDir {
+		os.Setenv(packerPluginPathEnv, dir)
+	}
+	return nil
+}
+
+var packerVariableSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{{Type: "variable", LabelNames: []string{"name"}}},
+}
+
+var packerVariableTypeSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{{Name: "type"}},
+}
+
+// packerVariableTypes returns the type constraints of the variables declared
+// by the packer module in modulePath; variables without a type accept any
+// value
+func packerVariableTypes(modulePath string) (map[string]cty.Type, error) {
+	files, err := filepath.Glob(filepath.Join(modulePath, "*.pkr.hcl"))
+	if err != nil {
+		return nil, err
+	}
+	parser := hclparse.NewParser()
+	types := map[string]cty.Type{}
+	for _, f := range files {
+		file, diags := parser.ParseHCLFile(f)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		content, _, diags := file.Body.PartialContent(packerVariableSchema)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		for _, b := range content.Blocks {
+			attrs, _, diags := b.Body.PartialContent(packerVariableTypeSchema)
+			if diags.HasErrors() {
+				return nil, diags
+			}
+			ty := cty.DynamicPseudoType
+			if a, ok := attrs.Attributes["type"]; ok {
+				if ty, diags = typeexpr.TypeConstraint(a.Expr); diags.HasErrors() {
+					return nil, diags
+				}
+			}
+			types[b.Labels[0]] = ty
+		}
+	}
+	return types, nil
+}
+
+// convertPackerInputs converts the values of settings that use intergroup
+// outputs to the types of the packer variables they set, so that e.g. a
+// number output by Terraform as a string is written as a number. Settings
+// that the module does not declare are left for packer to report.
+func convertPackerInputs(modulePath string, values map[string]cty.Value) (map[string]cty.Value, error) {
+	types, err := packerVariableTypes(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	converted := make(map[string]cty.Value, len(values))
+	for name, v := range values {
+		ty, ok := types[name]
+		if !ok {
+			converted[name] = v
+			continue
+		}
+		if converted[name], err = convert.Convert(v, ty); err != nil {
+			return nil, fmt.Errorf("packer variable %s of %s has type %s, but its value from previous groups has type %s: %w",
+				name, modulePath, typeexpr.TypeString(ty), v.Type().FriendlyName(), err)
+		}
+	}
+	return converted, nil
+}
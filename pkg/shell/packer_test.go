@@ -20,7 +20,9 @@ import (
 	"errors"
 	"os"
 	"os/exec"
+	"path/filepath"
 
+	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
 )
 
@@ -51,3 +53,62 @@ func (s *MySuite) TestPacker(c *C) {
 	err = ExecPackerCmd(".", false)
 	c.Assert(err, NotNil)
 }
+
+func (s *MySuite) TestConvertPackerInputs(c *C) {
+	dir := c.MkDir()
+	vars := `
+variable "tags" {
+  type = list(string)
+}
+
+variable "labels" {
+  type = map(string)
+}
+
+variable "disk_size" {
+  type = number
+  validation {
+    condition     = var.disk_size > 0
+    error_message = "disk_size must be positive"
+  }
+}
+
+variable "metadata" {
+}
+`
+	c.Assert(os.WriteFile(filepath.Join(dir, "variables.pkr.hcl"), []byte(vars), 0644), IsNil)
+
+	types, err := packerVariableTypes(dir)
+	c.Assert(err, IsNil)
+	c.Check(types, DeepEquals, map[string]cty.Type{
+		"tags":      cty.List(cty.String),
+		"labels":    cty.Map(cty.String),
+		"disk_size": cty.Number,
+		"metadata":  cty.DynamicPseudoType,
+	})
+
+	metadata := cty.ObjectVal(map[string]cty.Value{"a": cty.True})
+	got, err := convertPackerInputs(dir, map[string]cty.Value{
+		"tags":       cty.TupleVal([]cty.Value{cty.StringVal("ssh")}),
+		"labels":     cty.ObjectVal(map[string]cty.Value{"env": cty.StringVal("dev")}),
+		"disk_size":  cty.StringVal("50"),
+		"metadata":   metadata,
+		"undeclared": cty.StringVal("x"),
+	})
+	c.Assert(err, IsNil)
+	want := map[string]cty.Value{
+		"tags":       cty.ListVal([]cty.Value{cty.StringVal("ssh")}),
+		"labels":     cty.MapVal(map[string]cty.Value{"env": cty.StringVal("dev")}),
+		"disk_size":  cty.NumberIntVal(50),
+		"metadata":   metadata,
+		"undeclared": cty.StringVal("x"),
+	}
+	c.Check(got, HasLen, len(want))
+	for name, v := range want {
+		c.Check(got[name].Type().Equals(v.Type()), Equals, true, Commentf("type of %s", name))
+		c.Check(got[name].Equals(v).True(), Equals, true, Commentf("value of %s", name))
+	}
+
+	_, err = convertPackerInputs(dir, map[string]cty.Value{"tags": cty.StringVal("ssh")})
+	c.Check(err, ErrorMatches, "packer variable tags .* has type list\\(string\\), but .* has type string: .*")
+}
@@ -0,0 +1,141 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/modulewriter"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+const (
+	// PluginCacheEnv is read by Terraform to share downloaded providers
+	// between working directories
+	PluginCacheEnv = "TF_PLUGIN_CACHE_DIR"
+	cliConfigEnv   = "TF_CLI_CONFIG_FILE"
+
+	// ProvidersMirrorDirName is the directory of the deployment, within the
+	// hidden ghpc directory, that holds its providers mirror
+	ProvidersMirrorDirName = "providers"
+	cliConfigName          = "terraform.tfrc"
+)
+
+// ProvidersMirrorDir returns the providers mirror of a deployment
+func ProvidersMirrorDir(deploymentRoot string) string {
+	return filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, ProvidersMirrorDirName)
+}
+
+// ConfigureProviderInstallation sets up the environment of the terraform
+// commands run for a deployment. Unless TF_PLUGIN_CACHE_DIR is already set,
+// providers are cached in the user cache directory, so that each one is only
+// downloaded once for all deployment groups. If the deployment has a providers
+// mirror, and no Terraform CLI configuration file is set, providers are
+// installed from the mirror instead of their registries.
+func ConfigureProviderInstallation(deploymentRoot string) error {
+	if os.Getenv(PluginCacheEnv) == "" {
+		cache, err := defaultPluginCacheDir()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(cache, 0755); err != nil {
+			return fmt.Errorf("failed to create Terraform plugin cache %s: %w", cache, err)
+		}
+		os.Setenv(PluginCacheEnv, cache)
+	}
+
+	mirror, err := filepath.Abs(ProvidersMirrorDir(deploymentRoot))
+	if err != nil {
+		return err
+	}
+	if isDir, _ := DirInfo(mirror); !isDir {
+		return nil
+	}
+	if cfg := os.Getenv(cliConfigEnv); cfg != "" {
+		log.Printf("not using providers mirror %s, as %s is set to %s", mirror, cliConfigEnv, cfg)
+		return nil
+	}
+	cfg := filepath.Join(filepath.Dir(mirror), cliConfigName)
+	if err := os.WriteFile(cfg, []byte(providersCLIConfig(mirror)), 0644); err != nil {
+		return err
+	}
+	log.Printf("installing Terraform providers from mirror %s", mirror)
+	os.Setenv(cliConfigEnv, cfg)
+	return nil
+}
+
+func defaultPluginCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find a Terraform plugin cache directory, set %s: %w", PluginCacheEnv, err)
+	}
+	return filepath.Join(dir, "ghpc", "terraform-plugins"), nil
+}
+
+// providersCLIConfig returns a Terraform CLI configuration that installs all
+// providers from a local mirror
+func providersCLIConfig(mirror string) string {
+	return fmt.Sprintf(`provider_installation {
+  filesystem_mirror {
+    path    = %q
+    include = ["*/*/*"]
+  }
+}
+`, mirror)
+}
+
+// MirrorProviders downloads the providers required by a Terraform deployment
+// group into mirrorDir for the given platforms, e.g. linux_amd64, or for the
+// current platform if none are given
+func MirrorProviders(tf *tfexec.Terraform, mirrorDir string, platforms []string) error {
+	mirror, err := filepath.Abs(mirrorDir)
+	if err != nil {
+		return err
+	}
+
+	// providers of child modules are only known once the modules are installed
+	if err := tf.Get(context.Background()); err != nil {
+		return &TfError{
+			help: fmt.Sprintf("installing the modules of %s failed; manually resolve errors below", tf.WorkingDir()),
+			err:  err,
+		}
+	}
+
+	args := []string{"providers", "mirror"}
+	for _, p := range platforms {
+		args = append(args, "-platform="+p)
+	}
+	args = append(args, mirror)
+
+	log.Printf("mirroring providers of %s to %s", tf.WorkingDir(), mirror)
+	cmd := exec.Command(tf.ExecPath(), args...)
+	cmd.Dir = tf.WorkingDir()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return &TfError{
+			help: fmt.Sprintf("mirroring the providers of %s failed; manually resolve errors below", tf.WorkingDir()),
+			err:  err,
+		}
+	}
+	return nil
+}
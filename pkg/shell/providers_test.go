@@ -0,0 +1,67 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestConfigureProviderInstallation(c *C) {
+	for _, env := range []string{PluginCacheEnv, cliConfigEnv} {
+		if v, ok := os.LookupEnv(env); ok {
+			defer os.Setenv(env, v)
+		} else {
+			defer os.Unsetenv(env)
+		}
+	}
+	cache := c.MkDir()
+	os.Setenv(PluginCacheEnv, cache)
+	os.Unsetenv(cliConfigEnv)
+
+	// without a mirror, providers come from their registries
+	root := c.MkDir()
+	c.Assert(ConfigureProviderInstallation(root), IsNil)
+	c.Check(os.Getenv(PluginCacheEnv), Equals, cache)
+	c.Check(os.Getenv(cliConfigEnv), Equals, "")
+
+	mirror := ProvidersMirrorDir(root)
+	c.Assert(os.MkdirAll(mirror, 0755), IsNil)
+	c.Assert(ConfigureProviderInstallation(root), IsNil)
+	cfg := os.Getenv(cliConfigEnv)
+	c.Check(cfg, Equals, filepath.Join(filepath.Dir(mirror), cliConfigName))
+	b, err := os.ReadFile(cfg)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, providersCLIConfig(mirror))
+
+	// a configuration file set by the user is kept
+	os.Setenv(cliConfigEnv, "/custom.tfrc")
+	c.Assert(ConfigureProviderInstallation(root), IsNil)
+	c.Check(os.Getenv(cliConfigEnv), Equals, "/custom.tfrc")
+}
+
+func (s *MySuite) TestProvidersCLIConfig(c *C) {
+	c.Check(providersCLIConfig("/dep/.ghpc/providers"), Equals, `provider_installation {
+  filesystem_mirror {
+    path    = "/dep/.ghpc/providers"
+    include = ["*/*/*"]
+  }
+}
+`)
+}
@@ -0,0 +1,352 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// executors that run the terraform and packer commands of ghpc deploy
+const (
+	LocalExecutor      = "local"
+	ContainerExecutor  = "container"
+	CloudBuildExecutor = "cloudbuild"
+)
+
+const (
+	// ContainerEngineEnv names the command that runs containers for the
+	// container executor; docker is used if it is unset
+	ContainerEngineEnv = "GHPC_CONTAINER_ENGINE"
+
+	remoteArchiveName   = "deployment.tgz"
+	cloudBuildTimeout   = "86400s"
+	cloudBuildPollDelay = 5 * time.Second
+)
+
+// RemoteDeployment is a ghpc deploy run inside a container image that
+// provides ghpc, terraform and packer, either locally or in Cloud Build
+type RemoteDeployment struct {
+	DeploymentRoot string
+	// Args are passed to ghpc deploy after the deployment directory
+	Args  []string
+	Image string
+	// ProjectID and StagingBucket, "gs://BUCKET[/FOLDER]", are only used by
+	// Cloud Build
+	ProjectID     string
+	StagingBucket string
+}
+
+func (r RemoteDeployment) deployArgs() []string {
+	name := filepath.Base(filepath.Clean(r.DeploymentRoot))
+	return append([]string{"deploy", "--auto-approve", name}, r.Args...)
+}
+
+// RunInContainer runs the deployment in a local container, mounting the
+// deployment directory and the gcloud credentials of the user
+func (r RemoteDeployment) RunInContainer() error {
+	engine := os.Getenv(ContainerEngineEnv)
+	if engine == "" {
+		engine = "docker"
+	}
+	if _, err := exec.LookPath(engine); err != nil {
+		return &TfError{
+			help: fmt.Sprintf("must have %s installed in PATH to deploy in a container; set %s to use another engine", engine, ContainerEngineEnv),
+			err:  err,
+		}
+	}
+
+	root, err := filepath.Abs(r.DeploymentRoot)
+	if err != nil {
+		return err
+	}
+	args := []string{"run", "--rm",
+		"--volume", fmt.Sprintf("%s:/workspace/%s", root, filepath.Base(root)),
+		"--workdir", "/workspace",
+		"--entrypoint", "ghpc",
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		gcloud := filepath.Join(home, ".config", "gcloud")
+		if isDir, _ := DirInfo(gcloud); isDir {
+			args = append(args, "--volume", gcloud+":/root/.config/gcloud:ro")
+		}
+	}
+	if creds := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); creds != "" {
+		args = append(args,
+			"--volume", creds+":/tmp/ghpc-credentials.json:ro",
+			"--env", "GOOGLE_APPLICATION_CREDENTIALS=/tmp/ghpc-credentials.json")
+	}
+	args = append(args, r.Image)
+	args = append(args, r.deployArgs()...)
+
+	log.Printf("deploying %s in container %s", root, r.Image)
+	cmd := exec.Command(engine, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// RunInCloudBuild uploads the deployment directory to the staging bucket and
+// deploys it in Cloud Build, streaming the build log. The deployment
+// directory, including local Terraform state and exported outputs, is
+// replaced by its state at the end of the build, even if the build failed.
+func (r RemoteDeployment) RunInCloudBuild() error {
+	ctx := context.Background()
+	cs, err := storage.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Storage client: %w", err)
+	}
+	cb, err := cloudbuild.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Build client: %w", err)
+	}
+
+	root := filepath.Clean(r.DeploymentRoot)
+	name := filepath.Base(root)
+	bucket, folder, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(r.StagingBucket, "gs://"), "/"), "/")
+	folder = path.Join(folder, name, time.Now().UTC().Format("20060102-150405"))
+
+	var src bytes.Buffer
+	if err := archiveDeployment(root, &src, isTerraformDir); err != nil {
+		return err
+	}
+	srcObject := path.Join(folder, "source.tgz")
+	if _, err := cs.Objects.Insert(bucket, &storage.Object{Name: srcObject}).Media(&src).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to upload deployment to gs://%s/%s: %w", bucket, srcObject, err)
+	}
+
+	location := fmt.Sprintf("gs://%s/%s", bucket, folder)
+	build := &cloudbuild.Build{
+		Source: &cloudbuild.Source{
+			StorageSource: &cloudbuild.StorageSource{Bucket: bucket, Object: srcObject},
+		},
+		Steps: []*cloudbuild.BuildStep{
+			{
+				Id:           "deploy",
+				Name:         r.Image,
+				Entrypoint:   "ghpc",
+				Args:         r.deployArgs(),
+				AllowFailure: true,
+			},
+			{
+				Id:         "archive",
+				Name:       "ubuntu",
+				Entrypoint: "tar",
+				Args:       []string{"czf", remoteArchiveName, "--exclude=.terraform", name},
+			},
+		},
+		Artifacts: &cloudbuild.Artifacts{
+			Objects: &cloudbuild.ArtifactObjects{Location: location + "/", Paths: []string{remoteArchiveName}},
+		},
+		LogsBucket: location,
+		Timeout:    cloudBuildTimeout,
+	}
+	op, err := cb.Projects.Builds.Create(r.ProjectID, build).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to start Cloud Build in project %s: %w", r.ProjectID, err)
+	}
+	var meta cloudbuild.BuildOperationMetadata
+	if err := json.Unmarshal(op.Metadata, &meta); err != nil || meta.Build == nil {
+		return fmt.Errorf("failed to read the ID of the Cloud Build started by operation %s", op.Name)
+	}
+	id := meta.Build.Id
+	log.Printf("deploying %s in Cloud Build %s: %s", root, id, meta.Build.LogUrl)
+
+	logObject := path.Join(folder, fmt.Sprintf("log-%s.txt", id))
+	var offset int64
+	for {
+		b, err := cb.Projects.Builds.Get(r.ProjectID, id).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to get status of Cloud Build %s: %w", id, err)
+		}
+		offset = streamObject(ctx, cs, bucket, logObject, offset, os.Stdout)
+		if isFinalBuildStatus(b.Status) {
+			build = b
+			break
+		}
+		time.Sleep(cloudBuildPollDelay)
+	}
+
+	archive := path.Join(folder, remoteArchiveName)
+	if resp, err := cs.Objects.Get(bucket, archive).Context(ctx).Download(); err == nil {
+		defer resp.Body.Close()
+		if err := extractDeployment(resp.Body, root); err != nil {
+			return fmt.Errorf("failed to restore deployment from gs://%s/%s: %w", bucket, archive, err)
+		}
+	} else {
+		log.Printf("the deployment directory was not updated, gs://%s/%s could not be read: %v", bucket, archive, err)
+	}
+
+	if build.Status != "SUCCESS" {
+		return fmt.Errorf("cloud build %s ended with status %s: %s", id, build.Status, build.StatusDetail)
+	}
+	if s := build.Steps[0]; s.Status != "SUCCESS" {
+		return fmt.Errorf("ghpc deploy failed in Cloud Build %s with status %s; see the log above", id, s.Status)
+	}
+	return nil
+}
+
+func isFinalBuildStatus(status string) bool {
+	switch status {
+	case "SUCCESS", "FAILURE", "INTERNAL_ERROR", "TIMEOUT", "CANCELLED", "EXPIRED":
+		return true
+	}
+	return false
+}
+
+// streamObject copies the content of an object from offset to w and returns
+// the new offset; objects that do not exist yet are skipped
+func streamObject(ctx context.Context, s *storage.Service, bucket string, object string, offset int64, w io.Writer) int64 {
+	call := s.Objects.Get(bucket, object).Context(ctx)
+	call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := call.Download()
+	if err != nil {
+		// not found, or no content past offset (416)
+		if e, ok := err.(*googleapi.Error); !ok || (e.Code != 404 && e.Code != 416) {
+			log.Printf("failed to read build log: %v", err)
+		}
+		return offset
+	}
+	defer resp.Body.Close()
+	n, _ := io.Copy(w, resp.Body)
+	return offset + n
+}
+
+// isTerraformDir matches Terraform working directories, which are left out of
+// archives sent to Cloud Build as they are recreated by init
+func isTerraformDir(rel string) bool {
+	return path.Base(rel) == ".terraform"
+}
+
+// archiveDeployment writes the deployment directory as a gzipped tarball
+// whose entries are prefixed with the name of the directory. Directories,
+// given by their slash-separated path relative to root, are left out if skip
+// matches them.
+func archiveDeployment(root string, w io.Writer, skip func(rel string) bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	name := filepath.Base(root)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && skip(filepath.ToSlash(rel)) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractDeployment writes the files of an archive created by
+// archiveDeployment into root, replacing existing files
+func extractDeployment(r io.Reader, root string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, rel, _ := strings.Cut(path.Clean(hdr.Name), "/")
+		if rel == "" || rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+			continue
+		}
+		dst := filepath.Join(root, filepath.FromSlash(rel))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestArchiveDeployment(c *C) {
+	root := filepath.Join(c.MkDir(), "deployment")
+	files := map[string]string{
+		"primary/main.tf":                   "module {}",
+		"primary/terraform.tfstate":         "{}",
+		".ghpc/artifacts/expanded.yaml":     "blueprint_name: test",
+		"primary/.terraform/providers/lock": "ignored",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(os.WriteFile(p, []byte(content), 0644), IsNil)
+	}
+
+	var buf bytes.Buffer
+	c.Assert(archiveDeployment(root, &buf, isTerraformDir), IsNil)
+
+	restored := filepath.Join(c.MkDir(), "restored")
+	c.Assert(extractDeployment(&buf, restored), IsNil)
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(restored, name))
+		if name == "primary/.terraform/providers/lock" {
+			c.Check(os.IsNotExist(err), Equals, true)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(string(got), Equals, content)
+	}
+}
+
+func (s *MySuite) TestRemoteDeployArgs(c *C) {
+	r := RemoteDeployment{DeploymentRoot: "out/hpc/", Args: []string{"--only-group", "primary"}}
+	c.Check(r.deployArgs(), DeepEquals, []string{"deploy", "--auto-approve", "hpc", "--only-group", "primary"})
+}
@@ -0,0 +1,204 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// slurmDrainScript drains every partition of a Slurm cluster, so that the
+// controller creates no more compute nodes while the cluster is destroyed
+const slurmDrainScript = `for p in $(sinfo --noheader --format=%R); do ` +
+	`scontrol update partitionname="$p" state=DRAIN; done`
+
+// slurmNodeFilter selects the VMs of a Slurm cluster of a deployment that have
+// a role, e.g. compute or controller. The deployment id label tells apart the
+// clusters of deployments whose names truncate to the same cluster name, or
+// are the same; deployments without one are told apart by their name.
+func slurmNodeFilter(c config.SlurmCluster, role string) string {
+	deployment := fmt.Sprintf("(labels.ghpc_deployment = %q)", c.DeploymentLabel)
+	if c.DeploymentID != "" {
+		deployment = fmt.Sprintf("(labels.%s = %q)", config.DeploymentIDLabel, c.DeploymentID)
+	}
+	return fmt.Sprintf("(labels.slurm_cluster_name = %q) AND (labels.slurm_instance_role = %q) AND %s",
+		c.Name, role, deployment)
+}
+
+// isSlurmPlacementGroup returns true if a resource policy is a placement
+// group that the controller of a Slurm cluster created for a job: its name
+// starts with the name of the cluster and it is in the region of the cluster.
+// Placement groups have no labels, so the clusters of deployments whose names
+// truncate to the same cluster name in the same region cannot be told apart.
+func isSlurmPlacementGroup(p *compute.ResourcePolicy, c config.SlurmCluster) bool {
+	return p.GroupPlacementPolicy != nil && strings.HasPrefix(p.Name, c.Name+"-") && path.Base(p.Region) == c.Region
+}
+
+// computeResource returns the InventoryResource of a Compute Engine resource
+// with its self link, as Cloud Asset Inventory would name it
+func computeResource(assetType string, project string, selfLink string) InventoryResource {
+	key := resourceKey(selfLink)
+	parts := strings.Split(key, "/")
+	location := "global"
+	if len(parts) > 3 && (parts[2] == "zones" || parts[2] == "regions") {
+		location = parts[3]
+	}
+	return InventoryResource{
+		Name:      "//compute.googleapis.com/" + key,
+		AssetType: assetType,
+		Project:   project,
+		Location:  location,
+	}
+}
+
+// SlurmResources returns the compute nodes and placement groups that the
+// controller of a Slurm cluster created on demand, i.e. that are recorded in
+// none of the Terraform states, by group, of the deployment
+func SlurmResources(ctx context.Context, c config.SlurmCluster, stateIDs map[string][]string) ([]InventoryResource, error) {
+	s, err := compute.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := []InventoryResource{}
+	err = s.Instances.AggregatedList(c.ProjectID).Filter(slurmNodeFilter(c, "compute")).Pages(ctx,
+		func(l *compute.InstanceAggregatedList) error {
+			for _, scoped := range l.Items {
+				for _, i := range scoped.Instances {
+					resources = append(resources, computeResource("compute.googleapis.com/Instance", c.ProjectID, i.SelfLink))
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the compute nodes of Slurm cluster %s: %w", c.Name, err)
+	}
+
+	switch {
+	case c.Region == "":
+		log.Printf("the region of Slurm cluster %s is not known; delete its placement groups manually", c.Name)
+	case c.Truncated:
+		log.Printf("Slurm cluster %s is named after the deployment name cut to 10 characters, "+
+			"which other deployments may share; delete its placement groups manually, "+
+			"or set slurm_cluster_name of module %s", c.Name, c.Module)
+	default:
+		err = s.ResourcePolicies.List(c.ProjectID, c.Region).Pages(ctx, func(l *compute.ResourcePolicyList) error {
+			for _, p := range l.Items {
+				if isSlurmPlacementGroup(p, c) {
+					resources = append(resources, computeResource("compute.googleapis.com/ResourcePolicy", c.ProjectID, p.SelfLink))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the placement groups of Slurm cluster %s: %w", c.Name, err)
+		}
+	}
+
+	ClassifyResources(resources, stateIDs, nil)
+	return Orphans(resources), nil
+}
+
+// DrainSlurmPartitions drains the partitions of a Slurm cluster with a
+// command run on its controller over SSH by gcloud, tunneled through IAP if
+// the controller has no external IP address. If the partitions cannot be
+// drained, the controller is stopped instead, so that it creates no more
+// compute nodes either way. Clusters whose controller is not running, or runs
+// outside of Google Cloud, are skipped.
+func DrainSlurmPartitions(ctx context.Context, c config.SlurmCluster) error {
+	if c.Hybrid {
+		log.Printf("the controller of Slurm cluster %s does not run in Google Cloud; drain its partitions manually", c.Name)
+		return nil
+	}
+	s, err := compute.NewService(ctx)
+	if err != nil {
+		return err
+	}
+	var controller *compute.Instance
+	err = s.Instances.AggregatedList(c.ProjectID).Filter(slurmNodeFilter(c, "controller")).Pages(ctx,
+		func(l *compute.InstanceAggregatedList) error {
+			for _, scoped := range l.Items {
+				for _, i := range scoped.Instances {
+					if i.Status == "RUNNING" {
+						controller = i
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return fmt.Errorf("failed to find the controller of Slurm cluster %s: %w", c.Name, err)
+	}
+	if controller == nil {
+		log.Printf("the controller of Slurm cluster %s is not running; its partitions are not drained", c.Name)
+		return nil
+	}
+
+	if _, err := exec.LookPath("gcloud"); err != nil {
+		log.Printf("gcloud is not installed in PATH, so the partitions of Slurm cluster %s cannot be drained", c.Name)
+		return stopSlurmController(ctx, s, c, controller)
+	}
+	args := []string{"compute", "ssh", controller.Name,
+		"--project", c.ProjectID, "--zone", path.Base(controller.Zone),
+		"--command", "sudo bash -c '" + slurmDrainScript + "'"}
+	if !hasExternalIP(controller) {
+		args = append(args, "--tunnel-through-iap")
+	}
+	log.Printf("draining the partitions of Slurm cluster %s on %s", c.Name, controller.Name)
+	cmd := exec.CommandContext(ctx, "gcloud", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("failed to drain the partitions of Slurm cluster %s: %v", c.Name, err)
+		return stopSlurmController(ctx, s, c, controller)
+	}
+	return nil
+}
+
+// stopSlurmController stops the controller of a Slurm cluster, which then
+// creates no more compute nodes
+func stopSlurmController(ctx context.Context, s *compute.Service, c config.SlurmCluster, controller *compute.Instance) error {
+	zone := path.Base(controller.Zone)
+	log.Printf("stopping the controller %s of Slurm cluster %s instead", controller.Name, c.Name)
+	op, err := s.Instances.Stop(c.ProjectID, zone, controller.Name).Context(ctx).Do()
+	if err == nil {
+		_, err = s.ZoneOperations.Wait(c.ProjectID, zone, op.Name).Context(ctx).Do()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stop the controller %s of Slurm cluster %s: %w", controller.Name, c.Name, err)
+	}
+	return nil
+}
+
+func hasExternalIP(i *compute.Instance) bool {
+	for _, nic := range i.NetworkInterfaces {
+		for _, ac := range nic.AccessConfigs {
+			if ac.NatIP != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
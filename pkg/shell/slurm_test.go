@@ -0,0 +1,76 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"hpc-toolkit/pkg/config"
+
+	compute "google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestSlurmNodeFilter(c *C) {
+	cluster := config.SlurmCluster{Name: "hpc", DeploymentLabel: "hpc-1"}
+	c.Check(slurmNodeFilter(cluster, "compute"), Equals,
+		`(labels.slurm_cluster_name = "hpc") AND (labels.slurm_instance_role = "compute") AND (labels.ghpc_deployment = "hpc-1")`)
+
+	cluster.DeploymentID = "0123456789abcdef"
+	c.Check(slurmNodeFilter(cluster, "compute"), Equals,
+		`(labels.slurm_cluster_name = "hpc") AND (labels.slurm_instance_role = "compute") AND (labels.ghpc_deployment_id = "0123456789abcdef")`)
+}
+
+func (s *MySuite) TestIsSlurmPlacementGroup(c *C) {
+	cluster := config.SlurmCluster{Name: "hpc", Region: "us-central1"}
+	placement := &compute.ResourcePolicyGroupPlacementPolicy{Collocation: "COLLOCATED"}
+	region := "https://www.googleapis.com/compute/v1/projects/p/regions/us-central1"
+	for _, tc := range []struct {
+		policy compute.ResourcePolicy
+		want   bool
+	}{
+		{compute.ResourcePolicy{Name: "hpc-debug-12-0", Region: region, GroupPlacementPolicy: placement}, true},
+		// placement groups of other clusters
+		{compute.ResourcePolicy{Name: "hpc2-debug-12-0", Region: region, GroupPlacementPolicy: placement}, false},
+		// placement groups of clusters of the same name in other regions
+		{compute.ResourcePolicy{Name: "hpc-debug-12-0", Region: region + "2", GroupPlacementPolicy: placement}, false},
+		// other resource policies
+		{compute.ResourcePolicy{Name: "hpc-snapshots", Region: region}, false},
+	} {
+		c.Check(isSlurmPlacementGroup(&tc.policy, cluster), Equals, tc.want, Commentf("%s in %s", tc.policy.Name, tc.policy.Region))
+	}
+}
+
+func (s *MySuite) TestComputeResource(c *C) {
+	node := computeResource("compute.googleapis.com/Instance", "p",
+		"https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/instances/hpc-debug-0")
+	c.Check(node, DeepEquals, InventoryResource{
+		Name:      "//compute.googleapis.com/projects/p/zones/us-central1-a/instances/hpc-debug-0",
+		AssetType: "compute.googleapis.com/Instance",
+		Project:   "p",
+		Location:  "us-central1-a",
+	})
+	c.Check(node.IsDeletable(), Equals, true)
+
+	pg := computeResource("compute.googleapis.com/ResourcePolicy", "p",
+		"https://www.googleapis.com/compute/v1/projects/p/regions/us-central1/resourcePolicies/hpc-debug-12-0")
+	c.Check(pg.Location, Equals, "us-central1")
+	c.Check(pg.IsDeletable(), Equals, true)
+
+	// nodes recorded in a Terraform state are not created on demand
+	resources := []InventoryResource{node, pg}
+	ClassifyResources(resources, map[string][]string{"cluster": {resourceKey(node.Name)}}, nil)
+	c.Check(Orphans(resources), DeepEquals, []InventoryResource{resources[1]})
+}
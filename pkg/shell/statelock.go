@@ -0,0 +1,154 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// StateLock is the lock on the Terraform state of a deployment group, as
+// described by the "Lock Info" of the error of a Terraform command that could
+// not acquire it
+type StateLock struct {
+	ID        string
+	Path      string
+	Operation string
+	Who       string
+	Version   string
+	Created   string
+	Info      string
+}
+
+// StateLockError is the failure of a Terraform command because another
+// holds the lock on the state of the group
+type StateLockError struct {
+	Dir  string
+	Lock StateLock
+	err  error
+}
+
+func (e *StateLockError) Error() string {
+	return fmt.Sprintf("the Terraform state of %s is locked by %s since %s (%s, lock ID %s); "+
+		"if no other Terraform command is running on it, the lock is stale: deploy again with --force-unlock, "+
+		"or run \"terraform -chdir=%s force-unlock %s\" (detailed error below)\n%s",
+		e.Dir, e.Lock.holder(), e.Lock.created(), e.Lock.operation(), e.Lock.ID, e.Dir, e.Lock.ID, e.err)
+}
+
+func (e *StateLockError) Unwrap() error {
+	return e.err
+}
+
+func (l StateLock) holder() string {
+	if l.Who == "" {
+		return "an unknown user"
+	}
+	return l.Who
+}
+
+func (l StateLock) created() string {
+	if l.Created == "" {
+		return "an unknown time"
+	}
+	return l.Created
+}
+
+func (l StateLock) operation() string {
+	op := strings.TrimPrefix(l.Operation, "OperationType")
+	if op == "" {
+		return "unknown operation"
+	}
+	return "terraform " + strings.ToLower(op)
+}
+
+var lockInfoExp = regexp.MustCompile(`(?m)^\s*(ID|Path|Operation|Who|Version|Created|Info):[ \t]*(.*?)\s*$`)
+
+// parseStateLock returns the lock described by the error of a Terraform
+// command that failed to acquire the state lock
+func parseStateLock(err error) (StateLock, bool) {
+	if err == nil {
+		return StateLock{}, false
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "Error acquiring the state lock") {
+		return StateLock{}, false
+	}
+	i := strings.Index(msg, "Lock Info:")
+	if i < 0 {
+		return StateLock{}, false
+	}
+
+	lock := StateLock{}
+	fields := map[string]*string{
+		"ID": &lock.ID, "Path": &lock.Path, "Operation": &lock.Operation, "Who": &lock.Who,
+		"Version": &lock.Version, "Created": &lock.Created, "Info": &lock.Info,
+	}
+	for _, m := range lockInfoExp.FindAllStringSubmatch(msg[i:], -1) {
+		if f := fields[m[1]]; *f == "" {
+			*f = m[2]
+		}
+	}
+	return lock, lock.ID != ""
+}
+
+// asStateLockError returns a StateLockError if err is the failure of a
+// Terraform command to acquire the state lock, or nil
+func asStateLockError(tf *tfexec.Terraform, err error) error {
+	lock, ok := parseStateLock(err)
+	if !ok {
+		return nil
+	}
+	return &StateLockError{Dir: tf.WorkingDir(), Lock: lock, err: err}
+}
+
+// ForceUnlock removes a stale lock on the Terraform state of a group
+func ForceUnlock(tf *tfexec.Terraform, lock StateLock) error {
+	log.Printf("removing the lock %s on the Terraform state of %s, held by %s since %s",
+		lock.ID, tf.WorkingDir(), lock.holder(), lock.created())
+	if err := tf.ForceUnlock(context.Background(), lock.ID); err != nil {
+		return &TfError{
+			help: fmt.Sprintf("failed to remove the lock %s on the Terraform state of %s", lock.ID, tf.WorkingDir()),
+			err:  err,
+		}
+	}
+	return nil
+}
+
+// ForceUnlockChoice prompts the user to confirm the removal of a lock on the
+// Terraform state, which must only be removed if no other Terraform command
+// holds it; only "y" or "yes" (case-insensitive) confirm it
+func ForceUnlockChoice(e *StateLockError) bool {
+	log.Printf("the Terraform state of %s is locked by %s since %s (%s, lock ID %s)",
+		e.Dir, e.Lock.holder(), e.Lock.created(), e.Lock.operation(), e.Lock.ID)
+	fmt.Print("Removing a lock that another Terraform command still holds can corrupt the state. Remove it? [y,N]: ")
+
+	var userResponse string
+	if _, err := fmt.Scanln(&userResponse); err != nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(userResponse)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
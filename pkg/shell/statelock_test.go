@@ -0,0 +1,78 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"errors"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	. "gopkg.in/check.v1"
+)
+
+const lockErrorOutput = `exit status 1
+
+Error: Error acquiring the state lock
+
+Error message: writing "gs://bucket/prefix/default.tflock" failed: googleapi:
+Error 412: At least one of the pre-conditions you specified did not hold.,
+conditionNotMet
+Lock Info:
+  ID:        1690000000000000
+  Path:      gs://bucket/prefix/default.tflock
+  Operation: OperationTypeApply
+  Who:       alice@workstation
+  Version:   1.5.2
+  Created:   2023-07-20 12:00:00.000000 +0000 UTC
+  Info:      
+
+Terraform acquires a state lock to protect the state from being written
+by multiple users at the same time. Please resolve the issue above and try
+again. For most commands, you can disable locking with the "-lock=false"
+flag, but this is not recommended.
+`
+
+func (s *MySuite) TestParseStateLock(c *C) {
+	lock, ok := parseStateLock(errors.New(lockErrorOutput))
+	c.Assert(ok, Equals, true)
+	c.Check(lock, DeepEquals, StateLock{
+		ID:        "1690000000000000",
+		Path:      "gs://bucket/prefix/default.tflock",
+		Operation: "OperationTypeApply",
+		Who:       "alice@workstation",
+		Version:   "1.5.2",
+		Created:   "2023-07-20 12:00:00.000000 +0000 UTC",
+	})
+
+	_, ok = parseStateLock(errors.New("exit status 1\n\nError: Invalid reference"))
+	c.Check(ok, Equals, false)
+	_, ok = parseStateLock(nil)
+	c.Check(ok, Equals, false)
+}
+
+func (s *MySuite) TestAsStateLockError(c *C) {
+	tf, err := tfexec.NewTerraform(c.MkDir(), "terraform")
+	c.Assert(err, IsNil)
+
+	c.Check(asStateLockError(tf, errors.New("exit status 1")), IsNil)
+
+	err = asStateLockError(tf, errors.New(lockErrorOutput))
+	var lockErr *StateLockError
+	c.Assert(errors.As(err, &lockErr), Equals, true)
+	c.Check(lockErr.Lock.ID, Equals, "1690000000000000")
+	c.Check(err, ErrorMatches, "(?s)the Terraform state of .* is locked by alice@workstation since "+
+		"2023-07-20 12:00:00.000000 \\+0000 UTC \\(terraform apply, lock ID 1690000000000000\\);.*--force-unlock.*")
+}
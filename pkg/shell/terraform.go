@@ -28,10 +28,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 	"github.com/zclconf/go-cty/cty/gocty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
 )
 
 // ApplyBehavior abstracts behaviors for making changes to cloud infrastructure
@@ -138,9 +143,15 @@ func outputModule(tf *tfexec.Terraform) (map[string]cty.Value, error) {
 // note planned deprecration of Plan in favor of JSON-only format
 // may need to determine future-proof way of getting human-readable plan
 // https://github.com/hashicorp/terraform-exec/blob/1b7714111a94813e92936051fb3014fec81218d5/tfexec/plan.go#L128-L129
-func planModule(tf *tfexec.Terraform, path string, destroy bool) (bool, error) {
-	outOpt := tfexec.Out(path)
-	wantsChange, err := tf.Plan(context.Background(), outOpt, tfexec.Destroy(destroy))
+func planModule(tf *tfexec.Terraform, path string, destroy bool, targets []string) (bool, error) {
+	opts := []tfexec.PlanOption{tfexec.Out(path), tfexec.Destroy(destroy)}
+	for _, t := range targets {
+		opts = append(opts, tfexec.Target(t))
+	}
+	wantsChange, err := tf.Plan(context.Background(), opts...)
+	if lockErr := asStateLockError(tf, err); lockErr != nil {
+		return false, lockErr
+	}
 	if err != nil {
 		return false, &TfError{
 			help: fmt.Sprintf("terraform plan for %s failed; suggest running \"ghpc export-outputs\" on previous deployment groups to define inputs", tf.WorkingDir()),
@@ -185,6 +196,9 @@ func applyPlanConsoleOutput(tf *tfexec.Terraform, path string) error {
 	tf.SetStdout(os.Stdout)
 	tf.SetStderr(os.Stderr)
 	if err := tf.Apply(context.Background(), planFileOpt); err != nil {
+		if lockErr := asStateLockError(tf, err); lockErr != nil {
+			return lockErr
+		}
 		return err
 	}
 	tf.SetStdout(nil)
@@ -194,8 +208,9 @@ func applyPlanConsoleOutput(tf *tfexec.Terraform, path string) error {
 
 // generate a Terraform plan to apply or destroy a module
 // recall "destroy" is just an alias for "apply -destroy"!
-// apply the plan automatically or after prompting the user
-func applyOrDestroy(tf *tfexec.Terraform, b ApplyBehavior, destroy bool) error {
+// apply the plan automatically or after prompting the user; the plan is
+// limited to the targets, if any, and their dependencies
+func applyOrDestroy(tf *tfexec.Terraform, b ApplyBehavior, destroy bool, targets []string) error {
 	action := "adding or changing"
 	pastTense := "applied"
 	if destroy {
@@ -208,13 +223,16 @@ func applyOrDestroy(tf *tfexec.Terraform, b ApplyBehavior, destroy bool) error {
 	}
 
 	log.Printf("testing if module in %s requires %s cloud infrastructure", tf.WorkingDir(), action)
+	if len(targets) > 0 {
+		log.Printf("limiting the plan to %s", strings.Join(targets, ", "))
+	}
 	// capture Terraform plan in a file
 	f, err := os.CreateTemp("", "plan-)")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer os.Remove(f.Name())
-	wantsChange, err := planModule(tf, f.Name(), destroy)
+	wantsChange, err := planModule(tf, f.Name(), destroy, targets)
 	if err != nil {
 		return err
 	}
@@ -238,8 +256,8 @@ func applyOrDestroy(tf *tfexec.Terraform, b ApplyBehavior, destroy bool) error {
 	return nil
 }
 
-func getOutputs(tf *tfexec.Terraform, b ApplyBehavior) (map[string]cty.Value, error) {
-	err := applyOrDestroy(tf, b, false)
+func getOutputs(tf *tfexec.Terraform, b ApplyBehavior, targets []string) (map[string]cty.Value, error) {
+	err := applyOrDestroy(tf, b, false, targets)
 	if err != nil {
 		return nil, err
 	}
@@ -252,23 +270,99 @@ func getOutputs(tf *tfexec.Terraform, b ApplyBehavior) (map[string]cty.Value, er
 }
 
 func outputsFile(artifactsDir string, group config.GroupName) string {
-	return filepath.Join(artifactsDir, fmt.Sprintf("%s_outputs.tfvars", string(group)))
+	return filepath.Join(artifactsDir, modulewriter.OutputsFilename(group))
+}
+
+// outputTypesFile records the Terraform types of the outputs of group, which
+// HCL literals in the outputs file do not preserve
+func outputTypesFile(artifactsDir string, group config.GroupName) string {
+	return filepath.Join(artifactsDir, modulewriter.OutputTypesFilename(group))
+}
+
+func writeOutputTypes(outputValues map[string]cty.Value, file string) error {
+	types := map[string]json.RawMessage{}
+	for name, v := range outputValues {
+		b, err := ctyjson.MarshalType(v.Type())
+		if err != nil {
+			return err
+		}
+		types[name] = b
+	}
+	b, err := json.MarshalIndent(types, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// readOutputTypes returns the types of the outputs of a group; it is empty if
+// the outputs were exported before their types were recorded
+func readOutputTypes(file string) (map[string]cty.Type, error) {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return map[string]cty.Type{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("failed to read output types from %s: %w", file, err)
+	}
+	types := map[string]cty.Type{}
+	for name, r := range raw {
+		if types[name], err = ctyjson.UnmarshalType(r); err != nil {
+			return nil, fmt.Errorf("failed to read type of output %s from %s: %w", name, file, err)
+		}
+	}
+	return types, nil
+}
+
+// readOutputs reads the outputs exported by group, converted to the types of
+// the Terraform outputs, so that e.g. lists of network tags are not read as
+// tuples or maps of labels as objects
+func readOutputs(artifactsDir string, group config.GroupName) (map[string]cty.Value, error) {
+	values, err := modulereader.ReadHclAttributes(outputsFile(artifactsDir, group))
+	if err != nil {
+		return nil, err
+	}
+	types, err := readOutputTypes(outputTypesFile(artifactsDir, group))
+	if err != nil {
+		return nil, err
+	}
+	for name, ty := range types {
+		v, ok := values[name]
+		if !ok {
+			continue
+		}
+		if values[name], err = convert.Convert(v, ty); err != nil {
+			return nil, fmt.Errorf("output %s of group %s does not have its recorded type: %w", name, group, err)
+		}
+	}
+	return values, nil
+}
+
+// OutputsExported reports whether ExportOutputs has written the outputs of
+// group to artifactsDir
+func OutputsExported(artifactsDir string, group config.GroupName) bool {
+	_, err := os.Stat(outputsFile(artifactsDir, group))
+	return err == nil
 }
 
 // ExportOutputs will run terraform output and capture data needed for
-// subsequent deployment groups
-func ExportOutputs(tf *tfexec.Terraform, artifactsDir string, applyBehavior ApplyBehavior) error {
+// subsequent deployment groups. Targets, Terraform resource or module
+// addresses, limit the changes applied beforehand.
+func ExportOutputs(tf *tfexec.Terraform, artifactsDir string, applyBehavior ApplyBehavior, targets ...string) error {
 	thisGroup := config.GroupName(filepath.Base(tf.WorkingDir()))
 	filepath := outputsFile(artifactsDir, thisGroup)
 
-	outputValues, err := getOutputs(tf, applyBehavior)
+	outputValues, err := getOutputs(tf, applyBehavior, targets)
 	if err != nil {
 		return err
 	}
 
-	// TODO: confirm that outputValues has keys we would expect from the
-	// blueprint; edge case is that "terraform output" can be missing keys
-	// whose values are null
+	// "terraform output" leaves out outputs whose values are null; ImportInputs
+	// reports them if a later group needs them
 	if len(outputValues) == 0 {
 		log.Printf("group %s contains no artifacts to export", thisGroup)
 		return nil
@@ -278,6 +372,9 @@ func ExportOutputs(tf *tfexec.Terraform, artifactsDir string, applyBehavior Appl
 	if err := modulewriter.WriteHclAttributes(outputValues, filepath); err != nil {
 		return err
 	}
+	if err := writeOutputTypes(outputValues, outputTypesFile(artifactsDir, thisGroup)); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -292,6 +389,9 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 	if err != nil {
 		return err
 	}
+	if err := dc.Config.RestoreSensitiveVars(deploymentRoot); err != nil {
+		return err
+	}
 	g, err := dc.Config.Group(config.GroupName(filepath.Base(deploymentGroupDir)))
 	if err != nil {
 		return err
@@ -300,6 +400,10 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 	if err != nil {
 		return err
 	}
+	refs := map[string]config.Reference{}
+	for _, ref := range g.FindAllIntergroupReferences(dc.Config) {
+		refs[config.AutomaticOutputName(ref.Name, ref.Module)] = ref
+	}
 
 	// for each prior group, read all output values and filter for those needed
 	// as input values to this group; merge into a single map
@@ -309,14 +413,17 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 			continue
 		}
 		log.Printf("collecting outputs for group %s from group %s", g.Name, groupName)
-		filepath := outputsFile(artifactsDir, groupName)
-		groupOutputValues, err := modulereader.ReadHclAttributes(filepath)
+		groupOutputValues, err := readOutputs(artifactsDir, groupName)
 		if err != nil {
 			return &TfError{
 				help: fmt.Sprintf("consider running \"ghpc export-outputs %s/%s\"", deploymentRoot, groupName),
 				err:  err,
 			}
 		}
+		groupOutputValues, err = checkIntergroupOutputs(deploymentRoot, g.Name, groupName, intergroupOutputNames, groupOutputValues, refs)
+		if err != nil {
+			return err
+		}
 		intergroupValues := intersectMapKeys(intergroupOutputNames, groupOutputValues)
 		mergeMapsWithoutLoss(allInputValues, intergroupValues)
 	}
@@ -332,8 +439,7 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 	case config.PackerKind:
 		thisGroupIdx := dc.Config.GroupIndex(g.Name)
 		packerGroup := dc.Config.DeploymentGroups[thisGroupIdx]
-		// Packer groups are enforced to have length 1
-		packerModule := packerGroup.Modules[0]
+		packerModule := packerGroup.PackerModule()
 		moduleID := string(packerModule.ID)
 		outfile = filepath.Join(deploymentGroupDir, moduleID, fmt.Sprintf("%s_inputs.auto.pkrvars.hcl", moduleID))
 
@@ -356,7 +462,10 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 		if err != nil {
 			return err
 		}
-		allInputValues = evaluatedSettings.Items()
+		modulePath := filepath.Join(deploymentGroupDir, moduleID)
+		if allInputValues, err = convertPackerInputs(modulePath, evaluatedSettings.Items()); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unexpected error: unknown module kind for group %s", g.Name)
 	}
@@ -368,7 +477,116 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 	return nil
 }
 
+// missingOutputs returns the names of the outputs that are absent or null;
+// "terraform output" leaves out outputs whose values are null
+func missingOutputs(names []string, values map[string]cty.Value) []string {
+	missing := []string{}
+	for _, name := range names {
+		if v, ok := values[name]; !ok || v.IsNull() {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// checkIntergroupOutputs errors if outputs of the producer group that the
+// consumer group needs are missing or null, naming the module outputs rather
+// than letting Terraform prompt for unset variables. The exported outputs may
+// be stale, so missing outputs are first looked up in the Terraform state of
+// the producer group; the returned values include those found there.
+func checkIntergroupOutputs(deploymentRoot string, consumer config.GroupName, producer config.GroupName,
+	names []string, values map[string]cty.Value, refs map[string]config.Reference) (map[string]cty.Value, error) {
+	missing := missingOutputs(names, values)
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	producerDir := filepath.Join(deploymentRoot, string(producer))
+	if isDir, _ := DirInfo(producerDir); isDir {
+		live, err := liveOutputs(producerDir)
+		if err != nil {
+			log.Printf("WARNING: could not read the outputs of group %s from its Terraform state: %v", producer, err)
+		} else if stillMissing := missingOutputs(missing, live); len(stillMissing) > 0 {
+			missing = stillMissing
+		} else {
+			log.Printf("outputs exported by group %s are stale; using the outputs of its Terraform state", producer)
+			merged := maps.Clone(values)
+			maps.Copy(merged, intersectMapKeys(missing, live))
+			return merged, nil
+		}
+	}
+
+	descs := make([]string, len(missing))
+	for i, name := range missing {
+		if ref, ok := refs[name]; ok {
+			descs[i] = fmt.Sprintf("output %s of module %s", ref.Name, ref.Module)
+		} else {
+			descs[i] = fmt.Sprintf("output %s", name)
+		}
+	}
+	return nil, &TfError{
+		help: fmt.Sprintf("apply group %s, e.g. with \"ghpc deploy %s --only-group %s\", "+
+			"and check that its modules set these outputs", producer, deploymentRoot, producer),
+		err: fmt.Errorf("group %s needs outputs of group %s that are missing or null: %s",
+			consumer, producer, strings.Join(descs, ", ")),
+	}
+}
+
+// liveOutputs returns the outputs of the Terraform state of a group, as
+// "terraform output -json" reports them
+func liveOutputs(groupDir string) (map[string]cty.Value, error) {
+	tf, err := ConfigureTerraform(groupDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := initModule(tf); err != nil {
+		return nil, err
+	}
+	return outputModule(tf)
+}
+
+// StateResources returns the addresses of the managed resources recorded in
+// the Terraform state of the module working directory
+func StateResources(tf *tfexec.Terraform) ([]string, error) {
+	if err := initModule(tf); err != nil {
+		return nil, err
+	}
+	state, err := tf.Show(context.Background())
+	if err != nil {
+		return nil, &TfError{
+			help: fmt.Sprintf("reading the state of %s failed; manually resolve errors below", tf.WorkingDir()),
+			err:  err,
+		}
+	}
+	return managedResources(state), nil
+}
+
+// managedResources returns the addresses of the managed resources of a state
+// in all of its modules
+func managedResources(state *tfjson.State) []string {
+	if state == nil || state.Values == nil {
+		return nil
+	}
+	addresses := []string{}
+	var walk func(m *tfjson.StateModule)
+	walk = func(m *tfjson.StateModule) {
+		if m == nil {
+			return
+		}
+		for _, r := range m.Resources {
+			if r.Mode == tfjson.ManagedResourceMode {
+				addresses = append(addresses, r.Address)
+			}
+		}
+		for _, c := range m.ChildModules {
+			walk(c)
+		}
+	}
+	walk(state.Values.RootModule)
+	return addresses
+}
+
 // Destroy destroys all infrastructure in the module working directory
 func Destroy(tf *tfexec.Terraform, b ApplyBehavior) error {
-	return applyOrDestroy(tf, b, true)
+	return applyOrDestroy(tf, b, true, nil)
 }
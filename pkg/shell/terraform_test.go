@@ -18,10 +18,14 @@ package shell
 
 import (
 	"errors"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
 	"os"
 	"os/exec"
 	"testing"
 
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
 )
 
@@ -54,3 +58,72 @@ func (s *MySuite) TestFindTerraform(c *C) {
 	var tfe *TfError
 	c.Assert(errors.As(err, &tfe), Equals, true)
 }
+
+func (s *MySuite) TestReadOutputs(c *C) {
+	dir := c.MkDir()
+	outputs := map[string]cty.Value{
+		"network_tags": cty.ListVal([]cty.Value{cty.StringVal("ssh")}),
+		"labels":       cty.MapVal(map[string]cty.Value{"env": cty.StringVal("dev")}),
+		"subnetwork":   cty.NullVal(cty.String),
+	}
+	c.Assert(modulewriter.WriteHclAttributes(outputs, outputsFile(dir, "primary")), IsNil)
+
+	// outputs exported without their types are read as HCL literals
+	got, err := readOutputs(dir, "primary")
+	c.Assert(err, IsNil)
+	c.Check(got["network_tags"].Type().IsTupleType(), Equals, true)
+
+	c.Assert(writeOutputTypes(outputs, outputTypesFile(dir, "primary")), IsNil)
+	got, err = readOutputs(dir, "primary")
+	c.Assert(err, IsNil)
+	c.Check(got, HasLen, len(outputs))
+	for name, v := range outputs {
+		c.Check(got[name].RawEquals(v), Equals, true, Commentf("output %s", name))
+	}
+}
+
+func (s *MySuite) TestCheckIntergroupOutputs(c *C) {
+	root := c.MkDir()
+	refs := map[string]config.Reference{
+		"network_id_network1": config.ModuleRef("network1", "network_id"),
+		"subnetwork_network1": config.ModuleRef("network1", "subnetwork"),
+	}
+	names := []string{"network_id_network1", "subnetwork_network1"}
+
+	values := map[string]cty.Value{
+		"network_id_network1": cty.StringVal("net"),
+		"subnetwork_network1": cty.StringVal("subnet"),
+	}
+	got, err := checkIntergroupOutputs(root, "compute", "primary", names, values, refs)
+	c.Assert(err, IsNil)
+	c.Check(got, DeepEquals, values)
+
+	// the producer group has no working directory to look up its state in
+	values["subnetwork_network1"] = cty.NullVal(cty.String)
+	delete(values, "network_id_network1")
+	_, err = checkIntergroupOutputs(root, "compute", "primary", names, values, refs)
+	c.Assert(err, NotNil)
+	var tfe *TfError
+	c.Assert(errors.As(err, &tfe), Equals, true)
+	c.Check(tfe.err, ErrorMatches, "group compute needs outputs of group primary that are missing or null: "+
+		"output network_id of module network1, output subnetwork of module network1")
+}
+
+func (s *MySuite) TestManagedResources(c *C) {
+	c.Check(managedResources(nil), IsNil)
+	c.Check(managedResources(&tfjson.State{}), IsNil)
+
+	state := &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{
+		Resources: []*tfjson.StateResource{
+			{Address: "google_compute_instance.a", Mode: tfjson.ManagedResourceMode},
+			{Address: "data.google_compute_image.b", Mode: tfjson.DataResourceMode},
+		},
+		ChildModules: []*tfjson.StateModule{{
+			Resources: []*tfjson.StateResource{
+				{Address: "module.nodes.google_compute_instance.c", Mode: tfjson.ManagedResourceMode},
+			},
+		}},
+	}}}
+	c.Check(managedResources(state), DeepEquals, []string{
+		"google_compute_instance.a", "module.nodes.google_compute_instance.c"})
+}
@@ -0,0 +1,241 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// terraformReleases hosts the releases of Terraform
+const terraformReleases = "https://releases.hashicorp.com/terraform"
+
+// InstalledTerraformVersion returns the version of the terraform in PATH
+func InstalledTerraformVersion() (*version.Version, error) {
+	path, err := exec.LookPath("terraform")
+	if err != nil {
+		return nil, &TfError{
+			help: "must have a copy of terraform installed in PATH",
+			err:  err,
+		}
+	}
+	out, err := exec.Command(path, "version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s version failed: %w", path, err)
+	}
+	return parseToolVersion(string(out))
+}
+
+// CheckTerraformVersion errors if the terraform in PATH does not satisfy the
+// version constraint recorded in the manifest of a deployment
+func CheckTerraformVersion(constraint string) error {
+	c, err := version.NewConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	v, err := InstalledTerraformVersion()
+	if err != nil {
+		return err
+	}
+	if !c.Check(v) {
+		return fmt.Errorf("the deployment requires Terraform %s, but terraform in PATH is version %s; "+
+			"install a supported version or deploy with --install-terraform", constraint, v)
+	}
+	return nil
+}
+
+// InstallTerraform returns the directory of a terraform binary that satisfies
+// the constraint. The highest such release already installed in
+// cacheDir/terraform/VERSION is used; otherwise the highest release is
+// downloaded there from releases.hashicorp.com and verified against its
+// published SHA-256 checksum.
+func InstallTerraform(ctx context.Context, constraint string, cacheDir string) (string, error) {
+	c, err := version.NewConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	root := filepath.Join(cacheDir, "terraform")
+	if v := highestSatisfying(installedTerraformVersions(root), c); v != nil {
+		return filepath.Join(root, v.Original()), nil
+	}
+
+	releases, err := terraformReleaseVersions(ctx)
+	if err != nil {
+		return "", err
+	}
+	v := highestSatisfying(releases, c)
+	if v == nil {
+		return "", fmt.Errorf("no release of Terraform satisfies %s", constraint)
+	}
+	dir := filepath.Join(root, v.Original())
+	if err := downloadTerraform(ctx, v.Original(), dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// installedTerraformVersions lists the versions installed in root
+func installedTerraformVersions(root string) []*version.Version {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	vs := []*version.Version{}
+	for _, e := range entries {
+		v, err := version.NewVersion(e.Name())
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(root, e.Name(), terraformBinary())); err == nil {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// highestSatisfying returns the highest version that is not a pre-release and
+// satisfies c, or nil
+func highestSatisfying(vs []*version.Version, c version.Constraints) *version.Version {
+	sort.Sort(sort.Reverse(version.Collection(vs)))
+	for _, v := range vs {
+		if v.Prerelease() == "" && c.Check(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+func terraformReleaseVersions(ctx context.Context) ([]*version.Version, error) {
+	body, err := httpGet(ctx, terraformReleases+"/index.json")
+	if err != nil {
+		return nil, err
+	}
+	var index struct {
+		Versions map[string]json.RawMessage `json:"versions"`
+	}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("failed to decode the index of Terraform releases: %w", err)
+	}
+	vs := []*version.Version{}
+	for s := range index.Versions {
+		if v, err := version.NewVersion(s); err == nil {
+			vs = append(vs, v)
+		}
+	}
+	return vs, nil
+}
+
+// downloadTerraform installs the terraform binary of a release for the
+// current platform in dir
+func downloadTerraform(ctx context.Context, v string, dir string) error {
+	archive := fmt.Sprintf("terraform_%s_%s_%s.zip", v, runtime.GOOS, runtime.GOARCH)
+	sums, err := httpGet(ctx, fmt.Sprintf("%s/%s/terraform_%s_SHA256SUMS", terraformReleases, v, v))
+	if err != nil {
+		return err
+	}
+	want, err := releaseChecksum(sums, archive)
+	if err != nil {
+		return err
+	}
+	data, err := httpGet(ctx, fmt.Sprintf("%s/%s/%s", terraformReleases, v, archive))
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("the checksum of %s does not match its published SHA-256 checksum", archive)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", archive, err)
+	}
+	for _, f := range zr.File {
+		if f.Name != terraformBinary() {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		out, err := os.OpenFile(filepath.Join(dir, f.Name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	return fmt.Errorf("%s has no %s binary", archive, terraformBinary())
+}
+
+// releaseChecksum returns the checksum of a file in a SHA256SUMS file
+func releaseChecksum(sums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum is published for %s", name)
+}
+
+func terraformBinary() string {
+	if runtime.GOOS == "windows" {
+		return "terraform.exe"
+	}
+	return "terraform"
+}
+
+func httpGet(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
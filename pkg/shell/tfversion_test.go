@@ -0,0 +1,78 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-version"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestCheckTerraformVersion(c *C) {
+	dir := c.MkDir()
+	pathEnv := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", pathEnv)
+
+	script := "#!/bin/sh\necho Terraform v1.4.6\necho on linux_amd64\n"
+	c.Assert(os.WriteFile(filepath.Join(dir, "terraform"), []byte(script), 0755), IsNil)
+	c.Check(CheckTerraformVersion(">= 1.2"), IsNil)
+	c.Check(CheckTerraformVersion(">= 1.5"), ErrorMatches,
+		"the deployment requires Terraform >= 1.5, but terraform in PATH is version 1.4.6.*")
+	c.Check(CheckTerraformVersion("latest"), NotNil)
+}
+
+func (s *MySuite) TestHighestSatisfying(c *C) {
+	vs := []*version.Version{}
+	for _, s := range []string{"1.4.6", "1.6.0-beta1", "1.5.7", "1.2.9", "2.0.0"} {
+		vs = append(vs, version.Must(version.NewVersion(s)))
+	}
+	c.Check(highestSatisfying(vs, version.MustConstraints(version.NewConstraint(">= 1.2, < 2.0"))).String(), Equals, "1.5.7")
+	c.Check(highestSatisfying(vs, version.MustConstraints(version.NewConstraint("~> 1.4.0"))).String(), Equals, "1.4.6")
+	c.Check(highestSatisfying(vs, version.MustConstraints(version.NewConstraint(">= 3.0"))), IsNil)
+}
+
+func (s *MySuite) TestInstallTerraformFromCache(c *C) {
+	cacheDir := c.MkDir()
+	for _, v := range []string{"1.3.9", "1.5.7"} {
+		dir := filepath.Join(cacheDir, "terraform", v)
+		c.Assert(os.MkdirAll(dir, 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(dir, terraformBinary()), nil, 0755), IsNil)
+	}
+	// a directory without a binary is not an installation
+	c.Assert(os.MkdirAll(filepath.Join(cacheDir, "terraform", "1.6.0"), 0755), IsNil)
+
+	dir, err := InstallTerraform(nil, ">= 1.2", cacheDir)
+	c.Assert(err, IsNil)
+	c.Check(dir, Equals, filepath.Join(cacheDir, "terraform", "1.5.7"))
+
+	dir, err = InstallTerraform(nil, "~> 1.3.0", cacheDir)
+	c.Assert(err, IsNil)
+	c.Check(dir, Equals, filepath.Join(cacheDir, "terraform", "1.3.9"))
+}
+
+func (s *MySuite) TestReleaseChecksum(c *C) {
+	sums := []byte("aaaa  terraform_1.5.7_darwin_arm64.zip\nbbbb  terraform_1.5.7_linux_amd64.zip\n")
+	sum, err := releaseChecksum(sums, "terraform_1.5.7_linux_amd64.zip")
+	c.Assert(err, IsNil)
+	c.Check(sum, Equals, "bbbb")
+
+	_, err = releaseChecksum(sums, "terraform_1.5.7_windows_amd64.zip")
+	c.Check(err, ErrorMatches, "no checksum is published for .*")
+}
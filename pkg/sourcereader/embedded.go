@@ -16,6 +16,7 @@ package sourcereader
 
 import (
 	"fmt"
+	"hpc-toolkit/pkg/deploymentio"
 	"io/fs"
 	"io/ioutil"
 	"os"
@@ -39,17 +40,14 @@ type BaseFS interface {
 // EmbeddedSourceReader reads modules from a local directory
 type EmbeddedSourceReader struct{}
 
+// copyFileOut copies an embedded file, which is executable if it is a script,
+// as the embedded file system does not keep the modes of files
 func copyFileOut(bfs BaseFS, src string, dst string) error {
 	content, err := bfs.ReadFile(src)
 	if err != nil {
 		return fmt.Errorf("failed to read embedded %#v: %v", src, err)
 	}
-	f, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed to create %#v: %v", dst, err)
-	}
-	defer f.Close()
-	if _, err = f.Write(content); err != nil {
+	if err := deploymentio.WriteFile(dst, content, deploymentio.IsScript(content)); err != nil {
 		return fmt.Errorf("failed to write %#v: %v", dst, err)
 	}
 	return nil
@@ -69,7 +67,7 @@ func copyDirFromModules(bfs BaseFS, source string, dest string) error {
 		entrySource := path.Join(source, entryName)
 		entryDest := filepath.Join(dest, entryName)
 		if dirEntry.IsDir() {
-			if err := os.Mkdir(entryDest, 0755); err != nil {
+			if err := deploymentio.Mkdir(entryDest); err != nil {
 				return err
 			}
 			if err = copyDirFromModules(bfs, entrySource, entryDest); err != nil {
@@ -98,6 +98,23 @@ func (s *MySuite) TestCopyDirFromModules(c *C) {
 	c.Assert(err, ErrorMatches, "*file exists")
 }
 
+func (s *MySuite) TestCopyFileOutScript(c *C) {
+	aferoFS := afero.NewMemMapFs()
+	afero.WriteFile(aferoFS, "scripts/install.sh", []byte("#!/bin/bash\necho install\n"), 0644)
+	afero.WriteFile(aferoFS, "scripts/README.md", []byte("# scripts\n"), 0644)
+	testModFS := afero.NewIOFS(aferoFS)
+	copyDir := c.MkDir()
+
+	// the embedded file system has no modes; scripts are made executable
+	c.Assert(copyDirFromModules(testModFS, "scripts", copyDir), IsNil)
+	fInfo, err := os.Stat(filepath.Join(copyDir, "install.sh"))
+	c.Assert(err, IsNil)
+	c.Check(fInfo.Mode().Perm()&0100, Equals, os.FileMode(0100))
+	fInfo, err = os.Stat(filepath.Join(copyDir, "README.md"))
+	c.Assert(err, IsNil)
+	c.Check(fInfo.Mode().Perm()&0111, Equals, os.FileMode(0))
+}
+
 func (s *MySuite) TestCopyFSToTempDir(c *C) {
 	// Setup
 	testModFS := getTestFS()
@@ -17,9 +17,9 @@ package sourcereader
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-getter"
@@ -39,7 +39,7 @@ var goGetterDecompressors = map[string]getter.Decompressor{}
 // GitSourceReader reads modules from a git repository
 type GitSourceReader struct{}
 
-func copyGitModules(srcPath string, destPath string) error {
+func copyGitModules(ctx context.Context, srcPath string, destPath string) error {
 	client := getter.Client{
 		Src: srcPath,
 		Dst: destPath,
@@ -50,29 +50,75 @@ func copyGitModules(srcPath string, destPath string) error {
 		Detectors:     goGetterDetectors,
 		Decompressors: goGetterDecompressors,
 		Getters:       goGetterGetters,
-		Ctx:           context.Background(),
+		Ctx:           ctx,
 	}
 	err := client.Get()
 	return err
 }
 
-// GetModule copies the git source to a provided destination (the deployment directory)
-func (r GitSourceReader) GetModule(modPath string, copyPath string) error {
+// fetchedRepos holds the local clones of git repositories, keyed by the
+// source of the repository without a subdirectory, so that the modules of a
+// repository are cloned once however often they are read or copied
+var fetchedRepos = map[string]string{}
+var fetchedMu sync.Mutex
+
+// FetchGitModule clones the repository of a git source, unless it was already
+// cloned, and returns the local directory of the module. The clone is removed
+// if ctx is cancelled before it completes.
+func FetchGitModule(ctx context.Context, modPath string) (string, error) {
 	if !IsGitPath(modPath) {
-		return fmt.Errorf("Source is not valid: %s", modPath)
+		return "", fmt.Errorf("Source is not valid: %s", modPath)
+	}
+	repo, subDir := getter.SourceDirSubdir(modPath)
+	cloneDir, err := fetchGitRepo(ctx, repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to clone git module at %s: %v", modPath, err)
 	}
 
-	modDir, err := ioutil.TempDir("", "git-module-*")
-	defer os.RemoveAll(modDir)
-	writeDir := filepath.Join(modDir, "mod")
+	modDir := filepath.Join(cloneDir, subDir)
+	if info, err := os.Stat(modDir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("failed to clone git module at %s: %s is not a directory of %s",
+			modPath, subDir, repo)
+	}
+	return modDir, nil
+}
+
+// fetchGitRepo clones a git repository, a source without a subdirectory,
+// unless it was already cloned, and returns the directory of the clone
+func fetchGitRepo(ctx context.Context, repo string) (string, error) {
+	fetchedMu.Lock()
+	defer fetchedMu.Unlock()
+	if cloneDir, ok := fetchedRepos[repo]; ok {
+		return cloneDir, nil
+	}
+	tmpDir, err := os.MkdirTemp("", "git-module-*")
 	if err != nil {
-		return err
+		return "", err
+	}
+	cloneDir := filepath.Join(tmpDir, "repo")
+	if err := copyGitModules(ctx, repo, cloneDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("failed to clone %s to tmp dir %s: %v", repo, cloneDir, err)
 	}
+	fetchedRepos[repo] = cloneDir
+	return cloneDir, nil
+}
 
-	if err := copyGitModules(modPath, writeDir); err != nil {
-		return fmt.Errorf("failed to clone git module at %s to tmp dir %s: %v",
-			modPath, writeDir, err)
+// CleanupFetched removes the clones made by FetchGitModule
+func CleanupFetched() {
+	fetchedMu.Lock()
+	defer fetchedMu.Unlock()
+	for repo, dir := range fetchedRepos {
+		os.RemoveAll(filepath.Dir(dir))
+		delete(fetchedRepos, repo)
 	}
+}
 
-	return copyFromPath(writeDir, copyPath)
+// GetModule copies the git source to a provided destination (the deployment directory)
+func (r GitSourceReader) GetModule(modPath string, copyPath string) error {
+	modDir, err := FetchGitModule(context.Background(), modPath)
+	if err != nil {
+		return err
+	}
+	return copyFromPath(modDir, copyPath)
 }
@@ -15,9 +15,13 @@
 package sourcereader
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"testing"
 
 	. "gopkg.in/check.v1"
 )
@@ -31,7 +35,7 @@ func (s *MySuite) TestCopyGitModules(c *C) {
 
 	// Success via HTTPS
 	destDirForHTTPS := filepath.Join(destDir, "https")
-	err := copyGitModules("github.com/terraform-google-modules/terraform-google-project-factory//helpers", destDirForHTTPS)
+	err := copyGitModules(context.Background(), "github.com/terraform-google-modules/terraform-google-project-factory//helpers", destDirForHTTPS)
 	c.Assert(err, IsNil)
 	fInfo, err := os.Stat(filepath.Join(destDirForHTTPS, "terraform_validate"))
 	c.Assert(err, IsNil)
@@ -41,7 +45,7 @@ func (s *MySuite) TestCopyGitModules(c *C) {
 
 	// Success via HTTPS (Root directory)
 	destDirForHTTPSRootDir := filepath.Join(destDir, "https-rootdir")
-	err = copyGitModules("github.com/terraform-google-modules/terraform-google-service-accounts.git?ref=v4.1.1", destDirForHTTPSRootDir)
+	err = copyGitModules(context.Background(), "github.com/terraform-google-modules/terraform-google-service-accounts.git?ref=v4.1.1", destDirForHTTPSRootDir)
 	c.Assert(err, IsNil)
 	fInfo, err = os.Stat(filepath.Join(destDirForHTTPSRootDir, "main.tf"))
 	c.Assert(err, IsNil)
@@ -65,3 +69,112 @@ func (s *MySuite) TestGetModule_Git(c *C) {
 	expectedErr = "Source is not valid: .*"
 	c.Assert(err, ErrorMatches, expectedErr)
 }
+
+// makeGitRepo creates a local git repository holding numModules terraform
+// modules, modules/m0 to modules/m<numModules-1>
+func makeGitRepo(dir string, numModules int) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return err
+	}
+	for i := 0; i < numModules; i++ {
+		modDir := filepath.Join(dir, "modules", fmt.Sprintf("m%d", i))
+		if err := os.MkdirAll(modDir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(modDir, "main.tf"), []byte("variable \"name\" {}\n"), 0644); err != nil {
+			return err
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "modules"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	return nil
+}
+
+func (s *MySuite) TestFetchGitModule(c *C) {
+	repoDir := filepath.Join(testDir, "TestFetchGitModule")
+	if err := makeGitRepo(repoDir, 2); err != nil {
+		c.Skip(err.Error())
+	}
+	defer CleanupFetched()
+
+	m0, err := FetchGitModule(context.Background(), "git::file://"+repoDir+"//modules/m0")
+	c.Assert(err, IsNil)
+	_, err = os.Stat(filepath.Join(m0, "main.tf"))
+	c.Assert(err, IsNil)
+
+	// the modules of a repository share its clone
+	m1, err := FetchGitModule(context.Background(), "git::file://"+repoDir+"//modules/m1")
+	c.Assert(err, IsNil)
+	c.Check(filepath.Dir(m1), Equals, filepath.Dir(m0))
+	c.Check(fetchedRepos, HasLen, 1)
+
+	_, err = FetchGitModule(context.Background(), "git::file://"+repoDir+"//modules/missing")
+	c.Check(err, ErrorMatches, ".*modules/missing is not a directory.*")
+
+	// clones aborted by a cancelled context are not kept
+	other := filepath.Join(testDir, "TestFetchGitModuleCancelled")
+	c.Assert(makeGitRepo(other, 1), IsNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = FetchGitModule(ctx, "git::file://"+other+"//modules/m0")
+	c.Check(err, NotNil)
+	c.Check(fetchedRepos, HasLen, 1)
+
+	CleanupFetched()
+	c.Check(fetchedRepos, HasLen, 0)
+	_, err = os.Stat(m0)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+// BenchmarkGetModule_Git copies the 50 modules of a repository into a
+// deployment, as ghpc create does after reading them, by cloning the
+// repository for every read and copy and by reusing a single clone
+func BenchmarkGetModule_Git(b *testing.B) {
+	const numModules = 50
+	repoDir := b.TempDir()
+	if err := makeGitRepo(repoDir, numModules); err != nil {
+		b.Skip(err)
+	}
+	source := func(i int) string {
+		return fmt.Sprintf("git::file://%s//modules/m%d", repoDir, i)
+	}
+
+	b.Run("clone_per_module", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			dst := b.TempDir()
+			for i := 0; i < numModules; i++ {
+				// once to read the module and once to copy it
+				if err := copyGitModules(context.Background(), source(i), filepath.Join(dst, "read", fmt.Sprint(i))); err != nil {
+					b.Fatal(err)
+				}
+				if err := copyGitModules(context.Background(), source(i), filepath.Join(dst, "copy", fmt.Sprint(i))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("fetch_once", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			dst := b.TempDir()
+			for i := 0; i < numModules; i++ {
+				if _, err := FetchGitModule(context.Background(), source(i)); err != nil {
+					b.Fatal(err)
+				}
+				if err := (GitSourceReader{}).GetModule(source(i), filepath.Join(dst, fmt.Sprint(i))); err != nil {
+					b.Fatal(err)
+				}
+			}
+			CleanupFetched()
+		}
+	})
+}
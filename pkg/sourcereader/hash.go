@@ -0,0 +1,169 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// prefixes of the source hashes that pin the contents of module sources
+const (
+	sha256HashPrefix = "sha256:"
+	gitHashPrefix    = "git:"
+)
+
+var sha256HashExp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+var gitCommitExp = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// CheckSourceHash errors if a source hash is not one of sha256:<hex>, the
+// hash of the files of a module computed by HashModule, or git:<commit>, the
+// commit of a git source
+func CheckSourceHash(source string, hash string) error {
+	switch {
+	case strings.HasPrefix(hash, sha256HashPrefix):
+		if !sha256HashExp.MatchString(strings.TrimPrefix(hash, sha256HashPrefix)) {
+			return fmt.Errorf("source hash %q must be sha256: followed by 64 lowercase hexadecimal digits", hash)
+		}
+	case strings.HasPrefix(hash, gitHashPrefix):
+		if !IsGitPath(source) {
+			return fmt.Errorf("source hash %q pins a git commit, but %s is not a git source", hash, source)
+		}
+		if !gitCommitExp.MatchString(strings.TrimPrefix(hash, gitHashPrefix)) {
+			return fmt.Errorf("source hash %q must be git: followed by a commit hash of 7 to 40 lowercase hexadecimal digits", hash)
+		}
+	default:
+		return fmt.Errorf("unsupported source hash %q, must be sha256:<hash of the module files> or git:<commit>", hash)
+	}
+	return nil
+}
+
+// VerifySourceHash reads the module at source, cloning git sources, and
+// errors if it does not match the source hash
+func VerifySourceHash(ctx context.Context, source string, hash string) error {
+	if err := CheckSourceHash(source, hash); err != nil {
+		return err
+	}
+	var fsys fs.FS
+	root := "."
+	switch {
+	case IsGitPath(source):
+		dir, err := FetchGitModule(ctx, source)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(hash, gitHashPrefix) {
+			return verifyGitCommit(source, dir, strings.TrimPrefix(hash, gitHashPrefix))
+		}
+		fsys = os.DirFS(dir)
+	case IsEmbeddedPath(source):
+		fsys, root = ModuleFS, path.Clean(source)
+	case IsLocalPath(source):
+		fsys = os.DirFS(source)
+	default:
+		return fmt.Errorf("Source is not valid: %s", source)
+	}
+
+	got, err := HashModule(fsys, root)
+	if err != nil {
+		return fmt.Errorf("failed to hash module source %s: %w", source, err)
+	}
+	if got != hash {
+		return fmt.Errorf("module source %s has the hash %s, not %s", source, got, hash)
+	}
+	return nil
+}
+
+func verifyGitCommit(source string, dir string, commit string) error {
+	head, err := gitHead(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read the commit of %s: %w", source, err)
+	}
+	if !strings.HasPrefix(head, commit) {
+		return fmt.Errorf("module source %s is at commit %s, not %s", source, head, commit)
+	}
+	return nil
+}
+
+// gitHead returns the commit checked out in the repository holding dir
+func gitHead(dir string) (string, error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+// HashModule returns the source hash of the files of the module at root in
+// fsys: the SHA-256 of their sorted paths, relative to root, and of the
+// SHA-256 of their contents. git metadata is left out.
+func HashModule(fsys fs.FS, root string) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		rel := p
+		if root != "." {
+			rel = strings.TrimPrefix(p, root+"/")
+		}
+		fmt.Fprintf(h, "%s\x00%x\n", rel, sha256.Sum256(content))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return sha256HashPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PinnedSource returns a git source that selects the commit of a git: source
+// hash, so that the module Terraform installs is the one that was verified.
+// Other sources are returned unchanged.
+func PinnedSource(source string, hash string) string {
+	if !IsGitPath(source) || !strings.HasPrefix(hash, gitHashPrefix) {
+		return source
+	}
+	base, rawQuery, _ := strings.Cut(source, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return source
+	}
+	query.Set("ref", strings.TrimPrefix(hash, gitHashPrefix))
+	return base + "?" + query.Encode()
+}
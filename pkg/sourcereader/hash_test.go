@@ -0,0 +1,91 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestCheckSourceHash(c *C) {
+	sha := "sha256:" + strings.Repeat("ab", 32)
+	c.Check(CheckSourceHash("./mod", sha), IsNil)
+	c.Check(CheckSourceHash("github.com/x/y", "git:0a1b2c3"), IsNil)
+
+	c.Check(CheckSourceHash("./mod", "sha256:AB"), ErrorMatches, ".*64 lowercase hexadecimal digits")
+	c.Check(CheckSourceHash("./mod", "git:0a1b2c3"), ErrorMatches, ".*not a git source")
+	c.Check(CheckSourceHash("github.com/x/y", "git:main"), ErrorMatches, ".*7 to 40 lowercase hexadecimal digits")
+	c.Check(CheckSourceHash("./mod", "sigstore:bundle.json"), ErrorMatches, "unsupported source hash.*")
+}
+
+func (s *MySuite) TestHashModule(c *C) {
+	fsys := afero.NewMemMapFs()
+	afero.WriteFile(fsys, "mod/main.tf", []byte("main"), 0644)
+	afero.WriteFile(fsys, "mod/sub/variables.tf", []byte("variables"), 0644)
+	afero.WriteFile(fsys, "mod/.git/HEAD", []byte("ref: refs/heads/main"), 0644)
+	h, err := HashModule(afero.NewIOFS(fsys), "mod")
+	c.Assert(err, IsNil)
+	c.Check(h, Matches, "sha256:[0-9a-f]{64}")
+
+	// the hash does not depend on the location of the module nor on git
+	// metadata
+	other := afero.NewMemMapFs()
+	afero.WriteFile(other, "main.tf", []byte("main"), 0644)
+	afero.WriteFile(other, "sub/variables.tf", []byte("variables"), 0644)
+	o, err := HashModule(afero.NewIOFS(other), ".")
+	c.Assert(err, IsNil)
+	c.Check(o, Equals, h)
+
+	afero.WriteFile(other, "sub/variables.tf", []byte("edited"), 0644)
+	o, err = HashModule(afero.NewIOFS(other), ".")
+	c.Assert(err, IsNil)
+	c.Check(o, Not(Equals), h)
+}
+
+func (s *MySuite) TestVerifySourceHash(c *C) {
+	ctx := context.Background()
+	h, err := HashModule(os.DirFS(terraformDir), ".")
+	c.Assert(err, IsNil)
+	c.Check(VerifySourceHash(ctx, terraformDir, h), IsNil)
+
+	bad := "sha256:" + strings.Repeat("0", 64)
+	c.Check(VerifySourceHash(ctx, terraformDir, bad), ErrorMatches, ".* has the hash "+h+", not "+bad)
+
+	repoDir := filepath.Join(testDir, "TestVerifySourceHash")
+	if err := makeGitRepo(repoDir, 1); err != nil {
+		c.Skip(err.Error())
+	}
+	defer CleanupFetched()
+	source := "git::file://" + repoDir + "//modules/m0"
+	dir, err := FetchGitModule(ctx, source)
+	c.Assert(err, IsNil)
+	commit, err := gitHead(dir)
+	c.Assert(err, IsNil)
+	c.Check(VerifySourceHash(ctx, source, "git:"+commit), IsNil)
+	c.Check(VerifySourceHash(ctx, source, "git:"+commit[:7]), IsNil)
+	c.Check(VerifySourceHash(ctx, source, "git:0000000"), ErrorMatches, ".* is at commit "+commit+", not 0000000")
+}
+
+func (s *MySuite) TestPinnedSource(c *C) {
+	c.Check(PinnedSource("github.com/x/y//mod", "git:0a1b2c3"), Equals, "github.com/x/y//mod?ref=0a1b2c3")
+	c.Check(PinnedSource("github.com/x/y//mod?ref=v1&depth=1", "git:0a1b2c3"), Equals, "github.com/x/y//mod?depth=1&ref=0a1b2c3")
+	c.Check(PinnedSource("github.com/x/y//mod?ref=v1", ""), Equals, "github.com/x/y//mod?ref=v1")
+	c.Check(PinnedSource("./mod", "git:0a1b2c3"), Equals, "./mod")
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-getter"
+	"github.com/otiai10/copy"
+)
+
+// VendorPath returns the directory of the copy of the repository of a git
+// source at its ref, relative to a vendor directory, and the subdirectory of
+// the module in it, e.g. github.com/org/repo@v1.0 and modules/network/vpc for
+// github.com/org/repo//modules/network/vpc?ref=v1.0
+func VendorPath(source string) (string, string, error) {
+	if !IsGitPath(source) {
+		return "", "", fmt.Errorf("Source is not valid: %s", source)
+	}
+	repo, subDir := getter.SourceDirSubdir(strings.TrimPrefix(source, "git::"))
+	base, rawQuery, _ := strings.Cut(repo, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid query of git source %s: %w", source, err)
+	}
+
+	// https://github.com/org/repo.git, git@github.com:org/repo.git and
+	// github.com/org/repo are all vendored in github.com/org/repo
+	if _, rest, found := strings.Cut(base, "://"); found {
+		base = rest
+	}
+	if user, rest, found := strings.Cut(base, "@"); found && !strings.Contains(user, "/") {
+		base = strings.Replace(rest, ":", "/", 1)
+	}
+	dir := strings.Trim(path.Clean("/"+strings.TrimSuffix(base, ".git")), "/")
+	if ref := query.Get("ref"); ref != "" {
+		dir += "@" + strings.ReplaceAll(ref, "/", "-")
+	}
+	subDir = path.Clean("/" + subDir)[1:]
+	if dir == "" {
+		return "", "", fmt.Errorf("cannot vendor git source %s", source)
+	}
+	return dir, subDir, nil
+}
+
+// VendorGitModule copies the repository of a git source, without its git
+// metadata, to its VendorPath in vendorDir, unless it is there already, and
+// returns the directory of the module in the copy. The whole repository is
+// copied, so that modules may keep referring to the modules next to them.
+func VendorGitModule(ctx context.Context, source string, vendorDir string) (string, error) {
+	repoPath, subDir, err := VendorPath(source)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(vendorDir, filepath.FromSlash(repoPath))
+	modDir := filepath.Join(dst, filepath.FromSlash(subDir))
+
+	if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+		repo, _ := getter.SourceDirSubdir(source)
+		cloneDir, err := fetchGitRepo(ctx, repo)
+		if err != nil {
+			return "", fmt.Errorf("failed to vendor git module at %s: %v", source, err)
+		}
+		// a partial copy would be taken for a vendored repository
+		tmp := dst + ".tmp"
+		os.RemoveAll(tmp)
+		err = copy.Copy(cloneDir, tmp, copy.Options{
+			Skip: func(info os.FileInfo, src, dest string) (bool, error) {
+				return info.IsDir() && info.Name() == ".git", nil
+			},
+		})
+		if err == nil {
+			err = os.Rename(tmp, dst)
+		}
+		if err != nil {
+			os.RemoveAll(tmp)
+			return "", fmt.Errorf("failed to vendor git module at %s to %s: %w", source, dst, err)
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(modDir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("failed to vendor git module at %s: %s is not a directory of %s", source, subDir, dst)
+	}
+	return modDir, nil
+}
+
+// VendorSource vendors the module of a git source with VendorGitModule and
+// returns its local source and the source hash that pins it. A source hash
+// of the git source is verified; git:<commit> hashes are verified before
+// vendoring, as the copy has no git metadata, and are replaced by the hash of
+// the files of the copy.
+func VendorSource(ctx context.Context, source string, hash string, vendorDir string) (string, string, error) {
+	isCommit := strings.HasPrefix(hash, gitHashPrefix)
+	if isCommit {
+		if err := VerifySourceHash(ctx, source, hash); err != nil {
+			return "", "", err
+		}
+	}
+	modDir, err := VendorGitModule(ctx, source, vendorDir)
+	if err != nil {
+		return "", "", err
+	}
+	local, err := LocalSource(modDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case hash == "":
+		return local, "", nil
+	case isCommit:
+		localHash, err := HashModule(os.DirFS(modDir), ".")
+		if err != nil {
+			return "", "", err
+		}
+		return local, localHash, nil
+	default:
+		if err := VerifySourceHash(ctx, local, hash); err != nil {
+			return "", "", err
+		}
+		return local, hash, nil
+	}
+}
+
+// LocalSource returns the local source of a module directory: its path
+// relative to the working directory, which local sources of blueprints are
+// relative to, or its absolute path if it has none
+func LocalSource(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(wd, abs)
+	switch {
+	case err != nil:
+		return abs, nil
+	case strings.HasPrefix(rel, "../"):
+		return rel, nil
+	default:
+		return "./" + rel, nil
+	}
+}
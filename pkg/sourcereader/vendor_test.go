@@ -0,0 +1,97 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestVendorPath(c *C) {
+	type test struct {
+		source string
+		repo   string
+		subDir string
+	}
+	for _, t := range []test{
+		{"github.com/org/repo//modules/network/vpc?ref=v1.0", "github.com/org/repo@v1.0", "modules/network/vpc"},
+		{"github.com/org/repo", "github.com/org/repo", ""},
+		{"git::https://github.com/org/repo.git//modules/vpc?ref=feature/x", "github.com/org/repo@feature-x", "modules/vpc"},
+		{"git@github.com:org/repo.git//modules/vpc", "github.com/org/repo", "modules/vpc"},
+		{"git::ssh://git@example.com/org/repo.git?ref=v2", "example.com/org/repo@v2", ""},
+		{"git::file:///src/../repo//modules/m0", "repo", "modules/m0"},
+	} {
+		repo, subDir, err := VendorPath(t.source)
+		c.Check(err, IsNil, Commentf("source %s", t.source))
+		c.Check(repo, Equals, t.repo, Commentf("source %s", t.source))
+		c.Check(subDir, Equals, t.subDir, Commentf("source %s", t.source))
+	}
+
+	_, _, err := VendorPath("./modules/vpc")
+	c.Check(err, ErrorMatches, "Source is not valid: .*")
+}
+
+func (s *MySuite) TestVendorSource(c *C) {
+	ctx := context.Background()
+	repoDir := filepath.Join(testDir, "TestVendorSource")
+	if err := makeGitRepo(repoDir, 2); err != nil {
+		c.Skip(err.Error())
+	}
+	defer CleanupFetched()
+	vendorDir := filepath.Join(testDir, "TestVendorSourceVendor")
+	source := func(m string) string { return "git::file://" + repoDir + "//modules/" + m }
+	repoPath, _, err := VendorPath(source("m0"))
+	c.Assert(err, IsNil)
+	vendored := filepath.Join(vendorDir, repoPath)
+
+	// the whole repository is copied, without its git metadata
+	local, hash, err := VendorSource(ctx, source("m0"), "", vendorDir)
+	c.Assert(err, IsNil)
+	c.Check(hash, Equals, "")
+	abs, err := filepath.Abs(local)
+	c.Assert(err, IsNil)
+	c.Check(abs, Equals, filepath.Join(vendored, "modules", "m0"))
+	_, err = os.Stat(filepath.Join(vendored, "modules", "m1", "main.tf"))
+	c.Check(err, IsNil)
+	_, err = os.Stat(filepath.Join(vendored, ".git"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// commit hashes are replaced by the hashes of the vendored files
+	dir, err := FetchGitModule(ctx, source("m1"))
+	c.Assert(err, IsNil)
+	commit, err := gitHead(dir)
+	c.Assert(err, IsNil)
+	local, hash, err = VendorSource(ctx, source("m1"), "git:"+commit, vendorDir)
+	c.Assert(err, IsNil)
+	want, err := HashModule(os.DirFS(filepath.Join(vendored, "modules", "m1")), ".")
+	c.Assert(err, IsNil)
+	c.Check(hash, Equals, want)
+	c.Check(VerifySourceHash(ctx, local, hash), IsNil)
+
+	// hashes of files are verified on the vendored copy
+	_, hash, err = VendorSource(ctx, source("m1"), want, vendorDir)
+	c.Check(err, IsNil)
+	c.Check(hash, Equals, want)
+	bad := "sha256:" + strings.Repeat("0", 64)
+	_, _, err = VendorSource(ctx, source("m1"), bad, vendorDir)
+	c.Check(err, ErrorMatches, ".* has the hash "+want+", not "+bad)
+
+	_, _, err = VendorSource(ctx, source("missing"), "", vendorDir)
+	c.Check(err, ErrorMatches, ".*modules/missing is not a directory.*")
+}
@@ -0,0 +1,151 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"golang.org/x/exp/slices"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+const batchAPI = "batch.googleapis.com"
+
+// roles that include the permissions of the Batch service agent
+var batchServiceAgentRoles = []string{"roles/batch.serviceAgent", "roles/editor", "roles/owner"}
+
+// roles that allow the VMs of Batch jobs to report their state to Batch
+var batchAgentReporterRoles = []string{"roles/batch.agentReporter", "roles/batch.admin", "roles/editor", "roles/owner"}
+
+// roles that allow the VMs of Batch jobs to write their logs to Cloud Logging
+var logWriterRoles = []string{"roles/logging.logWriter", "roles/logging.admin", "roles/editor", "roles/owner"}
+
+const batchServiceAgentMsg = "module %s runs Batch jobs, but the Batch service agent %s is not granted roles/batch.serviceAgent in project %s; jobs would fail to create their VMs"
+const batchAgentReporterMsg = "module %s runs Batch jobs as service account %s, which is not granted roles/batch.agentReporter in project %s; jobs would never leave the SCHEDULED state"
+const batchLogWriterMsg = "WARNING: module %s runs Batch jobs as service account %s, which is not granted roles/logging.logWriter in project %s; the logs of its jobs would be lost"
+const batchUnverifiedMsg = "WARNING: the Batch permissions of module %s could not be verified: %v"
+const batchError = "one or more modules run Batch jobs that would fail after they are submitted"
+
+// BatchModule is a module that submits Cloud Batch jobs
+type BatchModule struct {
+	Module    string
+	ProjectID string
+	// ServiceAccount runs the VMs of the jobs; DefaultComputeServiceAccount
+	// stands for the Compute Engine default service account of the project.
+	// Its roles are not checked if it is empty.
+	ServiceAccount string
+}
+
+// TestBatchPermissions errors if the Batch API is disabled in the project of a
+// module that submits Batch jobs, if the Batch service agent of the project
+// is not granted its role or if the service account of the VMs of the jobs is
+// not granted roles/batch.agentReporter. These failures are only reported by
+// Batch once a job is submitted. Roles granted through groups, folders or
+// organizations are not considered.
+func TestBatchPermissions(ctx context.Context, modules []BatchModule) error {
+	crm, err := cloudresourcemanager.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+	numbers := map[string]int64{}
+	policies := map[string]map[string][]string{}
+	apis := map[string]error{}
+
+	errored := false
+	for _, m := range modules {
+		if _, ok := apis[m.ProjectID]; !ok {
+			apis[m.ProjectID] = TestApisEnabled(ctx, m.ProjectID, []string{batchAPI})
+			if apis[m.ProjectID] != nil {
+				log.Print(apis[m.ProjectID])
+				errored = true
+			}
+		}
+
+		n, ok := numbers[m.ProjectID]
+		if !ok {
+			p, err := crm.Projects.Get(m.ProjectID).Context(ctx).Do()
+			if err != nil {
+				log.Printf(batchUnverifiedMsg, m.Module, fmt.Errorf(projectError, m.ProjectID))
+				continue
+			}
+			n = p.ProjectNumber
+			numbers[m.ProjectID] = n
+		}
+		roles, ok := policies[m.ProjectID]
+		if !ok {
+			if roles, err = memberRoles(ctx, crm, m.ProjectID); err != nil {
+				log.Printf(batchUnverifiedMsg, m.Module, err)
+				continue
+			}
+			policies[m.ProjectID] = roles
+		}
+
+		agent := fmt.Sprintf("service-%d@gcp-sa-cloudbatch.iam.gserviceaccount.com", n)
+		if !hasAnyRole(roles, agent, batchServiceAgentRoles) {
+			log.Printf(batchServiceAgentMsg, m.Module, agent, m.ProjectID)
+			errored = true
+		}
+		sa := m.ServiceAccount
+		if sa == "" {
+			continue
+		}
+		if sa == DefaultComputeServiceAccount {
+			sa = fmt.Sprintf("%d-compute@developer.gserviceaccount.com", n)
+		}
+		if !hasAnyRole(roles, sa, batchAgentReporterRoles) {
+			log.Printf(batchAgentReporterMsg, m.Module, sa, m.ProjectID)
+			errored = true
+		}
+		if !hasAnyRole(roles, sa, logWriterRoles) {
+			log.Printf(batchLogWriterMsg, m.Module, sa, m.ProjectID)
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(batchError)
+	}
+	return nil
+}
+
+// memberRoles returns the roles granted unconditionally in the IAM policy of
+// a project, by member
+func memberRoles(ctx context.Context, crm *cloudresourcemanager.Service, projectID string) (map[string][]string, error) {
+	p, err := crm.Projects.GetIamPolicy(projectID, &cloudresourcemanager.GetIamPolicyRequest{}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the IAM policy of project %s: %w", projectID, err)
+	}
+	roles := map[string][]string{}
+	for _, b := range p.Bindings {
+		if b.Condition != nil {
+			continue
+		}
+		for _, member := range b.Members {
+			roles[member] = append(roles[member], b.Role)
+		}
+	}
+	return roles, nil
+}
+
+// hasAnyRole returns true if the service account is granted one of the roles
+func hasAnyRole(roles map[string][]string, sa string, any []string) bool {
+	for _, r := range roles["serviceAccount:"+sa] {
+		if slices.Contains(any, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// cmekKeyName matches the names of Cloud KMS keys, optionally followed by a
+// key version; the groups are the project, location, key ring and key
+var cmekKeyName = regexp.MustCompile(`^(?://cloudkms\.googleapis\.com/)?projects/([^/]+)/locations/([^/]+)/keyRings/([^/]+)/cryptoKeys/([^/]+)(?:/cryptoKeyVersions/[^/]+)?$`)
+
+// roles that allow a service agent to encrypt and decrypt with a key
+var cmekRoles = []string{"roles/cloudkms.cryptoKeyEncrypterDecrypter", "roles/owner"}
+
+const cmekMsg = "module %s would fail to encrypt its resources with key %s: %s"
+const cmekUnverifiedMsg = "WARNING: the key %s of module %s could not be verified: %v"
+const cmekError = "one or more modules set customer-managed encryption keys that their resources cannot use"
+
+// CMEKModule is a module that encrypts its disks or Filestore instance with a
+// customer-managed Cloud KMS key
+type CMEKModule struct {
+	Module    string
+	ProjectID string
+	// Region of the resources of the module; the location of the key is not
+	// checked if it is empty
+	Region string
+	// Key is the name of the key,
+	// projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY
+	Key string
+	// Filestore is true if the key encrypts a Filestore instance rather than
+	// Compute Engine disks
+	Filestore bool
+}
+
+// serviceAgent returns the service agent that uses the key of the module on
+// behalf of a project
+func (m CMEKModule) serviceAgent(projectNumber int64) string {
+	if m.Filestore {
+		return fmt.Sprintf("service-%d@cloud-filer.iam.gserviceaccount.com", projectNumber)
+	}
+	return fmt.Sprintf("service-%d@compute-system.iam.gserviceaccount.com", projectNumber)
+}
+
+// TestCMEKKeys errors if the customer-managed key of a module does not exist,
+// is not an enabled encryption key, is not in the region of the resources of
+// the module, or is not usable by the Compute Engine or Filestore service
+// agent of the project of the module. Compute Engine also accepts global keys;
+// Filestore does not. These failures are only reported once the resources are
+// created. Roles granted through groups, folders or organizations are not
+// considered.
+func TestCMEKKeys(ctx context.Context, modules []CMEKModule) error {
+	var f Findings
+	defer f.Log()
+
+	kms, err := cloudkms.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+	crm, err := cloudresourcemanager.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+	numbers := map[string]int64{}
+	policies := map[string]map[string][]string{}
+
+	errored := false
+	for _, m := range modules {
+		match := cmekKeyName.FindStringSubmatch(m.Key)
+		if match == nil {
+			f.Printf(m.Module, cmekMsg, m.Module, m.Key,
+				"the key is not of the form projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY")
+			errored = true
+			continue
+		}
+		keyProject, location, ring, key := match[1], match[2], match[3], match[4]
+		ringName := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s", keyProject, location, ring)
+		keyName := fmt.Sprintf("%s/cryptoKeys/%s", ringName, key)
+
+		if m.Region != "" && location != m.Region && (m.Filestore || location != "global") {
+			f.Printf(m.Module, cmekMsg, m.Module, keyName, fmt.Sprintf(
+				"the key is in location %s, but the resources of the module are in region %s", location, m.Region))
+			errored = true
+		}
+
+		ck, err := kms.Projects.Locations.KeyRings.CryptoKeys.Get(keyName).Context(ctx).Do()
+		var herr *googleapi.Error
+		if errors.As(err, &herr) && herr.Code == http.StatusNotFound {
+			f.Printf(m.Module, cmekMsg, m.Module, keyName, "the key does not exist")
+			errored = true
+			continue
+		} else if err != nil {
+			f.Printf(m.Module, cmekUnverifiedMsg, keyName, m.Module, err)
+			continue
+		}
+		if ck.Purpose != "ENCRYPT_DECRYPT" {
+			f.Printf(m.Module, cmekMsg, m.Module, keyName, fmt.Sprintf("the key has purpose %s rather than ENCRYPT_DECRYPT", ck.Purpose))
+			errored = true
+		}
+		if ck.Primary != nil && ck.Primary.State != "ENABLED" {
+			f.Printf(m.Module, cmekMsg, m.Module, keyName, fmt.Sprintf("the primary version of the key is %s", ck.Primary.State))
+			errored = true
+		}
+
+		n, ok := numbers[m.ProjectID]
+		if !ok {
+			p, err := crm.Projects.Get(m.ProjectID).Context(ctx).Do()
+			if err != nil {
+				f.Printf(m.Module, cmekUnverifiedMsg, keyName, m.Module, fmt.Errorf(projectError, m.ProjectID))
+				continue
+			}
+			n = p.ProjectNumber
+			numbers[m.ProjectID] = n
+		}
+		agent := m.serviceAgent(n)
+
+		granted, err := agentGranted(ctx, kms, crm, policies, agent, keyProject, []string{keyName, ringName})
+		if err != nil {
+			f.Printf(m.Module, cmekUnverifiedMsg, keyName, m.Module, err)
+			continue
+		}
+		if !granted {
+			f.Printf(m.Module, cmekMsg, m.Module, keyName, fmt.Sprintf(
+				"the service agent %s is not granted roles/cloudkms.cryptoKeyEncrypterDecrypter on the key", agent))
+			errored = true
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(cmekError)
+	}
+	return nil
+}
+
+// agentGranted returns true if the service agent is granted a CMEK role on
+// one of the keys or key rings, or on the project of the key; policies caches
+// the roles of the IAM policies by resource
+func agentGranted(ctx context.Context, kms *cloudkms.Service, crm *cloudresourcemanager.Service,
+	policies map[string]map[string][]string, agent string, keyProject string, resources []string) (bool, error) {
+	for _, resource := range resources {
+		roles, ok := policies[resource]
+		if !ok {
+			var err error
+			if roles, err = kmsMemberRoles(ctx, kms, resource); err != nil {
+				return false, err
+			}
+			policies[resource] = roles
+		}
+		if hasAnyRole(roles, agent, cmekRoles) {
+			return true, nil
+		}
+	}
+	project := "projects/" + keyProject
+	roles, ok := policies[project]
+	if !ok {
+		var err error
+		if roles, err = memberRoles(ctx, crm, keyProject); err != nil {
+			return false, err
+		}
+		policies[project] = roles
+	}
+	return hasAnyRole(roles, agent, cmekRoles), nil
+}
+
+// kmsMemberRoles returns the roles granted unconditionally in the IAM policy
+// of a key or key ring, by member
+func kmsMemberRoles(ctx context.Context, kms *cloudkms.Service, resource string) (map[string][]string, error) {
+	var p *cloudkms.Policy
+	var err error
+	if cmekKeyName.MatchString(resource) {
+		p, err = kms.Projects.Locations.KeyRings.CryptoKeys.GetIamPolicy(resource).Context(ctx).Do()
+	} else {
+		p, err = kms.Projects.Locations.KeyRings.GetIamPolicy(resource).Context(ctx).Do()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the IAM policy of %s: %w", resource, err)
+	}
+	roles := map[string][]string{}
+	for _, b := range p.Bindings {
+		if b.Condition != nil {
+			continue
+		}
+		for _, member := range b.Members {
+			roles[member] = append(roles[member], b.Role)
+		}
+	}
+	return roles, nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	artifactregistry "google.golang.org/api/artifactregistry/v1"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultComputeServiceAccount stands for the Compute Engine default service
+// account of the project of a module
+const DefaultComputeServiceAccount = "default"
+
+const artifactRegistrySuffix = "-docker.pkg.dev"
+
+// roles that allow pulling images from Artifact Registry
+var imagePullRoles = []string{
+	"roles/artifactregistry.reader",
+	"roles/artifactregistry.writer",
+	"roles/artifactregistry.repoAdmin",
+	"roles/artifactregistry.admin",
+	"roles/viewer",
+	"roles/editor",
+	"roles/owner",
+}
+
+const imageMissingMsg = "module %s uses container image %s, which does not exist"
+const imagePullMsg = "module %s uses container image %s, but service account %s is not granted a role that can pull it on repository %s or project %s; jobs would fail to start"
+const imageUnverifiedMsg = "WARNING: module %s uses container image %s, which could not be verified: %v"
+const imageError = "one or more modules use container images that do not exist or cannot be pulled"
+
+// ContainerImageModule is a module that runs containers
+type ContainerImageModule struct {
+	Module    string
+	ProjectID string
+	Images    []string
+	// ServiceAccounts pull the images, e.g. those of the nodes that run the
+	// containers; DefaultComputeServiceAccount stands for the Compute Engine
+	// default service account of the project. Only the existence of images
+	// is checked if there are none.
+	ServiceAccounts []string
+}
+
+// artifactImage is an image in Artifact Registry, e.g.
+// us-docker.pkg.dev/PROJECT/REPOSITORY/IMAGE:TAG
+type artifactImage struct {
+	location, project, repository, pkg string
+	// version is the tag or the digest of the image
+	tag, digest string
+}
+
+func (i artifactImage) repositoryName() string {
+	return fmt.Sprintf("projects/%s/locations/%s/repositories/%s", i.project, i.location, i.repository)
+}
+
+// parseArtifactImage returns ok false for images that are not in Artifact
+// Registry
+func parseArtifactImage(image string) (artifactImage, bool, error) {
+	host, rest, found := strings.Cut(image, "/")
+	if !found || !strings.HasSuffix(host, artifactRegistrySuffix) {
+		return artifactImage{}, false, nil
+	}
+	img := artifactImage{location: strings.TrimSuffix(host, artifactRegistrySuffix)}
+	if name, digest, found := strings.Cut(rest, "@"); found {
+		rest, img.digest = name, digest
+	} else if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		rest, img.tag = rest[:i], rest[i+1:]
+	} else {
+		img.tag = "latest"
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) != 3 || slices.Contains(parts, "") {
+		return artifactImage{}, true, fmt.Errorf("container image %s must be of the form LOCATION-docker.pkg.dev/PROJECT/REPOSITORY/IMAGE", image)
+	}
+	img.project, img.repository, img.pkg = parts[0], parts[1], parts[2]
+	return img, true, nil
+}
+
+// TestContainerImages errors if the Artifact Registry images of modules do not
+// exist or if the service accounts that pull them are not granted a role that
+// allows it on the repository or its project. Roles granted through groups,
+// folders or organizations are not considered. Images in other registries
+// are not checked.
+func TestContainerImages(ctx context.Context, modules []ContainerImageModule) error {
+	var f Findings
+	defer f.Log()
+
+	ar, err := artifactregistry.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+	crm, err := cloudresourcemanager.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+	c := imageChecker{ar: ar, crm: crm, policies: map[string][]string{}, numbers: map[string]int64{}}
+
+	errored := false
+	for _, m := range modules {
+		for _, image := range m.Images {
+			img, ok, err := parseArtifactImage(image)
+			if !ok {
+				continue
+			}
+			if err != nil {
+				f.Printf(m.Module, "module %s: %v", m.Module, err)
+				errored = true
+				continue
+			}
+			exists, err := c.imageExists(ctx, img)
+			if err != nil {
+				f.Printf(m.Module, imageUnverifiedMsg, m.Module, image, err)
+				continue
+			}
+			if !exists {
+				f.Printf(m.Module, imageMissingMsg, m.Module, image)
+				errored = true
+				continue
+			}
+			for _, sa := range m.ServiceAccounts {
+				if sa == DefaultComputeServiceAccount {
+					if sa, err = c.defaultServiceAccount(ctx, m.ProjectID); err != nil {
+						f.Printf(m.Module, imageUnverifiedMsg, m.Module, image, err)
+						continue
+					}
+				}
+				granted, err := c.canPull(ctx, img, sa)
+				if err != nil {
+					f.Printf(m.Module, imageUnverifiedMsg, m.Module, image, err)
+					continue
+				}
+				if !granted {
+					f.Printf(m.Module, imagePullMsg, m.Module, image, sa, img.repositoryName(), img.project)
+					errored = true
+				}
+			}
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(imageError)
+	}
+	return nil
+}
+
+// imageChecker caches the IAM members allowed to pull images, by repository
+// and project, and the numbers of projects
+type imageChecker struct {
+	ar       *artifactregistry.Service
+	crm      *cloudresourcemanager.Service
+	policies map[string][]string
+	numbers  map[string]int64
+}
+
+func (c imageChecker) imageExists(ctx context.Context, img artifactImage) (bool, error) {
+	pkg := img.repositoryName() + "/packages/" + url.PathEscape(img.pkg)
+	var err error
+	if img.digest != "" {
+		_, err = c.ar.Projects.Locations.Repositories.Packages.Versions.Get(pkg + "/versions/" + img.digest).Context(ctx).Do()
+	} else {
+		_, err = c.ar.Projects.Locations.Repositories.Packages.Tags.Get(pkg + "/tags/" + img.tag).Context(ctx).Do()
+	}
+	var herr *googleapi.Error
+	if errors.As(err, &herr) && herr.Code == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c imageChecker) defaultServiceAccount(ctx context.Context, projectID string) (string, error) {
+	n, ok := c.numbers[projectID]
+	if !ok {
+		p, err := c.crm.Projects.Get(projectID).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the default service account of project %s: %w", projectID, err)
+		}
+		n = p.ProjectNumber
+		c.numbers[projectID] = n
+	}
+	return fmt.Sprintf("%d-compute@developer.gserviceaccount.com", n), nil
+}
+
+// canPull returns true if the service account, or all users, are granted a
+// role that can pull images on the repository or its project
+func (c imageChecker) canPull(ctx context.Context, img artifactImage, sa string) (bool, error) {
+	repo := img.repositoryName()
+	if _, ok := c.policies[repo]; !ok {
+		p, err := c.ar.Projects.Locations.Repositories.GetIamPolicy(repo).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("failed to read the IAM policy of repository %s: %w", repo, err)
+		}
+		members := []string{}
+		for _, b := range p.Bindings {
+			if slices.Contains(imagePullRoles, b.Role) && b.Condition == nil {
+				members = append(members, b.Members...)
+			}
+		}
+		c.policies[repo] = members
+	}
+	project := "projects/" + img.project
+	if _, ok := c.policies[project]; !ok {
+		p, err := c.crm.Projects.GetIamPolicy(img.project, &cloudresourcemanager.GetIamPolicyRequest{}).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("failed to read the IAM policy of project %s: %w", img.project, err)
+		}
+		members := []string{}
+		for _, b := range p.Bindings {
+			if slices.Contains(imagePullRoles, b.Role) && b.Condition == nil {
+				members = append(members, b.Members...)
+			}
+		}
+		c.policies[project] = members
+	}
+
+	for _, member := range []string{"serviceAccount:" + sa, "allUsers", "allAuthenticatedUsers"} {
+		if slices.Contains(c.policies[repo], member) || slices.Contains(c.policies[project], member) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+const credentialsExpiredMsg = "%s have expired or been revoked (%v); %s"
+const credentialsRejectedMsg = "%s were rejected by Google Cloud (%s); %s"
+const impersonationDeniedMsg = "%s cannot impersonate service account %s (%v); " +
+	"grant them roles/iam.serviceAccountTokenCreator on it"
+const credentialsPermissionMsg = "%s are valid, but do not have permission to access project %s; " +
+	"grant them a role on it, e.g. roles/viewer, or check the project ID"
+
+// CredentialsError is the failure of the credentials with which Terraform
+// authenticates, e.g. because they expired, rather than a lack of permissions
+type CredentialsError struct {
+	msg string
+}
+
+func (e *CredentialsError) Error() string {
+	return e.msg
+}
+
+// TerraformCredentials returns the options of API clients that authenticate
+// like the Google provider of Terraform: with the access token of
+// GOOGLE_OAUTH_ACCESS_TOKEN or the credentials of GOOGLE_CREDENTIALS if they
+// are set, else with the application default credentials
+func TerraformCredentials() []option.ClientOption {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return []option.ClientOption{option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))}
+	}
+	if creds := os.Getenv("GOOGLE_CREDENTIALS"); creds != "" {
+		if strings.HasPrefix(strings.TrimSpace(creds), "{") {
+			return []option.ClientOption{option.WithCredentialsJSON([]byte(creds))}
+		}
+		return []option.ClientOption{option.WithCredentialsFile(creds)}
+	}
+	return nil
+}
+
+// describeCredentials names the credentials of TerraformCredentials and tells
+// how to renew them
+func describeCredentials() (string, string) {
+	switch {
+	case os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN") != "":
+		return "the access token of GOOGLE_OAUTH_ACCESS_TOKEN", "set GOOGLE_OAUTH_ACCESS_TOKEN to a new token, e.g. from \"gcloud auth print-access-token\""
+	case os.Getenv("GOOGLE_CREDENTIALS") != "":
+		return "the credentials of GOOGLE_CREDENTIALS", "set GOOGLE_CREDENTIALS to a valid service account key"
+	default:
+		return "your application default credentials", "run \"gcloud auth application-default login\" to renew them"
+	}
+}
+
+// TestCredentials errors if the credentials with which Terraform
+// authenticates, impersonating the service account of ctx if any, cannot get
+// an access token, or if projectID is not empty and they cannot read it.
+// Expired or revoked credentials are reported as a CredentialsError, unlike
+// missing permissions.
+func TestCredentials(ctx context.Context, projectID string) error {
+	who, renew := describeCredentials()
+	opts := ClientOptions(ctx, append(TerraformCredentials(), option.WithScopes(cloudPlatformScope))...)
+	creds, err := transport.Creds(ctx, opts...)
+	if err != nil {
+		return handleClientError(err)
+	}
+
+	if _, err := creds.TokenSource.Token(); err != nil {
+		sa, impersonating := ctx.Value(impersonationKey{}).(string)
+		switch {
+		case isExpiredCredentials(err):
+			return &CredentialsError{msg: fmt.Sprintf(credentialsExpiredMsg, who, err, renew)}
+		case impersonating && isPermissionDenied(err):
+			return fmt.Errorf(impersonationDeniedMsg, who, sa, err)
+		default:
+			return fmt.Errorf("%s could not get an access token: %w", who, err)
+		}
+	}
+	if projectID == "" {
+		return nil
+	}
+
+	crm, err := cloudresourcemanager.NewService(ctx, option.WithTokenSource(creds.TokenSource))
+	if err != nil {
+		return handleClientError(err)
+	}
+	_, err = crm.Projects.Get(projectID).Fields("projectId").Context(ctx).Do()
+	var herr *googleapi.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &herr) && herr.Code == http.StatusUnauthorized:
+		return &CredentialsError{msg: fmt.Sprintf(credentialsRejectedMsg, who, herr.Message, renew)}
+	case errors.As(err, &herr) && (herr.Code == http.StatusForbidden || herr.Code == http.StatusNotFound):
+		return fmt.Errorf(credentialsPermissionMsg, who, projectID)
+	default:
+		return err
+	}
+}
+
+// isExpiredCredentials returns true if the error of a token request tells
+// that the credentials expired or were revoked, e.g. because the refresh
+// token of gcloud application default credentials requires a new login
+func isExpiredCredentials(err error) bool {
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) && (rerr.ErrorCode == "invalid_grant" || rerr.ErrorCode == "invalid_rapt") {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"invalid_grant", "invalid_rapt", "reauth", "token has been expired", "token expired"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPermissionDenied returns true if the error of a token request tells that
+// the credentials are not allowed to get it
+func isPermissionDenied(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "PERMISSION_DENIED") || strings.Contains(msg, "status code 403") ||
+		strings.Contains(msg, "iam.serviceAccounts.getAccessToken")
+}
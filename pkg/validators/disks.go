@@ -0,0 +1,140 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	compute "google.golang.org/api/compute/v1"
+)
+
+const diskTooSmallMsg = "module %s has a boot disk of %d GB, but its image %s requires at least %d GB"
+const diskImageUnverifiedMsg = "module %s: could not get image %s, its boot disk size was not checked: %v"
+const localSSDCountMsg = "module %s: machine type %s %s"
+const diskSizeError = "one or more modules have boot disks smaller than their images"
+
+// localSSDCounts are the numbers of local SSDs that can be attached to the
+// machine types of a family; larger machine types of a family may not accept
+// the smallest counts
+var localSSDCounts = map[string][]int64{
+	"n1":  {1, 2, 3, 4, 5, 6, 7, 8, 16, 24},
+	"n2":  {1, 2, 4, 8, 16, 24},
+	"n2d": {1, 2, 4, 8, 16, 24},
+	"c2":  {1, 2, 4, 8},
+	"c2d": {1, 2, 4, 8},
+}
+
+// families whose machine types cannot attach local SSDs, or come with a fixed
+// number of them
+var noLocalSSDFamilies = []string{"e2", "t2d", "t2a", "m2", "h3"}
+var bundledLocalSSDFamilies = []string{"a3", "c3", "c3d"}
+
+// DiskModule is a module whose VMs boot from an image
+type DiskModule struct {
+	Module string
+	// ImageProject, with ImageFamily or ImageName, is the boot image; it is
+	// empty if the image or the size of the disk is not known
+	ImageProject string
+	ImageFamily  string
+	ImageName    string
+	DiskSizeGB   int64
+	MachineType  string
+	// LocalSSDCount is the number of local SSDs of each VM
+	LocalSSDCount int64
+}
+
+func (m DiskModule) image() string {
+	if m.ImageName != "" {
+		return fmt.Sprintf("projects/%s/global/images/%s", m.ImageProject, m.ImageName)
+	}
+	return fmt.Sprintf("projects/%s/global/images/family/%s", m.ImageProject, m.ImageFamily)
+}
+
+// localSSDProblem describes why a number of local SSDs cannot be attached to
+// a machine type, or returns "" if it can or is not known
+func localSSDProblem(machineType string, count int64) string {
+	if count <= 0 || machineType == "" {
+		return ""
+	}
+	family, _, _ := strings.Cut(machineType, "-")
+	switch {
+	case strings.HasSuffix(machineType, "-lssd") || slices.Contains(bundledLocalSSDFamilies, family):
+		return fmt.Sprintf("comes with its own local SSDs, local_ssd_count must be 0, got %d", count)
+	case slices.Contains(noLocalSSDFamilies, family):
+		return fmt.Sprintf("does not support local SSDs, local_ssd_count must be 0, got %d", count)
+	}
+	counts, ok := localSSDCounts[family]
+	if ok && !slices.Contains(counts, count) {
+		return fmt.Sprintf("accepts %v local SSDs, got %d", counts, count)
+	}
+	return ""
+}
+
+// TestDiskSizes errors if the boot disks of modules are smaller than the
+// minimum disk size of their images and warns if their numbers of local SSDs
+// cannot be attached to their machine types. Images that cannot be read,
+// e.g. those built by earlier deployment groups, are not checked.
+func TestDiskSizes(ctx context.Context, modules []DiskModule) error {
+	var f Findings
+	defer f.Log()
+
+	var s *compute.Service
+	sizes := map[string]int64{}
+	errored := false
+	for _, m := range modules {
+		if p := localSSDProblem(m.MachineType, m.LocalSSDCount); p != "" {
+			f.Printf(m.Module, localSSDCountMsg, m.Module, m.MachineType, p)
+		}
+		if m.ImageProject == "" {
+			continue
+		}
+
+		image := m.image()
+		size, ok := sizes[image]
+		if !ok {
+			if s == nil {
+				var err error
+				if s, err = compute.NewService(ctx, ClientOptions(ctx)...); err != nil {
+					return handleClientError(err)
+				}
+			}
+			var img *compute.Image
+			var err error
+			if m.ImageName != "" {
+				img, err = s.Images.Get(m.ImageProject, m.ImageName).Context(ctx).Do()
+			} else {
+				img, err = s.Images.GetFromFamily(m.ImageProject, m.ImageFamily).Context(ctx).Do()
+			}
+			if err != nil {
+				f.Printf(m.Module, diskImageUnverifiedMsg, m.Module, image, err)
+				continue
+			}
+			size = img.DiskSizeGb
+			sizes[image] = size
+		}
+		if m.DiskSizeGB < size {
+			f.Printf(m.Module, diskTooSmallMsg, m.Module, m.DiskSizeGB, image, size)
+			errored = true
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(diskSizeError)
+	}
+	return nil
+}
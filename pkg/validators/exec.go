@@ -0,0 +1,67 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TestExec runs command with args from dir, adding env to the environment of
+// ghpc; the check fails if the command cannot be run or exits with a nonzero
+// status, and the output of the command is included in the error. A relative
+// command path, such as ./check.sh, is resolved against dir, while a bare
+// command name is looked up in the PATH. An empty dir is the working directory.
+func TestExec(ctx context.Context, dir string, command string, args []string, env map[string]string) error {
+	path := command
+	if strings.ContainsRune(command, filepath.Separator) && !filepath.IsAbs(command) {
+		path = filepath.Join(dir, command)
+		if dir == "" {
+			// keep the ./ that makes exec treat the command as a path
+			path = "." + string(filepath.Separator) + path
+		}
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	names := make([]string, 0, len(env))
+	for n := range env {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		cmd.Env = append(cmd.Env, n+"="+env[n])
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	output := strings.TrimSpace(string(out))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if output == "" {
+			return fmt.Errorf("command %s exited with status %d", command, exitErr.ExitCode())
+		}
+		return fmt.Errorf("command %s exited with status %d:\n%s", command, exitErr.ExitCode(), output)
+	}
+	return fmt.Errorf("could not run command %s: %w", command, err)
+}
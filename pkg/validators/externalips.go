@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+const vmExternalIPAccessConstraint = "constraints/compute.vmExternalIpAccess"
+
+const externalLoginNodesMsg = "WARNING: %d login nodes have external IPs (%s %s), more than %d; " +
+	"expose fewer login nodes to the internet, e.g. reach them with IAP TCP forwarding or through a bastion"
+const externalIPsDeniedMsg = "WARNING: module %s creates VMs with external IPs, but the organization policy %s " +
+	"denies external IPs to all VMs in project %s; %s"
+const externalIPsRestrictedMsg = "WARNING: module %s creates VMs with external IPs, but the organization policy %s " +
+	"only allows external IPs on listed VMs in project %s; %s, or list its VMs in the policy"
+
+// ExternalIPModule is a module that creates VMs with external IPs
+type ExternalIPModule struct {
+	Module string
+	// ProjectID is empty if the project of the module cannot be determined
+	// before deployment
+	ProjectID string
+	// VMs is the number of VMs of the module
+	VMs int
+	// Login is true if the VMs are the login nodes of a cluster
+	Login bool
+	// Fix tells how to remove the external IPs, e.g. set disable_public_ips: true
+	Fix string
+}
+
+// externalIPPolicy is the effect of the organization policy on external IPs
+// in a project
+type externalIPPolicy int
+
+const (
+	externalIPsAllowed externalIPPolicy = iota
+	externalIPsRestricted
+	externalIPsDenied
+)
+
+// TestExternalIPs warns if modules create more than maxLoginNodes login nodes
+// with external IPs, or if the organization policy of the project of a module
+// denies the external IPs of its VMs, which would fail to be created. It
+// never fails.
+func TestExternalIPs(ctx context.Context, modules []ExternalIPModule, maxLoginNodes int) error {
+	var f Findings
+	defer f.Log()
+
+	login := []string{}
+	loginNodes := 0
+	for _, m := range modules {
+		if m.Login {
+			login = append(login, m.Module)
+			loginNodes += m.VMs
+		}
+	}
+	if loginNodes > maxLoginNodes {
+		log.Printf(externalLoginNodesMsg, loginNodes, pluralModules(len(login)), strings.Join(login, ", "), maxLoginNodes)
+	}
+
+	var crm *cloudresourcemanager.Service
+	policies := map[string]externalIPPolicy{}
+	for _, m := range modules {
+		if m.ProjectID == "" {
+			continue
+		}
+		p, ok := policies[m.ProjectID]
+		if !ok {
+			if crm == nil {
+				var err error
+				if crm, err = cloudresourcemanager.NewService(ctx, ClientOptions(ctx)...); err != nil {
+					return handleClientError(err)
+				}
+			}
+			p = getExternalIPPolicy(ctx, crm, m.ProjectID)
+			policies[m.ProjectID] = p
+		}
+		switch p {
+		case externalIPsDenied:
+			f.Printf(m.Module, externalIPsDeniedMsg, m.Module, vmExternalIPAccessConstraint, m.ProjectID, m.Fix)
+		case externalIPsRestricted:
+			f.Printf(m.Module, externalIPsRestrictedMsg, m.Module, vmExternalIPAccessConstraint, m.ProjectID, m.Fix)
+		}
+	}
+	return nil
+}
+
+// getExternalIPPolicy reads the effective organization policy on external IPs
+// of a project; reading organization policies requires permissions that users
+// may not have, in which case external IPs are taken to be allowed
+func getExternalIPPolicy(ctx context.Context, crm *cloudresourcemanager.Service, projectID string) externalIPPolicy {
+	req := &cloudresourcemanager.GetEffectiveOrgPolicyRequest{Constraint: vmExternalIPAccessConstraint}
+	policy, err := crm.Projects.GetEffectiveOrgPolicy("projects/"+projectID, req).Context(ctx).Do()
+	if err != nil {
+		log.Printf("could not read organization policy %s of project %s: %v", vmExternalIPAccessConstraint, projectID, err)
+		return externalIPsAllowed
+	}
+	return externalIPPolicyOf(policy.ListPolicy)
+}
+
+func externalIPPolicyOf(lp *cloudresourcemanager.ListPolicy) externalIPPolicy {
+	switch {
+	case lp == nil || lp.AllValues == "ALLOW":
+		return externalIPsAllowed
+	case lp.AllValues == "DENY":
+		return externalIPsDenied
+	case len(lp.AllowedValues) > 0:
+		return externalIPsRestricted
+	default:
+		return externalIPsAllowed
+	}
+}
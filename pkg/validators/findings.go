@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// modulePlaceholder stands for the module in the messages that are compared
+// to group findings
+const modulePlaceholder = "\x00"
+
+// Findings collects the messages of the problems that a validator finds in
+// modules, so that a problem shared by many modules, e.g. a zone that does not
+// exist, is printed once with the list of the modules it affects rather than
+// once per module. The zero value is ready to use.
+type Findings struct {
+	keys  []string
+	found map[string]*finding
+}
+
+type finding struct {
+	// msg is the message of the first module with the problem
+	msg     string
+	modules []string
+}
+
+// Printf records the message of a problem of a module, formatted like
+// log.Printf. Messages that only differ by the name of the module are the
+// same finding.
+func (f *Findings) Printf(module string, format string, args ...interface{}) {
+	f.PrintfIn(module, "", "", format, args...)
+}
+
+// PrintfIn records the message of a problem of a module in a project and
+// zone, either of which may be empty. Messages that only differ by the name of
+// the module are the same finding if they are in the same project and zone.
+func (f *Findings) PrintfIn(module string, project string, zone string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	key := strings.Join([]string{project, zone, anonymize(msg, module)}, "\x00")
+
+	if f.found == nil {
+		f.found = map[string]*finding{}
+	}
+	if fd, ok := f.found[key]; ok {
+		if !slices.Contains(fd.modules, module) {
+			fd.modules = append(fd.modules, module)
+		}
+		return
+	}
+	f.keys = append(f.keys, key)
+	f.found[key] = &finding{msg: msg, modules: []string{module}}
+}
+
+// anonymize replaces the name of the module in a message by a placeholder,
+// wherever it appears as a whole name rather than as part of another, e.g.
+// vm in vm-1
+func anonymize(msg string, module string) string {
+	if module == "" {
+		return msg
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(msg, module)
+		if i == -1 {
+			b.WriteString(msg)
+			return b.String()
+		}
+		end := i + len(module)
+		if (i == 0 || !isNameByte(msg[i-1])) && (end == len(msg) || !isNameByte(msg[end])) {
+			b.WriteString(msg[:i])
+			b.WriteString(modulePlaceholder)
+		} else {
+			b.WriteString(msg[:end])
+		}
+		msg = msg[end:]
+	}
+}
+
+// isNameByte returns true if c may be part of the name of a module
+func isNameByte(c byte) bool {
+	return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// Len returns the number of distinct findings
+func (f *Findings) Len() int {
+	return len(f.keys)
+}
+
+// Messages returns a message per finding, in the order they were first
+// recorded, naming the other modules that have the same problem
+func (f *Findings) Messages() []string {
+	msgs := make([]string, len(f.keys))
+	for i, k := range f.keys {
+		fd := f.found[k]
+		msgs[i] = fd.msg
+		if others := fd.modules[1:]; len(others) > 0 {
+			msgs[i] += fmt.Sprintf(" (also %s %s)", pluralModules(len(others)), strings.Join(others, ", "))
+		}
+	}
+	return msgs
+}
+
+// Log prints the findings, each once
+func (f *Findings) Log() {
+	for _, m := range f.Messages() {
+		log.Print(m)
+	}
+}
+
+func pluralModules(n int) string {
+	if n == 1 {
+		return "module"
+	}
+	return "modules"
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
+
+// Setup GoCheck
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+// record is a problem recorded by Findings.PrintfIn
+type record struct {
+	module  string
+	project string
+	zone    string
+	format  string
+	args    []interface{}
+}
+
+func (s *MySuite) TestFindings(c *C) {
+	type test struct {
+		name    string
+		records []record
+		want    []string
+	}
+	badZone := func(module string) record {
+		return record{module, "p", "us-central1-z", "module %s: zone %s does not exist in project %s",
+			[]interface{}{module, "us-central1-z", "p"}}
+	}
+	tests := []test{
+		{"none", nil, []string{}},
+		{"single", []record{badZone("vm0")}, []string{
+			"module vm0: zone us-central1-z does not exist in project p",
+		}},
+		{"same zone", []record{badZone("vm0"), badZone("vm1"), badZone("vm2")}, []string{
+			"module vm0: zone us-central1-z does not exist in project p (also modules vm1, vm2)",
+		}},
+		{"repeated module", []record{badZone("vm0"), badZone("vm1"), badZone("vm0")}, []string{
+			"module vm0: zone us-central1-z does not exist in project p (also module vm1)",
+		}},
+		{"other message", []record{
+			badZone("vm0"),
+			{"vm1", "p", "us-central1-z", "module %s has no disk", []interface{}{"vm1"}},
+			badZone("vm2"),
+		}, []string{
+			"module vm0: zone us-central1-z does not exist in project p (also module vm2)",
+			"module vm1 has no disk",
+		}},
+		{"other project", []record{
+			{"vm0", "p", "z", "module %s: zone not found", []interface{}{"vm0"}},
+			{"vm1", "q", "z", "module %s: zone not found", []interface{}{"vm1"}},
+			{"vm2", "p", "z", "module %s: zone not found", []interface{}{"vm2"}},
+		}, []string{
+			"module vm0: zone not found (also module vm2)",
+			"module vm1: zone not found",
+		}},
+		{"other zone", []record{
+			{"vm0", "p", "a", "module %s: zone not found", []interface{}{"vm0"}},
+			{"vm1", "p", "b", "module %s: zone not found", []interface{}{"vm1"}},
+		}, []string{
+			"module vm0: zone not found",
+			"module vm1: zone not found",
+		}},
+		{"module in other arguments", []record{
+			{"vm0", "", "", "%v", []interface{}{errors.New("module vm0: image not found")}},
+			{"vm1", "", "", "%v", []interface{}{errors.New("module vm1: image not found")}},
+		}, []string{
+			"module vm0: image not found (also module vm1)",
+		}},
+		{"module within other names", []record{
+			{"vm", "", "", "module %s uses %s", []interface{}{"vm", "vm-disk"}},
+			{"db", "", "", "module %s uses %s", []interface{}{"db", "vm-disk"}},
+			{"vm-disk", "", "", "module %s uses %s", []interface{}{"vm-disk", "db-disk"}},
+		}, []string{
+			"module vm uses vm-disk (also module db)",
+			"module vm-disk uses db-disk",
+		}},
+	}
+	for _, t := range tests {
+		var f Findings
+		for _, r := range t.records {
+			f.PrintfIn(r.module, r.project, r.zone, r.format, r.args...)
+		}
+		c.Check(f.Messages(), DeepEquals, t.want, Commentf(t.name))
+		c.Check(f.Len(), Equals, len(t.want), Commentf(t.name))
+	}
+}
+
+func (s *MySuite) TestFindingsPrintf(c *C) {
+	var f Findings
+	for i := 0; i < 30; i++ {
+		module := fmt.Sprintf("vm%d", i)
+		f.Printf(module, "module %s: zone %s is not available", module, "us-central1-z")
+	}
+	c.Assert(f.Len(), Equals, 1)
+	c.Check(f.Messages()[0], Matches, `module vm0: zone us-central1-z is not available \(also modules vm1, vm2, .*, vm29\)`)
+}
+
+func (s *MySuite) TestAnonymize(c *C) {
+	tests := []struct {
+		msg    string
+		module string
+		want   string
+	}{
+		{"module vm: fails", "vm", "module \x00: fails"},
+		{"vm", "vm", "\x00"},
+		{"vm and vm", "vm", "\x00 and \x00"},
+		{"module vm-1: fails", "vm", "module vm-1: fails"},
+		{"module my_vm: fails", "vm", "module my_vm: fails"},
+		{"vm2 vm", "vm", "vm2 \x00"},
+		{"vm, (vm)", "vm", "\x00, (\x00)"},
+		{"no module", "", "no module"},
+	}
+	for _, t := range tests {
+		c.Check(anonymize(t.msg, t.module), Equals, t.want, Commentf("%q", t.msg))
+	}
+}
+
+func (s *MySuite) TestModuleZones(c *C) {
+	cache := newLocationCache()
+	cache.list = func(ctx context.Context, projectID string) (map[string]*compute.Zone, map[string]*compute.Region, error) {
+		if projectID == "missing" {
+			return nil, nil, errors.New("project not found")
+		}
+		return map[string]*compute.Zone{"us-central1-a": {Name: "us-central1-a"}}, nil, nil
+	}
+	ctx := context.WithValue(context.Background(), locationCacheKey{}, cache)
+
+	c.Check(TestModuleZones(ctx, nil), IsNil)
+	c.Check(TestModuleZones(ctx, []ZoneModule{{"vm0", "p", "us-central1-a"}}), IsNil)
+
+	modules := []ZoneModule{}
+	for i := 0; i < 30; i++ {
+		modules = append(modules, ZoneModule{fmt.Sprintf("vm%d", i), "p", "us-central1-z"})
+	}
+	modules = append(modules, ZoneModule{"db", "missing", "us-central1-a"})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+	c.Check(TestModuleZones(ctx, modules), ErrorMatches, moduleZoneError)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Assert(lines, HasLen, 2)
+	c.Check(lines[0], Matches, `module vm0: zone us-central1-z is not available in project ID p .* \(also modules vm1, .*, vm29\)`)
+	c.Check(lines[1], Matches, `module db: zone us-central1-a is not available in project ID missing .*`)
+}
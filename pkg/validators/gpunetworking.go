@@ -0,0 +1,193 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+const gpuNetworkingMsg = "module %s would fail to create its %s VMs: %s"
+const gpuNetworkingWarningMsg = "WARNING: module %s: %s"
+const gpuFirewallMsg = "WARNING: module %s: network %s of network interface %d has no firewall rule that allows ingress traffic " +
+	"between its VMs; %s traffic between the GPUs would be blocked. Allow all TCP traffic from the subnetworks of the network"
+const gpuFirewallUnverifiedMsg = "WARNING: the firewall rules of network interface %d of module %s could not be verified: %v"
+const gpuNetworkingError = "one or more modules create GPU VMs with network interfaces that Compute Engine rejects"
+
+// GPUNetwork is an existing network, or subnetwork, of a network interface
+// of the VMs of a GPUNetworkModule
+type GPUNetwork struct {
+	Interface int
+	// Network or Subnetwork is the name or self link of the network;
+	// Subnetwork is in Region, if it is not a self link
+	Network    string
+	Subnetwork string
+	ProjectID  string
+	Region     string
+}
+
+// GPUNetworkModule is a module whose GPU VMs need a network interface per
+// GPU network, e.g. with A3 machine types
+type GPUNetworkModule struct {
+	Module      string
+	MachineType string
+	// Stack is the GPU networking stack of the machine type, e.g.
+	// GPUDirect-TCPX
+	Stack string
+	// Problems are network interface settings that Compute Engine rejects;
+	// Warnings are settings that leave the GPU networks unusable
+	Problems []string
+	Warnings []string
+	// Networks are the existing networks of the network interfaces, whose
+	// firewall rules are checked
+	Networks []GPUNetwork
+}
+
+// TestGPUNetworking checks the network interfaces of modules whose GPU VMs
+// need a network interface per GPU network, in a VPC network of its own. It
+// fails on settings that Compute Engine rejects and warns on those that leave
+// the GPU networks unusable, including existing networks whose firewall rules
+// block the traffic between the VMs.
+func TestGPUNetworking(ctx context.Context, modules []GPUNetworkModule) error {
+	var w Findings
+	defer w.Log()
+
+	var f Findings
+	for _, m := range modules {
+		for _, p := range m.Problems {
+			f.Printf(m.Module, gpuNetworkingMsg, m.Module, m.MachineType, p)
+		}
+		for _, msg := range m.Warnings {
+			w.Printf(m.Module, gpuNetworkingWarningMsg, m.Module, msg)
+		}
+	}
+	f.Log()
+
+	var s *compute.Service
+	allowed := map[string]bool{}
+	for _, m := range modules {
+		for _, n := range m.Networks {
+			if n.ProjectID == "" {
+				continue
+			}
+			if s == nil {
+				var err error
+				if s, err = compute.NewService(ctx, ClientOptions(ctx)...); err != nil {
+					return handleClientError(err)
+				}
+			}
+			network, err := gpuNetworkOf(ctx, s, n)
+			if err != nil {
+				w.Printf(m.Module, gpuFirewallUnverifiedMsg, n.Interface, m.Module, err)
+				continue
+			}
+			// the firewall rules of a shared VPC are in its host project
+			project := n.ProjectID
+			if p, ok := selfLinkPart(network, "projects"); ok {
+				project = p
+			}
+			ok, checked := allowed[network]
+			if !checked {
+				if ok, err = allowsInternalIngress(ctx, s, project, network); err != nil {
+					w.Printf(m.Module, gpuFirewallUnverifiedMsg, n.Interface, m.Module, err)
+					continue
+				}
+				allowed[network] = ok
+			}
+			if !ok {
+				w.Printf(m.Module, gpuFirewallMsg, m.Module, path.Base(network), n.Interface, m.Stack)
+			}
+		}
+	}
+
+	if f.Len() > 0 {
+		return fmt.Errorf(gpuNetworkingError)
+	}
+	return nil
+}
+
+// gpuNetworkOf returns the self link of the network of a GPUNetwork
+func gpuNetworkOf(ctx context.Context, s *compute.Service, n GPUNetwork) (string, error) {
+	if n.Subnetwork == "" {
+		net, err := s.Networks.Get(n.ProjectID, path.Base(n.Network)).Context(ctx).Do()
+		if err != nil {
+			return "", err
+		}
+		return net.SelfLink, nil
+	}
+	project, region, name := subnetworkID(n)
+	if region == "" {
+		return "", fmt.Errorf("the region of subnetwork %s is not known", n.Subnetwork)
+	}
+	sub, err := s.Subnetworks.Get(project, region, name).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return sub.Network, nil
+}
+
+// subnetworkID splits the self link of a subnetwork, e.g.
+// projects/p/regions/r/subnetworks/s, or a subnetwork name in the project
+// and region of the GPUNetwork
+func subnetworkID(n GPUNetwork) (string, string, string) {
+	project, region, name := n.ProjectID, n.Region, path.Base(n.Subnetwork)
+	if p, ok := selfLinkPart(n.Subnetwork, "projects"); ok {
+		project = p
+	}
+	if r, ok := selfLinkPart(n.Subnetwork, "regions"); ok {
+		region = r
+	}
+	return project, region, name
+}
+
+// selfLinkPart returns the part of a self link that follows a collection,
+// e.g. r for regions in projects/p/regions/r/subnetworks/s
+func selfLinkPart(link string, collection string) (string, bool) {
+	parts := strings.Split(link, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == collection {
+			return parts[i+1], true
+		}
+	}
+	return "", false
+}
+
+// allowsInternalIngress returns true if an enabled ingress firewall rule of
+// a network allows all TCP ports from some sources, as GPU networking stacks
+// use ports of their own
+func allowsInternalIngress(ctx context.Context, s *compute.Service, project string, network string) (bool, error) {
+	found := false
+	err := s.Firewalls.List(project).Filter(fmt.Sprintf("network=%q", network)).Pages(ctx, func(l *compute.FirewallList) error {
+		for _, fw := range l.Items {
+			if fw.Disabled || (fw.Direction != "" && fw.Direction != "INGRESS") || fw.Network != network {
+				continue
+			}
+			if len(fw.SourceRanges) == 0 && len(fw.SourceTags) == 0 && len(fw.SourceServiceAccounts) == 0 {
+				continue
+			}
+			for _, a := range fw.Allowed {
+				if (a.IPProtocol == "all" || a.IPProtocol == "tcp") && len(a.Ports) == 0 {
+					found = true
+				}
+			}
+		}
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	compute "google.golang.org/api/compute/v1"
+)
+
+const gpuDriverMsg = "WARNING: module %s attaches %s GPUs, but its image %s shows no sign of including NVIDIA drivers; " +
+	"its VMs may start without CUDA unless a startup script installs the drivers. " +
+	"Use an image that includes them, or label the image, e.g. nvidia-driver=VERSION"
+const gpuImageUnverifiedMsg = "module %s: could not get image %s, its GPU drivers were not checked: %v"
+
+// gpuDriverProjects publish images that all include NVIDIA drivers
+var gpuDriverProjects = []string{"schedmd-slurm-public"}
+
+// gpuDriverSign matches the names, families, descriptions, licenses and labels
+// of images that include NVIDIA drivers, e.g. the CUDA families of the Deep
+// Learning VM images, such as common-cu113
+var gpuDriverSign = regexp.MustCompile(`(?i)nvidia|cuda|gpu|(^|[-_])cu\d+`)
+
+// GPUModule is a module whose VMs have GPUs
+type GPUModule struct {
+	Module string
+	// Accelerator is the type of the GPUs, e.g. nvidia-tesla-t4
+	Accelerator string
+	// ImageProject, with ImageFamily or ImageName, is the boot image of the
+	// VMs; it is empty if the image is not known before deployment
+	ImageProject string
+	ImageFamily  string
+	ImageName    string
+}
+
+func (m GPUModule) image() string {
+	return DiskModule{ImageProject: m.ImageProject, ImageFamily: m.ImageFamily, ImageName: m.ImageName}.image()
+}
+
+// includesGPUDrivers returns true if an image is known, or labeled, to
+// include NVIDIA drivers
+func includesGPUDrivers(project string, img *compute.Image) bool {
+	if slices.Contains(gpuDriverProjects, project) {
+		return true
+	}
+	signs := append([]string{img.Name, img.Family, img.Description}, img.Licenses...)
+	for k, v := range img.Labels {
+		signs = append(signs, k, v)
+	}
+	for _, s := range signs {
+		if gpuDriverSign.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestGPUImages warns if modules attach GPUs to VMs whose boot images show no
+// sign of including NVIDIA drivers, which leaves the VMs, e.g. Slurm nodes,
+// without CUDA. An image includes drivers if it is published by a project
+// whose images all do, or if its name, family, description, licenses or
+// labels mention NVIDIA, CUDA or GPUs. Images that cannot be read, e.g.
+// those built by earlier deployment groups, are not checked.
+func TestGPUImages(ctx context.Context, modules []GPUModule) error {
+	var f Findings
+	defer f.Log()
+
+	var s *compute.Service
+	includes := map[string]bool{}
+	for _, m := range modules {
+		if m.ImageProject == "" {
+			continue
+		}
+
+		image := m.image()
+		ok, checked := includes[image]
+		if !checked {
+			if s == nil {
+				var err error
+				if s, err = compute.NewService(ctx, ClientOptions(ctx)...); err != nil {
+					return handleClientError(err)
+				}
+			}
+			var img *compute.Image
+			var err error
+			if m.ImageName != "" {
+				img, err = s.Images.Get(m.ImageProject, m.ImageName).Context(ctx).Do()
+			} else {
+				img, err = s.Images.GetFromFamily(m.ImageProject, m.ImageFamily).Context(ctx).Do()
+			}
+			if err != nil {
+				f.Printf(m.Module, gpuImageUnverifiedMsg, m.Module, image, err)
+				continue
+			}
+			ok = includesGPUDrivers(m.ImageProject, img)
+			includes[image] = ok
+		}
+		if !ok {
+			f.Printf(m.Module, gpuDriverMsg, m.Module, strings.TrimPrefix(m.Accelerator, "nvidia-"), image)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+
+	"google.golang.org/api/option"
+)
+
+type impersonationKey struct{}
+
+// WithImpersonation returns a context whose validators call Google Cloud APIs
+// as the service account, rather than as the application default
+// credentials; an empty service account leaves ctx unchanged
+func WithImpersonation(ctx context.Context, serviceAccount string) context.Context {
+	if serviceAccount == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, impersonationKey{}, serviceAccount)
+}
+
+// ClientOptions returns the options of the API clients created with ctx,
+// followed by opts
+func ClientOptions(ctx context.Context, opts ...option.ClientOption) []option.ClientOption {
+	sa, ok := ctx.Value(impersonationKey{}).(string)
+	if !ok {
+		return opts
+	}
+	return append([]option.ClientOption{option.ImpersonateCredentials(sa)}, opts...)
+}
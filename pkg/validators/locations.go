@@ -0,0 +1,208 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// projectLocations are the zones and regions of a project, keyed by name;
+// done is closed once they have been listed, or err set
+type projectLocations struct {
+	done    chan struct{}
+	zones   map[string]*compute.Zone
+	regions map[string]*compute.Region
+	err     error
+}
+
+// locationCache lists the zones and regions of each project once, so that
+// validators checking many zones and regions of a project make two Compute
+// API calls rather than one or two per check
+type locationCache struct {
+	mu       sync.Mutex
+	projects map[string]*projectLocations
+	// list is replaced in tests
+	list func(ctx context.Context, projectID string) (map[string]*compute.Zone, map[string]*compute.Region, error)
+}
+
+type locationCacheKey struct{}
+
+func newLocationCache() *locationCache {
+	return &locationCache{projects: map[string]*projectLocations{}, list: listLocations}
+}
+
+// WithLocationCache returns a context whose validators share the zones and
+// regions they list; it should span a single validation run, so that zones
+// and regions are not cached beyond it
+func WithLocationCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, locationCacheKey{}, newLocationCache())
+}
+
+// PrefetchLocations starts listing the zones and regions of projects in
+// parallel, so that the validators that need them later do not wait for one
+// project after another. It has no effect unless ctx has a location cache.
+func PrefetchLocations(ctx context.Context, projects []string) {
+	c, ok := ctx.Value(locationCacheKey{}).(*locationCache)
+	if !ok {
+		return
+	}
+	for _, p := range projects {
+		go c.get(ctx, p)
+	}
+}
+
+// locationsOf returns the zones and regions of a project, from the location
+// cache of ctx if it has one
+func locationsOf(ctx context.Context, projectID string) (*projectLocations, error) {
+	c, ok := ctx.Value(locationCacheKey{}).(*locationCache)
+	if !ok {
+		c = newLocationCache()
+	}
+	return c.get(ctx, projectID)
+}
+
+func (c *locationCache) get(ctx context.Context, projectID string) (*projectLocations, error) {
+	c.mu.Lock()
+	pl, found := c.projects[projectID]
+	if !found {
+		pl = &projectLocations{done: make(chan struct{})}
+		c.projects[projectID] = pl
+	}
+	c.mu.Unlock()
+
+	if found {
+		select {
+		case <-pl.done:
+			return pl, pl.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	pl.zones, pl.regions, pl.err = c.list(ctx, projectID)
+	if pl.err != nil && ctx.Err() != nil {
+		// a cancelled or expired listing is tried again by the next validator
+		c.mu.Lock()
+		delete(c.projects, projectID)
+		c.mu.Unlock()
+	}
+	close(pl.done)
+	return pl, pl.err
+}
+
+// listLocations lists the zones and regions of a project
+func listLocations(ctx context.Context, projectID string) (map[string]*compute.Zone, map[string]*compute.Region, error) {
+	s, err := compute.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return nil, nil, handleClientError(err)
+	}
+	zones := map[string]*compute.Zone{}
+	err = s.Zones.List(projectID).Pages(ctx, func(l *compute.ZoneList) error {
+		for _, z := range l.Items {
+			zones[z.Name] = z
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	regions := map[string]*compute.Region{}
+	err = s.Regions.List(projectID).Pages(ctx, func(l *compute.RegionList) error {
+		for _, r := range l.Items {
+			regions[r.Name] = r
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return zones, regions, nil
+}
+
+// SelectZone returns the first zone of a region, in the order of their names,
+// that is up in the project
+func SelectZone(ctx context.Context, projectID string, region string) (string, error) {
+	l, err := locationsOf(ctx, projectID)
+	if err != nil {
+		return "", fmt.Errorf(regionError, region, projectID)
+	}
+	if up := upZones(l, region); len(up) > 0 {
+		return up[0], nil
+	}
+	return "", fmt.Errorf("region %s has no zone that is up in project ID %s", region, projectID)
+}
+
+// TestZoneAvailable errors if a zone is not up in the project, listing the
+// zones of its region that are
+func TestZoneAvailable(ctx context.Context, projectID string, zone string) error {
+	l, err := locationsOf(ctx, projectID)
+	if err != nil || l.zones[zone] == nil {
+		return fmt.Errorf(zoneError, zone, projectID)
+	}
+	z := l.zones[zone]
+	if z.Status == "UP" {
+		return nil
+	}
+	region := path.Base(z.Region)
+	return fmt.Errorf("zone %s is %s in project ID %s; zones of region %s that are up: %s",
+		zone, z.Status, projectID, region, strings.Join(upZones(l, region), ", "))
+}
+
+// ZoneModule is a module that sets the zone of its resources
+type ZoneModule struct {
+	Module    string
+	ProjectID string
+	Zone      string
+}
+
+const moduleZoneMsg = "module %s: %v"
+const moduleZoneError = "one or more modules set zones that are not available in their projects"
+
+// TestModuleZones errors if the zones that modules set are not available in
+// their projects. Modules that set the same zone in the same project are
+// reported once.
+func TestModuleZones(ctx context.Context, modules []ZoneModule) error {
+	var f Findings
+	for _, m := range modules {
+		if err := TestZoneExists(ctx, m.ProjectID, m.Zone); err != nil {
+			f.PrintfIn(m.Module, m.ProjectID, m.Zone, moduleZoneMsg, m.Module, err)
+		}
+	}
+	f.Log()
+
+	if f.Len() > 0 {
+		return fmt.Errorf(moduleZoneError)
+	}
+	return nil
+}
+
+// upZones returns the sorted names of the zones of a region that are up
+func upZones(l *projectLocations, region string) []string {
+	up := []string{}
+	for name, z := range l.zones {
+		if path.Base(z.Region) == region && z.Status == "UP" {
+			up = append(up, name)
+		}
+	}
+	sort.Strings(up)
+	return up
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// APIs that receive the logs and metrics of the Ops Agent
+var opsAgentAPIs = []string{"logging.googleapis.com", "monitoring.googleapis.com"}
+
+const opsAgentScopesMsg = "module %s installs the Ops Agent with %s, but its service account lacks the scopes %s; its logs and metrics would be rejected"
+const opsAgentAPIsMsg = "module %s installs the Ops Agent with %s, but its logs and metrics would be dropped: %v"
+const opsAgentError = "one or more modules install the Ops Agent on VMs that cannot send logs and metrics"
+
+// OpsAgentModule is a module whose VMs run startup-scripts that install the
+// Ops Agent
+type OpsAgentModule struct {
+	Module    string
+	ProjectID string
+	// Scripts are the startup-script modules that install the agent
+	Scripts []string
+	// MissingScopes are the scopes needed by the agent that the service
+	// account of the VMs lacks
+	MissingScopes []string
+}
+
+// TestOpsAgent errors if the VMs of modules that install the Ops Agent lack
+// the scopes needed to write logs and metrics, or if the Cloud Logging and
+// Cloud Monitoring APIs are disabled in their projects
+func TestOpsAgent(ctx context.Context, modules []OpsAgentModule) error {
+	var f Findings
+	defer f.Log()
+
+	projects := map[string]error{}
+	errored := false
+	for _, m := range modules {
+		scripts := strings.Join(m.Scripts, ", ")
+		if len(m.MissingScopes) > 0 {
+			f.Printf(m.Module, opsAgentScopesMsg, m.Module, scripts, strings.Join(m.MissingScopes, ", "))
+			errored = true
+		}
+
+		err, ok := projects[m.ProjectID]
+		if !ok {
+			err = TestApisEnabled(ctx, m.ProjectID, opsAgentAPIs)
+			projects[m.ProjectID] = err
+		}
+		if err != nil {
+			f.Printf(m.Module, opsAgentAPIsMsg, m.Module, scripts, err)
+			errored = true
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(opsAgentError)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	compute "google.golang.org/api/compute/v1"
+)
+
+const requireOSLoginConstraint = "constraints/compute.requireOsLogin"
+
+const osLoginEnforcedMsg = "module %s sets ssh-keys metadata, but the organization policy %s enforces OS Login in project %s; users must log in to its VMs with OS Login"
+const osLoginProjectMsg = "module %s sets ssh-keys metadata, but OS Login is enabled in the metadata of project %s; set enable-oslogin to \"FALSE\" in the module metadata or log in with OS Login"
+const osLoginModuleMsg = "module %s sets ssh-keys metadata, but enables OS Login on its VMs; set enable_oslogin to \"DISABLE\" or log in with OS Login"
+const osLoginError = "one or more modules set SSH keys that their VMs would ignore because OS Login is enabled"
+
+// SSHKeysModule is a module that sets instance-level SSH keys in the metadata
+// of its VMs
+type SSHKeysModule struct {
+	Module    string
+	ProjectID string
+	// OSLogin is true if the module enables OS Login on its VMs, false if it
+	// disables it and nil if its VMs inherit the setting of the project
+	OSLogin *bool
+}
+
+type projectOSLogin struct {
+	enabled  bool
+	enforced bool
+}
+
+// TestOSLoginSSHKeys errors if OS Login is enabled on the VMs of modules that
+// set instance-level SSH keys, which OS Login ignores. Project metadata and
+// organization policies are queried for modules that do not disable OS Login
+// themselves.
+func TestOSLoginSSHKeys(ctx context.Context, modules []SSHKeysModule) error {
+	var f Findings
+	defer f.Log()
+
+	projects := map[string]projectOSLogin{}
+	errored := false
+	for _, m := range modules {
+		if m.OSLogin != nil && *m.OSLogin {
+			f.Printf(m.Module, osLoginModuleMsg, m.Module)
+			errored = true
+			continue
+		}
+
+		p, ok := projects[m.ProjectID]
+		if !ok {
+			var err error
+			if p, err = getProjectOSLogin(ctx, m.ProjectID); err != nil {
+				return err
+			}
+			projects[m.ProjectID] = p
+		}
+		switch {
+		case p.enforced:
+			f.Printf(m.Module, osLoginEnforcedMsg, m.Module, requireOSLoginConstraint, m.ProjectID)
+			errored = true
+		case p.enabled && m.OSLogin == nil:
+			f.Printf(m.Module, osLoginProjectMsg, m.Module, m.ProjectID)
+			errored = true
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(osLoginError)
+	}
+	return nil
+}
+
+func getProjectOSLogin(ctx context.Context, projectID string) (projectOSLogin, error) {
+	s, err := compute.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return projectOSLogin{}, handleClientError(err)
+	}
+	project, err := s.Projects.Get(projectID).Fields("commonInstanceMetadata").Context(ctx).Do()
+	if err != nil {
+		return projectOSLogin{}, fmt.Errorf(projectError, projectID)
+	}
+
+	p := projectOSLogin{}
+	if md := project.CommonInstanceMetadata; md != nil {
+		for _, item := range md.Items {
+			if item.Key == "enable-oslogin" && item.Value != nil {
+				p.enabled = strings.EqualFold(*item.Value, "true")
+			}
+		}
+	}
+
+	// reading organization policies requires permissions that users may not
+	// have, in which case only the project metadata is considered
+	crm, err := cloudresourcemanager.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return projectOSLogin{}, handleClientError(err)
+	}
+	req := &cloudresourcemanager.GetEffectiveOrgPolicyRequest{Constraint: requireOSLoginConstraint}
+	policy, err := crm.Projects.GetEffectiveOrgPolicy("projects/"+projectID, req).Context(ctx).Do()
+	if err != nil {
+		log.Printf("could not read organization policy %s of project %s: %v", requireOSLoginConstraint, projectID, err)
+	} else if policy.BooleanPolicy != nil {
+		p.enforced = policy.BooleanPolicy.Enforced
+	}
+	return p, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"hpc-toolkit/pkg/quota"
+)
+
+const quotaShortfallMsg = "project %s lacks %s quota in region %s: modules %s need %d, but %v of the limit of %v are in use; run \"ghpc quota request\" to request a limit of %d"
+const quotaError = "one or more Compute Engine quotas are too low for the VMs of the blueprint"
+
+// TestComputeQuotas errors if the regional CPU quotas of the projects of the
+// demands are too low for the VMs to be created in addition to the current
+// usage. Quotas that are consumed by VMs not yet created, e.g. those of
+// reservations, are not considered.
+func TestComputeQuotas(ctx context.Context, demands []quota.Demand) error {
+	shortfalls, err := quota.Shortfalls(ctx, demands, ClientOptions(ctx)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+	for _, s := range shortfalls {
+		log.Printf(quotaShortfallMsg, s.ProjectID, s.Metric, s.Region,
+			strings.Join(s.Modules, ", "), s.Required, s.Usage, s.Limit, s.Desired())
+	}
+	if len(shortfalls) > 0 {
+		return fmt.Errorf(quotaError)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// MetadataSizeLimit is the maximum size in bytes of the metadata of a VM
+const MetadataSizeLimit = 256 * 1024
+
+const startupScriptMsg = "module %s runner %s: %v"
+const metadataSizeMsg = "module %s sets %d bytes of metadata, which exceeds the limit of %d bytes; consider moving scripts into startup-script runners"
+const startupScriptError = "one or more startup scripts would fail when the VMs boot"
+
+var shebangExp = regexp.MustCompile(`^#!\s*(?:/usr/bin/env\s+)?(\S+)`)
+
+// Runner is a startup-script runner whose fields are known before deployment
+type Runner struct {
+	Module      string
+	Type        string
+	Destination string
+	Source      string
+	Content     string
+}
+
+// TestStartupScripts errors if a local runner source is missing, a GCS runner
+// source is not accessible, a shell runner has a syntax error or a module sets
+// more metadata than a VM accepts; metadataSizes maps module IDs to bytes.
+// Relative runner sources are resolved against blueprintDir.
+func TestStartupScripts(ctx context.Context, blueprintDir string, runners []Runner, metadataSizes map[string]int) error {
+	var f Findings
+	defer f.Log()
+
+	errored := false
+	for _, r := range runners {
+		if err := testRunner(ctx, blueprintDir, r); err != nil {
+			f.Printf(r.Module, startupScriptMsg, r.Module, r.Destination, err)
+			errored = true
+		}
+	}
+
+	mods := maps.Keys(metadataSizes)
+	slices.Sort(mods)
+	for _, mod := range mods {
+		if metadataSizes[mod] > MetadataSizeLimit {
+			f.Printf(mod, metadataSizeMsg, mod, metadataSizes[mod], MetadataSizeLimit)
+			errored = true
+		}
+	}
+
+	if errored {
+		return fmt.Errorf(startupScriptError)
+	}
+	return nil
+}
+
+func testRunner(ctx context.Context, blueprintDir string, r Runner) error {
+	content := r.Content
+	switch {
+	case strings.HasPrefix(r.Source, "gs://"):
+		return testGCSObjectExists(ctx, r.Source)
+	case filepath.IsAbs(r.Source):
+		b, err := os.ReadFile(r.Source)
+		if err != nil {
+			return fmt.Errorf("source could not be read: %w", err)
+		}
+		content = string(b)
+	case r.Source != "":
+		b, err := os.ReadFile(filepath.Join(blueprintDir, r.Source))
+		if err == nil {
+			content = string(b)
+			break
+		}
+		if isModuleFile(r.Source) {
+			// copied into the deployment group directory with its module
+			return nil
+		}
+		return fmt.Errorf("source could not be read from the blueprint directory: %w", err)
+	}
+
+	if r.Type != "shell" {
+		return nil
+	}
+	return testShellSyntax(ctx, content)
+}
+
+// isModuleFile returns true if a relative runner source names a file of a
+// module, which ghpc copies into the deployment group directory
+func isModuleFile(source string) bool {
+	return strings.HasPrefix(filepath.ToSlash(filepath.Clean(source)), "modules/")
+}
+
+// testShellSyntax checks the syntax of a script with the shell named by its
+// shebang, or sh if it has none, without executing it. Scripts for shells that
+// are not installed are assumed to be valid.
+func testShellSyntax(ctx context.Context, script string) error {
+	shell := "sh"
+	if m := shebangExp.FindStringSubmatch(script); m != nil {
+		shell = filepath.Base(m[1])
+	}
+	switch shell {
+	case "sh", "bash", "dash", "ksh", "zsh":
+	default:
+		return nil
+	}
+	path, err := exec.LookPath(shell)
+	if err != nil {
+		return nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "-n")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s syntax check failed: %s", shell, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func testGCSObjectExists(ctx context.Context, uri string) error {
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+	if bucket == "" || object == "" {
+		return fmt.Errorf("%s is not a valid Cloud Storage object", uri)
+	}
+
+	s, err := storage.NewService(ctx, ClientOptions(ctx)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+	if _, err := s.Objects.Get(bucket, object).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("%s is not accessible with your credentials: %w", uri, err)
+	}
+	return nil
+}
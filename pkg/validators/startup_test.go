@@ -0,0 +1,38 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestRunnerRelativeSource(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "scripts"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "scripts", "ok.sh"), []byte("#!/bin/sh\necho ok\n"), 0644), IsNil)
+
+	// relative sources are read from the blueprint directory
+	c.Check(testRunner(ctx, dir, Runner{Type: "shell", Source: "scripts/ok.sh"}), IsNil)
+	c.Check(testRunner(ctx, dir, Runner{Type: "shell", Source: "./scripts/missing.sh"}), ErrorMatches,
+		"source could not be read from the blueprint directory: .*missing.sh.*")
+	// module files are copied into the deployment group with their module
+	c.Check(testRunner(ctx, dir, Runner{Type: "shell", Source: "modules/startup-script/examples/install.sh"}), IsNil)
+	c.Check(testRunner(ctx, dir, Runner{Type: "shell", Source: "./modules/filestore/scripts/mount.sh"}), IsNil)
+}
@@ -19,8 +19,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 
+	"golang.org/x/exp/maps"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
@@ -39,6 +41,17 @@ const unusedModuleMsg = "module %s uses module %s, but matching setting and outp
 const unusedModuleError = "One or more used modules could not have their settings and outputs linked."
 const unusedDeploymentVariableMsg = "the deployment variable \"%s\" was not used in this blueprint"
 const unusedDeploymentVariableError = "one or more deployment variables was not used by any modules"
+const slurmAccountingMsg = "Slurm controller %s has an invalid accounting database configuration: %s"
+const slurmAccountingError = "one or more Slurm controllers would fail to connect to their accounting database"
+const hostnameMsg = "module %s would fail to create its VMs: %s"
+const hostnameError = "one or more modules would create VMs with invalid hostnames"
+const spotMsg = "module %s would fail to create its VMs: %s"
+const spotWarningMsg = "WARNING: module %s: %s"
+const spotError = "one or more modules request Spot, preemptible or local SSD configurations that Compute Engine rejects"
+const placementMsg = "module %s: %s"
+const placementWarningMsg = "WARNING: module %s: %s"
+const subnetWarningMsg = "WARNING: module %s: %s"
+const placementError = "one or more modules request placement policies or network MTUs that Compute Engine rejects"
 
 func handleClientError(e error) error {
 	if strings.Contains(e.Error(), "could not find default credentials") {
@@ -67,31 +80,138 @@ func TestDeploymentVariablesNotUsed(unusedVariables []string) error {
 // of the blueprint are actually used, i.e. the outputs and settings are
 // connected.
 func TestModuleNotUsed(unusedModules map[string][]string) error {
-	any := false
-	for mod, unusedMods := range unusedModules {
-		for _, unusedMod := range unusedMods {
-			log.Printf(unusedModuleMsg, mod, unusedMod)
-			any = true
+	var f Findings
+	for _, mod := range sortedModules(unusedModules) {
+		for _, unusedMod := range unusedModules[mod] {
+			f.Printf(mod, unusedModuleMsg, mod, unusedMod)
 		}
 	}
+	f.Log()
 
-	if any {
+	if f.Len() > 0 {
 		return fmt.Errorf(unusedModuleError)
 	}
 
 	return nil
 }
 
+// TestSlurmAccounting errors if the accounting database configuration of any
+// Slurm controller has problems and prints them to the output for the user
+func TestSlurmAccounting(problems map[string][]string) error {
+	var f Findings
+	for _, controller := range sortedModules(problems) {
+		for _, p := range problems[controller] {
+			f.Printf(controller, slurmAccountingMsg, controller, p)
+		}
+	}
+	f.Log()
+
+	if f.Len() > 0 {
+		return fmt.Errorf(slurmAccountingError)
+	}
+
+	return nil
+}
+
+// TestHostnames errors if the VMs of any module would be given hostnames that
+// Compute Engine rejects and prints the offending modules for the user
+func TestHostnames(problems map[string][]string) error {
+	var f Findings
+	for _, module := range sortedModules(problems) {
+		for _, p := range problems[module] {
+			f.Printf(module, hostnameMsg, module, p)
+		}
+	}
+	f.Log()
+
+	if f.Len() > 0 {
+		return fmt.Errorf(hostnameError)
+	}
+
+	return nil
+}
+
+// TestSpotConfiguration errors if the VMs of any module request a combination
+// of preemptible scheduling and local SSDs that Compute Engine rejects. The
+// warnings, e.g. of preemptible controllers, are printed but do not fail.
+func TestSpotConfiguration(problems map[string][]string, warnings map[string][]string) error {
+	var w Findings
+	for _, module := range sortedModules(warnings) {
+		for _, msg := range warnings[module] {
+			w.Printf(module, spotWarningMsg, module, msg)
+		}
+	}
+	w.Log()
+
+	var f Findings
+	for _, module := range sortedModules(problems) {
+		for _, p := range problems[module] {
+			f.Printf(module, spotMsg, module, p)
+		}
+	}
+	f.Log()
+
+	if f.Len() > 0 {
+		return fmt.Errorf(spotError)
+	}
+
+	return nil
+}
+
+// TestPlacementAndMTU errors if any module requests a compact placement
+// policy or network MTU that Compute Engine rejects. The warnings, e.g. of
+// networks without jumbo frames, are printed but do not fail.
+func TestPlacementAndMTU(problems map[string][]string, warnings map[string][]string) error {
+	var w Findings
+	for _, module := range sortedModules(warnings) {
+		for _, msg := range warnings[module] {
+			w.Printf(module, placementWarningMsg, module, msg)
+		}
+	}
+	w.Log()
+
+	var f Findings
+	for _, module := range sortedModules(problems) {
+		for _, p := range problems[module] {
+			f.Printf(module, placementMsg, module, p)
+		}
+	}
+	f.Log()
+
+	if f.Len() > 0 {
+		return fmt.Errorf(placementError)
+	}
+	return nil
+}
+
+// TestSubnetCapacity prints the warnings of subnetworks that may run out of
+// addresses as the VMs of a deployment scale up; it never fails, as the
+// nodes may never all run at once
+func TestSubnetCapacity(warnings map[string][]string) error {
+	var w Findings
+	for _, module := range sortedModules(warnings) {
+		for _, msg := range warnings[module] {
+			w.Printf(module, subnetWarningMsg, module, msg)
+		}
+	}
+	w.Log()
+	return nil
+}
+
+func sortedModules(m map[string][]string) []string {
+	modules := maps.Keys(m)
+	sort.Strings(modules)
+	return modules
+}
+
 // TestApisEnabled tests whether APIs are enabled in given project
-func TestApisEnabled(projectID string, requiredAPIs []string) error {
+func TestApisEnabled(ctx context.Context, projectID string, requiredAPIs []string) error {
 	// can return immediately if there are 0 APIs to test
 	if len(requiredAPIs) == 0 {
 		return nil
 	}
 
-	ctx := context.Background()
-
-	s, err := serviceusage.NewService(ctx, option.WithQuotaProject(projectID))
+	s, err := serviceusage.NewService(ctx, ClientOptions(ctx, option.WithQuotaProject(projectID))...)
 	if err != nil {
 		err = handleClientError(err)
 		return err
@@ -103,7 +223,7 @@ func TestApisEnabled(projectID string, requiredAPIs []string) error {
 		serviceNames = append(serviceNames, prefix+"/services/"+api)
 	}
 
-	resp, err := s.Services.BatchGet(prefix).Names(serviceNames...).Do()
+	resp, err := s.Services.BatchGet(prefix).Names(serviceNames...).Context(ctx).Do()
 	if err != nil {
 		var herr *googleapi.Error
 		if !errors.As(err, &herr) {
@@ -144,14 +264,13 @@ func TestApisEnabled(projectID string, requiredAPIs []string) error {
 }
 
 // TestProjectExists whether projectID exists / is accessible with credentials
-func TestProjectExists(projectID string) error {
-	ctx := context.Background()
-	s, err := compute.NewService(ctx)
+func TestProjectExists(ctx context.Context, projectID string) error {
+	s, err := compute.NewService(ctx, ClientOptions(ctx)...)
 	if err != nil {
 		err = handleClientError(err)
 		return err
 	}
-	_, err = s.Projects.Get(projectID).Fields().Do()
+	_, err = s.Projects.Get(projectID).Fields().Context(ctx).Do()
 	if err != nil {
 		if strings.Contains(err.Error(), computeDisabledError) {
 			log.Printf(computeDisabledMsg, projectID)
@@ -178,56 +297,35 @@ func getErrorReason(err googleapi.Error) (bool, string, map[string]interface{})
 	return false, "", nil
 }
 
-func getRegion(projectID string, region string) (*compute.Region, error) {
-	ctx := context.Background()
-	s, err := compute.NewService(ctx)
-	if err != nil {
-		err = handleClientError(err)
-		return nil, err
-	}
-	return s.Regions.Get(projectID, region).Do()
-}
-
 // TestRegionExists whether region exists / is accessible with credentials
-func TestRegionExists(projectID string, region string) error {
-	_, err := getRegion(projectID, region)
-	if err != nil {
+func TestRegionExists(ctx context.Context, projectID string, region string) error {
+	l, err := locationsOf(ctx, projectID)
+	if err != nil || l.regions[region] == nil {
 		return fmt.Errorf(regionError, region, projectID)
 	}
 	return nil
 }
 
-func getZone(projectID string, zone string) (*compute.Zone, error) {
-	ctx := context.Background()
-	s, err := compute.NewService(ctx)
-	if err != nil {
-		err = handleClientError(err)
-		return nil, err
-	}
-	return s.Zones.Get(projectID, zone).Do()
-}
-
 // TestZoneExists whether zone exists / is accessible with credentials
-func TestZoneExists(projectID string, zone string) error {
-	_, err := getZone(projectID, zone)
-	if err != nil {
+func TestZoneExists(ctx context.Context, projectID string, zone string) error {
+	l, err := locationsOf(ctx, projectID)
+	if err != nil || l.zones[zone] == nil {
 		return fmt.Errorf(zoneError, zone, projectID)
 	}
 	return nil
 }
 
 // TestZoneInRegion whether zone is in region
-func TestZoneInRegion(projectID string, zone string, region string) error {
-	regionObject, err := getRegion(projectID, region)
-	if err != nil {
+func TestZoneInRegion(ctx context.Context, projectID string, zone string, region string) error {
+	l, err := locationsOf(ctx, projectID)
+	if err != nil || l.regions[region] == nil {
 		return fmt.Errorf(regionError, region, projectID)
 	}
-	zoneObject, err := getZone(projectID, zone)
-	if err != nil {
+	if l.zones[zone] == nil {
 		return fmt.Errorf(zoneError, zone, projectID)
 	}
 
-	if zoneObject.Region != regionObject.SelfLink {
+	if l.zones[zone].Region != l.regions[region].SelfLink {
 		return fmt.Errorf(zoneInRegionError, zone, region, projectID)
 	}
 
@@ -0,0 +1,190 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package varsources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/oauth2/google"
+)
+
+const firestoreEndpoint = "https://firestore.googleapis.com/v1/"
+
+// firestoreValue is a typed Firestore value, e.g. {"integerValue": "1"}. It
+// is decoded by hand because the generated API client cannot tell a zero
+// value from a missing one.
+type firestoreValue map[string]json.RawMessage
+
+// firestore reads the fields of a Firestore document; keys are field names,
+// with dots separating the fields of maps
+type firestore struct {
+	document string
+	fields   map[string]firestoreValue
+}
+
+func newFirestore(project string, settings map[string]string) (Source, error) {
+	if err := requireSettings("firestore", settings, "document"); err != nil {
+		return nil, err
+	}
+	database := settings["database"]
+	if database == "" {
+		database = "(default)"
+	}
+	return &firestore{
+		document: fmt.Sprintf("projects/%s/databases/%s/documents/%s",
+			project, database, strings.Trim(settings["document"], "/")),
+	}, nil
+}
+
+func (f *firestore) Get(ctx context.Context, key string) (cty.Value, string, error) {
+	location := f.document + "#" + key
+	if f.fields == nil {
+		fields, err := getFirestoreDocument(ctx, f.document)
+		if err != nil {
+			return cty.NilVal, location, err
+		}
+		f.fields = fields
+	}
+	v, err := firestoreField(f.fields, strings.Split(key, "."))
+	if err != nil {
+		return cty.NilVal, location, fmt.Errorf("Firestore document %s: %w", f.document, err)
+	}
+	return v, location, nil
+}
+
+// getFirestoreDocument reads the fields of a document with the credentials of
+// the user
+func getFirestoreDocument(ctx context.Context, name string) (map[string]firestoreValue, error) {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/datastore")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, firestoreEndpoint+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Firestore document %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Firestore document %s: %w", name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read Firestore document %s: %s\n%s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var doc struct {
+		Fields map[string]firestoreValue `json:"fields"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode Firestore document %s: %w", name, err)
+	}
+	if doc.Fields == nil {
+		doc.Fields = map[string]firestoreValue{}
+	}
+	return doc.Fields, nil
+}
+
+// firestoreField returns the value at a path into the fields of a document
+func firestoreField(fields map[string]firestoreValue, path []string) (cty.Value, error) {
+	v, ok := fields[path[0]]
+	if !ok {
+		return cty.NilVal, fmt.Errorf("has no field %q", path[0])
+	}
+	if len(path) == 1 {
+		return v.toCty()
+	}
+	raw, ok := v["mapValue"]
+	if !ok {
+		return cty.NilVal, fmt.Errorf("field %q is not a map", path[0])
+	}
+	var m struct {
+		Fields map[string]firestoreValue `json:"fields"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return cty.NilVal, err
+	}
+	return firestoreField(m.Fields, path[1:])
+}
+
+// toCty converts a Firestore value; timestamps, references, bytes and
+// geographical points are not supported
+func (v firestoreValue) toCty() (cty.Value, error) {
+	for kind, raw := range v {
+		switch kind {
+		case "nullValue":
+			return cty.NullVal(cty.DynamicPseudoType), nil
+		case "stringValue":
+			var s string
+			err := json.Unmarshal(raw, &s)
+			return cty.StringVal(s), err
+		case "booleanValue":
+			var b bool
+			err := json.Unmarshal(raw, &b)
+			return cty.BoolVal(b), err
+		case "integerValue", "doubleValue":
+			// integers are encoded as strings
+			n, _, err := big.ParseFloat(strings.Trim(string(raw), `"`), 10, 512, big.ToNearestEven)
+			if err != nil {
+				return cty.NilVal, fmt.Errorf("invalid number %s", raw)
+			}
+			return cty.NumberVal(n), nil
+		case "arrayValue":
+			var a struct {
+				Values []firestoreValue `json:"values"`
+			}
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return cty.NilVal, err
+			}
+			vals := []cty.Value{}
+			for _, el := range a.Values {
+				cv, err := el.toCty()
+				if err != nil {
+					return cty.NilVal, err
+				}
+				vals = append(vals, cv)
+			}
+			return cty.TupleVal(vals), nil
+		case "mapValue":
+			var m struct {
+				Fields map[string]firestoreValue `json:"fields"`
+			}
+			if err := json.Unmarshal(raw, &m); err != nil {
+				return cty.NilVal, err
+			}
+			attrs := map[string]cty.Value{}
+			for name, el := range m.Fields {
+				cv, err := el.toCty()
+				if err != nil {
+					return cty.NilVal, err
+				}
+				attrs[name] = cv
+			}
+			return cty.ObjectVal(attrs), nil
+		default:
+			return cty.NilVal, fmt.Errorf("values of type %s are not supported", kind)
+		}
+	}
+	return cty.NilVal, fmt.Errorf("value has no type")
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package varsources
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+func (s *MySuite) TestFirestoreField(c *C) {
+	var fields map[string]firestoreValue
+	c.Assert(json.Unmarshal([]byte(`{
+		"vpc":     {"stringValue": "shared-vpc"},
+		"nodes":   {"integerValue": "0"},
+		"ratio":   {"doubleValue": 0.5},
+		"enabled": {"booleanValue": false},
+		"zones":   {"arrayValue": {"values": [{"stringValue": "a"}, {"stringValue": "b"}]}},
+		"images":  {"mapValue": {"fields": {"golden": {"stringValue": "hpc-rocky"}}}},
+		"updated": {"timestampValue": "2023-07-01T00:00:00Z"}
+	}`), &fields), IsNil)
+
+	get := func(key string) (cty.Value, error) {
+		return firestoreField(fields, strings.Split(key, "."))
+	}
+
+	v, err := get("vpc")
+	c.Check(err, IsNil)
+	c.Check(v, DeepEquals, cty.StringVal("shared-vpc"))
+
+	// zero values are kept
+	v, err = get("nodes")
+	c.Check(err, IsNil)
+	c.Check(v.Equals(cty.NumberIntVal(0)).True(), Equals, true)
+	v, err = get("enabled")
+	c.Check(err, IsNil)
+	c.Check(v, DeepEquals, cty.False)
+
+	v, err = get("ratio")
+	c.Check(err, IsNil)
+	c.Check(v.Equals(cty.NumberFloatVal(0.5)).True(), Equals, true)
+
+	v, err = get("zones")
+	c.Check(err, IsNil)
+	c.Check(v, DeepEquals, cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}))
+
+	// dotted keys read the fields of maps
+	v, err = get("images.golden")
+	c.Check(err, IsNil)
+	c.Check(v, DeepEquals, cty.StringVal("hpc-rocky"))
+
+	_, err = get("images.silver")
+	c.Check(err, ErrorMatches, `has no field "silver"`)
+	_, err = get("vpc.name")
+	c.Check(err, ErrorMatches, `field "vpc" is not a map`)
+	_, err = get("updated")
+	c.Check(err, ErrorMatches, "values of type timestampValue are not supported")
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package varsources
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+	runtimeconfig "google.golang.org/api/runtimeconfig/v1beta1"
+)
+
+// runtimeConfig reads the variables of a Runtime Config resource, whose
+// values are strings
+type runtimeConfig struct {
+	config string
+	s      *runtimeconfig.Service
+}
+
+func newRuntimeConfig(project string, settings map[string]string) (Source, error) {
+	if err := requireSettings("runtimeconfig", settings, "config"); err != nil {
+		return nil, err
+	}
+	s, err := runtimeconfig.NewService(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return runtimeConfig{
+		config: fmt.Sprintf("projects/%s/configs/%s", project, settings["config"]),
+		s:      s,
+	}, nil
+}
+
+func (r runtimeConfig) Get(ctx context.Context, key string) (cty.Value, string, error) {
+	name := r.config + "/variables/" + key
+	v, err := r.s.Projects.Configs.Variables.Get(name).Context(ctx).Do()
+	if err != nil {
+		return cty.NilVal, name, fmt.Errorf("failed to read Runtime Config variable %s: %w", name, err)
+	}
+	if v.Text != "" || v.Value == "" {
+		return cty.StringVal(v.Text), name, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v.Value)
+	if err != nil {
+		return cty.NilVal, name, fmt.Errorf("Runtime Config variable %s has an invalid value: %w", name, err)
+	}
+	return cty.StringVal(string(b)), name, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package varsources reads the values of deployment variables from central
+// stores, such as Runtime Config or Firestore, that are maintained outside of
+// blueprints, e.g. by the platform team of an organization.
+package varsources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Source reads values from a central store
+type Source interface {
+	// Get returns the value stored under key and the location of the value
+	// in the store, e.g. the resource name of a Runtime Config variable
+	Get(ctx context.Context, key string) (v cty.Value, location string, err error)
+}
+
+// Factory creates a source from the project of the store and the settings
+// of the source type
+type Factory func(project string, settings map[string]string) (Source, error)
+
+var factories = map[string]Factory{
+	"runtimeconfig": newRuntimeConfig,
+	"firestore":     newFirestore,
+}
+
+// Register makes a type of source available to blueprints under name,
+// replacing any source of the same name
+func Register(name string, f Factory) {
+	factories[name] = f
+}
+
+// Types returns the sorted names of the registered types of source
+func Types() []string {
+	names := []string{}
+	for n := range factories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a source of the named type
+func New(name string, project string, settings map[string]string) (Source, error) {
+	f, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown var source type %q, must be one of %v", name, Types())
+	}
+	return f(project, settings)
+}
+
+// requireSettings errors if any of the named settings is empty
+func requireSettings(name string, settings map[string]string, required ...string) error {
+	for _, r := range required {
+		if settings[r] == "" {
+			return fmt.Errorf("var source %s requires setting %q", name, r)
+		}
+	}
+	return nil
+}
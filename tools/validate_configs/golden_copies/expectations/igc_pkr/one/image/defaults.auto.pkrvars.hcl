@@ -17,9 +17,10 @@
 deployment_name = "golden_copy_deployment"
 
 labels = {
-  ghpc_blueprint  = "igc"
-  ghpc_deployment = "golden_copy_deployment"
-  ghpc_role       = "packer"
+  ghpc_blueprint     = "igc"
+  ghpc_deployment    = "golden_copy_deployment"
+  ghpc_deployment_id = "golden"
+  ghpc_role          = "packer"
 }
 
 project_id = "invalid-project"
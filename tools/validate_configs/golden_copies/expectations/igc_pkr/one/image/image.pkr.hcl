@@ -124,7 +124,10 @@ build {
     output     = var.manifest_file
     strip_path = true
     custom_data = {
-      built-by = "cloud-hpc-toolkit"
+      built-by     = "cloud-hpc-toolkit"
+      deployment   = var.deployment_name
+      image-family = local.image_family
+      project-id   = var.project_id
     }
   }
 
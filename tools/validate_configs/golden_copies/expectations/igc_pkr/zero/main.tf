@@ -14,6 +14,13 @@
   * limitations under the License.
   */
 
+# Generated by ghpc, do not edit; change the blueprint and run ghpc create instead
+#   blueprint:        igc
+#   blueprint sha256: golden (of ../.ghpc/artifacts/expanded_blueprint.yaml)
+#   deployment group: zero
+#   ghpc version:     golden
+
+# ghpc module network0, source modules/network/vpc
 module "network0" {
   source          = "./modules/embedded/modules/network/vpc"
   deployment_name = var.deployment_name
@@ -21,6 +28,7 @@ module "network0" {
   region          = var.region
 }
 
+# ghpc module homefs, source modules/file-system/filestore
 module "homefs" {
   source          = "./modules/embedded/modules/file-system/filestore"
   deployment_name = var.deployment_name
@@ -34,6 +42,7 @@ module "homefs" {
   zone        = var.zone
 }
 
+# ghpc module projectsfs, source modules/file-system/filestore
 module "projectsfs" {
   source          = "./modules/embedded/modules/file-system/filestore"
   deployment_name = var.deployment_name
@@ -47,6 +56,7 @@ module "projectsfs" {
   zone        = var.zone
 }
 
+# ghpc module script, source modules/scripts/startup-script
 module "script" {
   source          = "./modules/embedded/modules/scripts/startup-script"
   deployment_name = var.deployment_name
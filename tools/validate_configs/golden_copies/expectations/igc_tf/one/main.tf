@@ -14,6 +14,13 @@
   * limitations under the License.
   */
 
+# Generated by ghpc, do not edit; change the blueprint and run ghpc create instead
+#   blueprint:        igc
+#   blueprint sha256: golden (of ../.ghpc/artifacts/expanded_blueprint.yaml)
+#   deployment group: one
+#   ghpc version:     golden
+
+# ghpc module homefs, source modules/file-system/filestore
 module "homefs" {
   source          = "./modules/embedded/modules/file-system/filestore"
   deployment_name = var.deployment_name
@@ -14,6 +14,13 @@
   * limitations under the License.
   */
 
+# Generated by ghpc, do not edit; change the blueprint and run ghpc create instead
+#   blueprint:        igc
+#   blueprint sha256: golden (of ../.ghpc/artifacts/expanded_blueprint.yaml)
+#   deployment group: zero
+#   ghpc version:     golden
+
+# ghpc module network0, source modules/network/vpc
 module "network0" {
   source          = "./modules/embedded/modules/network/vpc"
   deployment_name = var.deployment_name
@@ -15,12 +15,12 @@
   */
 
 output "nat_ips_network0" {
-  description = "Generated output from module 'network0'"
+  description = "the external IPs assigned to the NAT"
   value       = module.network0.nat_ips
 }
 
 output "subnetwork_name_network0" {
-  description = "Generated output from module 'network0'"
+  description = "The name of the primary subnetwork"
   value       = module.network0.subnetwork_name
 }
 
@@ -21,11 +21,12 @@ image_family = "$(zebra/to(ad"
 image_name = "((cat /dog))"
 
 labels = {
-  brown           = "$(fox)"
-  ghpc_blueprint  = "text_escape"
-  ghpc_deployment = "golden_copy_deployment"
-  ghpc_role       = "packer"
-  ñred            = "ñblue"
+  brown              = "$(fox)"
+  ghpc_blueprint     = "text_escape"
+  ghpc_deployment    = "golden_copy_deployment"
+  ghpc_deployment_id = "golden"
+  ghpc_role          = "packer"
+  ñred               = "ñblue"
 }
 
 project_id = "invalid-project"
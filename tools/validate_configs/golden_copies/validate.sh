@@ -69,6 +69,17 @@ run_test() {
 	done
 	find . -name "README.md" -exec rm {} \;
 	sed -i -E 's/(ghpc_version: )(.*)/\1golden/' .ghpc/artifacts/expanded_blueprint.yaml
+	# ghpc create labels the resources with a random deployment id
+	find . -type f -exec sed -i -E 's/(ghpc_deployment_id\W+)[0-9a-f]{16}/\1golden/' {} +
+	# the manifest records when the deployment was created and the hashes of
+	# its files; only the list of files, without modules, is compared
+	sed -i -E -e 's/^(created: ).*/\1golden/' -e '/\/modules\//d' \
+		-e 's/^( +[^ ].*: )[0-9a-f]{64}$/\1golden/' .ghpc/artifacts/manifest.yaml
+	find . -name "main.tf" -exec sed -i -E \
+		-e 's/(blueprint sha256: )[0-9a-f]+/\1golden/' \
+		-e 's/(ghpc version: +)(.*)/\1golden/' \
+		-e 's/(ghpc_version += )(.*)/\1"golden"/' \
+		-e 's/(blueprint_sha256 += )(.*)/\1"golden"/' {} \;
 
 	# Compare the deployment folder with the golden copy
 	diff --recursive --exclude="previous_deployment_groups" \